@@ -0,0 +1,159 @@
+// Package btree
+// page allocator
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "sort"
+
+// AllocStrategy decides which deleted page is reused by the next allocation
+type AllocStrategy int
+
+const (
+	ALLOC_LIFO      AllocStrategy = iota // Reuse the most recently deleted page (default)
+	ALLOC_FIFO                           // Reuse the least recently deleted page
+	ALLOC_FIRST_FIT                      // Reuse the deleted page with the lowest page id, keeps pages near the start of the file
+	ALLOC_APPEND                         // Never reuse deleted pages, always append to the end of the file
+)
+
+// FreelistStats describes the deleted pages of a pager
+type FreelistStats struct {
+	TotalPages        int64   // Number of pages in the file
+	FreePages         int     // Number of deleted pages waiting to be reused
+	FreeNodePages     int     // Number of deleted pages which held the first page of a node
+	FreeOverflowPages int     // Number of deleted pages which were overflow pages of a node
+	FreeRuns          int     // Number of runs of consecutive deleted pages
+	LargestFreeRun    int     // Length of the longest run of consecutive deleted pages
+	Fragmentation     float64 // Share of the file made of deleted pages, from 0 to 1
+}
+
+// pageClasses marks the deleted pages which were overflow pages, the other deleted pages were node pages
+type pageClasses map[int64]bool
+
+// takeFreePage removes a deleted page from the freelist according to the allocation strategy
+// A deleted overflow page is taken for an overflow page and a deleted node page for a node page so node and overflow
+// data are not interleaved, a page of the other class is only taken when there is none of the requested class.
+// false is returned if no deleted page should be reused
+// deletedPagesLock must be held
+func (p *Pager) takeFreePage(overflow bool) (int64, bool) {
+	if len(p.deletedPages) == 0 || p.allocator == ALLOC_APPEND {
+		return -1, false
+	}
+
+	i := p.pickFreePage(overflow)
+	if i < 0 {
+		i = p.pickFreePage(!overflow)
+	}
+
+	pageID := p.deletedPages[i]
+	p.deletedPages = append(p.deletedPages[:i], p.deletedPages[i+1:]...)
+	delete(p.freeOverflow, pageID)
+	p.logAlloc(pageID)
+
+	return pageID, true
+}
+
+// pickFreePage returns the index of the deleted page of a class the allocation strategy reuses next, -1 if there is none
+// deletedPagesLock must be held
+func (p *Pager) pickFreePage(overflow bool) int {
+	switch p.allocator {
+	case ALLOC_FIFO:
+		for i, pageID := range p.deletedPages {
+			if p.freeOverflow[pageID] == overflow {
+				return i
+			}
+		}
+	case ALLOC_FIRST_FIT:
+		first := -1
+		for i, pageID := range p.deletedPages {
+			if p.freeOverflow[pageID] == overflow && (first < 0 || pageID < p.deletedPages[first]) {
+				first = i
+			}
+		}
+		return first
+	default:
+		for i := len(p.deletedPages) - 1; i >= 0; i-- {
+			if p.freeOverflow[p.deletedPages[i]] == overflow {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// freeClassCounts returns the number of deleted node pages and deleted overflow pages
+// deletedPagesLock must be held
+func (p *Pager) freeClassCounts() (int, int) {
+	overflow := 0
+	for _, pageID := range p.deletedPages {
+		if p.freeOverflow[pageID] {
+			overflow++
+		}
+	}
+	return len(p.deletedPages) - overflow, overflow
+}
+
+// FreelistStats returns statistics about the deleted pages and how fragmented the file is
+func (p *Pager) FreelistStats() (FreelistStats, error) {
+	stat, err := p.file.Stat()
+	if err != nil {
+		return FreelistStats{}, err
+	}
+
+	p.deletedPagesLock.Lock()
+	free := append([]int64(nil), p.deletedPages...)
+	nodePages, overflowPages := p.freeClassCounts()
+	p.deletedPagesLock.Unlock()
+
+	sort.Slice(free, func(i, j int) bool { return free[i] < free[j] })
+
+	stats := FreelistStats{TotalPages: stat.Size() / (PAGE_SIZE + HEADER_SIZE), FreePages: len(free), FreeNodePages: nodePages, FreeOverflowPages: overflowPages}
+
+	run := 0
+	for i, pageID := range free {
+		if i > 0 && pageID == free[i-1]+1 {
+			run++
+		} else {
+			run = 1
+			stats.FreeRuns++
+		}
+
+		if run > stats.LargestFreeRun {
+			stats.LargestFreeRun = run
+		}
+	}
+
+	if stats.TotalPages > 0 {
+		stats.Fragmentation = float64(stats.FreePages) / float64(stats.TotalPages)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,216 @@
+// Package btree
+// page allocator tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestPager_Allocator(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	tests := []struct {
+		allocator AllocStrategy
+		expected  int64
+	}{
+		{ALLOC_LIFO, 2},
+		{ALLOC_FIFO, 5},
+		{ALLOC_FIRST_FIT, 1},
+		{ALLOC_APPEND, 10},
+	}
+
+	for _, test := range tests {
+		pager, err := OpenPagerWithOptions("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, &PagerOptions{Allocator: test.allocator})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 10; i++ {
+			_, err := pager.Write([]byte("page"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, pageID := range []int64{5, 1, 2} {
+			err = pager.DeletePage(pageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		pageID, err := pager.Write([]byte("reused"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pageID != test.expected {
+			t.Fatalf("allocator %d: expected page %d, got %d", test.allocator, test.expected, pageID)
+		}
+
+		err = pager.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.Remove("btree.db.del")
+	}
+}
+
+func TestPager_FreelistStats(t *testing.T) {
+	pager, err := OpenMemoryPager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := pager.Write([]byte("page"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pageID := range []int64{7, 1, 2, 3} {
+		err = pager.DeletePage(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := pager.FreelistStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.TotalPages != 10 || stats.FreePages != 4 || stats.FreeRuns != 2 || stats.LargestFreeRun != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if stats.Fragmentation != 0.4 {
+		t.Fatalf("expected fragmentation 0.4, got %f", stats.Fragmentation)
+	}
+}
+
+func TestPager_FreelistClasses(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a node spanning three pages between two single page nodes
+	first, err := pager.Write([]byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large, err := pager.Write(bytes.Repeat([]byte("v"), 2*PAGE_SIZE+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pager.Write([]byte("last"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overflow, err := pager.overflowPages(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pageID := range []int64{first, large} {
+		err = pager.DeletePage(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := pager.FreelistStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.FreeNodePages != 2 || stats.FreeOverflowPages != 2 {
+		t.Fatalf("expected 2 free node pages and 2 free overflow pages, got %+v", stats)
+	}
+
+	// the classes survive a reopen
+	err = pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	// the most recently deleted page is an overflow page, a node takes the deleted node page instead
+	pageID, err := pager.Write([]byte("node"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pageID != large {
+		t.Fatalf("expected the deleted node page %d to be reused, got %d", large, pageID)
+	}
+
+	// overflow pages reuse the deleted overflow pages
+	pageID, err = pager.Write(bytes.Repeat([]byte("w"), 2*PAGE_SIZE+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pageID != first {
+		t.Fatalf("expected the deleted node page %d to be reused, got %d", first, pageID)
+	}
+
+	reused, err := pager.overflowPages(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(overflow)
+	slices.Sort(reused)
+	if !slices.Equal(reused, overflow) {
+		t.Fatalf("expected the overflow pages %v to be reused, got %v", overflow, reused)
+	}
+}
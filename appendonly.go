@@ -0,0 +1,368 @@
+// Package btree
+// append-only sessions
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrNotAscending is returned when appending a key which is smaller than the greatest key of the tree
+var ErrNotAscending = errors.New("key is smaller than the greatest key")
+
+// appendBufferKeys is the number of keys an append session buffers before it flushes
+const appendBufferKeys = 4096
+
+// AppendSession inserts strictly ascending keys (i.e. timestamps or sequence numbers) in batches
+// Keys are added to the right edge of the tree filling every leaf before starting the next one instead of splitting leaves in half
+// Appending the greatest key again appends the value to it.  Buffered keys are not visible until they are flushed
+type AppendSession struct {
+	b    *BTree
+	keys []*Key // buffered keys in ascending order
+	last []byte // the last key appended, nil before the first
+}
+
+// AppendOnly starts an append session, Flush or Close adds the buffered keys to the tree
+// Versioned btrees and btrees with a value comparator are not supported
+func (b *BTree) AppendOnly() (*AppendSession, error) {
+	if b.versioned || b.valueCompare != nil || b.uniqueValues || b.keyVersions {
+		return nil, errors.New("append sessions do not support versioned btrees, value comparators, unique values or key versions")
+	}
+
+	return &AppendSession{b: b}, nil
+}
+
+// Append buffers a key value pair, the key must not be smaller than the previous key
+func (s *AppendSession) Append(key, value []byte) error {
+	return s.append(s.b.transformKey(key), value)
+}
+
+// append buffers a key value pair of a transformed key
+func (s *AppendSession) append(key, value []byte) error {
+	err := s.b.checkKeySize(key)
+	if err != nil {
+		return err
+	}
+
+	if s.last != nil && bytes.Compare(key, s.last) < 0 {
+		return ErrNotAscending
+	}
+
+	s.b.countPut(key, value)
+
+	value, ptr, err := s.b.storeValue(value)
+	if err != nil {
+		return err
+	}
+
+	if n := len(s.keys); n > 0 && equal(s.keys[n-1].K, key) {
+		s.keys[n-1].appendValue(value, ptr)
+		return nil
+	}
+
+	k := &Key{K: key, V: make([][]byte, 0)}
+	k.appendValue(value, ptr)
+	s.keys = append(s.keys, k)
+	s.last = key
+
+	if len(s.keys) >= appendBufferKeys {
+		return s.Flush()
+	}
+
+	return nil
+}
+
+// Flush adds the buffered keys to the right edge of the tree
+// ErrNotAscending is returned if the tree gained a greater key since the keys were buffered, the keys stay buffered
+func (s *AppendSession) Flush() error {
+	if len(s.keys) == 0 {
+		return nil
+	}
+
+	err := s.b.atomic(func() error {
+		return s.b.appendRight(s.keys)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.keys = s.keys[:0]
+
+	return nil
+}
+
+// Close flushes the buffered keys and ends the session
+func (s *AppendSession) Close() error {
+	return s.Flush()
+}
+
+// appendRight adds ascending keys to the right edge of the tree
+// The nodes on the path from the root to the rightmost leaf (the spine) are kept in memory, nodes which fill up are written once
+func (b *BTree) appendRight(keys []*Key) error {
+	b.shapeChanged()
+
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	// the spine holds a node per level
+	spine := make([]*Node, 1, b.stackSize())
+	spine[0] = root
+	for x := root; !x.Leaf; {
+		x, err = b.descendChild(x, len(x.Children)-1, len(spine))
+		if err != nil {
+			return err
+		}
+		spine = append(spine, x)
+	}
+
+	// the greatest key of a btree is the last key of its rightmost leaf
+	var last *Key
+	if leaf := spine[len(spine)-1]; len(leaf.Keys) > 0 {
+		last = leaf.Keys[len(leaf.Keys)-1]
+	}
+
+	if last != nil && lessThan(keys[0].K, last.K) {
+		return ErrNotAscending
+	}
+
+	for _, k := range keys {
+		if last != nil && equal(k.K, last.K) {
+			// the hash set of the key is rebuilt on demand
+			err := b.dropHashSet(last)
+			if err != nil {
+				return err
+			}
+
+			// an expired or deleted key which was not purged yet starts over
+			if last.hidden() {
+				err = b.dropBitmap(last)
+				if err != nil {
+					return err
+				}
+				*last = Key{K: last.K, V: make([][]byte, 0)}
+			} else if last.B != 0 {
+				return ErrValueMode
+			}
+
+			for i := range k.V {
+				last.appendValue(k.V[i], i < len(k.Ptr) && k.Ptr[i])
+			}
+			continue
+		}
+
+		last = k
+
+		leaf := spine[len(spine)-1]
+
+		full, err := b.isFull(leaf)
+		if err != nil {
+			return err
+		}
+
+		if !full {
+			leaf.Keys = append(leaf.Keys, k)
+			continue
+		}
+
+		// the full leaf is done, the key separates it from a new leaf
+		spine, err = b.pushSeparator(spine, len(spine)-1, k)
+		if err != nil {
+			return err
+		}
+	}
+
+	for l := 1; l < len(spine); {
+		var merged bool
+		spine, merged, err = b.balanceRight(spine, l)
+		if err != nil {
+			return err
+		}
+
+		// a merge takes a key from the parent which may leave it underfull
+		if merged {
+			l = max(l-1, 1)
+			continue
+		}
+		l++
+	}
+
+	for _, n := range spine {
+		err = b.writeNode(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushSeparator writes the full spine node at level l and adds sep to its parent
+// The spine continues below sep with new empty nodes down to a new empty leaf
+func (b *BTree) pushSeparator(spine []*Node, l int, sep *Key) ([]*Node, error) {
+	err := b.writeNode(spine[l])
+	if err != nil {
+		return nil, err
+	}
+
+	if l == 0 {
+		// the root moves to a new page so the new root keeps page 0
+		root := spine[0]
+
+		left, err := b.newNode(root.Leaf)
+		if err != nil {
+			return nil, err
+		}
+
+		left.Keys, left.Children = root.Keys, root.Children
+
+		err = b.writeNode(left)
+		if err != nil {
+			return nil, err
+		}
+
+		root.Leaf = false
+		root.Keys = []*Key{sep}
+		root.Children = []int64{left.Page}
+
+		// the tree is one level taller
+		err = b.grew(root)
+		if err != nil {
+			return nil, err
+		}
+		spine = append(spine, nil)
+		return b.growSpine(spine, 1)
+	}
+
+	parent := spine[l-1]
+
+	full, err := b.isFull(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	if full {
+		return b.pushSeparator(spine, l-1, sep)
+	}
+
+	parent.Keys = append(parent.Keys, sep)
+	return b.growSpine(spine, l)
+}
+
+// growSpine replaces the spine from level l down with new empty nodes linked to the node at level l-1
+func (b *BTree) growSpine(spine []*Node, l int) ([]*Node, error) {
+	child, err := b.newNode(true)
+	if err != nil {
+		return nil, err
+	}
+	spine[len(spine)-1] = child
+
+	for i := len(spine) - 2; i >= l; i-- {
+		n, err := b.newNode(false)
+		if err != nil {
+			return nil, err
+		}
+
+		// an internal node without keys only exists until a separator arrives or the spine is balanced
+		n.Children = []int64{child.Page}
+		spine[i] = n
+		child = n
+	}
+
+	spine[l-1].Children = append(spine[l-1].Children, child.Page)
+
+	return spine, nil
+}
+
+// balanceRight gives the spine node at level l at least T-1 keys by moving keys from its left sibling or merging with it
+// true is returned if the node was merged into its sibling
+func (b *BTree) balanceRight(spine []*Node, l int) ([]*Node, bool, error) {
+	right, parent := spine[l], spine[l-1]
+	if len(right.Keys) >= b.T-1 || len(parent.Keys) == 0 {
+		return spine, false, nil
+	}
+
+	left, err := b.readNode(parent.Children[len(parent.Children)-2])
+	if err != nil {
+		return nil, false, err
+	}
+
+	sep := len(parent.Keys) - 1
+
+	if len(left.Keys)+len(right.Keys) >= 2*(b.T-1) {
+		// rotate keys through the parent until the right node has enough
+		for len(right.Keys) < b.T-1 {
+			right.Keys = append([]*Key{parent.Keys[sep]}, right.Keys...)
+			parent.Keys[sep] = left.Keys[len(left.Keys)-1]
+			left.Keys = left.Keys[:len(left.Keys)-1]
+
+			if !right.Leaf {
+				right.Children = append([]int64{left.Children[len(left.Children)-1]}, right.Children...)
+				left.Children = left.Children[:len(left.Children)-1]
+			}
+		}
+
+		return spine, false, b.writeNode(left)
+	}
+
+	left.Keys = append(append(left.Keys, parent.Keys[sep]), right.Keys...)
+	left.Children = append(left.Children, right.Children...)
+	parent.Keys = parent.Keys[:sep]
+	parent.Children = parent.Children[:len(parent.Children)-1]
+
+	err = b.freePage(right.Page)
+	if err != nil {
+		return nil, false, err
+	}
+
+	spine[l] = left
+
+	if l == 1 && len(parent.Keys) == 0 {
+		// the root lost its last key, the merged node becomes the root
+		parent.Keys, parent.Children, parent.Leaf = left.Keys, left.Children, left.Leaf
+
+		err = b.freePage(left.Page)
+		if err != nil {
+			return nil, false, err
+		}
+
+		spine = append(spine[:1], spine[2:]...)
+
+		err = b.shrank(parent)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return spine, true, nil
+}
@@ -0,0 +1,249 @@
+// Package btree
+// append-only sessions tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// checkBTree fails if the tree breaks a btree invariant: key order, key counts per node or leaf depth
+func checkBTree(t *testing.T, b *BTree) {
+	t.Helper()
+
+	root, err := b.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafDepth := -1
+
+	var check func(n *Node, depth int, low, high []byte)
+	check = func(n *Node, depth int, low, high []byte) {
+		if n.Page != 0 && (len(n.Keys) < b.T-1 || len(n.Keys) > 2*b.T-1) {
+			t.Fatalf("page %d holds %d keys", n.Page, len(n.Keys))
+		}
+
+		for i, key := range n.Keys {
+			if (low != nil && !lessThan(low, key.K)) || (high != nil && !lessThan(key.K, high)) {
+				t.Fatalf("page %d: key %s out of order", n.Page, key.K)
+			}
+			if i > 0 && !lessThan(n.Keys[i-1].K, key.K) {
+				t.Fatalf("page %d: key %s out of order", n.Page, key.K)
+			}
+		}
+
+		if n.Leaf {
+			if leafDepth == -1 {
+				leafDepth = depth
+			}
+			if depth != leafDepth {
+				t.Fatalf("page %d: leaf at depth %d, expected %d", n.Page, depth, leafDepth)
+			}
+			return
+		}
+
+		if len(n.Children) != len(n.Keys)+1 {
+			t.Fatalf("page %d: %d children for %d keys", n.Page, len(n.Children), len(n.Keys))
+		}
+
+		for i, c := range n.Children {
+			child, err := b.readNode(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			childLow, childHigh := low, high
+			if i > 0 {
+				childLow = n.Keys[i-1].K
+			}
+			if i < len(n.Keys) {
+				childHigh = n.Keys[i].K
+			}
+
+			check(child, depth+1, childLow, childHigh)
+		}
+	}
+
+	check(root, 0, nil, nil)
+}
+
+func TestAppendSession(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	for _, count := range []int{1, 5, 6, 7, 100, 1000, 10000} {
+		btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the tree already holds keys the session appends after
+		for i := 0; i < 50; i++ {
+			err = btree.Put([]byte(fmt.Sprintf("%08d", i)), []byte("put"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		session, err := btree.AppendOnly()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// appending the greatest key again appends a value
+		err = session.Append([]byte(fmt.Sprintf("%08d", 49)), []byte("appended"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 50; i < 50+count; i++ {
+			err = session.Append([]byte(fmt.Sprintf("%08d", i)), []byte(fmt.Sprintf("%d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = session.Append([]byte(fmt.Sprintf("%08d", 0)), []byte("old"))
+		if !errors.Is(err, ErrNotAscending) {
+			t.Fatalf("expected ErrNotAscending, got %v", err)
+		}
+
+		err = session.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkBTree(t, btree)
+
+		key, err := btree.Get([]byte(fmt.Sprintf("%08d", 49)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || len(key.V) != 2 || string(key.V[1]) != "appended" {
+			t.Fatalf("expected the value to be appended to key 49, got %v", key)
+		}
+
+		cursor := btree.Cursor()
+		i := 0
+		for key, err := cursor.First(); key != nil || err != nil; key, err = cursor.Next() {
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(key.K) != fmt.Sprintf("%08d", i) {
+				t.Fatalf("expected key %08d, got %s", i, key.K)
+			}
+			i++
+		}
+
+		if i != 50+count {
+			t.Fatalf("expected %d keys, got %d", 50+count, i)
+		}
+
+		// regular puts keep working after the session
+		err = btree.Put([]byte(fmt.Sprintf("%08d", 25)), []byte("again"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkBTree(t, btree)
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAppendSession_FullLeaves(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	session, err := btree.AppendOnly()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10000; i++ {
+		err = session.Append([]byte(fmt.Sprintf("%08d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = session.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBTree(t, btree)
+
+	ranges, err := btree.KeyRangesByLeaf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every leaf but the last is full, Put splits leaves in half
+	for _, r := range ranges[:len(ranges)-1] {
+		if r.Count != 2*btree.T-1 {
+			t.Fatalf("expected a full leaf, got %d keys in page %d", r.Count, r.Page)
+		}
+	}
+
+	// a greater key put after the session was buffered is not overtaken
+	session, err = btree.AppendOnly()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = session.Append([]byte("a"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("b"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = session.Flush()
+	if !errors.Is(err, ErrNotAscending) {
+		t.Fatalf("expected ErrNotAscending, got %v", err)
+	}
+}
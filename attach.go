@@ -0,0 +1,375 @@
+// Package btree
+// attach and detach
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+)
+
+// Attach imports every key of the btree file at path into this btree
+// If this btree is empty and the foreign nodes fit its order the foreign pages are copied over with their page numbers rebased,
+// otherwise the foreign keys are rolled forward one by one.  Values, metadata, versions and expiry times are kept.
+func (b *BTree) Attach(path string) error {
+	foreign, err := openForeign(path, b.T, b.keyTransform)
+	if err != nil {
+		return err
+	}
+	defer foreign.Close()
+
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	foreignRoot, err := foreign.getRoot()
+	if err != nil {
+		return err
+	}
+
+	fits, err := foreign.fitsOrder(foreignRoot, b.T)
+	if err != nil {
+		return err
+	}
+
+	if len(root.Keys) == 0 && root.Leaf && fits {
+		err = b.atomic(func() error {
+			_, err := b.copyNode(foreign, foreignRoot, true)
+			return err
+		})
+		b.replaced()
+	} else {
+		err = foreign.walk(foreignRoot, func(n *Node) error {
+			for _, k := range n.Keys {
+				err := b.atomic(func() error {
+					return b.rollForward(foreign, k)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	// keys with an expiry are added to our expiry index so Sweep can find them
+	return foreign.walk(foreignRoot, func(n *Node) error {
+		for _, k := range n.Keys {
+			if k.E == 0 {
+				continue
+			}
+
+			if b.expiry == nil {
+				err := b.openExpiryIndex()
+				if err != nil {
+					return err
+				}
+			}
+
+			err := b.expiry.Put(expiryIndexKey(k.E, k.K), k.K)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Detach moves the keys within [start, end] into a new btree file at path and deletes them from this btree
+func (b *BTree) Detach(start, end []byte, path string) error {
+	start, end = b.transformKey(start), b.transformKey(end)
+
+	if b.closed.Load() {
+		return ErrClosed
+	}
+
+	_, err := os.Stat(path)
+	if err == nil {
+		return errors.New("detach target already exists")
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	keys, err := b.rangeKeys(start, end, root)
+	if err != nil {
+		return err
+	}
+
+	// the detached keys were transformed, the target records the same transform
+	target, err := OpenWithOptions(path, os.O_CREATE|os.O_RDWR, int(b.perm), b.T, &Options{KeyTransform: b.keyTransform, Dictionary: b.Dictionary(), FixedKeySize: b.fixedKeySize})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		k := key.(*Key)
+
+		err = target.rollForwardKey(b, k)
+		if err != nil {
+			target.Close()
+			return err
+		}
+	}
+
+	// the keys are only deleted once the target is safely on disk
+	err = target.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		_, err = b.deleteKey(key.(*Key).K)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openForeign opens an existing btree file read-only and its value log if there is one
+// The file must have been written with the same key transform, its metadata files are left as they are
+func openForeign(path string, t int, transform KeyTransform) (*BTree, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	foreign, err := OpenWithOptions(path, os.O_RDONLY, 0644, t, &Options{KeyTransform: transform, PagerOptions: &PagerOptions{passive: true}})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(path + ".vlog")
+	if err == nil {
+		foreign.ValueLog, err = OpenValueLog(path+".vlog", os.O_RDONLY, 0)
+		if err != nil {
+			foreign.Close()
+			return nil, err
+		}
+	}
+
+	return foreign, nil
+}
+
+// fitsOrder returns true if every node below x holds a valid number of keys for a btree of order t
+func (b *BTree) fitsOrder(x *Node, t int) (bool, error) {
+	fits := true
+	err := b.walk(x, func(n *Node) error {
+		if len(n.Keys) > 2*t-1 || (n != x && len(n.Keys) < t-1) {
+			fits = false
+		}
+		return nil
+	})
+	return fits, err
+}
+
+// copyNode copies the subtree of src rooted at x into new pages of this btree and returns the page of its root
+// The root of the subtree is written to page 0 if root is true
+func (b *BTree) copyNode(src *BTree, x *Node, root bool) (int64, error) {
+	n := &Node{Leaf: x.Leaf, Keys: make([]*Key, 0, len(x.Keys)), Children: make([]int64, 0, len(x.Children))}
+
+	for _, c := range x.Children {
+		child, err := src.readNode(c)
+		if err != nil {
+			return -1, err
+		}
+
+		page, err := b.copyNode(src, child, false)
+		if err != nil {
+			return -1, err
+		}
+
+		n.Children = append(n.Children, page)
+	}
+
+	for _, k := range x.Keys {
+		rebased, err := b.rebaseKey(src, k)
+		if err != nil {
+			return -1, err
+		}
+		n.Keys = append(n.Keys, rebased)
+	}
+
+	if !root {
+		placeholder, err := b.encodeRecord(&Node{Leaf: n.Leaf})
+		if err != nil {
+			return -1, err
+		}
+
+		n.Page, err = b.allocatePage(placeholder)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	return n.Page, b.writeNode(n)
+}
+
+// rebaseKey copies a key of src resolving values from its value log and storing large values in our value log
+func (b *BTree) rebaseKey(src *BTree, k *Key) (*Key, error) {
+	rebased := &Key{K: k.K, V: make([][]byte, 0, len(k.V)), E: k.E, M: k.M, Ver: k.Ver, D: k.D, R: k.R, F: k.F, W: k.W}
+
+	for i := range k.V {
+		v, err := src.resolveValue(k, i)
+		if err != nil {
+			return nil, err
+		}
+
+		v, ptr, err := b.storeValue(v)
+		if err != nil {
+			return nil, err
+		}
+
+		rebased.appendValue(v, ptr)
+	}
+
+	if k.B != 0 {
+		bm, err := src.readBitmap(k)
+		if err != nil {
+			return nil, err
+		}
+
+		err = b.writeBitmap(rebased, bm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rebased, nil
+}
+
+// rollForwardKey rolls a key of src forward atomically and adds it to our expiry index if it expires
+func (b *BTree) rollForwardKey(src *BTree, k *Key) error {
+	err := b.atomic(func() error {
+		return b.rollForward(src, k)
+	})
+	if err != nil || k.E == 0 {
+		return err
+	}
+
+	if b.expiry == nil {
+		err = b.openExpiryIndex()
+		if err != nil {
+			return err
+		}
+	}
+
+	return b.expiry.Put(expiryIndexKey(k.E, k.K), k.K)
+}
+
+// rollForward inserts every value of a key of src keeping its metadata, versions and expiry
+// Deleted keys and keys without values or a bitmap are skipped.
+func (b *BTree) rollForward(src *BTree, k *Key) error {
+	if k.D || (len(k.V) == 0 && k.B == 0) {
+		return nil
+	}
+
+	if k.B != 0 {
+		bm, err := src.readBitmap(k)
+		if err != nil {
+			return err
+		}
+
+		err = b.updateBitmap(k.K, true, func(target *Bitmap) {
+			*target = *target.Or(bm)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range k.V {
+		v, err := src.resolveValue(k, i)
+		if err != nil {
+			return err
+		}
+
+		err = b.insert(k.K, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	n, i, err := b.findNodeForKey(root, k.K)
+	if err != nil {
+		return err
+	}
+
+	// the values of k were appended last
+	key := n.Keys[i]
+	base := len(key.V) - len(k.V)
+
+	for j, meta := range k.M {
+		if meta != nil {
+			key.setMeta(base+j, meta)
+		}
+	}
+
+	if !b.versioned {
+		for j := range k.Ver {
+			if k.Ver[j] != 0 {
+				key.Ver = append(key.Ver, make([]uint64, len(key.V)-len(key.Ver))...)
+				key.Ver[base+j] = k.Ver[j]
+			}
+		}
+	}
+
+	if k.E != 0 {
+		key.E = k.E
+	}
+
+	if k.F != 0 {
+		key.F = k.F
+	}
+
+	// the values are placed in order once their metadata and versions are set
+	for j := base; j < len(key.V); j++ {
+		_, err = b.placeValue(key, j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return b.writeNode(n)
+}
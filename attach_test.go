@@ -0,0 +1,284 @@
+// Package btree
+// attach and detach tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_Attach(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("foreign.db")
+	defer os.Remove("foreign.db.del")
+
+	foreign, err := Open("foreign.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		err = foreign.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = foreign.PutWithMeta([]byte("050"), []byte("meta"), ValueMeta{Flags: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = foreign.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"empty", "non-empty"} {
+		btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name == "non-empty" {
+			err = btree.Put([]byte("local"), []byte("value"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = btree.Attach("foreign.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 200; i++ {
+			key, err := btree.Get([]byte(fmt.Sprintf("%03d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key == nil || string(key.V[0]) != fmt.Sprintf("value%d", i) {
+				t.Fatalf("%s: expected key %03d to be attached", name, i)
+			}
+		}
+
+		key, err := btree.GetWithMeta([]byte("050"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(key.V) != 2 || key.M[1] == nil || key.M[1].Flags != 3 {
+			t.Fatalf("%s: expected metadata to be attached", name)
+		}
+
+		// the tree must still accept writes after attaching
+		err = btree.Put([]byte("after"), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err = btree.Get([]byte("after"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil {
+			t.Fatalf("%s: expected key after attach", name)
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.Remove("btree.db")
+		os.Remove("btree.db.del")
+	}
+}
+
+func TestBTree_Detach(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("detached.db")
+	defer os.Remove("detached.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 20; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%02d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Detach([]byte("05"), []byte("07"), "detached.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detached, err := Open("detached.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer detached.Close()
+
+	keys, err := detached.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 3 || string(keys[0].K) != "05" || string(keys[2].K) != "07" {
+		t.Fatalf("expected keys 05 to 07 in the detached tree, got %d keys", len(keys))
+	}
+
+	for i := 0; i < 20; i++ {
+		key, err := btree.Get([]byte(fmt.Sprintf("%02d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		detachedKey := i >= 5 && i <= 7
+		if (key == nil) != detachedKey {
+			t.Fatalf("unexpected presence of key %02d after detach", i)
+		}
+	}
+
+	err = btree.Detach([]byte("00"), []byte("01"), "detached.db")
+	if err == nil {
+		t.Fatal("expected an error detaching into an existing file")
+	}
+}
+
+func TestBTree_Attach_Deleted(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("foreign.db")
+	defer os.Remove("foreign.db.del")
+
+	foreign, err := OpenWithOptions("foreign.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{TombstoneDeletes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		err = foreign.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 50; i += 2 {
+		err = foreign.Delete([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = foreign.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"empty", "non-empty"} {
+		btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := 25
+		if name == "non-empty" {
+			err = btree.Put([]byte("local"), []byte("value"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected++
+		}
+
+		err = btree.Attach("foreign.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := btree.InOrderTraversal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != expected {
+			t.Fatalf("%s: expected deleted keys to stay deleted, got %d keys", name, len(keys))
+		}
+
+		key, err := btree.Get([]byte("010"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key != nil {
+			t.Fatalf("%s: expected a deleted key to stay hidden", name)
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.Remove("btree.db")
+		os.Remove("btree.db.del")
+	}
+
+	// a mistyped path is not created
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	err = btree.Attach("missing.db")
+	if err == nil {
+		t.Fatal("expected an error attaching a missing file")
+	}
+
+	_, err = os.Stat("missing.db")
+	if !os.IsNotExist(err) {
+		os.Remove("missing.db")
+		t.Fatal("expected the missing file not to be created")
+	}
+}
@@ -0,0 +1,181 @@
+// Package btree
+// audit log
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAuditBuffer is the number of audit records buffered for the sink when Options.AuditBuffer is not set
+const DefaultAuditBuffer = 1024
+
+// AuditOp is the operation of an audit record
+type AuditOp string
+
+const (
+	AUDIT_PUT    AuditOp = "put"    // Values were put into a key
+	AUDIT_DELETE AuditOp = "delete" // A key was deleted
+	AUDIT_REMOVE AuditOp = "remove" // A value was removed from a key
+)
+
+// AuditRecord describes an operation on the btree
+type AuditRecord struct {
+	Time    time.Time `json:"time"`          // When the operation finished
+	Op      AuditOp   `json:"op"`            // The operation
+	KeyHash uint64    `json:"key_hash"`      // FNV-64a hash of the key, the key itself is not recorded
+	Size    int       `json:"size"`          // Bytes of the values put or removed, 0 for a delete
+	Tag     string    `json:"tag,omitempty"` // The tag of the handle, see Options.AuditTag
+	Err     string    `json:"err,omitempty"` // The error of a failed operation
+}
+
+// AuditSink receives the audit records of a btree
+// Records are delivered in order by a single goroutine, a sink which falls behind loses records rather than slowing the btree down.
+type AuditSink interface {
+	Audit(record AuditRecord)
+}
+
+// AuditFunc is a function receiving audit records
+type AuditFunc func(record AuditRecord)
+
+// Audit calls f
+func (f AuditFunc) Audit(record AuditRecord) {
+	f(record)
+}
+
+// auditWriter writes audit records as lines of JSON
+type auditWriter struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewAuditWriter returns a sink writing every record as a line of JSON to w, write errors are ignored as the audit log is lossy
+func NewAuditWriter(w io.Writer) AuditSink {
+	return &auditWriter{enc: json.NewEncoder(w)}
+}
+
+// Audit writes a record
+func (w *auditWriter) Audit(record AuditRecord) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	_ = w.enc.Encode(record)
+}
+
+// auditor hands the audit records of a btree to its sink through a buffer, records are dropped while the buffer is full
+type auditor struct {
+	sink    AuditSink
+	tag     string
+	lock    sync.RWMutex     // guards closing records
+	closed  bool             // true once records is closed
+	records chan AuditRecord // records waiting for the sink
+	done    chan struct{}    // closed once every record was delivered
+	dropped atomic.Uint64    // records dropped while the buffer was full
+}
+
+// newAuditor starts delivering the records of a btree to sink
+func newAuditor(sink AuditSink, tag string, buffer int) *auditor {
+	if buffer <= 0 {
+		buffer = DefaultAuditBuffer
+	}
+
+	a := &auditor{sink: sink, tag: tag, records: make(chan AuditRecord, buffer), done: make(chan struct{})}
+	go a.run()
+
+	return a
+}
+
+// run delivers records until the auditor is closed
+func (a *auditor) run() {
+	defer close(a.done)
+
+	for record := range a.records {
+		a.sink.Audit(record)
+	}
+}
+
+// record queues a record of an operation on key, it is dropped if the buffer is full
+func (a *auditor) record(op AuditOp, key []byte, size int, err error) {
+	h := fnv.New64a()
+	h.Write(key)
+
+	record := AuditRecord{Time: time.Now(), Op: op, KeyHash: h.Sum64(), Size: size, Tag: a.tag}
+	if err != nil {
+		record.Err = err.Error()
+	}
+
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+
+	select {
+	case a.records <- record:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// close delivers the queued records and stops the auditor
+func (a *auditor) close() {
+	a.lock.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.records)
+	}
+	a.lock.Unlock()
+
+	<-a.done
+}
+
+// audit records an operation on key if auditing is enabled
+func (b *BTree) audit(op AuditOp, key []byte, size int, err error) {
+	if b.auditor != nil {
+		b.auditor.record(op, key, size, err)
+	}
+}
+
+// valuesSize returns the bytes of values
+func valuesSize(values [][]byte) int {
+	size := 0
+	for _, v := range values {
+		size += len(v)
+	}
+	return size
+}
@@ -0,0 +1,169 @@
+// Package btree
+// audit log tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"os"
+	"testing"
+)
+
+func TestBTree_Audit(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	var log bytes.Buffer
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Audit: NewAuditWriter(&log), AuditTag: "billing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutMulti([]byte("key"), []byte("a"), []byte("bc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Remove([]byte("key"), []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Remove([]byte("missing"), []byte("a"))
+	if err == nil {
+		t.Fatal("expected an error removing from a missing key")
+	}
+
+	err = btree.Delete([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reads are not audited
+	_, err = btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Close waits for the records to be written
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte("key"))
+
+	expected := []AuditRecord{
+		{Op: AUDIT_PUT, KeyHash: h.Sum64(), Size: 5, Tag: "billing"},
+		{Op: AUDIT_PUT, KeyHash: h.Sum64(), Size: 3, Tag: "billing"},
+		{Op: AUDIT_REMOVE, KeyHash: h.Sum64(), Size: 1, Tag: "billing"},
+		{Op: AUDIT_REMOVE, Size: 1, Tag: "billing", Err: ErrKeyNotFound.Error()},
+		{Op: AUDIT_DELETE, KeyHash: h.Sum64(), Tag: "billing"},
+	}
+
+	scanner := bufio.NewScanner(&log)
+	for i := 0; scanner.Scan(); i++ {
+		if i >= len(expected) {
+			t.Fatalf("unexpected record %s", scanner.Text())
+		}
+
+		var record AuditRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if record.Time.IsZero() {
+			t.Fatalf("expected record %d to have a time", i)
+		}
+
+		record.Time = expected[i].Time
+		if i == 3 {
+			record.KeyHash = 0
+		}
+
+		if record != expected[i] {
+			t.Fatalf("expected record %d to be %+v, got %+v", i, expected[i], record)
+		}
+	}
+}
+
+func TestBTree_Audit_Dropped(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	release := make(chan struct{})
+	delivered := 0
+
+	// the sink blocks until released so the buffer of a single record fills up
+	sink := AuditFunc(func(record AuditRecord) {
+		<-release
+		delivered++
+	})
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Audit: sink, AuditBuffer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		err = btree.Put([]byte{byte(i)}, []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dropped := btree.Stats().AuditDropped
+	if dropped < 3 {
+		t.Fatalf("expected at least 3 dropped records, got %d", dropped)
+	}
+
+	close(release)
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uint64(delivered)+dropped != 5 {
+		t.Fatalf("expected 5 records delivered or dropped, got %d delivered and %d dropped", delivered, dropped)
+	}
+}
@@ -0,0 +1,470 @@
+// Package btree
+// incremental backups
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// backupMagic starts every backup written by BackupIncremental, backups starting with backupMagicV1 carry no files
+const (
+	backupMagic   = "BTINCBK2"
+	backupMagicV1 = "BTINCBK1"
+)
+
+// ErrBackupChain is returned when restoring an incremental backup which does not follow the backups restored so far
+var ErrBackupChain = errors.New("backup does not follow the restored backups")
+
+// pageEpochs tracks the backup epoch each page was last written in
+// Epochs are persisted on sync and close, after a crash every page counts as written in the current epoch
+type pageEpochs struct {
+	file    pageFile         // file the epochs are persisted to, nil for pagers without one
+	current uint64           // epoch assigned to pages written now, advanced by every backup
+	since   uint64           // every page counts as written in this epoch, set when writes may have gone untracked
+	pages   map[int64]uint64 // epoch each page was last written in
+}
+
+// newPageEpochs returns the epochs of a new pager
+func newPageEpochs() pageEpochs {
+	return pageEpochs{current: 1, pages: make(map[int64]uint64)}
+}
+
+// touch records a page written in the current epoch
+func (e *pageEpochs) touch(pageID int64) {
+	e.pages[pageID] = e.current
+}
+
+// epoch returns the epoch a page was last written in
+func (e *pageEpochs) epoch(pageID int64) uint64 {
+	return max(e.pages[pageID], e.since)
+}
+
+// load reads the epochs from file, a file which was not closed cleanly marks every page as written
+func (e *pageEpochs) load(file pageFile) error {
+	*e = newPageEpochs()
+	e.file = file
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() == 0 {
+		return nil
+	}
+
+	data := make([]byte, stat.Size())
+	_, err = file.ReadAt(data, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	if len(data) < 25 {
+		return errors.New("invalid epoch file")
+	}
+
+	clean := data[0] == 1
+	e.current = binary.BigEndian.Uint64(data[1:9])
+	e.since = binary.BigEndian.Uint64(data[9:17])
+	n := binary.BigEndian.Uint64(data[17:25])
+	data = data[25:]
+
+	if uint64(len(data)) != n*16 {
+		return errors.New("invalid epoch file")
+	}
+
+	for i := uint64(0); i < n; i++ {
+		e.pages[int64(binary.BigEndian.Uint64(data[i*16:]))] = binary.BigEndian.Uint64(data[i*16+8:])
+	}
+
+	if !clean {
+		// pages written after the last sync were not recorded
+		e.since = e.current
+	}
+
+	return nil
+}
+
+// write persists the epochs, clean is only set when the pager is closed
+func (e *pageEpochs) write(clean bool) error {
+	if e.file == nil {
+		return nil
+	}
+
+	data := make([]byte, 25, 25+len(e.pages)*16)
+	if clean {
+		data[0] = 1
+	}
+	binary.BigEndian.PutUint64(data[1:9], e.current)
+	binary.BigEndian.PutUint64(data[9:17], e.since)
+	binary.BigEndian.PutUint64(data[17:25], uint64(len(e.pages)))
+
+	for pageID, epoch := range e.pages {
+		data = binary.BigEndian.AppendUint64(data, uint64(pageID))
+		data = binary.BigEndian.AppendUint64(data, epoch)
+	}
+
+	err := e.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.file.WriteAt(data, 0)
+	return err
+}
+
+// createEpochFile creates the file the epochs are persisted to if the pager has none yet
+func (p *Pager) createEpochFile() error {
+	if p.epochs.file != nil || p.epochName == "" {
+		return nil
+	}
+
+	file, err := openFile(p.epochName, os.O_CREATE|os.O_RDWR, p.perm)
+	if err != nil {
+		return err
+	}
+
+	p.epochs.file = file
+
+	return nil
+}
+
+// BackupManifest describes a backup written by BackupIncremental
+type BackupManifest struct {
+	Since     uint64   // The epoch the backup follows, 0 for a full backup
+	Epoch     uint64   // The epoch to pass as since to the next BackupIncremental
+	PageCount int64    // The number of pages when the backup was taken
+	FreePages []int64  // The deleted pages when the backup was taken
+	Pages     []int64  // The pages stored in the backup
+	Files     []string // The suffixes of the files stored next to the btree file which are in the backup
+}
+
+// BackupIncremental writes the pages modified since the backup which returned since, 0 writes every page
+// The returned epoch is passed as since to the next backup, RestoreIncremental applies the backups in order
+func (p *Pager) BackupIncremental(w io.Writer, since uint64) (uint64, error) {
+	return p.backupIncremental(w, since, nil)
+}
+
+// backupIncremental writes a backup holding the pages modified since the backup which returned since and files
+// files maps the suffix of a file stored next to the btree file to its contents, they are written after the pages
+func (p *Pager) backupIncremental(w io.Writer, since uint64, files map[string][]byte) (uint64, error) {
+	if p.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	err := p.createEpochFile()
+	if err != nil {
+		return 0, err
+	}
+
+	manifest := &BackupManifest{
+		Since:     since,
+		Epoch:     p.epochs.current,
+		PageCount: p.count.Load(),
+		FreePages: p.deletedPages,
+		Pages:     make([]int64, 0),
+	}
+
+	for pageID := int64(0); pageID < manifest.PageCount; pageID++ {
+		if since == 0 || p.epochs.epoch(pageID) > since {
+			manifest.Pages = append(manifest.Pages, pageID)
+		}
+	}
+
+	hash := crc32.NewIEEE()
+	out := io.MultiWriter(w, hash)
+
+	header := []byte(backupMagic)
+	header = binary.BigEndian.AppendUint64(header, manifest.Since)
+	header = binary.BigEndian.AppendUint64(header, manifest.Epoch)
+	header = binary.BigEndian.AppendUint64(header, uint64(manifest.PageCount))
+	header = binary.BigEndian.AppendUint64(header, uint64(len(manifest.FreePages)))
+	for _, pageID := range manifest.FreePages {
+		header = binary.BigEndian.AppendUint64(header, uint64(pageID))
+	}
+	header = binary.BigEndian.AppendUint64(header, uint64(len(manifest.Pages)))
+
+	_, err = out.Write(header)
+	if err != nil {
+		return 0, err
+	}
+
+	page := make([]byte, 8+PAGE_SIZE+HEADER_SIZE)
+	for _, pageID := range manifest.Pages {
+		clear(page)
+		binary.BigEndian.PutUint64(page, uint64(pageID))
+
+		// a final page cut short is written padded
+		_, err = p.file.ReadAt(page[8:], pageID*(PAGE_SIZE+HEADER_SIZE))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+
+		_, err = out.Write(page)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for suffix := range files {
+		manifest.Files = append(manifest.Files, suffix)
+	}
+	sort.Strings(manifest.Files)
+
+	trailer := binary.BigEndian.AppendUint64(nil, uint64(len(manifest.Files)))
+	for _, suffix := range manifest.Files {
+		trailer = binary.BigEndian.AppendUint64(trailer, uint64(len(suffix)))
+		trailer = append(trailer, suffix...)
+		trailer = binary.BigEndian.AppendUint64(trailer, uint64(len(files[suffix])))
+		trailer = append(trailer, files[suffix]...)
+	}
+
+	_, err = out.Write(trailer)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.Write(binary.BigEndian.AppendUint32(nil, hash.Sum32()))
+	if err != nil {
+		return 0, err
+	}
+
+	// pages written from now on belong to the next backup
+	p.epochs.current++
+
+	err = p.epochs.write(false)
+	if err != nil {
+		return 0, err
+	}
+
+	return manifest.Epoch, nil
+}
+
+// readBackup reads and verifies a backup written by BackupIncremental
+// The pages are returned by page id and the files by suffix, backups written before files were carried return no files
+func readBackup(r io.Reader) (*BackupManifest, map[int64][]byte, map[string][]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(data) < len(backupMagic)+44 {
+		return nil, nil, nil, errors.New("not a backup")
+	}
+
+	magic := string(data[:len(backupMagic)])
+	if magic != backupMagic && magic != backupMagicV1 {
+		return nil, nil, nil, errors.New("not a backup")
+	}
+
+	body := data[:len(data)-4]
+	if binary.BigEndian.Uint32(data[len(data)-4:]) != crc32.ChecksumIEEE(body) {
+		return nil, nil, nil, errors.New("backup checksum mismatch")
+	}
+
+	body = body[len(backupMagic):]
+	next := func() (uint64, error) {
+		if len(body) < 8 {
+			return 0, errors.New("truncated backup")
+		}
+		v := binary.BigEndian.Uint64(body)
+		body = body[8:]
+		return v, nil
+	}
+
+	manifest := &BackupManifest{}
+	var values [4]uint64
+	for i := range values {
+		values[i], err = next()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	manifest.Since, manifest.Epoch, manifest.PageCount = values[0], values[1], int64(values[2])
+
+	manifest.FreePages = make([]int64, values[3])
+	for i := range manifest.FreePages {
+		pageID, err := next()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		manifest.FreePages[i] = int64(pageID)
+	}
+
+	n, err := next()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if uint64(len(body))/(8+PAGE_SIZE+HEADER_SIZE) < n || (magic == backupMagicV1 && uint64(len(body)) != n*(8+PAGE_SIZE+HEADER_SIZE)) {
+		return nil, nil, nil, errors.New("truncated backup")
+	}
+
+	pages := make(map[int64][]byte, n)
+	manifest.Pages = make([]int64, n)
+	for i := range manifest.Pages {
+		pageID := int64(binary.BigEndian.Uint64(body))
+		manifest.Pages[i] = pageID
+		pages[pageID] = body[8 : 8+PAGE_SIZE+HEADER_SIZE]
+		body = body[8+PAGE_SIZE+HEADER_SIZE:]
+	}
+
+	if magic == backupMagicV1 {
+		return manifest, pages, nil, nil
+	}
+
+	files := make(map[string][]byte)
+
+	// field reads a length prefixed field of the files section
+	field := func() ([]byte, error) {
+		size, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(body)) < size {
+			return nil, errors.New("truncated backup")
+		}
+		value := body[:size]
+		body = body[size:]
+		return value, nil
+	}
+
+	count, err := next()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		suffix, err := field()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		contents, err := field()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		manifest.Files = append(manifest.Files, string(suffix))
+		files[string(suffix)] = contents
+	}
+
+	if len(body) != 0 {
+		return nil, nil, nil, errors.New("invalid backup")
+	}
+
+	return manifest, pages, files, nil
+}
+
+// RestoreIncremental applies a backup written by BackupIncremental to the file name
+// A full backup replaces the file, an incremental backup must follow the last backup restored to the file
+// The restored file is only modified once the whole backup was read and verified
+func RestoreIncremental(r io.Reader, name string) (*BackupManifest, error) {
+	manifest, pages, files, err := readBackup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := OpenPager(name, os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		return nil, err
+	}
+
+	p.deletedPagesLock.Lock()
+
+	err = p.createEpochFile()
+	if err == nil && manifest.Since == 0 {
+		err = p.file.Truncate(0)
+	} else if err == nil && (p.epochs.current != manifest.Since+1 || p.epochs.since != 0 || len(p.epochs.pages) != 0) {
+		// the file was restored from another chain or modified after the last restore
+		err = ErrBackupChain
+	}
+
+	for pageID := int64(0); err == nil && pageID < manifest.PageCount; pageID++ {
+		if page, ok := pages[pageID]; ok {
+			_, err = p.file.WriteAt(page, pageID*(PAGE_SIZE+HEADER_SIZE))
+		}
+	}
+
+	if err == nil {
+		err = p.file.Truncate(manifest.PageCount * (PAGE_SIZE + HEADER_SIZE))
+	}
+
+	if err == nil {
+		p.deletedPages = append(make([]int64, 0, len(manifest.FreePages)), manifest.FreePages...)
+		p.freeOverflow = make(pageClasses)
+		p.count.Store(manifest.PageCount)
+
+		// the restored file continues after the backup, pages written to it from now on are tracked
+		p.epochs.current = manifest.Epoch + 1
+		p.epochs.since = 0
+		p.epochs.pages = make(map[int64]uint64)
+	}
+
+	p.deletedPagesLock.Unlock()
+
+	err = errors.Join(err, p.Close())
+	if err != nil {
+		return nil, err
+	}
+
+	// every backup carries all files of the tree as of the backup
+	if files != nil {
+		err = writeCopiedFiles(name, files, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// BackupIncremental writes the pages modified since the backup which returned since, 0 writes every page
+// Only supported when the BTree is stored by a Pager, the value log is not part of the backup
+// The files the pages cannot be read without, such as the recorded key transform or dictionary, are stored in every backup
+func (b *BTree) BackupIncremental(w io.Writer, since uint64) (uint64, error) {
+	pager, ok := b.Pager.(*Pager)
+	if !ok {
+		return 0, errors.New("storage does not support BackupIncremental")
+	}
+
+	return pager.backupIncremental(w, since, b.copiedFiles())
+}
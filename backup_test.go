@@ -0,0 +1,177 @@
+// Package btree
+// incremental backups tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestBTree_BackupIncremental(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.epoch")
+	defer os.Remove("restored.db")
+	defer os.Remove("restored.db.del")
+	defer os.Remove("restored.db.epoch")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	put(0, 500)
+
+	full := new(bytes.Buffer)
+	epoch, err := btree.BackupIncremental(full, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(500, 510)
+
+	first := new(bytes.Buffer)
+	epoch, err = btree.BackupIncremental(first, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Len() >= full.Len()/2 {
+		t.Fatalf("expected the incremental backup to be much smaller than %d bytes, got %d", full.Len(), first.Len())
+	}
+
+	put(510, 520)
+
+	second := new(bytes.Buffer)
+	_, err = btree.BackupIncremental(second, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := RestoreIncremental(bytes.NewReader(full.Bytes()), "restored.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if manifest.Since != 0 {
+		t.Fatalf("expected a full backup, got since %d", manifest.Since)
+	}
+
+	// backups must be restored in order
+	_, err = RestoreIncremental(bytes.NewReader(second.Bytes()), "restored.db")
+	if !errors.Is(err, ErrBackupChain) {
+		t.Fatalf("expected ErrBackupChain, got %v", err)
+	}
+
+	for _, backup := range []*bytes.Buffer{first, second} {
+		_, err = RestoreIncremental(bytes.NewReader(backup.Bytes()), "restored.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a corrupt backup is never applied
+	corrupt := bytes.Clone(second.Bytes())
+	corrupt[len(corrupt)/2] ^= 0xff
+	_, err = RestoreIncremental(bytes.NewReader(corrupt), "restored.db")
+	if err == nil {
+		t.Fatal("expected an error restoring a corrupt backup")
+	}
+
+	restored, err := Open("restored.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	for i := 0; i < 520; i++ {
+		key, err := restored.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil {
+			t.Fatalf("expected key %d to be restored", i)
+		}
+	}
+}
+
+func TestPageEpochs_Crash(t *testing.T) {
+	file := newMemFile()
+
+	epochs := newPageEpochs()
+	epochs.file = file
+	epochs.current = 5
+	epochs.touch(1)
+
+	err := epochs.write(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = epochs.load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if epochs.epoch(1) != 5 || epochs.epoch(2) != 0 {
+		t.Fatalf("expected page 1 in epoch 5 and page 2 untouched, got %d and %d", epochs.epoch(1), epochs.epoch(2))
+	}
+
+	// epochs which were not written on close may miss pages
+	err = epochs.write(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = epochs.load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if epochs.epoch(2) != 5 {
+		t.Fatalf("expected every page to count as written in epoch 5, got %d", epochs.epoch(2))
+	}
+}
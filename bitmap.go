@@ -0,0 +1,412 @@
+// Package btree
+// integer value sets
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+)
+
+// ErrValueMode is returned when a key storing its values as a bitmap is written as a key storing byte values or the other way around
+var ErrValueMode = errors.New("key stores its values in another mode")
+
+// AddValue adds an integer value to the bitmap of a key and returns true if it was not in the bitmap
+// A key written with AddValue stores its values as a roaring bitmap in a page of its own (Key.B) instead of as byte values,
+// which keeps dense integer ids small.  A key which does not exist is created, a key holding byte values returns ErrValueMode.
+func (b *BTree) AddValue(k []byte, v uint32) (bool, error) {
+	added, err := b.AddValues(k, v)
+	return added > 0, err
+}
+
+// AddValues adds integer values to the bitmap of a key with a single write and returns the number of values which were not in it, see AddValue
+func (b *BTree) AddValues(k []byte, values ...uint32) (int, error) {
+	k = b.transformKey(k)
+
+	err := b.checkKeySize(k)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	err = b.atomic(func() error {
+		return b.updateBitmap(k, true, func(bm *Bitmap) {
+			for _, v := range values {
+				if bm.Add(v) {
+					added++
+				}
+			}
+		})
+	})
+
+	b.audit(AUDIT_PUT, k, 4*len(values), err)
+	b.changed(AUDIT_PUT, k, 4*len(values), err)
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// RemoveValue removes an integer value from the bitmap of a key and returns true if it was in the bitmap
+// A key whose bitmap is left empty is deleted.  ErrKeyNotFound is returned if the key does not exist.
+func (b *BTree) RemoveValue(k []byte, v uint32) (bool, error) {
+	k = b.transformKey(k)
+
+	removed := false
+	err := b.atomic(func() error {
+		return b.updateBitmap(k, false, func(bm *Bitmap) {
+			removed = bm.Remove(v)
+		})
+	})
+
+	b.audit(AUDIT_REMOVE, k, 4, err)
+	if removed {
+		b.changed(AUDIT_REMOVE, k, 4, err)
+	}
+	if err != nil {
+		return false, err
+	}
+	return removed, nil
+}
+
+// ContainsValue returns true if an integer value is in the bitmap of a key, false if the key does not exist
+func (b *BTree) ContainsValue(k []byte, v uint32) (bool, error) {
+	bm, err := b.Bitmap(k)
+	if err != nil || bm == nil {
+		return false, err
+	}
+
+	return bm.Contains(v), nil
+}
+
+// Bitmap returns the integer values of a key, nil if the key does not exist
+// ErrValueMode is returned for a key holding byte values.
+func (b *BTree) Bitmap(k []byte) (*Bitmap, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := b.lookupKey(root, b.transformKey(k))
+	if err != nil || key == nil || key.hidden() {
+		return nil, err
+	}
+
+	if key.B == 0 {
+		return nil, ErrValueMode
+	}
+
+	return b.readBitmap(key)
+}
+
+// IntersectValues returns the integer values held by the bitmaps of every key, a key which does not exist holds none
+func (b *BTree) IntersectValues(keys ...[]byte) (*Bitmap, error) {
+	var result *Bitmap
+	for _, k := range keys {
+		bm, err := b.Bitmap(k)
+		if err != nil {
+			return nil, err
+		}
+
+		if bm == nil {
+			return &Bitmap{}, nil
+		}
+
+		if result == nil {
+			result = bm
+		} else {
+			result = result.And(bm)
+		}
+	}
+
+	if result == nil {
+		return &Bitmap{}, nil
+	}
+	return result, nil
+}
+
+// UnionValues returns the integer values held by the bitmap of any of the keys
+func (b *BTree) UnionValues(keys ...[]byte) (*Bitmap, error) {
+	result := &Bitmap{}
+	for _, k := range keys {
+		bm, err := b.Bitmap(k)
+		if err != nil {
+			return nil, err
+		}
+
+		if bm != nil {
+			result = result.Or(bm)
+		}
+	}
+	return result, nil
+}
+
+// DifferenceValues returns the integer values held by the bitmap of k and by none of the bitmaps of others
+func (b *BTree) DifferenceValues(k []byte, others ...[]byte) (*Bitmap, error) {
+	result, err := b.Bitmap(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		return &Bitmap{}, nil
+	}
+
+	for _, other := range others {
+		bm, err := b.Bitmap(other)
+		if err != nil {
+			return nil, err
+		}
+
+		if bm != nil {
+			result = result.AndNot(bm)
+		}
+	}
+	return result, nil
+}
+
+// updateBitmap applies fn to the bitmap of a transformed key and writes it back
+// If create is set a key which does not exist is created with an empty bitmap, otherwise ErrKeyNotFound is returned.
+// A key left with an empty bitmap is deleted.
+func (b *BTree) updateBitmap(k []byte, create bool, fn func(bm *Bitmap)) error {
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	n, i, err := b.findNodeForKey(root, k)
+	if errors.Is(err, ErrKeyNotFound) {
+		if !create {
+			return ErrKeyNotFound
+		}
+
+		bm := &Bitmap{}
+		fn(bm)
+		if bm.Cardinality() == 0 {
+			return nil
+		}
+
+		key := &Key{K: k, V: make([][]byte, 0)}
+		err = b.writeBitmap(key, bm)
+		if err != nil {
+			return err
+		}
+
+		b.keyChanged(key)
+		return b.insertFromRoot(key)
+	} else if err != nil {
+		return err
+	}
+
+	key := n.Keys[i]
+
+	// an expired or deleted key which was not purged yet starts over
+	if key.hidden() {
+		if !create {
+			return ErrKeyNotFound
+		}
+
+		err = b.dropHashSet(key)
+		if err == nil {
+			err = b.dropBitmap(key)
+		}
+		if err != nil {
+			return err
+		}
+
+		key = &Key{K: key.K, V: make([][]byte, 0), R: key.R, W: key.W}
+		n.Keys[i] = key
+	} else if key.B == 0 && len(key.V) > 0 {
+		return ErrValueMode
+	}
+
+	bm := &Bitmap{}
+	if key.B != 0 {
+		bm, err = b.readBitmap(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	fn(bm)
+
+	if bm.Cardinality() == 0 {
+		_, err = b.delete(k)
+		return err
+	}
+
+	page := key.B
+	err = b.writeBitmap(key, bm)
+	if err != nil {
+		return err
+	}
+
+	b.keyChanged(key)
+
+	// the node records the page of a new bitmap and the version of the key
+	if key.B != page || b.keyVersions {
+		return b.writeNode(n)
+	}
+	return nil
+}
+
+// readBitmap reads the bitmap page of k
+func (b *BTree) readBitmap(k *Key) (*Bitmap, error) {
+	data, err := b.Pager.ReadPage(k.B)
+	if err != nil {
+		return nil, err
+	}
+
+	bm := &Bitmap{}
+	err = bm.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return bm, nil
+}
+
+// writeBitmap writes the bitmap page of k, a page is allocated for a key without one, the caller writes the node holding k
+func (b *BTree) writeBitmap(k *Key, bm *Bitmap) error {
+	encoded, err := bm.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if k.B != 0 {
+		return b.Pager.WritePage(k.B, encoded)
+	}
+
+	k.B, err = b.allocatePage(encoded)
+	return err
+}
+
+// dropBitmap frees the bitmap page of k, the caller writes the node holding k
+func (b *BTree) dropBitmap(k *Key) error {
+	if k.B == 0 {
+		return nil
+	}
+
+	err := b.freePage(k.B)
+	if err != nil {
+		return err
+	}
+
+	k.B = 0
+
+	return nil
+}
+
+// detachBitmap clears the bitmap page of the stored key k and returns it, so deleting the key does not free it
+func (b *BTree) detachBitmap(k []byte) (int64, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	n, i, err := b.findNodeForKey(root, k)
+	if err != nil || n.Keys[i].B == 0 {
+		return 0, err
+	}
+
+	page := n.Keys[i].B
+	n.Keys[i].B = 0
+
+	return page, b.writeNode(n)
+}
+
+// takeBitmaps frees every bitmap page and returns the bitmaps by key, the keys no longer refer to them until restoreBitmaps
+func (b *BTree) takeBitmaps() (map[string]*Bitmap, error) {
+	bitmaps := make(map[string]*Bitmap)
+
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.walk(root, func(n *Node) error {
+		taken := false
+		for _, k := range n.Keys {
+			if k == nil || k.B == 0 {
+				continue
+			}
+
+			bm, err := b.readBitmap(k)
+			if err != nil {
+				return err
+			}
+
+			err = b.dropBitmap(k)
+			if err != nil {
+				return err
+			}
+
+			bitmaps[string(k.K)] = bm
+			taken = true
+		}
+
+		if !taken {
+			return nil
+		}
+		return b.writeNode(n)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bitmaps, nil
+}
+
+// restoreBitmaps writes the bitmaps returned by takeBitmaps to new pages
+func (b *BTree) restoreBitmaps(bitmaps map[string]*Bitmap) error {
+	for k, bm := range bitmaps {
+		root, err := b.getRoot()
+		if err != nil {
+			return err
+		}
+
+		n, i, err := b.findNodeForKey(root, []byte(k))
+		if err != nil {
+			return err
+		}
+
+		err = b.writeBitmap(n.Keys[i], bm)
+		if err != nil {
+			return err
+		}
+
+		err = b.writeNode(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,290 @@
+// Package btree
+// integer value sets tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBTree_BitmapValues(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("bitmap*.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	btree, err := Open("bitmap.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failing run closes the btree before its files are removed, closing it again is harmless
+	defer btree.Close()
+
+	// byte valued keys around the bitmap keys split the tree
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// even ids are tagged red, multiples of 3 blue
+	for v := uint32(0); v < 100; v += 2 {
+		_, err = btree.AddValue([]byte("red"), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	red, blue := make([]uint32, 0), make([]uint32, 0)
+	for v := uint32(100); v < 10000; v += 2 {
+		red = append(red, v)
+	}
+
+	for v := uint32(0); v < 10000; v += 3 {
+		blue = append(blue, v)
+	}
+
+	_, err = btree.AddValues([]byte("red"), red...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = btree.AddValues([]byte("blue"), blue...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := btree.AddValue([]byte("red"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added {
+		t.Fatal("expected 2 to be in the bitmap already")
+	}
+
+	n, err := btree.AddValues([]byte("green"), 1, 2, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 3 {
+		t.Fatalf("expected 3 values added, got %d", n)
+	}
+
+	// the modes do not mix
+	_, err = btree.AddValue([]byte("key000"), 1)
+	if !errors.Is(err, ErrValueMode) {
+		t.Fatalf("expected ErrValueMode, got %v", err)
+	}
+
+	err = btree.Put([]byte("red"), []byte("value"))
+	if !errors.Is(err, ErrValueMode) {
+		t.Fatalf("expected ErrValueMode, got %v", err)
+	}
+
+	_, err = btree.Bitmap([]byte("key000"))
+	if !errors.Is(err, ErrValueMode) {
+		t.Fatalf("expected ErrValueMode, got %v", err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = Open("bitmap.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	ok, err := btree.ContainsValue([]byte("red"), 4242)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("expected 4242 to be red")
+	}
+
+	ok, err = btree.ContainsValue([]byte("missing"), 1)
+	if err != nil || ok {
+		t.Fatalf("expected a missing key to hold no values, got %v %v", ok, err)
+	}
+
+	both, err := btree.IntersectValues([]byte("red"), []byte("blue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// multiples of 6 below 10000
+	if both.Cardinality() != 1667 {
+		t.Fatalf("expected 1667 values, got %d", both.Cardinality())
+	}
+
+	either, err := btree.UnionValues([]byte("red"), []byte("blue"), []byte("missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if either.Cardinality() != 5000+3334-1667 {
+		t.Fatalf("expected %d values, got %d", 5000+3334-1667, either.Cardinality())
+	}
+
+	onlyRed, err := btree.DifferenceValues([]byte("red"), []byte("blue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if onlyRed.Cardinality() != 5000-1667 || onlyRed.Contains(6) || !onlyRed.Contains(4) {
+		t.Fatalf("expected %d values, got %d", 5000-1667, onlyRed.Cardinality())
+	}
+
+	// the bitmap moves with a renamed key and survives a layout optimization and a copy
+	err = btree.ReKey([]byte("blue"), []byte("cyan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = btree.OptimizeLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.CopyTo("bitmap_copy.db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := Open("bitmap_copy.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+
+	for _, tree := range []*BTree{btree, copied} {
+		for _, k := range []string{"red", "cyan", "green"} {
+			bm, err := tree.Bitmap([]byte(k))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bm == nil {
+				t.Fatalf("expected key %s to hold a bitmap", k)
+			}
+		}
+
+		cyan, err := tree.Bitmap([]byte("cyan"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cyan.Cardinality() != 3334 || !cyan.Contains(9999) {
+			t.Fatalf("expected 3334 blue values, got %d", cyan.Cardinality())
+		}
+	}
+
+	// removing the last value deletes the key
+	for _, v := range []uint32{1, 2, 3} {
+		removed, err := btree.RemoveValue([]byte("green"), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !removed {
+			t.Fatalf("expected %d to be removed", v)
+		}
+	}
+
+	key, err := btree.Get([]byte("green"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected the emptied key to be deleted")
+	}
+
+	_, err = btree.RemoveValue([]byte("green"), 1)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// deleting a key frees its bitmap page, a new key reuses it
+	free := btree.Pager.(*Pager).FreePageCount()
+
+	err = btree.Delete([]byte("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if btree.Pager.(*Pager).FreePageCount() <= free {
+		t.Fatal("expected the bitmap page to be freed")
+	}
+
+	values, err := btree.UnionValues([]byte("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values.Cardinality() != 0 {
+		t.Fatalf("expected no red values, got %d", values.Cardinality())
+	}
+
+	_, err = btree.AddValues([]byte("red"), 7, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bm, err := btree.Bitmap([]byte("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(bm.Values(), []uint32{5, 7}) {
+		t.Fatalf("expected 5 and 7, got %v", bm.Values())
+	}
+}
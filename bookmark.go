@@ -0,0 +1,143 @@
+// Package btree
+// cursor bookmarks
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// BOOKMARK_VERSION is the version of the bookmark encoding
+const BOOKMARK_VERSION = 1
+
+const (
+	bookmarkStarted  = 1 << 0 // the cursor returned a key
+	bookmarkExcludes = 1 << 1 // the cursor skips a range, see NRangeCursor
+)
+
+// ErrInvalidBookmark is returned by SeekBookmark for a token which was not returned by Cursor.Bookmark or was damaged
+var ErrInvalidBookmark = errors.New("invalid bookmark")
+
+// Bookmark returns an opaque token of the cursor position, SeekBookmark resumes iterating after it
+// The token holds the last key returned and is checksummed, it stays valid across writes and after the btree is reopened.
+// Keys inserted after the position are returned once resumed, like writes made during iteration.
+func (c *Cursor) Bookmark() []byte {
+	var flags byte
+	if c.started {
+		flags |= bookmarkStarted
+	}
+
+	if c.exclude != nil {
+		flags |= bookmarkExcludes
+	}
+
+	token := []byte{BOOKMARK_VERSION, flags}
+	token = appendBookmarkKey(token, c.last)
+
+	if c.exclude != nil {
+		token = appendBookmarkKey(token, c.exclude.start)
+		token = appendBookmarkKey(token, c.exclude.end)
+	}
+
+	return binary.BigEndian.AppendUint32(token, crc32.ChecksumIEEE(token))
+}
+
+// SeekBookmark returns a cursor resuming after the position a bookmark was taken at, the next call to Next returns the following key
+// ErrInvalidBookmark is returned for a token which was not returned by Cursor.Bookmark or does not fit this btree.
+func (b *BTree) SeekBookmark(token []byte) (*Cursor, error) {
+	if len(token) < 6 || token[0] != BOOKMARK_VERSION {
+		return nil, ErrInvalidBookmark
+	}
+
+	body := token[:len(token)-4]
+	if binary.BigEndian.Uint32(token[len(token)-4:]) != crc32.ChecksumIEEE(body) {
+		return nil, ErrInvalidBookmark
+	}
+
+	flags := body[1]
+	c := &Cursor{b: b, started: flags&bookmarkStarted != 0}
+
+	rest, last, ok := readBookmarkKey(body[2:])
+	if !ok {
+		return nil, ErrInvalidBookmark
+	}
+	c.last = last
+
+	if flags&bookmarkExcludes != 0 {
+		var start, end []byte
+		rest, start, ok = readBookmarkKey(rest)
+		if ok {
+			rest, end, ok = readBookmarkKey(rest)
+		}
+
+		if !ok {
+			return nil, ErrInvalidBookmark
+		}
+
+		c.exclude = &keyBounds{start: start, end: end, includeStart: true, includeEnd: true}
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrInvalidBookmark
+	}
+
+	// the keys were stored transformed, a btree with a fixed key size never returned a key of another size
+	if c.started && b.checkKeySize(c.last) != nil {
+		return nil, ErrInvalidBookmark
+	}
+
+	return c, nil
+}
+
+// appendBookmarkKey appends a key prefixed with its length to a bookmark
+func appendBookmarkKey(token, k []byte) []byte {
+	token = binary.BigEndian.AppendUint32(token, uint32(len(k)))
+	return append(token, k...)
+}
+
+// readBookmarkKey reads a key written by appendBookmarkKey and returns the rest of the bookmark, false if it is cut short
+func readBookmarkKey(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+
+	n := binary.BigEndian.Uint32(data)
+	if uint64(n) > uint64(len(data)-4) {
+		return nil, nil, false
+	}
+
+	// the key is copied so the cursor does not alias the caller's token
+	k := make([]byte, n)
+	copy(k, data[4:4+n])
+	return data[4+n:], k, true
+}
@@ -0,0 +1,158 @@
+// Package btree
+// cursor bookmarks tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_SeekBookmark(t *testing.T) {
+	defer os.Remove("bookmark.db")
+	defer os.Remove("bookmark.db.del")
+
+	btree, err := Open("bookmark.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 300; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a bookmark taken before the first key resumes at the first key
+	token := btree.Cursor().Bookmark()
+
+	c, err := btree.SeekBookmark(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := c.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(key.K) != "0000" {
+		t.Fatalf("expected key 0000, got %s", key.K)
+	}
+
+	c = btree.Cursor()
+	for key, err = c.First(); key != nil && string(key.K) != "0149"; key, err = c.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token = c.Bookmark()
+
+	excluded := btree.NRangeCursor([]byte("0100"), []byte("0199"))
+	_, err = excluded.Seek([]byte("0050"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	excludedToken := excluded.Bookmark()
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = Open("bookmark.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	// a key inserted after the position is returned once resumed
+	err = btree.Put([]byte("0149a"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = btree.SeekBookmark(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"0149a", "0150", "0151"}
+	for _, e := range expected {
+		key, err = c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.K) != e {
+			t.Fatalf("expected key %s, got %v", e, key)
+		}
+	}
+
+	// the excluded range survives the bookmark
+	c, err = btree.SeekBookmark(excludedToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for key, err = c.Next(); key != nil || err != nil; key, err = c.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(key.K) >= "0100" && string(key.K) <= "0199" {
+			t.Fatalf("expected key %s to be skipped", key.K)
+		}
+		count++
+	}
+
+	// 0051..0099 and 0200..0299
+	if count != 149 {
+		t.Fatalf("expected 149 keys, got %d", count)
+	}
+
+	// damaged tokens are refused
+	damaged := append([]byte(nil), token...)
+	damaged[3] ^= 0xff
+
+	for _, bad := range [][]byte{nil, []byte("bookmark"), damaged, token[:len(token)-1]} {
+		_, err = btree.SeekBookmark(bad)
+		if !errors.Is(err, ErrInvalidBookmark) {
+			t.Fatalf("expected ErrInvalidBookmark, got %v", err)
+		}
+	}
+}
@@ -36,22 +36,131 @@ import (
 	"errors"
 	"fmt"
 	"github.com/hashicorp/go-msgpack/codec"
+	"io"
+	"math"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // BTree is the main BTree struct
-// ** not thread safe
+// Not thread safe apart from Put, PutMulti and Get of a btree opened with Options.ConcurrentWriters, which may also race with Close
 type BTree struct {
-	Pager *Pager // The pager for the btree
-	T     int    // The order of the tree
+	Pager          Storage               // The pager for the btree
+	T              int                   // The order of the tree
+	ValueLog       *ValueLog             // The value log for large values, nil if disabled
+	valueThreshold int                   // Values of at least this size are stored in the value log
+	journal        *shadowJournal        // The journal used to publish operations atomically, nil if disabled
+	faults         *faultPlan            // Faults injected into the root split journal by tests, nil in production
+	expiry         *BTree                // The expiry index for keys with a ttl, opened on first use
+	name           string                // The file name of the btree, empty for in-memory trees
+	perm           os.FileMode           // The file mode used for files belonging to the btree
+	closed         atomic.Bool           // True once the btree is closed
+	versioned      bool                  // True if every appended value is assigned a version
+	lease          *lease                // The write lease shared with other processes, nil if disabled
+	tombstones     bool                  // True if Delete marks keys with a tombstone instead of removing them
+	generation     atomic.Uint64         // Incremented on every node write so cursors notice modifications
+	codec          Codec                 // The codec used by PutObject and GetObject
+	fillFactor     float64               // Split nodes once their encoded size reaches this fraction of a page, 0 splits at 2T-1 keys
+	seqSplits      bool                  // Split nodes 90/10 while keys are inserted in ascending order
+	hooks          *Hooks                // Callbacks fired as the tree changes shape, nil if disabled
+	stats          treeCounters          // Counters reported by Stats
+	rangeLocks     *rangeLocks           // Key range locks, created on first use
+	rangeLocksOnce sync.Once             // Creates rangeLocks
+	txn            *Txn                  // The active write transaction, nil if there is none
+	keyTransform   KeyTransform          // Applied to every key passed to the btree, nil if disabled
+	valueCompare   func(a, b []byte) int // Orders the values of a key, nil keeps insertion order
+	rightmost      rightmostCache        // The rightmost leaf cached for sequential inserts
+	height         atomic.Int64          // The number of levels of the tree, 0 until Height is called
+	uniqueValues   bool                  // True if Put skips values the key already holds
+	valueHash      ValueHash             // Hashes values for the hash sets of keys with many values, nil compares every value
+	noLocking      bool                  // True if internal locks are disabled, see Options.NoLocking
+	compressor     *nodeCompressor       // Compresses nodes with the dictionary of the btree, nil if nodes are not compressed
+	retention      *RetentionPolicy      // Limits the history of versioned keys, nil keeps every value
+	popLock        sync.Mutex            // Serializes PopMin and PopMax so every key is popped once
+	fixedKeySize   int                   // The size of every key, nodes are written in the fixed key size encoding, 0 if keys may be of any size
+	sequences      *sequences            // The sequences of NextSequence, opened on first use
+	sequencesLock  sync.Mutex            // Guards opening sequences
+	sequenceBatch  int                   // Number of sequence ids reserved at a time, 0 for DefaultSequenceBatch
+	keyVersions    bool                  // True if every change of a key increments its version
+	versionLock    sync.Mutex            // Serializes PutIfVersion
+	latches        *latches              // Page latches of concurrent puts and gets, nil unless Options.ConcurrentWriters is set
+	sepIndex       *sepIndex             // The separator keys kept in memory for point lookups, nil unless Options.SeparatorIndex is set
+	namespaces     *namespaceTable       // The usage of the namespaces of NamespacedTree, opened on first use
+	namespacesLock sync.Mutex            // Guards opening namespaces
+	ops            *opLog                // The operation ids of PutIdempotent, opened on first use
+	opsLock        sync.Mutex            // Guards opening ops
+	opsWindow      int                   // Number of operation ids PutIdempotent remembers, 0 for DefaultIdempotencyWindow
+	heat           *heatmap              // Sampled node reads per page, nil unless Options.Heatmap is set
+	auditor        *auditor              // Delivers audit records to Options.Audit, nil if auditing is disabled
+	subs           subscriptions         // The key ranges watched through Subscribe
+	ingest         ingestSlot            // The spill log of an ingest in progress, see StartIngest
+	commits        *commitCounter        // The operations published for followers, nil unless Options.Followers is set
+	rangeDels      *rangeTombstones      // The key ranges deleted by TombstoneRange, nil if no range was deleted
+	opened         Options               // The options the btree was opened with, see Options
+	strict         bool                  // True if malformed nodes return errors, see Options.Strict
+	verifyPtrs     bool                  // True if traversals check every child pointer, see Options.VerifyChildren
+	nodeCodec      Codec                 // Codec nodes are stored with, nil for msgpack, see Options.NodeCodec
+	nodeCodecName  string                // Name of the codec nodes are stored with, empty for btrees not opened from a file
+	maxDepth       int                   // The deepest a traversal descends, see Options.MaxDepth
+}
+
+// Options are optional settings used when opening a BTree
+type Options struct {
+	ValueLogThreshold int                   // Values of at least this many bytes are stored in a separate value log, 0 disables the value log
+	SafeWrites        bool                  // Publish the pages modified by each Put, Delete and Remove atomically
+	PagerOptions      *PagerOptions         // Options for the underlying pager such as i/o timeouts
+	Versioned         bool                  // Assign every appended value a version, see GetAt and GetLatest
+	LeaseTTL          time.Duration         // Coordinate writers through a lease file, handles without the lease are read-only, 0 disables the lease
+	LeaseOwner        string                // Owner id of the lease, a random id is used if empty
+	TombstoneDeletes  bool                  // Delete marks keys with a tombstone which is purged later by PurgeTombstones or OptimizeLayout
+	Codec             Codec                 // Codec used by PutObject and GetObject, defaults to MsgpackCodec
+	FillFactor        float64               // Split nodes by encoded size once they fill this fraction of a page (i.e. 0.9) instead of at 2T-1 keys, 0 splits by key count
+	Hooks             *Hooks                // Callbacks fired on splits, merges and page allocation for observability
+	ValueComparator   func(a, b []byte) int // Keeps the values of every key sorted, see ValuesBetween, nil keeps values in insertion order
+	KeyTransform      KeyTransform          // Normalizes every key (i.e. LowerCaseKeys), recorded with the btree so it is always opened with the same transform
+	UniqueValues      bool                  // Put and PutWithMeta skip values the key already holds, giving every key set semantics
+	ValueHash         ValueHash             // Checks keys with many values for duplicates through a per-key hash set page (i.e. FNV64aValues), nil compares every value
+	NoLocking         bool                  // Disable internal locking for callers which already serialize every call on the btree, see the README
+	Dictionary        []byte                // Compress nodes with deflate and this preset dictionary (see TrainDictionary), recorded with the btree so it is always opened with it
+	Retention         *RetentionPolicy      // Limits the history of versioned keys read by GetAt and AsOf, nil keeps every value
+	FixedKeySize      int                   // Size every key must have (after the key transform), nodes then store their keys in a dense array, recorded with the btree
+	SequenceBatch     int                   // Number of ids NextSequence reserves at a time, 0 uses DefaultSequenceBatch
+	KeyVersions       bool                  // Track a version per key incremented by every change of the key, see PutIfVersion
+	ConcurrentWriters bool                  // Let Put, PutMulti and Get run concurrently latching the pages they descend through, see the README
+	SeparatorIndex    bool                  // Keep the separator keys of the internal nodes in memory so Get reads a single page
+	Audit             AuditSink             // Receives a record of every Put, PutMulti, Delete and Remove, lossy, see the README
+	AuditTag          string                // Tag of the audit records of this handle, i.e. the name of the service
+	AuditBuffer       int                   // Number of audit records buffered for the sink, 0 uses DefaultAuditBuffer
+	Strict            bool                  // Return ErrNilKeySlot and ErrDanglingChild for malformed nodes instead of working around them, i.e. in tests and CI
+	NodeCodec         string                // Name of the registered codec nodes are stored with (see RegisterCodec), recorded with the btree, empty uses the recorded or default codec
+	MaxDepth          int                   // The deepest a traversal descends before returning ErrMaxDepth, 0 uses DefaultMaxDepth
+	IdempotencyWindow int                   // Number of operation ids PutIdempotent remembers, 0 uses DefaultIdempotencyWindow
+	Heatmap           bool                  // Count sampled node reads per page across restarts, see HotPages and Warmup
+	HeatmapSample     int                   // Number of node reads one of which is counted in the heatmap, 0 uses DefaultHeatmapSample
+	PinInternalNodes  bool                  // Read every internal node once on open and pin its page so the first queries do not wait for the storage
+	SequentialSplits  bool                  // Split nodes 90/10 instead of in half while keys are inserted in ascending order so their pages end up nearly full
+	VerifyChildren    bool                  // Check that every child pointer a traversal follows is within the storage and leads to keys between its separators, returning ErrInconsistentChild otherwise
+	Followers         bool                  // Count the published operations in a .commit file so followers opened with OpenFollower never read an operation half written
 }
 
 // Key is the key struct for the BTree
 type Key struct {
-	K []byte   // The key
-	V [][]byte // The values
+	K   []byte       // The key
+	V   [][]byte     // The values
+	Ptr []bool       `codec:",omitempty"` // Marks values which are pointers into the value log
+	E   int64        `codec:",omitempty"` // Expiry time in unix nanoseconds, 0 if the key does not expire
+	M   []*ValueMeta `codec:",omitempty"` // Metadata of the values, nil for values stored without metadata
+	Ver []uint64     `codec:",omitempty"` // Versions of the values when the btree is versioned, 0 for unversioned values
+	D   bool         `codec:",omitempty"` // Tombstone, true if the key was deleted and waits to be purged
+	H   int64        `codec:",omitempty"` // Page of the hash set of the values, 0 if the key has none, see Options.UniqueValues
+	R   uint64       `codec:",omitempty"` // Version of the key, incremented by every change when Options.KeyVersions is set, see PutIfVersion
+	F   uint64       `codec:",omitempty"` // User flags of the key, see SetFlags
+	B   int64        `codec:",omitempty"` // Page of the bitmap of the integer values of the key, 0 if the key stores byte values, see AddValue
+	W   uint64       `codec:",omitempty"` // Highest version assigned to a value of the key, versions of removed values are never assigned again
 }
 
 // Node is the node struct for the BTree
@@ -64,35 +173,434 @@ type Node struct {
 
 // Open opens a new or existing BTree
 func Open(name string, flag, perm int, t int) (*BTree, error) {
+	return OpenWithOptions(name, flag, perm, t, nil)
+}
+
+// OpenWithOptions opens a new or existing BTree with the provided options
+func OpenWithOptions(name string, flag, perm int, t int, opts *Options) (*BTree, error) {
 	if t < 2 {
 		return nil, errors.New("t must be greater than 1")
 
 	}
 
-	pager, err := OpenPager(name, flag, os.FileMode(perm), time.Millisecond*128)
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.FillFactor < 0 || opts.FillFactor > 1 {
+		return nil, errors.New("fill factor must be between 0 and 1")
+	}
+
+	if opts.ConcurrentWriters && opts.NoLocking {
+		return nil, ErrConcurrentWritersNoLocking
+	}
+
+	// concurrent puts are not published through the commit counter
+	if opts.ConcurrentWriters && opts.Followers {
+		return nil, errors.New("followers are not supported with concurrent writers")
+	}
+
+	var l *lease
+	var err error
+	if opts.LeaseTTL > 0 {
+		l, err = newLease(name+".lease", opts.LeaseOwner, opts.LeaseTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		// another owner holding the lease leaves this handle read-only
+		err = l.acquire()
+		if err != nil && !errors.Is(err, ErrReadOnly) {
+			return nil, err
+		}
+	}
+
+	// the pager fills in its defaults which are reported by Options
+	pagerOpts := &PagerOptions{}
+	if opts.PagerOptions != nil {
+		*pagerOpts = *opts.PagerOptions
+	}
+
+	// a read-only handle leaves the metadata files to the owner of the lease
+	pagerOpts.passive = l != nil && !l.held()
+
+	pager, err := OpenPagerWithOptions(name, flag, os.FileMode(perm), pagerOpts)
+	if err != nil {
+		if l != nil {
+			l.release()
+		}
+		return nil, err
+	}
+
+	var faults *faultPlan
+	if opts.PagerOptions != nil {
+		faults = opts.PagerOptions.faults
+	}
+
+	// a root split interrupted by a crash is finished before the root is read
+	err = recoverRootSplit(name+".split", os.FileMode(perm), pager, faults)
+
+	// a value log compaction interrupted by a crash is finished before its nodes are read, read-only handles leave it to the owner of the lease
+	if err == nil && !pagerOpts.passive {
+		err = recoverValueLogSwap(name+".vlog", os.FileMode(perm), pager)
+	}
+
+	if err != nil {
+		pager.Close()
+		if l != nil {
+			l.release()
+		}
+		return nil, err
+	}
+
+	b := &BTree{
+		T:             t,
+		Pager:         pager,
+		faults:        faults,
+		name:          name,
+		perm:          os.FileMode(perm),
+		versioned:     opts.Versioned,
+		tombstones:    opts.TombstoneDeletes,
+		codec:         opts.Codec,
+		fillFactor:    opts.FillFactor,
+		seqSplits:     opts.SequentialSplits,
+		hooks:         opts.Hooks,
+		valueCompare:  opts.ValueComparator,
+		uniqueValues:  opts.UniqueValues,
+		valueHash:     opts.ValueHash,
+		retention:     opts.Retention,
+		sequenceBatch: opts.SequenceBatch,
+		keyVersions:   opts.KeyVersions,
+		strict:        opts.Strict,
+		verifyPtrs:    opts.VerifyChildren,
+		maxDepth:      opts.MaxDepth,
+		opsWindow:     opts.IdempotencyWindow,
+		opened:        *opts,
+		lease:         l,
+	}
+
+	opened := *pagerOpts
+	opened.passive = false
+	b.opened.PagerOptions = &opened
+
+	// the root may be compressed, the dictionary is loaded before it is read
+	err = b.checkDictionary(opts.Dictionary)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	err = b.checkNodeCodec(opts.NodeCodec)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	err = b.checkFixedKeySize(opts.FixedKeySize)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	err = b.checkKeyTransform(opts.KeyTransform)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	b.rangeDels, err = openRangeTombstones(name + ".rangedel")
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	_, err = os.Stat(name + ".ttl")
+	if err == nil {
+		err = b.openExpiryIndex()
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	if opts.ValueLogThreshold > 0 {
+		b.ValueLog, err = OpenValueLog(name+".vlog", flag, os.FileMode(perm))
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.valueThreshold = opts.ValueLogThreshold
+	}
+
+	if opts.SafeWrites {
+		b.journal, err = openShadowJournal(name+".shadow", os.FileMode(perm), pager, faults)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	if opts.Followers {
+		b.commits, err = openCommitCounter(name+".commit", os.FileMode(perm))
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	if opts.NoLocking {
+		b.disableLocking()
+	}
+
+	if opts.ConcurrentWriters {
+		b.latches = newLatches()
+	}
+
+	err = b.recoverPreparedTxn()
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	if opts.SeparatorIndex {
+		b.sepIndex, err = b.newSepIndex()
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	if opts.Audit != nil {
+		b.auditor = newAuditor(opts.Audit, opts.AuditTag, opts.AuditBuffer)
+	}
+
+	if opts.Heatmap {
+		err = b.openHeatmap(opts.HeatmapSample)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	if opts.PinInternalNodes {
+		_, err = b.pinInternalNodes()
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// OpenMemory opens a new BTree which keeps its pages in memory instead of a file
+func OpenMemory(t int) (*BTree, error) {
+	if t < 2 {
+		return nil, errors.New("t must be greater than 1")
+	}
+
+	pager, err := OpenMemoryPager()
 	if err != nil {
 		return nil, err
 	}
 
+	return OpenWithStorage(pager, t)
+}
+
+// OpenWithStorage opens a BTree on top of the provided page storage
+func OpenWithStorage(storage Storage, t int) (*BTree, error) {
+	if t < 2 {
+		return nil, errors.New("t must be greater than 1")
+	}
+
 	return &BTree{
 		T:     t,
-		Pager: pager,
+		Pager: storage,
 	}, nil
 }
 
+// loadRecordedOptions uses the dictionary, codec, fixed key size and key transform recorded for the btree file name
+// Nothing is recorded, the btree must have been opened with the key transform recorded for name if there is one
+func (b *BTree) loadRecordedOptions(name string) error {
+	own := b.name
+	b.name = name
+	defer func() { b.name = own }()
+
+	err := b.checkDictionary(nil)
+	if err != nil {
+		return err
+	}
+
+	err = b.checkNodeCodec("")
+	if err != nil {
+		return err
+	}
+
+	err = b.checkFixedKeySize(0)
+	if err != nil {
+		return err
+	}
+
+	return b.checkKeyTransform(b.keyTransform)
+}
+
+// SaveTo writes a copy of the BTree to name, the copy can be opened with Open
+// Only supported when the BTree is stored by a Pager
+func (b *BTree) SaveTo(name string) error {
+	pager, ok := b.Pager.(*Pager)
+	if !ok {
+		return errors.New("storage does not support SaveTo")
+	}
+
+	err := pager.SaveTo(name)
+	if err != nil {
+		return err
+	}
+
+	err = writeCopiedFiles(name, b.copiedFiles(), b.perm)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sidecars() {
+		if s.save != nil {
+			err = s.save(b, name+s.suffix)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadFrom replaces the contents of the BTree with the BTree stored in name
+// Only supported when the BTree is stored by a Pager
+func (b *BTree) LoadFrom(name string) error {
+	pager, ok := b.Pager.(*Pager)
+	if !ok {
+		return errors.New("storage does not support LoadFrom")
+	}
+
+	// nodes compressed with another dictionary could not be read
+	dict, err := os.ReadFile(name + ".dict")
+	if err == nil && !bytes.Equal(dict, b.Dictionary()) {
+		return ErrDictionaryMismatch
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = pager.LoadFrom(name)
+	if err != nil {
+		return err
+	}
+
+	// every node was replaced
+	b.replaced()
+
+	_, err = os.Stat(name + ".vlog")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if b.ValueLog == nil {
+		b.ValueLog = openMemoryValueLog()
+	}
+
+	return b.ValueLog.LoadFrom(name + ".vlog")
+}
+
 // Close closes the BTree
+// Every file is closed even if closing another one fails, all errors are returned combined
+// Further operations on the BTree return ErrClosed
 func (b *BTree) Close() error {
-	return b.Pager.Close()
+	if !b.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+
+	var errs []error
+
+	if b.txn != nil {
+		// a prepared transaction stays in its prepare file and is recovered on open
+		if !b.txn.prepared {
+			errs = append(errs, b.txn.Abort())
+		} else if b.txn.journal != nil {
+			errs = append(errs, b.txn.journal.Close())
+		}
+		b.Pager = b.txn.storage
+	}
+
+	// a handle whose lease expired must not write over the files of the new owner
+	if !b.HasLease() {
+		b.handOver()
+	}
+
+	if b.expiry != nil {
+		errs = append(errs, b.expiry.Close())
+	}
+
+	if b.sequences != nil {
+		errs = append(errs, b.sequences.close())
+	}
+
+	if b.namespaces != nil {
+		errs = append(errs, b.namespaces.close())
+	}
+
+	if b.ops != nil {
+		errs = append(errs, b.ops.close())
+	}
+
+	if b.heat != nil && b.HasLease() {
+		errs = append(errs, b.heat.close())
+	}
+
+	// an unfinished ingest continues from its spill log once StartIngest is called again
+	if in := b.ingest.Swap(nil); in != nil {
+		in.lock.Lock()
+		errs = append(errs, in.close())
+		in.lock.Unlock()
+	}
+
+	if b.journal != nil {
+		errs = append(errs, b.journal.Close())
+	}
+
+	if b.commits != nil {
+		errs = append(errs, b.commits.close())
+	}
+
+	if b.ValueLog != nil {
+		errs = append(errs, b.ValueLog.Close())
+	}
+
+	errs = append(errs, b.Pager.Close())
+
+	// the lease is given up once every file of the btree is written
+	if b.lease != nil {
+		errs = append(errs, b.lease.release())
+	}
+
+	// the records of every operation are delivered before Close returns
+	if b.auditor != nil {
+		b.auditor.close()
+	}
+
+	return errors.Join(errs...)
+}
+
+// IsClosed returns true once the BTree is closed
+func (b *BTree) IsClosed() bool {
+	return b.closed.Load()
 }
 
 // encodeNode encodes a node into a byte slice
 func encodeNode(n *Node) ([]byte, error) {
-	// Create a new msgpack handle
-	handle := new(codec.MsgpackHandle)
-
 	var encoded []byte
-	enc := codec.NewEncoderBytes(&encoded, handle)
-	err := enc.Encode(n)
+	enc := codec.NewEncoderBytes(&encoded, msgpackHandle)
+	err := enc.Encode(toNodeRecord(n))
 	if err != nil {
 		return nil, err
 	}
@@ -110,26 +618,19 @@ func (b *BTree) newNode(leaf bool) (*Node, error) {
 	}
 
 	// we encode the new node
-	encodedNode, err := encodeNode(newNode)
+	encodedNode, err := b.encodeRecord(newNode)
 	if err != nil {
 		return nil, err
 
 	}
 
 	// we write the new node to the pager
-	newNode.Page, err = b.Pager.Write(encodedNode)
-	if err != nil {
-		return nil, err
-	}
-
-	encodedNode, err = encodeNode(newNode)
+	newNode.Page, err = b.allocatePage(encodedNode)
 	if err != nil {
 		return nil, err
-
 	}
 
-	// Write updated node
-	err = b.Pager.WriteTo(newNode.Page, encodedNode)
+	err = b.writeNode(newNode)
 	if err != nil {
 		return nil, err
 	}
@@ -140,28 +641,80 @@ func (b *BTree) newNode(leaf bool) (*Node, error) {
 
 // decodeNode decodes a byte slice into a node
 func decodeNode(data []byte) (*Node, error) {
-	// Create a new msgpack handle
-	handle := new(codec.MsgpackHandle)
-
-	var n *Node
+	var n *nodeRecord
 
-	dec := codec.NewDecoderBytes(data, handle)
+	dec := codec.NewDecoderBytes(data, msgpackHandle)
 	err := dec.Decode(&n)
 	if err != nil {
 		return nil, err
 
 	}
 
-	return n, nil
+	return fromNodeRecord(n), nil
+
+}
+
+// readNode reads and decodes the node stored at page
+func (b *BTree) readNode(page int64) (*Node, error) {
+	if b.heat != nil {
+		b.heat.touch(page)
+	}
+
+	data, err := b.Pager.ReadPage(page)
+	if err != nil {
+		if b.strict {
+			return b.strictRead(page, nil, err)
+		}
+		return nil, err
+	}
+
+	n, err := b.decodePage(data)
+	b.markRangeTombstones(n)
+	if b.strict {
+		return b.strictRead(page, n, err)
+	}
+
+	return n, err
+}
+
+// writeNode encodes a node and writes it to its page
+func (b *BTree) writeNode(n *Node) error {
+	return b.writeNodeTo(b.Pager, n)
+}
+
+// writeNodeTo encodes a node and writes it to its page of storage
+func (b *BTree) writeNodeTo(storage Storage, n *Node) error {
+	if b.strict {
+		err := checkKeySlots(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	encodedNode, err := b.encodePage(n)
+	if err != nil {
+		return err
+	}
+
+	if b.rangeDels != nil {
+		b.rangeDels.punch(n)
+	}
+
+	b.generation.Add(1)
+	b.stats.nodeWrites.Add(1)
 
+	return storage.WritePage(n.Page, encodedNode)
 }
 
 // getRoot returns the root of the BTree
 func (b *BTree) getRoot() (*Node, error) {
+	if b.closed.Load() {
+		return nil, ErrClosed
+	}
 
-	root, err := b.Pager.GetPage(0)
+	root, err := b.Pager.ReadPage(0)
 	if err != nil {
-		if err.Error() == "EOF" {
+		if errors.Is(err, io.EOF) {
 			// create root
 			// initial root if a leaf node and starts at page 0
 			rootNode := &Node{
@@ -172,13 +725,19 @@ func (b *BTree) getRoot() (*Node, error) {
 			}
 
 			// encode the root node
-			encodedRoot, err := encodeNode(rootNode)
+			encodedRoot, err := b.encodeRecord(rootNode)
 			if err != nil {
 				return nil, err
 			}
 
+			// the empty root is written through a shadow so page 0 is taken before nodes are allocated
+			storage := b.Pager
+			if shadow, ok := storage.(*shadowStorage); ok {
+				storage = shadow.Storage
+			}
+
 			// write the root to the file
-			err = b.Pager.WriteTo(0, encodedRoot)
+			err = storage.WritePage(0, encodedRoot)
 			if err != nil {
 
 				return nil, err
@@ -192,11 +751,16 @@ func (b *BTree) getRoot() (*Node, error) {
 	}
 
 	// decode the root
-	rootNode, err := decodeNode(root)
+	rootNode, err := b.decodePage(root)
 	if err != nil {
 
 		return nil, err
 	}
+	b.markRangeTombstones(rootNode)
+
+	if b.strict {
+		return b.strictRead(0, rootNode, nil)
+	}
 
 	return rootNode, nil
 }
@@ -225,268 +789,449 @@ func (b *BTree) splitRoot() error {
 		Children: []int64{newOldRoot.Page},
 	}
 
-	// Split new old root and move median key up to new root
-	err = b.splitChild(newRoot, 0, newOldRoot)
-	if err != nil {
-		return err
-	}
-
-	// encoded new root
-	encodedNewRoot, err := encodeNode(newRoot)
-	if err != nil {
-		return err
-
+	// the keys of the root moved to the new old root
+	if b.sepIndex != nil {
+		b.sepIndex.moved(newOldRoot, 0)
 	}
 
-	// Write new root and new old root to file
-	err = b.Pager.WriteTo(newRoot.Page, encodedNewRoot)
+	// Split new old root and move median key up to new root
+	z, median, err := b.divide(newRoot, 0, newOldRoot)
 	if err != nil {
 		return err
 	}
 
-	// encoded new old root
-	encodedNewOldRoot, err := encodeNode(newOldRoot)
+	// the halves go to new pages nothing references until page 0 is replaced, see writeRoot
+	err = b.writeNodes(newOldRoot, z)
 	if err != nil {
 		return err
-
 	}
 
-	err = b.Pager.WriteTo(newOldRoot.Page, encodedNewOldRoot)
+	err = b.writeRoot(newRoot)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	b.splitDone(newRoot, newOldRoot, z, median)
 
-// splitChild splits a child node of x at index i
-func (b *BTree) splitChild(x *Node, i int, y *Node) error {
-	z, err := b.newNode(y.Leaf)
+	err = b.onRootSplit(newRoot, newOldRoot)
 	if err != nil {
 		return err
 	}
 
-	z.Keys = append(z.Keys, y.Keys[b.T:]...)
-	y.Keys = y.Keys[:b.T]
+	return b.grew(newRoot)
+}
 
-	if !y.Leaf {
-		z.Children = append(z.Children, y.Children[b.T:]...)
-		y.Children = y.Children[:b.T]
+// appendToNode appends the values of k to the existing key at index i of x
+func (b *BTree) appendToNode(x *Node, i int, k *Key) error {
+	// an expired or deleted key which was not purged yet starts over
+	if x.Keys[i].hidden() {
+		err := b.dropHashSet(x.Keys[i])
+		if err == nil {
+			err = b.dropBitmap(x.Keys[i])
+		}
+		if err != nil {
+			return err
+		}
+		x.Keys[i] = &Key{K: x.Keys[i].K, V: make([][]byte, 0), R: x.Keys[i].R, W: x.Keys[i].W}
+	} else if x.Keys[i].B != 0 {
+		return ErrValueMode
 	}
 
-	x.Keys = append(x.Keys, nil)
-	x.Children = append(x.Children, 0)
+	b.keyChanged(x.Keys[i])
 
-	for j := len(x.Keys) - 1; j > i; j-- {
-		x.Keys[j] = x.Keys[j-1]
+	for j := range k.V {
+		x.Keys[i].appendValue(k.V[j], j < len(k.Ptr) && k.Ptr[j])
+
+		err := b.valueAppended(x.Keys[i])
+		if err != nil {
+			return err
+		}
 	}
-	x.Keys[i] = y.Keys[b.T-1]
 
-	// remove the key from y
-	y.Keys = y.Keys[:b.T-1]
+	return b.writeNode(x)
+}
 
-	for j := len(x.Children) - 1; j > i+1; j-- {
-		x.Children[j] = x.Children[j-1]
+// isFull returns true if x must be split before inserting into it
+// By default a node is full at 2T-1 keys, with a fill factor a node of at least 3 keys is full once its encoded size reaches the fill factor of a page
+func (b *BTree) isFull(x *Node) (bool, error) {
+	if b.fillFactor <= 0 {
+		return len(x.Keys) >= (2*b.T)-1, nil
 	}
-	x.Children[i+1] = z.Page
 
-	// encode y
-	encodedY, err := encodeNode(y)
-	if err != nil {
-		return err
+	if len(x.Keys) < 3 {
+		return false, nil
 	}
 
-	err = b.Pager.WriteTo(y.Page, encodedY)
+	encoded, err := b.encodeRecord(x)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	return float64(len(encoded)) >= b.fillFactor*PAGE_SIZE, nil
+}
+
+// splitPoint returns the index of the key of y which moves up when y is split
+// By default the middle key, with a fill factor the key splitting the encoded size of y most evenly so neither half is left nearly empty by large values.
+// With sequential splits a node split by an ascending insert keeps 90% of its keys (or bytes) on the left.
+func (b *BTree) splitPoint(y *Node) (int, error) {
+	fraction := 0.5
+	if b.appending(y) {
+		fraction = sequentialSplit
+	}
+
+	if len(y.Keys) < 3 {
+		return len(y.Keys) / 2, nil
+	}
+
+	if b.fillFactor <= 0 {
+		if fraction == 0.5 {
+			return len(y.Keys) / 2, nil
+		}
+
+		// the right half keeps at least one key
+		return min(max(int(float64(len(y.Keys))*fraction), 1), len(y.Keys)-2), nil
+	}
+
+	sizes := make([]int, len(y.Keys))
+	total := 0
+	for j, key := range y.Keys {
+		encoded, err := b.encodeRecord(&Node{Leaf: y.Leaf, Keys: []*Key{key}})
+		if err != nil {
+			return 0, err
+		}
+		sizes[j] = len(encoded)
+		total += sizes[j]
+	}
+
+	// both halves keep at least one key
+	mid := 1
+	best := -1.0
+	left := sizes[0]
+	for j := 1; j < len(y.Keys)-1; j++ {
+		right := total - left - sizes[j]
+		diff := math.Abs(float64(left) - fraction*float64(left+right))
+
+		if best < 0 || diff < best {
+			mid, best = j, diff
+		}
+
+		left += sizes[j]
 	}
 
-	// encode z
-	encodedZ, err := encodeNode(z)
+	return mid, nil
+}
+
+// splitChild splits a child node of x at index i
+// The key of y at its split point moves up to x, a full node of 2T-1 keys is split at key T-1
+func (b *BTree) splitChild(x *Node, i int, y *Node) error {
+	z, median, err := b.divide(x, i, y)
 	if err != nil {
 		return err
 	}
 
-	err = b.Pager.WriteTo(z.Page, encodedZ)
+	err = b.writeNodes(y, z, x)
 	if err != nil {
 		return err
 	}
 
-	// encode x
-	encodedX, err := encodeNode(x)
+	b.splitDone(x, y, z, median)
+
+	return nil
+}
+
+// divide moves the keys of y behind its split point to a new node z and the key at the split point up to x at index i
+// Nothing is written, z is allocated and returned with the median key
+func (b *BTree) divide(x *Node, i int, y *Node) (*Node, *Key, error) {
+	z, err := b.newNode(y.Leaf)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	err = b.Pager.WriteTo(x.Page, encodedX)
+	mid, err := b.splitPoint(y)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	median := y.Keys[mid]
 
-	return nil
+	z.Keys = append(z.Keys, y.Keys[mid+1:]...)
+
+	if !y.Leaf {
+		z.Children = append(z.Children, y.Children[mid+1:]...)
+		y.Children = y.Children[:mid+1]
+	}
+
+	x.Keys = append(x.Keys, nil)
+	x.Children = append(x.Children, 0)
+
+	for j := len(x.Keys) - 1; j > i; j-- {
+		x.Keys[j] = x.Keys[j-1]
+	}
+	x.Keys[i] = median
+
+	// remove the median and the keys moved to z from y
+	y.Keys = y.Keys[:mid]
+
+	for j := len(x.Children) - 1; j > i+1; j-- {
+		x.Children[j] = x.Children[j-1]
+	}
+	x.Children[i+1] = z.Page
+
+	return z, median, nil
+}
+
+// splitDone updates the separator index and fires the split hook once the nodes of a split are written
+func (b *BTree) splitDone(x, y, z *Node, median *Key) {
+	if b.sepIndex != nil {
+		b.sepIndex.split(x, y, z, median.K)
+	}
+
+	b.onSplit(y, z, median.K)
 }
 
 // Put inserts a key into the BTree
 // A key can have multiple values
 // Put inserts a key value pair into the BTree
 func (b *BTree) Put(key, value []byte) error {
+	key = b.transformKey(key)
 
-	root, err := b.getRoot()
-	if err != nil {
-		return err
+	// during an ingest the value is added to the tree by FinishIngest
+	spilled, err := b.spill(key, [][]byte{value})
+	if !spilled {
+		if b.latched() {
+			err = b.putLatched(key, [][]byte{value})
+		} else {
+			err = b.atomic(func() error {
+				return b.put(key, value)
+			})
+		}
 	}
 
-	if len(root.Keys) == (2*b.T)-1 {
+	b.audit(AUDIT_PUT, key, len(value), err)
+	b.changed(AUDIT_PUT, key, len(value), err)
+	return err
+}
 
-		err = b.splitRoot()
-		if err != nil {
-			return err
-		}
+// put inserts a key value pair into the BTree, the value is placed in order if a value comparator is set
+func (b *BTree) put(key, value []byte) error {
+	return b.putValues(key, [][]byte{value})
+}
 
-		rootBytes, err := b.Pager.GetPage(0)
-		if err != nil {
-			return err
+// PutMulti appends several values to a key in a single descent and a single write of the node holding the key
+// It is equivalent to calling Put for every value in order
+func (b *BTree) PutMulti(key []byte, values ...[]byte) error {
+	key = b.transformKey(key)
+
+	spilled, err := b.spill(key, values)
+	if !spilled {
+		if b.latched() {
+			err = b.putLatched(key, values)
+		} else {
+			err = b.atomic(func() error {
+				return b.putValues(key, values)
+			})
 		}
+	}
 
-		root, err = decodeNode(rootBytes)
+	b.audit(AUDIT_PUT, key, valuesSize(values), err)
+	b.changed(AUDIT_PUT, key, valuesSize(values), err)
+	return err
+}
+
+// putValues appends values to a key, the values are placed in order if a value comparator is set
+func (b *BTree) putValues(key []byte, values [][]byte) error {
+	if b.uniqueValues {
+		var err error
+		values, err = b.newValues(key, values)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = b.insertNonFull(root, key, value)
+	if len(values) == 0 {
+		return nil
+	}
+
+	err := b.insertValues(key, values)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return b.placeLatest(key, len(values))
+}
 
+// insert appends a value to a key inserting the key if it does not exist
+func (b *BTree) insert(key, value []byte) error {
+	return b.insertValues(key, [][]byte{value})
 }
 
-// insertNonFull inserts a key into a non-full node
-func (b *BTree) insertNonFull(x *Node, key []byte, value []byte) error {
-	i := len(x.Keys) - 1
+// insertValues appends values to a key inserting the key if it does not exist
+func (b *BTree) insertValues(key []byte, values [][]byte) error {
+	err := b.checkKeySize(key)
+	if err != nil {
+		return err
+	}
 
-	if x.Leaf {
-		for i >= 0 && lessThan(key, x.Keys[i].K) {
-			i--
+	k := &Key{K: key, V: make([][]byte, 0, len(values))}
+
+	// a new key starts at version 1, an existing one is incremented when the values are appended
+	b.keyChanged(k)
+
+	for _, value := range values {
+		b.countPut(key, value)
+
+		value, ptr, err := b.storeValue(value)
+		if err != nil {
+			return err
 		}
 
-		// If key exists, append the value
-		if i >= 0 && equal(key, x.Keys[i].K) {
+		k.appendValue(value, ptr)
+	}
 
-			x.Keys[i].V = append(x.Keys[i].V, value)
+	// ascending keys skip the descent while the rightmost leaf has room
+	inserted, err := b.insertRightmost(k)
+	if err != nil {
+		return err
+	}
 
-			// encode the node
-			encodedNode, err := encodeNode(x)
-			if err != nil {
-				return err
-			}
+	if !inserted {
+		err = b.insertFromRoot(k)
+		if err != nil {
+			return err
+		}
 
-			err = b.Pager.WriteTo(x.Page, encodedNode)
-			if err != nil {
-				return err
-			}
+		err = b.cacheRightmost()
+		if err != nil {
+			return err
+		}
+	}
 
-			return nil
-		} else {
+	if b.versioned {
+		return b.versionLatest(key, len(values))
+	}
 
-			// If key doesn't exist, insert new key and value
-			x.Keys = append(x.Keys, nil)
-			j := len(x.Keys) - 1
-			for j > i+1 {
-				x.Keys[j] = x.Keys[j-1]
-				j--
-			}
+	return nil
 
-			values := make([][]byte, 0)
-			values = append(values, value)
-			x.Keys[j] = &Key{K: key, V: values}
+}
 
-		}
+// insertFromRoot inserts a key descending from the root, splitting full nodes on the way
+func (b *BTree) insertFromRoot(k *Key) error {
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
 
-		// encode the node
-		encodedNode, err := encodeNode(x)
+	full, err := b.isFull(root)
+	if err != nil {
+		return err
+	}
+
+	if full {
+
+		err = b.splitRoot()
 		if err != nil {
 			return err
 		}
 
-		err = b.Pager.WriteTo(x.Page, encodedNode)
+		root, err = b.readNode(0)
 		if err != nil {
 			return err
 		}
+	}
 
-		return nil
+	return b.insertNonFull(root, k)
+}
 
-	} else {
+// insertNonFull inserts a key into a non-full node
+// k holds the values in stored form, it is added to the tree as is if the key does not exist yet
+func (b *BTree) insertNonFull(x *Node, k *Key) error {
+	key := k.K
+
+	for depth := 1; !x.Leaf; depth++ {
+		i := len(x.Keys) - 1
 		for i >= 0 && lessThan(key, x.Keys[i].K) {
 			i--
 		}
+
+		// the key lives in this internal node, append the value here
+		if i >= 0 && equal(key, x.Keys[i].K) {
+			return b.appendToNode(x, i, k)
+		}
+
 		i++
-		childBytes, err := b.Pager.GetPage(x.Children[i])
+		child, err := b.descendChild(x, i, depth)
 		if err != nil {
 			return err
 		}
 
-		child, err := decodeNode(childBytes)
+		full, err := b.isFull(child)
 		if err != nil {
 			return err
 		}
 
-		if len(child.Keys) == (2*b.T)-1 {
+		if full {
 
 			err = b.splitChild(x, i, child)
 			if err != nil {
 				return err
 			}
 
+			// the key may have been promoted by the split
+			if equal(key, x.Keys[i].K) {
+				return b.appendToNode(x, i, k)
+			}
+
 			if greaterThan(key, x.Keys[i].K) {
 				i++
 			}
 
+			child, err = b.readNode(x.Children[i])
+			if err != nil {
+				return err
+			}
 		}
 
-		childBytes, err = b.Pager.GetPage(x.Children[i])
-		if err != nil {
-			return err
-		}
+		x = child
+	}
 
-		child, err = decodeNode(childBytes)
-		if err != nil {
-			return err
-		}
+	i := len(x.Keys) - 1
+	for i >= 0 && lessThan(key, x.Keys[i].K) {
+		i--
+	}
 
-		err = b.insertNonFull(child, key, value)
-		if err != nil {
-			return err
-		}
+	// If key exists, append the value
+	if i >= 0 && equal(key, x.Keys[i].K) {
+		return b.appendToNode(x, i, k)
+	}
 
+	// If key doesn't exist, insert new key and value
+	x.Keys = append(x.Keys, nil)
+	j := len(x.Keys) - 1
+	for j > i+1 {
+		x.Keys[j] = x.Keys[j-1]
+		j--
 	}
-	return nil
+
+	x.Keys[j] = k
+
+	return b.writeNode(x)
 }
 
 // lessThan compares two values and returns true if a is less than b
 func lessThan(a, b []byte) bool {
 
 	return bytes.Compare(a, b) < 0
-
-	return false
 }
 
 // greaterThan compares two values and returns true if a is greater than b
 func greaterThan(a, b []byte) bool {
 
 	return bytes.Compare(a, b) > 0
-
-	return false
 }
 
 // equal compares two values and returns true if a is equal than b
 func equal(a, b []byte) bool {
 
 	return bytes.Equal(a, b)
-
-	return false
 }
 
 // notEq compares two values and returns true if a is not equal to b
@@ -526,12 +1271,7 @@ func (b *BTree) printTree(node *Node, indent string, last bool) error {
 	fmt.Println()
 
 	for i, child := range node.Children {
-		cBytes, err := b.Pager.GetPage(child)
-		if err != nil {
-			return err
-		}
-
-		c, err := decodeNode(cBytes)
+		c, err := b.readNode(child)
 		if err != nil {
 			return err
 		}
@@ -542,404 +1282,590 @@ func (b *BTree) printTree(node *Node, indent string, last bool) error {
 	return nil
 }
 
-// Get returns the values associated with a key
-func (b *BTree) Get(k []byte) (*Key, error) {
+// ToDot writes the tree as a Graphviz DOT graph to w
+// Every node shows its page number, keys, key count and how full its page is
+func (b *BTree) ToDot(w io.Writer) error {
 	root, err := b.getRoot()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return b.searchRecursive(root, k)
-
-}
-
-// searchRecursive searches for a key in the BTree
-func (b *BTree) searchRecursive(x *Node, k []byte) (*Key, error) {
-
-	i := 0
-
-	x.Keys = removeNilFromKeys(x.Keys)
-
-	for i < len(x.Keys) && greaterThan(k, x.Keys[i].K) {
-		i++
+	_, err = fmt.Fprintln(w, "digraph btree {\n\tnode [shape=box];")
+	if err != nil {
+		return err
 	}
 
-	// If the key is found in the node, return true
-	if i < len(x.Keys) && equal(k, x.Keys[i].K) {
-		return x.Keys[i], nil
-	} else if x.Leaf {
-		return nil, nil
-	} else {
-		childBytes, err := b.Pager.GetPage(x.Children[i])
+	err = b.walk(root, func(n *Node) error {
+		encoded, err := encodeNode(n)
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		keys := make([]string, 0, len(n.Keys))
+		for _, key := range n.Keys {
+			if key == nil {
+				continue
+			}
+
+			k := key.K
+			if len(k) > 32 {
+				k = k[:32]
+			}
+			// quoting escapes binary keys and double quotes for the label
+			keys = append(keys, strings.Trim(strconv.Quote(string(k)), "\""))
 		}
 
-		child, err := decodeNode(childBytes)
+		label := fmt.Sprintf("page %d\\n%s\\nkeys %d/%d fill %d%%", n.Page, strings.Join(keys, " "), len(keys), 2*b.T-1, len(encoded)*100/PAGE_SIZE)
+		_, err = fmt.Fprintf(w, "\tn%d [label=\"%s\"];\n", n.Page, label)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		return b.searchRecursive(child, k)
-	}
-}
+		for i, child := range n.Children {
+			_, err = fmt.Fprintf(w, "\tn%d -> n%d [label=\"%d\"];\n", n.Page, child, i)
+			if err != nil {
+				return err
+			}
+		}
 
-// Remove removes a value from key
-func (b *BTree) Remove(key, value []byte) error {
-	root, err := b.getRoot()
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	return b.remove(root, key, value)
-
+	_, err = fmt.Fprintln(w, "}")
+	return err
 }
 
-// remove removes a value from a key
-func (b *BTree) remove(x *Node, key, value []byte) error {
+// Get returns the values associated with a key
+// During an ingest the values in the spill log follow the values in the tree, see StartIngest.
+func (b *BTree) Get(k []byte) (*Key, error) {
+	k = b.transformKey(k)
 
-	i := 0
-	for i < len(x.Keys) && greaterThan(key, x.Keys[i].K) {
-		i++
+	key, err := b.get(k)
+	if in := b.ingest.Load(); in != nil && err == nil {
+		return in.get(k, key)
 	}
 
-	// If the key is found in the node, return true
-	if i < len(x.Keys) && equal(key, x.Keys[i].K) {
-		// remove the value from the key
-
-		for j := 0; j < len(x.Keys[i].V); j++ {
-			if bytes.Equal(x.Keys[i].V[j], value) {
-				x.Keys[i].V = append(x.Keys[i].V[:j], x.Keys[i].V[j+1:]...)
-				break
-			}
-		}
-
-		// if the key has no values, remove the key
-		if len(x.Keys[i].V) == 0 {
-			// @TODO: remove the key from the node
-			return nil
-		}
-
-		// encode the node
-		encodedNode, err := encodeNode(x)
-		if err != nil {
-			return err
-		}
+	return key, err
+}
 
-		err = b.Pager.WriteTo(x.Page, encodedNode)
-		if err != nil {
-			return err
-		}
+// get returns the values associated with a transformed key
+func (b *BTree) get(k []byte) (*Key, error) {
+	if b.latches != nil {
+		return b.getLatched(k)
+	}
 
-		return nil
-	} else if x.Leaf {
-		return errors.New("key not found")
-	} else {
-		childBytes, err := b.Pager.GetPage(x.Children[i])
+	if b.sepIndex != nil {
+		key, ok, err := b.indexedGet(k)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		child, err := decodeNode(childBytes)
-		if err != nil {
-			return err
+		if ok {
+			if key == nil || key.hidden() {
+				return nil, nil
+			}
+			return b.resolveKey(key)
 		}
-
-		return b.remove(child, key, value)
 	}
-}
-
-// Delete deletes a key from the BTree
-func (b *BTree) Delete(k []byte) error {
 
 	root, err := b.getRoot()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = b.deleteRecursive(root, k)
-	if err != nil {
-		return err
+	key, err := b.lookupKey(root, k)
+	if err != nil || key == nil || key.hidden() {
+		return nil, err
 	}
 
-	return nil
+	return b.resolveKey(key)
 }
 
-// deleteRecursive deletes a key from the BTree
-func (b *BTree) deleteRecursive(x *Node, k []byte) error {
+// lookupKey searches the subtree x for a key
+func (b *BTree) lookupKey(x *Node, k []byte) (*Key, error) {
+	var err error
 
-	x.Keys = removeNilFromKeys(x.Keys)
+	for depth := 1; ; depth++ {
+		x.Keys = removeNilFromKeys(x.Keys)
 
-	i := 0
-	for i < len(x.Keys) && greaterThan(k, x.Keys[i].K) {
-		i++
+		i := b.searchKeys(x.Keys, k)
+
+		if i < len(x.Keys) && equal(k, x.Keys[i].K) {
+			return x.Keys[i], nil
+		} else if x.Leaf {
+			return nil, nil
+		}
+
+		x, err = b.descendChild(x, i, depth)
+		if err != nil {
+			return nil, err
+		}
 	}
+}
 
-	if i < len(x.Keys) && equal(k, x.Keys[i].K) {
-		if x.Leaf {
+// Remove removes a value from key, removing the last value of the key deletes the key like Delete
+func (b *BTree) Remove(key, value []byte) error {
+	_, err := b.RemoveCount(key, value)
+	return err
+}
 
-			x.Keys = append(x.Keys[:i], x.Keys[i+1:]...)
+// RemoveCount removes a value from key and returns the number of values removed, 0 if the key does not hold the value
+// ErrKeyNotFound is returned if the key does not exist, a key whose last value is removed is deleted like Delete
+func (b *BTree) RemoveCount(key, value []byte) (int, error) {
+	key = b.transformKey(key)
+
+	removed := 0
+	err := b.atomic(func() error {
+		var err error
+		removed, err = b.removeValue(key, value)
+		return err
+	})
+	if err != nil {
+		removed = 0
+	}
 
-			x.Keys = removeNilFromKeys(x.Keys)
+	b.audit(AUDIT_REMOVE, key, len(value), err)
+	if removed > 0 {
+		b.changed(AUDIT_REMOVE, key, len(value), err)
+	}
+	return removed, err
+}
 
-			// encode the node
-			encodedNode, err := encodeNode(x)
-			if err != nil {
-				return err
-			}
+// removeValue removes a value from key and returns the number of values removed
+func (b *BTree) removeValue(key, value []byte) (int, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	return b.remove(root, key, value)
+
+}
+
+// remove removes a value from a key and returns the number of values removed
+func (b *BTree) remove(x *Node, key, value []byte) (int, error) {
+	x, i, err := b.findNodeForKey(x, key)
+	if err != nil {
+		return 0, err
+	}
+
+	// a deleted or expired key does not exist for readers, its values are left alone
+	if x.Keys[i].hidden() {
+		return 0, ErrKeyNotFound
+	}
+
+	// remove the value from the key
+	removed := 0
+
+	for j := 0; j < len(x.Keys[i].V); j++ {
+		v, err := b.resolveValue(x.Keys[i], j)
+		if err != nil {
+			return 0, err
+		}
+
+		if bytes.Equal(v, value) {
+			b.countRemoved(x.Keys[i], j)
+			x.Keys[i].removeValue(j)
+			removed++
 
-			err = b.Pager.WriteTo(x.Page, encodedNode)
+			err = b.valueRemoved(x.Keys[i], value)
 			if err != nil {
-				return err
+				return 0, err
 			}
+			break
+		}
+	}
 
-			return nil
+	if len(x.Keys[i].V) == 0 {
+		return removed, b.deleteEmptyKey(key)
+	}
+
+	b.keyChanged(x.Keys[i])
+
+	err = b.writeNode(x)
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// deleteEmptyKey deletes a key whose last value was removed, a key without values is never kept in the tree
+// A tombstone is written instead when deletes leave tombstones
+func (b *BTree) deleteEmptyKey(key []byte) error {
+	var err error
+	if b.tombstones {
+		_, err = b.tombstone(key)
+	} else {
+		_, err = b.delete(key)
+	}
+	return err
+}
+
+// Delete deletes a key from the BTree
+func (b *BTree) Delete(k []byte) error {
+	_, err := b.DeleteCount(k)
+	return err
+}
+
+// DeleteCount deletes a key from the BTree and returns the number of keys deleted, 0 if the key did not exist
+func (b *BTree) DeleteCount(k []byte) (int, error) {
+	k = b.transformKey(k)
+	deleted, err := b.deleteKey(k)
+
+	b.audit(AUDIT_DELETE, k, 0, err)
+	if deleted > 0 {
+		b.changed(AUDIT_DELETE, k, 0, err)
+	}
+	return deleted, err
+}
+
+// deleteKey deletes a key which was already transformed and returns the number of keys deleted, see Delete
+func (b *BTree) deleteKey(k []byte) (int, error) {
+	deleted := false
+	err := b.atomic(func() error {
+		var err error
+		if b.tombstones {
+			deleted, err = b.tombstone(k)
 		} else {
-			// x is not a leaf
+			deleted, err = b.delete(k)
+		}
+		return err
+	})
+	if err != nil || !deleted {
+		return 0, err
+	}
 
-			predecessor, err := b.findPredecessor(x, i)
-			if err != nil {
-				return err
-			}
+	return 1, nil
+}
 
-			x.Keys[i] = predecessor
+// delete deletes a key from the BTree and returns true if the key was visible to reads, false if there was nothing to delete
+// Nodes on the way down are refilled to at least T keys by borrowing from or merging with a sibling, so a key can be removed
+// from any node without leaving it with fewer than T-1 keys.  A root left without keys collapses into its only child.
+func (b *BTree) delete(k []byte) (bool, error) {
+	// deletes merge and rotate nodes
+	b.shapeChanged()
 
-			// encode the node
-			encodedNode, err := encodeNode(x)
-			if err != nil {
-				return err
-			}
+	root, err := b.getRoot()
+	if err != nil {
+		return false, err
+	}
 
-			err = b.Pager.WriteTo(x.Page, encodedNode)
-			if err != nil {
-				return err
-			}
+	// nothing is restructured for a key which is not there
+	key, err := b.lookupKey(root, k)
+	if err != nil || key == nil {
+		return false, err
+	}
 
-			childBytes, err := b.Pager.GetPage(x.Children[i])
-			if err != nil {
-				if strings.Contains(err.Error(), "EOF") {
+	// an expired or tombstoned key was already gone for readers
+	visible := !key.hidden()
 
-					return nil
-				}
-				return err
-			}
+	for i := range key.V {
+		b.countRemoved(key, i)
+	}
 
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return err
-			}
+	err = b.dropHashSet(key)
+	if err == nil {
+		err = b.dropBitmap(key)
+	}
+	if err != nil {
+		return false, err
+	}
 
-			if predecessor == nil || child == nil {
-				return nil
-			}
+	err = b.deleteRecursive(root, k)
+	if err != nil {
+		return false, err
+	}
 
-			err = b.deleteRecursive(child, predecessor.K) // delete the predecessor
-			if err != nil {
-				return err
-			}
+	return visible, b.collapseRoot()
+}
+
+// deleteRecursive deletes a key from the subtree x, x holds at least T keys unless it is the root
+// Every step moves one level down, the key may be replaced by its predecessor or successor on the way.
+func (b *BTree) deleteRecursive(x *Node, k []byte) error {
+	var err error
 
-			return nil // return without error if key is found
+	for depth := 1; ; depth++ {
+		err = b.checkDepth(x.Page, depth)
+		if err != nil {
+			return err
 		}
-	} else {
+
+		x.Keys = removeNilFromKeys(x.Keys)
+
+		i := 0
+		for i < len(x.Keys) && greaterThan(k, x.Keys[i].K) {
+			i++
+		}
+
+		found := i < len(x.Keys) && equal(k, x.Keys[i].K)
+
 		if x.Leaf {
-			return nil // return without error if key is not found
-		} else {
+			if !found {
+				return nil // return without error if key is not found
+			}
+
+			x.Keys = append(x.Keys[:i], x.Keys[i+1:]...)
+
+			return b.writeNode(x)
+		}
 
-			childBytes, err := b.Pager.GetPage(x.Children[i])
+		if !found {
+			x, err = b.fillChild(x, i)
 			if err != nil {
-				if strings.Contains(err.Error(), "EOF") {
-					return nil
-				}
 				return err
 			}
+			continue
+		}
+
+		left, err := b.readNode(x.Children[i])
+		if err != nil {
+			return err
+		}
 
-			child, err := decodeNode(childBytes)
+		// the key is replaced by its predecessor or successor which is then deleted from the child holding it
+		if len(left.Keys) >= b.T {
+			predecessor, err := b.findPredecessor(left)
 			if err != nil {
 				return err
 			}
 
-			err = b.deleteRecursive(child, k)
+			x.Keys[i] = predecessor
+
+			err = b.writeNode(x)
 			if err != nil {
 				return err
 			}
 
+			x, k = left, predecessor.K
+			continue
 		}
-	}
 
-	if len(x.Children) > 0 {
-
-		if i+1 < len(x.Children) {
+		right, err := b.readNode(x.Children[i+1])
+		if err != nil {
+			return err
+		}
 
-			childBytes, err := b.Pager.GetPage(x.Children[i])
+		if len(right.Keys) >= b.T {
+			successor, err := b.findSuccessor(right)
 			if err != nil {
-				if strings.Contains(err.Error(), "EOF") {
-					return nil
-				}
 				return err
 			}
 
-			child, err := decodeNode(childBytes)
+			x.Keys[i] = successor
+
+			err = b.writeNode(x)
 			if err != nil {
 				return err
 			}
 
-			if !x.Leaf && len(child.Keys) < b.T-1 {
-
-				err := b.mergeNodes(x, i)
-				if err != nil {
-					return err
-				}
-
-			}
-
-			return nil
-
+			x, k = right, successor.K
+			continue
 		}
 
+		// both children are minimal, the key moves down into their merge
+		x, err = b.mergeNodes(x, i, left, right)
+		if err != nil {
+			return err
+		}
 	}
-
-	return nil
 }
 
-// findPredecessor finds the predecessor of a node
-func (b *BTree) findPredecessor(x *Node, i int) (*Key, error) {
-
-	curBytes, err := b.Pager.GetPage(x.Children[i])
+// fillChild makes sure child i of x holds at least T keys before descending into it and returns it
+// A key is borrowed from a sibling with T keys or more through x, otherwise the child is merged with a sibling
+func (b *BTree) fillChild(x *Node, i int) (*Node, error) {
+	child, err := b.readNode(x.Children[i])
 	if err != nil {
-		if strings.Contains(err.Error(), "EOF") {
-			return nil, nil
-		}
 		return nil, err
 	}
 
-	cur, err := decodeNode(curBytes)
-	if err != nil {
-		return nil, err
+	child.Keys = removeNilFromKeys(child.Keys)
+	if len(child.Keys) >= b.T {
+		return child, nil
 	}
 
-	for !cur.Leaf {
+	var left, right *Node
 
-		curBytes, err = b.Pager.GetPage(cur.Children[len(cur.Children)-1])
+	if i > 0 {
+		left, err = b.readNode(x.Children[i-1])
 		if err != nil {
 			return nil, err
 		}
 
-		cur, err = decodeNode(curBytes)
+		left.Keys = removeNilFromKeys(left.Keys)
+		if len(left.Keys) >= b.T {
+			// the separator moves down in front of the child and the last key of the left sibling takes its place
+			child.Keys = append([]*Key{x.Keys[i-1]}, child.Keys...)
+			x.Keys[i-1] = left.Keys[len(left.Keys)-1]
+			left.Keys = left.Keys[:len(left.Keys)-1]
+
+			if !left.Leaf {
+				child.Children = append([]int64{left.Children[len(left.Children)-1]}, child.Children...)
+				left.Children = left.Children[:len(left.Children)-1]
+			}
+
+			return child, b.writeNodes(left, child, x)
+		}
+	}
+
+	if i < len(x.Children)-1 {
+		right, err = b.readNode(x.Children[i+1])
 		if err != nil {
 			return nil, err
 		}
 
-		if len(cur.Keys) == 0 {
-			return nil, nil
-		}
+		right.Keys = removeNilFromKeys(right.Keys)
+		if len(right.Keys) >= b.T {
+			// the separator moves down behind the child and the first key of the right sibling takes its place
+			child.Keys = append(child.Keys, x.Keys[i])
+			x.Keys[i] = right.Keys[0]
+			right.Keys = right.Keys[1:]
 
-		return cur.Keys[len(cur.Keys)-1], nil
+			if !right.Leaf {
+				child.Children = append(child.Children, right.Children[0])
+				right.Children = right.Children[1:]
+			}
 
+			return child, b.writeNodes(right, child, x)
+		}
 	}
 
-	if len(cur.Keys) == 0 {
-		return nil, nil
+	if right != nil {
+		return b.mergeNodes(x, i, child, right)
 	}
 
-	return cur.Keys[len(cur.Keys)-1], nil
+	return b.mergeNodes(x, i-1, left, child)
 }
 
-// mergeNodes merges two nodes
-func (b *BTree) mergeNodes(x *Node, i int) error {
-
-	if len(x.Children) == i+1 {
-		return nil
+// writeNodes writes several nodes
+func (b *BTree) writeNodes(nodes ...*Node) error {
+	for _, n := range nodes {
+		err := b.writeNode(n)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	child1Bytes, err := b.Pager.GetPage(x.Children[i])
-	if err != nil {
-		if strings.Contains(err.Error(), "EOF") {
-			return nil
+// findPredecessor returns the greatest key of the subtree x
+func (b *BTree) findPredecessor(x *Node) (*Key, error) {
+	var err error
+	for depth := 1; !x.Leaf; depth++ {
+		x, err = b.descendChild(x, len(x.Children)-1, depth)
+		if err != nil {
+			return nil, err
 		}
-		return err
 	}
 
-	child2Bytes, err := b.Pager.GetPage(x.Children[i+1])
-	if err != nil {
-		if strings.Contains(err.Error(), "EOF") {
-			return nil
-		}
-		return err
+	x.Keys = removeNilFromKeys(x.Keys)
+	if len(x.Keys) == 0 {
+		return nil, fmt.Errorf("page %d: empty leaf", x.Page)
 	}
 
-	child1, err := decodeNode(child1Bytes)
-	if err != nil {
-		return err
+	return x.Keys[len(x.Keys)-1], nil
+}
 
+// findSuccessor returns the smallest key of the subtree x
+func (b *BTree) findSuccessor(x *Node) (*Key, error) {
+	var err error
+	for depth := 1; !x.Leaf; depth++ {
+		x, err = b.descendChild(x, 0, depth)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	child2, err := decodeNode(child2Bytes)
-	if err != nil {
-		return err
-
+	x.Keys = removeNilFromKeys(x.Keys)
+	if len(x.Keys) == 0 {
+		return nil, fmt.Errorf("page %d: empty leaf", x.Page)
 	}
 
-	child1.Keys = append(child1.Keys, x.Keys[i])
-	child1.Keys = append(child1.Keys, child2.Keys...)
-	child1.Children = append(child1.Children, child2.Children...)
+	return x.Keys[0], nil
+}
+
+// mergeNodes merges child i+1 of x (right) and the separator key i into child i (left) and returns the merged node
+func (b *BTree) mergeNodes(x *Node, i int, left, right *Node) (*Node, error) {
+	left.Keys = append(left.Keys, x.Keys[i])
+	left.Keys = append(left.Keys, right.Keys...)
+	left.Children = append(left.Children, right.Children...)
+
 	x.Keys = append(x.Keys[:i], x.Keys[i+1:]...)
 	x.Children = append(x.Children[:i+1], x.Children[i+2:]...)
 
-	x.Keys = removeNilFromKeys(x.Keys)
-
-	// encode the node
-	encodedNode, err := encodeNode(x)
+	err := b.writeNodes(x, left)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = b.Pager.WriteTo(x.Page, encodedNode)
+	err = b.freePage(right.Page)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	child1.Keys = removeNilFromKeys(child1.Keys)
+	b.onMerge(left, right.Page)
 
-	// encode the node
-	encodedNode, err = encodeNode(child1)
-	if err != nil {
-		return err
-	}
+	return left, nil
+}
+
+// collapseRoot moves the only child of a root without keys into the root, the tree loses a level
+// The root stays at page 0 and the page of the child is freed
+func (b *BTree) collapseRoot() error {
+	b.shapeChanged()
 
-	err = b.Pager.WriteTo(child1.Page, encodedNode)
+	root, err := b.getRoot()
 	if err != nil {
 		return err
 	}
 
-	child2.Keys = removeNilFromKeys(child2.Keys)
+	for len(removeNilFromKeys(root.Keys)) == 0 && !root.Leaf && len(root.Children) == 1 {
+		child, err := b.readNode(root.Children[0])
+		if err != nil {
+			return err
+		}
+
+		root.Keys, root.Children, root.Leaf = child.Keys, child.Children, child.Leaf
+
+		err = b.writeNode(root)
+		if err != nil {
+			return err
+		}
+
+		err = b.freePage(child.Page)
+		if err != nil {
+			return err
+		}
+
+		return b.shrank(root)
+	}
 
-	return b.Pager.DeletePage(child2.Page)
+	return nil
 }
 
 // findNodeForKey finds the node for a key
 func (b *BTree) findNodeForKey(x *Node, key []byte) (*Node, int, error) {
-	i := 0
-	for i < len(x.Keys) && lessThan(x.Keys[i].K, key) {
-		i++
-	}
+	var err error
 
-	if i < len(x.Keys) && equal(key, x.Keys[i].K) {
-		return x, i, nil
-	} else if !x.Leaf {
-		childBytes, err := b.Pager.GetPage(x.Children[i])
-		if err != nil {
-			return nil, 0, err
+	for depth := 1; ; depth++ {
+		i := 0
+		for i < len(x.Keys) && lessThan(x.Keys[i].K, key) {
+			i++
+		}
+
+		if i < len(x.Keys) && equal(key, x.Keys[i].K) {
+			return x, i, nil
+		} else if x.Leaf {
+			return nil, 0, ErrKeyNotFound
 		}
 
-		child, err := decodeNode(childBytes)
+		x, err = b.descendChild(x, i, depth)
 		if err != nil {
 			return nil, 0, err
 		}
-
-		return b.findNodeForKey(child, key)
 	}
-
-	return nil, 0, errors.New("key not found")
 }
 
 // Iterator returns an iterator for a key
@@ -957,125 +1883,87 @@ func (k *Key) Iterator() func() ([]byte, bool) {
 
 // NRange returns all keys not within the range [start, end]
 func (b *BTree) NRange(start, end []byte) ([]*Key, error) {
+	start, end = b.transformKey(start), b.transformKey(end)
+
 	root, err := b.getRoot()
 	if err != nil {
 		return nil, err
 	}
 
-	return b.nrange(root, start, end)
+	keys, err := b.nrange(root, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resolveKeys(keys)
 }
 
 // nrange returns all keys not within the range [start, end]
 func (b *BTree) nrange(x *Node, start, end []byte) ([]*Key, error) {
 	keys := make([]*Key, 0)
-	if x != nil {
-		for i := 0; i < len(x.Keys); i++ {
-			if !x.Leaf {
-				childBytes, err := b.Pager.GetPage(x.Children[i])
-				if err != nil {
-					return nil, err
-				}
+	err := b.scanOutside(x, &keyBounds{start: start, end: end, includeStart: true, includeEnd: true}, func(k *Key) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
 
-				child, err := decodeNode(childBytes)
-				if err != nil {
-					return nil, err
-				}
+// Range returns all keys in the BTree that are within the range [start, end]
+func (b *BTree) Range(start, end []byte) ([]interface{}, error) {
+	start, end = b.transformKey(start), b.transformKey(end)
 
-				childKeys, err := b.nrange(child, start, end)
-				if err != nil {
-					return nil, err
-				}
-				keys = append(keys, childKeys...)
-			}
-			if lessThan(x.Keys[i].K, start) || greaterThan(x.Keys[i].K, end) {
-				keys = append(keys, x.Keys[i])
-			}
-		}
-		if !x.Leaf {
-			childBytes, err := b.Pager.GetPage(x.Children[len(x.Children)-1])
-			if err != nil {
-				return nil, err
-			}
-
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return nil, err
-			}
-
-			childKeys, err := b.nrange(child, start, end)
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, childKeys...)
-		}
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
 	}
-	return keys, nil
-}
 
-// Range returns all keys in the BTree that are within the range [start, end]
-func (b *BTree) Range(start, end []byte) ([]interface{}, error) {
-	root, err := b.getRoot()
+	keys, err := b.rangeKeys(start, end, root)
 	if err != nil {
 		return nil, err
 	}
 
-	return b.rangeKeys(start, end, root)
+	result := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if key.(*Key).hidden() {
+			continue
+		}
+
+		resolved, err := b.resolveKey(key.(*Key))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resolved)
+	}
+
+	return result, nil
 }
 
 // lessThanEq compares two values and returns true if a is less than or equal to b
 func lessThanEq(a, b []byte) bool {
 	return bytes.Compare(a, b) <= 0
-	return false
-
 }
 
 // rangeKeys returns all keys in the BTree that are within the range [start, end]
 func (b *BTree) rangeKeys(start, end []byte, x *Node) ([]interface{}, error) {
-	keys := make([]interface{}, 0)
-	if x != nil {
-
+	found, err := b.collectInOrder(x, func(n *Node) int {
 		i := 0
-		for i < len(x.Keys) && lessThan(x.Keys[i].K, start) {
-			i++
-		}
-		for i < len(x.Keys) && lessThanEq(x.Keys[i].K, end) {
-			if !x.Leaf {
-				childBytes, err := b.Pager.GetPage(x.Children[i])
-				if err != nil {
-					return nil, err
-				}
-
-				child, err := decodeNode(childBytes)
-				if err != nil {
-					return nil, err
-				}
-
-				childKeys, err := b.rangeKeys(start, end, child)
-				if err != nil {
-					return nil, err
-				}
-				keys = append(keys, childKeys...)
-			}
-			keys = append(keys, x.Keys[i])
+		for i < len(n.Keys) && lessThan(n.Keys[i].K, start) {
 			i++
 		}
-		if !x.Leaf && i < len(x.Children) {
-			childBytes, err := b.Pager.GetPage(x.Children[i])
-			if err != nil {
-				return nil, err
-			}
-
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return nil, err
-			}
+		return i
+	}, func(k *Key) bool {
+		return lessThanEq(k.K, end)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-			childKeys, err := b.rangeKeys(start, end, child)
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, childKeys...)
-		}
+	keys := make([]interface{}, 0, len(found))
+	for _, k := range found {
+		keys = append(keys, k)
 	}
 	return keys, nil
 }
@@ -1093,61 +1981,24 @@ func removeNilFromKeys(keys []*Key) []*Key {
 
 // NGet gets all keys not equal to k
 func (b *BTree) NGet(k []byte) ([]*Key, error) {
+	k = b.transformKey(k)
+
 	root, err := b.getRoot()
 	if err != nil {
 		return nil, err
 	}
 
-	return b.nget(root, k)
+	keys, err := b.nget(root, k)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resolveKeys(keys)
 }
 
 // nget gets all keys not equal to k
 func (b *BTree) nget(x *Node, k []byte) ([]*Key, error) {
-	keys := make([]*Key, 0)
-	if x != nil {
-		i := 0
-		for i < len(x.Keys) {
-			if notEq(x.Keys[i].K, k) {
-				if !x.Leaf {
-					childBytes, err := b.Pager.GetPage(x.Children[i])
-					if err != nil {
-						return nil, err
-					}
-
-					child, err := decodeNode(childBytes)
-					if err != nil {
-						return nil, err
-					}
-
-					childKeys, err := b.nget(child, k)
-					if err != nil {
-						return nil, err
-					}
-					keys = append(keys, childKeys...)
-				}
-				keys = append(keys, x.Keys[i])
-			}
-			i++
-		}
-		if !x.Leaf && i <= len(x.Children) {
-			childBytes, err := b.Pager.GetPage(x.Children[i])
-			if err != nil {
-				return nil, err
-			}
-
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return nil, err
-			}
-
-			childKeys, err := b.nget(child, k)
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, childKeys...)
-		}
-	}
-	return keys, nil
+	return b.nrange(x, k, k)
 }
 
 // InOrderTraversal returns all keys in the BTree in order
@@ -1157,286 +2008,459 @@ func (b *BTree) InOrderTraversal() ([]*Key, error) {
 		return nil, err
 	}
 
-	return b.inOrderTraversal(root)
+	keys, err := b.inOrderTraversal(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resolveKeys(keys)
 }
 
 // inOrderTraversal returns all keys in the BTree in order
 func (b *BTree) inOrderTraversal(x *Node) ([]*Key, error) {
-	keys := make([]*Key, 0)
-	if x != nil {
-		i := 0
-		for i < len(x.Keys) {
-			if !x.Leaf {
-				childBytes, err := b.Pager.GetPage(x.Children[i])
-				if err != nil {
-					return nil, err
-				}
-
-				child, err := decodeNode(childBytes)
-				if err != nil {
-					return nil, err
-				}
-
-				childKeys, err := b.inOrderTraversal(child)
-				if err != nil {
-					return nil, err
-				}
-				keys = append(keys, childKeys...)
-			}
-			keys = append(keys, x.Keys[i])
-			i++
-		}
-		if !x.Leaf && i < len(x.Children) {
-			childBytes, err := b.Pager.GetPage(x.Children[i])
-			if err != nil {
-				return nil, err
-			}
-
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return nil, err
-			}
-
-			childKeys, err := b.inOrderTraversal(child)
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, childKeys...)
-		}
-	}
-	return keys, nil
+	return b.collectInOrder(x, fromFirst, takeAll)
 }
 
 // LessThan returns all keys less than k
 func (b *BTree) LessThan(k []byte) ([]*Key, error) {
+	k = b.transformKey(k)
+
 	root, err := b.getRoot()
 	if err != nil {
 		return nil, err
 	}
 
-	return b.lessThan(root, k)
+	keys, err := b.lessThan(root, k)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resolveKeys(keys)
 }
 
 // lessThan returns all keys less than k
 func (b *BTree) lessThan(x *Node, k []byte) ([]*Key, error) {
-	keys := make([]*Key, 0)
-	if x != nil {
-		i := 0
-		for i < len(x.Keys) && lessThan(x.Keys[i].K, k) {
-			if !x.Leaf {
-				childBytes, err := b.Pager.GetPage(x.Children[i])
-				if err != nil {
-					return nil, err
-				}
+	return b.collectInOrder(x, fromFirst, func(key *Key) bool {
+		return lessThan(key.K, k)
+	})
+}
 
-				child, err := decodeNode(childBytes)
-				if err != nil {
-					return nil, err
-				}
+// GreaterThan returns all keys greater than k
+func (b *BTree) GreaterThan(k []byte) ([]*Key, error) {
+	k = b.transformKey(k)
 
-				childKeys, err := b.lessThan(child, k)
-				if err != nil {
-					return nil, err
-				}
-				keys = append(keys, childKeys...)
-			}
-			keys = append(keys, x.Keys[i])
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := b.greaterThan(root, k)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resolveKeys(keys)
+}
+
+// greaterThan returns all keys greater than k
+func (b *BTree) greaterThan(x *Node, k []byte) ([]*Key, error) {
+	return b.collectInOrder(x, func(n *Node) int {
+		i := 0
+		for i < len(n.Keys) && lessThanEq(n.Keys[i].K, k) {
 			i++
 		}
-		if !x.Leaf && i < len(x.Children) {
-			childBytes, err := b.Pager.GetPage(x.Children[i])
-			if err != nil {
-				return nil, err
-			}
+		return i
+	}, takeAll)
+}
 
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return nil, err
-			}
+// LessThanEq returns all keys less than or equal to k
+func (b *BTree) LessThanEq(k []byte) ([]*Key, error) {
+	k = b.transformKey(k)
 
-			childKeys, err := b.lessThan(child, k)
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, childKeys...)
-		}
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := b.lessThanEq(root, k)
+	if err != nil {
+		return nil, err
 	}
-	return keys, nil
 
+	return b.resolveKeys(keys)
 }
 
-// GreaterThan returns all keys greater than k
-func (b *BTree) GreaterThan(k []byte) ([]*Key, error) {
+// lessThanEq returns all keys less than or equal to k
+func (b *BTree) lessThanEq(x *Node, k []byte) ([]*Key, error) {
+	return b.collectInOrder(x, fromFirst, func(key *Key) bool {
+		return lessThan(key.K, k)
+	})
+}
+
+// GreaterThanEq returns all keys greater than or equal to k
+func (b *BTree) GreaterThanEq(k []byte) ([]*Key, error) {
+	k = b.transformKey(k)
+
 	root, err := b.getRoot()
 	if err != nil {
 		return nil, err
 	}
 
-	return b.greaterThan(root, k)
+	keys, err := b.greaterThanEq(root, k)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resolveKeys(keys)
 }
 
-// greaterThan returns all keys greater than k
-func (b *BTree) greaterThan(x *Node, k []byte) ([]*Key, error) {
-	keys := make([]*Key, 0)
-	if x != nil {
-		i := 0
-		for i < len(x.Keys) && lessThanEq(x.Keys[i].K, k) {
-			i++
+// greaterThanEq returns all keys greater than or equal to k
+func (b *BTree) greaterThanEq(x *Node, k []byte) ([]*Key, error) {
+	return b.collectInOrder(x, fromFirst, func(key *Key) bool {
+		return lessThan(k, key.K)
+	})
+}
+
+// appendValue appends a value to the key, ptr marks the value as a pointer into the value log
+func (k *Key) appendValue(value []byte, ptr bool) {
+	if ptr || len(k.Ptr) > 0 {
+		k.Ptr = append(k.Ptr, make([]bool, len(k.V)-len(k.Ptr))...)
+		k.Ptr = append(k.Ptr, ptr)
+	}
+
+	k.V = append(k.V, value)
+}
+
+// removeValue removes the value at index i from the key
+func (k *Key) removeValue(i int) {
+	k.V = append(k.V[:i], k.V[i+1:]...)
+	if i < len(k.Ptr) {
+		k.Ptr = append(k.Ptr[:i], k.Ptr[i+1:]...)
+	}
+	if i < len(k.M) {
+		k.M = append(k.M[:i], k.M[i+1:]...)
+	}
+	if i < len(k.Ver) {
+		k.Ver = append(k.Ver[:i], k.Ver[i+1:]...)
+	}
+}
+
+// storeValue appends large values to the value log and returns the value to keep in the tree
+// ptr is true if the returned value is a pointer into the value log
+func (b *BTree) storeValue(value []byte) ([]byte, bool, error) {
+	if b.ValueLog == nil || b.valueThreshold <= 0 || len(value) < b.valueThreshold {
+		return value, false, nil
+	}
+
+	offset, err := b.ValueLog.Append(value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return encodeValuePointer(offset, len(value)), true, nil
+}
+
+// resolveValue returns the value at index i of the key reading it from the value log if required
+func (b *BTree) resolveValue(k *Key, i int) ([]byte, error) {
+	if i >= len(k.Ptr) || !k.Ptr[i] {
+		return k.V[i], nil
+	}
+
+	if b.ValueLog == nil {
+		return nil, errors.New("value log is not open")
+	}
+
+	offset, _, err := decodeValuePointer(k.V[i])
+	if err != nil {
+		return nil, err
+	}
+
+	return b.ValueLog.Read(offset)
+}
+
+// resolveKey returns a copy of the key with all value log pointers replaced by their values
+func (b *BTree) resolveKey(k *Key) (*Key, error) {
+	if len(k.Ptr) == 0 {
+		return k, nil
+	}
+
+	resolved := &Key{K: k.K, V: make([][]byte, len(k.V)), E: k.E, M: k.M, Ver: k.Ver, R: k.R, F: k.F, B: k.B, W: k.W}
+	for i := range k.V {
+		v, err := b.resolveValue(k, i)
+		if err != nil {
+			return nil, err
 		}
-		for i < len(x.Keys) {
-			if !x.Leaf {
-				childBytes, err := b.Pager.GetPage(x.Children[i])
-				if err != nil {
-					return nil, err
-				}
+		resolved.V[i] = v
+	}
 
-				child, err := decodeNode(childBytes)
-				if err != nil {
-					return nil, err
-				}
+	return resolved, nil
+}
 
-				childKeys, err := b.greaterThan(child, k)
-				if err != nil {
-					return nil, err
-				}
-				keys = append(keys, childKeys...)
-			}
-			keys = append(keys, x.Keys[i])
-			i++
+// resolveKeys resolves value log pointers for a slice of keys and drops expired and deleted keys
+func (b *BTree) resolveKeys(keys []*Key) ([]*Key, error) {
+	result := make([]*Key, 0, len(keys))
+	for _, key := range keys {
+		if key.hidden() {
+			continue
 		}
-		if !x.Leaf && i < len(x.Children) {
-			childBytes, err := b.Pager.GetPage(x.Children[i])
-			if err != nil {
-				return nil, err
-			}
 
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return nil, err
-			}
+		resolved, err := b.resolveKey(key)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resolved)
+	}
+	return result, nil
+}
 
-			childKeys, err := b.greaterThan(child, k)
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, childKeys...)
+// walk calls fn for every node in the tree starting at x, parents before their children and children left to right
+func (b *BTree) walk(x *Node, fn func(n *Node) error) error {
+	err := fn(x)
+	if err != nil {
+		return err
+	}
+
+	stack := make([]traversalFrame, 1, b.stackSize())
+	stack[0] = traversalFrame{node: x}
+
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+
+		if f.node.Leaf || f.i >= len(f.node.Children) {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		i := f.i
+		f.i++
+
+		child, err := b.descendChild(f.node, i, len(stack))
+		if err != nil {
+			return err
 		}
+
+		err = fn(child)
+		if err != nil {
+			return err
+		}
+
+		stack = append(stack, traversalFrame{node: child})
 	}
-	return keys, nil
 
+	return nil
 }
 
-// LessThanEq returns all keys less than or equal to k
-func (b *BTree) LessThanEq(k []byte) ([]*Key, error) {
-	root, err := b.getRoot()
+// CompactValueLog rewrites the value log keeping only values still referenced by the tree
+// The live values are copied to a new log and the nodes pointing at them are rewritten in memory, the rewritten nodes are journaled
+// before the logs are swapped so a crash leaves the tree pointing at the old log or the swap is finished on open.
+func (b *BTree) CompactValueLog() error {
+	if b.ValueLog == nil {
+		return errors.New("value log is not enabled")
+	}
+
+	if b.txn != nil {
+		return errors.New("value log can not be compacted within a transaction")
+	}
+
+	if _, ok := b.ValueLog.file.(*readOnlyFile); ok {
+		return errors.New("value log is read-only")
+	}
+
+	compacted, shadow, err := b.copyLiveValues()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if compacted.name == "" {
+		err = shadow.apply(b.Pager)
+		if err != nil {
+			return err
+		}
+
+		b.ValueLog = compacted
+		return nil
 	}
 
-	return b.lessThanEq(root, k)
+	return b.published(func() error {
+		return b.swapValueLog(compacted, shadow)
+	})
 }
 
-// lessThanEq returns all keys less than or equal to k
-func (b *BTree) lessThanEq(x *Node, k []byte) ([]*Key, error) {
-	keys := make([]*Key, 0)
-	if x != nil {
-		i := 0
-		for i < len(x.Keys) && lessThan(x.Keys[i].K, k) {
-			if !x.Leaf {
-				childBytes, err := b.Pager.GetPage(x.Children[i])
-				if err != nil {
-					return nil, err
+// copyLiveValues copies every value the tree references to a new value log
+// The nodes pointing at the copies are written to the returned shadow storage, the tree is left untouched.
+func (b *BTree) copyLiveValues() (*ValueLog, *shadowStorage, error) {
+	var compacted *ValueLog
+	var err error
+
+	if b.ValueLog.name == "" {
+		compacted = openMemoryValueLog()
+	} else {
+		compacted, err = OpenValueLog(b.ValueLog.name+".compact", os.O_CREATE|os.O_RDWR|os.O_TRUNC, b.perm)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		compacted.Close()
+		return nil, nil, err
+	}
+
+	storage := b.Pager
+	shadow := newShadowStorage(storage)
+
+	// nodes read below a rewritten node are read back from the shadow storage
+	b.Pager = shadow
+	err = b.walk(root, func(n *Node) error {
+		modified := false
+		for _, key := range n.Keys {
+			if key == nil {
+				continue
+			}
+
+			for i := range key.Ptr {
+				if !key.Ptr[i] {
+					continue
 				}
 
-				child, err := decodeNode(childBytes)
+				v, err := b.resolveValue(key, i)
 				if err != nil {
-					return nil, err
+					return err
 				}
 
-				childKeys, err := b.lessThanEq(child, k)
+				offset, err := compacted.Append(v)
 				if err != nil {
-					return nil, err
+					return err
 				}
-				keys = append(keys, childKeys...)
+
+				key.V[i] = encodeValuePointer(offset, len(v))
+				modified = true
 			}
-			keys = append(keys, x.Keys[i])
-			i++
 		}
-		if !x.Leaf && i < len(x.Children) {
-			childBytes, err := b.Pager.GetPage(x.Children[i])
-			if err != nil {
-				return nil, err
-			}
 
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return nil, err
-			}
+		if !modified {
+			return nil
+		}
 
-			childKeys, err := b.lessThanEq(child, k)
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, childKeys...)
+		return b.writeNode(n)
+	})
+	b.Pager = storage
+
+	// nodes decoded during the walk point at the new log
+	b.replaced()
+
+	if err == nil {
+		err = compacted.Sync()
+	}
+
+	if err != nil {
+		compacted.Close()
+		if compacted.name != "" {
+			os.Remove(compacted.name)
 		}
+		return nil, nil, err
 	}
-	return keys, nil
 
+	return compacted, shadow, nil
 }
 
-// GreaterThanEq returns all keys greater than or equal to k
-func (b *BTree) GreaterThanEq(k []byte) ([]*Key, error) {
-	root, err := b.getRoot()
+// swapValueLog replaces the value log with a compacted one and publishes the nodes pointing into it
+// The nodes are journaled in .vlog.swap first, from then on the compaction is finished by recoverValueLogSwap if the process crashes.
+func (b *BTree) swapValueLog(compacted *ValueLog, shadow *shadowStorage) error {
+	name := b.ValueLog.name
+
+	file, err := openFile(name+".swap", os.O_CREATE|os.O_RDWR|os.O_TRUNC, b.perm)
 	if err != nil {
-		return nil, err
+		compacted.Close()
+		os.Remove(compacted.name)
+		return err
 	}
 
-	return b.greaterThanEq(root, k)
-}
+	journal := &shadowJournal{file: file}
 
-// greaterThanEq returns all keys greater than or equal to k
-func (b *BTree) greaterThanEq(x *Node, k []byte) ([]*Key, error) {
-	keys := make([]*Key, 0)
-	if x != nil {
-		i := 0
-		for i < len(x.Keys) && lessThan(k, x.Keys[i].K) {
-			if !x.Leaf {
-				childBytes, err := b.Pager.GetPage(x.Children[i])
-				if err != nil {
-					return nil, err
-				}
+	err = journal.write(shadow)
+	if err != nil {
+		journal.Close()
+		os.Remove(name + ".swap")
+		compacted.Close()
+		os.Remove(compacted.name)
+		return err
+	}
 
-				child, err := decodeNode(childBytes)
-				if err != nil {
-					return nil, err
-				}
+	// a failure from here on leaves the journal for the next open to finish the swap
+	err = b.ValueLog.Close()
+	if err != nil {
+		journal.Close()
+		return err
+	}
 
-				childKeys, err := b.greaterThanEq(child, k)
-				if err != nil {
-					return nil, err
-				}
-				keys = append(keys, childKeys...)
-			}
-			keys = append(keys, x.Keys[i])
-			i++
+	err = os.Rename(compacted.name, name)
+	if err != nil {
+		journal.Close()
+		return err
+	}
+
+	compacted.name = name
+	b.ValueLog = compacted
+
+	err = shadow.apply(b.Pager)
+	if err != nil {
+		journal.Close()
+		return err
+	}
+
+	err = journal.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(name + ".swap")
+}
+
+// recoverValueLogSwap finishes a value log compaction whose swap was journaled before a crash
+// A compaction which crashed before journaling its swap left the tree pointing at the old log, its new log is removed.
+func recoverValueLogSwap(name string, perm os.FileMode, storage Storage) error {
+	file, err := openFile(name+".swap", os.O_RDWR, perm)
+	if os.IsNotExist(err) {
+		err = os.Remove(name + ".compact")
+		if os.IsNotExist(err) {
+			return nil
 		}
-		if !x.Leaf && i < len(x.Children) {
-			childBytes, err := b.Pager.GetPage(x.Children[i])
-			if err != nil {
-				return nil, err
-			}
+		return err
+	} else if err != nil {
+		return err
+	}
 
-			child, err := decodeNode(childBytes)
-			if err != nil {
-				return nil, err
-			}
+	journal := &shadowJournal{file: file}
 
-			childKeys, err := b.greaterThanEq(child, k)
-			if err != nil {
-				return nil, err
-			}
-			keys = append(keys, childKeys...)
+	s, ok, err := journal.load(storage)
+	if err == nil && ok {
+		// the log may have been renamed before the crash
+		err = os.Rename(name+".compact", name)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		if err == nil {
+			err = s.apply(storage)
+		}
+	} else if err == nil {
+		err = os.Remove(name + ".compact")
+		if os.IsNotExist(err) {
+			err = nil
 		}
 	}
-	return keys, nil
+
+	if err != nil {
+		journal.Close()
+		return err
+	}
+
+	err = journal.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(name + ".swap")
 }
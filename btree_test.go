@@ -31,10 +31,14 @@
 package btree
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestOpen(t *testing.T) {
@@ -62,6 +66,49 @@ func TestOpen(t *testing.T) {
 
 }
 
+func TestBTree_Close_Concurrent(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 3, &Options{ConcurrentWriters: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// puts see the btree closing under them and only one Close succeeds
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			for j := 0; !btree.IsClosed(); j++ {
+				btree.Put([]byte(fmt.Sprintf("%d-%d", i, j)), []byte("value"))
+			}
+			errs <- btree.Close()
+		}(i)
+	}
+
+	time.Sleep(time.Millisecond * 20)
+
+	closed := 0
+	for i := 0; i < 9; i++ {
+		var err error
+		if i == 0 {
+			err = btree.Close()
+		} else {
+			err = <-errs
+		}
+
+		if err == nil {
+			closed++
+		} else if !errors.Is(err, ErrClosed) {
+			t.Fatal(err)
+		}
+	}
+
+	if closed != 1 {
+		t.Fatalf("expected one Close to succeed, got %d", closed)
+	}
+}
+
 func TestBTree_Close(t *testing.T) {
 	defer os.Remove("btree.db")
 	defer os.Remove("btree.db.del")
@@ -76,6 +123,24 @@ func TestBTree_Close(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	if !btree.IsClosed() {
+		t.Fatal("expected btree to be closed")
+	}
+
+	err = btree.Put([]byte("key"), []byte("value"))
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	_, err = btree.Get([]byte("key"))
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	err = btree.Close()
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
 }
 
 func TestBTree_Put(t *testing.T) {
@@ -134,51 +199,507 @@ func TestBTree_Put2(t *testing.T) {
 
 	}
 
-	for i := 0; i < 500; i++ {
-		key, err := btree.Get([]byte(strconv.Itoa(i)))
+	for i := 0; i < 500; i++ {
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected value to be %d, got %s", i, key.V[0])
+		}
+
+		if key == nil {
+			t.Fatal("expected key to be not nil")
+		}
+	}
+}
+
+func TestBTree_Put_ExistingKey(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 200; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// keys living in internal nodes must get the value appended instead of a duplicate key
+	for i := 0; i < 200; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte("b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(key.V) != 2 {
+			t.Fatalf("expected 2 values for key %d, got %d", i, len(key.V))
+		}
+	}
+
+	keys, err := btree.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 200 {
+		t.Fatalf("expected 200 keys, got %d", len(keys))
+	}
+}
+
+func TestBTree_PutMulti(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 200; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// appending to an existing key rewrites only the node holding it once the path has no full nodes to split
+	for i := 0; i < 200; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte("b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		generation := btree.generation.Load()
+
+		err = btree.PutMulti([]byte(strconv.Itoa(i)), []byte("c"), []byte("d"), []byte("e"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if btree.generation.Load() != generation+1 {
+			t.Fatalf("expected a single node write for key %d, got %d", i, btree.generation.Load()-generation)
+		}
+
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(key.V) != 5 || string(key.V[0]) != "a" || string(key.V[4]) != "e" {
+			t.Fatalf("expected a to e for key %d, got %q", i, key.V)
+		}
+	}
+
+	err = btree.PutMulti([]byte("new"), []byte("x"), []byte("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || len(key.V) != 2 || string(key.V[0]) != "x" || string(key.V[1]) != "y" {
+		t.Fatal("expected the new key with both values")
+	}
+
+	// no values leave the tree untouched
+	err = btree.PutMulti([]byte("none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err = btree.Get([]byte("none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected no key to be inserted")
+	}
+
+	checkBTree(t, btree)
+}
+
+func TestBTree_PutMulti_Options(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{
+		Versioned:         true,
+		ValueComparator:   bytes.Compare,
+		UniqueValues:      true,
+		ValueLogThreshold: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.Put([]byte("key"), []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutMulti([]byte("key"), []byte("d"), []byte("b"), []byte("a"), []byte("d"), []byte("c-in-the-value-log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a", "b", "c-in-the-value-log", "d"}
+	if len(key.V) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, key.V)
+	}
+
+	for i, v := range expected {
+		if string(key.V[i]) != v {
+			t.Fatalf("expected %q, got %q", expected, key.V)
+		}
+	}
+
+	// the values are versioned in the order they were passed
+	for version, v := range []string{"b", "d", "a", "c-in-the-value-log"} {
+		value, err := btree.GetAt([]byte("key"), uint64(version+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(value) != v {
+			t.Fatalf("expected %s at version %d, got %s", v, version+1, value)
+		}
+	}
+}
+
+func TestBTree_Put_LargeNodes(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	// nodes larger than a page continue in overflow pages which must not overwrite other nodes
+	value := bytes.Repeat([]byte("v"), 700)
+	for i := 0; i < 300; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 300; i++ {
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || !bytes.Equal(key.V[0], value) {
+			t.Fatalf("expected key %d to be intact", i)
+		}
+	}
+}
+
+func TestBTree_FillFactor(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	_, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{FillFactor: 1.5})
+	if err == nil {
+		t.Fatal("expected an error for a fill factor above 1")
+	}
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{FillFactor: 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 1000; i++ {
+		err := btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := btree.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// small keys fill pages instead of stopping at 2T-1 keys
+	maxKeys := 0
+	err = btree.walk(root, func(n *Node) error {
+		if len(n.Keys) > maxKeys {
+			maxKeys = len(n.Keys)
+		}
+
+		encoded, err := encodeNode(n)
+		if err != nil {
+			return err
+		}
+
+		if len(encoded) > PAGE_SIZE {
+			t.Fatalf("expected node %d to fit in a page, got %d bytes", n.Page, len(encoded))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if maxKeys <= 2*btree.T-1 {
+		t.Fatalf("expected nodes larger than 2T-1 keys, got at most %d", maxKeys)
+	}
+
+	keys, err := btree.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1000 {
+		t.Fatalf("expected 1000 keys, got %d", len(keys))
+	}
+
+	for i, key := range keys {
+		if string(key.K) != fmt.Sprintf("%04d", i) {
+			t.Fatalf("expected key %04d, got %s", i, key.K)
+		}
+	}
+}
+
+func TestBTree_FillFactor_SplitPoint(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{FillFactor: 0.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	// ten small keys followed by a large one
+	n := &Node{Leaf: true}
+	for i := 0; i < 10; i++ {
+		n.Keys = append(n.Keys, &Key{K: []byte(fmt.Sprintf("%02d", i)), V: [][]byte{[]byte("small")}})
+	}
+	n.Keys = append(n.Keys, &Key{K: []byte("10"), V: [][]byte{bytes.Repeat([]byte("v"), 3000)}})
+
+	mid, err := btree.splitPoint(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// splitting in the middle would leave the large value with half of the small keys
+	if mid != 9 {
+		t.Fatalf("expected the split point before the large key at 9, got %d", mid)
+	}
+
+	for i := 0; i < 1000; i++ {
+		size := 8
+		if i%10 == 0 {
+			size = 300
+		}
+
+		err := btree.Put([]byte(fmt.Sprintf("%04d", i)), bytes.Repeat([]byte("v"), size))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := btree.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.walk(root, func(n *Node) error {
+		encoded, err := encodeNode(n)
+		if err != nil {
+			return err
+		}
+
+		if len(encoded) > PAGE_SIZE {
+			t.Fatalf("expected node %d to fit in a page, got %d bytes", n.Page, len(encoded))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := btree.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1000 {
+		t.Fatalf("expected 1000 keys, got %d", len(keys))
+	}
+}
+
+func TestBTree_Delete(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+	}
+
+	for i := 0; i < 500; i++ {
+		err := btree.Delete([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if key != nil {
+			t.Fatalf("expected key to be nil")
+		}
+	}
+}
+
+func TestBTree_Delete_Collapse(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	keys := make([]string, 0)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%04d", (i*7919)%1000)
+		err = b.Put([]byte(key), []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	height, err := b.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if height < 4 {
+		t.Fatalf("expected the tree to grow, got a height of %d", height)
+	}
+
+	for i, key := range keys {
+		err = b.Delete([]byte(key))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if string(key.V[0]) != strconv.Itoa(i) {
-			t.Fatalf("expected value to be %d, got %s", i, key.V[0])
-		}
+		if i%50 == 0 {
+			checkBTree(t, b)
+			checkHeight(t, b)
 
-		if key == nil {
-			t.Fatal("expected key to be not nil")
+			for _, kept := range keys[i+1:] {
+				k, err := b.Get([]byte(kept))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if k == nil {
+					t.Fatalf("expected key %s after deleting %s", kept, key)
+				}
+			}
 		}
 	}
-}
 
-func TestBTree_Delete(t *testing.T) {
-	defer os.Remove("btree.db")
-	defer os.Remove("btree.db.del")
+	// the root collapsed back into an empty leaf
+	if checkHeight(t, b) != 1 {
+		t.Fatal("expected a height of 1")
+	}
 
-	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	root, err := b.getRoot()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer btree.Close()
-
-	for i := 0; i < 500; i++ {
+	if !root.Leaf || len(root.Keys) != 0 {
+		t.Fatal("expected an empty root leaf")
+	}
+}
 
-		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+func TestBTree_Delete_Random(t *testing.T) {
+	for _, order := range []int{2, 3, 5} {
+		b, err := OpenMemory(order)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-	}
+		model := make(map[string]bool)
+		for i := 0; i < 3000; i++ {
+			key := fmt.Sprintf("%04d", (i*7907)%600)
+			if (i/7)%3 == 2 {
+				err = b.Delete([]byte(key))
+				delete(model, key)
+			} else {
+				err = b.Put([]byte(key), []byte(key))
+				model[key] = true
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
 
-	for i := 0; i < 500; i++ {
-		err := btree.Delete([]byte(strconv.Itoa(i)))
+		checkBTree(t, b)
+
+		for i := 0; i < 600; i++ {
+			key := fmt.Sprintf("%04d", i)
+			k, err := b.Get([]byte(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (k != nil) != model[key] {
+				t.Fatalf("order %d: key %s expected %v", order, key, model[key])
+			}
+		}
+
+		err = b.Close()
 		if err != nil {
 			t.Fatal(err)
 		}
-		key, err := btree.Get([]byte(strconv.Itoa(i)))
-		if key != nil {
-			t.Fatalf("expected key to be nil")
-		}
 	}
 }
 
@@ -251,6 +772,178 @@ func TestBTree_Remove(t *testing.T) {
 	}
 }
 
+func TestBTree_Remove_LastValue(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 100; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a key left without values is deleted
+	for i := 0; i < 100; i += 2 {
+		err := btree.Remove([]byte(strconv.Itoa(i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (key == nil) != (i%2 == 0) {
+			t.Fatalf("unexpected presence of key %d", i)
+		}
+	}
+
+	err = btree.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTree_Remove_LastValue_Reopen(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	for _, tombstones := range []bool{false, true} {
+		btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 3, &Options{TombstoneDeletes: tombstones})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			err := btree.PutMulti([]byte(strconv.Itoa(i)), []byte("a"), []byte("b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the key is kept until its last value is removed
+		for i := 0; i < 100; i++ {
+			for _, v := range []string{"a", "b"} {
+				if v == "b" && i%2 == 1 {
+					continue
+				}
+
+				err := btree.Remove([]byte(strconv.Itoa(i)), []byte(v))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		btree, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{TombstoneDeletes: tombstones})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			key, err := btree.Get([]byte(strconv.Itoa(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if i%2 == 0 && key != nil {
+				t.Fatalf("tombstones %v: expected key %d to be deleted with its last value", tombstones, i)
+			}
+
+			if i%2 == 1 && (key == nil || len(key.V) != 1 || string(key.V[0]) != "b") {
+				t.Fatalf("tombstones %v: expected key %d to keep value b, got %v", tombstones, i, key)
+			}
+		}
+
+		err = btree.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.Remove("btree.db.del")
+	}
+}
+
+func TestBTree_RemoveCount_DeleteCount(t *testing.T) {
+	for _, tombstones := range []bool{false, true} {
+		btree, err := OpenMemory(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		btree.tombstones = tombstones
+
+		for i := 0; i < 20; i++ {
+			err := btree.PutMulti([]byte(strconv.Itoa(i)), []byte("a"), []byte("b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		removed, err := btree.RemoveCount([]byte("1"), []byte("a"))
+		if err != nil || removed != 1 {
+			t.Fatalf("expected 1 value removed, got %d, %v", removed, err)
+		}
+
+		removed, err = btree.RemoveCount([]byte("1"), []byte("a"))
+		if err != nil || removed != 0 {
+			t.Fatalf("expected no value removed, got %d, %v", removed, err)
+		}
+
+		// removing the last value deletes the key
+		removed, err = btree.RemoveCount([]byte("1"), []byte("b"))
+		if err != nil || removed != 1 {
+			t.Fatalf("expected the last value removed, got %d, %v", removed, err)
+		}
+
+		// a tombstone does not hold the key either
+		_, err = btree.RemoveCount([]byte("1"), []byte("b"))
+		if err != ErrKeyNotFound {
+			t.Fatalf("tombstones %v: expected ErrKeyNotFound, got %v", tombstones, err)
+		}
+
+		deleted, err := btree.DeleteCount([]byte("2"))
+		if err != nil || deleted != 1 {
+			t.Fatalf("expected 1 key deleted, got %d, %v", deleted, err)
+		}
+
+		deleted, err = btree.DeleteCount([]byte("2"))
+		if err != nil || deleted != 0 {
+			t.Fatalf("expected no key deleted, got %d, %v", deleted, err)
+		}
+
+		deleted, err = btree.DeleteCount([]byte("missing"))
+		if err != nil || deleted != 0 {
+			t.Fatalf("expected no key deleted, got %d, %v", deleted, err)
+		}
+
+		err = btree.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		btree.Close()
+	}
+}
+
 func TestBTree_NGet(t *testing.T) {
 	// NGet gets keys not equal to the key
 	defer os.Remove("btree.db")
@@ -517,3 +1210,167 @@ func TestBTree_LessThanEq(t *testing.T) {
 		}
 	}
 }
+
+func TestOpenMemory(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 500; i++ {
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected value to be %d", i)
+		}
+	}
+}
+
+func TestBTree_SaveTo(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 100; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.SaveTo("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onDisk, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer onDisk.Close()
+
+	for i := 0; i < 100; i++ {
+		key, err := onDisk.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected value to be %d", i)
+		}
+	}
+
+	loaded, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer loaded.Close()
+
+	err = loaded.LoadFrom("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loaded.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 100 {
+		t.Fatalf("expected 100 keys, got %d", len(keys))
+	}
+}
+
+func TestOpenWithStorage(t *testing.T) {
+	pager, err := OpenMemoryPager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var storage Storage = pager
+
+	btree, err := OpenWithStorage(storage, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 100; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := btree.Get([]byte("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.V[0]) != "42" {
+		t.Fatal("expected key 42")
+	}
+
+	err = btree.Pager.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTree_ToDot(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 20; i++ {
+		err := btree.Put([]byte(fmt.Sprintf("%02d", i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = btree.ToDot(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dot := buf.String()
+
+	if !strings.HasPrefix(dot, "digraph btree {") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("expected a digraph, got %s", dot)
+	}
+
+	if !strings.Contains(dot, "n0 -> n") {
+		t.Fatal("expected edges from the root")
+	}
+
+	if !strings.Contains(dot, "page 0") {
+		t.Fatal("expected the root node")
+	}
+}
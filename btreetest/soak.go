@@ -0,0 +1,395 @@
+// Package btreetest
+// soak testing
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btreetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/guycipher/btree"
+)
+
+// Options configure a soak run
+type Options struct {
+	Duration   time.Duration                // How long the workload runs, defaults to 1s
+	Workers    int                          // Goroutines issuing operations, defaults to 4
+	Keys       int                          // Number of distinct keys the workload uses, defaults to 1000
+	Values     int                          // Number of distinct values per key, defaults to 8
+	CheckEvery time.Duration                // Interval between invariant checks, defaults to 100ms
+	Seed       int64                        // Seed of the workload, 0 picks one from the clock, see Result.Seed
+	Open       func() (*btree.BTree, error) // Opens the btree under test, it must be empty, defaults to an in-memory btree of order 3
+}
+
+// Result reports a soak run
+type Result struct {
+	Seed       int64  // Seed the run used, every worker draws its operations from a source seeded from it
+	Operations uint64 // Operations executed
+	Checks     int    // Invariant checks which passed
+	Puts       uint64 // Put and PutMulti calls
+	Deletes    uint64 // Delete calls
+	Removes    uint64 // Remove calls
+	Gets       uint64 // Get calls
+	Ranges     uint64 // Range calls
+}
+
+// soak is the state of a soak run
+// The btree is not thread safe so every operation holds lock, the workers interleave randomly between operations
+type soak struct {
+	opts   *Options
+	tree   *btree.BTree
+	lock   sync.Mutex
+	model  map[string][][]byte // reference model, the values of every key in insertion order
+	result Result
+}
+
+// Soak runs a randomized mix of puts, deletes, removes, gets and ranges against a btree from several goroutines for the configured duration
+// Every result is compared with a reference model and the tree is periodically checked with Check and compared with the model as a whole.
+// The first mismatch stops the run and is returned along with the seed to replay it.
+func Soak(opts *Options) (*Result, error) {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.Duration <= 0 {
+		o.Duration = time.Second
+	}
+
+	if o.Workers < 1 {
+		o.Workers = 4
+	}
+
+	if o.Keys < 1 {
+		o.Keys = 1000
+	}
+
+	if o.Values < 1 {
+		o.Values = 8
+	}
+
+	if o.CheckEvery <= 0 {
+		o.CheckEvery = time.Millisecond * 100
+	}
+
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+
+	if o.Open == nil {
+		o.Open = func() (*btree.BTree, error) {
+			return btree.OpenMemory(3)
+		}
+	}
+
+	tree, err := o.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Close()
+
+	s := &soak{opts: &o, tree: tree, model: make(map[string][][]byte), result: Result{Seed: o.Seed}}
+
+	// the model starts out empty, a tree which does not is caught before the workload can hide it
+	err = s.check()
+	if err != nil {
+		return &s.result, fmt.Errorf("soak failed (seed %d): %w", o.Seed, err)
+	}
+
+	deadline := time.Now().Add(o.Duration)
+	errs := make(chan error, o.Workers+1)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for w := 0; w < o.Workers; w++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+
+			for time.Now().Before(deadline) {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				err := s.step(rng)
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(rand.New(rand.NewSource(o.Seed + int64(w))))
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(o.CheckEvery)
+		defer ticker.Stop()
+
+		for time.Now().Before(deadline) {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			err := s.check()
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	// the first failure stops every goroutine
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+
+	var first error
+	for err := range errs {
+		if first == nil {
+			first = err
+			close(stop)
+		}
+	}
+
+	if first == nil {
+		first = s.check()
+	}
+
+	if first != nil {
+		return &s.result, fmt.Errorf("soak failed (seed %d): %w", o.Seed, first)
+	}
+
+	return &s.result, nil
+}
+
+// key returns the ith key of the workload
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key%06d", i))
+}
+
+// value returns the ith value of the workload
+func value(i int) []byte {
+	return []byte(fmt.Sprintf("value%03d", i))
+}
+
+// step runs a random operation and compares its result with the model
+func (s *soak) step(rng *rand.Rand) error {
+	k := key(rng.Intn(s.opts.Keys))
+	v := value(rng.Intn(s.opts.Values))
+	op := rng.Intn(100)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.result.Operations++
+
+	switch {
+	case op < 35:
+		s.result.Puts++
+
+		err := s.tree.Put(k, v)
+		if err != nil {
+			return fmt.Errorf("put %s: %w", k, err)
+		}
+
+		s.model[string(k)] = append(s.model[string(k)], v)
+	case op < 40:
+		s.result.Puts++
+
+		values := [][]byte{v, value(rng.Intn(s.opts.Values))}
+
+		err := s.tree.PutMulti(k, values...)
+		if err != nil {
+			return fmt.Errorf("put multi %s: %w", k, err)
+		}
+
+		s.model[string(k)] = append(s.model[string(k)], values...)
+	case op < 55:
+		s.result.Deletes++
+
+		err := s.tree.Delete(k)
+		if err != nil {
+			return fmt.Errorf("delete %s: %w", k, err)
+		}
+
+		delete(s.model, string(k))
+	case op < 65:
+		s.result.Removes++
+
+		err := s.tree.Remove(k, v)
+
+		values, ok := s.model[string(k)]
+		if !ok {
+			if !errors.Is(err, btree.ErrKeyNotFound) {
+				return fmt.Errorf("remove %s: expected ErrKeyNotFound, got %v", k, err)
+			}
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("remove %s: %w", k, err)
+		}
+
+		// the first occurrence of the value is removed, a key left without values is deleted
+		for i := range values {
+			if bytes.Equal(values[i], v) {
+				s.model[string(k)] = append(values[:i:i], values[i+1:]...)
+				break
+			}
+		}
+
+		if len(s.model[string(k)]) == 0 {
+			delete(s.model, string(k))
+		}
+	case op < 90:
+		s.result.Gets++
+
+		got, err := s.tree.Get(k)
+		if err != nil {
+			return fmt.Errorf("get %s: %w", k, err)
+		}
+
+		return s.compare(k, got)
+	default:
+		s.result.Ranges++
+
+		end := key(rng.Intn(s.opts.Keys))
+		if bytes.Compare(end, k) < 0 {
+			k, end = end, k
+		}
+
+		got, err := s.tree.Range(k, end)
+		if err != nil {
+			return fmt.Errorf("range %s to %s: %w", k, end, err)
+		}
+
+		expected := s.keysBetween(k, end)
+		if len(got) != len(expected) {
+			return fmt.Errorf("range %s to %s: expected %d keys, got %d", k, end, len(expected), len(got))
+		}
+
+		for i, g := range got {
+			if !bytes.Equal(g.(*btree.Key).K, expected[i]) {
+				return fmt.Errorf("range %s to %s: expected key %s at %d, got %s", k, end, expected[i], i, g.(*btree.Key).K)
+			}
+
+			err = s.compare(expected[i], g.(*btree.Key))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compare compares a key read from the tree with the model, got is nil for a missing key
+func (s *soak) compare(k []byte, got *btree.Key) error {
+	values, ok := s.model[string(k)]
+
+	if !ok {
+		if got != nil {
+			return fmt.Errorf("key %s: expected no key, got %d values", k, len(got.V))
+		}
+		return nil
+	}
+
+	if got == nil {
+		return fmt.Errorf("key %s: expected %d values, got no key", k, len(values))
+	}
+
+	if len(got.V) != len(values) {
+		return fmt.Errorf("key %s: expected %d values, got %d", k, len(values), len(got.V))
+	}
+
+	for i := range values {
+		if !bytes.Equal(got.V[i], values[i]) {
+			return fmt.Errorf("key %s: expected value %s at %d, got %s", k, values[i], i, got.V[i])
+		}
+	}
+
+	return nil
+}
+
+// keysBetween returns the keys of the model within [start, end] in order
+func (s *soak) keysBetween(start, end []byte) [][]byte {
+	keys := make([][]byte, 0)
+	for k := range s.model {
+		if k >= string(start) && k <= string(end) {
+			keys = append(keys, []byte(k))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	return keys
+}
+
+// check checks the invariants of the tree and compares every key with the model
+func (s *soak) check() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.tree.Check()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < s.opts.Keys; i++ {
+		got, err := s.tree.Get(key(i))
+		if err != nil {
+			return fmt.Errorf("get %s: %w", key(i), err)
+		}
+
+		err = s.compare(key(i), got)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.result.Checks++
+
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package btreetest
+// soak testing tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btreetest
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/guycipher/btree"
+)
+
+// go test ./btreetest -soak 10m runs a long soak
+var duration = flag.Duration("soak", time.Millisecond*500, "duration of every soak test")
+
+func TestSoak(t *testing.T) {
+	result, err := Soak(&Options{Duration: *duration, Keys: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Operations == 0 || result.Checks == 0 || result.Puts == 0 || result.Ranges == 0 {
+		t.Fatalf("expected a mixed workload, got %+v", result)
+	}
+}
+
+func TestSoak_File(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+	defer os.Remove("btree.db.shadow")
+
+	_, err := Soak(&Options{
+		Duration: *duration,
+		Workers:  8,
+		Keys:     100,
+		Values:   3,
+		Open: func() (*btree.BTree, error) {
+			return btree.OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 2, &btree.Options{SafeWrites: true, ValueLogThreshold: 8})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSoak_Failure(t *testing.T) {
+	// a tree which is not empty disagrees with the empty model
+	_, err := Soak(&Options{
+		Duration: time.Millisecond * 50,
+		Open: func() (*btree.BTree, error) {
+			tree, err := btree.OpenMemory(3)
+			if err != nil {
+				return nil, err
+			}
+			return tree, tree.Put(key(0), value(0))
+		},
+	})
+	if err == nil {
+		t.Fatal("expected the soak to fail")
+	}
+}
+
+func TestSoak_Tombstones(t *testing.T) {
+	defer os.Remove("tombstones.db")
+	defer os.Remove("tombstones.db.del")
+
+	_, err := Soak(&Options{
+		Duration: *duration,
+		Keys:     200,
+		Open: func() (*btree.BTree, error) {
+			return btree.OpenWithOptions("tombstones.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 3, &btree.Options{TombstoneDeletes: true})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,229 @@
+// Package btree
+// adaptive page cache
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+const cacheHighWater = 0.9 // fraction of the memory limit above which the cache shrinks
+const cacheLowWater = 0.7  // fraction of the memory limit below which the cache grows
+
+// AdaptiveCacheOptions configure how an ObjectPager resizes its page cache with memory pressure
+type AdaptiveCacheOptions struct {
+	MinPages  int           // The cache never shrinks below this many pages, defaults to 1
+	MaxPages  int           // The cache never grows beyond this many pages, defaults to 4 times the cache size the pager was opened with
+	SoftLimit uint64        // Memory in bytes the process should stay under, 0 uses the limit set with debug.SetMemoryLimit
+	Interval  time.Duration // How often memory usage is sampled, defaults to 1s
+}
+
+// CacheStats reports the page cache of an ObjectPager
+type CacheStats struct {
+	Pages             int    // Pages currently cached
+	Capacity          int    // Pages the cache holds before evicting
+	Hits              uint64 // Reads served from the cache
+	Misses            uint64 // Reads which went to the object store
+	Evictions         uint64 // Pages evicted to make room for other pages
+	PressureEvictions uint64 // Pages evicted because the cache shrank under memory pressure
+	Shrinks           uint64 // Times the cache shrank under memory pressure
+	Grows             uint64 // Times the cache grew because memory was free
+}
+
+// cacheCounters are the counters reported by CacheStats, guarded by the pager lock
+type cacheCounters struct {
+	hits, misses, evictions, pressureEvictions, shrinks, grows uint64
+}
+
+// adaptiveCache resizes the page cache of an ObjectPager in the background
+type adaptiveCache struct {
+	min, max int                         // bounds of the cache size
+	usage    func() (used, limit uint64) // samples memory usage and the limit, a limit of 0 disables resizing
+	exit     chan struct{}               // closed to stop the background resizing
+	done     chan struct{}               // closed once the background resizing stopped
+}
+
+// ErrAdaptingCache is returned by AdaptCache if the cache of the pager is already adapting
+var ErrAdaptingCache = errors.New("cache is already adapting")
+
+// AdaptCache resizes the page cache with memory pressure until the pager is closed or StopAdaptingCache is called
+// Memory usage is sampled every interval, the cache is halved once usage exceeds 90% of the limit and grows by a quarter while usage stays below 70%
+func (p *ObjectPager) AdaptCache(opts *AdaptiveCacheOptions) error {
+	if opts == nil {
+		opts = &AdaptiveCacheOptions{}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.adaptive != nil {
+		return ErrAdaptingCache
+	}
+
+	a := &adaptiveCache{min: opts.MinPages, max: opts.MaxPages, exit: make(chan struct{}), done: make(chan struct{})}
+
+	if a.min < 1 {
+		a.min = 1
+	}
+
+	if a.max <= 0 {
+		a.max = p.cacheSize * 4
+	}
+
+	if a.max < a.min {
+		return errors.New("max pages must not be less than min pages")
+	}
+
+	limit := opts.SoftLimit
+	a.usage = func() (uint64, uint64) {
+		return memoryUsage(limit)
+	}
+
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	p.adaptive = a
+
+	go func() {
+		defer close(a.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.lock.Lock()
+				p.adaptCache()
+				p.lock.Unlock()
+			case <-a.exit:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StopAdaptingCache stops resizing the page cache, the cache keeps its current size
+func (p *ObjectPager) StopAdaptingCache() {
+	p.lock.Lock()
+	a := p.adaptive
+	p.adaptive = nil
+	p.lock.Unlock()
+
+	if a == nil {
+		return
+	}
+
+	close(a.exit)
+	<-a.done
+}
+
+// adaptCache resizes the cache once to the memory usage, the lock must be held
+func (p *ObjectPager) adaptCache() {
+	a := p.adaptive
+	if a == nil {
+		return
+	}
+
+	used, limit := a.usage()
+	if limit == 0 {
+		return
+	}
+
+	size := p.cacheSize
+	switch {
+	case float64(used) > float64(limit)*cacheHighWater:
+		size = max(size/2, a.min)
+	case float64(used) < float64(limit)*cacheLowWater:
+		size = min(size+size/4+1, a.max)
+	}
+
+	if size < p.cacheSize {
+		p.cacheSize = size
+		p.counters.shrinks++
+		p.counters.pressureEvictions += uint64(p.evict())
+	} else if size > p.cacheSize {
+		p.cacheSize = size
+		p.counters.grows++
+	}
+}
+
+// CacheStats returns the size of the page cache and its counters
+func (p *ObjectPager) CacheStats() CacheStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return CacheStats{
+		Pages:             len(p.cache),
+		Capacity:          p.cacheSize,
+		Hits:              p.counters.hits,
+		Misses:            p.counters.misses,
+		Evictions:         p.counters.evictions,
+		PressureEvictions: p.counters.pressureEvictions,
+		Shrinks:           p.counters.shrinks,
+		Grows:             p.counters.grows,
+	}
+}
+
+// memoryUsage returns the memory used by the go runtime and the limit it should stay under
+// The limit is softLimit or the limit set with debug.SetMemoryLimit, 0 if neither is set
+func memoryUsage(softLimit uint64) (uint64, uint64) {
+	limit := softLimit
+	if limit == 0 {
+		// a negative input only reads the limit
+		if l := debug.SetMemoryLimit(-1); l != math.MaxInt64 {
+			limit = uint64(l)
+		}
+	}
+
+	if limit == 0 {
+		return 0, 0
+	}
+
+	// the runtime counts the same memory against its own limit
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+
+	total, released := samples[0].Value.Uint64(), samples[1].Value.Uint64()
+
+	return total - released, limit
+}
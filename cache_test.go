@@ -0,0 +1,199 @@
+// Package btree
+// adaptive page cache tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestObjectPager_CacheStats(t *testing.T) {
+	store := &mapObjectStore{objects: make(map[string][]byte)}
+
+	pager, err := OpenObjectPager(store, "tree/", 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	for i := 0; i < 8; i++ {
+		_, err = pager.Allocate([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := pager.CacheStats()
+	if stats.Pages != 4 || stats.Capacity != 4 || stats.Evictions != 4 {
+		t.Fatalf("expected 4 cached pages after 4 evictions, got %+v", stats)
+	}
+
+	// pages 4 to 7 are cached
+	for i := int64(0); i < 8; i++ {
+		_, err = pager.ReadPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats = pager.CacheStats()
+	if stats.Hits != 0 || stats.Misses != 8 {
+		t.Fatalf("expected 8 misses, got %+v", stats)
+	}
+
+	_, err = pager.ReadPage(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats = pager.CacheStats(); stats.Hits != 1 {
+		t.Fatalf("expected a hit, got %+v", stats)
+	}
+}
+
+func TestObjectPager_AdaptCache(t *testing.T) {
+	store := &mapObjectStore{objects: make(map[string][]byte)}
+
+	pager, err := OpenObjectPager(store, "tree/", 16, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	err = pager.AdaptCache(&AdaptiveCacheOptions{MinPages: 8, MaxPages: 4})
+	if err == nil {
+		t.Fatal("expected max pages below min pages to be refused")
+	}
+
+	// the memory usage is driven by the test, the interval never fires
+	err = pager.AdaptCache(&AdaptiveCacheOptions{MinPages: 4, MaxPages: 32, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.AdaptCache(nil)
+	if !errors.Is(err, ErrAdaptingCache) {
+		t.Fatalf("expected ErrAdaptingCache, got %v", err)
+	}
+
+	used := uint64(0)
+	pager.lock.Lock()
+	pager.adaptive.usage = func() (uint64, uint64) {
+		return used, 1000
+	}
+	pager.lock.Unlock()
+
+	adapt := func() {
+		pager.lock.Lock()
+		pager.adaptCache()
+		pager.lock.Unlock()
+	}
+
+	for i := 0; i < 16; i++ {
+		_, err = pager.Allocate([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = pager.Pin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// under pressure the cache is halved down to the minimum, pinned pages stay
+	used = 950
+	adapt()
+	adapt()
+	adapt()
+
+	stats := pager.CacheStats()
+	if stats.Capacity != 4 || stats.Pages != 4 || stats.Shrinks != 2 || stats.PressureEvictions != 12 {
+		t.Fatalf("expected the cache to shrink to 4 pages, got %+v", stats)
+	}
+
+	_, err = pager.ReadPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats = pager.CacheStats(); stats.Misses != 0 {
+		t.Fatal("expected the pinned page to stay cached")
+	}
+
+	// between the water marks the size is kept
+	used = 800
+	adapt()
+
+	if stats = pager.CacheStats(); stats.Capacity != 4 {
+		t.Fatalf("expected the cache to keep its size, got %+v", stats)
+	}
+
+	// free memory grows the cache up to the maximum
+	used = 100
+	for i := 0; i < 20; i++ {
+		adapt()
+	}
+
+	if stats = pager.CacheStats(); stats.Capacity != 32 || stats.Grows == 0 {
+		t.Fatalf("expected the cache to grow to 32 pages, got %+v", stats)
+	}
+
+	pager.StopAdaptingCache()
+
+	if pager.adaptive != nil {
+		t.Fatal("expected the cache to stop adapting")
+	}
+
+	// adapting can start again once stopped
+	err = pager.AdaptCache(&AdaptiveCacheOptions{Interval: time.Millisecond, SoftLimit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// any process uses more than a byte so the cache shrinks to a single page
+	for i := 0; i < 100 && pager.CacheStats().Capacity != 1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if stats = pager.CacheStats(); stats.Capacity != 1 {
+		t.Fatalf("expected the cache to shrink to a page, got %+v", stats)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	used, limit := memoryUsage(1 << 40)
+	if limit != 1<<40 || used == 0 {
+		t.Fatalf("expected the soft limit and the memory in use, got %d of %d", used, limit)
+	}
+}
@@ -0,0 +1,143 @@
+// Package btree
+// structural checks
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorrupt is wrapped by the errors Check returns
+var ErrCorrupt = errors.New("btree is corrupt")
+
+// Check walks the whole tree and returns the first violated invariant, nil if the tree is sound
+// Keys are ordered within every node and lie between the separators above them, every node other than the root holds
+// T-1 to 2T-1 keys (any number of keys but at least one with a fill factor, at least one and at most 2T-1 with sequential splits), internal nodes have one child more than keys,
+// every leaf is at the same depth, no page is referenced twice and a known height matches the tree.
+func (b *BTree) Check() error {
+	if b.closed.Load() {
+		return ErrClosed
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	leafDepth := -1
+	seen := map[int64]bool{root.Page: true}
+
+	var check func(n *Node, depth int, low, high []byte) error
+	check = func(n *Node, depth int, low, high []byte) error {
+		if n != root {
+			if b.fillFactor <= 0 && ((!b.seqSplits && len(n.Keys) < b.T-1) || len(n.Keys) > 2*b.T-1) {
+				return fmt.Errorf("%w: page %d holds %d keys", ErrCorrupt, n.Page, len(n.Keys))
+			}
+
+			if len(n.Keys) == 0 {
+				return fmt.Errorf("%w: page %d holds no keys", ErrCorrupt, n.Page)
+			}
+		}
+
+		for i, key := range n.Keys {
+			if key == nil {
+				return fmt.Errorf("%w: page %d holds a nil key", ErrCorrupt, n.Page)
+			}
+
+			if (low != nil && !lessThan(low, key.K)) || (high != nil && !lessThan(key.K, high)) {
+				return fmt.Errorf("%w: page %d: key %q lies outside of its parent's separators", ErrCorrupt, n.Page, key.K)
+			}
+
+			if i > 0 && !lessThan(n.Keys[i-1].K, key.K) {
+				return fmt.Errorf("%w: page %d: key %q out of order", ErrCorrupt, n.Page, key.K)
+			}
+		}
+
+		if n.Leaf {
+			if len(n.Children) != 0 {
+				return fmt.Errorf("%w: leaf page %d has children", ErrCorrupt, n.Page)
+			}
+
+			if leafDepth == -1 {
+				leafDepth = depth
+			}
+
+			if depth != leafDepth {
+				return fmt.Errorf("%w: leaf page %d at depth %d, expected %d", ErrCorrupt, n.Page, depth, leafDepth)
+			}
+
+			return nil
+		}
+
+		if len(n.Children) != len(n.Keys)+1 {
+			return fmt.Errorf("%w: page %d has %d children for %d keys", ErrCorrupt, n.Page, len(n.Children), len(n.Keys))
+		}
+
+		for i, c := range n.Children {
+			if seen[c] {
+				return fmt.Errorf("%w: page %d is referenced twice", ErrCorrupt, c)
+			}
+			seen[c] = true
+
+			child, err := b.readNode(c)
+			if err != nil {
+				return err
+			}
+
+			childLow, childHigh := low, high
+			if i > 0 {
+				childLow = n.Keys[i-1].K
+			}
+			if i < len(n.Keys) {
+				childHigh = n.Keys[i].K
+			}
+
+			err = check(child, depth+1, childLow, childHigh)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err = check(root, 0, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if height := b.height.Load(); height != 0 && height != int64(leafDepth+1) {
+		return fmt.Errorf("%w: height is %d, the tree has %d levels", ErrCorrupt, height, leafDepth+1)
+	}
+
+	return nil
+}
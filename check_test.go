@@ -0,0 +1,133 @@
+// Package btree
+// structural checks tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBTree_Check(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	err = b.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 500; i += 3 {
+		err = b.Delete([]byte(fmt.Sprintf("%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = b.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a height which does not match the tree
+	b.height.Add(1)
+
+	err = b.Check()
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt for a wrong height, got %v", err)
+	}
+
+	b.height.Add(-1)
+
+	root, err := b.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys out of order in a child of the root
+	child, err := b.readNode(root.Children[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child.Keys[0], child.Keys[1] = child.Keys[1], child.Keys[0]
+
+	err = b.writeNode(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Check()
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt for keys out of order, got %v", err)
+	}
+
+	child.Keys[0], child.Keys[1] = child.Keys[1], child.Keys[0]
+
+	err = b.writeNode(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a page referenced twice
+	root.Children[1] = root.Children[0]
+
+	err = b.writeNode(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Check()
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt for a page referenced twice, got %v", err)
+	}
+}
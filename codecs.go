@@ -0,0 +1,221 @@
+// Package btree
+// codec registry
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// NODE_CODEC_LENGTH_SIZE is the size of the length in front of a node encoded with a codec other than the default
+// Its first byte is 0 for nodes below 16MiB so it can not be mistaken for the marker of a compressed or fixed key node.
+const NODE_CODEC_LENGTH_SIZE = 4
+
+// DefaultNodeCodec is the name of the codec nodes are stored with when Options.NodeCodec is not set
+const DefaultNodeCodec = "msgpack"
+
+// ErrUnknownCodec is returned for a codec name which was not registered, see RegisterCodec
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// ErrCodecMismatch is returned when opening a btree with a different node codec than the one it was written with
+var ErrCodecMismatch = errors.New("node codec does not match the btree")
+
+// msgpackHandle is shared by every msgpack encoder and decoder of the package
+// A handle is safe for concurrent use once configured, it is never modified after initialization.
+var msgpackHandle = new(codec.MsgpackHandle)
+
+// codecRegistry holds the codecs nodes can be stored with by name
+var codecRegistry = struct {
+	lock   sync.RWMutex
+	codecs map[string]Codec
+}{codecs: map[string]Codec{
+	DefaultNodeCodec: MsgpackCodec{},
+	"json":           JSONCodec{},
+}}
+
+// RegisterCodec registers a codec under name so btrees can store their nodes with it, i.e. a cbor or protobuf codec
+// The codec encodes node records, structs of exported fields.  Pages hold the encoded record behind its length so the codec never sees the padding of a page.
+// Codecs are registered before opening the btrees using them, a name can not be registered twice.
+func RegisterCodec(name string, c Codec) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("invalid codec name %q", name)
+	}
+
+	if c == nil {
+		return errors.New("codec must not be nil")
+	}
+
+	codecRegistry.lock.Lock()
+	defer codecRegistry.lock.Unlock()
+
+	if _, ok := codecRegistry.codecs[name]; ok {
+		return fmt.Errorf("codec %q is already registered", name)
+	}
+
+	codecRegistry.codecs[name] = c
+	return nil
+}
+
+// LookupCodec returns the codec registered under name
+func LookupCodec(name string) (Codec, bool) {
+	codecRegistry.lock.RLock()
+	defer codecRegistry.lock.RUnlock()
+
+	c, ok := codecRegistry.codecs[name]
+	return c, ok
+}
+
+// checkNodeCodec selects the codec nodes are stored with, it is recorded in name.codec unless it is the default
+// A btree which already holds keys keeps the codec it was written with, the recorded codec is used if name is empty.
+func (b *BTree) checkNodeCodec(name string) error {
+	recorded, err := os.ReadFile(b.name + ".codec")
+	if err == nil {
+		r := strings.TrimSpace(string(recorded))
+		if name != "" && name != r {
+			return ErrCodecMismatch
+		}
+		return b.useNodeCodec(r)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if name == "" || name == DefaultNodeCodec {
+		return b.useNodeCodec(DefaultNodeCodec)
+	}
+
+	err = b.useNodeCodec(name)
+	if err != nil {
+		return err
+	}
+
+	// nodes already written are msgpack, only an empty root is rewritten
+	data, err := b.Pager.ReadPage(0)
+	if err == nil {
+		var root *Node
+		root, err = decodeNode(data)
+		if err != nil {
+			return err
+		}
+
+		if !root.Leaf || len(root.Keys) > 0 {
+			return ErrCodecMismatch
+		}
+
+		err = b.writeNode(root)
+	} else if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(b.name+".codec", []byte(name+"\n"), b.perm)
+}
+
+// useNodeCodec looks up the codec nodes are stored with, the default codec encodes nodes directly
+func (b *BTree) useNodeCodec(name string) error {
+	c, ok := LookupCodec(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCodec, name)
+	}
+
+	b.nodeCodecName = name
+	if name != DefaultNodeCodec {
+		b.nodeCodec = c
+	}
+
+	return nil
+}
+
+// NodeCodec returns the name of the codec nodes are stored with
+func (b *BTree) NodeCodec() string {
+	if b.nodeCodecName == "" {
+		return DefaultNodeCodec
+	}
+	return b.nodeCodecName
+}
+
+// encodeRecord encodes a node with the node codec of the btree
+func (b *BTree) encodeRecord(n *Node) ([]byte, error) {
+	if b.nodeCodec == nil {
+		return encodeNode(n)
+	}
+
+	encoded, err := b.nodeCodec.Marshal(toNodeRecord(n))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encoded) >= 1<<24 {
+		return nil, fmt.Errorf("codec %s: encoded node of %d bytes is too large", b.nodeCodecName, len(encoded))
+	}
+
+	framed := make([]byte, NODE_CODEC_LENGTH_SIZE, NODE_CODEC_LENGTH_SIZE+len(encoded))
+	binary.BigEndian.PutUint32(framed, uint32(len(encoded)))
+
+	return append(framed, encoded...), nil
+}
+
+// decodeRecord decodes a node encoded by encodeRecord
+func (b *BTree) decodeRecord(data []byte) (*Node, error) {
+	if b.nodeCodec == nil {
+		return decodeNode(data)
+	}
+
+	if len(data) < NODE_CODEC_LENGTH_SIZE {
+		return nil, errors.New("invalid node encoding")
+	}
+
+	length := int(binary.BigEndian.Uint32(data))
+	if length > len(data)-NODE_CODEC_LENGTH_SIZE {
+		return nil, fmt.Errorf("codec %s: node of %d bytes is cut short", b.nodeCodecName, length)
+	}
+
+	var r *nodeRecord
+	err := b.nodeCodec.Unmarshal(data[NODE_CODEC_LENGTH_SIZE:NODE_CODEC_LENGTH_SIZE+length], &r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return nil, errors.New("invalid node encoding")
+	}
+
+	return fromNodeRecord(r), nil
+}
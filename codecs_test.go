@@ -0,0 +1,305 @@
+// Package btree
+// codec registry tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// prefixedCodec stores msgpack behind a prefix so its pages can be told apart
+type prefixedCodec struct {
+	prefix byte
+}
+
+func (c prefixedCodec) Marshal(v interface{}) ([]byte, error) {
+	encoded, err := MsgpackCodec{}.Marshal(v)
+	return append([]byte{c.prefix}, encoded...), err
+}
+
+func (c prefixedCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 || data[0] != c.prefix {
+		return errors.New("missing prefix")
+	}
+	return MsgpackCodec{}.Unmarshal(data[1:], v)
+}
+
+func TestRegisterCodec(t *testing.T) {
+	err := RegisterCodec("prefixed", prefixedCodec{prefix: 'p'})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RegisterCodec("prefixed", prefixedCodec{prefix: 'p'})
+	if err == nil {
+		t.Fatal("expected an error registering a name twice")
+	}
+
+	err = RegisterCodec("bad name", JSONCodec{})
+	if err == nil {
+		t.Fatal("expected an error for an invalid name")
+	}
+
+	c, ok := LookupCodec("prefixed")
+	if !ok || c != (prefixedCodec{prefix: 'p'}) {
+		t.Fatalf("expected the registered codec, got %v", c)
+	}
+
+	_, ok = LookupCodec("missing")
+	if ok {
+		t.Fatal("expected no codec")
+	}
+
+	// output starting like the node markers is stored behind its length
+	err = RegisterCodec("clashing", prefixedCodec{prefix: fixedKeyNode})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	err = btree.useNodeCodec("clashing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTree_NodeCodec(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.codec")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{NodeCodec: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := btree.Pager.ReadPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(data[NODE_CODEC_LENGTH_SIZE:], []byte("{")) {
+		t.Fatalf("expected a json root, got %q", data[:NODE_CODEC_LENGTH_SIZE+1])
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := os.ReadFile("btree.db.codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(recorded) != "json\n" {
+		t.Fatalf("expected the codec to be recorded, got %q", recorded)
+	}
+
+	// the recorded codec is used when none is given
+	btree, err = Open("btree.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if btree.NodeCodec() != "json" {
+		t.Fatalf("expected the json codec, got %s", btree.NodeCodec())
+	}
+
+	for i := 0; i < 100; i++ {
+		key, err := btree.Get([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != "value" {
+			t.Fatalf("expected key %03d", i)
+		}
+	}
+
+	err = btree.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nodes behind their length are not taken for damaged pages
+	pager, err := OpenPager("btree.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirror, err := OpenPager("btree.db", os.O_RDONLY, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repaired, err := pager.RepairFrom(mirror)
+	if err != nil || len(repaired) != 0 {
+		t.Fatalf("expected no damaged pages, got %v, %v", repaired, err)
+	}
+
+	mirror.Close()
+	pager.Close()
+
+	_, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{NodeCodec: "msgpack"})
+	if !errors.Is(err, ErrCodecMismatch) {
+		t.Fatalf("expected ErrCodecMismatch, got %v", err)
+	}
+
+	os.Remove("btree.db")
+	os.Remove("btree.db.del")
+	os.Remove("btree.db.codec")
+
+	// a btree written with msgpack keeps it
+	btree, err = Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{NodeCodec: "json"})
+	if !errors.Is(err, ErrCodecMismatch) {
+		t.Fatalf("expected ErrCodecMismatch, got %v", err)
+	}
+
+	_, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{NodeCodec: "missing"})
+	if !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("expected ErrUnknownCodec, got %v", err)
+	}
+}
+
+func TestBTree_NodeCodec_SaveTo(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("codec*.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	for _, codec := range []string{"msgpack", "json"} {
+		btree, err := OpenWithOptions("codec.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 3, &Options{NodeCodec: codec})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = btree.SaveTo("codec_copy.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the copy is read with the codec of the btree it was saved from
+		copied, err := Open("codec_copy.db", os.O_RDWR, 0644, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if copied.NodeCodec() != codec {
+			t.Fatalf("expected the %s codec, got %s", codec, copied.NodeCodec())
+		}
+
+		for i := 0; i < 100; i++ {
+			key, err := copied.Get([]byte(fmt.Sprintf("%03d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key == nil || string(key.V[0]) != "value" {
+				t.Fatalf("%s: expected key %03d", codec, i)
+			}
+		}
+
+		err = copied.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, _ := filepath.Glob("codec*.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}
+}
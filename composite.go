@@ -0,0 +1,258 @@
+// Package btree
+// composite keys
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Column type tags, columns of different types order by their tag
+const (
+	columnBool   byte = 0x02
+	columnInt    byte = 0x03
+	columnUint   byte = 0x04
+	columnFloat  byte = 0x05
+	columnBytes  byte = 0x06
+	columnString byte = 0x07
+)
+
+// CompositeKey encodes columns into a key which orders column by column
+// Supported columns are bool, signed and unsigned integers, float32, float64, string and []byte
+// Integers compare by value regardless of their size, strings and byte slices compare bytewise
+func CompositeKey(columns ...interface{}) ([]byte, error) {
+	key := make([]byte, 0, len(columns)*9)
+
+	for _, column := range columns {
+		var err error
+		key, err = appendColumn(key, column)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// appendColumn appends the order preserving encoding of a column
+func appendColumn(key []byte, column interface{}) ([]byte, error) {
+	switch c := column.(type) {
+	case bool:
+		if c {
+			return append(key, columnBool, 1), nil
+		}
+		return append(key, columnBool, 0), nil
+	case int:
+		return appendInt(key, int64(c)), nil
+	case int8:
+		return appendInt(key, int64(c)), nil
+	case int16:
+		return appendInt(key, int64(c)), nil
+	case int32:
+		return appendInt(key, int64(c)), nil
+	case int64:
+		return appendInt(key, c), nil
+	case uint:
+		return appendUint(key, uint64(c)), nil
+	case uint8:
+		return appendUint(key, uint64(c)), nil
+	case uint16:
+		return appendUint(key, uint64(c)), nil
+	case uint32:
+		return appendUint(key, uint64(c)), nil
+	case uint64:
+		return appendUint(key, c), nil
+	case float32:
+		return appendFloat(key, float64(c)), nil
+	case float64:
+		return appendFloat(key, c), nil
+	case string:
+		return appendEscaped(append(key, columnString), []byte(c)), nil
+	case []byte:
+		return appendEscaped(append(key, columnBytes), c), nil
+	default:
+		return nil, fmt.Errorf("unsupported column type %T", column)
+	}
+}
+
+// appendInt appends a signed integer with its sign bit flipped so negative values order first
+func appendInt(key []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(append(key, columnInt), uint64(v)^(1<<63))
+}
+
+// appendUint appends an unsigned integer
+func appendUint(key []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(append(key, columnUint), v)
+}
+
+// appendFloat appends a float, negative values have every bit flipped so they order in reverse
+func appendFloat(key []byte, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(append(key, columnFloat), bits)
+}
+
+// appendEscaped appends a string with 0x00 escaped as 0x00 0xff and terminated by 0x00 0x01
+// The terminator orders shorter values first and keeps a column from running into the next one
+func appendEscaped(key []byte, v []byte) []byte {
+	for _, c := range v {
+		if c == 0x00 {
+			key = append(key, 0x00, 0xff)
+			continue
+		}
+		key = append(key, c)
+	}
+	return append(key, 0x00, 0x01)
+}
+
+// DecodeCompositeKey decodes a key built by CompositeKey
+// Signed integers are returned as int64, unsigned integers as uint64 and floats as float64
+func DecodeCompositeKey(key []byte) ([]interface{}, error) {
+	columns := make([]interface{}, 0)
+
+	for len(key) > 0 {
+		tag := key[0]
+		key = key[1:]
+
+		switch tag {
+		case columnBool:
+			if len(key) < 1 {
+				return nil, errors.New("truncated bool column")
+			}
+			columns = append(columns, key[0] == 1)
+			key = key[1:]
+		case columnInt, columnUint, columnFloat:
+			if len(key) < 8 {
+				return nil, errors.New("truncated numeric column")
+			}
+			bits := binary.BigEndian.Uint64(key)
+			key = key[8:]
+
+			switch tag {
+			case columnInt:
+				columns = append(columns, int64(bits^(1<<63)))
+			case columnUint:
+				columns = append(columns, bits)
+			default:
+				if bits&(1<<63) != 0 {
+					bits &^= 1 << 63
+				} else {
+					bits = ^bits
+				}
+				columns = append(columns, math.Float64frombits(bits))
+			}
+		case columnBytes, columnString:
+			v, rest, err := decodeEscaped(key)
+			if err != nil {
+				return nil, err
+			}
+			key = rest
+
+			if tag == columnString {
+				columns = append(columns, string(v))
+			} else {
+				columns = append(columns, v)
+			}
+		default:
+			return nil, fmt.Errorf("unknown column tag %#x", tag)
+		}
+	}
+
+	return columns, nil
+}
+
+// decodeEscaped decodes a value appended by appendEscaped and returns the rest of the key
+func decodeEscaped(key []byte) ([]byte, []byte, error) {
+	v := make([]byte, 0)
+	for i := 0; i+1 < len(key); i++ {
+		if key[i] != 0x00 {
+			v = append(v, key[i])
+			continue
+		}
+
+		switch key[i+1] {
+		case 0xff:
+			v = append(v, 0x00)
+			i++
+		case 0x01:
+			return v, key[i+2:], nil
+		default:
+			return nil, nil, errors.New("invalid escape in column")
+		}
+	}
+	return nil, nil, errors.New("unterminated column")
+}
+
+// CompareComposite compares the composite keys built from two lists of columns
+// It returns -1, 0 or 1 like bytes.Compare, which orders the encoded keys the same way
+func CompareComposite(a, b []interface{}) (int, error) {
+	x, err := CompositeKey(a...)
+	if err != nil {
+		return 0, err
+	}
+
+	y, err := CompositeKey(b...)
+	if err != nil {
+		return 0, err
+	}
+
+	return bytes.Compare(x, y), nil
+}
+
+// RangePrefix returns the composite keys whose leading columns equal columns in key order
+func (b *BTree) RangePrefix(columns ...interface{}) ([]*Key, error) {
+	prefix, err := CompositeKey(columns...)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = b.transformKey(prefix)
+	keys := make([]*Key, 0)
+
+	cursor := b.Cursor()
+	key, err := cursor.seek(prefix)
+	for ; err == nil && key != nil && bytes.HasPrefix(key.K, prefix); key, err = cursor.Next() {
+		keys = append(keys, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,188 @@
+// Package btree
+// composite keys tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestCompositeKey_Order(t *testing.T) {
+	// each column list is greater than the previous one
+	ordered := [][]interface{}{
+		{false},
+		{true},
+		{int64(math.MinInt64)},
+		{-1000, "b"},
+		{-1, "a"},
+		{0, ""},
+		{0, "a"},
+		{0, "a\x00"},
+		{0, "a\x00b"},
+		{0, "ab"},
+		{0, "b"},
+		{1, "a", 2},
+		{1, "a", 10},
+		{int8(2), "a"},
+		{uint(0)},
+		{uint64(math.MaxUint64)},
+		{math.Inf(-1)},
+		{-2.5},
+		{-0.5},
+		{0.0},
+		{0.5},
+		{math.Inf(1)},
+		{[]byte{}},
+		{[]byte{0x00}},
+		{[]byte{0x01}},
+		{"a"},
+	}
+
+	var previous []byte
+	for i, columns := range ordered {
+		key, err := CompositeKey(columns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i > 0 && bytes.Compare(previous, key) >= 0 {
+			t.Fatalf("expected %v to order after %v", columns, ordered[i-1])
+		}
+		previous = key
+
+		cmp, err := CompareComposite(columns, columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cmp != 0 {
+			t.Fatalf("expected %v to equal itself", columns)
+		}
+	}
+}
+
+func TestCompositeKey_Decode(t *testing.T) {
+	key, err := CompositeKey(true, -42, uint32(7), 1.5, "a\x00b", []byte{0x00, 0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns, err := DecodeCompositeKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []interface{}{true, int64(-42), uint64(7), 1.5, "a\x00b", []byte{0x00, 0x01}}
+	if !reflect.DeepEqual(columns, expect) {
+		t.Fatalf("expected %v, got %v", expect, columns)
+	}
+
+	_, err = DecodeCompositeKey(key[:len(key)-1])
+	if err == nil {
+		t.Fatal("expected an error decoding a truncated key")
+	}
+
+	_, err = CompositeKey(struct{}{})
+	if err == nil {
+		t.Fatal("expected an error for an unsupported column")
+	}
+}
+
+func TestBTree_RangePrefix(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	// an index on (country, city, id)
+	for _, country := range []string{"ca", "us", "usa"} {
+		for _, city := range []string{"a", "b"} {
+			for id := 0; id < 10; id++ {
+				key, err := CompositeKey(country, city, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = btree.Put(key, []byte(country+city+strconv.Itoa(id)))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	keys, err := btree.RangePrefix("us")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "usa" is not part of the "us" prefix
+	if len(keys) != 20 {
+		t.Fatalf("expected 20 keys, got %d", len(keys))
+	}
+
+	keys, err = btree.RangePrefix("us", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 10 {
+		t.Fatalf("expected 10 keys, got %d", len(keys))
+	}
+
+	ids := make([]int, 0)
+	for _, key := range keys {
+		columns, err := DecodeCompositeKey(key.K)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(columns[2].(int64)))
+	}
+
+	if !sort.IntsAreSorted(ids) || ids[0] != 0 || ids[9] != 9 {
+		t.Fatalf("expected ids 0 to 9 in order, got %v", ids)
+	}
+
+	keys, err = btree.RangePrefix("mx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 0 {
+		t.Fatalf("expected no keys, got %d", len(keys))
+	}
+}
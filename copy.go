@@ -0,0 +1,130 @@
+// Package btree
+// copying a btree
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+)
+
+// CopyOptions are the settings used by CopyTo
+type CopyOptions struct {
+	T       int      // Order of the copy, 0 keeps the order of this btree
+	Options *Options // Options the copy is opened with, nil keeps the options of this btree
+}
+
+// CopyTo streams every key of the btree into a new btree file at path, opened with possibly different options
+// The copy can use another order, dictionary, key transform or value comparator, values are placed by the comparator of the copy and keys are
+// transformed by its key transform.  Values, metadata, versions and expiry times are kept, deleted and expired keys are not copied.
+// The page layout is fixed by this version, see MigrateFile for files written with another layout.
+func (b *BTree) CopyTo(path string, opts *CopyOptions) error {
+	if b.closed.Load() {
+		return ErrClosed
+	}
+
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+
+	t := opts.T
+	if t == 0 {
+		t = b.T
+	}
+
+	options := opts.Options
+	if options == nil {
+		options = b.copyOptions()
+	}
+
+	_, err := os.Stat(path)
+	if err == nil {
+		return errors.New("copy target already exists")
+	}
+
+	perm := b.perm
+	if perm == 0 {
+		perm = 0644
+	}
+
+	target, err := OpenWithOptions(path, os.O_CREATE|os.O_RDWR, int(perm), t, options)
+	if err != nil {
+		return err
+	}
+
+	root, err := b.getRoot()
+	if err == nil {
+		err = b.walk(root, func(n *Node) error {
+			for _, k := range n.Keys {
+				if k == nil || k.hidden() {
+					continue
+				}
+
+				// the copy shares the values of k, only the key is transformed
+				copied := *k
+				copied.K = target.transformKey(k.K)
+
+				err := target.rollForwardKey(b, &copied)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return errors.Join(err, target.Close())
+}
+
+// copyOptions returns the options a copy of this btree is opened with when CopyTo is given none
+func (b *BTree) copyOptions() *Options {
+	return &Options{
+		ValueLogThreshold: b.valueThreshold,
+		Versioned:         b.versioned,
+		TombstoneDeletes:  b.tombstones,
+		Codec:             b.codec,
+		FillFactor:        b.fillFactor,
+		SequentialSplits:  b.seqSplits,
+		Hooks:             b.hooks,
+		ValueComparator:   b.valueCompare,
+		KeyTransform:      b.keyTransform,
+		UniqueValues:      b.uniqueValues,
+		ValueHash:         b.valueHash,
+		Dictionary:        b.Dictionary(),
+		Retention:         b.retention,
+		FixedKeySize:      b.fixedKeySize,
+		SequenceBatch:     b.sequenceBatch,
+		KeyVersions:       b.keyVersions,
+		NodeCodec:         b.NodeCodec(),
+		MaxDepth:          b.maxDepth,
+		IdempotencyWindow: b.opsWindow,
+	}
+}
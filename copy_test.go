@@ -0,0 +1,201 @@
+// Package btree
+// copying a btree tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBTree_CopyTo(t *testing.T) {
+	for _, name := range []string{"btree.db", "btree.db.del", "btree.db.ttl", "btree.db.ttl.del", "copy.db", "copy.db.del", "copy.db.ttl", "copy.db.ttl.del", "copy.db.dict", "copy.db.transform", "copy.db.height"} {
+		defer os.Remove(name)
+	}
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 300; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("Key%03d", i)), []byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.PutWithMeta([]byte("Key010"), []byte("a"), ValueMeta{Flags: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Delete([]byte("Key020"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutWithTTL([]byte("Expires"), []byte("value"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dict, err := btree.TrainDictionary(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reverse := func(a, b []byte) int { return bytes.Compare(b, a) }
+
+	err = btree.CopyTo("copy.db", &CopyOptions{T: 8, Options: &Options{Dictionary: dict, KeyTransform: LowerCaseKeys, ValueComparator: reverse}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.CopyTo("copy.db", nil)
+	if err == nil {
+		t.Fatal("expected an existing target to be refused")
+	}
+
+	copied, err := OpenWithOptions("copy.db", os.O_RDWR, 0644, 8, &Options{Dictionary: dict, KeyTransform: LowerCaseKeys, ValueComparator: reverse})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer copied.Close()
+
+	checkBTree(t, copied)
+
+	for i := 0; i < 300; i++ {
+		key, err := copied.Get([]byte(fmt.Sprintf("KEY%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 20 {
+			if key != nil {
+				t.Fatal("expected the deleted key not to be copied")
+			}
+			continue
+		}
+
+		if key == nil || string(key.V[0]) != fmt.Sprintf("value%d", i) && i != 10 {
+			t.Fatalf("expected key %03d to be copied", i)
+		}
+	}
+
+	key, err := copied.GetWithMeta([]byte("key010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the copy orders values by its own comparator
+	if len(key.V) != 2 || string(key.V[0]) != "value10" || string(key.V[1]) != "a" || key.M[1] == nil || key.M[1].Flags != 7 {
+		t.Fatalf("expected the values and metadata of key010 to be copied, got %q", key.V)
+	}
+
+	key, err = copied.Get([]byte("expires"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || key.E == 0 {
+		t.Fatal("expected the expiry time to be copied")
+	}
+
+	height, err := btree.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copiedHeight, err := copied.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if copiedHeight >= height {
+		t.Fatalf("expected the copy to use its own order, got height %d for height %d", copiedHeight, height)
+	}
+}
+
+func TestBTree_CopyTo_Defaults(t *testing.T) {
+	for _, name := range []string{"btree.db", "btree.db.del", "btree.db.transform", "copy.db", "copy.db.del", "copy.db.transform", "copy.db.height"} {
+		defer os.Remove(name)
+	}
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{KeyTransform: LowerCaseKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 50; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("KEY%02d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.CopyTo("copy.db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the copy records the key transform of this btree
+	_, err = Open("copy.db", os.O_RDWR, 0644, 3)
+	if err != ErrKeyTransformMismatch {
+		t.Fatalf("expected ErrKeyTransformMismatch, got %v", err)
+	}
+
+	copied, err := OpenWithOptions("copy.db", os.O_RDWR, 0644, 3, &Options{KeyTransform: LowerCaseKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer copied.Close()
+
+	for i := 0; i < 50; i++ {
+		key, err := copied.Get([]byte(fmt.Sprintf("Key%02d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil {
+			t.Fatalf("expected key %02d to be copied", i)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package btree
+// cursor
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+// Cursor iterates the keys of a tree in order
+//
+// A cursor never holds on to pages between calls.  It remembers the last key it returned and the write generation of the tree,
+// if the tree was modified since the last call (i.e. a split, merge, delete or layout optimization moved keys) it seeks again from the root.
+// Writes made during iteration therefore have well defined effects:
+//   - keys are always returned in ascending order and never twice
+//   - keys inserted after the cursor position are returned, keys inserted before it are not
+//   - keys deleted or expired before the cursor reaches them are skipped
+//   - values appended to a key after it was returned are not seen
+type Cursor struct {
+	b          *BTree
+	last       []byte     // last key returned, nil before the first call
+	started    bool       // true once the cursor was positioned
+	buf        []*Key     // remaining keys of the current leaf
+	generation uint64     // write generation of the tree when buf was filled
+	exclude    *keyBounds // keys skipped by the cursor, nil unless it was returned by NGetCursor or NRangeCursor
+}
+
+// Cursor returns a cursor positioned before the first key
+func (b *BTree) Cursor() *Cursor {
+	return &Cursor{b: b}
+}
+
+// NGetCursor returns a cursor positioned before the first key, iterating every key not equal to k like NGet
+func (b *BTree) NGetCursor(k []byte) *Cursor {
+	return b.NRangeCursor(k, k)
+}
+
+// NRangeCursor returns a cursor positioned before the first key, iterating every key not within the range [start, end] like NRange
+// Reaching the range the cursor seeks past its end instead of visiting the keys within it.
+func (b *BTree) NRangeCursor(start, end []byte) *Cursor {
+	start, end = b.transformKey(start), b.transformKey(end)
+	return &Cursor{b: b, exclude: &keyBounds{start: start, end: end, includeStart: true, includeEnd: true}}
+}
+
+// First positions the cursor at the first key and returns it, nil if the tree is empty
+func (c *Cursor) First() (*Key, error) {
+	c.last, c.started, c.buf = nil, false, nil
+	return c.Next()
+}
+
+// Seek positions the cursor at the first key greater than or equal to k and returns it, nil if there is none
+func (c *Cursor) Seek(k []byte) (*Key, error) {
+	return c.seek(c.b.transformKey(k))
+}
+
+// seek positions the cursor at the first key greater than or equal to a key which was already transformed
+func (c *Cursor) seek(k []byte) (*Key, error) {
+	c.buf = nil
+	c.started = true
+	c.last = k
+	return c.next(true)
+}
+
+// Next advances the cursor and returns the next key, nil once the cursor is past the last key
+func (c *Cursor) Next() (*Key, error) {
+	return c.next(false)
+}
+
+// next returns the next visible key, inclusive also returns a key equal to the current position
+func (c *Cursor) next(inclusive bool) (*Key, error) {
+	for {
+		if c.generation != c.b.generation.Load() {
+			// the tree changed under us, the buffered keys may be stale
+			c.buf = nil
+		}
+
+		if len(c.buf) == 0 {
+			err := c.fill(inclusive)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(c.buf) == 0 {
+				return nil, nil
+			}
+		}
+
+		key := c.buf[0]
+		c.buf = c.buf[1:]
+		c.last = key.K
+		c.started = true
+		inclusive = false
+
+		if c.excluded(key.K) {
+			c.skipExcluded()
+			continue
+		}
+
+		if key.hidden() {
+			continue
+		}
+
+		return c.b.resolveKey(key)
+	}
+}
+
+// fill seeks from the root to the successor of the last key and buffers it with the rest of its leaf
+func (c *Cursor) fill(inclusive bool) error {
+	root, err := c.b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	c.generation = c.b.generation.Load()
+	c.buf = nil
+
+	var successor *Key
+	x := root
+	for depth := 1; ; depth++ {
+		x.Keys = removeNilFromKeys(x.Keys)
+
+		i := 0
+		for i < len(x.Keys) && c.started && !c.after(x.Keys[i].K, inclusive) {
+			i++
+		}
+
+		if x.Leaf {
+			c.buf = append(c.buf, x.Keys[i:]...)
+			break
+		}
+
+		if i < len(x.Keys) {
+			// the separator follows every key of child i
+			successor = x.Keys[i]
+		}
+
+		x, err = c.b.descendChild(x, i, depth)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the leaf is exhausted, continue with the nearest separator above it
+	if len(c.buf) == 0 && successor != nil {
+		c.buf = append(c.buf, successor)
+	}
+
+	return nil
+}
+
+// excluded returns true if the cursor skips k
+func (c *Cursor) excluded(k []byte) bool {
+	return c.exclude != nil && c.exclude.afterStart(k) && c.exclude.beforeEnd(k)
+}
+
+// skipExcluded moves the cursor past the end of the excluded range, the buffered keys within it are dropped
+// The next key is read from the buffer if it holds keys beyond the range, otherwise the cursor seeks from the end of the range.
+func (c *Cursor) skipExcluded() {
+	for len(c.buf) > 0 && c.excluded(c.buf[0].K) {
+		c.buf = c.buf[1:]
+	}
+
+	if len(c.buf) == 0 {
+		c.last = c.exclude.end
+	}
+}
+
+// after returns true if k comes after the cursor position
+func (c *Cursor) after(k []byte, inclusive bool) bool {
+	if inclusive {
+		return !lessThan(k, c.last)
+	}
+	return greaterThan(k, c.last)
+}
@@ -0,0 +1,247 @@
+// Package btree
+// cursor tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBTree_Cursor(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := btree.Cursor()
+	i := 0
+	for key, err := c.First(); key != nil || err != nil; key, err = c.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(key.K) != fmt.Sprintf("%04d", i) {
+			t.Fatalf("expected key %04d, got %s", i, key.K)
+		}
+		i++
+	}
+
+	if i != 500 {
+		t.Fatalf("expected 500 keys, got %d", i)
+	}
+
+	key, err := c.Seek([]byte("0250"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.K) != "0250" {
+		t.Fatal("expected seek to position at 0250")
+	}
+
+	key, err = c.Seek([]byte("0250x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.K) != "0251" {
+		t.Fatal("expected seek to position at 0251")
+	}
+
+	key, err = c.Seek([]byte("9999"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected no key past the end")
+	}
+}
+
+func TestBTree_Cursor_ConcurrentWrites(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 200; i += 2 {
+		err = btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var last []byte
+
+	c := btree.Cursor()
+	for key, err := c.First(); key != nil || err != nil; key, err = c.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if last != nil && !greaterThan(key.K, last) {
+			t.Fatalf("expected ascending keys, got %s after %s", key.K, last)
+		}
+		last = key.K
+		seen[string(key.K)] = true
+
+		n := 0
+		fmt.Sscanf(string(key.K), "%d", &n)
+
+		if n == 20 {
+			// odd keys behind and ahead of the cursor, splitting pages under it
+			for i := 1; i < 200; i += 2 {
+				err = btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		k := fmt.Sprintf("%04d", i)
+		expected := i%2 == 0 || i > 20
+		if seen[k] != expected {
+			t.Fatalf("key %s: expected seen %v", k, expected)
+		}
+	}
+}
+
+func TestBTree_NRangeCursor(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// expect returns the keys of 0..499 outside of [start, end]
+	expect := func(start, end int) []string {
+		keys := make([]string, 0)
+		for i := 0; i < 500; i++ {
+			if i < start || i > end {
+				keys = append(keys, fmt.Sprintf("%04d", i))
+			}
+		}
+		return keys
+	}
+
+	collect := func(c *Cursor) []string {
+		keys := make([]string, 0)
+		for key, err := c.First(); key != nil || err != nil; key, err = c.Next() {
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, string(key.K))
+		}
+		return keys
+	}
+
+	for _, r := range [][2]int{{10, 20}, {0, 250}, {250, 499}, {0, 499}, {42, 42}} {
+		start, end := []byte(fmt.Sprintf("%04d", r[0])), []byte(fmt.Sprintf("%04d", r[1]))
+		want := fmt.Sprint(expect(r[0], r[1]))
+
+		keys, err := btree.NRange(start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]string, 0, len(keys))
+		for _, key := range keys {
+			got = append(got, string(key.K))
+		}
+
+		if fmt.Sprint(got) != want {
+			t.Fatalf("NRange %s-%s: expected %d keys in order, got %d", start, end, len(expect(r[0], r[1])), len(got))
+		}
+
+		if fmt.Sprint(collect(btree.NRangeCursor(start, end))) != want {
+			t.Fatalf("NRangeCursor %s-%s: expected the keys of NRange", start, end)
+		}
+	}
+
+	keys, err := btree.NGet([]byte("0042"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 499 {
+		t.Fatalf("expected 499 keys, got %d", len(keys))
+	}
+
+	if fmt.Sprint(collect(btree.NGetCursor([]byte("0042")))) != fmt.Sprint(expect(42, 42)) {
+		t.Fatal("NGetCursor: expected every key but 0042")
+	}
+
+	// the cursor seeks past the range while it is being modified
+	c := btree.NRangeCursor([]byte("0100"), []byte("0399"))
+	seen := 0
+	for key, err := c.First(); key != nil || err != nil; key, err = c.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(key.K) >= "0100" && string(key.K) <= "0399" {
+			t.Fatalf("expected key %s to be skipped", key.K)
+		}
+
+		err = btree.Put([]byte(fmt.Sprintf("%04dx", seen)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen++
+	}
+
+	if seen < 200 {
+		t.Fatalf("expected at least 200 keys, got %d", seen)
+	}
+}
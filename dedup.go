@@ -0,0 +1,371 @@
+// Package btree
+// unique values
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"slices"
+)
+
+// hashSetThreshold is the number of values from which a key's values are checked for duplicates through a hash set page
+const hashSetThreshold = 32
+
+// ValueHash hashes values to check keys with many values for duplicates without comparing every value, see Options.UniqueValues
+// The name is stored with every hash set so sets built with another hash are rebuilt
+type ValueHash interface {
+	Name() string             // Name identifies the hash, it must not change between releases
+	Hash(value []byte) uint64 // Hash returns the hash of a value
+}
+
+// valueHashFunc is a ValueHash built from a function
+type valueHashFunc struct {
+	name string
+	fn   func(value []byte) uint64
+}
+
+// Name returns the name of the hash
+func (h *valueHashFunc) Name() string {
+	return h.name
+}
+
+// Hash returns the hash of a value
+func (h *valueHashFunc) Hash(value []byte) uint64 {
+	return h.fn(value)
+}
+
+// ValueHashFunc returns a ValueHash named name which applies fn
+func ValueHashFunc(name string, fn func(value []byte) uint64) ValueHash {
+	return &valueHashFunc{name: name, fn: fn}
+}
+
+// FNV64aValues hashes values with 64-bit FNV-1a
+var FNV64aValues = ValueHashFunc("fnv64a", func(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	return h.Sum64()
+})
+
+// hashSet is the content of a hash set page, the sorted hashes of every value of a key
+// Hashes of different values may collide so a hash can be listed more than once
+type hashSet struct {
+	Name   string   // name of the ValueHash the set was built with
+	Hashes []uint64 // sorted hashes
+}
+
+// contains returns true if the set holds h
+func (s *hashSet) contains(h uint64) bool {
+	_, found := slices.BinarySearch(s.Hashes, h)
+	return found
+}
+
+// add adds h to the set
+func (s *hashSet) add(h uint64) {
+	i, _ := slices.BinarySearch(s.Hashes, h)
+	s.Hashes = slices.Insert(s.Hashes, i, h)
+}
+
+// remove removes one occurrence of h from the set
+func (s *hashSet) remove(h uint64) {
+	i, found := slices.BinarySearch(s.Hashes, h)
+	if found {
+		s.Hashes = slices.Delete(s.Hashes, i, i+1)
+	}
+}
+
+// newValues returns the values key does not hold yet in order, a value passed more than once is returned once
+// Keys with many values are checked through their hash set when a ValueHash is set, a hash found in the set is confirmed byte-wise
+func (b *BTree) newValues(key []byte, values [][]byte) ([][]byte, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := b.lookupKey(root, key)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(values))
+	fresh := make([][]byte, 0, len(values))
+
+	for _, value := range values {
+		if seen[string(value)] {
+			continue
+		}
+		seen[string(value)] = true
+
+		if k != nil && !k.hidden() {
+			found, err := b.holdsValue(k, value)
+			if err != nil {
+				return nil, err
+			}
+
+			if found {
+				continue
+			}
+		}
+
+		fresh = append(fresh, value)
+	}
+
+	return fresh, nil
+}
+
+// holdsValue returns true if k holds value
+func (b *BTree) holdsValue(k *Key, value []byte) (bool, error) {
+	if b.valueHash != nil && len(k.V) >= hashSetThreshold {
+		set, err := b.loadHashSet(k)
+		if err != nil {
+			return false, err
+		}
+
+		if !set.contains(b.valueHash.Hash(value)) {
+			return false, nil
+		}
+	}
+
+	for j := range k.V {
+		v, err := b.resolveValue(k, j)
+		if err != nil {
+			return false, err
+		}
+
+		if bytes.Equal(v, value) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// loadHashSet returns the hash set of k, a missing set or one built with another hash is built from the values of k
+func (b *BTree) loadHashSet(k *Key) (*hashSet, error) {
+	if k.H != 0 {
+		set, err := b.readHashSet(k)
+		if err != nil {
+			return nil, err
+		}
+
+		if set.Name == b.valueHash.Name() {
+			return set, nil
+		}
+	}
+
+	set := &hashSet{Name: b.valueHash.Name(), Hashes: make([]uint64, 0, len(k.V))}
+	for j := range k.V {
+		v, err := b.resolveValue(k, j)
+		if err != nil {
+			return nil, err
+		}
+		set.Hashes = append(set.Hashes, b.valueHash.Hash(v))
+	}
+	slices.Sort(set.Hashes)
+
+	if k.H != 0 {
+		return set, b.writeHashSet(k, set)
+	}
+
+	encoded, err := encodeHashSet(set)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := b.allocatePage(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	// the node holding k records the page of the set
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	n, i, err := b.findNodeForKey(root, k.K)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Keys[i].H = page
+	k.H = page
+
+	return set, b.writeNode(n)
+}
+
+// readHashSet reads the hash set page of k
+func (b *BTree) readHashSet(k *Key) (*hashSet, error) {
+	data, err := b.Pager.ReadPage(k.H)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHashSet(data)
+}
+
+// writeHashSet writes the hash set page of k
+func (b *BTree) writeHashSet(k *Key, set *hashSet) error {
+	encoded, err := encodeHashSet(set)
+	if err != nil {
+		return err
+	}
+
+	return b.Pager.WritePage(k.H, encoded)
+}
+
+// encodeHashSet encodes a hash set for its page
+// The page holds the uvarint length of the name, the name, the uvarint number of hashes and every hash as a little endian uint64
+// Hashes are fixed width so they decode on 32-bit platforms as well.
+func encodeHashSet(set *hashSet) ([]byte, error) {
+	encoded := make([]byte, 0, 2*binary.MaxVarintLen64+len(set.Name)+8*len(set.Hashes))
+	encoded = binary.AppendUvarint(encoded, uint64(len(set.Name)))
+	encoded = append(encoded, set.Name...)
+	encoded = binary.AppendUvarint(encoded, uint64(len(set.Hashes)))
+	for _, h := range set.Hashes {
+		encoded = binary.LittleEndian.AppendUint64(encoded, h)
+	}
+
+	return encoded, nil
+}
+
+// decodeHashSet decodes a hash set encoded by encodeHashSet, the page may be padded
+func decodeHashSet(data []byte) (*hashSet, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || uint64(len(data)-size) < n {
+		return nil, errors.New("hash set: truncated name")
+	}
+
+	set := &hashSet{Name: string(data[size : size+int(n)])}
+	data = data[size+int(n):]
+
+	n, size = binary.Uvarint(data)
+	if size <= 0 || uint64(len(data)-size)/8 < n {
+		return nil, errors.New("hash set: truncated hashes")
+	}
+	data = data[size:]
+
+	set.Hashes = make([]uint64, n)
+	for i := range set.Hashes {
+		set.Hashes[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+
+	return set, nil
+}
+
+// valueAppended adds the hash of the last value of k to its hash set, the caller writes the node holding k
+func (b *BTree) valueAppended(k *Key) error {
+	if k.H == 0 {
+		return nil
+	}
+
+	v, err := b.resolveValue(k, len(k.V)-1)
+	if err != nil {
+		return err
+	}
+
+	return b.updateHashSet(k, v, (*hashSet).add)
+}
+
+// valueRemoved removes the hash of a value removed from k from its hash set, the caller writes the node holding k
+func (b *BTree) valueRemoved(k *Key, value []byte) error {
+	if k.H == 0 {
+		return nil
+	}
+
+	return b.updateHashSet(k, value, (*hashSet).remove)
+}
+
+// updateHashSet applies fn to the hash set of k and the hash of value
+// A set which cannot be kept up to date, without a ValueHash or built with another one, is dropped and rebuilt on demand
+func (b *BTree) updateHashSet(k *Key, value []byte, fn func(set *hashSet, h uint64)) error {
+	if b.valueHash == nil {
+		return b.dropHashSet(k)
+	}
+
+	set, err := b.readHashSet(k)
+	if err != nil {
+		return err
+	}
+
+	if set.Name != b.valueHash.Name() {
+		return b.dropHashSet(k)
+	}
+
+	fn(set, b.valueHash.Hash(value))
+
+	return b.writeHashSet(k, set)
+}
+
+// dropHashSet frees the hash set page of k, the caller writes the node holding k
+func (b *BTree) dropHashSet(k *Key) error {
+	if k.H == 0 {
+		return nil
+	}
+
+	err := b.freePage(k.H)
+	if err != nil {
+		return err
+	}
+
+	k.H = 0
+
+	return nil
+}
+
+// dropHashSets frees every hash set page, they are rebuilt on demand
+func (b *BTree) dropHashSets() error {
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	return b.walk(root, func(n *Node) error {
+		dropped := false
+		for _, k := range n.Keys {
+			if k != nil && k.H != 0 {
+				err := b.dropHashSet(k)
+				if err != nil {
+					return err
+				}
+				dropped = true
+			}
+		}
+
+		if !dropped {
+			return nil
+		}
+
+		return b.writeNode(n)
+	})
+}
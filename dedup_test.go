@@ -0,0 +1,293 @@
+// Package btree
+// unique values tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_UniqueValues(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{UniqueValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for _, v := range []string{"a", "b", "a", "c", "b"} {
+		err = b.Put([]byte("key"), []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.PutWithMeta([]byte("key"), []byte("c"), ValueMeta{Flags: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := b.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(k.V) != 3 || string(k.V[0]) != "a" || string(k.V[1]) != "b" || string(k.V[2]) != "c" {
+		t.Fatalf("expected a, b, c, got %q", k.V)
+	}
+
+	// a removed value can be put again
+	err = b.Remove([]byte("key"), []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Put([]byte("key"), []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err = b.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(k.V) != 3 || string(k.V[2]) != "b" {
+		t.Fatalf("expected b to be put again, got %q", k.V)
+	}
+
+	_, err = b.AppendOnly()
+	if err == nil {
+		t.Fatal("expected append sessions to be refused")
+	}
+}
+
+func TestBTree_UniqueValues_HashSet(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{UniqueValues: true, ValueHash: FNV64aValues, ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := func(i int) []byte {
+		// every tenth value is large enough for the value log
+		if i%10 == 0 {
+			return bytes.Repeat([]byte(fmt.Sprintf("%03d", i)), 32)
+		}
+		return []byte(fmt.Sprintf("value%03d", i))
+	}
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			err = b.Put([]byte("key"), value(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	k, err := b.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(k.V) != 100 {
+		t.Fatalf("expected 100 values, got %d", len(k.V))
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := b.lookupKey(root, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if raw.H == 0 {
+		t.Fatal("expected the key to have a hash set")
+	}
+
+	set, err := b.readHashSet(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if set.Name != "fnv64a" || len(set.Hashes) != 100 {
+		t.Fatalf("expected 100 fnv64a hashes, got %d %s hashes", len(set.Hashes), set.Name)
+	}
+
+	err = b.Remove([]byte("key"), value(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err = b.readHashSet(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.Hashes) != 99 || set.contains(FNV64aValues.Hash(value(50))) {
+		t.Fatal("expected the hash of the removed value to be gone")
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a set built with another hash is rebuilt
+	constant := ValueHashFunc("constant", func(value []byte) uint64 { return 0 })
+
+	b, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{UniqueValues: true, ValueHash: constant, ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// every hash collides so duplicates are confirmed byte-wise
+	for i := 0; i < 100; i++ {
+		err = b.Put([]byte("key"), value(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k, err = b.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(k.V) != 100 {
+		t.Fatalf("expected 100 values, got %d", len(k.V))
+	}
+
+	root, err = b.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err = b.lookupKey(root, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err = b.readHashSet(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if set.Name != "constant" || len(set.Hashes) != 100 {
+		t.Fatalf("expected 100 constant hashes, got %d %s hashes", len(set.Hashes), set.Name)
+	}
+
+	// deleting the key frees its hash set
+	free := b.Pager.(*Pager).FreePageCount()
+
+	err = b.Delete([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Pager.(*Pager).FreePageCount() <= free {
+		t.Fatal("expected the hash set page to be freed")
+	}
+}
+
+func TestBTree_UniqueValues_OptimizeLayout(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{UniqueValues: true, ValueHash: FNV64aValues})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 50; i++ {
+		for j := 0; j < hashSetThreshold+1; j++ {
+			err = b.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("%03d", j)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the last put of every key went through its hash set
+	_, _, err = b.OptimizeLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBTree(t, b)
+
+	root, err := b.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.walk(root, func(n *Node) error {
+		for _, k := range n.Keys {
+			if k.H != 0 {
+				t.Fatalf("expected the hash set of %s to be dropped", k.K)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), []byte("000"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := b.Get([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(k.V) != hashSetThreshold+1 {
+			t.Fatalf("expected %d values, got %d", hashSetThreshold+1, len(k.V))
+		}
+	}
+}
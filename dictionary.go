@@ -0,0 +1,291 @@
+// Package btree
+// node compression dictionaries
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"github.com/hashicorp/go-msgpack/codec"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+)
+
+// MaxDictionarySize is the size beyond which a dictionary is not used, deflate only looks back 32KB
+const MaxDictionarySize = 32 * 1024
+
+// dictionarySamples is the number of keys BTree.TrainDictionary samples
+const dictionarySamples = 4096
+
+// compressedNode marks a page holding a node compressed with the dictionary of the btree, msgpack never uses this byte
+const compressedNode = 0xc1
+
+// ErrDictionaryMismatch is returned when opening a btree with a different dictionary than the one it was written with
+var ErrDictionaryMismatch = errors.New("dictionary does not match the btree")
+
+// nodeCompressor compresses encoded nodes with deflate and a preset dictionary
+type nodeCompressor struct {
+	dict    []byte    // the preset dictionary
+	writers sync.Pool // reusable *flate.Writer
+	readers sync.Pool // reusable flate readers
+}
+
+// newNodeCompressor returns a compressor using dict
+func newNodeCompressor(dict []byte) *nodeCompressor {
+	return &nodeCompressor{dict: dict}
+}
+
+// compress returns the encoded node compressed and marked with compressedNode, or encoded if compression does not make it smaller
+func (c *nodeCompressor) compress(encoded []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(encoded)))
+	buf.WriteByte(compressedNode)
+
+	w, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(buf)
+	} else {
+		var err error
+		w, err = flate.NewWriterDict(buf, flate.DefaultCompression, c.dict)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer c.writers.Put(w)
+
+	_, err := w.Write(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(encoded) {
+		return encoded, nil
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompress returns the encoded node of a page written by compress, the padding after the compressed data is ignored
+func (c *nodeCompressor) decompress(data []byte) ([]byte, error) {
+	src := bytes.NewReader(data[1:])
+
+	r, ok := c.readers.Get().(io.ReadCloser)
+	if ok {
+		err := r.(flate.Resetter).Reset(src, c.dict)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		r = flate.NewReaderDict(src, c.dict)
+	}
+	defer c.readers.Put(r)
+
+	return io.ReadAll(r)
+}
+
+// TrainDictionary builds a dictionary of at most size bytes from samples of keys, values or encoded keys
+// Samples which occur often and are long are kept, the most valuable last as deflate encodes close matches in fewer bits.
+func TrainDictionary(samples [][]byte, size int) []byte {
+	if size <= 0 || size > MaxDictionarySize {
+		size = MaxDictionarySize
+	}
+
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		if len(sample) > 0 {
+			counts[string(sample)]++
+		}
+	}
+
+	distinct := make([]string, 0, len(counts))
+	for sample := range counts {
+		distinct = append(distinct, sample)
+	}
+
+	// samples are ordered by the bytes they save, ties by content so training is deterministic
+	slices.SortFunc(distinct, func(a, b string) int {
+		if sa, sb := counts[a]*len(a), counts[b]*len(b); sa != sb {
+			return sb - sa
+		}
+		return bytes.Compare([]byte(a), []byte(b))
+	})
+
+	kept := make([]string, 0)
+	total := 0
+	for _, sample := range distinct {
+		if total+len(sample) > size {
+			continue
+		}
+		kept = append(kept, sample)
+		total += len(sample)
+	}
+
+	dict := make([]byte, 0, total)
+	for i := len(kept) - 1; i >= 0; i-- {
+		dict = append(dict, kept[i]...)
+	}
+
+	return dict
+}
+
+// TrainDictionary builds a dictionary of at most size bytes from a sample of the encoded keys of the btree
+// The dictionary is meant for a new btree holding similar keys, i.e. passed to MigrateFile through Options.Dictionary.
+func (b *BTree) TrainDictionary(size int) ([]byte, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	// reservoir sampling with a fixed seed keeps training deterministic
+	rng := rand.New(rand.NewSource(1))
+	samples := make([][]byte, 0, dictionarySamples)
+	seen := 0
+
+	err = b.walk(root, func(n *Node) error {
+		for _, k := range n.Keys {
+			if k == nil {
+				continue
+			}
+
+			var encoded []byte
+			err := codec.NewEncoderBytes(&encoded, msgpackHandle).Encode((*keyRecord)(k))
+			if err != nil {
+				return err
+			}
+
+			seen++
+			if len(samples) < dictionarySamples {
+				samples = append(samples, encoded)
+			} else if i := rng.Intn(seen); i < dictionarySamples {
+				samples[i] = encoded
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return TrainDictionary(samples, size), nil
+}
+
+// Dictionary returns the dictionary nodes are compressed with, nil if nodes are not compressed
+func (b *BTree) Dictionary() []byte {
+	if b.compressor == nil {
+		return nil
+	}
+	return b.compressor.dict
+}
+
+// checkDictionary compares the dictionary with the one recorded in the .dict file
+// A btree without a recorded dictionary records dict, pages written before stay readable as they are not marked as compressed
+func (b *BTree) checkDictionary(dict []byte) error {
+	if len(dict) > MaxDictionarySize {
+		return errors.New("dictionary is larger than MaxDictionarySize")
+	}
+
+	recorded, err := os.ReadFile(b.name + ".dict")
+	if err == nil {
+		if dict != nil && !bytes.Equal(dict, recorded) {
+			return ErrDictionaryMismatch
+		}
+		b.compressor = newNodeCompressor(recorded)
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(dict) == 0 {
+		return nil
+	}
+
+	err = os.WriteFile(b.name+".dict", dict, b.perm)
+	if err != nil {
+		return err
+	}
+
+	b.compressor = newNodeCompressor(slices.Clone(dict))
+	return nil
+}
+
+// encodePage encodes a node for its page, in the fixed key size encoding if the btree has a fixed key size and compressed if it has a dictionary
+func (b *BTree) encodePage(n *Node) ([]byte, error) {
+	var encoded []byte
+	var err error
+
+	fixed := false
+	if b.fixedKeySize > 0 {
+		encoded, fixed, err = encodeFixedNode(n, b.fixedKeySize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !fixed {
+		encoded, err = b.encodeRecord(n)
+	}
+
+	if err != nil || b.compressor == nil {
+		return encoded, err
+	}
+
+	return b.compressor.compress(encoded)
+}
+
+// decodePage decodes the node stored in a page, compressed or not
+func (b *BTree) decodePage(data []byte) (*Node, error) {
+	if len(data) > 0 && data[0] == compressedNode {
+		if b.compressor == nil {
+			return nil, errors.New("node is compressed but the btree has no dictionary")
+		}
+
+		encoded, err := b.compressor.decompress(data)
+		if err != nil {
+			return nil, err
+		}
+
+		data = encoded
+	}
+
+	if len(data) > 0 && data[0] == fixedKeyNode {
+		return decodeFixedNode(data)
+	}
+
+	return b.decodeRecord(data)
+}
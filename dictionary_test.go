@@ -0,0 +1,200 @@
+// Package btree
+// node compression dictionary tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestTrainDictionary(t *testing.T) {
+	samples := [][]byte{[]byte("rare"), []byte("common"), []byte("common"), []byte("common"), []byte("longer sample")}
+
+	dict := TrainDictionary(samples, 1024)
+
+	// the most valuable sample comes last
+	if !bytes.HasSuffix(dict, []byte("common")) || !bytes.Contains(dict, []byte("rare")) {
+		t.Fatalf("unexpected dictionary %q", dict)
+	}
+
+	if !bytes.Equal(dict, TrainDictionary(samples, 1024)) {
+		t.Fatal("expected training to be deterministic")
+	}
+
+	// samples which no longer fit are skipped for smaller ones
+	small := TrainDictionary(samples, 14)
+	if string(small) != "rarecommon" {
+		t.Fatalf("expected the most valuable samples within the size, got %q", small)
+	}
+}
+
+func TestBTree_Dictionary(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.dict")
+	defer os.Remove("plain.db")
+	defer os.Remove("plain.db.del")
+
+	put := func(b *BTree) {
+		for i := 0; i < 2000; i++ {
+			err := b.Put([]byte(fmt.Sprintf("user:%06d", i)), []byte(`{"status":"active","plan":"free"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	plain, err := Open("plain.db", os.O_CREATE|os.O_RDWR, 0644, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+
+	put(plain)
+
+	dict, err := plain.TrainDictionary(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 32, &Options{Dictionary: dict})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(b)
+
+	compressed, uncompressed := b.Pager.(*Pager).PageCount(), plain.Pager.(*Pager).PageCount()
+	if compressed*2 > uncompressed {
+		t.Fatalf("expected compressed nodes to use less than half the pages, got %d of %d", compressed, uncompressed)
+	}
+
+	err = b.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyFormat("btree.db", FormatVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the recorded dictionary is used when opening without one
+	b, err = Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b.Dictionary(), dict) {
+		t.Fatal("expected the recorded dictionary")
+	}
+
+	key, err := b.Get([]byte("user:001234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.V[0]) != `{"status":"active","plan":"free"}` {
+		t.Fatalf("expected the value, got %v", key)
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 32, &Options{Dictionary: []byte("other")})
+	if !errors.Is(err, ErrDictionaryMismatch) {
+		t.Fatalf("expected ErrDictionaryMismatch, got %v", err)
+	}
+}
+
+func TestBTree_Dictionary_Existing(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.dict")
+
+	b, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pages written before the dictionary stay readable next to compressed ones
+	b, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Dictionary: []byte("valuevaluevalue")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 100; i < 200; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		key, err := b.Get([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != "value" {
+			t.Fatalf("expected key %d, got %v", i, key)
+		}
+	}
+
+	err = b.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,111 @@
+// Package btree
+// tree diffs
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"slices"
+)
+
+// DiffKind is the way a key differs between two trees
+type DiffKind int
+
+const (
+	DIFF_ADDED   DiffKind = iota // The key is in the other tree only
+	DIFF_MISSING                 // The key is in this tree only
+	DIFF_CHANGED                 // The key is in both trees with different values
+)
+
+// KeyDiff is a key which differs between two trees
+type KeyDiff struct {
+	Kind  DiffKind // How the key differs
+	K     []byte   // The key
+	Key   *Key     // The key in this tree, nil for DIFF_ADDED
+	Other *Key     // The key in the other tree, nil for DIFF_MISSING
+}
+
+// Diff calls fn for every key which differs between this tree and other in key order
+// Both trees are walked in order at the same time with a cursor each, so neither is loaded into memory.  Keys are
+// compared as stored (after the key transform) and are changed if their values differ, values are read from the value log.
+// Deleted and expired keys are absent.  Diffing stops at the first error returned by fn.
+func (b *BTree) Diff(other *BTree, fn func(d *KeyDiff) error) error {
+	ours, theirs := b.Cursor(), other.Cursor()
+
+	key, err := ours.Next()
+	if err != nil {
+		return err
+	}
+
+	otherKey, err := theirs.Next()
+	if err != nil {
+		return err
+	}
+
+	for key != nil || otherKey != nil {
+		var d *KeyDiff
+		advanceOurs, advanceTheirs := true, true
+
+		switch {
+		case otherKey == nil || (key != nil && lessThan(key.K, otherKey.K)):
+			d = &KeyDiff{Kind: DIFF_MISSING, K: key.K, Key: key}
+			advanceTheirs = false
+		case key == nil || lessThan(otherKey.K, key.K):
+			d = &KeyDiff{Kind: DIFF_ADDED, K: otherKey.K, Other: otherKey}
+			advanceOurs = false
+		case !slices.EqualFunc(key.V, otherKey.V, bytes.Equal):
+			d = &KeyDiff{Kind: DIFF_CHANGED, K: key.K, Key: key, Other: otherKey}
+		}
+
+		if d != nil {
+			err = fn(d)
+			if err != nil {
+				return err
+			}
+		}
+
+		if advanceOurs {
+			key, err = ours.Next()
+			if err != nil {
+				return err
+			}
+		}
+
+		if advanceTheirs {
+			otherKey, err = theirs.Next()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+// Package btree
+// tree diffs tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_Diff(t *testing.T) {
+	defer os.Remove("diff.db")
+	defer os.Remove("diff.db.del")
+	defer os.Remove("diff.db.vlog")
+
+	btree, err := OpenWithOptions("diff.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	other, err := OpenMemory(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%03d", i))
+
+		// a large value in the value log is still compared by its contents
+		v := []byte(fmt.Sprintf("value-%03d-stored-in-the-value-log", i))
+
+		err = btree.Put(k, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch {
+		case i%10 == 3:
+			// missing from other
+		case i%10 == 5:
+			err = other.Put(k, []byte("changed"))
+		default:
+			err = other.Put(k, v)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// added past the last key of this tree
+	err = other.Put([]byte("999"), []byte("added"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs := make([]string, 0)
+	err = btree.Diff(other, func(d *KeyDiff) error {
+		diffs = append(diffs, fmt.Sprintf("%d:%s", d.Kind, d.K))
+
+		if (d.Key == nil) != (d.Kind == DIFF_ADDED) || (d.Other == nil) != (d.Kind == DIFF_MISSING) {
+			t.Fatalf("unexpected keys for %s: %v %v", d.K, d.Key, d.Other)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		switch i % 10 {
+		case 3:
+			expected = append(expected, fmt.Sprintf("%d:%03d", DIFF_MISSING, i))
+		case 5:
+			expected = append(expected, fmt.Sprintf("%d:%03d", DIFF_CHANGED, i))
+		}
+	}
+	expected = append(expected, fmt.Sprintf("%d:999", DIFF_ADDED))
+
+	if fmt.Sprint(diffs) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, diffs)
+	}
+
+	// identical trees do not differ
+	err = btree.Diff(btree, func(d *KeyDiff) error {
+		return fmt.Errorf("unexpected difference %s", d.K)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// diffing stops at the first error
+	stop := errors.New("stop")
+	calls := 0
+	err = btree.Diff(other, func(d *KeyDiff) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("expected diffing to stop after 1 call, got %d calls and %v", calls, err)
+	}
+}
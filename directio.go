@@ -0,0 +1,192 @@
+// Package btree
+// direct i/o
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// DIRECT_IO_ALIGNMENT is the alignment of the offsets, lengths and buffers of direct i/o, a multiple of the block size of common devices
+const DIRECT_IO_ALIGNMENT = 4096
+
+// errDirectIOUnsupported is returned by openDirect when the platform or file system has no direct i/o
+var errDirectIOUnsupported = errors.New("direct i/o unsupported")
+
+// directFile is a file opened for direct i/o
+// Pages are not aligned to blocks, so reads and writes are widened to whole aligned blocks read and written through aligned buffers.
+// A write only covering part of a block reads the block first.
+type directFile struct {
+	*os.File
+	lock sync.Mutex // serializes writes sharing a block
+}
+
+// openPageFile opens the page file of a pager, with direct i/o if requested
+// The file is opened normally if direct i/o is not supported, the returned bool is true if direct i/o is used
+func openPageFile(name string, flag int, perm os.FileMode, direct bool) (pageFile, bool, error) {
+	if direct {
+		f, err := openDirect(name, flag, perm)
+		if err == nil {
+			return &directFile{File: f}, true, nil
+		}
+		if !errors.Is(err, errDirectIOUnsupported) {
+			return nil, false, err
+		}
+	}
+
+	f, err := openFile(name, flag, perm)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return f, false, nil
+}
+
+// alignedBuffer returns a buffer of n bytes starting at a multiple of DIRECT_IO_ALIGNMENT
+func alignedBuffer(n int) []byte {
+	buf := make([]byte, n+DIRECT_IO_ALIGNMENT)
+
+	shift := int(uintptr(unsafe.Pointer(&buf[0])) & (DIRECT_IO_ALIGNMENT - 1))
+	if shift != 0 {
+		shift = DIRECT_IO_ALIGNMENT - shift
+	}
+
+	return buf[shift : shift+n : shift+n]
+}
+
+// alignDown returns the greatest multiple of DIRECT_IO_ALIGNMENT not greater than off
+func alignDown(off int64) int64 {
+	return off &^ (DIRECT_IO_ALIGNMENT - 1)
+}
+
+// alignUp returns the smallest multiple of DIRECT_IO_ALIGNMENT not less than off
+func alignUp(off int64) int64 {
+	return alignDown(off + DIRECT_IO_ALIGNMENT - 1)
+}
+
+// ReadAt reads len(p) bytes at off through the aligned blocks holding them
+func (f *directFile) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	start := alignDown(off)
+	buf := alignedBuffer(int(alignUp(off+int64(len(p))) - start))
+
+	// the last block of the file is read short
+	n, err := f.File.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	skip := int(off - start)
+	if n <= skip {
+		return 0, io.EOF
+	}
+
+	copied := copy(p, buf[skip:n])
+	if copied < len(p) {
+		return copied, io.EOF
+	}
+
+	return copied, nil
+}
+
+// WriteAt writes p at off, the blocks only partly covered by p are read and written back whole
+// The file is cut back to its length as whole blocks are written past its end.
+func (f *directFile) WriteAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	stat, err := f.File.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	start, end := alignDown(off), alignUp(off+int64(len(p)))
+	buf := alignedBuffer(int(end - start))
+
+	err = f.readBlock(buf[:DIRECT_IO_ALIGNMENT], start, stat.Size())
+	if err == nil && end-start > DIRECT_IO_ALIGNMENT {
+		err = f.readBlock(buf[len(buf)-DIRECT_IO_ALIGNMENT:], end-DIRECT_IO_ALIGNMENT, stat.Size())
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	copy(buf[off-start:], p)
+
+	_, err = f.File.WriteAt(buf, start)
+	if err != nil {
+		return 0, err
+	}
+
+	if length := max(stat.Size(), off+int64(len(p))); length < end {
+		err = f.File.Truncate(length)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// readBlock reads the block at off into buf, the part of the block beyond size is zeroed
+func (f *directFile) readBlock(buf []byte, off, size int64) error {
+	if off >= size {
+		return nil
+	}
+
+	n, err := f.File.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	clear(buf[n:])
+
+	return nil
+}
+
+// Truncate changes the size of the file, serialized with writes
+func (f *directFile) Truncate(size int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.File.Truncate(size)
+}
@@ -0,0 +1,183 @@
+// Package btree
+// direct i/o tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, n := range []int{1, PAGE_SIZE + HEADER_SIZE, DIRECT_IO_ALIGNMENT * 3} {
+		buf := alignedBuffer(n)
+		if len(buf) != n {
+			t.Fatalf("expected %d bytes, got %d", n, len(buf))
+		}
+
+		if uintptr(unsafe.Pointer(&buf[0]))%DIRECT_IO_ALIGNMENT != 0 {
+			t.Fatalf("expected a buffer of %d bytes to be aligned", n)
+		}
+	}
+}
+
+func TestDirectFile(t *testing.T) {
+	defer os.Remove("btree.db")
+
+	// the block arithmetic does not need O_DIRECT, a regular file is wrapped
+	f, err := openFile("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := &directFile{File: f}
+	defer file.Close()
+
+	expect := make([]byte, 0)
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		off := int64(r.Intn(5 * DIRECT_IO_ALIGNMENT))
+		data := make([]byte, 1+r.Intn(2*DIRECT_IO_ALIGNMENT))
+		r.Read(data)
+
+		n, err := file.WriteAt(data, off)
+		if err != nil || n != len(data) {
+			t.Fatalf("write %d: %d %v", i, n, err)
+		}
+
+		if end := int(off) + len(data); end > len(expect) {
+			expect = append(expect, make([]byte, end-len(expect))...)
+		}
+		copy(expect[off:], data)
+
+		// whole blocks are written but the file keeps its length
+		stat, err := file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stat.Size() != int64(len(expect)) {
+			t.Fatalf("write %d: expected a size of %d, got %d", i, len(expect), stat.Size())
+		}
+	}
+
+	got := make([]byte, len(expect))
+	n, err := file.ReadAt(got, 0)
+	if err != nil || n != len(expect) {
+		t.Fatalf("expected to read %d bytes, got %d %v", len(expect), n, err)
+	}
+
+	if !bytes.Equal(got, expect) {
+		t.Fatal("expected the file to hold every write")
+	}
+
+	// a read past the end is short
+	buf := make([]byte, 100)
+	n, err = file.ReadAt(buf, int64(len(expect)-10))
+	if n != 10 || err == nil {
+		t.Fatalf("expected a short read of 10 bytes, got %d %v", n, err)
+	}
+
+	if !bytes.Equal(buf[:10], expect[len(expect)-10:]) {
+		t.Fatal("expected the tail of the file")
+	}
+}
+
+func TestPager_DirectIO(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPagerWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, &PagerOptions{DirectIO: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file system of the test may not support O_DIRECT, the pager falls back to buffered i/o
+	t.Logf("direct i/o: %v", pager.DirectIO())
+
+	pages := make(map[int64][]byte)
+	for i := 0; i < 20; i++ {
+		data := bytes.Repeat([]byte{byte(i + 1)}, 1+i*150)
+
+		pageID, err := pager.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages[pageID] = data
+	}
+
+	// overwriting a page rewrites the blocks it shares with its neighbours
+	pages[3] = []byte("rewritten")
+	err = pager.WriteTo(3, pages[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Size()%(PAGE_SIZE+HEADER_SIZE) != 0 {
+		t.Fatalf("expected a whole number of pages, got %d bytes", stat.Size())
+	}
+
+	pager, err = OpenPager("btree.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	if pager.DirectIO() {
+		t.Fatal("expected buffered i/o when not requested")
+	}
+
+	for pageID, data := range pages {
+		got, err := pager.GetPage(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(bytes.TrimRight(got, "\x00"), data) {
+			t.Fatalf("page %d: expected %d bytes, got %d", pageID, len(data), len(bytes.TrimRight(got, "\x00")))
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package btree
+// effective options
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"time"
+)
+
+// EffectiveOptions describe how an open btree is configured with every default filled in, i.e. to log the configuration of many trees
+type EffectiveOptions struct {
+	Order        int           // The order of the tree
+	PageSize     int           // Bytes of a page, not counting its header
+	Storage      string        // Type of the storage the nodes are kept in, i.e. *btree.Pager
+	KeyTransform string        // Name of the key transform keys are compared after, empty if keys are compared as they are
+	SortedValues bool          // True if the values of every key are kept sorted by Options.ValueComparator
+	NodeCodec    string        // Name of the codec nodes are stored with
+	Compression  string        // "deflate" if nodes are compressed with a dictionary, "none" otherwise
+	SyncInterval time.Duration // Interval the pager syncs the file at, 0 if the tree is not stored by a Pager
+	SafeWrites   bool          // True if every operation is published atomically through the journal
+	CachePages   int           // Pages kept in memory, the cache capacity of an ObjectPager or the pinned pages of a Pager
+	Options      Options       // The options the btree was opened with, defaults and the settings recorded with the file filled in
+}
+
+// Options returns the effective configuration of the btree
+// The returned options are a snapshot, changing them does not change the btree.
+func (b *BTree) Options() EffectiveOptions {
+	opts := b.opened
+	opts.KeyTransform = b.keyTransform
+	opts.Dictionary = b.Dictionary()
+	opts.NodeCodec = b.NodeCodec()
+	opts.FixedKeySize = b.fixedKeySize
+	opts.Codec = b.objectCodec()
+
+	if opts.SequenceBatch == 0 {
+		opts.SequenceBatch = DefaultSequenceBatch
+	}
+
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+
+	if opts.IdempotencyWindow == 0 {
+		opts.IdempotencyWindow = DefaultIdempotencyWindow
+	}
+
+	if opts.Audit != nil && opts.AuditBuffer == 0 {
+		opts.AuditBuffer = DefaultAuditBuffer
+	}
+
+	if opts.Heatmap && opts.HeatmapSample == 0 {
+		opts.HeatmapSample = DefaultHeatmapSample
+	}
+
+	// a transaction stores its writes in memory until it is committed
+	storage := b.Pager
+	if b.txn != nil {
+		storage = b.txn.storage
+	}
+
+	effective := EffectiveOptions{
+		Order:        b.T,
+		PageSize:     PAGE_SIZE,
+		Storage:      fmt.Sprintf("%T", storage),
+		SortedValues: b.valueCompare != nil,
+		NodeCodec:    opts.NodeCodec,
+		Compression:  "none",
+		SafeWrites:   b.journal != nil,
+	}
+
+	if b.keyTransform != nil {
+		effective.KeyTransform = b.keyTransform.Name()
+	}
+
+	if b.compressor != nil {
+		effective.Compression = "deflate"
+	}
+
+	switch s := storage.(type) {
+	case *Pager:
+		pagerOpts := PagerOptions{}
+		if opts.PagerOptions != nil {
+			pagerOpts = *opts.PagerOptions
+		}
+
+		pagerOpts.SyncInterval = s.syncInterval
+		pagerOpts.Allocator = s.allocator
+		pagerOpts.DirectIO = s.directIO
+		if s.segments != nil {
+			pagerOpts.SegmentPages = s.segments.size / (PAGE_SIZE + HEADER_SIZE)
+		}
+
+		opts.PagerOptions = &pagerOpts
+		effective.SyncInterval = s.syncInterval
+
+		s.deletedPagesLock.Lock()
+		effective.CachePages = len(s.pinned)
+		s.deletedPagesLock.Unlock()
+	case *ObjectPager:
+		effective.CachePages = s.CacheStats().Capacity
+	}
+
+	effective.Options = opts
+
+	return effective
+}
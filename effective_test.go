@@ -0,0 +1,97 @@
+// Package btree
+// effective options tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBTree_Options(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.shadow")
+	defer os.Remove("btree.db.transform")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{
+		SafeWrites:      true,
+		KeyTransform:    LowerCaseKeys,
+		ValueComparator: bytes.Compare,
+		PagerOptions:    &PagerOptions{SyncInterval: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := btree.Options()
+
+	if opts.Order != 3 || opts.PageSize != PAGE_SIZE || opts.Storage != "*btree.Pager" {
+		t.Fatalf("unexpected layout %+v", opts)
+	}
+
+	if opts.KeyTransform != LowerCaseKeys.Name() || !opts.SortedValues || opts.NodeCodec != DefaultNodeCodec || opts.Compression != "none" {
+		t.Fatalf("unexpected encoding %+v", opts)
+	}
+
+	if opts.SyncInterval != time.Second || !opts.SafeWrites {
+		t.Fatalf("unexpected sync policy %+v", opts)
+	}
+
+	if opts.Options.MaxDepth != DefaultMaxDepth || opts.Options.SequenceBatch != DefaultSequenceBatch || opts.Options.PagerOptions.Retries != 3 {
+		t.Fatalf("expected defaults to be filled in, got %+v", opts.Options)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pager defaults are reported when no pager options are passed
+	btree, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{KeyTransform: LowerCaseKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	opts = btree.Options()
+
+	if opts.KeyTransform != LowerCaseKeys.Name() || opts.SyncInterval != 128*time.Millisecond || opts.SafeWrites {
+		t.Fatalf("unexpected options after reopening %+v", opts)
+	}
+
+	if opts.Options.PagerOptions == nil || opts.Options.PagerOptions.SyncInterval != opts.SyncInterval {
+		t.Fatalf("expected the pager options to be filled in, got %+v", opts.Options.PagerOptions)
+	}
+}
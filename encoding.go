@@ -0,0 +1,198 @@
+// Package btree
+// key and node marshaling
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// keyRecord and nodeRecord are Key and Node without methods
+// The msgpack codec calls MarshalBinary on types implementing it, pages are encoded through these types to keep the page format unchanged.
+type keyRecord Key
+
+type nodeRecord struct {
+	Page     int64
+	Keys     []*keyRecord
+	Children []int64
+	Leaf     bool
+}
+
+// toNodeRecord converts a node to its canonical record for encoding, equal nodes encode to the same bytes
+func toNodeRecord(n *Node) *nodeRecord {
+	if n == nil {
+		return nil
+	}
+
+	r := &nodeRecord{Page: n.Page, Children: canonicalSlice(n.Children), Leaf: n.Leaf}
+	if len(n.Keys) > 0 {
+		r.Keys = make([]*keyRecord, len(n.Keys))
+		for i, k := range n.Keys {
+			r.Keys[i] = canonicalKey(k)
+		}
+	}
+	return r
+}
+
+// canonicalKey returns the record of a key in its canonical form without modifying the key
+// Pointer flags, metadata and versions past the last one set read the same as missing ones and are dropped, empty slices are stored as nil.
+func canonicalKey(k *Key) *keyRecord {
+	if k == nil {
+		return nil
+	}
+
+	r := keyRecord(*k)
+	r.V = canonicalSlice(r.V)
+	r.Ptr = trimZeros(r.Ptr)
+	r.M = trimZeros(r.M)
+	r.Ver = trimZeros(r.Ver)
+
+	return &r
+}
+
+// canonicalSlice returns nil for an empty slice so nil and empty slices encode the same
+func canonicalSlice[T any](s []T) []T {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// trimZeros returns s without its trailing zero values, nil if every value is zero
+func trimZeros[T comparable](s []T) []T {
+	var zero T
+
+	n := len(s)
+	for n > 0 && s[n-1] == zero {
+		n--
+	}
+
+	return canonicalSlice(s[:n])
+}
+
+// fromNodeRecord converts a decoded record back to a node
+func fromNodeRecord(r *nodeRecord) *Node {
+	if r == nil {
+		return nil
+	}
+
+	n := &Node{Page: r.Page, Children: r.Children, Leaf: r.Leaf}
+	if r.Keys != nil {
+		n.Keys = make([]*Key, len(r.Keys))
+		for i, k := range r.Keys {
+			n.Keys[i] = (*Key)(k)
+		}
+	}
+	return n
+}
+
+// MarshalBinary encodes the node in the format it is stored in a page
+func (n *Node) MarshalBinary() ([]byte, error) {
+	return encodeNode(n)
+}
+
+// UnmarshalBinary decodes a node encoded by MarshalBinary
+func (n *Node) UnmarshalBinary(data []byte) error {
+	decoded, err := decodeNode(data)
+	if err != nil {
+		return err
+	}
+
+	if decoded == nil {
+		return errors.New("invalid node encoding")
+	}
+
+	*n = *decoded
+	return nil
+}
+
+// MarshalBinary encodes the key in the msgpack format it is stored in a page
+func (k *Key) MarshalBinary() ([]byte, error) {
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, msgpackHandle)
+	err := enc.Encode(canonicalKey(k))
+	if err != nil {
+		return nil, err
+	}
+
+	return encoded, nil
+}
+
+// UnmarshalBinary decodes a key encoded by MarshalBinary
+func (k *Key) UnmarshalBinary(data []byte) error {
+	var decoded keyRecord
+	dec := codec.NewDecoderBytes(data, msgpackHandle)
+	err := dec.Decode(&decoded)
+	if err != nil {
+		return err
+	}
+
+	*k = Key(decoded)
+	return nil
+}
+
+// keyJSON is the JSON representation of a key, byte slices are base64 encoded
+type keyJSON struct {
+	Key      []byte       `json:"key"`
+	Values   [][]byte     `json:"values"`
+	Expires  int64        `json:"expires,omitempty"`
+	Meta     []*ValueMeta `json:"meta,omitempty"`
+	Versions []uint64     `json:"versions,omitempty"`
+	Version  uint64       `json:"version,omitempty"`
+	Flags    uint64       `json:"flags,omitempty"`
+}
+
+// MarshalJSON encodes a key returned by a read as JSON
+// Keys holding unresolved value log pointers cannot be encoded
+func (k *Key) MarshalJSON() ([]byte, error) {
+	for _, ptr := range k.Ptr {
+		if ptr {
+			return nil, errors.New("key holds unresolved value log pointers")
+		}
+	}
+
+	return json.Marshal(keyJSON{Key: k.K, Values: k.V, Expires: k.E, Meta: k.M, Versions: k.Ver, Version: k.R, Flags: k.F})
+}
+
+// UnmarshalJSON decodes a key encoded by MarshalJSON
+func (k *Key) UnmarshalJSON(data []byte) error {
+	var decoded keyJSON
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	*k = Key{K: decoded.Key, V: decoded.Values, E: decoded.Expires, M: decoded.Meta, Ver: decoded.Versions, R: decoded.Version, F: decoded.Flags}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Package btree
+// key and node marshaling tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestNode_MarshalBinary(t *testing.T) {
+	n := &Node{
+		Page:     3,
+		Keys:     []*Key{{K: []byte("a"), V: [][]byte{[]byte("b")}, M: []*ValueMeta{nil, {Created: 1}}}},
+		Children: []int64{1, 2},
+	}
+
+	data, err := n.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the page format must not change with the marshaling methods
+	expected := "84a84368696c6472656e920102a44b6579739183a14ba161a14d92c082a74372656174656401a5466c61677300a15691a162a44c656166c2a45061676503"
+	if hex.EncodeToString(data) != expected {
+		t.Fatalf("unexpected node encoding %x", data)
+	}
+
+	var decoded Node
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Page != 3 || len(decoded.Children) != 2 || !bytes.Equal(decoded.Keys[0].K, []byte("a")) || decoded.Keys[0].M[1].Created != 1 {
+		t.Fatalf("unexpected decoded node %+v", decoded)
+	}
+}
+
+func TestKey_MarshalBinary(t *testing.T) {
+	key := &Key{K: []byte("key"), V: [][]byte{[]byte("v1"), []byte("v2")}, E: 42, Ver: []uint64{1, 2}}
+
+	data, err := key.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Key
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded.K) != "key" || len(decoded.V) != 2 || string(decoded.V[1]) != "v2" || decoded.E != 42 || decoded.Ver[1] != 2 {
+		t.Fatalf("unexpected decoded key %+v", decoded)
+	}
+}
+
+func TestKey_MarshalJSON(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.PutWithMeta([]byte("key"), []byte("value"), ValueMeta{Created: 5, Flags: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != `{"key":"a2V5","values":["dmFsdWU="],"meta":[{"Created":5,"Flags":1}]}` {
+		t.Fatalf("unexpected json %s", data)
+	}
+
+	var decoded *Key
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded.K) != "key" || string(decoded.V[0]) != "value" || decoded.M[0].Flags != 1 {
+		t.Fatalf("unexpected decoded key %+v", decoded)
+	}
+
+	_, err = json.Marshal(&Key{K: []byte("key"), V: [][]byte{{}}, Ptr: []bool{true}})
+	if err == nil {
+		t.Fatal("expected an error encoding value log pointers")
+	}
+}
+
+func TestNode_CanonicalEncoding(t *testing.T) {
+	n := &Node{
+		Page: 3,
+		Keys: []*Key{{K: []byte("aaaa"), V: [][]byte{[]byte("b"), []byte("c")}, Ptr: []bool{false, false}, M: []*ValueMeta{{Created: 1}, nil}, Ver: []uint64{0, 0}}},
+		Leaf: true,
+	}
+
+	// the same node without the unset flags, metadata and versions and with nil children
+	same := &Node{
+		Page:     3,
+		Keys:     []*Key{{K: []byte("aaaa"), V: [][]byte{[]byte("b"), []byte("c")}, M: []*ValueMeta{{Created: 1}}}},
+		Children: []int64{},
+		Leaf:     true,
+	}
+
+	encodings := map[string]func(n *Node) ([]byte, error){
+		"msgpack": encodeNode,
+		"json": func(n *Node) ([]byte, error) {
+			return JSONCodec{}.Marshal(toNodeRecord(n))
+		},
+		"fixed": func(n *Node) ([]byte, error) {
+			data, _, err := encodeFixedNode(n, 4)
+			return data, err
+		},
+	}
+
+	for name, encode := range encodings {
+		a, err := encode(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := encode(same)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(a, b) {
+			t.Fatalf("%s: expected equal nodes to encode the same, got %x and %x", name, a, b)
+		}
+	}
+
+	if len(n.Keys[0].Ptr) != 2 || len(n.Keys[0].M) != 2 || len(n.Keys[0].Ver) != 2 {
+		t.Fatal("expected encoding to leave the node unchanged")
+	}
+
+	// a decoded node is written back byte for byte
+	data, err := encodeNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeNode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := encodeNode(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, again) {
+		t.Fatalf("expected a rewrite to be byte identical, got %x and %x", data, again)
+	}
+}
@@ -0,0 +1,355 @@
+// Package btree
+// page eviction policies
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"container/list"
+	"slices"
+)
+
+// EvictionPolicy decides which cached page an ObjectPager evicts once its cache is full
+// The pager calls the policy with its lock held, implementations need no locking of their own.
+type EvictionPolicy interface {
+	Added(pageID int64)                                 // Added is called when a page enters the cache
+	Accessed(pageID int64)                              // Accessed is called when a cached page is read or written
+	Removed(pageID int64)                               // Removed is called when a page leaves the cache without being evicted, i.e. it was freed
+	Evict(pinned func(pageID int64) bool) (int64, bool) // Evict picks a page which is not pinned and forgets it, false if every page is pinned
+}
+
+// lruPolicy evicts the least recently used page
+type lruPolicy struct {
+	order *list.List              // pages from the most to the least recently used
+	pages map[int64]*list.Element // elements of order
+}
+
+// NewLRUPolicy returns a policy evicting the least recently used page, the default
+func NewLRUPolicy() EvictionPolicy {
+	return &lruPolicy{order: list.New(), pages: make(map[int64]*list.Element)}
+}
+
+// Added makes the page the most recently used
+func (p *lruPolicy) Added(pageID int64) {
+	p.pages[pageID] = p.order.PushFront(pageID)
+}
+
+// Accessed makes the page the most recently used
+func (p *lruPolicy) Accessed(pageID int64) {
+	if e, ok := p.pages[pageID]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+// Removed forgets the page
+func (p *lruPolicy) Removed(pageID int64) {
+	if e, ok := p.pages[pageID]; ok {
+		p.order.Remove(e)
+		delete(p.pages, pageID)
+	}
+}
+
+// Evict evicts the least recently used page which is not pinned
+func (p *lruPolicy) Evict(pinned func(pageID int64) bool) (int64, bool) {
+	return evictOldest(p.order, p.pages, pinned)
+}
+
+// evictOldest removes the element closest to the back of order which is not pinned
+func evictOldest(order *list.List, pages map[int64]*list.Element, pinned func(pageID int64) bool) (int64, bool) {
+	for e := order.Back(); e != nil; e = e.Prev() {
+		pageID := e.Value.(int64)
+		if !pinned(pageID) {
+			order.Remove(e)
+			delete(pages, pageID)
+			return pageID, true
+		}
+	}
+	return 0, false
+}
+
+// clockPolicy approximates LRU with a reference bit per page and a hand sweeping over the pages
+type clockPolicy struct {
+	ring  []clockEntry  // pages in the order they were added, freed slots are reused
+	slots map[int64]int // slot of every page in ring
+	free  []int         // free slots of ring
+	hand  int           // next slot looked at
+}
+
+// clockEntry is a slot of the clock
+type clockEntry struct {
+	pageID     int64 // the page
+	used       bool  // false if the slot is free
+	referenced bool  // set on access, cleared when the hand passes
+}
+
+// NewClockPolicy returns a policy evicting the first page the clock hand finds unreferenced since it last passed
+// Unlike LRU a scan touching every page once does not evict pages which were referenced again.
+func NewClockPolicy() EvictionPolicy {
+	return &clockPolicy{slots: make(map[int64]int)}
+}
+
+// Added places the page in a free slot
+func (p *clockPolicy) Added(pageID int64) {
+	entry := clockEntry{pageID: pageID, used: true}
+
+	if len(p.free) > 0 {
+		slot := p.free[len(p.free)-1]
+		p.free = p.free[:len(p.free)-1]
+		p.ring[slot] = entry
+		p.slots[pageID] = slot
+		return
+	}
+
+	p.slots[pageID] = len(p.ring)
+	p.ring = append(p.ring, entry)
+}
+
+// Accessed sets the reference bit of the page
+func (p *clockPolicy) Accessed(pageID int64) {
+	if slot, ok := p.slots[pageID]; ok {
+		p.ring[slot].referenced = true
+	}
+}
+
+// Removed frees the slot of the page
+func (p *clockPolicy) Removed(pageID int64) {
+	if slot, ok := p.slots[pageID]; ok {
+		p.ring[slot] = clockEntry{}
+		p.free = append(p.free, slot)
+		delete(p.slots, pageID)
+	}
+}
+
+// Evict advances the hand clearing reference bits until it finds an unreferenced page which is not pinned
+func (p *clockPolicy) Evict(pinned func(pageID int64) bool) (int64, bool) {
+	// two sweeps clear every reference bit, a third finds nothing only if every page is pinned
+	for steps := 0; steps < 2*len(p.ring)+1 && len(p.ring) > 0; steps++ {
+		slot := p.hand % len(p.ring)
+		p.hand = slot + 1
+
+		entry := &p.ring[slot]
+		if !entry.used || pinned(entry.pageID) {
+			continue
+		}
+
+		if entry.referenced {
+			entry.referenced = false
+			continue
+		}
+
+		pageID := entry.pageID
+		p.Removed(pageID)
+		return pageID, true
+	}
+
+	return 0, false
+}
+
+// twoQueuePolicy is the 2Q policy, pages seen once wait in a FIFO queue and only pages seen again reach the LRU queue
+type twoQueuePolicy struct {
+	in        *list.List              // pages seen once, oldest at the back
+	inPages   map[int64]*list.Element // elements of in
+	out       *list.List              // ids of pages recently evicted from in, oldest at the back
+	outPages  map[int64]*list.Element // elements of out
+	main      *list.List              // pages seen again, least recently used at the back
+	mainPages map[int64]*list.Element // elements of main
+}
+
+// New2QPolicy returns the scan resistant 2Q policy
+// A quarter of the cache holds pages seen once, pages accessed again after they were evicted from it are kept in an LRU queue,
+// so a large scan only cycles through the first queue and leaves frequently used pages cached.
+func New2QPolicy() EvictionPolicy {
+	return &twoQueuePolicy{
+		in:        list.New(),
+		inPages:   make(map[int64]*list.Element),
+		out:       list.New(),
+		outPages:  make(map[int64]*list.Element),
+		main:      list.New(),
+		mainPages: make(map[int64]*list.Element),
+	}
+}
+
+// Added queues a new page, a page remembered from the first queue goes to the LRU queue
+func (p *twoQueuePolicy) Added(pageID int64) {
+	if e, ok := p.outPages[pageID]; ok {
+		p.out.Remove(e)
+		delete(p.outPages, pageID)
+		p.mainPages[pageID] = p.main.PushFront(pageID)
+		return
+	}
+
+	p.inPages[pageID] = p.in.PushFront(pageID)
+}
+
+// Accessed makes a page of the LRU queue the most recently used, pages of the first queue keep their place
+func (p *twoQueuePolicy) Accessed(pageID int64) {
+	if e, ok := p.mainPages[pageID]; ok {
+		p.main.MoveToFront(e)
+	}
+}
+
+// Removed forgets the page
+func (p *twoQueuePolicy) Removed(pageID int64) {
+	if e, ok := p.inPages[pageID]; ok {
+		p.in.Remove(e)
+		delete(p.inPages, pageID)
+	}
+
+	if e, ok := p.mainPages[pageID]; ok {
+		p.main.Remove(e)
+		delete(p.mainPages, pageID)
+	}
+
+	if e, ok := p.outPages[pageID]; ok {
+		p.out.Remove(e)
+		delete(p.outPages, pageID)
+	}
+}
+
+// Evict evicts from the first queue while it holds more than a quarter of the pages, from the LRU queue otherwise
+func (p *twoQueuePolicy) Evict(pinned func(pageID int64) bool) (int64, bool) {
+	resident := p.in.Len() + p.main.Len()
+
+	first, second := p.main, p.in
+	if p.in.Len() > max(resident/4, 1) || p.main.Len() == 0 {
+		first, second = p.in, p.main
+	}
+
+	for _, queue := range []*list.List{first, second} {
+		pages := p.mainPages
+		if queue == p.in {
+			pages = p.inPages
+		}
+
+		pageID, ok := evictOldest(queue, pages, pinned)
+		if !ok {
+			continue
+		}
+
+		// pages evicted from the first queue are remembered for half the cache size
+		if queue == p.in {
+			p.outPages[pageID] = p.out.PushFront(pageID)
+			for p.out.Len() > max(resident/2, 1) {
+				oldest := p.out.Back()
+				p.out.Remove(oldest)
+				delete(p.outPages, oldest.Value.(int64))
+			}
+		}
+
+		return pageID, true
+	}
+
+	return 0, false
+}
+
+// lfuPolicy evicts the least frequently used page, the least recently used one among pages used as often
+type lfuPolicy struct {
+	counts  map[int64]int           // accesses of every page
+	pages   map[int64]*list.Element // element of every page in its bucket
+	buckets map[int]*list.List      // pages by access count, least recently used at the back
+}
+
+// NewLFUPolicy returns a policy evicting the least frequently used page
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{counts: make(map[int64]int), pages: make(map[int64]*list.Element), buckets: make(map[int]*list.List)}
+}
+
+// Added counts the first access of the page
+func (p *lfuPolicy) Added(pageID int64) {
+	p.counts[pageID] = 1
+	p.pages[pageID] = p.bucket(1).PushFront(pageID)
+}
+
+// Accessed moves the page to the bucket of its new count
+func (p *lfuPolicy) Accessed(pageID int64) {
+	count, ok := p.counts[pageID]
+	if !ok {
+		return
+	}
+
+	p.unlink(pageID, count)
+	p.counts[pageID] = count + 1
+	p.pages[pageID] = p.bucket(count + 1).PushFront(pageID)
+}
+
+// Removed forgets the page
+func (p *lfuPolicy) Removed(pageID int64) {
+	if count, ok := p.counts[pageID]; ok {
+		p.unlink(pageID, count)
+		delete(p.counts, pageID)
+		delete(p.pages, pageID)
+	}
+}
+
+// Evict evicts the least recently used page of the lowest count which is not pinned
+func (p *lfuPolicy) Evict(pinned func(pageID int64) bool) (int64, bool) {
+	counts := make([]int, 0, len(p.buckets))
+	for count := range p.buckets {
+		counts = append(counts, count)
+	}
+	slices.Sort(counts)
+
+	for _, count := range counts {
+		bucket := p.buckets[count]
+
+		pageID, ok := evictOldest(bucket, p.pages, pinned)
+		if !ok {
+			continue
+		}
+
+		if bucket.Len() == 0 {
+			delete(p.buckets, count)
+		}
+		delete(p.counts, pageID)
+
+		return pageID, true
+	}
+
+	return 0, false
+}
+
+// bucket returns the bucket of pages accessed count times
+func (p *lfuPolicy) bucket(count int) *list.List {
+	bucket, ok := p.buckets[count]
+	if !ok {
+		bucket = list.New()
+		p.buckets[count] = bucket
+	}
+	return bucket
+}
+
+// unlink removes the page from its bucket
+func (p *lfuPolicy) unlink(pageID int64, count int) {
+	bucket := p.buckets[count]
+	bucket.Remove(p.pages[pageID])
+	if bucket.Len() == 0 {
+		delete(p.buckets, count)
+	}
+}
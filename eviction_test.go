@@ -0,0 +1,182 @@
+// Package btree
+// page eviction policy tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEvictionPolicies(t *testing.T) {
+	policies := map[string]func() EvictionPolicy{
+		"lru":   NewLRUPolicy,
+		"clock": NewClockPolicy,
+		"2q":    New2QPolicy,
+		"lfu":   NewLFUPolicy,
+	}
+
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			p := policy()
+			for pageID := int64(0); pageID < 10; pageID++ {
+				p.Added(pageID)
+			}
+
+			p.Removed(3)
+
+			pinned := func(pageID int64) bool {
+				return pageID%2 == 0
+			}
+
+			// every page which is not pinned is evicted once, removed pages never
+			evicted := make(map[int64]bool)
+			for {
+				pageID, ok := p.Evict(pinned)
+				if !ok {
+					break
+				}
+
+				if pinned(pageID) || pageID == 3 || evicted[pageID] {
+					t.Fatalf("unexpected eviction of page %d", pageID)
+				}
+				evicted[pageID] = true
+			}
+
+			if len(evicted) != 4 {
+				t.Fatalf("expected 4 evictions, got %v", evicted)
+			}
+
+			_, ok := p.Evict(func(int64) bool { return false })
+			if !ok {
+				t.Fatal("expected the pinned pages to be evicted once unpinned")
+			}
+		})
+	}
+}
+
+func TestEvictionPolicies_Order(t *testing.T) {
+	unpinned := func(int64) bool { return false }
+
+	lru := NewLRUPolicy()
+	lru.Added(1)
+	lru.Added(2)
+	lru.Accessed(1)
+	if pageID, _ := lru.Evict(unpinned); pageID != 2 {
+		t.Fatalf("expected lru to evict page 2, got %d", pageID)
+	}
+
+	clock := NewClockPolicy()
+	clock.Added(1)
+	clock.Added(2)
+	clock.Accessed(1)
+	if pageID, _ := clock.Evict(unpinned); pageID != 2 {
+		t.Fatalf("expected clock to evict page 2, got %d", pageID)
+	}
+
+	lfu := NewLFUPolicy()
+	lfu.Added(1)
+	lfu.Added(2)
+	lfu.Accessed(1)
+	lfu.Accessed(2)
+	lfu.Accessed(2)
+	if pageID, _ := lfu.Evict(unpinned); pageID != 1 {
+		t.Fatalf("expected lfu to evict page 1, got %d", pageID)
+	}
+}
+
+func TestObjectPager_EvictionPolicy(t *testing.T) {
+	// misses returns the misses of reading a hot set again after a scan over every page
+	misses := func(policy EvictionPolicy) uint64 {
+		store := &mapObjectStore{objects: make(map[string][]byte)}
+		for pageID := 0; pageID < 2000; pageID++ {
+			store.objects[fmt.Sprintf("btree/%d", pageID)] = []byte{byte(pageID)}
+		}
+
+		pager, err := OpenObjectPager(store, "btree/", 40, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if policy != nil {
+			pager.SetEvictionPolicy(policy)
+		}
+
+		read := func(pageID int64) {
+			_, err := pager.ReadPage(pageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the hot set is read again and again between reads of other pages
+		cold := int64(1000)
+		for round := 0; round < 50; round++ {
+			for hot := int64(0); hot < 10; hot++ {
+				read(hot)
+			}
+
+			for i := 0; i < 20; i++ {
+				read(cold)
+				cold++
+			}
+		}
+
+		for pageID := int64(10); pageID < 1000; pageID++ {
+			read(pageID)
+		}
+
+		before := pager.CacheStats()
+		if before.Pages > 40 {
+			t.Fatalf("expected at most 40 cached pages, got %d", before.Pages)
+		}
+
+		for hot := int64(0); hot < 10; hot++ {
+			read(hot)
+		}
+
+		return pager.CacheStats().Misses - before.Misses
+	}
+
+	if m := misses(nil); m != 10 {
+		t.Fatalf("expected the scan to evict the hot set from lru, got %d misses", m)
+	}
+
+	if m := misses(NewClockPolicy()); m != 10 {
+		t.Fatalf("expected the scan to evict the hot set from clock, got %d misses", m)
+	}
+
+	for name, policy := range map[string]EvictionPolicy{"2q": New2QPolicy(), "lfu": NewLFUPolicy()} {
+		if m := misses(policy); m != 0 {
+			t.Fatalf("expected %s to keep the hot set cached through the scan, got %d misses", name, m)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package btree
+// range export and import
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// ExportFormat is the encoding of the keys written by ExportRange
+type ExportFormat int
+
+const (
+	EXPORT_MSGPACK ExportFormat = iota // Keys encoded as msgpack records back to back, like they are stored in a page
+	EXPORT_JSON                        // One JSON document per key and line, see Key.MarshalJSON
+)
+
+// exportMagic starts every export followed by the name of the format and a newline
+const exportMagic = "btree-export 1 "
+
+// ErrInvalidExport is returned by ImportInto for a stream which was not written by ExportRange
+var ErrInvalidExport = errors.New("invalid export")
+
+// String returns the name of the format written in the header of an export
+func (f ExportFormat) String() string {
+	switch f {
+	case EXPORT_MSGPACK:
+		return "msgpack"
+	case EXPORT_JSON:
+		return "json"
+	}
+	return fmt.Sprintf("ExportFormat(%d)", int(f))
+}
+
+// ExportRange writes the keys within [start, end] in key order to w and returns the number of keys written
+// A nil start or end leaves the range open on that side.  Values are read from the value log, values, metadata, versions
+// and expiry times are exported.  Expired and deleted keys and keys storing a bitmap (see AddValue) are skipped.  The keys are written as they are stored, after the key transform.
+func (b *BTree) ExportRange(w io.Writer, start, end []byte, format ExportFormat) (int, error) {
+	if format != EXPORT_MSGPACK && format != EXPORT_JSON {
+		return 0, fmt.Errorf("unknown export format %v", format)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	_, err := bw.WriteString(exportMagic + format.String() + "\n")
+	if err != nil {
+		return 0, err
+	}
+
+	var encode func(k *Key) error
+	if format == EXPORT_JSON {
+		enc := json.NewEncoder(bw)
+		encode = func(k *Key) error {
+			return enc.Encode(k)
+		}
+	} else {
+		enc := codec.NewEncoder(bw, msgpackHandle)
+		encode = func(k *Key) error {
+			return enc.Encode(canonicalKey(k))
+		}
+	}
+
+	count := 0
+	err = b.scanRangeOpt(start, end, nil, func(k *Key) error {
+		// the bitmap of a key storing integer values is a page of this file, such keys are not exported
+		if k.B != 0 {
+			return nil
+		}
+
+		resolved, err := b.resolveKey(k)
+		if err != nil {
+			return err
+		}
+
+		// the hash set page of the key belongs to this file
+		count++
+		return encode(&Key{K: resolved.K, V: resolved.V, E: resolved.E, M: resolved.M, Ver: resolved.Ver, R: resolved.R, F: resolved.F})
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, bw.Flush()
+}
+
+// ImportInto inserts every key of an export written by ExportRange and returns the number of keys imported
+// Values are appended to keys which already exist, metadata, versions and expiry times are kept.
+// Keys are transformed with the key transform of this btree.  Every key is imported atomically.
+func (b *BTree) ImportInto(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.ReadString('\n')
+	if err != nil || !strings.HasPrefix(header, exportMagic) {
+		return 0, ErrInvalidExport
+	}
+
+	var decode func(k *Key) error
+	switch strings.TrimSuffix(strings.TrimPrefix(header, exportMagic), "\n") {
+	case EXPORT_JSON.String():
+		dec := json.NewDecoder(br)
+		decode = func(k *Key) error {
+			return dec.Decode(k)
+		}
+	case EXPORT_MSGPACK.String():
+		dec := codec.NewDecoder(br, msgpackHandle)
+		decode = func(k *Key) error {
+			return dec.Decode((*keyRecord)(k))
+		}
+	default:
+		return 0, ErrInvalidExport
+	}
+
+	count := 0
+	for {
+		k := &Key{}
+
+		err = decode(k)
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, fmt.Errorf("%w: key %d: %v", ErrInvalidExport, count, err)
+		}
+
+		// the values were resolved when exporting
+		k.K, k.Ptr = b.transformKey(k.K), nil
+
+		err = b.rollForwardKey(b, k)
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+}
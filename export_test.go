@@ -0,0 +1,176 @@
+// Package btree
+// range export and import tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBTree_ExportRange(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+	defer os.Remove("btree.db.ttl")
+	defer os.Remove("btree.db.ttl.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	for i := 0; i < 100; i++ {
+		err = btree.PutMulti([]byte(fmt.Sprintf("tenant%d/%03d", i%2, i)), []byte("small"), bytes.Repeat([]byte("large"), 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.PutWithMeta([]byte("tenant1/meta"), []byte("value"), ValueMeta{Flags: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutWithTTL([]byte("tenant1/ttl"), []byte("value"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Delete([]byte("tenant1/001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []ExportFormat{EXPORT_MSGPACK, EXPORT_JSON} {
+		t.Run(format.String(), func(t *testing.T) {
+			defer os.Remove("imported.db")
+			defer os.Remove("imported.db.del")
+			defer os.Remove("imported.db.ttl")
+			defer os.Remove("imported.db.ttl.del")
+
+			buf := &bytes.Buffer{}
+
+			n, err := btree.ExportRange(buf, []byte("tenant1/"), []byte("tenant1/~"), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 50 keys of the tenant, one deleted, and the keys with metadata and a ttl
+			if n != 51 {
+				t.Fatalf("expected 51 keys exported, got %d", n)
+			}
+
+			imported, err := Open("imported.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer imported.Close()
+
+			n, err = imported.ImportInto(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != 51 {
+				t.Fatalf("expected 51 keys imported, got %d", n)
+			}
+
+			expected, err := btree.Range([]byte("tenant1/"), []byte("tenant1/~"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := imported.Range(nil, []byte("~"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(expected) {
+				t.Fatalf("expected %d keys, got %d", len(expected), len(got))
+			}
+
+			for i := range got {
+				g, e := got[i].(*Key), expected[i].(*Key)
+				if !bytes.Equal(g.K, e.K) || len(g.V) != len(e.V) || !bytes.Equal(g.V[len(g.V)-1], e.V[len(e.V)-1]) || g.E != e.E {
+					t.Fatalf("expected %s, got %s", e.K, g.K)
+				}
+			}
+
+			key, err := imported.GetWithMeta([]byte("tenant1/meta"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key.M[0] == nil || key.M[0].Flags != 7 {
+				t.Fatalf("expected the metadata to be imported, got %v", key.M)
+			}
+
+			// other tenants are not exported
+			key, err = imported.Get([]byte("tenant0/000"))
+			if err != nil || key != nil {
+				t.Fatalf("expected no key of another tenant, got %v %v", key, err)
+			}
+		})
+	}
+}
+
+func TestBTree_ImportInto_Invalid(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	_, err = btree.ImportInto(strings.NewReader("not an export\n"))
+	if !errors.Is(err, ErrInvalidExport) {
+		t.Fatalf("expected ErrInvalidExport, got %v", err)
+	}
+
+	_, err = btree.ImportInto(strings.NewReader(exportMagic + "json\n{\"key\": 1}\n"))
+	if !errors.Is(err, ErrInvalidExport) {
+		t.Fatalf("expected ErrInvalidExport for a corrupt key, got %v", err)
+	}
+
+	_, err = btree.ExportRange(&bytes.Buffer{}, nil, nil, ExportFormat(5))
+	if err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
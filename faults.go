@@ -0,0 +1,136 @@
+// Package btree
+// fault injection
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"sync"
+)
+
+// errInjectedFault is returned by page files once an injected crash happened
+var errInjectedFault = errors.New("injected fault")
+
+// faultPlan describes the faults injected into the files of a pager, see PagerOptions.faults
+// Tests use it to crash a btree at a precise write and validate recovery deterministically.  The plan is shared by every file
+// it wraps so writes are counted across them, like a process crashing while writing to several files.
+type faultPlan struct {
+	lock       sync.Mutex
+	crashAt    int  // the write which crashes counting from 1, 0 never crashes
+	tornBytes  int  // bytes of the crashing write which still reach the file, simulating a torn write
+	shortReads int  // reads which transfer only half of their buffer, the pager retries the rest
+	writes     int  // writes seen so far
+	crashed    bool // true once the crash happened, every later write, sync and truncate fails
+}
+
+// wrap returns file with the plan's faults injected
+func (f *faultPlan) wrap(file pageFile) pageFile {
+	return &faultFile{pageFile: file, plan: f}
+}
+
+// crash arms the plan to crash at the nth write from now
+func (f *faultPlan) crash(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.crashAt = f.writes + n
+}
+
+// hasCrashed returns true once the crash happened
+func (f *faultPlan) hasCrashed() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.crashed
+}
+
+// faultFile is a page file failing as described by a fault plan
+type faultFile struct {
+	pageFile
+	plan *faultPlan
+}
+
+// ReadAt reads from the file, short reads transfer half of the buffer without an error
+func (f *faultFile) ReadAt(b []byte, offset int64) (int, error) {
+	f.plan.lock.Lock()
+	short := f.plan.shortReads > 0 && len(b) > 1
+	if short {
+		f.plan.shortReads--
+	}
+	f.plan.lock.Unlock()
+
+	if short {
+		b = b[:len(b)/2]
+	}
+
+	return f.pageFile.ReadAt(b, offset)
+}
+
+// WriteAt writes to the file until the crash, the crashing write only writes its torn bytes
+func (f *faultFile) WriteAt(b []byte, offset int64) (int, error) {
+	f.plan.lock.Lock()
+	defer f.plan.lock.Unlock()
+
+	if f.plan.crashed {
+		return 0, errInjectedFault
+	}
+
+	f.plan.writes++
+	if f.plan.writes != f.plan.crashAt {
+		return f.pageFile.WriteAt(b, offset)
+	}
+
+	f.plan.crashed = true
+
+	torn := min(f.plan.tornBytes, len(b))
+	n, err := f.pageFile.WriteAt(b[:torn], offset)
+	if err != nil {
+		return n, err
+	}
+
+	return n, errInjectedFault
+}
+
+// Sync flushes the file, it fails once the crash happened
+func (f *faultFile) Sync() error {
+	if f.plan.hasCrashed() {
+		return errInjectedFault
+	}
+	return f.pageFile.Sync()
+}
+
+// Truncate truncates the file, it fails once the crash happened
+func (f *faultFile) Truncate(size int64) error {
+	if f.plan.hasCrashed() {
+		return errInjectedFault
+	}
+	return f.pageFile.Truncate(size)
+}
@@ -0,0 +1,224 @@
+// Package btree
+// fault injection tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFaultFile(t *testing.T) {
+	plan := &faultPlan{crashAt: 3, tornBytes: 2}
+	file := plan.wrap(newMemFile())
+
+	for i := 0; i < 2; i++ {
+		_, err := file.WriteAt([]byte("abcd"), int64(i*4))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := file.WriteAt([]byte("efgh"), 8)
+	if !errors.Is(err, errInjectedFault) || n != 2 {
+		t.Fatalf("expected a torn write of 2 bytes, got %d %v", n, err)
+	}
+
+	_, err = file.WriteAt([]byte("ijkl"), 12)
+	if !errors.Is(err, errInjectedFault) {
+		t.Fatalf("expected writes to fail after the crash, got %v", err)
+	}
+
+	if !errors.Is(file.Sync(), errInjectedFault) || !errors.Is(file.Truncate(0), errInjectedFault) {
+		t.Fatal("expected syncs and truncates to fail after the crash")
+	}
+
+	// reads still see what reached the file
+	data := make([]byte, 10)
+	_, err = file.ReadAt(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "abcdabcdef" {
+		t.Fatalf("expected the torn bytes in the file, got %q", data)
+	}
+
+	// short reads are continued by the retries
+	plan.shortReads = 2
+	retried := newRetryFile(file, 3, time.Millisecond)
+
+	data = make([]byte, 8)
+	n, err = retried.ReadAt(data, 0)
+	if err != nil || n != 8 || string(data) != "abcdabcd" {
+		t.Fatalf("expected the short reads to be continued, got %d %q %v", n, data, err)
+	}
+
+	if plan.shortReads != 0 {
+		t.Fatalf("expected every short read to happen, %d left", plan.shortReads)
+	}
+}
+
+func TestBTree_SafeWrites_Crash(t *testing.T) {
+	for _, torn := range []int{0, 100} {
+		crashed := 0
+
+		for n := 1; ; n++ {
+			done := crashTest(t, n, torn)
+			if done {
+				break
+			}
+			crashed++
+		}
+
+		if crashed == 0 {
+			t.Fatal("expected the operations to crash")
+		}
+	}
+}
+
+// crashTest crashes a btree with safe writes at the nth write of a put and a delete and checks the reopened btree
+// true is returned once both operations finished before the crash
+func crashTest(t *testing.T, n, torn int) bool {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.shadow")
+
+	plan := &faultPlan{tornBytes: torn}
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{SafeWrites: true, PagerOptions: &PagerOptions{faults: plan}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan.crash(n)
+
+	putErr := btree.Put([]byte("new"), []byte("value"))
+	deleteErr := btree.Delete([]byte("050"))
+
+	if (putErr != nil || deleteErr != nil) && !plan.hasCrashed() {
+		t.Fatalf("unexpected errors %v %v", putErr, deleteErr)
+	}
+
+	// the files are closed as they are after a crash
+	btree.Close()
+
+	btree, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{SafeWrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	checkBTree(t, btree)
+
+	for i := 0; i < 100; i++ {
+		key, err := btree.Get([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the delete is applied completely or not at all, nothing is written after a crash during the put
+		if i == 50 && key == nil {
+			if putErr != nil {
+				t.Fatalf("crash at write %d: the delete ran after the failed put", n)
+			}
+			continue
+		}
+
+		if i == 50 && deleteErr == nil {
+			t.Fatalf("crash at write %d: expected the published delete to survive", n)
+		}
+
+		if key == nil || string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("crash at write %d: expected key %03d to be intact", n, i)
+		}
+	}
+
+	key, err := btree.Get([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil && string(key.V[0]) != "value" {
+		t.Fatalf("crash at write %d: expected the new key to be intact", n)
+	}
+
+	if putErr == nil && key == nil {
+		t.Fatalf("crash at write %d: expected the published put to survive", n)
+	}
+
+	return !plan.hasCrashed()
+}
+
+func TestPager_ShortReads(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	plan := &faultPlan{}
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{PagerOptions: &PagerOptions{faults: plan}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	for i := 0; i < 200; i++ {
+		err = btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		// the first read of every get transfers half of the page
+		plan.shortReads = 1
+
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected key %d to be read completely", i)
+		}
+	}
+}
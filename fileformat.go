@@ -0,0 +1,344 @@
+// Package btree
+// file format description
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FormatVersion is the version of the on-disk format described by Format, it changes whenever a byte is read differently
+const FormatVersion = 1
+
+// FileFormat is a machine-readable description of the files of a btree for tools which parse them directly
+type FileFormat struct {
+	Version   int           `json:"version"`    // Version of the format
+	Layout    Layout        `json:"layout"`     // Page layout of the btree file
+	ByteOrder string        `json:"byte_order"` // Byte order of binary integers
+	Page      []FormatField `json:"page"`       // A page slot of the btree file, pages are stored back to back from offset 0
+	Node      []FormatField `json:"node"`       // The msgpack map a node is encoded as, stored in the data of a page and its overflow pages
+	Key       []FormatField `json:"key"`        // The msgpack map a key of a node is encoded as, fields with zero values are omitted
+	ValueMeta []FormatField `json:"value_meta"` // The msgpack map the metadata of a value is encoded as
+	Files     []FormatFile  `json:"files"`      // The btree file and the files stored next to it
+}
+
+// FormatField describes a field of a record stored on disk
+type FormatField struct {
+	Name        string `json:"name"`        // Name of the field, the map key for msgpack fields
+	Offset      int    `json:"offset"`      // Offset of the field within its record, -1 if the field has no fixed offset
+	Size        int    `json:"size"`        // Size of the field in bytes, 0 if the size varies
+	Encoding    string `json:"encoding"`    // How the field is encoded
+	Description string `json:"description"` // What the field holds
+}
+
+// FormatFile describes a file of a btree by the suffix appended to the name of the btree file
+type FormatFile struct {
+	Suffix      string        `json:"suffix"`           // Suffix of the file, empty for the btree file itself
+	Encoding    string        `json:"encoding"`         // How the file is encoded
+	Description string        `json:"description"`      // What the file holds and when it exists
+	Fields      []FormatField `json:"fields,omitempty"` // Fields of the records of binary files
+}
+
+// Format returns the description of the on-disk format written by this version
+// The description is deterministic, encoding it as json always gives the same bytes
+func Format() *FileFormat {
+	layout := CurrentLayout()
+
+	return &FileFormat{
+		Version:   FormatVersion,
+		Layout:    layout,
+		ByteOrder: "big-endian",
+		Page: []FormatField{
+			{Name: "header", Offset: 0, Size: layout.HeaderSize, Encoding: "ascii, null padded", Description: `"-1" for the last page of a chain, "c<page>" for the head of an overflow chain continued at page, "<page>" for an overflow page continued at page, empty for a page never written`},
+			{Name: "data", Offset: layout.HeaderSize, Size: layout.PageSize, Encoding: "bytes, null padded", Description: "a chunk of the encoded node, the data of a chain is the concatenation of its chunks, data starting with the byte 0xc1 is the node compressed with raw deflate (RFC 1951) and the preset dictionary in .dict, decompressed or not data starting with the byte 0xc7 is followed by a node with fixed size keys: a msgpack map of Page, Dense (the keys back to back, the key size is its length divided by the number of keys), Keys (keys without K), Children and Leaf"},
+		},
+		Node: []FormatField{
+			{Name: "Page", Offset: -1, Encoding: "msgpack int", Description: "page of the node, 0 for the root"},
+			{Name: "Keys", Offset: -1, Encoding: "msgpack array of keys", Description: "keys of the node in ascending byte order"},
+			{Name: "Children", Offset: -1, Encoding: "msgpack array of ints", Description: "pages of the children, one more than keys for internal nodes, nil for leaves"},
+			{Name: "Leaf", Offset: -1, Encoding: "msgpack bool", Description: "true for leaves"},
+		},
+		Key: []FormatField{
+			{Name: "K", Offset: -1, Encoding: "msgpack bytes", Description: "the key"},
+			{Name: "V", Offset: -1, Encoding: "msgpack array of bytes", Description: "the values, a value marked in Ptr holds a value log pointer"},
+			{Name: "Ptr", Offset: -1, Encoding: "msgpack array of bools", Description: "marks values stored in the value log, a pointer is an 8 byte offset followed by a 4 byte length"},
+			{Name: "E", Offset: -1, Encoding: "msgpack int", Description: "expiry time in unix nanoseconds"},
+			{Name: "M", Offset: -1, Encoding: "msgpack array of value metadata or nil", Description: "metadata of the values"},
+			{Name: "Ver", Offset: -1, Encoding: "msgpack array of uints", Description: "versions of the values, 0 for unversioned values"},
+			{Name: "D", Offset: -1, Encoding: "msgpack bool", Description: "tombstone, the key was deleted and waits to be purged"},
+			{Name: "H", Offset: -1, Encoding: "msgpack int", Description: "page of the hash set of the values, the uvarint length of the ValueHash name, the name, the uvarint number of hashes and the sorted hashes as little endian uint64s"},
+			{Name: "R", Offset: -1, Encoding: "msgpack uint", Description: "version of the key, incremented by every change of the key when key versions are tracked"},
+			{Name: "F", Offset: -1, Encoding: "msgpack uint", Description: "application defined flags of the key"},
+			{Name: "B", Offset: -1, Encoding: "msgpack int", Description: "page of the roaring bitmap of the integer values of the key, the uvarint number of containers followed by every container as its little endian uint16 upper 16 bits, a kind byte (0 array, 1 bitset), the uvarint number of values and either the sorted lower 16 bits as little endian uint16s or the 1024 word bitset as little endian uint64s"},
+			{Name: "W", Offset: -1, Encoding: "msgpack uint", Description: "highest version assigned to a value of the key, versions of removed values are not assigned again"},
+		},
+		ValueMeta: []FormatField{
+			{Name: "Created", Offset: -1, Encoding: "msgpack int", Description: "creation time in unix nanoseconds"},
+			{Name: "Flags", Offset: -1, Encoding: "msgpack uint", Description: "application defined flags"},
+		},
+		Files: []FormatFile{
+			{Suffix: "", Encoding: "pages", Description: "the nodes of the tree, see page, the root is stored at page 0"},
+			{Suffix: ".del", Encoding: "ascii", Description: "comma separated decimal ids of the deleted pages, optionally within square brackets, the ids of deleted overflow pages carry a plus sign"},
+			{Suffix: ".del.log", Encoding: "records", Description: "changes to the deleted pages since .del was last written, replayed over .del when opening and removed on close, a torn last record is ignored", Fields: []FormatField{
+				{Name: "page", Offset: 0, Size: 8, Encoding: "int64", Description: "page added to or taken from the deleted pages"},
+				{Name: "op", Offset: 8, Size: 1, Encoding: "uint8", Description: "1 if the page was deleted, 2 if it was reused"},
+			}},
+			{Suffix: ".format", Encoding: "ascii", Description: "page_size=<n> and header_size=<n> lines, only written for layouts other than a page size of 1024 and a header size of 16"},
+			{Suffix: ".transform", Encoding: "ascii", Description: "name of the key transform followed by a newline, only written for btrees with a key transform"},
+			{Suffix: ".dict", Encoding: "bytes", Description: "preset deflate dictionary of compressed nodes, only written for btrees opened with a dictionary"},
+			{Suffix: ".keysize", Encoding: "ascii", Description: "decimal fixed key size followed by a newline, only written for btrees opened with a fixed key size"},
+			{Suffix: ".seq", Encoding: "pages", Description: "sequences of NextSequence, page 0 holds a msgpack map of the sequence names to the first id which was not reserved, with its own .seq.del file"},
+			{Suffix: ".codec", Encoding: "ascii", Description: "name of the codec nodes are stored with followed by a newline, see RegisterCodec, only written for codecs other than msgpack whose nodes are stored behind a 4 byte big endian length"},
+			{Suffix: ".ns", Encoding: "pages", Description: "usage of the namespaces of NamespacedTree, page 0 holds a msgpack map with Clean, true if the btree was closed, and Usage, the prefixes to their keys, bytes and quota, with its own .ns.del file"},
+			{Suffix: ".heat", Encoding: "msgpack", Description: "sampled node reads per page written on close when Options.Heatmap is set, a msgpack map of Counts, the page numbers to their counts"},
+			{Suffix: ".ops", Encoding: "records", Description: "operation ids applied by PutIdempotent, 8 byte big endian ids appended back to back, oldest first, rewritten with the remembered ids once it holds twice Options.IdempotencyWindow"},
+			{Suffix: ".vlog", Encoding: "records", Description: "values stored outside of the tree, records are appended back to back", Fields: []FormatField{
+				{Name: "checksum", Offset: 0, Size: 4, Encoding: "uint32", Description: "crc32 (IEEE) of the value"},
+				{Name: "length", Offset: 4, Size: 4, Encoding: "uint32", Description: "length of the value"},
+				{Name: "value", Offset: 8, Encoding: "bytes", Description: "the value"},
+			}},
+			{Suffix: ".shadow", Encoding: "records followed by a trailer", Description: "journal of the operation being published with safe writes, empty when no operation is in flight, .prepare holds a prepared transaction in the same format", Fields: []FormatField{
+				{Name: "page", Offset: 0, Size: 8, Encoding: "int64", Description: "page written, freed or allocated by the operation"},
+				{Name: "length", Offset: 8, Size: 4, Encoding: "uint32", Description: "length of the page data, 4294967295 for a freed page and 4294967294 for an allocated page which carry no data"},
+				{Name: "data", Offset: 12, Encoding: "bytes", Description: "the page data"},
+				{Name: "trailer length", Offset: -1, Size: 4, Encoding: "uint32", Description: "length of the records, the second to last 4 bytes of the file"},
+				{Name: "trailer checksum", Offset: -1, Size: 4, Encoding: "uint32", Description: "crc32 (IEEE) of the records, the last 4 bytes of the file, a mismatch means the journal is incomplete"},
+			}},
+			{Suffix: ".vlog.compact", Encoding: "records", Description: "value log being compacted in the format of .vlog, renamed over .vlog once the compaction is journaled in .vlog.swap, removed on open if it was not"},
+			{Suffix: ".vlog.swap", Encoding: "records followed by a trailer", Description: "journal of a value log compaction holding the nodes pointing into .vlog.compact in the format of .shadow, only present while the logs are swapped or after a crash, finished and removed on open"},
+			{Suffix: ".split", Encoding: "records followed by a trailer", Description: "journal of a root split of a btree without safe writes holding the new page 0 in the format of .shadow, only present while the root is written or after a crash, replayed and removed on open"},
+			{Suffix: ".commit", Encoding: "binary", Description: "count of the operations published by a btree opened with followers enabled, odd while an operation writes its pages", Fields: []FormatField{
+				{Name: "count", Offset: 0, Size: 8, Encoding: "uint64", Description: "the commit count"},
+			}},
+			{Suffix: ".segments", Encoding: "ascii", Description: "pages per segment in decimal, only present once the file is split into segments"},
+			{Suffix: ".seg<n>", Encoding: "pages", Description: "segment n of a segmented file holding pages n*segment pages onwards in the page format, the file itself is segment 0"},
+			{Suffix: ".ingest", Encoding: "records", Description: "spill log of an ingest holding every put in put order, only present while an ingest is in progress or after a crash, removed by FinishIngest", Fields: []FormatField{
+				{Name: "key length", Offset: 0, Encoding: "uvarint", Description: "length of the key"},
+				{Name: "key", Offset: -1, Encoding: "bytes", Description: "the key"},
+				{Name: "value length", Offset: -1, Encoding: "uvarint", Description: "length of the value"},
+				{Name: "value", Offset: -1, Encoding: "bytes", Description: "the value"},
+			}},
+			{Suffix: ".rangedel", Encoding: "records", Description: "key ranges deleted by TombstoneRange in key order, the keys from start up to but excluding end are hidden, removed once PurgeTombstones purged their keys", Fields: []FormatField{
+				{Name: "start length", Offset: 0, Encoding: "uvarint", Description: "length of the first key of the range"},
+				{Name: "start", Offset: -1, Encoding: "bytes", Description: "the first key of the range"},
+				{Name: "end length", Offset: -1, Encoding: "uvarint", Description: "length of the key ending the range"},
+				{Name: "end", Offset: -1, Encoding: "bytes", Description: "the key ending the range, not part of it"},
+			}},
+			{Suffix: ".epoch", Encoding: "binary", Description: "backup epoch of every page, only written once a backup was taken", Fields: []FormatField{
+				{Name: "clean", Offset: 0, Size: 1, Encoding: "uint8", Description: "1 if the pager was closed cleanly"},
+				{Name: "current", Offset: 1, Size: 8, Encoding: "uint64", Description: "epoch of pages written now"},
+				{Name: "since", Offset: 9, Size: 8, Encoding: "uint64", Description: "every page counts as written in this epoch"},
+				{Name: "count", Offset: 17, Size: 8, Encoding: "uint64", Description: "number of page entries"},
+				{Name: "entries", Offset: 25, Encoding: "pairs of uint64", Description: "page id and epoch of every page entry"},
+			}},
+			{Suffix: ".height", Encoding: "ascii", Description: "height of the tree and the crc32 of the children and separator keys of the root it was measured for in decimal, ignored unless the checksum matches the root"},
+			{Suffix: ".ttl", Encoding: "btree", Description: "expiry index, a btree in this format keyed by the 8 byte expiry time followed by the key"},
+			{Suffix: ".lease", Encoding: "ascii", Description: "owner id and expiry time in unix nanoseconds on two lines, empty if the lease is free"},
+		},
+	}
+}
+
+// VerifyFormat confirms the btree file at name was written in the given format version
+// The layout, every page header, the root node (decompressed with the .dict file if it is compressed), the deleted pages and the value log records are checked.  Errors wrap ErrIncompatibleFormat.
+func VerifyFormat(name string, version int) error {
+	if version != FormatVersion {
+		return fmt.Errorf("%s: %w: unknown format version %d", name, ErrIncompatibleFormat, version)
+	}
+
+	format := Format()
+
+	layout, err := ReadLayout(name)
+	if err != nil {
+		return err
+	}
+
+	if layout != format.Layout {
+		return &FormatError{Name: name, Found: layout, Expected: format.Layout}
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	slot := layout.HeaderSize + layout.PageSize
+	if len(data)%slot != 0 {
+		return verifyError(name, int64(len(data)), "file size is not a multiple of the page size")
+	}
+
+	pages := int64(len(data) / slot)
+	for pageID := int64(0); pageID < pages; pageID++ {
+		offset := pageID * int64(slot)
+		header := string(bytes.TrimRight(data[offset:offset+int64(layout.HeaderSize)], "\x00"))
+
+		if header == "" || header == "-1" {
+			continue
+		}
+
+		next, err := strconv.ParseInt(strings.TrimPrefix(header, OVERFLOW_MARKER), 10, 64)
+		if err != nil || next < 0 || next >= pages {
+			return verifyError(name, offset, fmt.Sprintf("page %d: invalid header %q", pageID, header))
+		}
+	}
+
+	if pages > 0 {
+		storage, err := openLayoutStorage(name, layout)
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		root, err := storage.ReadPage(0)
+		if err != nil {
+			return verifyError(name, 0, fmt.Sprintf("root: %v", err))
+		}
+
+		// a compressed root is read with the dictionary of the file
+		tree := &BTree{}
+		dict, err := os.ReadFile(name + ".dict")
+		if err == nil {
+			tree.compressor = newNodeCompressor(dict)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		_, err = tree.decodePage(root)
+		if err != nil {
+			return verifyError(name, 0, fmt.Sprintf("root: %v", err))
+		}
+	}
+
+	err = verifyDeletedPages(name+".del", pages)
+	if err != nil {
+		return err
+	}
+
+	err = verifyFreelistLog(name+".del.log", pages)
+	if err != nil {
+		return err
+	}
+
+	return verifyValueLog(name + ".vlog")
+}
+
+// verifyError returns an error wrapping ErrIncompatibleFormat for a problem at offset of a file
+func verifyError(name string, offset int64, reason string) error {
+	return fmt.Errorf("%s: %w: offset %d: %s", name, ErrIncompatibleFormat, offset, reason)
+}
+
+// verifyDeletedPages checks that the deleted pages file lists pages of the btree file
+func verifyDeletedPages(name string, pages int64) error {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	list := strings.Trim(string(data), "[]")
+	if list == "" {
+		return nil
+	}
+
+	offset := int64(0)
+	for _, field := range strings.Split(list, ",") {
+		pageID, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || pageID < 0 || pageID >= pages {
+			return verifyError(name, offset, fmt.Sprintf("invalid deleted page %q", field))
+		}
+		offset += int64(len(field)) + 1
+	}
+
+	return nil
+}
+
+// verifyFreelistLog checks that the freelist log records pages of the btree file with a known operation
+func verifyFreelistLog(name string, pages int64) error {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// a torn last record is ignored like when replaying the log
+	for offset := 0; offset+FREELIST_RECORD_SIZE <= len(data); offset += FREELIST_RECORD_SIZE {
+		pageID := int64(binary.BigEndian.Uint64(data[offset:]))
+		if pageID < 0 || pageID >= pages {
+			return verifyError(name, int64(offset), fmt.Sprintf("invalid page %d", pageID))
+		}
+
+		op := data[offset+8]
+		if op != freelistFree && op != freelistAlloc {
+			return verifyError(name, int64(offset+8), fmt.Sprintf("unknown operation %d", op))
+		}
+	}
+
+	return nil
+}
+
+// verifyValueLog checks the checksum and length of every value log record
+func verifyValueLog(name string) error {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < VLOG_HEADER_SIZE {
+			return verifyError(name, int64(offset), "truncated record header")
+		}
+
+		checksum := binary.BigEndian.Uint32(data[offset:])
+		length := int(binary.BigEndian.Uint32(data[offset+4:]))
+
+		if len(data)-offset-VLOG_HEADER_SIZE < length {
+			return verifyError(name, int64(offset), "truncated record")
+		}
+
+		value := data[offset+VLOG_HEADER_SIZE : offset+VLOG_HEADER_SIZE+length]
+		if crc32.ChecksumIEEE(value) != checksum {
+			return verifyError(name, int64(offset), "checksum mismatch")
+		}
+
+		offset += VLOG_HEADER_SIZE + length
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+// Package btree
+// file format description tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	format := Format()
+
+	if format.Version != FormatVersion || format.Layout != CurrentLayout() {
+		t.Fatalf("expected version %d with the current layout, got %d %+v", FormatVersion, format.Version, format.Layout)
+	}
+
+	first, err := json.Marshal(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := json.Marshal(Format())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected the description to be deterministic")
+	}
+
+	// the msgpack fields described must be the fields encoded
+	fields := func(v interface{}) []string {
+		typ := reflect.TypeOf(v)
+		names := make([]string, 0, typ.NumField())
+		for i := 0; i < typ.NumField(); i++ {
+			names = append(names, typ.Field(i).Name)
+		}
+		return names
+	}
+
+	described := func(format []FormatField) []string {
+		names := make([]string, 0, len(format))
+		for _, field := range format {
+			names = append(names, field.Name)
+		}
+		return names
+	}
+
+	if !reflect.DeepEqual(fields(nodeRecord{}), described(format.Node)) {
+		t.Fatalf("node fields %v are described as %v", fields(nodeRecord{}), described(format.Node))
+	}
+
+	if !reflect.DeepEqual(fields(Key{}), described(format.Key)) {
+		t.Fatalf("key fields %v are described as %v", fields(Key{}), described(format.Key))
+	}
+
+	if !reflect.DeepEqual(fields(ValueMeta{}), described(format.ValueMeta)) {
+		t.Fatalf("value metadata fields %v are described as %v", fields(ValueMeta{}), described(format.ValueMeta))
+	}
+
+	for _, file := range format.Files {
+		if file.Suffix == ".vlog" && file.Fields[2].Offset != VLOG_HEADER_SIZE {
+			t.Fatalf("expected values at offset %d, got %d", VLOG_HEADER_SIZE, file.Fields[2].Offset)
+		}
+	}
+}
+
+func TestVerifyFormat(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), bytes.Repeat([]byte{byte(i)}, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		err = b.Delete([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyFormat("btree.db", FormatVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyFormat("btree.db", FormatVersion+1)
+	if !errors.Is(err, ErrIncompatibleFormat) {
+		t.Fatalf("expected ErrIncompatibleFormat for an unknown version, got %v", err)
+	}
+}
+
+func TestVerifyFormat_Corrupt(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	write := func(t *testing.T) []byte {
+		b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 64})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 20; i++ {
+			err = b.Put([]byte(fmt.Sprintf("%03d", i)), bytes.Repeat([]byte("v"), 100))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = b.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile("btree.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	corruptions := []struct {
+		name    string
+		corrupt func(data []byte) error
+	}{
+		{"size", func(data []byte) error {
+			return os.WriteFile("btree.db", data[:len(data)-1], 0644)
+		}},
+		{"header", func(data []byte) error {
+			copy(data[PAGE_SIZE+HEADER_SIZE:], "x1\x00")
+			return os.WriteFile("btree.db", data, 0644)
+		}},
+		{"chain", func(data []byte) error {
+			copy(data[PAGE_SIZE+HEADER_SIZE:], "c99999\x00")
+			return os.WriteFile("btree.db", data, 0644)
+		}},
+		{"root", func(data []byte) error {
+			copy(data[HEADER_SIZE:], "\xc1\xc1\xc1")
+			return os.WriteFile("btree.db", data, 0644)
+		}},
+		{"deleted pages", func(data []byte) error {
+			return os.WriteFile("btree.db.del", []byte("1,99999"), 0644)
+		}},
+		{"value log", func(data []byte) error {
+			vlog, err := os.ReadFile("btree.db.vlog")
+			if err != nil {
+				return err
+			}
+			vlog[VLOG_HEADER_SIZE] ^= 0xff
+			return os.WriteFile("btree.db.vlog", vlog, 0644)
+		}},
+	}
+
+	for _, c := range corruptions {
+		t.Run(c.name, func(t *testing.T) {
+			os.Remove("btree.db")
+			os.Remove("btree.db.del")
+			os.Remove("btree.db.vlog")
+
+			err := c.corrupt(write(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = VerifyFormat("btree.db", FormatVersion)
+			if !errors.Is(err, ErrIncompatibleFormat) {
+				t.Fatalf("expected ErrIncompatibleFormat, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// Package btree
+// fixed size keys
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// fixedKeyNode marks a page holding a node in the fixed key size encoding, nodes are msgpack maps which never start with this byte
+const fixedKeyNode = 0xc7
+
+// ErrKeySize is returned when writing a key which is not of the fixed key size of the btree
+var ErrKeySize = errors.New("key is not of the fixed key size")
+
+// ErrKeySizeMismatch is returned when opening a btree with a different fixed key size than the one it was written with
+var ErrKeySizeMismatch = errors.New("fixed key size does not match the btree")
+
+// fixedKeyRecord is a key of a fixed key size node, the key itself is stored in the dense key array of the node
+type fixedKeyRecord struct {
+	V   [][]byte
+	Ptr []bool       `codec:",omitempty"`
+	E   int64        `codec:",omitempty"`
+	M   []*ValueMeta `codec:",omitempty"`
+	Ver []uint64     `codec:",omitempty"`
+	D   bool         `codec:",omitempty"`
+	H   int64        `codec:",omitempty"`
+	R   uint64       `codec:",omitempty"`
+	F   uint64       `codec:",omitempty"`
+	B   int64        `codec:",omitempty"`
+	W   uint64       `codec:",omitempty"`
+}
+
+// fixedNodeRecord is a node whose keys are all of the same size, the keys are stored back to back in Dense without length headers
+// The key size is the size of Dense divided by the number of keys.
+type fixedNodeRecord struct {
+	Page     int64
+	Dense    []byte
+	Keys     []*fixedKeyRecord
+	Children []int64
+	Leaf     bool
+}
+
+// checkFixedKeySize compares the fixed key size with the one recorded in the .keysize file
+// A btree opened without a size uses the recorded one, a size is only recorded for an empty btree
+func (b *BTree) checkFixedKeySize(size int) error {
+	if size < 0 {
+		return errors.New("fixed key size must not be negative")
+	}
+
+	recorded, err := os.ReadFile(b.name + ".keysize")
+	if err == nil {
+		n, err := strconv.Atoi(strings.TrimSpace(string(recorded)))
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%s.keysize: invalid key size %q", b.name, recorded)
+		}
+
+		if size != 0 && size != n {
+			return ErrKeySizeMismatch
+		}
+
+		b.fixedKeySize = n
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	// keys already written to the btree may be of any size
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	if !root.Leaf || len(root.Keys) > 0 {
+		return ErrKeySizeMismatch
+	}
+
+	err = os.WriteFile(b.name+".keysize", []byte(strconv.Itoa(size)+"\n"), b.perm)
+	if err != nil {
+		return err
+	}
+
+	b.fixedKeySize = size
+	return nil
+}
+
+// checkKeySize returns ErrKeySize if the btree has a fixed key size and k is not of that size
+func (b *BTree) checkKeySize(k []byte) error {
+	if b.fixedKeySize > 0 && len(k) != b.fixedKeySize {
+		return ErrKeySize
+	}
+	return nil
+}
+
+// encodeFixedNode encodes a node in the fixed key size encoding, false is returned if a key is not of the size
+func encodeFixedNode(n *Node, size int) ([]byte, bool, error) {
+	r := &fixedNodeRecord{Page: n.Page, Dense: make([]byte, 0, len(n.Keys)*size), Keys: make([]*fixedKeyRecord, len(n.Keys)), Children: canonicalSlice(n.Children), Leaf: n.Leaf}
+
+	for i, k := range n.Keys {
+		if k == nil || len(k.K) != size {
+			return nil, false, nil
+		}
+
+		r.Dense = append(r.Dense, k.K...)
+
+		// the keys are stored in their canonical form like the keys of other nodes
+		c := canonicalKey(k)
+		r.Keys[i] = &fixedKeyRecord{V: c.V, Ptr: c.Ptr, E: c.E, M: c.M, Ver: c.Ver, D: c.D, H: c.H, R: c.R, F: c.F, B: c.B, W: c.W}
+	}
+
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, msgpackHandle)
+	err := enc.Encode(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return append([]byte{fixedKeyNode}, encoded...), true, nil
+}
+
+// decodeFixedNode decodes a node encoded by encodeFixedNode, the keys alias the decoded dense key array
+func decodeFixedNode(data []byte) (*Node, error) {
+	var r fixedNodeRecord
+
+	dec := codec.NewDecoderBytes(data[1:], msgpackHandle)
+	err := dec.Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+
+	size := 0
+	if len(r.Keys) > 0 {
+		size = len(r.Dense) / len(r.Keys)
+	}
+
+	if size*len(r.Keys) != len(r.Dense) {
+		return nil, errors.New("invalid fixed key size node")
+	}
+
+	n := &Node{Page: r.Page, Keys: make([]*Key, len(r.Keys)), Children: r.Children, Leaf: r.Leaf}
+	for i, k := range r.Keys {
+		n.Keys[i] = &Key{K: r.Dense[i*size : (i+1)*size : (i+1)*size], V: k.V, Ptr: k.Ptr, E: k.E, M: k.M, Ver: k.Ver, D: k.D, H: k.H, R: k.R, F: k.F, B: k.B, W: k.W}
+	}
+
+	return n, nil
+}
+
+// searchKeys returns the index of the first key of keys which is not less than k
+// Keys of a btree with a fixed key size are binary searched, otherwise they are scanned in order
+func (b *BTree) searchKeys(keys []*Key, k []byte) int {
+	if b.fixedKeySize > 0 {
+		return sort.Search(len(keys), func(i int) bool {
+			return !greaterThan(k, keys[i].K)
+		})
+	}
+
+	i := 0
+	for i < len(keys) && greaterThan(k, keys[i].K) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,223 @@
+// Package btree
+// fixed size keys tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// fixedKey returns i as an 8 byte big endian key
+func fixedKey(i int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(i))
+}
+
+func TestBTree_FixedKeySize(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.keysize")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{FixedKeySize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		err = btree.Put(fixedKey(i), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Put([]byte("short"), []byte("value"))
+	if err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, got %v", err)
+	}
+
+	err = btree.ReKey(fixedKey(1), []byte("short"))
+	if err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, got %v", err)
+	}
+
+	for i := 0; i < 500; i += 3 {
+		err = btree.Delete(fixedKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkBTree(t, btree)
+
+	data, err := btree.Pager.ReadPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0] != fixedKeyNode {
+		t.Fatalf("expected the root to be in the fixed key size encoding, got %x", data[0])
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{FixedKeySize: 4})
+	if err != ErrKeySizeMismatch {
+		t.Fatalf("expected ErrKeySizeMismatch, got %v", err)
+	}
+
+	// the recorded size is used when the btree is opened without one
+	btree, err = Open("btree.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+		key, err := btree.Get(fixedKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (key == nil) != (i%3 == 0) {
+			t.Fatalf("unexpected key %d: %v", i, key)
+		}
+	}
+
+	err = btree.Put([]byte("short"), []byte("value"))
+	if err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, got %v", err)
+	}
+
+	// pinned pages in the fixed key size encoding are viewed without copying
+	root, err := btree.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.walk(root, func(n *Node) error {
+		return btree.Pager.(*Pager).Pin(n.Page)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view, err := btree.GetZeroCopy(fixedKey(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if view == nil || view.Copied || string(view.V[0]) != "value" {
+		t.Fatalf("expected a view of key 100, got %v", view)
+	}
+
+	view.Release()
+}
+
+func TestBTree_FixedKeySize_NonEmpty(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys already written may be of any size
+	_, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{FixedKeySize: 8})
+	if err != ErrKeySizeMismatch {
+		t.Fatalf("expected ErrKeySizeMismatch, got %v", err)
+	}
+}
+
+func TestEncodeFixedNode(t *testing.T) {
+	n := &Node{Page: 4, Children: []int64{1, 2, 3}, Keys: []*Key{
+		{K: fixedKey(1), V: [][]byte{[]byte("a")}, M: []*ValueMeta{{Flags: 2}}},
+		{K: fixedKey(2), V: [][]byte{[]byte("b"), []byte("c")}, Ver: []uint64{1, 2}, D: true},
+		{K: fixedKey(3), V: [][]byte{[]byte("d")}},
+		{K: fixedKey(4), V: [][]byte{[]byte("e")}, E: 10},
+		{K: fixedKey(5), V: [][]byte{[]byte("f")}},
+	}}
+
+	encoded, ok, err := encodeFixedNode(n, 8)
+	if err != nil || !ok {
+		t.Fatalf("expected the node to be encoded, got %v %v", ok, err)
+	}
+
+	plain, err := encodeNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(encoded) >= len(plain) {
+		t.Fatalf("expected the fixed key size encoding to be smaller, got %d bytes for %d", len(encoded), len(plain))
+	}
+
+	decoded, err := decodeFixedNode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, n) {
+		t.Fatalf("expected %+v, got %+v", n, decoded)
+	}
+
+	viewed, err := viewNode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(viewed, n) {
+		t.Fatalf("expected %+v, got %+v", n, viewed)
+	}
+
+	// a key of another size leaves the node in the regular encoding
+	n.Keys[1].K = []byte("short")
+	_, ok, err = encodeFixedNode(n, 8)
+	if err != nil || ok {
+		t.Fatalf("expected the node not to be encoded, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,129 @@
+// Package btree
+// key flags
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+// SetFlags replaces the flags of a key, ErrKeyNotFound is returned if the key does not exist
+// Flags are an application defined bitmap stored with the key, i.e. soft delete, dirty or replication markers.
+// They are kept while values are added or removed and dropped with the key.
+func (b *BTree) SetFlags(k []byte, flags uint64) error {
+	return b.updateFlags(b.transformKey(k), func(uint64) uint64 { return flags })
+}
+
+// UpdateFlags sets the bits of set and clears the bits of clear in the flags of a key and returns the new flags
+// ErrKeyNotFound is returned if the key does not exist.
+func (b *BTree) UpdateFlags(k []byte, set, clear uint64) (uint64, error) {
+	var updated uint64
+	err := b.updateFlags(b.transformKey(k), func(flags uint64) uint64 {
+		updated = flags&^clear | set
+		return updated
+	})
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+// GetFlags returns the flags of a key, ErrKeyNotFound is returned if the key does not exist
+func (b *BTree) GetFlags(k []byte) (uint64, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := b.lookupKey(root, b.transformKey(k))
+	if err != nil {
+		return 0, err
+	}
+
+	if key == nil || key.hidden() {
+		return 0, ErrKeyNotFound
+	}
+
+	return key.F, nil
+}
+
+// RangeWithFlags returns the keys within the range [start, end] whose flags hold every bit of mask
+// Keys are filtered while the tree is traversed, keys which do not match are not resolved.
+func (b *BTree) RangeWithFlags(start, end []byte, mask uint64) ([]*Key, error) {
+	start, end = b.transformKey(start), b.transformKey(end)
+
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0)
+	bounds := &keyBounds{start: start, end: end, includeStart: true, includeEnd: true}
+
+	_, err = b.scanBounds(root, bounds, false, func(k *Key) (bool, error) {
+		if k.hidden() || k.F&mask != mask {
+			return false, nil
+		}
+
+		resolved, err := b.resolveKey(k)
+		if err != nil {
+			return true, err
+		}
+
+		keys = append(keys, resolved)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// updateFlags replaces the flags of a transformed key with the result of fn atomically
+func (b *BTree) updateFlags(k []byte, fn func(flags uint64) uint64) error {
+	return b.atomic(func() error {
+		root, err := b.getRoot()
+		if err != nil {
+			return err
+		}
+
+		n, i, err := b.findNodeForKey(root, k)
+		if err != nil {
+			return err
+		}
+
+		if n.Keys[i].hidden() {
+			return ErrKeyNotFound
+		}
+
+		n.Keys[i].F = fn(n.Keys[i].F)
+		b.keyChanged(n.Keys[i])
+
+		return b.writeNode(n)
+	})
+}
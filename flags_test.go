@@ -0,0 +1,180 @@
+// Package btree
+// key flags tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_Flags(t *testing.T) {
+	defer os.Remove("flags.db")
+	defer os.Remove("flags.db.del")
+
+	btree, err := Open("flags.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const (
+		dirty      = 1 << 0
+		replicated = 1 << 1
+	)
+
+	// every third key is dirty, every fifth is dirty and replicated
+	for i := 0; i < 100; i += 3 {
+		err = btree.SetFlags([]byte(fmt.Sprintf("%03d", i)), dirty)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 100; i += 5 {
+		flags, err := btree.UpdateFlags([]byte(fmt.Sprintf("%03d", i)), dirty|replicated, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if flags != dirty|replicated {
+			t.Fatalf("expected flags %d, got %d", dirty|replicated, flags)
+		}
+	}
+
+	err = btree.SetFlags([]byte("missing"), dirty)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	_, err = btree.GetFlags([]byte("missing"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// flags survive new values and reopening
+	err = btree.Put([]byte("003"), []byte("another value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = Open("flags.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	flags, err := btree.GetFlags([]byte("003"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if flags != dirty {
+		t.Fatalf("expected flags %d, got %d", dirty, flags)
+	}
+
+	key, err := btree.Get([]byte("010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.F != dirty|replicated {
+		t.Fatalf("expected flags %d on the key, got %d", dirty|replicated, key.F)
+	}
+
+	keys, err := btree.RangeWithFlags([]byte("000"), []byte("099"), dirty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// multiples of 3 or 5 below 100
+	if len(keys) != 47 {
+		t.Fatalf("expected 47 dirty keys, got %d", len(keys))
+	}
+
+	keys, err = btree.RangeWithFlags([]byte("000"), []byte("049"), dirty|replicated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 10 {
+		t.Fatalf("expected 10 replicated keys, got %d", len(keys))
+	}
+
+	for _, k := range keys {
+		if k.F != dirty|replicated {
+			t.Fatalf("expected key %s to be replicated", k.K)
+		}
+	}
+
+	// clearing a bit
+	flags, err = btree.UpdateFlags([]byte("000"), 0, dirty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if flags != replicated {
+		t.Fatalf("expected flags %d, got %d", replicated, flags)
+	}
+
+	// a deleted key loses its flags
+	err = btree.Delete([]byte("003"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("003"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags, err = btree.GetFlags([]byte("003"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if flags != 0 {
+		t.Fatalf("expected no flags, got %d", flags)
+	}
+}
@@ -0,0 +1,383 @@
+// Package btree
+// read replicas following a live file
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultRefreshInterval is how often a follower refreshes its view when FollowerOptions.RefreshInterval is not set
+const DefaultRefreshInterval = time.Millisecond * 100
+
+// DefaultPublishTimeout is how long a refresh waits for the primary to publish an operation when FollowerOptions.PublishTimeout is not set
+const DefaultPublishTimeout = time.Second * 5
+
+// ErrPrimaryStalled is returned by a follower whose primary did not finish publishing an operation within the publish timeout, i.e. because it crashed
+// The view is refreshed again by the next read, once the primary is reopened the counter is made even.
+var ErrPrimaryStalled = errors.New("primary did not finish publishing an operation")
+
+// errStaleView is returned by the storage of a follower for a page read after the primary published an operation
+var errStaleView = errors.New("view of the follower is stale")
+
+// commitCounter counts the operations a btree opened with Options.Followers published, in its .commit file
+// The counter is odd while an operation writes its pages, followers only trust pages read while it stayed at the same even value.
+// It is not synced, followers run on the same host and see it through the page cache.
+type commitCounter struct {
+	file pageFile
+	seq  uint64
+}
+
+// openCommitCounter opens the commit counter of a btree, a counter left odd by a crash is made even
+func openCommitCounter(filename string, perm os.FileMode) (*commitCounter, error) {
+	file, err := openFile(filename, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &commitCounter{file: file}
+
+	c.seq, err = readCommit(file)
+	if err == nil && c.seq%2 == 1 {
+		c.seq++
+		err = c.write()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// readCommit reads a commit counter, 0 if it was never written
+func readCommit(file pageFile) (uint64, error) {
+	buf := make([]byte, 8)
+
+	_, err := file.ReadAt(buf, 0)
+	if errors.Is(err, io.EOF) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+// write stores the counter
+func (c *commitCounter) write() error {
+	_, err := c.file.WriteAt(binary.BigEndian.AppendUint64(nil, c.seq), 0)
+	return err
+}
+
+// publish runs an operation with the counter odd
+func (c *commitCounter) publish(fn func() error) error {
+	c.seq++
+	err := c.write()
+	if err != nil {
+		c.seq--
+		return err
+	}
+
+	err = fn()
+
+	c.seq++
+	return errors.Join(err, c.write())
+}
+
+// close closes the commit counter
+func (c *commitCounter) close() error {
+	return c.file.Close()
+}
+
+// published runs fn writing the pages of an operation, with the commit counter odd if followers are enabled
+func (b *BTree) published(fn func() error) error {
+	if b.commits == nil {
+		return fn()
+	}
+	return b.commits.publish(fn)
+}
+
+// FollowerOptions are optional settings used when opening a follower
+type FollowerOptions struct {
+	RefreshInterval time.Duration // How often the view is refreshed, reads within an interval may miss newer operations, defaults to DefaultRefreshInterval
+	PublishTimeout  time.Duration // How long a refresh waits for an operation the primary is publishing before returning ErrPrimaryStalled, defaults to DefaultPublishTimeout
+}
+
+// Follower reads a btree file while another process, the primary, writes it
+// The primary must be opened with Options.Followers.  The follower keeps the pages it read in memory as a view of the file and
+// refreshes the view every FollowerOptions.RefreshInterval, so reads are slightly stale but never see an operation half written.
+type Follower struct {
+	b         *BTree
+	storage   *followerStorage
+	path      string
+	interval  time.Duration
+	timeout   time.Duration // how long a refresh waits for the primary to publish
+	lock      sync.Mutex    // serializes reads
+	refreshed time.Time     // when the view was last refreshed
+}
+
+// followerStorage serves the pages of the current view of a follower, pages read from the file are only kept if the primary published nothing meanwhile
+type followerStorage struct {
+	pager   *Pager
+	commits pageFile         // the commit counter of the primary
+	seq     uint64           // the commit counter the view belongs to
+	pages   map[int64][]byte // pages read for the view
+	stale   bool             // true once a read found the primary moved on
+}
+
+// OpenFollower opens the btree file at path read-only for reads while its primary writes it, see Follower
+// The value log of the file is read, the expiry index and other files belonging to the primary are not used.
+func OpenFollower(path string, t int, opts *FollowerOptions) (*Follower, error) {
+	if opts == nil {
+		opts = &FollowerOptions{}
+	}
+
+	interval := opts.RefreshInterval
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+
+	timeout := opts.PublishTimeout
+	if timeout <= 0 {
+		timeout = DefaultPublishTimeout
+	}
+
+	commits, err := openFile(path+".commit", os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return nil, errors.New("primary was not opened with followers enabled")
+	} else if err != nil {
+		return nil, err
+	}
+
+	file, _, _, err := openSegments(path, os.O_RDONLY, 0, false, 0)
+	if err != nil {
+		commits.Close()
+		return nil, err
+	}
+
+	// pages are never allocated or freed, the deleted pages of the primary are not needed
+	pager, err := newPager(&readOnlyFile{pageFile: file}, newMemFile(), DefaultRefreshInterval)
+	if err != nil {
+		commits.Close()
+		file.Close()
+		return nil, err
+	}
+
+	storage := &followerStorage{pager: pager, commits: commits, pages: make(map[int64][]byte)}
+
+	b, err := OpenWithStorage(storage, t)
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	// nodes are read with the options recorded by the primary
+	err = b.loadRecordedOptions(path)
+	if err == nil {
+		_, err = os.Stat(path + ".vlog")
+	}
+	if err == nil {
+		b.ValueLog, err = OpenValueLog(path+".vlog", os.O_RDONLY, 0)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		b.Close()
+		return nil, err
+	}
+
+	f := &Follower{b: b, storage: storage, path: path, interval: interval, timeout: timeout}
+
+	b.rangeDels, err = openRangeTombstones(path + ".rangedel")
+	if err == nil {
+		err = f.Refresh()
+	}
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Refresh drops the view if the primary published an operation since it was taken, waiting while an operation is written
+func (f *Follower) Refresh() error {
+	if f.b.closed.Load() {
+		return ErrClosed
+	}
+
+	// a primary which crashed while publishing leaves the counter odd until it is reopened
+	deadline := time.Now().Add(f.timeout)
+
+	seq, err := readCommit(f.storage.commits)
+	for err == nil && seq%2 == 1 {
+		if time.Now().After(deadline) {
+			return ErrPrimaryStalled
+		}
+
+		time.Sleep(time.Millisecond)
+		seq, err = readCommit(f.storage.commits)
+	}
+	if err != nil {
+		return err
+	}
+
+	if seq != f.storage.seq || f.storage.stale {
+		f.storage.seq = seq
+		f.storage.pages = make(map[int64][]byte)
+		f.b.replaced()
+
+		// a range tombstone newer than the pages read is noticed like a newer page
+		f.b.rangeDels, err = openRangeTombstones(f.path + ".rangedel")
+		if err != nil {
+			return err
+		}
+	}
+
+	f.storage.stale = false
+	f.refreshed = time.Now()
+
+	return nil
+}
+
+// View runs fn with the btree of the follower, fn must only read
+// fn runs again on a refreshed view if it read a page written after its view was taken, so it must not have other side effects.
+func (f *Follower) View(fn func(b *BTree) error) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if time.Since(f.refreshed) >= f.interval {
+		err := f.Refresh()
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		err := fn(f.b)
+		if !f.storage.stale {
+			return err
+		}
+
+		err = f.Refresh()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Get returns the values associated with a key as of the view of the follower, see BTree.Get
+func (f *Follower) Get(key []byte) (*Key, error) {
+	var k *Key
+	err := f.View(func(b *BTree) error {
+		var err error
+		k, err = b.Get(key)
+		return err
+	})
+	return k, err
+}
+
+// Range returns the keys within [start, end] as of the view of the follower, see BTree.Range
+func (f *Follower) Range(start, end []byte) ([]interface{}, error) {
+	var keys []interface{}
+	err := f.View(func(b *BTree) error {
+		var err error
+		keys, err = b.Range(start, end)
+		return err
+	})
+	return keys, err
+}
+
+// Close closes the follower, the primary is not affected
+func (f *Follower) Close() error {
+	return f.b.Close()
+}
+
+// ReadPage reads a page of the view, a page read from the file belongs to the view if the commit counter did not change
+func (s *followerStorage) ReadPage(pageID int64) ([]byte, error) {
+	if data, ok := s.pages[pageID]; ok {
+		return data, nil
+	}
+
+	if s.stale {
+		return nil, errStaleView
+	}
+
+	data, err := s.pager.ReadPage(pageID)
+
+	seq, seqErr := readCommit(s.commits)
+	if seqErr != nil {
+		return nil, seqErr
+	}
+
+	// the page may be torn or newer than the pages read before it
+	if seq != s.seq {
+		s.stale = true
+		return nil, errStaleView
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.pages[pageID] = data
+
+	return data, nil
+}
+
+// WritePage refuses to write
+func (s *followerStorage) WritePage(pageID int64, data []byte) error {
+	return ErrReadOnly
+}
+
+// Allocate refuses to allocate
+func (s *followerStorage) Allocate(data []byte) (int64, error) {
+	return -1, ErrReadOnly
+}
+
+// Free refuses to free
+func (s *followerStorage) Free(pageID int64) error {
+	return ErrReadOnly
+}
+
+// Sync has nothing to flush
+func (s *followerStorage) Sync() error {
+	return nil
+}
+
+// Close closes the file and the commit counter
+func (s *followerStorage) Close() error {
+	return errors.Join(s.pager.Close(), s.commits.Close())
+}
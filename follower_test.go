@@ -0,0 +1,324 @@
+// Package btree
+// read replicas following a live file tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFollower(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("follower.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	primary, err := Open("follower.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = primary.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = primary.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenFollower("follower.db", 3, nil)
+	if err == nil {
+		t.Fatal("expected an error following a primary without followers enabled")
+	}
+
+	primary, err = OpenWithOptions("follower.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Followers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	for i := 0; i < 100; i++ {
+		err = primary.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	follower, err := OpenFollower("follower.db", 3, &FollowerOptions{RefreshInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	key, err := follower.Get([]byte("key-001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || fmt.Sprintf("%s", key.V) != "[value-1]" {
+		t.Fatalf("expected [value-1], got %v", key)
+	}
+
+	err = primary.Put([]byte("key-001"), []byte("again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = primary.Put([]byte("zzz"), []byte("last"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pages read before are served from the view until it is refreshed
+	key, err = follower.Get([]byte("key-001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprintf("%s", key.V) != "[value-1]" {
+		t.Fatalf("expected the stale [value-1], got %s", key.V)
+	}
+
+	// a page missing from the view is read after the primary moved on, the view is refreshed
+	key, err = follower.Get([]byte("zzz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.V[0]) != "last" {
+		t.Fatalf("expected last, got %v", key)
+	}
+
+	key, err = follower.Get([]byte("key-001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprintf("%s", key.V) != "[value-1 again]" {
+		t.Fatalf("expected [value-1 again] after the refresh, got %s", key.V)
+	}
+
+	err = follower.View(func(b *BTree) error {
+		return b.Put([]byte("key"), []byte("value"))
+	})
+	if err == nil {
+		t.Fatal("expected an error writing through a follower")
+	}
+
+	keys, err := follower.Range([]byte("key-010"), []byte("key-019"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 10 {
+		t.Fatalf("expected 10 keys, got %d", len(keys))
+	}
+}
+
+func TestFollower_Concurrent(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("follower.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	primary, err := OpenWithOptions("follower.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Followers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	// keys are put in random order so views mixing pages of different operations lose keys in the middle
+	order := rand.New(rand.NewSource(1)).Perm(5000)
+
+	err = primary.Put([]byte(fmt.Sprintf("key-%04d", order[0])), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	follower, err := OpenFollower("follower.db", 3, &FollowerOptions{RefreshInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, i := range order[1:] {
+			err := primary.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("value"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// every view holds the keys of the first operations of the primary
+	for i := 0; i < 500; i++ {
+		err = follower.View(func(b *BTree) error {
+			keys, err := b.Range([]byte("key-0000"), []byte("key-9999"))
+			if err != nil {
+				return err
+			}
+
+			seen := make(map[string]bool)
+			for _, k := range keys {
+				seen[string(k.(*Key).K)] = true
+			}
+
+			for _, j := range order[:len(keys)] {
+				if !seen[fmt.Sprintf("key-%04d", j)] {
+					return fmt.Errorf("view of %d keys is missing key-%04d", len(keys), j)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestFollower_PrimaryStalled(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("follower.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	primary, err := OpenWithOptions("follower.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Followers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = primary.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = primary.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	follower, err := OpenFollower("follower.db", 3, &FollowerOptions{RefreshInterval: time.Nanosecond, PublishTimeout: time.Millisecond * 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	// a primary crashing while it publishes leaves the counter odd
+	seq, err := os.ReadFile("follower.db.commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile("follower.db.commit", binary.BigEndian.AppendUint64(nil, binary.BigEndian.Uint64(seq)+1), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = follower.Get([]byte("key"))
+	if !errors.Is(err, ErrPrimaryStalled) {
+		t.Fatalf("expected ErrPrimaryStalled, got %v", err)
+	}
+
+	// reopening the primary makes the counter even again
+	primary, err = OpenWithOptions("follower.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Followers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	key, err := follower.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil {
+		t.Fatal("expected the key once the primary recovered")
+	}
+}
+
+func TestFollower_NodeCodec(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("follower.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	primary, err := OpenWithOptions("follower.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Followers: true, NodeCodec: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	for i := 0; i < 100; i++ {
+		err = primary.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	follower, err := OpenFollower("follower.db", 3, &FollowerOptions{RefreshInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	key, err := follower.Get([]byte("key-042"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.V[0]) != "value-42" {
+		t.Fatalf("expected value-42, got %v", key)
+	}
+}
@@ -0,0 +1,342 @@
+// Package btree
+// file format
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Layout describes how pages are laid out in a btree file
+type Layout struct {
+	PageSize   int `json:"page_size"`   // Size of the data of a page
+	HeaderSize int `json:"header_size"` // Size of the header in front of the data of a page
+}
+
+// legacyLayout is the layout of files which do not record their layout
+// Files are only given a format file once the layout differs from it, files written with it stay readable by older versions
+var legacyLayout = Layout{PageSize: 1024, HeaderSize: 16}
+
+// ErrIncompatibleFormat is returned when opening a file written with a different page layout
+var ErrIncompatibleFormat = errors.New("incompatible file format")
+
+// FormatError describes a file written with a different page layout, it wraps ErrIncompatibleFormat
+type FormatError struct {
+	Name     string // The file
+	Found    Layout // The layout the file was written with
+	Expected Layout // The layout of this version
+}
+
+// Error returns the layouts of the file and of this version
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%s: %v: written with page size %d and header size %d, expected page size %d and header size %d, see MigrateFile",
+		e.Name, ErrIncompatibleFormat, e.Found.PageSize, e.Found.HeaderSize, e.Expected.PageSize, e.Expected.HeaderSize)
+}
+
+// Unwrap returns ErrIncompatibleFormat
+func (e *FormatError) Unwrap() error {
+	return ErrIncompatibleFormat
+}
+
+// CurrentLayout returns the page layout files are written with by this version
+func CurrentLayout() Layout {
+	return Layout{PageSize: PAGE_SIZE, HeaderSize: HEADER_SIZE}
+}
+
+// ReadLayout returns the page layout the file at name was written with
+// Files without a format file (name.format) were written with the layout of versions before the layout was recorded
+func ReadLayout(name string) (Layout, error) {
+	data, err := os.ReadFile(name + ".format")
+	if os.IsNotExist(err) {
+		return legacyLayout, nil
+	} else if err != nil {
+		return Layout{}, err
+	}
+
+	layout := Layout{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		field, value, _ := strings.Cut(line, "=")
+
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			return Layout{}, fmt.Errorf("%s.format: invalid line %q", name, line)
+		}
+
+		switch strings.TrimSpace(field) {
+		case "page_size":
+			layout.PageSize = n
+		case "header_size":
+			layout.HeaderSize = n
+		}
+	}
+
+	if layout.PageSize == 0 || layout.HeaderSize == 0 {
+		return Layout{}, fmt.Errorf("%s.format: missing page or header size", name)
+	}
+
+	return layout, nil
+}
+
+// writeLayout records the page layout of the file at name
+func writeLayout(name string, layout Layout, perm os.FileMode) error {
+	return os.WriteFile(name+".format", layout.encode(), perm)
+}
+
+// encode returns the layout in the format of the .format file
+func (l Layout) encode() []byte {
+	return []byte(fmt.Sprintf("page_size=%d\nheader_size=%d\n", l.PageSize, l.HeaderSize))
+}
+
+// checkLayout returns a FormatError if the file at name was written with another page layout
+// A new file is given a format file if this version does not use the legacy layout
+func checkLayout(name string, perm os.FileMode) error {
+	found, err := ReadLayout(name)
+	if err != nil {
+		return err
+	}
+
+	current := CurrentLayout()
+
+	stat, err := os.Stat(name)
+	if (os.IsNotExist(err) || (err == nil && stat.Size() == 0)) && found == legacyLayout {
+		if current == legacyLayout {
+			return nil
+		}
+		return writeLayout(name, current, perm)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if found != current {
+		return &FormatError{Name: name, Found: found, Expected: current}
+	}
+
+	return nil
+}
+
+// MigrateOptions are the settings used by MigrateFile
+type MigrateOptions struct {
+	From    *Layout  // Layout of the old file, read from its format file if nil
+	T       int      // Order of the new btree
+	Options *Options // Options the new btree is opened with, the key transform must match the one of the old file
+}
+
+// MigrateFile copies the btree in the file old, written with another page layout, into a new btree file written with the current layout
+// Keys are copied with their values, metadata, versions and expiry times, values in the value log of the old file (old.vlog) are resolved.
+// The old file is not modified.
+func MigrateFile(old, new string, opts *MigrateOptions) error {
+	if opts == nil || opts.T < 2 {
+		return errors.New("t must be greater than 1")
+	}
+
+	_, err := os.Stat(new)
+	if err == nil {
+		return errors.New("migration target already exists")
+	}
+
+	options := &Options{}
+	if opts.Options != nil {
+		copied := *opts.Options
+		options = &copied
+	}
+
+	// keys were transformed when written, the new btree must record the same transform
+	recorded, err := os.ReadFile(old + ".transform")
+	if err == nil {
+		if options.KeyTransform == nil || options.KeyTransform.Name() != strings.TrimSpace(string(recorded)) {
+			return ErrKeyTransformMismatch
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	layout := opts.From
+	if layout == nil {
+		found, err := ReadLayout(old)
+		if err != nil {
+			return err
+		}
+		layout = &found
+	}
+
+	storage, err := openLayoutStorage(old, *layout)
+	if err != nil {
+		return err
+	}
+
+	src, err := OpenWithStorage(storage, opts.T)
+	if err != nil {
+		storage.Close()
+		return err
+	}
+	defer src.Close()
+
+	// nodes of the old file are read with the codec, dictionary and key size recorded for it
+	src.keyTransform = options.KeyTransform
+	err = src.loadRecordedOptions(old)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(old + ".vlog")
+	if err == nil {
+		src.ValueLog, err = OpenValueLog(old+".vlog", os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	target, err := OpenWithOptions(new, os.O_CREATE|os.O_RDWR, 0644, opts.T, options)
+	if err != nil {
+		return err
+	}
+
+	// an empty file has no root and no keys
+	_, err = storage.ReadPage(0)
+	if errors.Is(err, io.EOF) {
+		return target.Close()
+	}
+
+	root, err := src.getRoot()
+	if err == nil {
+		err = src.walk(root, func(n *Node) error {
+			for _, k := range n.Keys {
+				err := target.rollForwardKey(src, k)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return errors.Join(err, target.Close())
+}
+
+// layoutStorage reads the pages of a file written with any page layout, it cannot be written
+type layoutStorage struct {
+	file   *os.File // the file
+	layout Layout   // the layout the file was written with
+}
+
+// openLayoutStorage opens the file at name for reading with a page layout
+func openLayoutStorage(name string, layout Layout) (*layoutStorage, error) {
+	if layout.PageSize <= 0 || layout.HeaderSize <= 0 {
+		return nil, errors.New("invalid layout")
+	}
+
+	file, err := openFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &layoutStorage{file: file, layout: layout}, nil
+}
+
+// readPage reads the header and data of a single page
+func (s *layoutStorage) readPage(pageID int64) (string, []byte, error) {
+	size := int64(s.layout.PageSize + s.layout.HeaderSize)
+	if pageID < 0 {
+		return "", nil, fmt.Errorf("%w: page %d", ErrPageNotFound, pageID)
+	}
+
+	page := make([]byte, size)
+	n, err := s.file.ReadAt(page, pageID*size)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", nil, err
+	}
+
+	if n < s.layout.HeaderSize {
+		return "", nil, fmt.Errorf("%w: page %d", ErrPageNotFound, pageID)
+	}
+
+	return string(bytes.Trim(page[:s.layout.HeaderSize], "\x00")), page[s.layout.HeaderSize:], nil
+}
+
+// ReadPage reads a page and the overflow pages linked to it
+func (s *layoutStorage) ReadPage(pageID int64) ([]byte, error) {
+	header, data, err := s.readPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := data
+	for hops := 0; header != "-1"; hops++ {
+		if hops > 1<<20 {
+			return nil, fmt.Errorf("page %d: overflow chain loops", pageID)
+		}
+
+		next, err := strconv.ParseInt(strings.TrimPrefix(header, OVERFLOW_MARKER), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: invalid header: %w", pageID, err)
+		}
+
+		header, data, err = s.readPage(next)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: broken overflow chain: %w", pageID, err)
+		}
+
+		result = append(result, data...)
+	}
+
+	return result, nil
+}
+
+// WritePage fails, the file is only read
+func (s *layoutStorage) WritePage(pageID int64, data []byte) error {
+	return errors.New("file is read-only")
+}
+
+// Allocate fails, the file is only read
+func (s *layoutStorage) Allocate(data []byte) (int64, error) {
+	return -1, errors.New("file is read-only")
+}
+
+// Free fails, the file is only read
+func (s *layoutStorage) Free(pageID int64) error {
+	return errors.New("file is read-only")
+}
+
+// Sync does nothing
+func (s *layoutStorage) Sync() error {
+	return nil
+}
+
+// Close closes the file
+func (s *layoutStorage) Close() error {
+	return s.file.Close()
+}
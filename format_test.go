@@ -0,0 +1,272 @@
+// Package btree
+// file format tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeLayoutFile writes the nodes of b to a file laid out with layout, as a version using other page sizes would
+func writeLayoutFile(t *testing.T, b *BTree, name string, layout Layout) {
+	t.Helper()
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	root, err := b.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := make([]*Node, 0)
+	next := int64(0)
+	err = b.walk(root, func(n *Node) error {
+		nodes = append(nodes, n)
+		if n.Page >= next {
+			next = n.Page + 1
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(layout.PageSize + layout.HeaderSize)
+	for _, n := range nodes {
+		data, err := encodeNode(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pages := []int64{n.Page}
+		for i := layout.PageSize; i < len(data); i += layout.PageSize {
+			pages = append(pages, next)
+			next++
+		}
+
+		for i, pageID := range pages {
+			header := "-1"
+			if i == 0 && len(pages) > 1 {
+				header = OVERFLOW_MARKER + strconv.FormatInt(pages[1], 10)
+			} else if i < len(pages)-1 {
+				header = strconv.FormatInt(pages[i+1], 10)
+			}
+
+			page := make([]byte, size)
+			copy(page, header)
+			copy(page[layout.HeaderSize:], data[i*layout.PageSize:min(len(data), (i+1)*layout.PageSize)])
+
+			_, err = f.WriteAt(page, pageID*size)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err = writeLayout(name, layout, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpen_IncompatibleFormat(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.format")
+
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	err = b.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeLayoutFile(t, b, "btree.db", Layout{PageSize: 2048, HeaderSize: 16})
+
+	_, err = Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if !errors.Is(err, ErrIncompatibleFormat) {
+		t.Fatalf("expected ErrIncompatibleFormat, got %v", err)
+	}
+
+	var formatErr *FormatError
+	if !errors.As(err, &formatErr) || formatErr.Found.PageSize != 2048 || formatErr.Expected != CurrentLayout() {
+		t.Fatalf("expected the layouts in the error, got %v", err)
+	}
+
+	// the file is left alone
+	_, err = os.Stat("btree.db.del")
+	if !os.IsNotExist(err) {
+		os.Remove("btree.db.del")
+		t.Fatal("expected no files to be created")
+	}
+}
+
+func TestOpen_LegacyFormat(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	b, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files in the legacy layout are not given a format file
+	_, err = os.Stat("btree.db.format")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected no format file, got %v", err)
+	}
+
+	layout, err := ReadLayout("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if layout != CurrentLayout() {
+		t.Fatalf("expected the current layout, got %+v", layout)
+	}
+}
+
+func TestReadLayout_Invalid(t *testing.T) {
+	defer os.Remove("btree.db.format")
+
+	err := os.WriteFile("btree.db.format", []byte("page_size=abc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReadLayout("btree.db")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	defer os.Remove("old.db")
+	defer os.Remove("old.db.format")
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.ttl")
+	defer os.Remove("btree.db.ttl.del")
+
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("%04d", i))
+		err = b.Put(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	large := bytes.Repeat([]byte("v"), 3000)
+	err = b.Put([]byte("large"), large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.PutWithTTL([]byte("session"), []byte("data"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeLayoutFile(t, b, "old.db", Layout{PageSize: 512, HeaderSize: 16})
+
+	err = MigrateFile("old.db", "btree.db", &MigrateOptions{T: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrated.Close()
+
+	checkBTree(t, migrated)
+
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("%04d", i))
+		k, err := migrated.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if k == nil || !bytes.Equal(k.V[0], key) {
+			t.Fatalf("expected key %s", key)
+		}
+	}
+
+	k, err := migrated.Get([]byte("large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k == nil || !bytes.Equal(k.V[0], large) {
+		t.Fatal("expected the large value")
+	}
+
+	k, err = migrated.Get([]byte("session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k == nil || k.E == 0 {
+		t.Fatal("expected the expiry to be kept")
+	}
+
+	// the target must not exist
+	err = MigrateFile("old.db", "btree.db", &MigrateOptions{T: 3})
+	if err == nil {
+		t.Fatal("expected an error migrating into an existing file")
+	}
+}
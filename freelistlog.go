@@ -0,0 +1,223 @@
+// Package btree
+// freelist log
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"slices"
+)
+
+const (
+	FREELIST_RECORD_SIZE = 9 // size of a freelist log record, the page id followed by the operation
+
+	freelistFree  = byte(1) // the page was added to the deleted pages
+	freelistAlloc = byte(2) // the page was taken from the deleted pages
+
+	// the log is folded into the deleted pages file once it holds this many records, or more records than there are deleted pages
+	freelistCheckpointRecords = 1024
+)
+
+// openFreelistLog opens the freelist log of filename and replays it over the deleted pages read from the deleted pages file
+// A record torn by a crash is ignored.  The replayed list is checkpointed right away so the log starts empty.
+func (p *Pager) openFreelistLog(filename string, perm os.FileMode, opts *PagerOptions) error {
+	var file pageFile
+
+	file, err := openFile(filename+".del.log", os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return err
+	}
+
+	if opts.faults != nil {
+		file = opts.faults.wrap(file)
+	}
+
+	file = newRetryFile(file, opts.Retries, opts.RetryBackoff)
+
+	if opts.ReadTimeout > 0 || opts.WriteTimeout > 0 {
+		file = &timeoutFile{pageFile: file, readTimeout: opts.ReadTimeout, writeTimeout: opts.WriteTimeout}
+	}
+
+	data, err := readFreelistLog(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	p.freelistLog, p.freelistLogName, p.freelistLogSize = file, filename+".del.log", int64(len(data))
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	p.applyFreelistLog(data)
+
+	// the log is checkpointed by the handle writing the file
+	if opts.passive {
+		return nil
+	}
+
+	return p.writeDelPages()
+}
+
+// applyFreelistLog replays the records of the freelist log over the deleted pages read from the deleted pages file
+func (p *Pager) applyFreelistLog(data []byte) {
+	p.deletedPages = replayFreelistLog(p.deletedPages, data)
+
+	// the log does not record the class of a page, pages freed since the last checkpoint are reused as node pages
+	freeOverflow := make(pageClasses)
+	for _, pageID := range p.deletedPages {
+		if p.freeOverflow[pageID] {
+			freeOverflow[pageID] = true
+		}
+	}
+	p.freeOverflow = freeOverflow
+}
+
+// readFreelistLog reads a freelist log, the last record may be torn
+func readFreelistLog(file pageFile) ([]byte, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, stat.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// replayFreelistLog applies the records of a freelist log to the deleted pages
+// The list keeps the order the pager had, a page freed again moves to the end.  The last record of a page wins,
+// so replaying a log over a snapshot which already includes it changes nothing.
+// Replay stops at a torn record or a record with an unknown operation.
+func replayFreelistLog(pages []int64, data []byte) []int64 {
+	order := make(map[int64]int, len(pages))
+	for i, pageID := range pages {
+		if _, ok := order[pageID]; !ok {
+			order[pageID] = i
+		}
+	}
+
+	for offset := 0; offset+FREELIST_RECORD_SIZE <= len(data); offset += FREELIST_RECORD_SIZE {
+		pageID := int64(binary.BigEndian.Uint64(data[offset:]))
+
+		switch data[offset+8] {
+		case freelistFree:
+			if _, ok := order[pageID]; !ok {
+				order[pageID] = len(pages) + offset
+			}
+		case freelistAlloc:
+			delete(order, pageID)
+		default:
+			offset = len(data)
+		}
+	}
+
+	replayed := make([]int64, 0, len(order))
+	for pageID := range order {
+		replayed = append(replayed, pageID)
+	}
+
+	slices.SortFunc(replayed, func(a, b int64) int {
+		return order[a] - order[b]
+	})
+
+	return replayed
+}
+
+// logFree records pages added to the deleted pages, deletedPagesLock must be held
+func (p *Pager) logFree(pages ...int64) {
+	for _, pageID := range pages {
+		p.freelistPending = appendFreelistRecord(p.freelistPending, pageID, freelistFree)
+	}
+}
+
+// logAlloc records a page taken from the deleted pages, deletedPagesLock must be held
+func (p *Pager) logAlloc(pageID int64) {
+	p.freelistPending = appendFreelistRecord(p.freelistPending, pageID, freelistAlloc)
+}
+
+// appendFreelistRecord appends a freelist log record to buf
+func appendFreelistRecord(buf []byte, pageID int64, op byte) []byte {
+	record := make([]byte, FREELIST_RECORD_SIZE)
+	binary.BigEndian.PutUint64(record[0:8], uint64(pageID))
+	record[8] = op
+	return append(buf, record...)
+}
+
+// flushFreelistLog appends the pending freelist records to the log
+// The deleted pages file is rewritten instead once the log grows past the checkpoint size, and for pagers without a log.
+// deletedPagesLock must be held
+func (p *Pager) flushFreelistLog() error {
+	if len(p.freelistPending) == 0 {
+		return nil
+	}
+
+	records := (p.freelistLogSize + int64(len(p.freelistPending))) / FREELIST_RECORD_SIZE
+	if p.freelistLog == nil || records > int64(max(freelistCheckpointRecords, len(p.deletedPages))) {
+		return p.writeDelPages()
+	}
+
+	// a partial write is overwritten by the next flush as the records stay pending
+	_, err := p.freelistLog.WriteAt(p.freelistPending, p.freelistLogSize)
+	if err != nil {
+		return err
+	}
+
+	p.freelistLogSize += int64(len(p.freelistPending))
+	p.freelistPending = p.freelistPending[:0]
+
+	return nil
+}
+
+// closeFreelistLog closes the freelist log and removes it, the deleted pages must have been checkpointed
+func (p *Pager) closeFreelistLog() error {
+	if p.freelistLog == nil {
+		return nil
+	}
+
+	err := p.freelistLog.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p.freelistLogName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,244 @@
+// Package btree
+// freelist log tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// crashCopy copies the files of a pager which was not closed as a crash would leave them
+func crashCopy(t *testing.T, from, to string) {
+	for _, suffix := range []string{"", ".del", ".del.log"} {
+		data, err := os.ReadFile(from + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(to+suffix, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPager_FreelistLog(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.del.log")
+	defer os.Remove("crash.db")
+	defer os.Remove("crash.db.del")
+	defer os.Remove("crash.db.del.log")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err = pager.Write([]byte("page"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pageID := range []int64{2, 5, 7} {
+		err = pager.DeletePage(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// page 7 is reused
+	_, err = pager.Write([]byte("reused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deletes are appended to the log instead of rewriting the deleted pages file
+	del, err := os.ReadFile("btree.db.del")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(del) != 0 {
+		t.Fatalf("expected the deleted pages file to be untouched, got %q", del)
+	}
+
+	log, err := os.ReadFile("btree.db.del.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(log) != 4*FREELIST_RECORD_SIZE {
+		t.Fatalf("expected 4 records in the log, got %d bytes", len(log))
+	}
+
+	// the log is replayed over the deleted pages file when reopening after a crash
+	crashCopy(t, "btree.db", "crash.db")
+
+	// a record torn by the crash is ignored
+	f, err := os.OpenFile("crash.db.del.log", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte{0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	crashed, err := OpenPager("crash.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(crashed.GetDeletedPages(), []int64{2, 5}) {
+		t.Fatalf("expected pages 2 and 5 to be deleted, got %v", crashed.GetDeletedPages())
+	}
+
+	// the replayed list is checkpointed
+	stat, err := os.Stat("crash.db.del.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Size() != 0 {
+		t.Fatalf("expected an empty log after opening, got %d bytes", stat.Size())
+	}
+
+	err = crashed.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the log is removed on close, the deleted pages file holds the list
+	_, err = os.Stat("crash.db.del.log")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the log to be removed on close, got %v", err)
+	}
+
+	del, err = os.ReadFile("crash.db.del")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(del) != "[2,5]" {
+		t.Fatalf("expected [2,5] in the deleted pages file, got %q", del)
+	}
+}
+
+func TestPager_FreelistLog_Checkpoint(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.del.log")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err = pager.Write([]byte("page"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every cycle logs a free and an alloc record while the list stays short
+	for i := 0; i < freelistCheckpointRecords; i++ {
+		err = pager.DeletePage(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = pager.Write([]byte("reused"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = pager.DeletePage(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat("btree.db.del.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Size() > freelistCheckpointRecords*FREELIST_RECORD_SIZE {
+		t.Fatalf("expected the log to be checkpointed, got %d bytes", stat.Size())
+	}
+
+	err = pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(pager.GetDeletedPages(), []int64{2}) {
+		t.Fatalf("expected page 2 to be deleted, got %v", pager.GetDeletedPages())
+	}
+}
+
+func TestReplayFreelistLog(t *testing.T) {
+	var log []byte
+	log = appendFreelistRecord(log, 4, freelistFree)
+	log = appendFreelistRecord(log, 1, freelistAlloc)
+	log = appendFreelistRecord(log, 1, freelistFree)
+	log = appendFreelistRecord(log, 3, freelistAlloc)
+
+	pages := replayFreelistLog([]int64{1, 2, 3}, log)
+	if !slices.Equal(pages, []int64{2, 4, 1}) {
+		t.Fatalf("expected [2 4 1], got %v", pages)
+	}
+
+	// replaying the log again changes nothing
+	if !slices.Equal(replayFreelistLog(pages, log), pages) {
+		t.Fatalf("expected replay to be idempotent, got %v", replayFreelistLog(pages, log))
+	}
+}
@@ -0,0 +1,149 @@
+// Package btree
+// health checks
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Health is a quick status of a btree for readiness probes, see BTree.Health
+type Health struct {
+	FileReachable    bool      // The btree file can be stat'ed
+	LayoutValid      bool      // The file was written with the page layout of this version and holds whole pages
+	RootDecodable    bool      // The root node can be read and decoded, true for an empty file
+	FreelistReadable bool      // The deleted pages file can be read and lists pages of the file
+	LastSync         time.Time // Time of the last successful sync of the file, the zero time if it was never synced
+	Err              error     // The first problem found, nil if the btree is healthy
+}
+
+// Healthy returns true if no problem was found
+func (h *Health) Healthy() bool {
+	return h.Err == nil
+}
+
+// Health checks that the file is reachable, its layout is valid, the root decodes and the deleted pages are readable
+// It reads the root and the deleted pages only, which makes it much cheaper than Check.  The checks stop at the first problem, which is
+// returned in Err.  The file, layout and deleted pages checks only apply to btrees stored by a Pager and pass for other storage.
+func (b *BTree) Health() *Health {
+	h := &Health{}
+
+	if b.closed.Load() {
+		h.Err = ErrClosed
+		return h
+	}
+
+	pager, ok := b.Pager.(*Pager)
+	if ok {
+		h.LastSync = pager.LastSync()
+
+		stat, err := pager.file.Stat()
+		if err != nil {
+			h.Err = fmt.Errorf("file not reachable: %w", err)
+			return h
+		}
+		h.FileReachable = true
+
+		if stat.Size()%(PAGE_SIZE+HEADER_SIZE) != 0 {
+			h.Err = fmt.Errorf("%w: file size %d is not a whole number of pages", ErrCorrupt, stat.Size())
+			return h
+		}
+
+		if b.name != "" {
+			layout, err := ReadLayout(b.name)
+			if err != nil {
+				h.Err = err
+				return h
+			}
+
+			if layout != CurrentLayout() {
+				h.Err = &FormatError{Name: b.name, Found: layout, Expected: CurrentLayout()}
+				return h
+			}
+		}
+		h.LayoutValid = true
+	} else {
+		h.FileReachable, h.LayoutValid = true, true
+	}
+
+	// the root of an empty file is only created by the first write
+	data, err := b.Pager.ReadPage(0)
+	if err == nil {
+		_, err = b.decodePage(data)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		h.Err = fmt.Errorf("root not decodable: %w", err)
+		return h
+	}
+	h.RootDecodable = true
+
+	if ok {
+		err = pager.checkDeletedPages()
+		if err != nil {
+			h.Err = fmt.Errorf("deleted pages not readable: %w", err)
+			return h
+		}
+	}
+	h.FreelistReadable = true
+
+	return h
+}
+
+// checkDeletedPages reads the deleted pages file and the freelist log and checks that it lists pages of the file
+func (p *Pager) checkDeletedPages() error {
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	pages, _, err := readDelPages(p.deletedPagesFile)
+	if err != nil {
+		return err
+	}
+
+	if p.freelistLog != nil {
+		data, err := readFreelistLog(p.freelistLog)
+		if err != nil {
+			return err
+		}
+		pages = replayFreelistLog(pages, data)
+	}
+
+	count := p.count.Load()
+	for _, pageID := range pages {
+		if pageID < 0 || pageID >= count {
+			return fmt.Errorf("%w: deleted page %d is not a page of the file", ErrCorrupt, pageID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// Package btree
+// health checks tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBTree_Health(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	// an empty file has no root yet
+	h := btree.Health()
+	if !h.Healthy() || !h.FileReachable || !h.LayoutValid || !h.RootDecodable || !h.FreelistReadable {
+		t.Fatalf("expected an empty btree to be healthy, got %+v", h)
+	}
+
+	if !h.LastSync.IsZero() {
+		t.Fatalf("expected no sync yet, got %v", h.LastSync)
+	}
+
+	for i := 0; i < 50; i++ {
+		err = btree.Put([]byte{byte(i)}, []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Pager.(*Pager).Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h = btree.Health()
+	if !h.Healthy() || h.LastSync.IsZero() {
+		t.Fatalf("expected a healthy synced btree, got %+v", h)
+	}
+
+	// deleted pages beyond the end of the file
+	err = os.WriteFile("btree.db.del", []byte("1000"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h = btree.Health()
+	if h.Healthy() || !h.RootDecodable || h.FreelistReadable || !errors.Is(h.Err, ErrCorrupt) {
+		t.Fatalf("expected the deleted pages to be reported, got %+v", h)
+	}
+
+	err = os.WriteFile("btree.db.del", nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// garbage in the data of the root
+	file, err := os.OpenFile("btree.db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = file.WriteAt([]byte{0x93, 0xff, 0xff}, HEADER_SIZE)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h = btree.Health()
+	if h.Healthy() || !h.LayoutValid || h.RootDecodable {
+		t.Fatalf("expected the root to be reported, got %+v", h)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h = btree.Health()
+	if h.Err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", h.Err)
+	}
+}
+
+func TestBTree_Health_Memory(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := btree.Health()
+	if !h.Healthy() || !h.RootDecodable || !h.FreelistReadable {
+		t.Fatalf("expected an in-memory btree to be healthy, got %+v", h)
+	}
+}
@@ -0,0 +1,214 @@
+// Package btree
+// page heatmap
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// DefaultHeatmapSample is the number of node reads one of which is counted when Options.HeatmapSample is not set
+const DefaultHeatmapSample = 8
+
+// PageHeat is the number of sampled reads of a page
+type PageHeat struct {
+	Page  int64  // The page number
+	Count uint64 // The number of sampled reads, halved every time the btree is opened
+}
+
+// heatmap counts sampled node reads per page, see Options.Heatmap
+// The counts are written to name.heat when the btree is closed and read back when it is opened, halved so old heat fades.
+type heatmap struct {
+	lock   sync.Mutex       // guards counts
+	counts map[int64]uint64 // sampled reads by page
+	sample uint64           // one in sample reads is counted
+	reads  atomic.Uint64    // number of reads, sampled or not
+	path   string           // the .heat file, empty for in-memory btrees
+	perm   os.FileMode      // the permissions of the .heat file
+}
+
+// heatRecord is the content of the .heat file
+type heatRecord struct {
+	Counts map[int64]uint64
+}
+
+// openHeatmap reads the counts of the previous runs from the .heat file next to the btree
+func (b *BTree) openHeatmap(sample int) error {
+	h := &heatmap{counts: make(map[int64]uint64), sample: uint64(sample), perm: b.perm}
+	if h.sample == 0 {
+		h.sample = DefaultHeatmapSample
+	}
+
+	if b.name != "" {
+		h.path = b.name + ".heat"
+
+		data, err := os.ReadFile(h.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if len(data) > 0 {
+			var r heatRecord
+			err = codec.NewDecoderBytes(data, msgpackHandle).Decode(&r)
+			if err != nil {
+				return err
+			}
+
+			for page, count := range r.Counts {
+				if count/2 > 0 {
+					h.counts[page] = count / 2
+				}
+			}
+		}
+	}
+
+	b.heat = h
+	return nil
+}
+
+// touch counts a read of page if it is sampled
+func (h *heatmap) touch(page int64) {
+	if h.reads.Add(1)%h.sample != 0 {
+		return
+	}
+
+	h.lock.Lock()
+	h.counts[page]++
+	h.lock.Unlock()
+}
+
+// forget drops the count of a freed page
+func (h *heatmap) forget(page int64) {
+	h.lock.Lock()
+	delete(h.counts, page)
+	h.lock.Unlock()
+}
+
+// remap moves the counts of pages which were moved to other pages, pages missing from mapping are dropped
+func (h *heatmap) remap(mapping map[int64]int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	counts := make(map[int64]uint64, len(h.counts))
+	for page, count := range h.counts {
+		if moved, ok := mapping[page]; ok {
+			counts[moved] = count
+		}
+	}
+	h.counts = counts
+}
+
+// hottest returns up to n pages by descending count, every page if n is 0 or less
+func (h *heatmap) hottest(n int) []PageHeat {
+	h.lock.Lock()
+	pages := make([]PageHeat, 0, len(h.counts))
+	for page, count := range h.counts {
+		pages = append(pages, PageHeat{Page: page, Count: count})
+	}
+	h.lock.Unlock()
+
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Count != pages[j].Count {
+			return pages[i].Count > pages[j].Count
+		}
+		return pages[i].Page < pages[j].Page
+	})
+
+	if n > 0 && len(pages) > n {
+		pages = pages[:n]
+	}
+	return pages
+}
+
+// close writes the counts to the .heat file, the file is replaced so a crash leaves the previous counts
+func (h *heatmap) close() error {
+	if h.path == "" {
+		return nil
+	}
+
+	h.lock.Lock()
+	var encoded []byte
+	err := codec.NewEncoderBytes(&encoded, msgpackHandle).Encode(&heatRecord{Counts: h.counts})
+	h.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := h.path + ".tmp"
+	err = os.WriteFile(tmp, encoded, h.perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, h.path)
+}
+
+// HotPages returns up to n of the most read pages by descending count, every counted page if n is 0 or less
+// Nil is returned for a btree opened without Options.Heatmap.
+func (b *BTree) HotPages(n int) []PageHeat {
+	if b.heat == nil {
+		return nil
+	}
+	return b.heat.hottest(n)
+}
+
+// Warmup reads the pages of the heatmap, hottest first, and returns the number of pages read
+// Reading a page loads it into the page cache of an ObjectPager or of the operating system, so the first queries after a restart
+// do not wait for the storage.  Pages which no longer exist are dropped from the heatmap.
+func (b *BTree) Warmup() (int, error) {
+	if b.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	if b.heat == nil {
+		return 0, nil
+	}
+
+	read := 0
+	for _, heat := range b.heat.hottest(0) {
+		_, err := b.Pager.ReadPage(heat.Page)
+		if errors.Is(err, ErrPageNotFound) {
+			b.heat.forget(heat.Page)
+			continue
+		} else if err != nil {
+			return read, err
+		}
+		read++
+	}
+
+	return read, nil
+}
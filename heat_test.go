@@ -0,0 +1,127 @@
+// Package btree
+// page heatmap tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_Heatmap(t *testing.T) {
+	defer os.Remove("heat.db")
+	defer os.Remove("heat.db.del")
+	defer os.Remove("heat.db.heat")
+
+	open := func() *BTree {
+		btree, err := OpenWithOptions("heat.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Heatmap: true, HeatmapSample: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return btree
+	}
+
+	btree := open()
+
+	for i := 0; i < 200; i++ {
+		err := btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the leaf of key 000 is read over and over
+	for i := 0; i < 100; i++ {
+		_, err := btree.Get([]byte("000"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := btree.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, _, err := btree.findNodeForKey(root, []byte("000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hot := btree.HotPages(1)
+	if len(hot) != 1 || hot[0].Page != leaf.Page {
+		t.Fatalf("expected the leaf of 000 at page %d to be the hottest page, got %v", leaf.Page, hot)
+	}
+
+	count := hot[0].Count
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the counts survive a restart, halved
+	btree = open()
+	defer btree.Close()
+
+	hot = btree.HotPages(1)
+	if len(hot) != 1 || hot[0].Page != leaf.Page || hot[0].Count != count/2 {
+		t.Fatalf("expected page %d with %d reads, got %v", leaf.Page, count/2, hot)
+	}
+
+	pages := len(btree.HotPages(0))
+
+	read, err := btree.Warmup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if read != pages {
+		t.Fatalf("expected %d pages read, got %d", pages, read)
+	}
+
+	// a btree without a heatmap has nothing to warm up
+	memory, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memory.Close()
+
+	if memory.HotPages(0) != nil {
+		t.Fatal("expected no heatmap")
+	}
+
+	read, err = memory.Warmup()
+	if err != nil || read != 0 {
+		t.Fatalf("expected nothing to warm up, got %d %v", read, err)
+	}
+}
@@ -0,0 +1,180 @@
+// Package btree
+// tree height
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// Height returns the number of levels of the tree, 1 for a tree whose root is a leaf
+// The height is persisted in the .height file with a fingerprint of the root, it is read from the leftmost path when the file
+// is missing or was written for another root, and then kept up to date by root splits and collapses
+func (b *BTree) Height() (int, error) {
+	if height := b.height.Load(); height > 0 {
+		return int(height), nil
+	}
+
+	x, err := b.getRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	if x.Leaf {
+		b.height.Store(1)
+		return 1, nil
+	}
+
+	height := b.readHeight(x)
+	if height == 0 {
+		root := x
+
+		height = 1
+		for !x.Leaf {
+			x, err = b.descendChild(x, 0, height)
+			if err != nil {
+				return 0, err
+			}
+			height++
+		}
+
+		err = b.writeHeight(root, height)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	b.height.Store(int64(height))
+
+	return height, nil
+}
+
+// grew records a root split, the tree is one level taller
+func (b *BTree) grew(root *Node) error {
+	for {
+		height := b.height.Load()
+		if height == 0 {
+			return nil
+		}
+
+		if b.height.CompareAndSwap(height, height+1) {
+			return b.writeHeight(root, int(height+1))
+		}
+	}
+}
+
+// shrank records a root collapse, the tree is one level shorter
+func (b *BTree) shrank(root *Node) error {
+	for {
+		height := b.height.Load()
+		if height == 0 {
+			return nil
+		}
+
+		if b.height.CompareAndSwap(height, height-1) {
+			return b.writeHeight(root, int(height-1))
+		}
+	}
+}
+
+// replaced forgets what is known about the shape of the tree after its pages were replaced or writes were discarded
+func (b *BTree) replaced() {
+	b.generation.Add(1)
+	b.height.Store(0)
+	b.shapeChanged()
+
+	// a height file left behind is written for another root, its fingerprint does not match
+	if b.persistsHeight() {
+		_ = os.Remove(b.name + ".height")
+	}
+}
+
+// stackSize returns the capacity traversal stacks are allocated with, the known height of the tree or 1 while it is unknown
+func (b *BTree) stackSize() int {
+	return max(int(b.height.Load()), 1)
+}
+
+// persistsHeight returns true if the height is persisted, only handles writing the file of the btree write the .height file
+func (b *BTree) persistsHeight() bool {
+	pager, ok := b.Pager.(*Pager)
+	return ok && b.name != "" && !pager.passive.Load() && b.HasLease()
+}
+
+// readHeight returns the height recorded in the .height file, 0 if there is none or it was written for another root
+func (b *BTree) readHeight(root *Node) int {
+	if b.name == "" {
+		return 0
+	}
+
+	data, err := os.ReadFile(b.name + ".height")
+	if err != nil {
+		return 0
+	}
+
+	var height int
+	var fingerprint uint32
+	_, err = fmt.Sscanf(string(data), "%d %d\n", &height, &fingerprint)
+	if err != nil || height < 2 || fingerprint != rootFingerprint(root) {
+		return 0
+	}
+
+	return height
+}
+
+// writeHeight records the height of the tree with the root it was measured for in the .height file
+func (b *BTree) writeHeight(root *Node, height int) error {
+	if !b.persistsHeight() {
+		return nil
+	}
+
+	return os.WriteFile(b.name+".height", []byte(fmt.Sprintf("%d %d\n", height, rootFingerprint(root))), b.perm)
+}
+
+// rootFingerprint returns a checksum of the children and separator keys of the root
+// Root splits and collapses always give the root new children, so a height file written before either does not match
+func rootFingerprint(root *Node) uint32 {
+	hash := crc32.NewIEEE()
+	for _, child := range root.Children {
+		hash.Write(binary.BigEndian.AppendUint64(nil, uint64(child)))
+	}
+
+	for _, k := range root.Keys {
+		if k != nil {
+			hash.Write(binary.BigEndian.AppendUint64(nil, uint64(len(k.K))))
+			hash.Write(k.K)
+		}
+	}
+
+	return hash.Sum32()
+}
@@ -0,0 +1,221 @@
+// Package btree
+// tree height tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkHeight compares the height kept by b with the height read from its pages
+func checkHeight(t *testing.T, b *BTree) int {
+	t.Helper()
+
+	kept, err := b.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.height.Store(0)
+
+	read, err := b.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kept != read {
+		t.Fatalf("expected a height of %d, got %d", read, kept)
+	}
+
+	return read
+}
+
+func TestBTree_Height(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if checkHeight(t, b) != 1 {
+		t.Fatal("expected an empty tree to have a height of 1")
+	}
+
+	for i := 0; i < 1000; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%04d", (i*7919)%1000)), []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i%100 == 0 {
+			checkHeight(t, b)
+		}
+	}
+
+	if checkHeight(t, b) < 4 {
+		t.Fatal("expected the tree to grow")
+	}
+}
+
+func TestBTree_Height_AppendOnly(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	_, err = b.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := b.AppendOnly()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		err = session.Append([]byte(fmt.Sprintf("%04d", i)), []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = session.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkHeight(t, b)
+}
+
+func TestBTree_Height_TxnAbort(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	before := checkHeight(t, b)
+
+	txn, err := b.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = txn.Abort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkHeight(t, b) != before {
+		t.Fatal("expected the aborted splits to be forgotten")
+	}
+}
+
+func TestBTree_Height_Persisted(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("height.db*")
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}()
+
+	b, err := Open("height.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the height file follows every root split
+	for i := 0; i < 1000; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	height := checkHeight(t, b)
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = Open("height.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	root, err := b.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorded := b.readHeight(root); recorded != height {
+		t.Fatalf("expected a recorded height of %d, got %d", height, recorded)
+	}
+
+	// a height file written for another root is not used
+	err = os.WriteFile("height.db.height", []byte(fmt.Sprintf("%d %d\n", height+1, rootFingerprint(root)+1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkHeight(t, b) != height {
+		t.Fatal("expected a stale height file to be ignored")
+	}
+
+	// every removal collapses the root back to a leaf
+	for i := 0; i < 1000; i++ {
+		err = b.Delete([]byte(fmt.Sprintf("%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkHeight(t, b)
+}
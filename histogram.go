@@ -0,0 +1,130 @@
+// Package btree
+// key histograms
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "errors"
+
+// KeyRange describes the keys stored in a node
+type KeyRange struct {
+	Start []byte // The first key in the node
+	End   []byte // The last key in the node
+	Count int    // The number of keys in the node
+	Page  int64  // The page number of the node
+}
+
+// KeyRangesByLeaf returns the key range of every non-empty leaf in key order
+func (b *BTree) KeyRangesByLeaf() ([]*KeyRange, error) {
+	ranges := make([]*KeyRange, 0)
+
+	err := b.walkLeafKeys(func(n *Node, keys []*Key) {
+		if len(keys) == 0 {
+			return
+		}
+
+		ranges = append(ranges, &KeyRange{
+			Start: keys[0].K,
+			End:   keys[len(keys)-1].K,
+			Count: len(keys),
+			Page:  n.Page,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ranges, nil
+}
+
+// Histogram returns buckets-1 keys splitting the keyspace into buckets holding about the same number of keys
+// Bucket i holds keys from boundary i-1 (inclusive) to boundary i (exclusive)
+// Keys of internal nodes are counted too, the keys are streamed in order so only the boundaries are kept in memory
+func (b *BTree) Histogram(buckets int) ([][]byte, error) {
+	if buckets < 1 {
+		return nil, errors.New("buckets must be greater than 0")
+	}
+
+	total, err := b.RangeCount(nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	boundaries := make([][]byte, 0, buckets-1)
+
+	// boundary i is the key at position i*total/buckets
+	pos, next := 0, 1
+	err = b.scanRangeOpt(nil, nil, nil, func(k *Key) error {
+		for next < buckets && next*total/buckets == pos {
+			next++
+
+			// duplicate boundaries happen when there are fewer keys than buckets
+			if len(boundaries) > 0 && equal(boundaries[len(boundaries)-1], k.K) {
+				continue
+			}
+
+			boundaries = append(boundaries, k.K)
+		}
+
+		pos++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return boundaries, nil
+}
+
+// walkLeafKeys calls fn for every leaf in key order with its live keys
+func (b *BTree) walkLeafKeys(fn func(n *Node, keys []*Key)) error {
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	// walk visits children left to right so leaves are visited in key order
+	return b.walk(root, func(n *Node) error {
+		if !n.Leaf {
+			return nil
+		}
+
+		keys := make([]*Key, 0, len(n.Keys))
+		for _, key := range n.Keys {
+			if key != nil && !key.hidden() {
+				keys = append(keys, key)
+			}
+		}
+
+		fn(n, keys)
+
+		return nil
+	})
+}
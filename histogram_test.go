@@ -0,0 +1,152 @@
+// Package btree
+// key histogram tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBTree_KeyRangesByLeaf(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("%03d", i)
+		err := btree.Put([]byte(key), []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ranges, err := btree.KeyRangesByLeaf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ranges) < 2 {
+		t.Fatalf("expected several leaves, got %d", len(ranges))
+	}
+
+	for i, r := range ranges {
+		if bytes.Compare(r.Start, r.End) > 0 {
+			t.Fatalf("expected leaf %d start to be before its end", i)
+		}
+
+		if i > 0 && bytes.Compare(ranges[i-1].End, r.Start) >= 0 {
+			t.Fatalf("expected leaf %d to start after the previous leaf", i)
+		}
+	}
+}
+
+func TestBTree_Histogram(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%04d", i)
+		err := btree.Put([]byte(key), []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	boundaries, err := btree.Histogram(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(boundaries) != 3 {
+		t.Fatalf("expected 3 boundaries, got %d", len(boundaries))
+	}
+
+	for i := 1; i < len(boundaries); i++ {
+		if bytes.Compare(boundaries[i-1], boundaries[i]) >= 0 {
+			t.Fatal("expected boundaries in ascending order")
+		}
+	}
+
+	// the buckets should be roughly even
+	for i, boundary := range boundaries {
+		keys, err := btree.LessThan(boundary)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := (i + 1) * 250
+		if len(keys) < expected-100 || len(keys) > expected+100 {
+			t.Fatalf("expected about %d keys below boundary %s, got %d", expected, boundary, len(keys))
+		}
+	}
+}
+
+func TestBTree_Histogram_InternalKeys(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("%04d", i)
+		err := btree.Put([]byte(key), []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// one bucket per key, every key but the first is a boundary including the keys of internal nodes
+	boundaries, err := btree.Histogram(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(boundaries) != 199 {
+		t.Fatalf("expected 199 boundaries, got %d", len(boundaries))
+	}
+
+	for i, boundary := range boundaries {
+		if string(boundary) != fmt.Sprintf("%04d", i+1) {
+			t.Fatalf("expected boundary %04d, got %s", i+1, boundary)
+		}
+	}
+}
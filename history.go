@@ -0,0 +1,239 @@
+// Package btree
+// time-travel reads and version retention
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// ErrHistoryPruned is returned by AsOf for a time the retention policy no longer keeps values for
+var ErrHistoryPruned = errors.New("history is pruned")
+
+// RetentionPolicy limits the history a versioned btree keeps, the newest value of a key is always kept
+type RetentionPolicy struct {
+	MaxVersions int           // Values kept per key, 0 keeps any number of values
+	MaxAge      time.Duration // Values committed longer ago are pruned, 0 keeps values of any age
+}
+
+// HistoricView reads a versioned btree as it was at a point in time, see AsOf
+type HistoricView struct {
+	b  *BTree // the btree
+	at int64  // the point in time in unix nanoseconds
+}
+
+// AsOf returns a read handle on the btree as it was at the time at
+// Versioned values record their commit time in ValueMeta.Created, the view sees for every key the newest value committed at or before at.
+// Values stored without a commit time count as committed at any time.  History removed by Delete, Remove or the retention policy is not seen.
+// ErrHistoryPruned is returned if at is older than the retention policy keeps values for.
+func (b *BTree) AsOf(at time.Time) (*HistoricView, error) {
+	if !b.versioned {
+		return nil, ErrNotVersioned
+	}
+
+	if b.retention != nil && b.retention.MaxAge > 0 && at.Before(time.Now().Add(-b.retention.MaxAge)) {
+		return nil, ErrHistoryPruned
+	}
+
+	return &HistoricView{b: b, at: at.UnixNano()}, nil
+}
+
+// Get returns the value of a key as of the time of the view and its version
+// A nil value is returned if the key had no value at that time
+func (h *HistoricView) Get(k []byte) ([]byte, uint64, error) {
+	key, err := h.b.Get(k)
+	if err != nil || key == nil {
+		return nil, 0, err
+	}
+
+	i := h.find(key)
+	if i == -1 {
+		return nil, 0, nil
+	}
+
+	return key.V[i], key.version(i), nil
+}
+
+// Range returns the keys within [start, end] which had a value at the time of the view
+// Every key holds a single value, the value as of that time, with its version and metadata
+func (h *HistoricView) Range(start, end []byte) ([]*Key, error) {
+	keys, err := h.b.Range(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Key, 0, len(keys))
+	for _, key := range keys {
+		k := key.(*Key)
+
+		i := h.find(k)
+		if i == -1 {
+			continue
+		}
+
+		result = append(result, &Key{K: k.K, V: [][]byte{k.V[i]}, E: k.E, M: []*ValueMeta{k.meta(i)}, Ver: []uint64{k.version(i)}})
+	}
+
+	return result, nil
+}
+
+// find returns the index of the newest value committed at or before the time of the view, -1 if there is none
+func (h *HistoricView) find(k *Key) int {
+	found := -1
+	for i := range k.V {
+		if k.created(i) <= h.at && (found == -1 || k.version(i) >= k.version(found)) {
+			found = i
+		}
+	}
+	return found
+}
+
+// meta returns the metadata of the value at index i, nil if it has none
+func (k *Key) meta(i int) *ValueMeta {
+	if i < len(k.M) {
+		return k.M[i]
+	}
+	return nil
+}
+
+// created returns the commit time of the value at index i, 0 if it has none
+func (k *Key) created(i int) int64 {
+	if meta := k.meta(i); meta != nil {
+		return meta.Created
+	}
+	return 0
+}
+
+// PruneVersions applies the retention policy to every key and returns the number of values pruned
+// Keys are also pruned as they are written, this prunes values which aged out since their key was last written.
+func (b *BTree) PruneVersions() (int, error) {
+	if !b.versioned {
+		return 0, ErrNotVersioned
+	}
+
+	if b.retention == nil {
+		return 0, errors.New("btree has no retention policy")
+	}
+
+	pruned := 0
+	err := b.atomic(func() error {
+		root, err := b.getRoot()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UnixNano()
+
+		return b.walk(root, func(n *Node) error {
+			before := pruned
+			for _, k := range n.Keys {
+				if k == nil {
+					continue
+				}
+
+				count, err := b.pruneKey(k, now)
+				if err != nil {
+					return err
+				}
+				pruned += count
+			}
+
+			if pruned == before {
+				return nil
+			}
+			return b.writeNode(n)
+		})
+	})
+
+	return pruned, err
+}
+
+// pruneKey removes the values of a key the retention policy no longer keeps and returns how many were removed, the key is not written
+func (b *BTree) pruneKey(k *Key, now int64) (int, error) {
+	if b.retention == nil || len(k.V) < 2 {
+		return 0, nil
+	}
+
+	// values ordered from the oldest version, the newest is never pruned
+	order := make([]int, len(k.V))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, c int) int {
+		if k.version(a) < k.version(c) {
+			return -1
+		} else if k.version(a) > k.version(c) {
+			return 1
+		}
+		return 0
+	})
+
+	drop := make([]bool, len(k.V))
+	for rank, i := range order[:len(order)-1] {
+		if b.retention.MaxVersions > 0 && rank < len(order)-b.retention.MaxVersions {
+			drop[i] = true
+		}
+
+		if b.retention.MaxAge > 0 && k.created(i) != 0 && k.created(i) < now-int64(b.retention.MaxAge) {
+			drop[i] = true
+		}
+	}
+
+	pruned := 0
+	for i := len(k.V) - 1; i >= 0; i-- {
+		if !drop[i] {
+			continue
+		}
+
+		var value []byte
+		if k.H != 0 {
+			var err error
+			value, err = b.resolveValue(k, i)
+			if err != nil {
+				return pruned, err
+			}
+		}
+
+		k.removeValue(i)
+		pruned++
+
+		if k.H != 0 {
+			err := b.valueRemoved(k, value)
+			if err != nil {
+				return pruned, err
+			}
+		}
+	}
+
+	return pruned, nil
+}
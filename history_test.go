@@ -0,0 +1,241 @@
+// Package btree
+// time-travel read and version retention tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBTree_AsOf(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	before := time.Now()
+
+	times := make([]time.Time, 0)
+	for i := 1; i <= 3; i++ {
+		time.Sleep(time.Millisecond)
+
+		for j := 0; j < 20; j++ {
+			err = b.Put([]byte(fmt.Sprintf("key%02d", j)), []byte(fmt.Sprintf("v%d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		times = append(times, time.Now())
+	}
+
+	for i, at := range times {
+		view, err := b.AsOf(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, version, err := view.Get([]byte("key05"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(value) != fmt.Sprintf("v%d", i+1) || version != uint64(i+1) {
+			t.Fatalf("expected v%d at version %d, got %s at %d", i+1, i+1, value, version)
+		}
+
+		keys, err := view.Range([]byte("key00"), []byte("key19"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != 20 || len(keys[0].V) != 1 || string(keys[0].V[0]) != fmt.Sprintf("v%d", i+1) || keys[0].Ver[0] != uint64(i+1) {
+			t.Fatalf("expected 20 keys as of v%d, got %d", i+1, len(keys))
+		}
+	}
+
+	// nothing was committed yet
+	view, err := b.AsOf(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, _, err := view.Get([]byte("key05"))
+	if err != nil || value != nil {
+		t.Fatalf("expected no value, got %s %v", value, err)
+	}
+
+	keys, err := view.Range(nil, nil)
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("expected no keys, got %d %v", len(keys), err)
+	}
+
+	// metadata written with a value sets its commit time
+	err = b.PutWithMeta([]byte("key05"), []byte("backdated"), ValueMeta{Created: before.UnixNano()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, version, err := view.Get([]byte("key05"))
+	if err != nil || string(value) != "backdated" || version != 4 {
+		t.Fatalf("expected the backdated value, got %s at %d %v", value, version, err)
+	}
+}
+
+func TestBTree_AsOf_NotVersioned(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	_, err = b.AsOf(time.Now())
+	if !errors.Is(err, ErrNotVersioned) {
+		t.Fatalf("expected ErrNotVersioned, got %v", err)
+	}
+
+	_, err = b.PruneVersions()
+	if !errors.Is(err, ErrNotVersioned) {
+		t.Fatalf("expected ErrNotVersioned, got %v", err)
+	}
+}
+
+func TestBTree_Retention(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Versioned: true, Retention: &RetentionPolicy{MaxVersions: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 1; i <= 10; i++ {
+		err = b.Put([]byte("key"), []byte(fmt.Sprintf("v%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.PutMulti([]byte("key"), []byte("v11"), []byte("v12"), []byte("v13"), []byte("v14"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := b.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 3 || string(key.V[0]) != "v12" || key.Ver[2] != 14 {
+		t.Fatalf("expected the 3 newest values, got %q %v", key.V, key.Ver)
+	}
+
+	value, err := b.GetAt([]byte("key"), 5)
+	if err != nil || value != nil {
+		t.Fatalf("expected version 5 to be pruned, got %s %v", value, err)
+	}
+}
+
+func TestBTree_Retention_MaxAge(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Hour * 2).UnixNano()
+
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+
+		err = b.PutWithMeta(key, []byte("old"), ValueMeta{Created: old})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = b.PutWithMeta(key, []byte("older"), ValueMeta{Created: old - 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// values written before the policy was set are pruned by PruneVersions
+	b, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Versioned: true, Retention: &RetentionPolicy{MaxAge: time.Hour}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// the newest value of a key is kept however old it is
+	pruned, err := b.PruneVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pruned != 30 {
+		t.Fatalf("expected 30 pruned values, got %d", pruned)
+	}
+
+	key, err := b.Get([]byte("key07"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 1 || string(key.V[0]) != "older" {
+		t.Fatalf("expected the newest version, got %q", key.V)
+	}
+
+	_, err = b.AsOf(time.Now().Add(-time.Hour * 2))
+	if !errors.Is(err, ErrHistoryPruned) {
+		t.Fatalf("expected ErrHistoryPruned, got %v", err)
+	}
+
+	err = b.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
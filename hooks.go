@@ -0,0 +1,130 @@
+// Package btree
+// event hooks
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+// Hooks are optional callbacks fired as the tree changes shape
+// Callbacks run synchronously inside the operation causing the change and must not use the tree
+type Hooks struct {
+	OnSplit     func(event SplitEvent) // A node was split in two
+	OnRootSplit func(event SplitEvent) // The root was split and the tree grew a level, OnSplit is fired as well
+	OnMerge     func(event MergeEvent) // Two sibling nodes were merged into one
+	OnAllocate  func(pageID int64)     // A page was allocated for a new node
+	OnFree      func(pageID int64)     // The page of a node was freed
+}
+
+// SplitEvent describes a node split
+type SplitEvent struct {
+	Left   KeyRange // The node which was split, keeping the keys before the median
+	Right  KeyRange // The new node holding the keys after the median
+	Median []byte   // The key moved up to the parent
+	Leaf   bool     // True if the split nodes are leaves
+}
+
+// MergeEvent describes two sibling nodes merged into one
+type MergeEvent struct {
+	Merged KeyRange // The node holding the keys of both siblings
+	Freed  int64    // The page of the right sibling which was freed
+	Leaf   bool     // True if the merged nodes are leaves
+}
+
+// onSplit fires the split hook
+func (b *BTree) onSplit(y, z *Node, median []byte) {
+	if b.hooks == nil || b.hooks.OnSplit == nil {
+		return
+	}
+	b.hooks.OnSplit(SplitEvent{Left: nodeRange(y), Right: nodeRange(z), Median: median, Leaf: y.Leaf})
+}
+
+// onRootSplit fires the root split hook for a new root holding the median and the two halves of the old root
+func (b *BTree) onRootSplit(root, left *Node) error {
+	if b.hooks == nil || b.hooks.OnRootSplit == nil {
+		return nil
+	}
+
+	right, err := b.readNode(root.Children[1])
+	if err != nil {
+		return err
+	}
+
+	b.hooks.OnRootSplit(SplitEvent{Left: nodeRange(left), Right: nodeRange(right), Median: root.Keys[0].K, Leaf: left.Leaf})
+	return nil
+}
+
+// onMerge fires the merge hook
+func (b *BTree) onMerge(merged *Node, freed int64) {
+	if b.hooks == nil || b.hooks.OnMerge == nil {
+		return
+	}
+	b.hooks.OnMerge(MergeEvent{Merged: nodeRange(merged), Freed: freed, Leaf: merged.Leaf})
+}
+
+// allocatePage allocates a page for a node firing the allocate hook
+func (b *BTree) allocatePage(data []byte) (int64, error) {
+	pageID, err := b.Pager.Allocate(data)
+	if err != nil {
+		return -1, err
+	}
+
+	if b.hooks != nil && b.hooks.OnAllocate != nil {
+		b.hooks.OnAllocate(pageID)
+	}
+
+	return pageID, nil
+}
+
+// freePage frees the page of a node firing the free hook
+func (b *BTree) freePage(pageID int64) error {
+	err := b.Pager.Free(pageID)
+	if err != nil {
+		return err
+	}
+
+	if b.hooks != nil && b.hooks.OnFree != nil {
+		b.hooks.OnFree(pageID)
+	}
+
+	if b.heat != nil {
+		b.heat.forget(pageID)
+	}
+
+	return nil
+}
+
+// nodeRange returns the key range of a node
+func nodeRange(n *Node) KeyRange {
+	r := KeyRange{Count: len(n.Keys), Page: n.Page}
+	if len(n.Keys) > 0 {
+		r.Start = n.Keys[0].K
+		r.End = n.Keys[len(n.Keys)-1].K
+	}
+	return r
+}
@@ -0,0 +1,106 @@
+// Package btree
+// event hooks tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_Hooks(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	splits, rootSplits, merges := 0, 0, 0
+	allocated := make(map[int64]bool)
+	freed := make(map[int64]bool)
+
+	hooks := &Hooks{
+		OnSplit: func(event SplitEvent) {
+			splits++
+			if bytes.Compare(event.Left.End, event.Median) >= 0 || bytes.Compare(event.Median, event.Right.Start) >= 0 {
+				t.Fatalf("expected the median between both halves, got %+v", event)
+			}
+		},
+		OnRootSplit: func(event SplitEvent) {
+			rootSplits++
+		},
+		OnMerge: func(event MergeEvent) {
+			merges++
+			if !freed[event.Freed] {
+				t.Fatalf("expected page %d to be freed before the merge event", event.Freed)
+			}
+		},
+		OnAllocate: func(pageID int64) {
+			allocated[pageID] = true
+		},
+		OnFree: func(pageID int64) {
+			freed[pageID] = true
+		},
+	}
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Hooks: hooks})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if splits == 0 || rootSplits == 0 || rootSplits >= splits {
+		t.Fatalf("unexpected splits %d and root splits %d", splits, rootSplits)
+	}
+
+	// every split allocates a node, a root split allocates two
+	if len(allocated) != splits+rootSplits {
+		t.Fatalf("expected %d allocated pages, got %d", splits+rootSplits, len(allocated))
+	}
+
+	for i := 0; i < 10; i++ {
+		err = btree.Delete([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if merges == 0 || len(freed) == 0 {
+		t.Fatalf("expected merges after deleting, got %d merges and %d freed pages", merges, len(freed))
+	}
+}
@@ -0,0 +1,231 @@
+// Package btree
+// idempotent puts
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+)
+
+// DefaultIdempotencyWindow is the number of operation ids PutIdempotent remembers when Options.IdempotencyWindow is not set
+const DefaultIdempotencyWindow = 4096
+
+// OP_ID_SIZE is the size of an operation id record in the .ops file
+const OP_ID_SIZE = 8
+
+// opLog remembers the ids of the operations applied by PutIdempotent, the oldest ids are forgotten once the window is full
+// The ids are appended to name.ops next to the btree file, kept in memory for in-memory btrees.
+type opLog struct {
+	lock    sync.Locker         // serializes PutIdempotent, a no-op if locking is disabled
+	file    *os.File            // the .ops file opened for appending, nil for in-memory btrees
+	path    string              // the path of the .ops file
+	perm    os.FileMode         // the permissions of the .ops file
+	window  int                 // number of ids remembered
+	ids     []uint64            // the remembered ids, oldest first
+	seen    map[uint64]struct{} // the remembered ids
+	records int                 // number of ids in the .ops file, it is compacted once it holds twice the window
+}
+
+// PutIdempotent appends a value to a key like Put unless an operation with the same id was applied before, true is returned if the value was put
+// The ids of the last Options.IdempotencyWindow operations are remembered across restarts, so a pipeline delivering
+// writes at least once can retry a write without duplicating its value.  The id is recorded after the value is put,
+// a crash between both leaves the operation unrecorded and a retry puts the value again.
+func (b *BTree) PutIdempotent(opID uint64, k, v []byte) (bool, error) {
+	if b.closed.Load() {
+		return false, ErrClosed
+	}
+
+	ops, err := b.opLog()
+	if err != nil {
+		return false, err
+	}
+
+	ops.lock.Lock()
+	defer ops.lock.Unlock()
+
+	if _, ok := ops.seen[opID]; ok {
+		return false, nil
+	}
+
+	err = b.Put(k, v)
+	if err != nil {
+		return false, err
+	}
+
+	return true, ops.record(opID)
+}
+
+// opLog opens the operation ids of PutIdempotent on first use
+func (b *BTree) opLog() (*opLog, error) {
+	b.opsLock.Lock()
+	defer b.opsLock.Unlock()
+
+	if b.ops != nil {
+		return b.ops, nil
+	}
+
+	ops := &opLog{lock: &sync.Mutex{}, perm: b.perm, window: b.opsWindow, seen: make(map[uint64]struct{})}
+	if ops.window <= 0 {
+		ops.window = DefaultIdempotencyWindow
+	}
+
+	if b.noLocking {
+		ops.lock = noLock{}
+	}
+
+	if b.name != "" {
+		ops.path = b.name + ".ops"
+
+		data, err := os.ReadFile(ops.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		// a record torn by a crash is ignored
+		for i := 0; i+OP_ID_SIZE <= len(data); i += OP_ID_SIZE {
+			ops.remember(binary.BigEndian.Uint64(data[i:]))
+			ops.records++
+		}
+
+		if len(data)%OP_ID_SIZE != 0 {
+			err = os.Truncate(ops.path, int64(ops.records*OP_ID_SIZE))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ops.file, err = os.OpenFile(ops.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, b.perm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.ops = ops
+	return ops, nil
+}
+
+// remember adds an id to the window, forgetting the oldest id if the window is full
+func (o *opLog) remember(id uint64) {
+	if _, ok := o.seen[id]; ok {
+		return
+	}
+
+	if len(o.ids) >= o.window {
+		delete(o.seen, o.ids[0])
+		o.ids = o.ids[1:]
+	}
+
+	o.ids = append(o.ids, id)
+	o.seen[id] = struct{}{}
+}
+
+// record remembers an applied id, appends it to the .ops file and syncs it
+func (o *opLog) record(id uint64) error {
+	o.remember(id)
+
+	if o.file == nil {
+		return nil
+	}
+
+	_, err := o.file.Write(binary.BigEndian.AppendUint64(nil, id))
+	if err != nil {
+		return err
+	}
+
+	err = o.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	o.records++
+	if o.records < 2*o.window {
+		return nil
+	}
+
+	return o.compact()
+}
+
+// compact rewrites the .ops file with the remembered ids only
+// The ids are written to a temporary file which replaces the .ops file, a crash leaves either file complete.
+func (o *opLog) compact() error {
+	data := make([]byte, 0, len(o.ids)*OP_ID_SIZE)
+	for _, id := range o.ids {
+		data = binary.BigEndian.AppendUint64(data, id)
+	}
+
+	tmp := o.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, o.perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, o.path)
+	if err != nil {
+		return err
+	}
+
+	err = o.file.Close()
+	if err != nil {
+		return err
+	}
+
+	o.file, err = os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, o.perm)
+	if err != nil {
+		return err
+	}
+
+	o.records = len(o.ids)
+	return nil
+}
+
+// close closes the .ops file
+func (o *opLog) close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.file == nil {
+		return nil
+	}
+
+	return o.file.Close()
+}
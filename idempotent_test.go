@@ -0,0 +1,169 @@
+// Package btree
+// idempotent puts tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBTree_PutIdempotent(t *testing.T) {
+	defer os.Remove("idempotent.db")
+	defer os.Remove("idempotent.db.del")
+	defer os.Remove("idempotent.db.ops")
+
+	open := func() *BTree {
+		btree, err := OpenWithOptions("idempotent.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{IdempotencyWindow: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return btree
+	}
+
+	btree := open()
+
+	for _, op := range []uint64{1, 2, 1, 3, 2} {
+		_, err := btree.PutIdempotent(op, []byte("key"), []byte{byte(op)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 3 {
+		t.Fatalf("expected 3 values, got %d", len(key.V))
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the ids are remembered after reopening
+	btree = open()
+
+	applied, err := btree.PutIdempotent(3, []byte("key"), []byte{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied {
+		t.Fatal("expected operation 3 to be ignored after reopening")
+	}
+
+	// the window holds 4 ids, operation 1 is forgotten after 4 more
+	for op := uint64(4); op < 8; op++ {
+		applied, err = btree.PutIdempotent(op, []byte("other"), []byte{byte(op)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !applied {
+			t.Fatalf("expected operation %d to be applied", op)
+		}
+	}
+
+	applied, err = btree.PutIdempotent(1, []byte("key"), []byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !applied {
+		t.Fatal("expected operation 1 to be forgotten")
+	}
+
+	// the file was compacted to the window
+	info, err := os.Stat("idempotent.db.ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() > 2*4*OP_ID_SIZE {
+		t.Fatalf("expected the ops file to be compacted, it holds %d bytes", info.Size())
+	}
+
+	// a torn record is ignored
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile("idempotent.db.ops", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Write([]byte{0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	btree = open()
+	defer btree.Close()
+
+	applied, err = btree.PutIdempotent(7, []byte("other"), []byte{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied {
+		t.Fatal("expected operation 7 to be ignored")
+	}
+
+	// in-memory btrees remember the ids while they are open
+	memory, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memory.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err = memory.PutIdempotent(1, []byte("key"), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err = memory.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 1 {
+		t.Fatalf("expected 1 value, got %d", len(key.V))
+	}
+}
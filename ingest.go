@@ -0,0 +1,379 @@
+// Package btree
+// ingest mode
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrIngesting is returned by writes other than Put and PutMulti while an ingest is in progress, and by Get if IngestOptions.FailReads is set
+var ErrIngesting = errors.New("btree is ingesting")
+
+// IngestOptions configures an ingest
+type IngestOptions struct {
+	FailReads bool // Get returns ErrIngesting instead of consulting the spill log
+}
+
+// ingestLog is the spill log of an ingest, every put is appended as a key and value record
+// Records are a uvarint key length, the key, a uvarint value length and the value.
+type ingestLog struct {
+	lock     sync.Mutex              // guards the log
+	path     string                  // the spill log, the name of the btree with .ingest
+	file     *os.File                // the spill log
+	w        *bufio.Writer           // buffers appended records
+	size     int64                   // bytes of the records in the log
+	index    map[string][]spillValue // the values of every key in put order
+	opts     IngestOptions           // the options of the ingest
+	finished bool                    // true once FinishIngest took the log, puts go to the tree
+}
+
+// ingestSlot holds the spill log of the ingest in progress, puts load it without locking
+type ingestSlot struct {
+	atomic.Pointer[ingestLog]
+}
+
+// spillValue is the position of a value in the spill log
+type spillValue struct {
+	off int64 // offset of the value
+	n   int   // length of the value
+}
+
+// StartIngest starts an ingest for an initial load, Put and PutMulti append to a spill log (btree.db.ingest) instead of the tree
+// FinishIngest sorts the spilled keys and adds them to the tree without splitting a node per put.  Other writes return ErrIngesting.
+// A spill log left by a crash is read back so the interrupted ingest continues.  The restrictions of AppendOnly apply.
+func (b *BTree) StartIngest(opts *IngestOptions) error {
+	if b.closed.Load() {
+		return ErrClosed
+	}
+
+	if b.name == "" {
+		return errors.New("ingest needs a btree backed by a file")
+	}
+
+	if b.versioned || b.valueCompare != nil || b.uniqueValues || b.keyVersions {
+		return errors.New("ingest does not support versioned btrees, value comparators, unique values or key versions")
+	}
+
+	if b.ingest.Load() != nil {
+		return ErrIngesting
+	}
+
+	if opts == nil {
+		opts = &IngestOptions{}
+	}
+
+	in, err := openIngestLog(b.name+".ingest", b.perm)
+	if err != nil {
+		return err
+	}
+	in.opts = *opts
+
+	b.ingest.Store(in)
+
+	return nil
+}
+
+// openIngestLog opens a spill log and indexes its records, a torn record at the end is truncated
+func openIngestLog(path string, perm os.FileMode) (*ingestLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &ingestLog{path: path, file: file, index: make(map[string][]spillValue)}
+
+	r := bufio.NewReader(file)
+	for {
+		key, value, n, err := readSpillRecord(r, in.size)
+		if err != nil {
+			break
+		}
+
+		in.index[string(key)] = append(in.index[string(key)], value)
+		in.size += n
+	}
+
+	err = file.Truncate(in.size)
+	if err == nil {
+		_, err = file.Seek(in.size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	in.w = bufio.NewWriter(file)
+
+	return in, nil
+}
+
+// readSpillRecord reads the record at off and returns its key, the position of its value and its length
+func readSpillRecord(r *bufio.Reader, off int64) ([]byte, spillValue, int64, error) {
+	keyLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, spillValue{}, 0, err
+	}
+
+	key := make([]byte, keyLen)
+	_, err = io.ReadFull(r, key)
+	if err != nil {
+		return nil, spillValue{}, 0, err
+	}
+
+	valueLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, spillValue{}, 0, err
+	}
+
+	_, err = r.Discard(int(valueLen))
+	if err != nil {
+		return nil, spillValue{}, 0, err
+	}
+
+	header := int64(uvarintLen(keyLen)) + int64(keyLen) + int64(uvarintLen(valueLen))
+	value := spillValue{off: off + header, n: int(valueLen)}
+
+	return key, value, header + int64(valueLen), nil
+}
+
+// uvarintLen returns the bytes of x encoded as a uvarint
+func uvarintLen(x uint64) int {
+	return len(binary.AppendUvarint(nil, x))
+}
+
+// spill appends the values of a key to the spill log during an ingest, false is returned if there is no ingest
+func (b *BTree) spill(key []byte, values [][]byte) (bool, error) {
+	in := b.ingest.Load()
+	if in == nil {
+		return false, nil
+	}
+
+	if b.closed.Load() {
+		return true, ErrClosed
+	}
+
+	err := b.checkKeySize(key)
+	if err != nil {
+		return true, err
+	}
+
+	return in.put(key, values)
+}
+
+// put appends the values of a key to the spill log, false is returned if the ingest finished and the values belong in the tree
+func (in *ingestLog) put(key []byte, values [][]byte) (bool, error) {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	if in.finished {
+		return false, nil
+	}
+
+	for _, v := range values {
+		record := binary.AppendUvarint(nil, uint64(len(key)))
+		record = append(record, key...)
+		record = binary.AppendUvarint(record, uint64(len(v)))
+
+		off := in.size + int64(len(record))
+
+		_, err := in.w.Write(append(record, v...))
+		if err != nil {
+			return true, err
+		}
+
+		in.index[string(key)] = append(in.index[string(key)], spillValue{off: off, n: len(v)})
+		in.size = off + int64(len(v))
+	}
+
+	return true, nil
+}
+
+// values reads the spilled values of a key, the caller holds the lock
+func (in *ingestLog) values(key string) ([][]byte, error) {
+	err := in.w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, 0, len(in.index[key]))
+	for _, sv := range in.index[key] {
+		v := make([]byte, sv.n)
+		_, err = in.file.ReadAt(v, sv.off)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+// get adds the spilled values of a key to the key read from the tree, k is nil if the tree does not hold the key
+func (in *ingestLog) get(key []byte, k *Key) (*Key, error) {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	if in.opts.FailReads && !in.finished {
+		return nil, ErrIngesting
+	}
+
+	if in.finished || len(in.index[string(key)]) == 0 {
+		return k, nil
+	}
+
+	values, err := in.values(string(key))
+	if err != nil {
+		return nil, err
+	}
+
+	// the key read from the tree may be a node's own key, the values are added to a copy
+	merged := &Key{K: key}
+	if k != nil {
+		*merged = *k
+	}
+	merged.V = append(slices.Clip(merged.V), values...)
+
+	return merged, nil
+}
+
+// close writes the buffered records and closes the spill log, the ingest continues once StartIngest is called again
+// The caller holds the lock.
+func (in *ingestLog) close() error {
+	err := in.w.Flush()
+	if err != nil {
+		in.file.Close()
+		return err
+	}
+
+	return in.file.Close()
+}
+
+// FinishIngest sorts the spilled keys and adds them to the tree, then removes the spill log
+// Keys greater than the greatest key of the tree are appended to its right edge filling every leaf, smaller keys are inserted.
+func (b *BTree) FinishIngest() (err error) {
+	in := b.ingest.Load()
+	if in == nil {
+		return errors.New("no ingest in progress")
+	}
+
+	// puts wait for the keys to be added and then go to the tree
+	in.lock.Lock()
+	defer in.lock.Unlock()
+
+	in.finished = true
+	b.ingest.Store(nil)
+
+	// the spill log is kept for another ingest if the keys could not be added
+	defer func() {
+		if err != nil {
+			in.close()
+		}
+	}()
+
+	greatest, err := b.greatestKey()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(in.index))
+	for k := range in.index {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	session := &AppendSession{b: b}
+	for _, k := range keys {
+		values, err := in.values(k)
+		if err != nil {
+			return err
+		}
+
+		key := []byte(k)
+		if greatest != nil && bytes.Compare(key, greatest) <= 0 {
+			err = b.atomic(func() error {
+				return b.putValues(key, values)
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, v := range values {
+			err = session.append(key, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = session.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = in.file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(in.path)
+}
+
+// greatestKey returns the last key of the rightmost leaf, nil if the tree is empty
+func (b *BTree) greatestKey() ([]byte, error) {
+	x, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	for depth := 1; !x.Leaf; depth++ {
+		x, err = b.descendChild(x, len(x.Children)-1, depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(x.Keys) == 0 {
+		return nil, nil
+	}
+
+	return x.Keys[len(x.Keys)-1].K, nil
+}
@@ -0,0 +1,156 @@
+// Package btree
+// ingest mode tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_Ingest(t *testing.T) {
+	defer os.Remove("ingest.db")
+	defer os.Remove("ingest.db.del")
+	defer os.Remove("ingest.db.ingest")
+
+	btree, err := Open("ingest.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys below the greatest key of the tree are inserted, the rest are appended
+	err = btree.Put([]byte("key-0500"), []byte("existing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.StartIngest(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(btree.StartIngest(nil), ErrIngesting) {
+		t.Fatal("expected ErrIngesting starting a second ingest")
+	}
+
+	// keys are put in descending order
+	for i := 999; i >= 0; i-- {
+		err = btree.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.PutMulti([]byte("key-0010"), []byte("a"), []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(btree.Delete([]byte("key-0010")), ErrIngesting) {
+		t.Fatal("expected ErrIngesting deleting during an ingest")
+	}
+
+	// gets consult the tree and the spill log
+	key, err := btree.Get([]byte("key-0500"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || fmt.Sprintf("%s", key.V) != "[existing value-500]" {
+		t.Fatalf("expected the values of the tree and the spill log, got %v", key)
+	}
+
+	// the spill log survives closing the btree
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = Open("ingest.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	err = btree.StartIngest(&IngestOptions{FailReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = btree.Get([]byte("key-0010"))
+	if !errors.Is(err, ErrIngesting) {
+		t.Fatalf("expected ErrIngesting reading during an ingest, got %v", err)
+	}
+
+	err = btree.FinishIngest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat("ingest.db.ingest")
+	if !os.IsNotExist(err) {
+		t.Fatal("expected the spill log to be removed")
+	}
+
+	checkBTree(t, btree)
+
+	for i := 0; i < 1000; i++ {
+		key, err := btree.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := fmt.Sprintf("[value-%d]", i)
+		switch i {
+		case 10:
+			expected = "[value-10 a b]"
+		case 500:
+			expected = "[existing value-500]"
+		}
+
+		if key == nil || fmt.Sprintf("%s", key.V) != expected {
+			t.Fatalf("expected %s for key %d, got %v", expected, i, key)
+		}
+	}
+
+	// writes go to the tree once the ingest finished
+	err = btree.Delete([]byte("key-0010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.FinishIngest()
+	if err == nil {
+		t.Fatal("expected an error finishing without an ingest")
+	}
+}
@@ -0,0 +1,113 @@
+// Package btree
+// key versions
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "errors"
+
+// ErrVersionMismatch is returned by PutIfVersion when the key changed since its version was read
+var ErrVersionMismatch = errors.New("key version does not match")
+
+// ErrNoKeyVersions is returned by key version operations on a btree opened without Options.KeyVersions
+var ErrNoKeyVersions = errors.New("btree does not track key versions")
+
+// KeyVersion returns the version of a key, 0 if the key does not exist
+// The version is incremented by every change of the key: appended or removed values, a rename or a delete.
+// It is serialized with PutIfVersion so both can be called from several goroutines.
+func (b *BTree) KeyVersion(k []byte) (uint64, error) {
+	if !b.keyVersions {
+		return 0, ErrNoKeyVersions
+	}
+
+	if !b.noLocking {
+		b.versionLock.Lock()
+		defer b.versionLock.Unlock()
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	return b.keyVersion(root, b.transformKey(k))
+}
+
+// PutIfVersion appends a value to a key like Put if the version of the key is still expected, otherwise ErrVersionMismatch is returned
+// An expected version of 0 only puts the value if the key does not exist.  This lets several writers update a key optimistically:
+// read the key and its version, compute the update and retry from the read if PutIfVersion fails.
+func (b *BTree) PutIfVersion(k, v []byte, expected uint64) error {
+	if !b.keyVersions {
+		return ErrNoKeyVersions
+	}
+
+	k = b.transformKey(k)
+
+	// the check and the put are serialized with other versioned puts so no change slips in between
+	if !b.noLocking {
+		b.versionLock.Lock()
+		defer b.versionLock.Unlock()
+	}
+
+	return b.atomic(func() error {
+		root, err := b.getRoot()
+		if err != nil {
+			return err
+		}
+
+		version, err := b.keyVersion(root, k)
+		if err != nil {
+			return err
+		}
+
+		if version != expected {
+			return ErrVersionMismatch
+		}
+
+		return b.put(k, v)
+	})
+}
+
+// keyVersion returns the version of a transformed key below x, 0 if the key does not exist
+func (b *BTree) keyVersion(x *Node, k []byte) (uint64, error) {
+	key, err := b.lookupKey(x, k)
+	if err != nil || key == nil || key.hidden() {
+		return 0, err
+	}
+
+	return key.R, nil
+}
+
+// keyChanged increments the version of a key which is being changed if the btree tracks key versions
+func (b *BTree) keyChanged(k *Key) {
+	if b.keyVersions {
+		k.R++
+	}
+}
@@ -0,0 +1,204 @@
+// Package btree
+// key versions tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestBTree_PutIfVersion(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{KeyVersions: true, TombstoneDeletes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.PutIfVersion([]byte("key"), []byte("a"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutIfVersion([]byte("key"), []byte("b"), 0)
+	if err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch for an existing key, got %v", err)
+	}
+
+	steps := []struct {
+		name   string
+		change func() error
+	}{
+		{"put", func() error { return btree.Put([]byte("key"), []byte("b")) }},
+		{"put multi", func() error { return btree.PutMulti([]byte("key"), []byte("c"), []byte("d")) }},
+		{"remove", func() error { return btree.Remove([]byte("key"), []byte("c")) }},
+		{"put if version", func() error { return btree.PutIfVersion([]byte("key"), []byte("e"), 4) }},
+	}
+
+	for i, step := range steps {
+		err = step.change()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		version, err := btree.KeyVersion([]byte("key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if version != uint64(i+2) {
+			t.Fatalf("%s: expected version %d, got %d", step.name, i+2, version)
+		}
+	}
+
+	key, err := btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.R != 5 || len(key.V) != 4 {
+		t.Fatalf("expected version 5 and 4 values, got %d and %q", key.R, key.V)
+	}
+
+	err = btree.PutIfVersion([]byte("key"), []byte("stale"), 4)
+	if err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+
+	// a deleted key does not exist but keeps counting under its tombstone
+	err = btree.Delete([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := btree.KeyVersion([]byte("key"))
+	if err != nil || version != 0 {
+		t.Fatalf("expected a deleted key to have version 0, got %d %v", version, err)
+	}
+
+	err = btree.PutIfVersion([]byte("key"), []byte("again"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err = btree.KeyVersion([]byte("key"))
+	if err != nil || version != 7 {
+		t.Fatalf("expected the recreated key to continue at version 7, got %d %v", version, err)
+	}
+
+	// splits move keys without changing them
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("other%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	version, err = btree.KeyVersion([]byte("key"))
+	if err != nil || version != 7 {
+		t.Fatalf("expected version 7 after splits, got %d %v", version, err)
+	}
+}
+
+func TestBTree_PutIfVersion_Concurrent(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	btree.keyVersions = true
+
+	defer btree.Close()
+
+	err = btree.PutIfVersion([]byte("counter"), []byte("0"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every worker increments the counter by reading it and retrying on conflicts
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; {
+				version, err := btree.KeyVersion([]byte("counter"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				err = btree.PutIfVersion([]byte("counter"), []byte("+1"), version)
+				if err == ErrVersionMismatch {
+					continue
+				} else if err != nil {
+					t.Error(err)
+					return
+				}
+				i++
+			}
+		}()
+	}
+	wg.Wait()
+
+	key, err := btree.Get([]byte("counter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 101 || key.R != 101 {
+		t.Fatalf("expected 101 values at version 101, got %d at version %d", len(key.V), key.R)
+	}
+}
+
+func TestBTree_KeyVersion_Disabled(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	_, err = btree.KeyVersion([]byte("key"))
+	if err != ErrNoKeyVersions {
+		t.Fatalf("expected ErrNoKeyVersions, got %v", err)
+	}
+
+	err = btree.PutIfVersion([]byte("key"), []byte("value"), 0)
+	if err != ErrNoKeyVersions {
+		t.Fatalf("expected ErrNoKeyVersions, got %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+// Package btree
+// concurrent writers
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrConcurrentWritersNoLocking is returned when opening a btree with both ConcurrentWriters and NoLocking
+var ErrConcurrentWritersNoLocking = errors.New("concurrent writers need internal locking")
+
+// latches are the page latches of a btree opened with Options.ConcurrentWriters
+// Latched puts and gets hold tree shared and latch the pages they descend through, every other write holds tree exclusively.
+type latches struct {
+	tree  sync.RWMutex     // shared by latched operations, exclusive for the other writes
+	lock  sync.Mutex       // guards pages
+	pages map[int64]*latch // latches of the pages in use
+}
+
+// latch is the latch of a page, dropped from the table once no goroutine holds or waits for it
+type latch struct {
+	sync.RWMutex
+	refs int
+}
+
+// newLatches creates an empty latch table
+func newLatches() *latches {
+	return &latches{pages: make(map[int64]*latch)}
+}
+
+// acquire latches a page, exclusively for writers and shared for readers
+func (l *latches) acquire(pageID int64, exclusive bool) {
+	l.lock.Lock()
+	lt, ok := l.pages[pageID]
+	if !ok {
+		lt = &latch{}
+		l.pages[pageID] = lt
+	}
+	lt.refs++
+	l.lock.Unlock()
+
+	if exclusive {
+		lt.Lock()
+	} else {
+		lt.RLock()
+	}
+}
+
+// release releases a latch taken with acquire
+func (l *latches) release(pageID int64, exclusive bool) {
+	l.lock.Lock()
+	lt := l.pages[pageID]
+	lt.refs--
+	if lt.refs == 0 {
+		delete(l.pages, pageID)
+	}
+	l.lock.Unlock()
+
+	if exclusive {
+		lt.Unlock()
+	} else {
+		lt.RUnlock()
+	}
+}
+
+// latched returns true if puts and gets run concurrently through page latches
+// Puts with features descending the tree more than once, versioned or unique values, a value comparator,
+// safe writes or a transaction, take the tree exclusively like every other write.
+func (b *BTree) latched() bool {
+	return b.latches != nil && b.journal == nil && b.txn == nil && !b.versioned && !b.uniqueValues && b.valueCompare == nil
+}
+
+// putLatched appends values to a key latching the pages on the way down
+// The nodes below the root are latched crabbing: a child is latched before its parent is released.  Full nodes are split on the way
+// down, so a split only changes the latched parent and child and the new sibling, which is latched before the parent is released if the key goes there.
+func (b *BTree) putLatched(key []byte, values [][]byte) error {
+	l := b.latches
+	l.tree.RLock()
+	defer l.tree.RUnlock()
+
+	if b.closed.Load() {
+		return ErrClosed
+	}
+
+	if !b.HasLease() {
+		return ErrReadOnly
+	}
+
+	err := b.checkKeySize(key)
+	if err != nil {
+		return err
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	k := &Key{K: key, V: make([][]byte, 0, len(values))}
+	b.keyChanged(k)
+
+	for _, value := range values {
+		b.countPut(key, value)
+
+		value, ptr, err := b.storeValue(value)
+		if err != nil {
+			return err
+		}
+
+		k.appendValue(value, ptr)
+	}
+
+	l.acquire(0, true)
+
+	root, err := b.getRoot()
+	if err != nil {
+		l.release(0, true)
+		return err
+	}
+
+	full, err := b.isFull(root)
+	if err == nil && full {
+		err = b.splitRoot()
+		if err == nil {
+			root, err = b.readNode(0)
+		}
+	}
+	if err != nil {
+		l.release(0, true)
+		return err
+	}
+
+	return b.insertLatched(root, k)
+}
+
+// insertLatched inserts k below x, x is latched exclusively and released before returning
+func (b *BTree) insertLatched(x *Node, k *Key) error {
+	l := b.latches
+
+	for {
+		i := len(x.Keys) - 1
+		for i >= 0 && lessThan(k.K, x.Keys[i].K) {
+			i--
+		}
+
+		if i >= 0 && equal(k.K, x.Keys[i].K) {
+			err := b.appendToNode(x, i, k)
+			l.release(x.Page, true)
+			return err
+		}
+
+		if x.Leaf {
+			x.Keys = append(x.Keys, nil)
+			copy(x.Keys[i+2:], x.Keys[i+1:])
+			x.Keys[i+1] = k
+
+			err := b.writeNode(x)
+			l.release(x.Page, true)
+			return err
+		}
+
+		i++
+		l.acquire(x.Children[i], true)
+
+		child, err := b.readNode(x.Children[i])
+
+		full := false
+		if err == nil {
+			full, err = b.isFull(child)
+		}
+
+		if err == nil && full {
+			err = b.splitChild(x, i, child)
+
+			// the key may have been promoted by the split
+			if err == nil && equal(k.K, x.Keys[i].K) {
+				l.release(x.Children[i], true)
+				err = b.appendToNode(x, i, k)
+				l.release(x.Page, true)
+				return err
+			}
+
+			// the new sibling is only reachable through x, it is latched before x is released
+			if err == nil && greaterThan(k.K, x.Keys[i].K) {
+				l.acquire(x.Children[i+1], true)
+				l.release(x.Children[i], true)
+				i++
+
+				child, err = b.readNode(x.Children[i])
+			}
+		}
+
+		if err != nil {
+			l.release(x.Children[i], true)
+			l.release(x.Page, true)
+			return err
+		}
+
+		l.release(x.Page, true)
+		x = child
+	}
+}
+
+// getLatched returns the values of a key latching the pages on the way down shared, concurrently with latched puts
+func (b *BTree) getLatched(k []byte) (*Key, error) {
+	l := b.latches
+	l.tree.RLock()
+	defer l.tree.RUnlock()
+
+	l.acquire(0, false)
+
+	x, err := b.getRoot()
+	if err != nil {
+		l.release(0, false)
+		return nil, err
+	}
+
+	for {
+		x.Keys = removeNilFromKeys(x.Keys)
+		i := b.searchKeys(x.Keys, k)
+
+		if i < len(x.Keys) && equal(k, x.Keys[i].K) {
+			l.release(x.Page, false)
+
+			key := x.Keys[i]
+			if key.hidden() {
+				return nil, nil
+			}
+
+			return b.resolveKey(key)
+		}
+
+		if x.Leaf {
+			l.release(x.Page, false)
+			return nil, nil
+		}
+
+		l.acquire(x.Children[i], false)
+		l.release(x.Page, false)
+
+		page := x.Children[i]
+		x, err = b.readNode(page)
+		if err != nil {
+			l.release(page, false)
+			return nil, err
+		}
+	}
+}
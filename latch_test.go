@@ -0,0 +1,180 @@
+// Package btree
+// concurrent writers tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestBTree_ConcurrentWriters(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ConcurrentWriters: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	const writers = 8
+	const keys = 300
+
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, writers*3)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			// every writer owns its keys, the first value of a key is appended to by the next writer too
+			for i := 0; i < keys; i++ {
+				err := btree.Put([]byte(fmt.Sprintf("%04d-%d", i, w)), []byte("a"))
+				if err == nil {
+					err = btree.PutMulti([]byte(fmt.Sprintf("%04d-%d", i, (w+1)%writers)), []byte("b"), []byte("c"))
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < keys; i++ {
+				_, err := btree.Get([]byte(fmt.Sprintf("%04d-%d", i, w)))
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+
+	// other writes take the tree exclusively
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 50; i++ {
+			err := btree.Put([]byte(fmt.Sprintf("deleted-%d", i)), []byte("a"))
+			if err == nil {
+				err = btree.Delete([]byte(fmt.Sprintf("deleted-%d", i)))
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	checkBTree(t, btree)
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keys; i++ {
+			key, err := btree.Get([]byte(fmt.Sprintf("%04d-%d", i, w)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key == nil || len(key.V) != 3 {
+				t.Fatalf("expected 3 values for key %04d-%d, got %v", i, w, key)
+			}
+		}
+	}
+
+	key, err := btree.Get([]byte("deleted-0"))
+	if err != nil || key != nil {
+		t.Fatalf("expected deleted-0 to be deleted, got %v %v", key, err)
+	}
+}
+
+func TestBTree_ConcurrentWriters_NoLocking(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	_, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ConcurrentWriters: true, NoLocking: true})
+	if !errors.Is(err, ErrConcurrentWritersNoLocking) {
+		t.Fatalf("expected ErrConcurrentWritersNoLocking, got %v", err)
+	}
+}
+
+func benchmarkConcurrentPut(b *testing.B, opts *Options, lock sync.Locker) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 32, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	// random keys spread the writers over disjoint subtrees
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := []byte(fmt.Sprintf("%016x", r.Uint64()))
+
+			lock.Lock()
+			err := btree.Put(key, key)
+			lock.Unlock()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkBTree_Put_SingleLock(b *testing.B) {
+	benchmarkConcurrentPut(b, nil, &sync.Mutex{})
+}
+
+func BenchmarkBTree_Put_ConcurrentWriters(b *testing.B) {
+	benchmarkConcurrentPut(b, &Options{ConcurrentWriters: true}, noLock{})
+}
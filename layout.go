@@ -0,0 +1,291 @@
+// Package btree
+// page layout optimizer
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "errors"
+
+// LayoutStats describes how well the leaves of a tree are laid out for range scans
+type LayoutStats struct {
+	Pages          int64   // Number of pages in the file
+	Nodes          int     // Number of nodes in the tree
+	Leaves         int     // Number of leaf nodes
+	ForwardJumps   float64 // Share of leaf to leaf steps in key order moving forward in the file, from 0 to 1
+	AverageJump    float64 // Average distance in pages between leaves which are neighbours in key order
+	SequentialRuns int     // Number of runs of leaves stored in key order within 2 pages of each other
+}
+
+// Layout returns locality metrics of the current page layout
+func (b *BTree) Layout() (LayoutStats, error) {
+	pager, ok := b.Pager.(*Pager)
+	if !ok {
+		return LayoutStats{}, errors.New("layout requires a pager")
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return LayoutStats{}, err
+	}
+
+	stats := LayoutStats{Pages: pager.PageCount()}
+	leaves := make([]int64, 0)
+
+	err = b.walk(root, func(n *Node) error {
+		stats.Nodes++
+		if n.Leaf {
+			leaves = append(leaves, n.Page)
+		}
+		return nil
+	})
+	if err != nil {
+		return LayoutStats{}, err
+	}
+
+	stats.Leaves = len(leaves)
+	if len(leaves) == 0 {
+		return stats, nil
+	}
+
+	forward, total := 0, int64(0)
+	stats.SequentialRuns = 1
+	for i := 1; i < len(leaves); i++ {
+		jump := leaves[i] - leaves[i-1]
+		if jump > 0 {
+			forward++
+		} else {
+			jump = -jump
+		}
+		total += jump
+
+		if leaves[i] <= leaves[i-1] || leaves[i]-leaves[i-1] > 2 {
+			stats.SequentialRuns++
+		}
+	}
+
+	if len(leaves) > 1 {
+		stats.ForwardJumps = float64(forward) / float64(len(leaves)-1)
+		stats.AverageJump = float64(total) / float64(len(leaves)-1)
+	}
+
+	return stats, nil
+}
+
+// OptimizeLayout rewrites the pages of the tree in key order and returns the layout before and after
+// Every node is followed by its subtrees from left to right, so leaves are stored in key order with their parents close by.
+// Tombstones are purged, a root without keys is collapsed and hash sets are dropped first, then nodes are moved in place and the file is truncated after the last page in use, deleted pages beyond it are dropped.
+func (b *BTree) OptimizeLayout() (LayoutStats, LayoutStats, error) {
+	pager, ok := b.Pager.(*Pager)
+	if !ok {
+		return LayoutStats{}, LayoutStats{}, errors.New("layout optimization requires a pager")
+	}
+
+	_, err := b.PurgeTombstones()
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	// trees written by versions which did not collapse the root may have a root without keys
+	err = b.atomic(b.collapseRoot)
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	// only nodes are moved, hash sets are rebuilt on demand
+	err = b.atomic(b.dropHashSets)
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	// bitmaps are data, they are held in memory while the nodes move and written to new pages afterwards
+	var bitmaps map[string]*Bitmap
+	err = b.atomic(func() error {
+		bitmaps, err = b.takeBitmaps()
+		return err
+	})
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	before, err := b.Layout()
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	// pre-order numbering keeps the root at page 0
+	order := make([]int64, 0, before.Nodes)
+	mapping := make(map[int64]int64, before.Nodes)
+	err = b.walk(root, func(n *Node) error {
+		mapping[n.Page] = int64(len(order))
+		order = append(order, n.Page)
+		return nil
+	})
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	// nodes continued in overflow pages are held in memory and their chains released,
+	// otherwise moving a node onto one of their overflow pages would overwrite them
+	loaded := make(map[int64]*Node)
+	for _, page := range order {
+		overflow, err := pager.overflowPages(page)
+		if err != nil {
+			return LayoutStats{}, LayoutStats{}, err
+		}
+
+		if len(overflow) == 0 {
+			continue
+		}
+
+		loaded[page], err = b.readNode(page)
+		if err != nil {
+			return LayoutStats{}, LayoutStats{}, err
+		}
+
+		err = pager.DeletePage(page)
+		if err != nil {
+			return LayoutStats{}, LayoutStats{}, err
+		}
+	}
+
+	// new overflow pages go to the end of the file so they never land on a page still to be moved
+	allocator := pager.allocator
+	pager.allocator = ALLOC_APPEND
+
+	err = b.atomic(func() error {
+		return b.movePages(order, mapping, loaded)
+	})
+	pager.allocator = allocator
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	if b.heat != nil {
+		b.heat.remap(mapping)
+	}
+
+	last := int64(len(order)) - 1
+	for page := int64(0); page < int64(len(order)); page++ {
+		overflow, err := pager.overflowPages(page)
+		if err != nil {
+			return LayoutStats{}, LayoutStats{}, err
+		}
+
+		for _, o := range overflow {
+			if o > last {
+				last = o
+			}
+		}
+	}
+
+	err = pager.truncate(last + 1)
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	err = b.atomic(func() error {
+		return b.restoreBitmaps(bitmaps)
+	})
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	after, err := b.Layout()
+	if err != nil {
+		return LayoutStats{}, LayoutStats{}, err
+	}
+
+	return before, after, nil
+}
+
+// movePages moves every node to the page given by mapping rewriting child pointers on the way
+// Nodes are moved along chains, a node is read before its page is overwritten so only one node per chain is held in memory
+// loaded holds nodes which were read ahead of time
+func (b *BTree) movePages(order []int64, mapping map[int64]int64, loaded map[int64]*Node) error {
+	b.shapeChanged()
+
+	moved := make(map[int64]bool, len(order))
+
+	for _, start := range order {
+		if moved[start] {
+			continue
+		}
+
+		n, err := b.loadNode(start, loaded)
+		if err != nil {
+			return err
+		}
+
+		for n != nil {
+			old := n.Page
+			target := mapping[old]
+			moved[old] = true
+
+			// the node living at the target has to be picked up before it is overwritten
+			var next *Node
+			if _, live := mapping[target]; live && !moved[target] {
+				next, err = b.loadNode(target, loaded)
+				if err != nil {
+					return err
+				}
+			}
+
+			n.Page = target
+			for i, c := range n.Children {
+				n.Children[i] = mapping[c]
+			}
+
+			// a node staying in place still needs its child pointers rewritten
+			if old != target || len(n.Children) > 0 {
+				err = b.writeNode(n)
+				if err != nil {
+					return err
+				}
+			}
+
+			n = next
+		}
+	}
+
+	return nil
+}
+
+// loadNode returns the node at page from loaded or reads it
+func (b *BTree) loadNode(page int64, loaded map[int64]*Node) (*Node, error) {
+	if n, ok := loaded[page]; ok {
+		return n, nil
+	}
+	return b.readNode(page)
+}
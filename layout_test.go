@@ -0,0 +1,215 @@
+// Package btree
+// page layout optimizer tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestBTree_OptimizeLayout(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	// random insertion order scatters the leaves over the file
+	for _, i := range rand.New(rand.NewSource(1)).Perm(500) {
+		err = btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, after, err := btree.OptimizeLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before.Nodes != after.Nodes || before.Leaves != after.Leaves {
+		t.Fatalf("expected the same nodes before and after, got %+v and %+v", before, after)
+	}
+
+	if after.ForwardJumps != 1 {
+		t.Fatalf("expected all leaf steps to move forward, got %f", after.ForwardJumps)
+	}
+
+	if after.AverageJump >= before.AverageJump || after.SequentialRuns >= before.SequentialRuns {
+		t.Fatalf("expected locality to improve, before %+v after %+v", before, after)
+	}
+
+	if after.Pages != int64(after.Nodes) {
+		t.Fatalf("expected the file to hold exactly %d pages, got %d", after.Nodes, after.Pages)
+	}
+
+	keys, err := btree.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 500 {
+		t.Fatalf("expected 500 keys, got %d", len(keys))
+	}
+
+	for i, key := range keys {
+		if string(key.K) != fmt.Sprintf("%04d", i) {
+			t.Fatalf("expected key %04d, got %s", i, key.K)
+		}
+	}
+
+	// the tree keeps working after the pages moved
+	for i := 500; i < 600; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 600; i++ {
+		key, err := btree.Get([]byte(fmt.Sprintf("%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil {
+			t.Fatalf("expected key %04d", i)
+		}
+	}
+}
+
+func TestBTree_OptimizeLayout_Overflow(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	// nodes with large values continue in overflow pages
+	value := make([]byte, 600)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(200) {
+		err = btree.Put([]byte(fmt.Sprintf("%04d", i)), value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, _, err = btree.OptimizeLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		key, err := btree.Get([]byte(fmt.Sprintf("%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || len(key.V[0]) != 600 {
+			t.Fatalf("expected key %04d after optimizing", i)
+		}
+	}
+}
+
+func TestBTree_OptimizeLayout_CollapsesRoot(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 4; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// older versions left a root without keys above its only child
+	root, err := btree.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := btree.newNode(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child.Keys = root.Keys
+	err = btree.writeNode(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root.Leaf, root.Keys, root.Children = false, []*Key{}, []int64{child.Page}
+	err = btree.writeNode(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree.height.Store(0)
+
+	_, _, err = btree.OptimizeLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkHeight(t, btree) != 1 {
+		t.Fatal("expected the root to collapse")
+	}
+
+	for i := 0; i < 4; i++ {
+		k, err := btree.Get([]byte(fmt.Sprintf("%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if k == nil {
+			t.Fatalf("expected key %d", i)
+		}
+	}
+}
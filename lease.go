@@ -0,0 +1,319 @@
+// Package btree
+// write lease
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrReadOnly is returned by writes to a btree which does not hold the write lease
+var ErrReadOnly = errors.New("btree is read-only, the write lease is held by another owner")
+
+// lease is a write lease kept in a lock file next to the btree
+// The lock file holds the owner id and the expiry time of the lease, an expired lease can be taken over by any owner
+type lease struct {
+	filename string        // name of the lock file
+	owner    string        // owner id of this handle
+	ttl      time.Duration // duration of the lease
+	expires  int64         // expiry of our lease in unix nanoseconds, 0 if the lease is not held
+}
+
+// newLease creates a lease handle, an empty owner is replaced by a random id
+func newLease(filename, owner string, ttl time.Duration) (*lease, error) {
+	if owner == "" {
+		id := make([]byte, 8)
+		_, err := rand.Read(id)
+		if err != nil {
+			return nil, err
+		}
+		owner = fmt.Sprintf("%d-%s", os.Getpid(), hex.EncodeToString(id))
+	}
+
+	if strings.ContainsAny(owner, "\n") {
+		return nil, errors.New("lease owner must not contain newlines")
+	}
+
+	return &lease{filename: filename, owner: owner, ttl: ttl}, nil
+}
+
+// held returns true if the lease is held and not expired
+func (l *lease) held() bool {
+	return l.expires > time.Now().UnixNano()
+}
+
+// acquire takes the lease if it is free, expired or already ours
+// The lock file is locked while it is read and written so two owners cannot take over an expired lease at the same time
+func (l *lease) acquire() error {
+	return withFileLock(l.filename, 0644, func(f *os.File) error {
+		owner, expires, err := l.read(f)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UnixNano()
+		if owner != "" && owner != l.owner && expires > now {
+			return ErrReadOnly
+		}
+
+		expires = now + int64(l.ttl)
+
+		err = l.write(f, l.owner, expires)
+		if err != nil {
+			return err
+		}
+
+		l.expires = expires
+		return nil
+	})
+}
+
+// release gives up the lease if it is ours, the lock file is emptied
+func (l *lease) release() error {
+	if l.expires == 0 {
+		return nil
+	}
+	l.expires = 0
+
+	return withFileLock(l.filename, 0644, func(f *os.File) error {
+		owner, _, err := l.read(f)
+		if err != nil || owner != l.owner {
+			return err
+		}
+
+		return l.write(f, "", 0)
+	})
+}
+
+// read reads the owner and expiry from the lock file, an empty lock file is a free lease
+func (l *lease) read(f *os.File) (string, int64, error) {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, math.MaxInt64))
+	if err != nil {
+		return "", 0, err
+	}
+
+	if len(data) == 0 {
+		return "", 0, nil
+	}
+
+	owner, expires, ok := strings.Cut(string(data), "\n")
+	if !ok {
+		return "", 0, errors.New("invalid lease file")
+	}
+
+	e, err := strconv.ParseInt(strings.TrimSpace(expires), 10, 64)
+	if err != nil {
+		return "", 0, errors.New("invalid lease file")
+	}
+
+	return owner, e, nil
+}
+
+// write replaces the contents of the lock file with an owner id and expiry, an empty owner frees the lease
+func (l *lease) write(f *os.File, owner string, expires int64) error {
+	err := f.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	if owner != "" {
+		_, err = f.WriteAt([]byte(owner+"\n"+strconv.FormatInt(expires, 10)+"\n"), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return f.Sync()
+}
+
+// AcquireLease takes the write lease of a btree opened with Options.LeaseTTL
+// ErrReadOnly is returned if another owner holds an unexpired lease
+func (b *BTree) AcquireLease() error {
+	if b.lease == nil {
+		return errors.New("btree was opened without a lease")
+	}
+
+	err := b.lease.acquire()
+	if err != nil {
+		return err
+	}
+
+	// another owner may have written the file since this handle last held the lease
+	return b.takeOver()
+}
+
+// RenewLease extends the write lease by its ttl
+// The lease must be renewed before it expires, otherwise another owner may take it over
+func (b *BTree) RenewLease() error {
+	if b.lease == nil {
+		return errors.New("btree was opened without a lease")
+	}
+
+	if !b.lease.held() {
+		return ErrReadOnly
+	}
+
+	return b.lease.acquire()
+}
+
+// ReleaseLease gives up the write lease so another owner can take it, the btree becomes read-only
+func (b *BTree) ReleaseLease() error {
+	if b.lease == nil {
+		return nil
+	}
+
+	b.handOver()
+
+	return b.lease.release()
+}
+
+// HasLease returns true if the btree holds an unexpired write lease
+// A btree opened without a lease always returns true
+func (b *BTree) HasLease() bool {
+	if b.lease == nil {
+		return true
+	}
+	return b.lease.held()
+}
+
+// takeOver reloads the page count, the deleted pages and the epochs another handle wrote before this handle took over the file
+// Pinned pages are read again, the pager writes its metadata files from now on.
+func (p *Pager) takeOver() error {
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	deletedPages, freeOverflow, err := readDelPages(p.deletedPagesFile)
+	if err != nil {
+		return err
+	}
+
+	p.deletedPages, p.freeOverflow = deletedPages, freeOverflow
+	p.freelistPending = p.freelistPending[:0]
+
+	if p.freelistLog != nil {
+		data, err := readFreelistLog(p.freelistLog)
+		if err != nil {
+			return err
+		}
+
+		p.freelistLogSize = int64(len(data))
+		p.applyFreelistLog(data)
+	}
+
+	stat, err := p.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	p.count.Store(pageCount(stat.Size()))
+	p.pinned.invalidateAll()
+
+	// the epoch file is created by the first backup, possibly taken through the other handle
+	if p.epochs.file == nil && p.epochName != "" {
+		epochFile, err := openFile(p.epochName, os.O_RDWR, p.perm)
+		if err == nil {
+			p.epochs.file = epochFile
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if p.epochs.file != nil {
+		err = p.epochs.load(p.epochs.file)
+		if err == nil {
+			err = p.epochs.write(false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	p.passive.Store(false)
+
+	return p.writeDelPages()
+}
+
+// takeOver reloads the state another owner of the lease wrote to the files of the btree, caches of the old state are dropped
+func (b *BTree) takeOver() error {
+	p, ok := b.Pager.(*Pager)
+	if !ok || !p.passive.Load() {
+		// the btree wrote the files itself since it last held the lease
+		return nil
+	}
+
+	err := p.takeOver()
+	if err != nil {
+		return err
+	}
+
+	if b.expiry != nil {
+		err = b.expiry.takeOver()
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.ValueLog != nil && b.ValueLog.file != nil {
+		b.ValueLog.lock.Lock()
+		stat, err := b.ValueLog.file.Stat()
+		if err == nil {
+			b.ValueLog.size = stat.Size()
+		}
+		b.ValueLog.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	b.replaced()
+
+	return nil
+}
+
+// handOver stops the btree from writing the metadata files once another owner may take the lease
+func (b *BTree) handOver() {
+	if p, ok := b.Pager.(*Pager); ok {
+		p.passive.Store(true)
+	}
+
+	if b.expiry != nil {
+		b.expiry.handOver()
+	}
+}
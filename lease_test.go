@@ -0,0 +1,230 @@
+// Package btree
+// write lease tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBTree_Lease(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.lease")
+
+	writer, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{LeaseTTL: time.Minute, LeaseOwner: "writer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Close()
+
+	if !writer.HasLease() {
+		t.Fatal("expected the first handle to hold the lease")
+	}
+
+	reader, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{LeaseTTL: time.Minute, LeaseOwner: "reader"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Close()
+
+	if reader.HasLease() {
+		t.Fatal("expected the second handle to be read-only")
+	}
+
+	err = reader.Put([]byte("key"), []byte("value"))
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	err = reader.AcquireLease()
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	err = writer.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.RenewLease()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.ReleaseLease()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Put([]byte("key"), []byte("value"))
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly after release, got %v", err)
+	}
+
+	err = reader.AcquireLease()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = reader.Put([]byte("other"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTree_Lease_Expired(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.lease")
+
+	first, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{LeaseTTL: time.Millisecond * 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer first.Close()
+
+	time.Sleep(time.Millisecond * 100)
+
+	if first.HasLease() {
+		t.Fatal("expected the lease to expire")
+	}
+
+	err = first.RenewLease()
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly renewing an expired lease, got %v", err)
+	}
+
+	second, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{LeaseTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer second.Close()
+
+	if !second.HasLease() {
+		t.Fatal("expected the expired lease to be taken over")
+	}
+}
+
+func TestBTree_Lease_Handover(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.del.log")
+	defer os.Remove("btree.db.lease")
+
+	a, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{LeaseTTL: time.Minute, LeaseOwner: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{LeaseTTL: time.Minute, LeaseOwner: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		err = a.Put([]byte(fmt.Sprintf("a%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// deleted pages are handed over with the lease
+	for i := 0; i < 50; i++ {
+		err = a.Delete([]byte(fmt.Sprintf("a%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = a.ReleaseLease()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.AcquireLease()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		err = b.Put([]byte(fmt.Sprintf("b%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the handle without the lease must not write its stale deleted pages over those of the writer
+	err = a.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reopened.Close()
+
+	checkBTree(t, reopened)
+
+	keys, err := reopened.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 350 {
+		t.Fatalf("expected 350 keys, got %d", len(keys))
+	}
+
+	for i := 0; i < 20; i++ {
+		err = reopened.Put([]byte(fmt.Sprintf("c%04d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkBTree(t, reopened)
+}
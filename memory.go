@@ -0,0 +1,123 @@
+// Package btree
+// in-memory file
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// memFile is an in-memory page file
+type memFile struct {
+	data []byte        // file contents
+	lock *sync.RWMutex // lock for data
+}
+
+// memFileInfo describes an in-memory page file
+type memFileInfo struct {
+	size int64 // size of the file
+}
+
+// newMemFile creates an empty in-memory file
+func newMemFile() *memFile {
+	return &memFile{data: make([]byte, 0), lock: &sync.RWMutex{}}
+}
+
+// ReadAt reads len(b) bytes from the file starting at offset
+func (m *memFile) ReadAt(b []byte, offset int64) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if offset >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, m.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// WriteAt writes len(b) bytes to the file starting at offset, growing the file if needed
+func (m *memFile) WriteAt(b []byte, offset int64) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	end := offset + int64(len(b))
+	if end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+
+	return copy(m.data[offset:end], b), nil
+}
+
+// Truncate changes the size of the file
+func (m *memFile) Truncate(size int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if size <= int64(len(m.data)) {
+		m.data = m.data[:size]
+	} else {
+		m.data = append(m.data, make([]byte, size-int64(len(m.data)))...)
+	}
+
+	return nil
+}
+
+// Sync is a no-op for in-memory files
+func (m *memFile) Sync() error {
+	return nil
+}
+
+// Close is a no-op for in-memory files
+func (m *memFile) Close() error {
+	return nil
+}
+
+// Stat returns the size of the in-memory file
+func (m *memFile) Stat() (os.FileInfo, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return &memFileInfo{size: int64(len(m.data))}, nil
+}
+
+func (fi *memFileInfo) Name() string       { return "memory" }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi *memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *memFileInfo) IsDir() bool        { return false }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
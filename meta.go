@@ -0,0 +1,106 @@
+// Package btree
+// value metadata
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "time"
+
+// ValueMeta is metadata stored alongside a single value
+type ValueMeta struct {
+	Created int64  // Creation time in unix nanoseconds
+	Flags   uint32 // Application defined flags
+}
+
+// PutWithMeta inserts a key value pair storing meta alongside the value
+// If meta.Created is 0 it is set to the current time
+func (b *BTree) PutWithMeta(key, value []byte, meta ValueMeta) error {
+	key = b.transformKey(key)
+
+	if meta.Created == 0 {
+		meta.Created = time.Now().UnixNano()
+	}
+
+	return b.atomic(func() error {
+		if b.uniqueValues {
+			fresh, err := b.newValues(key, [][]byte{value})
+			if err != nil || len(fresh) == 0 {
+				return err
+			}
+		}
+
+		err := b.insert(key, value)
+		if err != nil {
+			return err
+		}
+
+		root, err := b.getRoot()
+		if err != nil {
+			return err
+		}
+
+		n, i, err := b.findNodeForKey(root, key)
+		if err != nil {
+			return err
+		}
+
+		n.Keys[i].setMeta(len(n.Keys[i].V)-1, &meta)
+
+		// the meta moves with its value
+		_, err = b.placeValue(n.Keys[i], len(n.Keys[i].V)-1)
+		if err != nil {
+			return err
+		}
+
+		return b.writeNode(n)
+	})
+}
+
+// GetWithMeta gets a key along with the metadata of its values
+// The returned key's M has one entry per value, nil for values stored without metadata
+func (b *BTree) GetWithMeta(k []byte) (*Key, error) {
+	key, err := b.Get(k)
+	if err != nil || key == nil {
+		return key, err
+	}
+
+	meta := make([]*ValueMeta, len(key.V))
+	copy(meta, key.M)
+
+	return &Key{K: key.K, V: key.V, Ptr: key.Ptr, E: key.E, M: meta, Ver: key.Ver, R: key.R, F: key.F, B: key.B, W: key.W}, nil
+}
+
+// setMeta sets the metadata of the value at index i
+func (k *Key) setMeta(i int, meta *ValueMeta) {
+	if len(k.M) <= i {
+		k.M = append(k.M, make([]*ValueMeta, i+1-len(k.M))...)
+	}
+	k.M[i] = meta
+}
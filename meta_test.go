@@ -0,0 +1,127 @@
+// Package btree
+// value metadata tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBTree_PutWithMeta(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("key"), []byte("plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutWithMeta([]byte("key"), []byte("flagged"), ValueMeta{Flags: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutWithMeta([]byte("key"), []byte("dated"), ValueMeta{Created: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	key, err := btree.GetWithMeta([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 3 || len(key.M) != 3 {
+		t.Fatalf("expected 3 values with metadata, got %d values and %d metadata", len(key.V), len(key.M))
+	}
+
+	if key.M[0] != nil {
+		t.Fatal("expected no metadata for a value stored with Put")
+	}
+
+	if key.M[1].Flags != 7 || key.M[1].Created == 0 {
+		t.Fatalf("unexpected metadata %+v", key.M[1])
+	}
+
+	if key.M[2].Created != 42 {
+		t.Fatalf("expected created 42, got %d", key.M[2].Created)
+	}
+
+	err = btree.Remove([]byte("key"), []byte("flagged"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err = btree.GetWithMeta([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(key.V[1]) != "dated" || key.M[1].Created != 42 {
+		t.Fatal("expected metadata to follow its value after Remove")
+	}
+}
+
+func TestBTree_GetWithMeta_Missing(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	key, err := btree.GetWithMeta([]byte("missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected nil key")
+	}
+}
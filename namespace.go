@@ -0,0 +1,441 @@
+// Package btree
+// namespaces
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// ErrQuotaExceeded is returned when a write would take a namespace over its quota
+var ErrQuotaExceeded = errors.New("namespace quota exceeded")
+
+// ErrNamespaceOverlap is returned by NamespacedTree for a prefix of another namespace or starting with the prefix of another namespace
+var ErrNamespaceOverlap = errors.New("namespace overlaps another namespace")
+
+// NamespaceQuota limits the usage of a namespace, a limit of 0 is unlimited
+type NamespaceQuota struct {
+	MaxKeys  int64 // Maximum number of keys
+	MaxBytes int64 // Maximum number of bytes of keys and values
+}
+
+// NamespaceStats is the usage of a namespace
+type NamespaceStats struct {
+	Keys  int64          // Number of keys
+	Bytes int64          // Bytes of the keys, prefix included, and of their values
+	Quota NamespaceQuota // The quota of the namespace
+}
+
+// Namespace scopes the operations on a btree to the keys starting with a prefix and accounts for the keys and bytes they use
+// Keys passed to and returned by a namespace do not include the prefix.  Writes made to the btree directly are not accounted, see Recount.
+type Namespace struct {
+	b      *BTree
+	prefix []byte
+	table  *namespaceTable
+}
+
+// namespaceTable holds the usage of every namespace on a metadata page
+// The usage is written when the btree is closed, the page records whether it was, the usage of a btree which was not closed
+// cleanly is recounted when its namespaces are opened.
+type namespaceTable struct {
+	lock    sync.Locker                // serializes the operations of namespaces, a no-op if locking is disabled
+	pager   *Pager                     // the pager of the metadata page, page 0
+	usage   map[string]*NamespaceStats // usage of every namespace by prefix
+	counted map[string]bool            // namespaces whose usage is exact
+}
+
+// namespacePage is the content of the metadata page
+type namespacePage struct {
+	Clean bool                       // true if the usage was written when the btree was closed
+	Usage map[string]*NamespaceStats // usage of every namespace by prefix
+}
+
+// NamespacedTree returns the namespace of the keys starting with prefix
+// The usage of a namespace opened for the first time is counted from the keys already under the prefix.
+func (b *BTree) NamespacedTree(prefix []byte) (*Namespace, error) {
+	if len(prefix) == 0 {
+		return nil, errors.New("namespace prefix must not be empty")
+	}
+
+	if b.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	t, err := b.namespaceTable()
+	if err != nil {
+		return nil, err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for other := range t.usage {
+		if other != string(prefix) && (bytes.HasPrefix(prefix, []byte(other)) || bytes.HasPrefix([]byte(other), prefix)) {
+			return nil, ErrNamespaceOverlap
+		}
+	}
+
+	n := &Namespace{b: b, prefix: bytes.Clone(prefix), table: t}
+
+	if !t.counted[string(prefix)] {
+		err = n.recount()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// namespaceTable opens the metadata page of the namespaces on first use
+// The page is stored in name.ns next to the btree file, in memory for in-memory btrees
+func (b *BTree) namespaceTable() (*namespaceTable, error) {
+	b.namespacesLock.Lock()
+	defer b.namespacesLock.Unlock()
+
+	if b.namespaces != nil {
+		return b.namespaces, nil
+	}
+
+	var pager *Pager
+	var err error
+
+	if b.name == "" {
+		pager, err = OpenMemoryPager()
+	} else {
+		pager, err = OpenPagerWithOptions(b.name+".ns", os.O_CREATE|os.O_RDWR, b.perm, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := &namespaceTable{lock: &sync.Mutex{}, pager: pager, usage: make(map[string]*NamespaceStats), counted: make(map[string]bool)}
+	if b.noLocking {
+		t.lock = noLock{}
+	}
+
+	page := &namespacePage{}
+
+	data, err := pager.ReadPage(0)
+	if err == nil {
+		err = codec.NewDecoderBytes(data, msgpackHandle).Decode(page)
+	} else if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	// the usage is exact until the btree is closed, a crash before then leaves the page marked as not clean
+	if err == nil {
+		if page.Usage != nil {
+			t.usage = page.Usage
+		}
+
+		for prefix := range t.usage {
+			t.counted[prefix] = page.Clean
+		}
+
+		err = t.write(false)
+	}
+	if err != nil {
+		pager.Close()
+		return nil, err
+	}
+
+	b.namespaces = t
+	return t, nil
+}
+
+// write writes the usage of every namespace to the metadata page and syncs it
+func (t *namespaceTable) write(clean bool) error {
+	var encoded []byte
+	err := codec.NewEncoderBytes(&encoded, msgpackHandle).Encode(&namespacePage{Clean: clean, Usage: t.usage})
+	if err != nil {
+		return err
+	}
+
+	err = t.pager.WritePage(0, encoded)
+	if err != nil {
+		return err
+	}
+
+	return t.pager.Sync()
+}
+
+// close writes the usage of the namespaces marking it as exact, then closes the pager
+func (t *namespaceTable) close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	err := t.write(true)
+	return errors.Join(err, t.pager.Close())
+}
+
+// Prefix returns the prefix of the namespace
+func (n *Namespace) Prefix() []byte {
+	return bytes.Clone(n.prefix)
+}
+
+// Put inserts a key value pair into the namespace
+// ErrQuotaExceeded is returned without writing if the key or value would take the namespace over its quota.
+func (n *Namespace) Put(key, value []byte) error {
+	n.table.lock.Lock()
+	defer n.table.lock.Unlock()
+
+	full := n.key(key)
+
+	before, err := n.usageOf(full)
+	if err != nil {
+		return err
+	}
+
+	u := n.usage()
+
+	keys, size := u.Keys, u.Bytes+int64(len(value))
+	if before < 0 {
+		keys, size = keys+1, size+int64(len(full))
+	}
+
+	if (u.Quota.MaxKeys > 0 && keys > u.Quota.MaxKeys) || (u.Quota.MaxBytes > 0 && size > u.Quota.MaxBytes) {
+		return ErrQuotaExceeded
+	}
+
+	return n.account(full, before, func() error {
+		return n.b.Put(full, value)
+	})
+}
+
+// Get returns the values of a key of the namespace, the returned key does not include the prefix
+func (n *Namespace) Get(key []byte) (*Key, error) {
+	k, err := n.b.Get(n.key(key))
+	if err != nil || k == nil {
+		return nil, err
+	}
+
+	return n.strip(k), nil
+}
+
+// Delete deletes a key of the namespace
+func (n *Namespace) Delete(key []byte) error {
+	n.table.lock.Lock()
+	defer n.table.lock.Unlock()
+
+	full := n.key(key)
+
+	before, err := n.usageOf(full)
+	if err != nil {
+		return err
+	}
+
+	return n.account(full, before, func() error {
+		return n.b.Delete(full)
+	})
+}
+
+// Remove removes a value from a key of the namespace
+func (n *Namespace) Remove(key, value []byte) error {
+	n.table.lock.Lock()
+	defer n.table.lock.Unlock()
+
+	full := n.key(key)
+
+	before, err := n.usageOf(full)
+	if err != nil {
+		return err
+	}
+
+	return n.account(full, before, func() error {
+		return n.b.Remove(full, value)
+	})
+}
+
+// Range returns the keys of the namespace within [start, end], a nil bound leaves the range open on that side within the namespace
+func (n *Namespace) Range(start, end []byte) ([]*Key, error) {
+	opts := &RangeOptions{IncludeStart: true, IncludeEnd: true}
+
+	from := n.key(start)
+
+	var to []byte
+	if end != nil {
+		to = n.key(end)
+	} else {
+		to = prefixEnd(n.prefix)
+		opts.IncludeEnd = false
+	}
+
+	keys, err := n.b.RangeOpt(from, to, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, k := range keys {
+		keys[i] = n.strip(k)
+	}
+
+	return keys, nil
+}
+
+// Stats returns the usage and quota of the namespace
+func (n *Namespace) Stats() NamespaceStats {
+	n.table.lock.Lock()
+	defer n.table.lock.Unlock()
+
+	return *n.usage()
+}
+
+// SetQuota sets the quota of the namespace, it is kept with the usage and applies to later writes
+func (n *Namespace) SetQuota(quota NamespaceQuota) error {
+	if quota.MaxKeys < 0 || quota.MaxBytes < 0 {
+		return errors.New("quota must not be negative")
+	}
+
+	n.table.lock.Lock()
+	defer n.table.lock.Unlock()
+
+	n.usage().Quota = quota
+	return n.table.write(false)
+}
+
+// Recount counts the keys and bytes of the namespace from the tree, i.e. after writing to the btree directly under the prefix
+func (n *Namespace) Recount() error {
+	n.table.lock.Lock()
+	defer n.table.lock.Unlock()
+
+	return n.recount()
+}
+
+// recount counts the usage of the namespace, the table lock must be held
+func (n *Namespace) recount() error {
+	keys, size := int64(0), int64(0)
+
+	err := n.b.scanRangeOpt(n.prefix, prefixEnd(n.prefix), &RangeOptions{IncludeStart: true}, func(k *Key) error {
+		resolved, err := n.b.resolveKey(k)
+		if err != nil {
+			return err
+		}
+
+		keys++
+		size += keySize(resolved)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	u := n.usage()
+	u.Keys, u.Bytes = keys, size
+	n.table.counted[string(n.prefix)] = true
+
+	return nil
+}
+
+// account runs a write of the key full and applies the change of its usage to the namespace
+// before is the usage of the key before the write, -1 if it did not exist
+func (n *Namespace) account(full []byte, before int64, write func() error) error {
+	err := write()
+	if err != nil {
+		return err
+	}
+
+	after, err := n.usageOf(full)
+	if err != nil {
+		return err
+	}
+
+	u := n.usage()
+
+	if before < 0 && after >= 0 {
+		u.Keys++
+	} else if before >= 0 && after < 0 {
+		u.Keys--
+	}
+
+	u.Bytes += max(after, 0) - max(before, 0)
+
+	return nil
+}
+
+// usageOf returns the bytes used by the key full, -1 if the key does not exist
+func (n *Namespace) usageOf(full []byte) (int64, error) {
+	k, err := n.b.Get(full)
+	if err != nil || k == nil {
+		return -1, err
+	}
+
+	return keySize(k), nil
+}
+
+// usage returns the usage of the namespace, the table lock must be held
+func (n *Namespace) usage() *NamespaceStats {
+	u, ok := n.table.usage[string(n.prefix)]
+	if !ok {
+		u = &NamespaceStats{}
+		n.table.usage[string(n.prefix)] = u
+	}
+	return u
+}
+
+// key returns the key of the btree for a key of the namespace
+func (n *Namespace) key(key []byte) []byte {
+	return append(bytes.Clone(n.prefix), key...)
+}
+
+// strip returns a copy of k without the prefix
+func (n *Namespace) strip(k *Key) *Key {
+	stripped := *k
+	stripped.K = bytes.TrimPrefix(k.K, n.prefix)
+	return &stripped
+}
+
+// keySize returns the bytes of a key and its resolved values
+func keySize(k *Key) int64 {
+	size := int64(len(k.K))
+	for _, v := range k.V {
+		size += int64(len(v))
+	}
+	return size
+}
+
+// prefixEnd returns the first key after every key starting with prefix, nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+// Package btree
+// namespaces tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBTree_NamespacedTree(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	tenantA, err := btree.NamespacedTree([]byte("a/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantB, err := btree.NamespacedTree([]byte("b/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"x", "y", "z"} {
+		err = tenantA.Put([]byte(k), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = tenantB.Put([]byte("x"), []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := tenantB.Get([]byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(key.K) != "x" || string(key.V[0]) != "other" {
+		t.Fatalf("expected x=other, got %s=%s", key.K, key.V[0])
+	}
+
+	key, err = tenantB.Get([]byte("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatalf("expected y to be in another namespace, got %v", key)
+	}
+
+	keys, err := tenantA.Range(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 3 || string(keys[0].K) != "x" || string(keys[2].K) != "z" {
+		t.Fatalf("expected x, y and z, got %d keys", len(keys))
+	}
+
+	keys, err = tenantA.Range([]byte("y"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys from y, got %d", len(keys))
+	}
+
+	// a key holds its prefix, its key and its values
+	stats := tenantA.Stats()
+	if stats.Keys != 3 || stats.Bytes != 3*(3+5) {
+		t.Fatalf("expected 3 keys and 24 bytes, got %d keys and %d bytes", stats.Keys, stats.Bytes)
+	}
+
+	err = tenantA.Put([]byte("x"), []byte("more"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenantA.Remove([]byte("x"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenantA.Delete([]byte("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats = tenantA.Stats()
+	if stats.Keys != 2 || stats.Bytes != 3+4+3+5 {
+		t.Fatalf("expected 2 keys and 15 bytes, got %d keys and %d bytes", stats.Keys, stats.Bytes)
+	}
+
+	treeStats := btree.Stats()
+	if treeStats.Namespaces["a/"] != stats || treeStats.Namespaces["b/"].Keys != 1 {
+		t.Fatalf("expected the usage of both namespaces in the stats, got %v", treeStats.Namespaces)
+	}
+
+	_, err = btree.NamespacedTree([]byte("a/b"))
+	if !errors.Is(err, ErrNamespaceOverlap) {
+		t.Fatalf("expected ErrNamespaceOverlap, got %v", err)
+	}
+
+	_, err = btree.NamespacedTree(nil)
+	if err == nil {
+		t.Fatal("expected an error for an empty prefix")
+	}
+}
+
+func TestNamespace_Quota(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	tenant, err := btree.NamespacedTree([]byte("t/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.SetQuota(NamespaceQuota{MaxKeys: 2, MaxBytes: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.Put([]byte("a"), []byte("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.Put([]byte("b"), []byte("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.Put([]byte("c"), []byte("1"))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded for a third key, got %v", err)
+	}
+
+	err = tenant.Put([]byte("a"), []byte("1234567"))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded for 21 bytes, got %v", err)
+	}
+
+	key, err := btree.Get([]byte("t/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected the rejected key not to be written")
+	}
+
+	err = tenant.Put([]byte("a"), []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.SetQuota(NamespaceQuota{MaxKeys: -1})
+	if err == nil {
+		t.Fatal("expected an error for a negative quota")
+	}
+}
+
+func TestNamespace_Reopen(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.ns")
+	defer os.Remove("btree.db.ns.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys written before the namespace is opened are counted
+	err = btree.Put([]byte("t/a"), []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant, err := btree.NamespacedTree([]byte("t/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.SetQuota(NamespaceQuota{MaxKeys: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.Put([]byte("b"), []byte("2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := NamespaceStats{Keys: 2, Bytes: 8, Quota: NamespaceQuota{MaxKeys: 10}}
+	if tenant.Stats() != expected {
+		t.Fatalf("expected %v, got %v", expected, tenant.Stats())
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a clean close keeps the usage and the quota
+	btree, err = Open("btree.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant, err = btree.NamespacedTree([]byte("t/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tenant.Stats() != expected {
+		t.Fatalf("expected %v after a clean restart, got %v", expected, tenant.Stats())
+	}
+
+	// writes made directly are accounted by Recount
+	err = btree.Put([]byte("t/c"), []byte("3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tenant.Recount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = NamespaceStats{Keys: 3, Bytes: 12, Quota: NamespaceQuota{MaxKeys: 10}}
+	if tenant.Stats() != expected {
+		t.Fatalf("expected %v after a recount, got %v", expected, tenant.Stats())
+	}
+
+	// a crash leaves the page marked as not clean, the usage is recounted
+	err = btree.Put([]byte("t/d"), []byte("4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.namespaces.pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btree.namespaces = nil
+
+	tenant, err = btree.NamespacedTree([]byte("t/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = NamespaceStats{Keys: 4, Bytes: 16, Quota: NamespaceQuota{MaxKeys: 10}}
+	if tenant.Stats() != expected {
+		t.Fatalf("expected %v after a crash, got %v", expected, tenant.Stats())
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
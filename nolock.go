@@ -0,0 +1,68 @@
+// Package btree
+// locking
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+// noLock is a sync.Locker which does nothing, it replaces the internal locks when locking is disabled
+type noLock struct{}
+
+// Lock does nothing
+func (noLock) Lock() {}
+
+// Unlock does nothing
+func (noLock) Unlock() {}
+
+// disableLocking replaces the lock of the pager with a no-op, see PagerOptions.NoLocking
+func (p *Pager) disableLocking() {
+	p.deletedPagesLock = noLock{}
+}
+
+// disableLocking replaces the lock of the value log with a no-op
+func (v *ValueLog) disableLocking() {
+	v.lock = noLock{}
+}
+
+// disableLocking disables the internal locks of the btree's pager, value log and expiry index, see Options.NoLocking
+func (b *BTree) disableLocking() {
+	b.noLocking = true
+
+	if p, ok := b.Pager.(*Pager); ok {
+		p.disableLocking()
+	}
+
+	if b.ValueLog != nil {
+		b.ValueLog.disableLocking()
+	}
+
+	if b.expiry != nil {
+		b.expiry.disableLocking()
+	}
+}
@@ -0,0 +1,181 @@
+// Package btree
+// locking tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBTree_NoLocking(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+	defer os.Remove("btree.db.ttl")
+	defer os.Remove("btree.db.ttl.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{NoLocking: true, ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	if _, ok := btree.Pager.(*Pager).deletedPagesLock.(noLock); !ok {
+		t.Fatal("expected the pager lock to be disabled")
+	}
+
+	if _, ok := btree.ValueLog.lock.(noLock); !ok {
+		t.Fatal("expected the value log lock to be disabled")
+	}
+
+	large := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 500; i++ {
+		err = btree.Put([]byte(strconv.Itoa(i)), large)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.PutWithTTL([]byte("session"), []byte("token"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := btree.expiry.Pager.(*Pager).deletedPagesLock.(noLock); !ok {
+		t.Fatal("expected the expiry index lock to be disabled")
+	}
+
+	for i := 0; i < 500; i += 2 {
+		err = btree.Delete([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 500; i++ {
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (key != nil) != (i%2 == 1) {
+			t.Fatalf("unexpected presence of key %d", i)
+		}
+
+		if key != nil && !bytes.Equal(key.V[0], large) {
+			t.Fatalf("expected the value of key %d from the value log", i)
+		}
+	}
+
+	// the scan falls back to a single worker
+	count := 0
+	err = btree.RangeParallel([]byte("0"), []byte("99999"), 8, func(key *Key) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 250 {
+		t.Fatalf("expected 250 keys, got %d", count)
+	}
+
+	checkBTree(t, btree)
+}
+
+func benchmarkPut(b *testing.B, opts *Options) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 32, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkGet(b *testing.B, opts *Options) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 32, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 10000; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := btree.Get([]byte(strconv.Itoa(i % 10000)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBTree_Put_Locking(b *testing.B) {
+	benchmarkPut(b, nil)
+}
+
+func BenchmarkBTree_Put_NoLocking(b *testing.B) {
+	benchmarkPut(b, &Options{NoLocking: true})
+}
+
+func BenchmarkBTree_Get_Locking(b *testing.B) {
+	benchmarkGet(b, nil)
+}
+
+func BenchmarkBTree_Get_NoLocking(b *testing.B) {
+	benchmarkGet(b, &Options{NoLocking: true})
+}
@@ -0,0 +1,136 @@
+// Package btree
+// object codecs
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// ErrKeyNotFound is returned when a key which must exist is not in the tree
+var ErrKeyNotFound = errors.New("key not found")
+
+// Codec serializes Go values stored with PutObject and read with GetObject
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)      // Marshal encodes v
+	Unmarshal(data []byte, v interface{}) error // Unmarshal decodes data into v
+}
+
+// MsgpackCodec encodes values with msgpack, the format used for pages
+type MsgpackCodec struct{}
+
+// Marshal encodes v with msgpack
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, msgpackHandle)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return encoded, nil
+}
+
+// Unmarshal decodes msgpack data into v
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := codec.NewDecoderBytes(data, msgpackHandle)
+	return dec.Decode(v)
+}
+
+// JSONCodec encodes values with encoding/json
+type JSONCodec struct{}
+
+// Marshal encodes v as JSON
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// PutObject encodes v with the codec of the tree and appends it as a value of key
+// With a ValueComparator the value is stored with its creation time so GetObject can tell which value is the most recent
+func (b *BTree) PutObject(key []byte, v interface{}) error {
+	data, err := b.objectCodec().Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if b.valueCompare != nil {
+		return b.PutWithMeta(key, data, ValueMeta{})
+	}
+
+	return b.Put(key, data)
+}
+
+// GetObject decodes the most recent value of key into v
+// ErrKeyNotFound is returned if the key does not exist
+func (b *BTree) GetObject(key []byte, v interface{}) error {
+	k, err := b.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if k == nil || len(k.V) == 0 {
+		return ErrKeyNotFound
+	}
+
+	return b.objectCodec().Unmarshal(k.V[b.latestValue(k)], v)
+}
+
+// latestValue returns the index of the most recently written value of k
+// Values are kept in insertion order unless a ValueComparator sorts them, then the highest version and creation time win
+func (b *BTree) latestValue(k *Key) int {
+	latest := len(k.V) - 1
+	if b.valueCompare == nil {
+		return latest
+	}
+
+	for i := range k.V {
+		if k.version(i) > k.version(latest) || k.version(i) == k.version(latest) && k.created(i) > k.created(latest) {
+			latest = i
+		}
+	}
+
+	return latest
+}
+
+// objectCodec returns the codec of the tree
+func (b *BTree) objectCodec() Codec {
+	if b.codec == nil {
+		return MsgpackCodec{}
+	}
+	return b.codec
+}
@@ -0,0 +1,128 @@
+// Package btree
+// object codecs tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+type testObject struct {
+	Name  string
+	Count int
+}
+
+func TestBTree_PutObject(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	for _, c := range []Codec{nil, MsgpackCodec{}, JSONCodec{}} {
+		btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 3, &Options{Codec: c})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = btree.PutObject([]byte("key"), testObject{Name: "first", Count: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = btree.PutObject([]byte("key"), testObject{Name: "second", Count: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var obj testObject
+		err = btree.GetObject([]byte("key"), &obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if obj.Name != "second" || obj.Count != 2 {
+			t.Fatalf("codec %T: unexpected object %+v", c, obj)
+		}
+
+		err = btree.GetObject([]byte("missing"), &obj)
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.Remove("btree.db.del")
+	}
+}
+
+func TestBTree_GetObject_ValueComparator(t *testing.T) {
+	// values sorted in descending order, the most recent value is not the last one
+	reverse := func(a, b []byte) int {
+		return bytes.Compare(b, a)
+	}
+
+	for _, versioned := range []bool{false, true} {
+		btree, err := OpenMemory(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		btree.valueCompare = reverse
+		btree.versioned = versioned
+		btree.codec = JSONCodec{}
+
+		for i, name := range []string{"a", "c", "b"} {
+			err = btree.PutObject([]byte("key"), testObject{Name: name, Count: i})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var obj testObject
+		err = btree.GetObject([]byte("key"), &obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if obj.Name != "b" || obj.Count != 2 {
+			t.Fatalf("versioned %v: expected the most recent object b, got %+v", versioned, obj)
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Package btree
+// object storage pager
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"github.com/hashicorp/go-msgpack/codec"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// ErrObjectNotFound is returned by an ObjectStore when an object does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore is a minimal object storage client, i.e. an S3 bucket
+type ObjectStore interface {
+	Get(key string) ([]byte, error)    // Get returns the object data or ErrObjectNotFound
+	Put(key string, data []byte) error // Put creates or replaces an object
+	Delete(key string) error           // Delete removes an object
+}
+
+// ObjectPager stores pages as objects in an ObjectStore
+// Pages are cached in memory and written back in batches
+type ObjectPager struct {
+	store     ObjectStore      // object store holding the pages
+	prefix    string           // prefix for object keys
+	cache     map[int64][]byte // cached pages
+	policy    EvictionPolicy   // picks the cached page to evict, LRU by default
+	cacheSize int              // maximum number of cached pages
+	dirty     map[int64][]byte // pages written but not yet flushed
+	batchSize int              // number of dirty pages which triggers a flush
+	meta      *objectPagerMeta // allocation state
+	lock      *sync.Mutex      // lock for the pager
+	pinned    map[int64]int    // pin count of pinned pages, never evicted
+	adaptive  *adaptiveCache   // resizes the cache with memory pressure, nil if the size is fixed
+	counters  cacheCounters    // counters reported by CacheStats
+}
+
+// objectPagerMeta is the allocation state persisted alongside the pages
+type objectPagerMeta struct {
+	Next int64   // next never used page id
+	Free []int64 // freed page ids
+}
+
+// OpenObjectPager opens a pager on top of an object store
+// cacheSize is the number of pages kept in memory, batchSize the number of dirty pages written back at once
+func OpenObjectPager(store ObjectStore, prefix string, cacheSize, batchSize int) (*ObjectPager, error) {
+	if cacheSize < 1 {
+		cacheSize = 1
+	}
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	p := &ObjectPager{
+		store:     store,
+		prefix:    prefix,
+		cache:     make(map[int64][]byte),
+		policy:    NewLRUPolicy(),
+		cacheSize: cacheSize,
+		dirty:     make(map[int64][]byte),
+		batchSize: batchSize,
+		meta:      &objectPagerMeta{Free: make([]int64, 0)},
+		lock:      &sync.Mutex{},
+		pinned:    make(map[int64]int),
+	}
+
+	data, err := store.Get(p.metaKey())
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		dec := codec.NewDecoderBytes(data, msgpackHandle)
+		err = dec.Decode(p.meta)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// pageKey returns the object key of a page
+func (p *ObjectPager) pageKey(pageID int64) string {
+	return p.prefix + strconv.FormatInt(pageID, 10)
+}
+
+// metaKey returns the object key of the allocation state
+func (p *ObjectPager) metaKey() string {
+	return p.prefix + "meta"
+}
+
+// ReadPage reads a page from the cache or the object store
+func (p *ObjectPager) ReadPage(pageID int64) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.read(pageID)
+}
+
+// read reads a page from the cache or the object store, the lock must be held
+func (p *ObjectPager) read(pageID int64) ([]byte, error) {
+	if data, ok := p.dirty[pageID]; ok {
+		return data, nil
+	}
+
+	if data, ok := p.cache[pageID]; ok {
+		p.counters.hits++
+		p.policy.Accessed(pageID)
+		return data, nil
+	}
+
+	p.counters.misses++
+
+	data, err := p.store.Get(p.pageKey(pageID))
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	p.addToCache(pageID, data)
+
+	return data, nil
+}
+
+// WritePage writes a page, the write reaches the object store on the next flush
+func (p *ObjectPager) WritePage(pageID int64, data []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if pageID >= p.meta.Next {
+		p.meta.Next = pageID + 1
+	}
+
+	return p.write(pageID, data)
+}
+
+// write buffers a page write and flushes once the batch is full
+func (p *ObjectPager) write(pageID int64, data []byte) error {
+	page := make([]byte, len(data))
+	copy(page, data)
+
+	p.dirty[pageID] = page
+	p.addToCache(pageID, page)
+
+	if len(p.dirty) >= p.batchSize {
+		return p.flush()
+	}
+
+	return nil
+}
+
+// Allocate writes data to a free or new page and returns its id
+func (p *ObjectPager) Allocate(data []byte) (int64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var pageID int64
+	if len(p.meta.Free) > 0 {
+		pageID = p.meta.Free[len(p.meta.Free)-1]
+		p.meta.Free = p.meta.Free[:len(p.meta.Free)-1]
+	} else {
+		pageID = p.meta.Next
+		p.meta.Next++
+	}
+
+	return pageID, p.write(pageID, data)
+}
+
+// Free deletes a page and marks its id for reuse
+func (p *ObjectPager) Free(pageID int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.dirty, pageID)
+	delete(p.pinned, pageID)
+	if _, ok := p.cache[pageID]; ok {
+		p.policy.Removed(pageID)
+		delete(p.cache, pageID)
+	}
+
+	// a page freed twice is only listed once, it would otherwise be handed out twice
+	if !slices.Contains(p.meta.Free, pageID) {
+		p.meta.Free = append(p.meta.Free, pageID)
+	}
+
+	err := p.store.Delete(p.pageKey(pageID))
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// Sync writes all dirty pages and the allocation state to the object store
+func (p *ObjectPager) Sync() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.flush()
+}
+
+// Close stops resizing the cache and flushes the pager
+func (p *ObjectPager) Close() error {
+	p.StopAdaptingCache()
+
+	return p.Sync()
+}
+
+// flush writes all dirty pages and the allocation state to the object store
+func (p *ObjectPager) flush() error {
+	for pageID, data := range p.dirty {
+		err := p.store.Put(p.pageKey(pageID), data)
+		if err != nil {
+			return err
+		}
+		delete(p.dirty, pageID)
+	}
+
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, msgpackHandle)
+	err := enc.Encode(p.meta)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Put(p.metaKey(), encoded)
+}
+
+// addToCache adds a page to the cache evicting pages chosen by the eviction policy if full
+func (p *ObjectPager) addToCache(pageID int64, data []byte) {
+	if _, ok := p.cache[pageID]; ok {
+		p.cache[pageID] = data
+		p.policy.Accessed(pageID)
+		return
+	}
+
+	p.cache[pageID] = data
+	p.policy.Added(pageID)
+
+	p.counters.evictions += uint64(p.evict())
+}
+
+// evict evicts the pages chosen by the eviction policy until the cache fits its size and returns how many were evicted
+// Pinned pages are skipped, the cache grows beyond its size if every page is pinned
+func (p *ObjectPager) evict() int {
+	pinned := func(pageID int64) bool {
+		return p.pinned[pageID] > 0
+	}
+
+	evicted := 0
+	for len(p.cache) > p.cacheSize {
+		pageID, ok := p.policy.Evict(pinned)
+		if !ok {
+			break
+		}
+
+		delete(p.cache, pageID)
+		evicted++
+	}
+	return evicted
+}
+
+// SetEvictionPolicy replaces the policy choosing the cached page to evict, see NewLRUPolicy, NewClockPolicy, New2QPolicy and NewLFUPolicy
+// The pages already cached are handed to the new policy in page order
+func (p *ObjectPager) SetEvictionPolicy(policy EvictionPolicy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pages := make([]int64, 0, len(p.cache))
+	for pageID := range p.cache {
+		pages = append(pages, pageID)
+	}
+	slices.Sort(pages)
+
+	for _, pageID := range pages {
+		policy.Added(pageID)
+	}
+
+	p.policy = policy
+	p.counters.evictions += uint64(p.evict())
+}
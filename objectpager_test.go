@@ -0,0 +1,186 @@
+// Package btree
+// object storage pager tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// mapObjectStore is an ObjectStore kept in a map
+type mapObjectStore struct {
+	objects map[string][]byte
+	puts    int
+	lock    sync.Mutex
+}
+
+func (m *mapObjectStore) Get(key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return data, nil
+}
+
+func (m *mapObjectStore) Put(key string, data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.objects[key] = data
+	m.puts++
+	return nil
+}
+
+func (m *mapObjectStore) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func TestOpenObjectPager(t *testing.T) {
+	store := &mapObjectStore{objects: make(map[string][]byte)}
+
+	pager, err := OpenObjectPager(store, "tree/", 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err := OpenWithStorage(pager, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = OpenObjectPager(store, "tree/", 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = OpenWithStorage(pager, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected value to be %d", i)
+		}
+	}
+}
+
+func TestObjectPager_WriteBack(t *testing.T) {
+	store := &mapObjectStore{objects: make(map[string][]byte)}
+
+	pager, err := OpenObjectPager(store, "tree/", 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 7; i++ {
+		_, err := pager.Allocate([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if store.puts != 0 {
+		t.Fatalf("expected no writes before the batch is full, got %d", store.puts)
+	}
+
+	err = pager.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 7 pages and the allocation state
+	if store.puts != 8 {
+		t.Fatalf("expected 8 writes, got %d", store.puts)
+	}
+}
+
+func TestObjectPager_DoubleFree(t *testing.T) {
+	store := &mapObjectStore{objects: make(map[string][]byte)}
+
+	pager, err := OpenObjectPager(store, "tree/", 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	pageID, err := pager.Allocate([]byte("page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = pager.Free(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a page freed twice is handed out once
+	first, err := pager.Allocate([]byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := pager.Allocate([]byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != pageID || second == pageID {
+		t.Fatalf("expected page %d to be reused once, got %d and %d", pageID, first, second)
+	}
+}
@@ -0,0 +1,260 @@
+// Package btree
+// functional options
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"os"
+	"time"
+)
+
+// Defaults used by OpenPath
+const (
+	DefaultFlag  = os.O_CREATE | os.O_RDWR // Create the file if it does not exist and open it for reading and writing
+	DefaultPerm  = 0644                    // File mode of created files
+	DefaultOrder = 64                      // Order of the tree
+)
+
+// Option configures a btree opened with OpenPath
+type Option func(*openConfig)
+
+// openConfig collects the settings of OpenPath
+type openConfig struct {
+	flag    int
+	perm    os.FileMode
+	t       int
+	options Options
+}
+
+// OpenPath opens a new or existing BTree at path, by default it is created if missing, opened for reading and writing with mode 0644 and order 64
+// It is OpenWithOptions with the settings given as options, i.e. OpenPath("btree.db", WithOrder(3), WithValueLog(4096))
+func OpenPath(path string, opts ...Option) (*BTree, error) {
+	config := &openConfig{flag: DefaultFlag, perm: DefaultPerm, t: DefaultOrder}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	options := config.options
+	return OpenWithOptions(path, config.flag, int(config.perm), config.t, &options)
+}
+
+// WithFlag sets the flags the file is opened with, i.e. os.O_RDONLY
+func WithFlag(flag int) Option {
+	return func(c *openConfig) { c.flag = flag }
+}
+
+// WithPerm sets the file mode of created files
+func WithPerm(perm os.FileMode) Option {
+	return func(c *openConfig) { c.perm = perm }
+}
+
+// WithOrder sets the order of the tree
+func WithOrder(t int) Option {
+	return func(c *openConfig) { c.t = t }
+}
+
+// WithOptions replaces every setting of Options, options given after it change single settings
+func WithOptions(opts *Options) Option {
+	return func(c *openConfig) {
+		if opts != nil {
+			c.options = *opts
+		}
+	}
+}
+
+// WithValueLog stores values of at least threshold bytes in the value log, see Options.ValueLogThreshold
+func WithValueLog(threshold int) Option {
+	return func(c *openConfig) { c.options.ValueLogThreshold = threshold }
+}
+
+// WithSafeWrites publishes the pages modified by every write atomically, see Options.SafeWrites
+func WithSafeWrites() Option {
+	return func(c *openConfig) { c.options.SafeWrites = true }
+}
+
+// WithPagerOptions sets the options of the pager, see Options.PagerOptions
+func WithPagerOptions(opts *PagerOptions) Option {
+	return func(c *openConfig) { c.options.PagerOptions = opts }
+}
+
+// WithVersioned assigns every appended value a version, see Options.Versioned
+func WithVersioned() Option {
+	return func(c *openConfig) { c.options.Versioned = true }
+}
+
+// WithLease coordinates writers through a lease file held for ttl, an empty owner uses a random id, see Options.LeaseTTL
+func WithLease(ttl time.Duration, owner string) Option {
+	return func(c *openConfig) {
+		c.options.LeaseTTL = ttl
+		c.options.LeaseOwner = owner
+	}
+}
+
+// WithTombstoneDeletes marks deleted keys with a tombstone, see Options.TombstoneDeletes
+func WithTombstoneDeletes() Option {
+	return func(c *openConfig) { c.options.TombstoneDeletes = true }
+}
+
+// WithCodec sets the codec used by PutObject and GetObject, see Options.Codec
+func WithCodec(codec Codec) Option {
+	return func(c *openConfig) { c.options.Codec = codec }
+}
+
+// WithFillFactor splits nodes by encoded size, see Options.FillFactor
+func WithFillFactor(fillFactor float64) Option {
+	return func(c *openConfig) { c.options.FillFactor = fillFactor }
+}
+
+// WithHooks sets the callbacks fired as the tree changes shape, see Options.Hooks
+func WithHooks(hooks *Hooks) Option {
+	return func(c *openConfig) { c.options.Hooks = hooks }
+}
+
+// WithValueComparator keeps the values of every key sorted, see Options.ValueComparator
+func WithValueComparator(compare func(a, b []byte) int) Option {
+	return func(c *openConfig) { c.options.ValueComparator = compare }
+}
+
+// WithKeyTransform normalizes every key, see Options.KeyTransform
+func WithKeyTransform(transform KeyTransform) Option {
+	return func(c *openConfig) { c.options.KeyTransform = transform }
+}
+
+// WithUniqueValues gives every key set semantics, see Options.UniqueValues
+func WithUniqueValues() Option {
+	return func(c *openConfig) { c.options.UniqueValues = true }
+}
+
+// WithValueHash checks keys with many values for duplicates through a hash set, see Options.ValueHash
+func WithValueHash(hash ValueHash) Option {
+	return func(c *openConfig) { c.options.ValueHash = hash }
+}
+
+// WithNoLocking disables internal locking, see Options.NoLocking
+func WithNoLocking() Option {
+	return func(c *openConfig) { c.options.NoLocking = true }
+}
+
+// WithDictionary compresses nodes with a preset dictionary, see Options.Dictionary
+func WithDictionary(dict []byte) Option {
+	return func(c *openConfig) { c.options.Dictionary = dict }
+}
+
+// WithRetention limits the history of versioned keys, see Options.Retention
+func WithRetention(policy *RetentionPolicy) Option {
+	return func(c *openConfig) { c.options.Retention = policy }
+}
+
+// WithSequenceBatch sets the number of ids NextSequence reserves at a time, see Options.SequenceBatch
+func WithSequenceBatch(n int) Option {
+	return func(c *openConfig) { c.options.SequenceBatch = n }
+}
+
+// WithConcurrentWriters lets puts and gets run concurrently, see Options.ConcurrentWriters
+func WithConcurrentWriters() Option {
+	return func(c *openConfig) { c.options.ConcurrentWriters = true }
+}
+
+// WithSeparatorIndex keeps the separator keys in memory for point lookups, see Options.SeparatorIndex
+func WithSeparatorIndex() Option {
+	return func(c *openConfig) { c.options.SeparatorIndex = true }
+}
+
+// WithAudit sends a record of every write to sink tagged with tag, see Options.Audit
+func WithAudit(sink AuditSink, tag string) Option {
+	return func(c *openConfig) {
+		c.options.Audit = sink
+		c.options.AuditTag = tag
+	}
+}
+
+// WithAuditBuffer sets the number of audit records buffered for the sink, see Options.AuditBuffer
+func WithAuditBuffer(n int) Option {
+	return func(c *openConfig) { c.options.AuditBuffer = n }
+}
+
+// WithStrict returns errors for malformed nodes instead of working around them, see Options.Strict
+func WithStrict() Option {
+	return func(c *openConfig) { c.options.Strict = true }
+}
+
+// WithNodeCodec stores nodes with the codec registered under name, see Options.NodeCodec
+func WithNodeCodec(name string) Option {
+	return func(c *openConfig) { c.options.NodeCodec = name }
+}
+
+// WithKeyVersions tracks a version per key, see Options.KeyVersions
+func WithKeyVersions() Option {
+	return func(c *openConfig) { c.options.KeyVersions = true }
+}
+
+// WithFixedKeySize requires every key to be of size bytes, see Options.FixedKeySize
+func WithFixedKeySize(size int) Option {
+	return func(c *openConfig) { c.options.FixedKeySize = size }
+}
+
+// WithIdempotencyWindow sets the number of operation ids PutIdempotent remembers, see Options.IdempotencyWindow
+func WithIdempotencyWindow(n int) Option {
+	return func(c *openConfig) { c.options.IdempotencyWindow = n }
+}
+
+// WithHeatmap counts one in sample node reads per page across restarts, see Options.Heatmap
+func WithHeatmap(sample int) Option {
+	return func(c *openConfig) {
+		c.options.Heatmap = true
+		c.options.HeatmapSample = sample
+	}
+}
+
+// WithPinInternalNodes pins the pages of the internal nodes on open, see Options.PinInternalNodes
+func WithPinInternalNodes() Option {
+	return func(c *openConfig) { c.options.PinInternalNodes = true }
+}
+
+// WithSequentialSplits splits nodes 90/10 while keys are inserted in ascending order, see Options.SequentialSplits
+func WithSequentialSplits() Option {
+	return func(c *openConfig) { c.options.SequentialSplits = true }
+}
+
+// WithVerifyChildren checks every child pointer a traversal follows, see Options.VerifyChildren
+func WithVerifyChildren() Option {
+	return func(c *openConfig) { c.options.VerifyChildren = true }
+}
+
+// WithFollowers counts the published operations for followers, see Options.Followers
+func WithFollowers() Option {
+	return func(c *openConfig) { c.options.Followers = true }
+}
+
+// WithMaxDepth sets the deepest a traversal descends before returning ErrMaxDepth, see Options.MaxDepth
+func WithMaxDepth(depth int) Option {
+	return func(c *openConfig) { c.options.MaxDepth = depth }
+}
@@ -0,0 +1,149 @@
+// Package btree
+// functional options tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOpenPath(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	btree, err := OpenPath("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if btree.T != DefaultOrder {
+		t.Fatalf("expected order %d, got %d", DefaultOrder, btree.T)
+	}
+
+	err = btree.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Mode().Perm()&^0022 != DefaultPerm&^0022 {
+		t.Fatalf("expected mode %o, got %o", DefaultPerm, stat.Mode().Perm())
+	}
+
+	btree, err = OpenPath("btree.db", WithOrder(3), WithOptions(&Options{ValueLogThreshold: 1, Versioned: true}), WithValueLog(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	if btree.T != 3 || btree.valueThreshold != 64 || !btree.versioned {
+		t.Fatalf("expected options given later to override WithOptions, got order %d threshold %d versioned %v", btree.T, btree.valueThreshold, btree.versioned)
+	}
+
+	key, err := btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.V[0]) != "value" {
+		t.Fatal("expected the key to be read back")
+	}
+
+	_, err = OpenPath("missing.db", WithFlag(os.O_RDWR))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the missing file not to be created, got %v", err)
+	}
+}
+
+func TestOpenPath_Options(t *testing.T) {
+	// every field of Options can be set with an option
+	opts := []Option{
+		WithValueLog(1),
+		WithSafeWrites(),
+		WithPagerOptions(&PagerOptions{}),
+		WithVersioned(),
+		WithLease(time.Second, "owner"),
+		WithTombstoneDeletes(),
+		WithCodec(JSONCodec{}),
+		WithFillFactor(0.5),
+		WithHooks(&Hooks{}),
+		WithValueComparator(bytes.Compare),
+		WithKeyTransform(LowerCaseKeys),
+		WithUniqueValues(),
+		WithValueHash(FNV64aValues),
+		WithNoLocking(),
+		WithDictionary([]byte("dict")),
+		WithRetention(&RetentionPolicy{}),
+		WithFixedKeySize(8),
+		WithSequenceBatch(10),
+		WithKeyVersions(),
+		WithConcurrentWriters(),
+		WithSeparatorIndex(),
+		WithAudit(AuditFunc(func(AuditRecord) {}), "tag"),
+		WithAuditBuffer(10),
+		WithStrict(),
+		WithNodeCodec("json"),
+		WithMaxDepth(16),
+		WithIdempotencyWindow(10),
+		WithHeatmap(4),
+		WithPinInternalNodes(),
+		WithSequentialSplits(),
+		WithVerifyChildren(),
+		WithFollowers(),
+	}
+
+	config := &openConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	v := reflect.ValueOf(config.options)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Errorf("no option sets Options.%s", v.Type().Field(i).Name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package btree
+// overlay storage
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// readOnlyFile wraps a page file which must never be modified
+type readOnlyFile struct {
+	pageFile
+}
+
+// WriteAt refuses to write
+func (f *readOnlyFile) WriteAt(b []byte, offset int64) (int, error) {
+	return 0, errors.New("file is read-only")
+}
+
+// Truncate refuses to truncate
+func (f *readOnlyFile) Truncate(size int64) error {
+	return errors.New("file is read-only")
+}
+
+// Sync has nothing to flush
+func (f *readOnlyFile) Sync() error {
+	return nil
+}
+
+// overlayStorage reads pages from the overlay if they were written there and from the base otherwise
+// Every write, allocation and free goes to the overlay so the base is never modified
+type overlayStorage struct {
+	base    *Pager // read-only base file
+	overlay *Pager // overlay file holding the pages written since the overlay was created
+}
+
+// ReadPage reads a page from the overlay or the base
+func (s *overlayStorage) ReadPage(pageID int64) ([]byte, error) {
+	// a page which was never written to the overlay reads as an empty header
+	header, err := s.overlay.readHeader(pageID)
+	if err == nil && header != "" {
+		return s.overlay.ReadPage(pageID)
+	}
+	return s.base.ReadPage(pageID)
+}
+
+// WritePage writes a page to the overlay
+func (s *overlayStorage) WritePage(pageID int64, data []byte) error {
+	return s.overlay.WritePage(pageID, data)
+}
+
+// Allocate writes data to a new page of the overlay, new pages never collide with pages of the base
+func (s *overlayStorage) Allocate(data []byte) (int64, error) {
+	return s.overlay.Allocate(data)
+}
+
+// Free marks a page as deleted in the overlay
+// Overflow pages of a page stored in the base are not reused
+func (s *overlayStorage) Free(pageID int64) error {
+	return s.overlay.Free(pageID)
+}
+
+// Sync flushes the overlay
+func (s *overlayStorage) Sync() error {
+	return s.overlay.Sync()
+}
+
+// Close closes the overlay and the base
+func (s *overlayStorage) Close() error {
+	return errors.Join(s.overlay.Close(), s.base.Close())
+}
+
+// OpenOverlay opens the BTree stored in base redirecting every write to overlay, the base file is never modified
+// Reopening the same overlay continues where it left off, i.e. to test a migration or bulk update against production data
+// The value log of the base is read but never appended to, large values written through the overlay are stored in its nodes.
+// The codec, dictionary, fixed key size and key transform recorded for the base are used, its expiry index and other files are not.
+func OpenOverlay(base, overlay string, t int) (*BTree, error) {
+	if t < 2 {
+		return nil, errors.New("t must be greater than 1")
+	}
+
+	stat, err := os.Stat(base)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := openFile(base, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// the deleted pages of the base are not needed, pages are only allocated from the overlay
+	basePager, err := newPager(&readOnlyFile{pageFile: file}, newMemFile(), time.Millisecond*128)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	overlayPager, err := OpenPager(overlay, os.O_CREATE|os.O_RDWR, stat.Mode().Perm(), time.Millisecond*128)
+	if err != nil {
+		basePager.Close()
+		return nil, err
+	}
+
+	// pages beyond the base are allocated in the overlay, the overlay file is sparse below them
+	if overlayPager.count.Load() < basePager.count.Load() {
+		overlayPager.count.Store(basePager.count.Load())
+	}
+
+	b, err := OpenWithStorage(&overlayStorage{base: basePager, overlay: overlayPager}, t)
+	if err != nil {
+		return nil, err
+	}
+
+	b.name = overlay
+	b.perm = stat.Mode().Perm()
+
+	// nodes of the base are read with the options it was written with
+	err = b.loadRecordedOptions(base)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	_, err = os.Stat(base + ".vlog")
+	if err == nil {
+		b.ValueLog, err = openReadOnlyValueLog(base + ".vlog")
+	}
+	if err != nil && !os.IsNotExist(err) {
+		b.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
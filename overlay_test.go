@@ -0,0 +1,274 @@
+// Package btree
+// overlay storage tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestOpenOverlay(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("overlay.db")
+	defer os.Remove("overlay.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		err = btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := os.ReadFile("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overlay, err := OpenOverlay("btree.db", "overlay.db", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bulk update splitting and rewriting nodes of the base
+	for i := 200; i < 400; i++ {
+		err = overlay.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = overlay.Put([]byte("0"), []byte("updated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = overlay.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.ReadFile("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(base, after) {
+		t.Fatal("expected the base to be untouched")
+	}
+
+	// reopening the overlay continues where it left off
+	overlay, err = OpenOverlay("btree.db", "overlay.db", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer overlay.Close()
+
+	for i := 0; i < 400; i++ {
+		key, err := overlay.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil {
+			t.Fatalf("expected key %d in the overlay", i)
+		}
+	}
+
+	key, err := overlay.Get([]byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 2 || string(key.V[1]) != "updated" {
+		t.Fatalf("expected the update in the overlay, got %q", key.V)
+	}
+
+	btree, err = Open("btree.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	key, err = btree.Get([]byte("300"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected the base not to see the overlay")
+	}
+
+	key, err = btree.Get([]byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 1 {
+		t.Fatalf("expected the base value only, got %q", key.V)
+	}
+}
+
+func TestOpenOverlay_ValueLog(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+	defer os.Remove("overlay.db")
+	defer os.Remove("overlay.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte("v"), 256)
+	for i := 0; i < 50; i++ {
+		err = btree.Put([]byte(strconv.Itoa(i)), large)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vlog, err := os.ReadFile("btree.db.vlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overlay, err := OpenOverlay("btree.db", "overlay.db", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a large value written through the overlay is stored in its node
+	err = overlay.Put([]byte("new"), large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"0", "49", "new"} {
+		key, err := overlay.Get([]byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || !bytes.Equal(key.V[0], large) {
+			t.Fatalf("expected the large value of key %s", k)
+		}
+	}
+
+	err = overlay.CompactValueLog()
+	if err == nil {
+		t.Fatal("expected the value log of the base not to be compacted")
+	}
+
+	err = overlay.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.ReadFile("btree.db.vlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(vlog, after) {
+		t.Fatal("expected the value log of the base to be untouched")
+	}
+}
+
+func TestOpenOverlay_NodeCodec(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.codec")
+	defer os.Remove("overlay.db")
+	defer os.Remove("overlay.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{NodeCodec: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overlay, err := OpenOverlay("btree.db", "overlay.db", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer overlay.Close()
+
+	if overlay.NodeCodec() != "json" {
+		t.Fatalf("expected the recorded codec json, got %s", overlay.NodeCodec())
+	}
+
+	err = overlay.Put([]byte("100"), []byte("100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i <= 100; i++ {
+		key, err := overlay.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected value %d", i)
+		}
+	}
+}
@@ -33,6 +33,7 @@ package btree
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -40,39 +41,196 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const PAGE_SIZE = 1024 // Page size
 const HEADER_SIZE = 16 // next (overflowed)
 
+// Storage is the interface the BTree uses to store its pages
+// Pager implements Storage on top of a file (OpenPager) or memory (OpenMemoryPager)
+type Storage interface {
+	ReadPage(pageID int64) ([]byte, error)     // ReadPage reads a page and any overflow pages linked to it
+	WritePage(pageID int64, data []byte) error // WritePage writes data to a specific page
+	Allocate(data []byte) (int64, error)       // Allocate writes data to a new page and returns its id
+	Free(pageID int64) error                   // Free marks a page as deleted so it can be reused
+	Sync() error                               // Sync flushes written pages to durable storage
+	Close() error                              // Close closes the storage
+}
+
+// pageFile is the storage a pager reads and writes, an os.File or an in-memory file
+type pageFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+	Stat() (os.FileInfo, error)
+}
+
 // Pager manages pages in a file
 type Pager struct {
-	file             *os.File      // file to store pages
+	file             pageFile      // file to store pages
 	deletedPages     []int64       // list of deleted pages
-	deletedPagesLock *sync.Mutex   // lock for deletedPages
-	deletedPagesFile *os.File      // file to store deleted pages
-	count            int64         // cached count of pages
+	freeOverflow     pageClasses   // deleted pages which were overflow pages, the others were node pages, guarded by deletedPagesLock
+	deletedPagesLock sync.Locker   // lock for deletedPages, a no-op if locking is disabled
+	deletedPagesFile pageFile      // file to store deleted pages
+	count            atomic.Int64  // number of pages in the file
 	syncInterval     time.Duration // interval to sync the file
 	exit             chan struct{} // exit channel
 	wg               *sync.WaitGroup
+	closed           atomic.Bool   // true once the pager is closed
+	allocator        AllocStrategy // strategy used to reuse deleted pages
+	stats            pagerCounters // i/o counters
+	epochs           pageEpochs    // backup epoch of every page, guarded by deletedPagesLock
+	epochName        string        // file the epochs are persisted to once a backup was taken, empty for in-memory pagers
+	perm             os.FileMode   // file mode of the pager's files
+	pinned           pinnedPages   // pages kept in memory, guarded by deletedPagesLock
+	lastSync         atomic.Int64  // time of the last successful sync in unix nanoseconds, 0 if the file was never synced
+	freelistLog      pageFile      // append-only log of the changes to the deleted pages since the last checkpoint, nil for in-memory pagers
+	freelistLogName  string        // file of the freelist log
+	freelistLogSize  int64         // bytes of complete records in the freelist log
+	freelistPending  []byte        // freelist records not yet appended to the log, guarded by deletedPagesLock
+	directIO         bool          // true if the file is read and written with direct i/o
+	fileName         string        // file the pages are stored in, empty for in-memory pagers
+	segments         *segmentFile  // the segments of the file, nil unless PagerOptions.SegmentPages is set
+	passive          atomic.Bool   // true while another handle writes the file, the deleted pages and epochs are then never written
 }
 
+// ErrClosed is returned when using a closed pager or btree
+var ErrClosed = errors.New("closed")
+
+// ErrPageNotFound is returned when reading a page beyond the end of the file, it wraps io.EOF
+var ErrPageNotFound = fmt.Errorf("page not found: %w", io.EOF)
+
+const OVERFLOW_MARKER = "c" // prefix of the header of a page continued in overflow pages
+
 // OpenPager opens a file for page management
 func OpenPager(filename string, flag int, perm os.FileMode, syncInterval time.Duration) (*Pager, error) {
-	file, err := os.OpenFile(filename, flag, perm)
+	return OpenPagerWithOptions(filename, flag, perm, &PagerOptions{SyncInterval: syncInterval})
+}
+
+// PagerOptions are optional settings used when opening a pager
+type PagerOptions struct {
+	SyncInterval time.Duration // Interval to sync the file, defaults to 128ms
+	ReadTimeout  time.Duration // Deadline for a single read, 0 waits forever
+	WriteTimeout time.Duration // Deadline for a single write or sync, 0 waits forever
+	Allocator    AllocStrategy // Strategy used to reuse deleted pages, defaults to ALLOC_LIFO
+	Retries      int           // Attempts for calls failing with transient errors (EINTR, EAGAIN, short writes), defaults to 3, negative disables retries
+	RetryBackoff time.Duration // Wait before retrying, doubled after every attempt, defaults to 1ms
+	NoLocking    bool          // Disable internal locking, every call on the pager must then be serialized by the caller
+	DirectIO     bool          // Bypass the page cache of the operating system with O_DIRECT, the file is opened normally where it is not supported
+	SegmentPages int64         // Split the file into segment files of this many pages, an existing segmented file keeps its recorded segment size
+	faults       *faultPlan    // Faults injected into the pager's files by tests, nil in production
+	passive      bool          // Open the pager without writing its metadata files, see Pager.passive
+}
+
+// OpenPagerWithOptions opens a file for page management with the provided options
+func OpenPagerWithOptions(filename string, flag int, perm os.FileMode, opts *PagerOptions) (*Pager, error) {
+	if opts == nil {
+		opts = &PagerOptions{}
+	}
+
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Millisecond * 128
+	}
+
+	// files written with another page layout would be misread
+	err := checkLayout(filename, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	var file, deletedPagesFile pageFile
+
+	file, segments, direct, err := openSegments(filename, flag, perm, opts.DirectIO, opts.SegmentPages)
 	if err != nil {
 		return nil, err
 	}
 
 	// open the deleted pages file
-	deletedPagesFile, err := os.OpenFile(filename+".del", os.O_CREATE|os.O_RDWR, perm)
+	deletedPagesFile, err = openFile(filename+".del", os.O_CREATE|os.O_RDWR, perm)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
+	if opts.Retries == 0 {
+		opts.Retries = 3
+	}
+
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = time.Millisecond
+	}
+
+	// faults are injected below the retries so injected short reads are continued like real ones
+	if opts.faults != nil {
+		file = opts.faults.wrap(file)
+		deletedPagesFile = opts.faults.wrap(deletedPagesFile)
+	}
+
+	// retries happen within the timeout of a call
+	file = newRetryFile(file, opts.Retries, opts.RetryBackoff)
+	deletedPagesFile = newRetryFile(deletedPagesFile, opts.Retries, opts.RetryBackoff)
+
+	if opts.ReadTimeout > 0 || opts.WriteTimeout > 0 {
+		file = &timeoutFile{pageFile: file, readTimeout: opts.ReadTimeout, writeTimeout: opts.WriteTimeout}
+		deletedPagesFile = &timeoutFile{pageFile: deletedPagesFile, readTimeout: opts.ReadTimeout, writeTimeout: opts.WriteTimeout}
+	}
+
+	p, err := newPager(file, deletedPagesFile, opts.SyncInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	p.epochName, p.perm, p.directIO = filename+".epoch", perm, direct
+	p.fileName, p.segments = filename, segments
+	p.passive.Store(opts.passive)
+
+	err = p.openFreelistLog(filename, perm, opts)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	// the epoch file only exists once a backup was taken
+	epochFile, err := openFile(p.epochName, os.O_RDWR, perm)
+	if err == nil {
+		// the epochs are marked as not closed cleanly until Close
+		err = p.epochs.load(epochFile)
+		if err == nil && !opts.passive {
+			err = p.epochs.write(false)
+		}
+		if err != nil {
+			epochFile.Close()
+		}
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	p.allocator = opts.Allocator
+
+	if opts.NoLocking {
+		p.disableLocking()
+	}
+
+	return p, nil
+}
+
+// OpenMemoryPager opens a pager which keeps its pages in memory instead of a file
+func OpenMemoryPager() (*Pager, error) {
+	return newPager(newMemFile(), newMemFile(), time.Second)
+}
+
+// newPager creates a pager on top of a page file and deleted pages file
+func newPager(file, deletedPagesFile pageFile, syncInterval time.Duration) (*Pager, error) {
 	// read the deleted pages
-	deletedPages, err := readDelPages(deletedPagesFile)
+	deletedPages, freeOverflow, err := readDelPages(deletedPagesFile)
 	if err != nil {
 		return nil, err
 	}
@@ -82,9 +240,8 @@ func OpenPager(filename string, flag int, perm os.FileMode, syncInterval time.Du
 		return nil, err
 	}
 
-	count := stat.Size() / (PAGE_SIZE + HEADER_SIZE)
-
-	p := &Pager{file: file, deletedPages: deletedPages, deletedPagesFile: deletedPagesFile, deletedPagesLock: &sync.Mutex{}, count: count, syncInterval: syncInterval, wg: &sync.WaitGroup{}}
+	p := &Pager{file: file, deletedPages: deletedPages, freeOverflow: freeOverflow, deletedPagesFile: deletedPagesFile, deletedPagesLock: &sync.Mutex{}, syncInterval: syncInterval, exit: make(chan struct{}), wg: &sync.WaitGroup{}, epochs: newPageEpochs(), pinned: make(pinnedPages)}
+	p.count.Store(pageCount(stat.Size()))
 	p.wg.Add(1)
 	go p.sync()
 
@@ -92,11 +249,14 @@ func OpenPager(filename string, flag int, perm os.FileMode, syncInterval time.Du
 }
 
 func (p *Pager) sync() {
+	defer p.wg.Done()
 	ticker := time.NewTicker(p.syncInterval)
 	for {
 		select {
 		case <-ticker.C:
-			p.file.Sync()
+			if p.file.Sync() == nil {
+				p.lastSync.Store(time.Now().UnixNano())
+			}
 		case <-p.exit:
 			ticker.Stop()
 			return
@@ -105,6 +265,7 @@ func (p *Pager) sync() {
 }
 
 // writeDelPages writes the deleted pages that are in-memory to the deleted pages file
+// This checkpoints the freelist, the freelist log is emptied once the deleted pages file is written
 func (p *Pager) writeDelPages() error {
 
 	// Truncate the file
@@ -113,34 +274,52 @@ func (p *Pager) writeDelPages() error {
 		return err
 	}
 
-	// Seek to the start of the file
-	_, err = p.deletedPagesFile.Seek(0, io.SeekStart)
+	// Write the deleted pages to the file
+	_, err = p.deletedPagesFile.WriteAt(p.formatDelPages(), 0)
 	if err != nil {
 		return err
 	}
 
-	// Write the deleted pages to the file
-	_, err = p.deletedPagesFile.WriteAt([]byte(strings.Join(strings.Fields(fmt.Sprint(p.deletedPages)), ",")), 0)
+	p.freelistPending = p.freelistPending[:0]
+
+	if p.freelistLog == nil || p.freelistLogSize == 0 {
+		return nil
+	}
+
+	// the snapshot must be durable before the records it includes are dropped
+	err = p.deletedPagesFile.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = p.freelistLog.Truncate(0)
 	if err != nil {
 		return err
 	}
+	p.freelistLogSize = 0
 
 	return nil
 }
 
 // readDelPages reads the deleted pages from the deleted pages file
-func readDelPages(file *os.File) ([]int64, error) {
+func readDelPages(file pageFile) ([]int64, pageClasses, error) {
 	pages := make([]int64, 0)
+	overflow := make(pageClasses)
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// stored in comma separated format
 	// i.e. 1,2,3,4,5
-	data, err := io.ReadAll(file)
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, stat.Size()))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(data) == 0 {
-		return pages, nil
+		return pages, overflow, nil
 	}
 
 	data = bytes.TrimLeft(data, "[")
@@ -158,9 +337,32 @@ func readDelPages(file *os.File) ([]int64, error) {
 
 		pages = append(pages, page)
 
+		// overflow pages carry a plus sign which older versions parse as part of the number
+		if strings.HasPrefix(pageStr, "+") {
+			overflow[page] = true
+		}
 	}
 
-	return pages, nil
+	return pages, overflow, nil
+}
+
+// formatDelPages formats the deleted pages as read by readDelPages, overflow pages are written with a plus sign
+// deletedPagesLock must be held
+func (p *Pager) formatDelPages() []byte {
+	data := make([]byte, 1, len(p.deletedPages)*8+2)
+	data[0] = '['
+	for i, pageID := range p.deletedPages {
+		if i > 0 {
+			data = append(data, ',')
+		}
+
+		if p.freeOverflow[pageID] {
+			data = append(data, '+')
+		}
+
+		data = strconv.AppendInt(data, pageID, 10)
+	}
+	return append(data, ']')
 }
 
 // splitDataIntoChunks splits data into chunks of PAGE_SIZE
@@ -180,161 +382,254 @@ func splitDataIntoChunks(data []byte) [][]byte {
 }
 
 // WriteTo writes data to a specific page
+// Data larger than a page continues in overflow pages linked through the page headers, overflow pages of the previous version of the page are reused
 func (p *Pager) WriteTo(pageID int64, data []byte) error {
-	p.DeletePage(pageID)
-	// remove from deleted pages
+	if p.closed.Load() {
+		return ErrClosed
+	}
+
+	if pageID < 0 || pageID > maxPageID {
+		return fmt.Errorf("page %d out of range", pageID)
+	}
+
 	p.deletedPagesLock.Lock()
 	defer p.deletedPagesLock.Unlock()
 
+	// remove from deleted pages
 	for i, page := range p.deletedPages {
 		if page == pageID {
 			p.deletedPages = append(p.deletedPages[:i], p.deletedPages[i+1:]...)
+			delete(p.freeOverflow, pageID)
+			p.logAlloc(pageID)
+			break
 		}
 	}
 
-	// the reason we are doing this is because we are going to write to the page thus having any overflowed pages which are linked to the page may not be needed
-
-	// check if data is larger than the page size
-	if len(data) > PAGE_SIZE {
-		// create an array [][]byte
-		// each element is a page
-
-		chunks := splitDataIntoChunks(data)
-
-		// clear data to free up memory
-		data = nil
+	// overflow pages of the previous version of the page are reused
+	old, err := p.overflowPages(pageID)
+	if err != nil {
+		return err
+	}
 
-		headerBuffer := make([]byte, HEADER_SIZE)
+	chunks := splitDataIntoChunks(data)
+	if len(chunks) == 0 {
+		chunks = [][]byte{nil}
+	}
 
-		// We need to create pages for each chunk
-		// after index 0
-		// the next page is the current page + 1
-
-		// index 0 would have the next page of index 1 index 1 would have the next page of index 2
-
-		for i, chunk := range chunks {
-			// check if we are at the last chunk
-			if i == len(chunks)-1 {
-				headerBuffer = make([]byte, HEADER_SIZE)
-				nextPage := pageID + 1
-				copy(headerBuffer, strconv.FormatInt(nextPage, 10))
-
-				// if chunk is less than PAGE_SIZE, we need to pad it with null bytes
-				if len(chunk) < PAGE_SIZE {
-					chunk = append(chunk, make([]byte, PAGE_SIZE-len(chunk))...)
-				}
-
-				// write the chunk to the file
-				_, err := p.file.WriteAt(append(headerBuffer, chunk...), pageID*(PAGE_SIZE+HEADER_SIZE))
-				if err != nil {
-					return err
-				}
-
-			} else {
-				// update the header
-				headerBuffer = make([]byte, HEADER_SIZE)
-				nextPage := pageID + 1
-				copy(headerBuffer, strconv.FormatInt(nextPage, 10))
-
-				if len(chunk) < PAGE_SIZE {
-					chunk = append(chunk, make([]byte, PAGE_SIZE-len(chunk))...)
-				}
-
-				// write the chunk to the file
-				_, err := p.file.WriteAt(append(headerBuffer, chunk...), pageID*(PAGE_SIZE+HEADER_SIZE))
-				if err != nil {
-					return err
-				}
-
-				// update the pageID
-				pageID = nextPage
+	pages := []int64{pageID}
+	for i := 1; i < len(chunks); i++ {
+		if len(old) > 0 {
+			pages = append(pages, old[0])
+			old = old[1:]
+			continue
+		}
 
-			}
+		overflow, err := p.allocateOverflow()
+		if err != nil {
+			return err
 		}
+		pages = append(pages, overflow)
+	}
 
-	} else {
-		// create a buffer to store the header
-		headerBuffer := make([]byte, HEADER_SIZE)
+	// overflow pages which are no longer needed can be reused
+	p.deletedPages = append(p.deletedPages, old...)
+	for _, page := range old {
+		p.freeOverflow[page] = true
+	}
+	p.logFree(old...)
 
-		// set the next page to -1
-		copy(headerBuffer, "-1")
+	for i, chunk := range chunks {
+		headerBuffer := make([]byte, HEADER_SIZE)
 
-		// if data is less than PAGE_SIZE, we need to pad it with null bytes
-		if len(data) < PAGE_SIZE {
-			data = append(data, make([]byte, PAGE_SIZE-len(data))...)
+		if i == len(chunks)-1 {
+			copy(headerBuffer, "-1")
+		} else if i == 0 {
+			// the head of an overflow chain is marked so the chain can be reused when the page is rewritten
+			copy(headerBuffer, OVERFLOW_MARKER+strconv.FormatInt(pages[1], 10))
+		} else {
+			copy(headerBuffer, strconv.FormatInt(pages[i+1], 10))
 		}
 
-		// write the data to the file
-		_, err := p.file.WriteAt(append(headerBuffer, data...), (PAGE_SIZE+HEADER_SIZE)*pageID)
+		// if chunk is less than PAGE_SIZE, we need to pad it with null bytes
+		page := make([]byte, HEADER_SIZE+PAGE_SIZE)
+		copy(page, headerBuffer)
+		copy(page[HEADER_SIZE:], chunk)
+
+		_, err := p.file.WriteAt(page, pages[i]*(PAGE_SIZE+HEADER_SIZE))
 		if err != nil {
 			return err
 		}
+		p.epochs.touch(pages[i])
+		p.pinned.invalidate(pages[i])
+
+		if pages[i] >= p.count.Load() {
+			p.count.Store(pages[i] + 1)
+		}
 
+		p.stats.pagesWritten.Add(1)
+		p.stats.bytesWritten.Add(uint64(len(page)))
+		if i > 0 {
+			p.stats.overflowPagesWritten.Add(1)
+		}
 	}
 
 	return nil
 }
 
-// Write writes data to the next available page
-func (p *Pager) Write(data []byte) (int64, error) {
-	// check if there are any deleted pages
-	if len(p.deletedPages) > 0 {
-		// get the last deleted page
-		pageID := p.deletedPages[len(p.deletedPages)-1]
-		p.deletedPages = p.deletedPages[:len(p.deletedPages)-1]
+// allocateOverflow returns a page for overflowed data, a deleted page or a new page at the end of the file
+// deletedPagesLock must be held
+func (p *Pager) allocateOverflow() (int64, error) {
+	pageID, ok := p.takeFreePage(true)
+	if ok {
+		return pageID, nil
+	}
 
-		err := p.WriteTo(pageID, data)
-		if err != nil {
-			return -1, err
-		}
+	// the page is written right after all pages of the chain are allocated
+	return p.count.Add(1) - 1, nil
+}
 
-		return pageID, nil
+// overflowPages returns the overflow pages linked to a page written with an overflow marker
+// Pages of chains written by older versions are not returned as their end cannot be told apart from the following pages
+func (p *Pager) overflowPages(pageID int64) ([]int64, error) {
+	header, err := p.readHeader(pageID)
+	if errors.Is(err, io.EOF) {
+		// a page which does not exist yet has no overflow pages
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
 
-	} else {
-		// get the current file size
-		fileInfo, err := p.file.Stat()
-		if err != nil {
-			return -1, err
-		}
+	if !strings.HasPrefix(header, OVERFLOW_MARKER) {
+		return nil, nil
+	}
 
-		if fileInfo.Size() == 0 {
-			err = p.WriteTo(0, data)
-			if err != nil {
-				return -1, err
-			}
-			p.count++
-			return 0, nil
+	pages := make([]int64, 0)
+	next, err := strconv.ParseInt(strings.TrimPrefix(header, OVERFLOW_MARKER), 10, 64)
+	for err == nil && next != -1 {
+		if int64(len(pages)) > p.count.Load() {
+			return nil, fmt.Errorf("page %d: overflow chain loops", pageID)
 		}
 
-		// create a new page
-		pageId := fileInfo.Size() / (PAGE_SIZE + HEADER_SIZE)
+		pages = append(pages, next)
 
-		err = p.WriteTo(pageId, data)
+		header, err = p.readHeader(next)
 		if err != nil {
-			return -1, err
+			return nil, fmt.Errorf("page %d: broken overflow chain: %w", pageID, err)
 		}
-		p.count++
-		return pageId, nil
+
+		next, err = strconv.ParseInt(header, 10, 64)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("page %d: invalid overflow header: %w", pageID, err)
 	}
+
+	return pages, nil
 }
 
-// Close closes the file
+// readHeader reads the header of a page without its null bytes
+func (p *Pager) readHeader(pageID int64) (string, error) {
+	header := make([]byte, HEADER_SIZE)
+	_, err := p.file.ReadAt(header, pageID*(PAGE_SIZE+HEADER_SIZE))
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.Trim(header, "\x00")), nil
+}
+
+// Write writes data to the next available page
+func (p *Pager) Write(data []byte) (int64, error) {
+	if p.closed.Load() {
+		return -1, ErrClosed
+	}
+
+	// reuse a deleted page or reserve a new page at the end of the file
+	p.deletedPagesLock.Lock()
+	pageID, ok := p.takeFreePage(false)
+	if !ok {
+		pageID = p.count.Add(1) - 1
+	}
+	p.deletedPagesLock.Unlock()
+
+	err := p.WriteTo(pageID, data)
+	if err != nil {
+		return -1, err
+	}
+
+	return pageID, nil
+}
+
+// Close writes the deleted pages, syncs and closes both files
+// All errors encountered are returned combined, further calls return ErrClosed
 func (p *Pager) Close() error {
+	if !p.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+
 	// close the exit channel
 	close(p.exit)
 	p.wg.Wait() // wait for the sync goroutine to finish
 
-	// sync one last time
-	p.file.Sync()
+	// the metadata files belong to the handle writing the file
+	if p.passive.Load() {
+		errs := []error{p.deletedPagesFile.Close(), p.file.Close()}
+		if p.freelistLog != nil {
+			errs = append(errs, p.freelistLog.Close())
+		}
+		if p.epochs.file != nil {
+			errs = append(errs, p.epochs.file.Close())
+		}
+		return errors.Join(errs...)
+	}
 
 	// write the deleted pages to the file
-	p.writeDelPages()
-	return p.file.Close()
+	p.deletedPagesLock.Lock()
+	delErr := p.writeDelPages()
+	epochErr := p.epochs.write(true)
+	p.deletedPagesLock.Unlock()
+
+	errs := []error{
+		delErr,
+		epochErr,
+		p.deletedPagesFile.Sync(),
+		p.file.Sync(),
+		p.deletedPagesFile.Close(),
+		p.file.Close(),
+	}
+
+	// the log is empty once the deleted pages were written
+	if delErr == nil {
+		errs = append(errs, p.closeFreelistLog())
+	} else if p.freelistLog != nil {
+		errs = append(errs, p.freelistLog.Sync(), p.freelistLog.Close())
+	}
+
+	if p.epochs.file != nil {
+		errs = append(errs, p.epochs.file.Sync(), p.epochs.file.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// DirectIO returns true if the file bypasses the page cache of the operating system
+// It is false if direct i/o was not requested or is not supported by the platform or file system
+func (p *Pager) DirectIO() bool {
+	return p.directIO
+}
+
+// IsClosed returns true once the pager is closed
+func (p *Pager) IsClosed() bool {
+	return p.closed.Load()
 }
 
 // GetPage gets a page and returns the data
 // Will gather all the pages that are linked together
+// ErrPageNotFound is returned for pages beyond the end of the file, a final page cut short by a crash is returned padded with null bytes
 func (p *Pager) GetPage(pageID int64) ([]byte, error) {
+	if p.closed.Load() {
+		return nil, ErrClosed
+	}
 
 	p.deletedPagesLock.Lock()
 	// Check if in deleted pages, if so return nil
@@ -342,105 +637,419 @@ func (p *Pager) GetPage(pageID int64) ([]byte, error) {
 		p.deletedPagesLock.Unlock()
 		return nil, nil
 	}
+
+	// pinned pages are served from memory
+	if pin, ok := p.pinned[pageID]; ok {
+		defer p.deletedPagesLock.Unlock()
+		return pin.get(p, pageID)
+	}
 	p.deletedPagesLock.Unlock()
 
-	result := make([]byte, 0)
+	return p.readChain(pageID)
+}
 
-	// get the page
-	dataPHeader := make([]byte, PAGE_SIZE+HEADER_SIZE)
+// readChain reads a page and the overflow pages linked to it
+func (p *Pager) readChain(pageID int64) ([]byte, error) {
+	page, err := p.readPage(pageID)
+	if err != nil {
+		return nil, err
+	}
 
-	if pageID == 0 {
+	header := string(bytes.Trim(page[:HEADER_SIZE], "\x00"))
 
-		_, err := p.file.ReadAt(dataPHeader, 0)
+	// the data of a single page is returned without copying
+	if header == "-1" {
+		return page[HEADER_SIZE:], nil
+	}
+
+	chained := strings.HasPrefix(header, OVERFLOW_MARKER)
+	nextPage, err := strconv.ParseInt(strings.TrimPrefix(header, OVERFLOW_MARKER), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("page %d: invalid header: %w", pageID, err)
+	}
+
+	result := page[HEADER_SIZE:]
+
+	for hops := 0; nextPage != -1; hops++ {
+		if int64(hops) > p.count.Load() {
+			return nil, fmt.Errorf("page %d: overflow chain loops", pageID)
+		}
+
+		page, err = p.readPage(nextPage)
 		if err != nil {
-			return nil, err
+			if !chained && errors.Is(err, ErrPageNotFound) {
+				// chains written by older versions are not terminated
+				break
+			}
+			return nil, fmt.Errorf("page %d: broken overflow chain: %w", pageID, err)
 		}
-	} else {
 
-		_, err := p.file.ReadAt(dataPHeader, pageID*(PAGE_SIZE+HEADER_SIZE))
+		// append the data to the result
+		result = append(result, page[HEADER_SIZE:]...)
+
+		// get the next page
+		nextPage, err = strconv.ParseInt(string(bytes.Trim(page[:HEADER_SIZE], "\x00")), 10, 64)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("page %d: invalid overflow header: %w", pageID, err)
 		}
 	}
 
-	// get header
-	header := dataPHeader[:HEADER_SIZE]
-	data := dataPHeader[HEADER_SIZE:]
+	return result, nil
+}
 
-	// remove the null bytes
-	header = bytes.Trim(header, "\x00")
-	//data = bytes.Trim(data, "\x00")
+// readPage reads the header and data of a single page
+func (p *Pager) readPage(pageID int64) ([]byte, error) {
+	if pageID < 0 || pageID > maxPageID {
+		return nil, fmt.Errorf("%w: page %d", ErrPageNotFound, pageID)
+	}
 
-	// append the data to the result
-	result = append(result, data...)
+	page := make([]byte, PAGE_SIZE+HEADER_SIZE)
 
-	// get the next page
-	nextPage, err := strconv.ParseInt(string(header), 10, 64)
-	if err != nil {
+	n, err := p.file.ReadAt(page, pageID*(PAGE_SIZE+HEADER_SIZE))
+	if err != nil && !errors.Is(err, io.EOF) {
 		return nil, err
 	}
 
-	if nextPage == -1 {
-		return result, nil
+	// a page without a complete header was never written
+	if n < HEADER_SIZE {
+		return nil, fmt.Errorf("%w: page %d", ErrPageNotFound, pageID)
+	}
+
+	p.stats.pagesRead.Add(1)
+	p.stats.bytesRead.Add(uint64(n))
+
+	return page, nil
+}
+
+// GetDeletedPages returns the list of deleted pages
+func (p *Pager) GetDeletedPages() []int64 {
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+	return p.deletedPages
+}
 
+// DeletePage deletes a page
+func (p *Pager) DeletePage(pageID int64) error {
+	if p.closed.Load() {
+		return ErrClosed
 	}
 
-	for {
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
 
-		dataPHeader = make([]byte, PAGE_SIZE+HEADER_SIZE)
+	// the overflow pages are deleted with the page
+	overflow, err := p.overflowPages(pageID)
+	if err != nil {
+		return err
+	}
 
-		_, err := p.file.ReadAt(dataPHeader, nextPage*(PAGE_SIZE+HEADER_SIZE))
+	if len(overflow) > 0 {
+		// unlink the chain so a later write to the page does not reuse pages handed out again
+		header := make([]byte, HEADER_SIZE)
+		copy(header, "-1")
+		_, err = p.file.WriteAt(header, pageID*(PAGE_SIZE+HEADER_SIZE))
 		if err != nil {
-			break
+			return err
 		}
+		p.epochs.touch(pageID)
+	}
 
-		// get header
-		header = dataPHeader[:HEADER_SIZE]
-		data = dataPHeader[HEADER_SIZE:]
+	// a deleted page is no longer pinned
+	delete(p.pinned, pageID)
 
-		// remove the null bytes
-		header = bytes.Trim(header, "\x00")
-		//data = bytes.Trim(data, "\x00")
+	// Add the pages to the deleted pages, a page deleted twice is only listed once
+	for i, page := range append([]int64{pageID}, overflow...) {
+		if !slices.Contains(p.deletedPages, page) {
+			p.deletedPages = append(p.deletedPages, page)
+			p.freeOverflow[page] = i > 0
+			p.logFree(page)
+		}
+	}
 
-		// append the data to the result
-		result = append(result, data...)
+	// log the changes to the deleted pages
+	err = p.flushFreelistLog()
+	if err != nil {
+		return err
+	}
 
-		// get the next page
-		nextPage, err = strconv.ParseInt(string(header), 10, 64)
-		if err != nil || nextPage == -1 {
-			break
+	return nil
+}
+
+// ReadPage reads a page, see GetPage
+func (p *Pager) ReadPage(pageID int64) ([]byte, error) {
+	return p.GetPage(pageID)
+}
+
+// WritePage writes data to a specific page, see WriteTo
+func (p *Pager) WritePage(pageID int64, data []byte) error {
+	return p.WriteTo(pageID, data)
+}
+
+// Allocate writes data to the next available page, see Write
+func (p *Pager) Allocate(data []byte) (int64, error) {
+	return p.Write(data)
+}
+
+// Free marks a page as deleted, see DeletePage
+func (p *Pager) Free(pageID int64) error {
+	return p.DeletePage(pageID)
+}
+
+// Sync flushes the pages and deleted pages to disk
+func (p *Pager) Sync() error {
+	if p.closed.Load() {
+		return ErrClosed
+	}
+
+	p.deletedPagesLock.Lock()
+	err := p.flushFreelistLog()
+	if err == nil {
+		err = p.epochs.write(false)
+	}
+	if err == nil && p.freelistLog != nil {
+		err = p.freelistLog.Sync()
+	}
+	p.deletedPagesLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = p.deletedPagesFile.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = p.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	p.lastSync.Store(time.Now().UnixNano())
+	return nil
+}
+
+// LastSync returns the time of the last successful sync of the file, the zero time if it was never synced
+func (p *Pager) LastSync() time.Time {
+	ns := p.lastSync.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Count returns the number of pages
+//
+// Deprecated: use PageCount
+func (p *Pager) Count() int64 {
+	return p.PageCount()
+}
+
+// PageCount returns the number of pages in the file including deleted and overflow pages
+func (p *Pager) PageCount() int64 {
+	return p.count.Load()
+}
+
+// FreePageCount returns the number of deleted pages waiting to be reused
+func (p *Pager) FreePageCount() int64 {
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+	return int64(len(p.deletedPages))
+}
+
+// FileSize returns the size of the page file in bytes, the size of every segment together for a segmented file
+// The size is computed from the page count if the file cannot be stat'ed
+func (p *Pager) FileSize() int64 {
+	if p.segments != nil {
+		size := int64(0)
+		for _, name := range p.segments.files() {
+			stat, err := os.Stat(name)
+			if err != nil {
+				return p.PageCount() * (PAGE_SIZE + HEADER_SIZE)
+			}
+			size += stat.Size()
 		}
+		return size
+	}
 
+	stat, err := p.file.Stat()
+	if err != nil {
+		return p.PageCount() * (PAGE_SIZE + HEADER_SIZE)
 	}
 
-	return result, nil
+	return stat.Size()
 }
 
-// GetDeletedPages returns the list of deleted pages
-func (p *Pager) GetDeletedPages() []int64 {
+// LiveDataSize returns the size in bytes of the pages which are in use, that is all pages but the deleted ones
+func (p *Pager) LiveDataSize() int64 {
 	p.deletedPagesLock.Lock()
 	defer p.deletedPagesLock.Unlock()
-	return p.deletedPages
+	return (p.count.Load() - int64(len(p.deletedPages))) * (PAGE_SIZE + HEADER_SIZE)
 }
 
-// DeletePage deletes a page
-func (p *Pager) DeletePage(pageID int64) error {
+// pageCount returns the number of pages of a file of size bytes, a final partial page counts as a page
+func pageCount(size int64) int64 {
+	return (size + PAGE_SIZE + HEADER_SIZE - 1) / (PAGE_SIZE + HEADER_SIZE)
+}
+
+// SaveTo writes a copy of the pager's pages and deleted pages to filename
+func (p *Pager) SaveTo(filename string) error {
+	file, err := openFile(filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = copyFile(file, p.file)
+	if err != nil {
+		return err
+	}
+
+	deletedPagesFile, err := openFile(filename+".del", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer deletedPagesFile.Close()
+
 	p.deletedPagesLock.Lock()
 	defer p.deletedPagesLock.Unlock()
 
-	// Add the page to the deleted pages
-	p.deletedPages = append(p.deletedPages, pageID)
+	// the deleted pages file may lag behind the in-memory list
+	err = p.writeDelPages()
+	if err != nil {
+		return err
+	}
 
-	// write the deleted pages to the file
-	err := p.writeDelPages()
+	err = copyFile(deletedPagesFile, p.deletedPagesFile)
+	if err != nil {
+		return err
+	}
+
+	err = deletedPagesFile.Sync()
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// LoadFrom replaces the pager's pages and deleted pages with the contents of filename
+func (p *Pager) LoadFrom(filename string) error {
+	file, err := openFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	deletedPages := make([]int64, 0)
+	freeOverflow := make(pageClasses)
+
+	deletedPagesFile, err := openFile(filename+".del", os.O_RDONLY, 0)
+	if err == nil {
+		defer deletedPagesFile.Close()
+
+		deletedPages, freeOverflow, err = readDelPages(deletedPagesFile)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	err = copyFile(p.file, file)
+	if err != nil {
+		return err
+	}
+
+	stat, err := p.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	p.deletedPages = deletedPages
+	p.freeOverflow = freeOverflow
+	p.count.Store(pageCount(stat.Size()))
+
+	// every page was replaced
+	p.epochs.since = p.epochs.current
+	p.epochs.pages = make(map[int64]uint64)
+	p.pinned.invalidateAll()
+
+	return p.writeDelPages()
+}
+
+// copyFile replaces the contents of dst with the contents of src
+func copyFile(dst, src pageFile) error {
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = dst.Truncate(0)
 	if err != nil {
 		return err
 	}
 
+	buf := make([]byte, PAGE_SIZE+HEADER_SIZE)
+	for offset := int64(0); offset < stat.Size(); offset += int64(len(buf)) {
+		n, err := src.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		_, err = dst.WriteAt(buf[:n], offset)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Count returns the number of pages
-func (p *Pager) Count() int64 {
-	return p.count
+// truncate shrinks the file to n pages dropping deleted pages beyond it
+func (p *Pager) truncate(n int64) error {
+	if p.closed.Load() {
+		return ErrClosed
+	}
+
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	kept := make([]int64, 0, len(p.deletedPages))
+	for _, pageID := range p.deletedPages {
+		if pageID < n {
+			kept = append(kept, pageID)
+		} else {
+			delete(p.freeOverflow, pageID)
+		}
+	}
+	p.deletedPages = kept
+
+	for pageID := range p.epochs.pages {
+		if pageID >= n {
+			delete(p.epochs.pages, pageID)
+		}
+	}
+
+	for pageID := range p.pinned {
+		if pageID >= n {
+			delete(p.pinned, pageID)
+		}
+	}
+
+	err := p.writeDelPages()
+	if err != nil {
+		return err
+	}
+
+	err = p.file.Truncate(n * (PAGE_SIZE + HEADER_SIZE))
+	if err != nil {
+		return err
+	}
+
+	p.count.Store(n)
+
+	return nil
 }
@@ -33,6 +33,7 @@ package btree
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -133,3 +134,342 @@ func TestPager_Count(t *testing.T) {
 		t.Fatalf("expected 10000, got %d", count)
 	}
 }
+
+func TestOpenMemoryPager(t *testing.T) {
+	pager, err := OpenMemoryPager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	for i := 0; i < 1000; i++ {
+		_, err := pager.Write([]byte(fmt.Sprintf("Hello World %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := pager.GetPage(999)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bytes.ReplaceAll(data, []byte("\x00"), []byte(""))) != "Hello World 999" {
+		t.Fatalf("expected Hello World 999, got %s", string(bytes.ReplaceAll(data, []byte("\x00"), []byte(""))))
+	}
+
+	if pager.Count() != 1000 {
+		t.Fatalf("expected 1000, got %d", pager.Count())
+	}
+}
+
+func TestPager_Close(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageID, err := pager.Write([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.DeletePage(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pager.IsClosed() {
+		t.Fatal("expected pager to be closed")
+	}
+
+	_, err = pager.GetPage(pageID)
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	_, err = pager.Write([]byte("Hello World"))
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	// the deleted pages were flushed on close
+	pager, err = OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	if len(pager.GetDeletedPages()) != 1 || pager.GetDeletedPages()[0] != pageID {
+		t.Fatalf("expected deleted page %d, got %v", pageID, pager.GetDeletedPages())
+	}
+}
+
+func TestPager_GetPage_NotFound(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pager.GetPage(0)
+	if !errors.Is(err, ErrPageNotFound) {
+		t.Fatalf("expected ErrPageNotFound reading an empty file, got %v", err)
+	}
+
+	_, err = pager.Write([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pager.GetPage(1)
+	if !errors.Is(err, ErrPageNotFound) {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+
+	_, err = pager.GetPage(-1)
+	if !errors.Is(err, ErrPageNotFound) {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+
+	err = pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash may leave the final page cut short
+	err = os.Truncate("btree.db", HEADER_SIZE+5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	data, err := pager.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != PAGE_SIZE || string(bytes.Trim(data, "\x00")) != "Hello" {
+		t.Fatalf("expected the partial page padded with null bytes, got %q", bytes.Trim(data, "\x00"))
+	}
+}
+
+func TestPager_Overflow(t *testing.T) {
+	pager, err := OpenMemoryPager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := pager.Write([]byte(fmt.Sprintf("page %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	large := bytes.Repeat([]byte("x"), PAGE_SIZE*2+10)
+	err = pager.WriteTo(0, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the overflow pages must not overwrite the pages following page 0
+	for i := 1; i < 3; i++ {
+		data, err := pager.GetPage(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(bytes.Trim(data, "\x00")) != fmt.Sprintf("page %d", i) {
+			t.Fatalf("expected page %d to be intact, got %q", i, bytes.Trim(data, "\x00"))
+		}
+	}
+
+	data, err := pager.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bytes.Trim(data, "\x00"), large) {
+		t.Fatal("expected the overflowed data back")
+	}
+
+	// rewriting the page reuses its overflow pages
+	count := pager.Count()
+
+	err = pager.WriteTo(0, bytes.Repeat([]byte("y"), PAGE_SIZE*2+10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pager.Count() != count {
+		t.Fatalf("expected %d pages after rewriting, got %d", count, pager.Count())
+	}
+
+	// a smaller version releases the overflow pages it no longer needs
+	err = pager.WriteTo(0, []byte("small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pager.GetDeletedPages()) != 2 {
+		t.Fatalf("expected 2 released overflow pages, got %v", pager.GetDeletedPages())
+	}
+
+	data, err = pager.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bytes.Trim(data, "\x00")) != "small" {
+		t.Fatalf("expected small, got %q", bytes.Trim(data, "\x00"))
+	}
+}
+
+func TestPager_SizeAccounting(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err := pager.Write([]byte(fmt.Sprintf("Hello World %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a page continued in 2 overflow pages
+	_, err = pager.Write(bytes.Repeat([]byte("x"), PAGE_SIZE*2+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pageID := range []int64{3, 4, 4} {
+		err = pager.DeletePage(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(pages, free int64) {
+		t.Helper()
+
+		if pager.PageCount() != pages {
+			t.Fatalf("expected %d pages, got %d", pages, pager.PageCount())
+		}
+
+		if pager.FreePageCount() != free {
+			t.Fatalf("expected %d free pages, got %d", free, pager.FreePageCount())
+		}
+
+		stat, err := os.Stat("btree.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pager.FileSize() != stat.Size() {
+			t.Fatalf("expected file size %d, got %d", stat.Size(), pager.FileSize())
+		}
+
+		if pager.LiveDataSize() != (pages-free)*(PAGE_SIZE+HEADER_SIZE) {
+			t.Fatalf("unexpected live data size %d", pager.LiveDataSize())
+		}
+	}
+
+	check(13, 2)
+
+	// deleting the large page releases its overflow pages
+	err = pager.DeletePage(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check(13, 5)
+
+	_, err = pager.Write([]byte("reused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check(13, 4)
+
+	err = pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	check(13, 4)
+}
+
+func TestPager_FileSize_PartialPage(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pager.Write([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn write leaves a partial page at the end of the file
+	file, err := os.OpenFile("btree.db", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = file.Write([]byte("torn"))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = OpenPager("btree.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	stat, err := os.Stat("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pager.FileSize() != stat.Size() {
+		t.Fatalf("expected file size %d, got %d", stat.Size(), pager.FileSize())
+	}
+}
@@ -0,0 +1,169 @@
+// Package btree
+// parallel range scans
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "sync"
+
+// RangeParallel calls fn for every key within the range [start, end] scanning subtrees with multiple workers
+// The range is split at internal node boundaries, fn is called concurrently and keys are not visited in order
+// The first error returned by fn stops the scan and is returned, trees opened with Options.NoLocking are scanned by a single worker
+func (b *BTree) RangeParallel(start, end []byte, workers int, fn func(key *Key) error) error {
+	start, end = b.transformKey(start), b.transformKey(end)
+
+	// without internal locks the pager must not be read from several goroutines
+	if workers < 1 || b.noLocking {
+		workers = 1
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	subtrees := []*Node{root}
+	separators := make([]*Key, 0)
+
+	// split the range until there are enough subtrees to keep the workers busy
+	for len(subtrees) < workers {
+		next := make([]*Node, 0)
+		expanded := false
+
+		for _, n := range subtrees {
+			if n.Leaf {
+				next = append(next, n)
+				continue
+			}
+
+			expanded = true
+
+			for i, c := range n.Children {
+				// child i holds keys between Keys[i-1] and Keys[i]
+				if i > 0 && n.Keys[i-1] != nil && greaterThan(n.Keys[i-1].K, end) {
+					break
+				}
+
+				if i < len(n.Keys) && n.Keys[i] != nil && lessThan(n.Keys[i].K, start) {
+					continue
+				}
+
+				child, err := b.readNode(c)
+				if err != nil {
+					return err
+				}
+
+				next = append(next, child)
+			}
+
+			for _, key := range n.Keys {
+				if key != nil && lessThanEq(start, key.K) && lessThanEq(key.K, end) {
+					separators = append(separators, key)
+				}
+			}
+		}
+
+		subtrees = next
+
+		if !expanded {
+			break
+		}
+	}
+
+	for _, key := range separators {
+		err = b.visitKey(key, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	tasks := make(chan *Node)
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	once := &sync.Once{}
+	var firstErr error
+
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range tasks {
+				keys, err := b.rangeKeys(start, end, n)
+				if err != nil {
+					fail(err)
+					continue
+				}
+
+				for _, key := range keys {
+					err = b.visitKey(key.(*Key), fn)
+					if err != nil {
+						fail(err)
+						break
+					}
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, n := range subtrees {
+		select {
+		case tasks <- n:
+		case <-stop:
+			break dispatch
+		}
+	}
+
+	close(tasks)
+	wg.Wait()
+
+	return firstErr
+}
+
+// visitKey resolves a key and calls fn with it unless the key expired or was deleted
+func (b *BTree) visitKey(key *Key, fn func(key *Key) error) error {
+	if key.hidden() {
+		return nil
+	}
+
+	resolved, err := b.resolveKey(key)
+	if err != nil {
+		return err
+	}
+
+	return fn(resolved)
+}
@@ -0,0 +1,232 @@
+// Package btree
+// page pinning
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrNotPinned is returned when unpinning a page which is not pinned
+var ErrNotPinned = errors.New("page not pinned")
+
+// pinnedPages are the pages a pager keeps in memory
+type pinnedPages map[int64]*pinnedPage
+
+// pinnedPage is a page kept in memory until it is unpinned as often as it was pinned
+type pinnedPage struct {
+	count int    // number of pins
+	data  []byte // the page with its overflow pages, nil until read again after a write
+}
+
+// get returns a copy of the pinned data, reading the page if it changed since it was last read
+// deletedPagesLock must be held
+func (pin *pinnedPage) get(p *Pager, pageID int64) ([]byte, error) {
+	if pin.data == nil {
+		data, err := p.readChain(pageID)
+		if err != nil {
+			return nil, err
+		}
+		pin.data = data
+	}
+
+	return slices.Clone(pin.data), nil
+}
+
+// invalidate drops the data of a pinned page after it was written
+func (pp pinnedPages) invalidate(pageID int64) {
+	if pin, ok := pp[pageID]; ok {
+		pin.data = nil
+	}
+}
+
+// invalidateAll drops the data of every pinned page
+func (pp pinnedPages) invalidateAll() {
+	for _, pin := range pp {
+		pin.data = nil
+	}
+}
+
+// Pin keeps a page and its overflow pages in memory so reads do not reach the file
+// A page pinned n times stays in memory until it is unpinned n times or deleted.  Embedding engines pin hot internal nodes, i.e. the root
+func (p *Pager) Pin(pageID int64) error {
+	if p.closed.Load() {
+		return ErrClosed
+	}
+
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	if pageID < 0 || pageID >= p.count.Load() || slices.Contains(p.deletedPages, pageID) {
+		return fmt.Errorf("%w: page %d", ErrPageNotFound, pageID)
+	}
+
+	pin, ok := p.pinned[pageID]
+	if !ok {
+		pin = &pinnedPage{}
+	}
+
+	// the page is read right away so the first read after pinning is served from memory
+	_, err := pin.get(p, pageID)
+	if err != nil {
+		return err
+	}
+
+	pin.count++
+	p.pinned[pageID] = pin
+
+	return nil
+}
+
+// Unpin releases a pin taken with Pin, the page is dropped from memory once every pin is released
+func (p *Pager) Unpin(pageID int64) error {
+	if p.closed.Load() {
+		return ErrClosed
+	}
+
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	pin, ok := p.pinned[pageID]
+	if !ok {
+		return fmt.Errorf("%w: page %d", ErrNotPinned, pageID)
+	}
+
+	pin.count--
+	if pin.count == 0 {
+		delete(p.pinned, pageID)
+	}
+
+	return nil
+}
+
+// Pinned returns the pinned pages in ascending order
+func (p *Pager) Pinned() []int64 {
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	pages := make([]int64, 0, len(p.pinned))
+	for pageID := range p.pinned {
+		pages = append(pages, pageID)
+	}
+	slices.Sort(pages)
+
+	return pages
+}
+
+// Pin keeps a page in the cache, pinned pages are never evicted
+// A page pinned n times stays cached until it is unpinned n times or freed
+func (p *ObjectPager) Pin(pageID int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, err := p.read(pageID)
+	if err != nil {
+		return err
+	}
+
+	p.pinned[pageID]++
+
+	return nil
+}
+
+// Unpin releases a pin taken with Pin, the page may be evicted once every pin is released
+func (p *ObjectPager) Unpin(pageID int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.pinned[pageID] == 0 {
+		return fmt.Errorf("%w: page %d", ErrNotPinned, pageID)
+	}
+
+	p.pinned[pageID]--
+	if p.pinned[pageID] == 0 {
+		delete(p.pinned, pageID)
+	}
+
+	return nil
+}
+
+// pagePinner is storage which can keep pages in memory, both the Pager and the ObjectPager are
+type pagePinner interface {
+	Pin(pageID int64) error
+	Unpin(pageID int64) error
+}
+
+// pinInternalNodes pins the page of every internal node, level by level from the root, and returns the number of pinned pages
+// Leaves are never read past the leftmost one Height descends to, storage which cannot pin pages pins nothing
+func (b *BTree) pinInternalNodes() (int, error) {
+	pinner, ok := b.Pager.(pagePinner)
+	if !ok {
+		return 0, nil
+	}
+
+	height, err := b.Height()
+	if err != nil {
+		return 0, err
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	pinned := 0
+	level := []*Node{root}
+	for depth := 1; depth < height; depth++ {
+		next := make([]*Node, 0)
+		for _, n := range level {
+			err = pinner.Pin(n.Page)
+			if err != nil {
+				return pinned, err
+			}
+			pinned++
+
+			// the children one level above the leaves are the last internal nodes
+			if depth+1 == height {
+				continue
+			}
+
+			for i := range n.Children {
+				child, err := b.descendChild(n, i, depth)
+				if err != nil {
+					return pinned, err
+				}
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+
+	return pinned, nil
+}
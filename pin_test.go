@@ -0,0 +1,297 @@
+// Package btree
+// page pinning tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPager_Pin(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	large := make([]byte, PAGE_SIZE*2)
+	for i := range large {
+		large[i] = 'a'
+	}
+
+	pageID, err := pager.Write(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Pin(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Pin(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager.ResetStats()
+
+	data, err := pager.GetPage(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data[:len(large)]) != string(large) {
+		t.Fatal("expected the pinned page")
+	}
+
+	if pager.Stats().PagesRead != 0 {
+		t.Fatalf("expected no pages read, got %d", pager.Stats().PagesRead)
+	}
+
+	// writing a pinned page is seen by the next read
+	err = pager.WriteTo(pageID, []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = pager.GetPage(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0] != 'b' {
+		t.Fatal("expected the rewritten page")
+	}
+
+	if len(pager.Pinned()) != 1 {
+		t.Fatalf("expected 1 pinned page, got %d", len(pager.Pinned()))
+	}
+
+	err = pager.Unpin(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Unpin(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Unpin(pageID)
+	if !errors.Is(err, ErrNotPinned) {
+		t.Fatalf("expected ErrNotPinned, got %v", err)
+	}
+
+	if len(pager.Pinned()) != 0 {
+		t.Fatal("expected no pinned pages")
+	}
+}
+
+func TestPager_PinDeleted(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	err = pager.Pin(0)
+	if !errors.Is(err, ErrPageNotFound) {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+
+	pageID, err := pager.Write([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Pin(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deleting the page drops its pin
+	err = pager.DeletePage(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pager.Pinned()) != 0 {
+		t.Fatal("expected no pinned pages")
+	}
+
+	err = pager.Pin(pageID)
+	if !errors.Is(err, ErrPageNotFound) {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+}
+
+func TestObjectPager_Pin(t *testing.T) {
+	store := &mapObjectStore{objects: make(map[string][]byte)}
+
+	pager, err := OpenObjectPager(store, "tree/", 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = pager.Allocate([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = pager.Pin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i < 5; i++ {
+		_, err = pager.ReadPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := pager.cache[0]; !ok {
+		t.Fatal("expected the pinned page to stay cached")
+	}
+
+	if len(pager.cache) != 2 {
+		t.Fatalf("expected 2 cached pages, got %d", len(pager.cache))
+	}
+
+	err = pager.Unpin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pager.Unpin(0)
+	if !errors.Is(err, ErrNotPinned) {
+		t.Fatalf("expected ErrNotPinned, got %v", err)
+	}
+
+	_, err = pager.ReadPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pager.ReadPage(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := pager.cache[0]; ok {
+		t.Fatal("expected the unpinned page to be evicted")
+	}
+}
+
+func TestBTree_PinInternalNodes(t *testing.T) {
+	defer os.Remove("pinned.db")
+	defer os.Remove("pinned.db.del")
+	defer os.Remove("pinned.db.height")
+
+	btree, err := Open("pinned.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := btree.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	internal := make([]int64, 0)
+	err = btree.walk(root, func(n *Node) error {
+		if !n.Leaf {
+			internal = append(internal, n.Page)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(internal)
+
+	if len(internal) < 2 {
+		t.Fatalf("expected a tree with more than one internal node, got %d", len(internal))
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = OpenWithOptions("pinned.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{PinInternalNodes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	pinned := btree.Pager.(*Pager).Pinned()
+	if !slices.Equal(pinned, internal) {
+		t.Fatalf("expected the internal nodes %v to be pinned, got %v", internal, pinned)
+	}
+
+	// the pinned nodes are still written and read as usual
+	err = btree.Put([]byte("500"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("500"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(key.V[0]) != "value" {
+		t.Fatalf("expected value, got %s", key.V[0])
+	}
+}
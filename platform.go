@@ -0,0 +1,108 @@
+// Package btree
+// platform portability
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"math"
+	"os"
+)
+
+// The files of a btree are opened through openFile and locked through lockFile and unlockFile, implemented per platform:
+//   - unix systems with flock use os.OpenFile and advisory flock locks (platform_flock.go)
+//   - windows opens files sharing them for reading, writing and deleting so sidecar files can be renamed and removed while open,
+//     and locks a byte range beyond the end of the file as windows locks are mandatory (platform_windows.go)
+//   - other platforms use os.OpenFile, locks are a no-op as there is no other process to coordinate with (platform_other.go)
+//
+// Page files opened for direct i/o use O_DIRECT where the platform has it (platform_direct.go) and are opened normally elsewhere (platform_nodirect.go)
+
+// maxPageID is the greatest page whose offset fits a file offset
+const maxPageID = math.MaxInt64/(PAGE_SIZE+HEADER_SIZE) - 1
+
+// ErrValueTooLarge is returned for values which cannot be addressed on this platform or in the value log
+var ErrValueTooLarge = errors.New("value too large")
+
+// checkLength returns the length of a stored value as an int, failing for lengths which do not fit an int on 32-bit platforms
+func checkLength(length uint64) (int, error) {
+	if length > uint64(math.MaxInt) {
+		return 0, ErrValueTooLarge
+	}
+	return int(length), nil
+}
+
+// withFileLock runs fn holding an exclusive lock on the file at path, the file is created if it does not exist
+// A file removed or replaced while waiting for the lock is opened again so the lock is always taken on the current file
+func withFileLock(path string, perm os.FileMode, fn func(f *os.File) error) error {
+	for {
+		f, err := openFile(path, os.O_CREATE|os.O_RDWR, perm)
+		if err != nil {
+			return err
+		}
+
+		err = lockFile(f)
+		if err != nil {
+			return errors.Join(err, f.Close())
+		}
+
+		current, err := isCurrent(f, path)
+		if err == nil && !current {
+			err = errors.Join(unlockFile(f), f.Close())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err == nil {
+			err = fn(f)
+		}
+
+		return errors.Join(err, unlockFile(f), f.Close())
+	}
+}
+
+// isCurrent returns true if f is the file found at path
+func isCurrent(f *os.File, path string) (bool, error) {
+	opened, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	found, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return os.SameFile(opened, found), nil
+}
@@ -0,0 +1,142 @@
+// Package btree
+// platform portability tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLease_Concurrent(t *testing.T) {
+	defer os.Remove("btree.db.lease")
+
+	leases := make([]*lease, 8)
+	for i := range leases {
+		l, err := newLease("btree.db.lease", "", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leases[i] = l
+	}
+
+	wg := &sync.WaitGroup{}
+	errs := make([]error, len(leases))
+	for i, l := range leases {
+		wg.Add(1)
+		go func(i int, l *lease) {
+			defer wg.Done()
+			errs[i] = l.acquire()
+		}(i, l)
+	}
+	wg.Wait()
+
+	held := 0
+	for i, err := range errs {
+		if err == nil {
+			held++
+		} else if !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("lease %d: %v", i, err)
+		}
+	}
+
+	if held != 1 {
+		t.Fatalf("expected exactly one owner, got %d", held)
+	}
+}
+
+func TestOpenFile_RenameWhileOpen(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.tmp")
+
+	f, err := openFile("btree.db", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = os.WriteFile("btree.db.tmp", []byte("replaced"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files are shared for deleting so an open file can be replaced
+	err = os.Rename("btree.db.tmp", "btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "replaced" {
+		t.Fatalf("expected the replaced file, got %q", data)
+	}
+}
+
+func TestPager_PageOutOfRange(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	err = pager.WriteTo(maxPageID+1, []byte("a"))
+	if err == nil {
+		t.Fatal("expected an error writing beyond the addressable pages")
+	}
+
+	_, err = pager.GetPage(maxPageID + 1)
+	if !errors.Is(err, ErrPageNotFound) {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	n, err := checkLength(1024)
+	if err != nil || n != 1024 {
+		t.Fatalf("expected 1024, got %d %v", n, err)
+	}
+
+	_, err = checkLength(math.MaxUint64)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Package btree
+// popping keys
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+// PopMin deletes the smallest key and returns it with its values, nil if the btree is empty
+// Pops are serialized so concurrent callers never pop the same key, which lets the btree be used as a persistent priority or work queue.
+func (b *BTree) PopMin() (*Key, error) {
+	return b.pop(false)
+}
+
+// PopMax deletes the largest key and returns it with its values, nil if the btree is empty
+func (b *BTree) PopMax() (*Key, error) {
+	return b.pop(true)
+}
+
+// pop deletes and returns the smallest or largest live key
+func (b *BTree) pop(max bool) (*Key, error) {
+	if b.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	if !b.noLocking {
+		b.popLock.Lock()
+		defer b.popLock.Unlock()
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := b.edgeKey(root, max)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	// values in the value log are read before the key is gone
+	key, err = b.resolveKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = b.deleteKey(key.K)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// edgeKey returns the smallest or largest key below x which is neither deleted nor expired, nil if there is none
+func (b *BTree) edgeKey(x *Node, max bool) (*Key, error) {
+	stack := make([]traversalFrame, 1, b.stackSize())
+	stack[0] = traversalFrame{node: x}
+
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		n := len(f.node.Keys)
+
+		if f.i > n {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		i := f.i
+		if max {
+			i = n - f.i
+		}
+
+		// child i holds the keys between key i-1 and key i
+		if !f.descended && !f.node.Leaf && i < len(f.node.Children) {
+			f.descended = true
+
+			child, err := b.descendChild(f.node, i, len(stack))
+			if err != nil {
+				return nil, err
+			}
+
+			stack = append(stack, traversalFrame{node: child})
+			continue
+		}
+
+		f.i++
+		f.descended = false
+
+		k := i
+		if max {
+			k = i - 1
+		}
+
+		if k >= 0 && k < n && f.node.Keys[k] != nil && !f.node.Keys[k].hidden() {
+			return f.node.Keys[k], nil
+		}
+	}
+
+	return nil, nil
+}
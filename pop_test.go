@@ -0,0 +1,164 @@
+// Package btree
+// popping keys tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestBTree_PopMin(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{TombstoneDeletes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	key, err := btree.PopMin()
+	if err != nil || key != nil {
+		t.Fatalf("expected nothing to pop from an empty btree, got %v %v", key, err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// deleted keys are skipped
+	err = btree.Delete([]byte("000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Delete([]byte("099"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < 50; i++ {
+		key, err := btree.PopMin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.K) != fmt.Sprintf("%03d", i) || string(key.V[0]) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("expected key %03d, got %v", i, key)
+		}
+
+		key, err = btree.PopMax()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.K) != fmt.Sprintf("%03d", 99-i) {
+			t.Fatalf("expected key %03d, got %v", 99-i, key)
+		}
+	}
+
+	key, err = btree.PopMax()
+	if err != nil || key != nil {
+		t.Fatalf("expected the btree to be empty, got %v %v", key, err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = btree.PopMin()
+	if err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestBTree_PopMin_Concurrent(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 200; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("job"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var lock sync.Mutex
+	popped := make(map[string]int)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				key, err := btree.PopMin()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if key == nil {
+					return
+				}
+
+				lock.Lock()
+				popped[string(key.K)]++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(popped) != 200 {
+		t.Fatalf("expected 200 keys to be popped, got %d", len(popped))
+	}
+
+	for k, n := range popped {
+		if n != 1 {
+			t.Fatalf("expected key %s to be popped once, got %d", k, n)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package btree
+// key range locks
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// LockMode is the mode of a range lock
+type LockMode int
+
+const (
+	LOCK_SHARED    LockMode = iota // Shared locks on overlapping ranges are compatible
+	LOCK_EXCLUSIVE                 // Exclusive locks conflict with any other lock on an overlapping range
+)
+
+// ErrRangeLocked is returned by TryLockRange when the range is locked by another owner
+var ErrRangeLocked = errors.New("range is locked by another owner")
+
+// ErrDeadlock is returned by LockRange when waiting for the range would deadlock
+var ErrDeadlock = errors.New("deadlock detected")
+
+// rangeLock is a lock held by an owner on the keys within [start, end], nil bounds are unbounded
+type rangeLock struct {
+	owner      uint64
+	start, end []byte
+	mode       LockMode
+}
+
+// rangeLocks is the lock table of a tree
+type rangeLocks struct {
+	lock  sync.Mutex
+	cond  *sync.Cond
+	held  []*rangeLock
+	waits map[uint64]map[uint64]bool // owners each waiting owner waits for
+}
+
+// LockRange locks the keys within [start, end] for owner, waiting while another owner holds a conflicting lock
+// A nil start or end leaves that side of the range unbounded, i.e. to lock the gap after the last key.
+// Locks are advisory, they are not checked by the tree's own reads and writes and are meant for layers implementing isolation on top of it.
+// An owner never conflicts with itself.  ErrDeadlock is returned instead of waiting if the owners holding the range wait for owner.
+func (b *BTree) LockRange(owner uint64, start, end []byte, mode LockMode) error {
+	start, end = b.transformKey(start), b.transformKey(end)
+
+	t := b.rangeLockTable()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for {
+		blockers := t.conflicts(owner, start, end, mode)
+		if len(blockers) == 0 {
+			delete(t.waits, owner)
+			t.held = append(t.held, &rangeLock{owner: owner, start: start, end: end, mode: mode})
+			return nil
+		}
+
+		t.waits[owner] = blockers
+		if t.waitsFor(blockers, owner, make(map[uint64]bool)) {
+			delete(t.waits, owner)
+			return ErrDeadlock
+		}
+
+		t.cond.Wait()
+	}
+}
+
+// TryLockRange locks the keys within [start, end] for owner, ErrRangeLocked is returned instead of waiting
+func (b *BTree) TryLockRange(owner uint64, start, end []byte, mode LockMode) error {
+	start, end = b.transformKey(start), b.transformKey(end)
+
+	t := b.rangeLockTable()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.conflicts(owner, start, end, mode)) > 0 {
+		return ErrRangeLocked
+	}
+
+	t.held = append(t.held, &rangeLock{owner: owner, start: start, end: end, mode: mode})
+	return nil
+}
+
+// UnlockRange releases a lock owner took on exactly [start, end]
+func (b *BTree) UnlockRange(owner uint64, start, end []byte) error {
+	start, end = b.transformKey(start), b.transformKey(end)
+
+	t := b.rangeLockTable()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for i, l := range t.held {
+		if l.owner == owner && bytes.Equal(l.start, start) && bytes.Equal(l.end, end) && (l.start == nil) == (start == nil) && (l.end == nil) == (end == nil) {
+			t.held = append(t.held[:i], t.held[i+1:]...)
+			t.cond.Broadcast()
+			return nil
+		}
+	}
+
+	return errors.New("range is not locked by owner")
+}
+
+// UnlockAll releases every lock held by owner, i.e. when its transaction ends
+func (b *BTree) UnlockAll(owner uint64) {
+	t := b.rangeLockTable()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	held := t.held[:0]
+	for _, l := range t.held {
+		if l.owner != owner {
+			held = append(held, l)
+		}
+	}
+	t.held = held
+
+	t.cond.Broadcast()
+}
+
+// rangeLockTable returns the lock table of the tree creating it on first use
+func (b *BTree) rangeLockTable() *rangeLocks {
+	b.rangeLocksOnce.Do(func() {
+		b.rangeLocks = &rangeLocks{waits: make(map[uint64]map[uint64]bool)}
+		b.rangeLocks.cond = sync.NewCond(&b.rangeLocks.lock)
+	})
+	return b.rangeLocks
+}
+
+// conflicts returns the other owners holding locks which conflict with the requested lock
+func (t *rangeLocks) conflicts(owner uint64, start, end []byte, mode LockMode) map[uint64]bool {
+	blockers := make(map[uint64]bool)
+	for _, l := range t.held {
+		if l.owner == owner || (l.mode == LOCK_SHARED && mode == LOCK_SHARED) {
+			continue
+		}
+
+		if rangesOverlap(l.start, l.end, start, end) {
+			blockers[l.owner] = true
+		}
+	}
+	return blockers
+}
+
+// waitsFor returns true if any of owners waits, directly or through other owners, for target
+func (t *rangeLocks) waitsFor(owners map[uint64]bool, target uint64, seen map[uint64]bool) bool {
+	for o := range owners {
+		if o == target {
+			return true
+		}
+
+		if seen[o] {
+			continue
+		}
+		seen[o] = true
+
+		if t.waitsFor(t.waits[o], target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangesOverlap returns true if [aStart, aEnd] and [bStart, bEnd] share a key, nil bounds are unbounded
+func rangesOverlap(aStart, aEnd, bStart, bEnd []byte) bool {
+	if aEnd != nil && bStart != nil && bytes.Compare(aEnd, bStart) < 0 {
+		return false
+	}
+
+	if bEnd != nil && aStart != nil && bytes.Compare(bEnd, aStart) < 0 {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,174 @@
+// Package btree
+// key range locks tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBTree_TryLockRange(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.TryLockRange(1, []byte("b"), []byte("d"), LOCK_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shared locks are compatible
+	err = btree.TryLockRange(2, []byte("c"), []byte("e"), LOCK_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.TryLockRange(3, []byte("d"), []byte("f"), LOCK_EXCLUSIVE)
+	if !errors.Is(err, ErrRangeLocked) {
+		t.Fatalf("expected ErrRangeLocked, got %v", err)
+	}
+
+	// ranges which do not overlap never conflict
+	err = btree.TryLockRange(3, []byte("f"), []byte("g"), LOCK_EXCLUSIVE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an owner does not conflict with itself
+	err = btree.TryLockRange(3, []byte("f"), nil, LOCK_EXCLUSIVE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unbounded range conflicts with everything after f
+	err = btree.TryLockRange(1, []byte("z"), []byte("z"), LOCK_SHARED)
+	if !errors.Is(err, ErrRangeLocked) {
+		t.Fatalf("expected ErrRangeLocked, got %v", err)
+	}
+
+	btree.UnlockAll(3)
+
+	err = btree.TryLockRange(1, []byte("z"), []byte("z"), LOCK_EXCLUSIVE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.UnlockRange(1, []byte("z"), []byte("z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.UnlockRange(1, []byte("z"), []byte("z"))
+	if err == nil {
+		t.Fatal("expected an error unlocking a range which is not locked")
+	}
+}
+
+func TestBTree_LockRange(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.LockRange(1, []byte("a"), []byte("c"), LOCK_EXCLUSIVE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- btree.LockRange(2, []byte("b"), []byte("b"), LOCK_EXCLUSIVE)
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("expected owner 2 to wait, got %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	err = btree.UnlockRange(1, []byte("a"), []byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = <-acquired
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTree_LockRange_Deadlock(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.LockRange(1, []byte("a"), []byte("a"), LOCK_EXCLUSIVE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.LockRange(2, []byte("b"), []byte("b"), LOCK_EXCLUSIVE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := make(chan error)
+	go func() {
+		// owner 1 waits for owner 2
+		waiting <- btree.LockRange(1, []byte("b"), []byte("b"), LOCK_EXCLUSIVE)
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+
+	// owner 2 waiting for owner 1 would deadlock
+	err = btree.LockRange(2, []byte("a"), []byte("a"), LOCK_EXCLUSIVE)
+	if !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("expected ErrDeadlock, got %v", err)
+	}
+
+	// owner 2 aborts, owner 1 gets its lock
+	btree.UnlockAll(2)
+
+	err = <-waiting
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,246 @@
+// Package btree
+// range options
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+// RangeOptions are the bounds and order of a range query
+type RangeOptions struct {
+	IncludeStart bool // Return a key equal to start
+	IncludeEnd   bool // Return a key equal to end
+	Limit        int  // Maximum number of keys returned, 0 returns every key
+	Reverse      bool // Return keys in descending order starting at end
+}
+
+// keyBounds are the bounds of a range, a nil bound leaves the range open on that side
+type keyBounds struct {
+	start, end               []byte
+	includeStart, includeEnd bool
+}
+
+// afterStart returns true if k is not cut off by the start of the range
+func (r *keyBounds) afterStart(k []byte) bool {
+	if r.start == nil {
+		return true
+	}
+	if r.includeStart {
+		return lessThanEq(r.start, k)
+	}
+	return lessThan(r.start, k)
+}
+
+// beforeEnd returns true if k is not cut off by the end of the range
+func (r *keyBounds) beforeEnd(k []byte) bool {
+	if r.end == nil {
+		return true
+	}
+	if r.includeEnd {
+		return lessThanEq(k, r.end)
+	}
+	return lessThan(k, r.end)
+}
+
+// RangeOpt returns the keys between start and end with the bounds, limit and order of opts
+// A nil start or end leaves the range open on that side, nil opts behave like Range (both bounds inclusive, ascending, no limit)
+func (b *BTree) RangeOpt(start, end []byte, opts *RangeOptions) ([]*Key, error) {
+	keys := make([]*Key, 0)
+
+	err := b.scanRangeOpt(start, end, opts, func(k *Key) error {
+		resolved, err := b.resolveKey(k)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, resolved)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RangeFilter returns the keys within [start, end] for which filter returns true
+// The filter is called during the traversal with the key and its values, only the keys it accepts are collected.
+// The slices passed to filter must not be modified.
+func (b *BTree) RangeFilter(start, end []byte, filter func(k []byte, vs [][]byte) bool) ([]*Key, error) {
+	keys := make([]*Key, 0)
+
+	err := b.scanRangeOpt(start, end, nil, func(k *Key) error {
+		resolved, err := b.resolveKey(k)
+		if err != nil {
+			return err
+		}
+
+		if filter(resolved.K, resolved.V) {
+			keys = append(keys, resolved)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RangeCount returns the number of keys RangeOpt would return without reading their values from the value log
+func (b *BTree) RangeCount(start, end []byte, opts *RangeOptions) (int, error) {
+	count := 0
+
+	err := b.scanRangeOpt(start, end, opts, func(k *Key) error {
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
+// scanRangeOpt calls fn for every visible key of a range query in the requested order until the limit is reached
+func (b *BTree) scanRangeOpt(start, end []byte, opts *RangeOptions, fn func(k *Key) error) error {
+	if opts == nil {
+		opts = &RangeOptions{IncludeStart: true, IncludeEnd: true}
+	}
+
+	if start != nil {
+		start = b.transformKey(start)
+	}
+	if end != nil {
+		end = b.transformKey(end)
+	}
+
+	bounds := &keyBounds{start: start, end: end, includeStart: opts.IncludeStart, includeEnd: opts.IncludeEnd}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	_, err = b.scanBounds(root, bounds, opts.Reverse, func(k *Key) (bool, error) {
+		if k.hidden() {
+			return false, nil
+		}
+
+		err := fn(k)
+		if err != nil {
+			return true, err
+		}
+
+		found++
+		return opts.Limit > 0 && found >= opts.Limit, nil
+	})
+
+	return err
+}
+
+// scanBounds visits the keys of the subtree x within bounds in ascending or descending order, only descending into children which may hold keys of the range
+// fn returns true to stop, scanBounds returns true once fn stopped or the range was passed
+func (b *BTree) scanBounds(x *Node, bounds *keyBounds, reverse bool, fn func(k *Key) (bool, error)) (bool, error) {
+	// the slots of a node with n keys alternate child 0, key 0, child 1 .. key n-1, child n
+	// slot 2i is child i which holds the keys between key i-1 and key i, slot 2i+1 is key i
+	first := func(n *Node) int {
+		n.Keys = removeNilFromKeys(n.Keys)
+		if reverse {
+			return 2 * len(n.Keys)
+		}
+		return 0
+	}
+
+	step := 1
+	if reverse {
+		step = -1
+	}
+
+	stack := make([]traversalFrame, 1, b.stackSize())
+	stack[0] = traversalFrame{node: x, i: first(x)}
+
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		n := len(f.node.Keys)
+
+		if f.i < 0 || f.i > 2*n {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		slot := f.i
+		f.i += step
+
+		if slot%2 == 1 {
+			k := f.node.Keys[slot/2]
+
+			// the key is past the range in the direction of the scan
+			if !bounds.afterStart(k.K) {
+				if reverse {
+					return true, nil
+				}
+				continue
+			}
+
+			if !bounds.beforeEnd(k.K) {
+				if !reverse {
+					return true, nil
+				}
+				continue
+			}
+
+			stop, err := fn(k)
+			if stop || err != nil {
+				return true, err
+			}
+			continue
+		}
+
+		if f.node.Leaf {
+			continue
+		}
+
+		i := slot / 2
+
+		// every key of the child is before start or after end
+		if i < n && bounds.start != nil && lessThanEq(f.node.Keys[i].K, bounds.start) {
+			continue
+		}
+
+		if i > 0 && bounds.end != nil && lessThanEq(bounds.end, f.node.Keys[i-1].K) {
+			continue
+		}
+
+		child, err := b.descendChild(f.node, i, len(stack))
+		if err != nil {
+			return true, err
+		}
+
+		stack = append(stack, traversalFrame{node: child, i: first(child)})
+	}
+
+	return false, nil
+}
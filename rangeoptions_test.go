@@ -0,0 +1,242 @@
+// Package btree
+// range options tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestBTree_RangeOpt(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	keys := make([][]byte, 0)
+	for i := 0; i < 300; i += 2 {
+		key := []byte(fmt.Sprintf("%04d", i))
+		err = b.Put(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	r := rand.New(rand.NewSource(3))
+	for n := 0; n < 500; n++ {
+		// bounds fall on keys and between keys
+		start := []byte(fmt.Sprintf("%04d", r.Intn(310)))
+		end := []byte(fmt.Sprintf("%04d", r.Intn(310)))
+		if r.Intn(10) == 0 {
+			start = nil
+		}
+		if r.Intn(10) == 0 {
+			end = nil
+		}
+
+		opts := &RangeOptions{IncludeStart: r.Intn(2) == 0, IncludeEnd: r.Intn(2) == 0, Limit: r.Intn(5) * r.Intn(10), Reverse: r.Intn(2) == 0}
+
+		want := make([][]byte, 0)
+		for _, key := range keys {
+			if start != nil && (bytes.Compare(key, start) < 0 || (!opts.IncludeStart && bytes.Equal(key, start))) {
+				continue
+			}
+			if end != nil && (bytes.Compare(key, end) > 0 || (!opts.IncludeEnd && bytes.Equal(key, end))) {
+				continue
+			}
+			want = append(want, key)
+		}
+		if opts.Reverse {
+			slices.Reverse(want)
+		}
+		if opts.Limit > 0 && len(want) > opts.Limit {
+			want = want[:opts.Limit]
+		}
+
+		got, err := b.RangeOpt(start, end, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != len(want) {
+			t.Fatalf("range %s %s %+v: expected %d keys, got %d", start, end, opts, len(want), len(got))
+		}
+
+		for i := range want {
+			if !bytes.Equal(got[i].K, want[i]) {
+				t.Fatalf("range %s %s %+v: expected %s at %d, got %s", start, end, opts, want[i], i, got[i].K)
+			}
+		}
+
+		count, err := b.RangeCount(start, end, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != len(want) {
+			t.Fatalf("expected a count of %d, got %d", len(want), count)
+		}
+	}
+}
+
+func TestBTree_RangeOpt_Defaults(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("%04d", i))
+		err = b.Put(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.Delete([]byte("0012"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nil options behave like Range
+	keys, err := b.RangeOpt([]byte("0010"), []byte("0020"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := b.Range([]byte("0010"), []byte("0020"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != len(expected) || len(keys) != 10 {
+		t.Fatalf("expected %d keys, got %d", len(expected), len(keys))
+	}
+
+	for i := range keys {
+		if !bytes.Equal(keys[i].K, expected[i].(*Key).K) {
+			t.Fatalf("expected %s, got %s", expected[i].(*Key).K, keys[i].K)
+		}
+	}
+}
+
+func TestBTree_RangeOpt_Limit(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	b, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%04d", i))
+		err = b.Put(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.ResetStats()
+
+	keys, err := b.RangeOpt(nil, nil, &RangeOptions{Limit: 3, Reverse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 3 || string(keys[0].K) != "0999" || string(keys[2].K) != "0997" {
+		t.Fatalf("expected the last 3 keys, got %d", len(keys))
+	}
+
+	// the scan stops at the limit instead of reading the whole tree
+	if b.Stats().PagesRead > 20 {
+		t.Fatalf("expected few pages read, got %d", b.Stats().PagesRead)
+	}
+}
+
+func TestBTree_RangeFilter(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 200; i++ {
+		value := []byte(fmt.Sprintf("small%d", i))
+		if i%3 == 0 {
+			value = bytes.Repeat([]byte{'x'}, 100+i)
+		}
+
+		err = b.Put([]byte(fmt.Sprintf("%04d", i)), value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	calls := 0
+	keys, err := b.RangeFilter([]byte("0010"), []byte("0100"), func(k []byte, vs [][]byte) bool {
+		calls++
+		// values stored in the value log are resolved before filtering
+		return len(vs[0]) >= 100
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 91 {
+		t.Fatalf("expected the filter to be called for 91 keys, got %d", calls)
+	}
+
+	if len(keys) != 30 {
+		t.Fatalf("expected 30 keys, got %d", len(keys))
+	}
+
+	for _, key := range keys {
+		i := 0
+		fmt.Sscanf(string(key.K), "%04d", &i)
+		if i%3 != 0 || len(key.V[0]) != 100+i {
+			t.Fatalf("unexpected key %s", key.K)
+		}
+	}
+}
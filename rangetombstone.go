@@ -0,0 +1,275 @@
+// Package btree
+// range tombstones
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// keyInterval is the keys from start (inclusive) to end (exclusive)
+type keyInterval struct {
+	start []byte
+	end   []byte
+}
+
+// rangeTombstones are the key ranges deleted by TombstoneRange whose keys were not purged yet
+// Keys within a range are marked with a tombstone when their node is decoded, so reads skip them and writes start them over.
+// A key written after its range was deleted is cut out of the range when its node is written.
+type rangeTombstones struct {
+	lock      sync.RWMutex
+	path      string        // file the ranges are kept in, empty for in-memory btrees
+	ranges    []keyInterval // sorted and disjoint
+	committed []keyInterval // the ranges as of the last successful operation
+}
+
+// openRangeTombstones reads the range tombstones of a btree, nil if it has none
+// The file holds the ranges in order, every bound as a uvarint length followed by the bytes.
+func openRangeTombstones(path string) (*rangeTombstones, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	t := &rangeTombstones{path: path}
+	for len(data) > 0 {
+		var bounds [2][]byte
+		for i := range bounds {
+			n, size := binary.Uvarint(data)
+			if size <= 0 || uint64(len(data)-size) < n {
+				return nil, errors.New(path + ": truncated range")
+			}
+
+			bounds[i] = data[size : size+int(n)]
+			data = data[size+int(n):]
+		}
+
+		t.ranges = append(t.ranges, keyInterval{start: bounds[0], end: bounds[1]})
+	}
+
+	t.committed = t.ranges
+
+	return t, nil
+}
+
+// find returns the index of the range holding k, -1 if k is not deleted, the caller holds the lock
+func (t *rangeTombstones) find(k []byte) int {
+	i := sort.Search(len(t.ranges), func(i int) bool {
+		return bytes.Compare(t.ranges[i].end, k) > 0
+	})
+
+	if i < len(t.ranges) && bytes.Compare(t.ranges[i].start, k) <= 0 {
+		return i
+	}
+
+	return -1
+}
+
+// add deletes the keys within r merging it with the ranges it overlaps or touches
+func (t *rangeTombstones) add(r keyInterval) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ranges := make([]keyInterval, 0, len(t.ranges)+1)
+	for _, other := range t.ranges {
+		switch {
+		case bytes.Compare(other.end, r.start) < 0:
+			ranges = append(ranges, other)
+		case bytes.Compare(r.end, other.start) < 0:
+			ranges = append(ranges, r)
+			r = other
+		default:
+			if bytes.Compare(other.start, r.start) < 0 {
+				r.start = other.start
+			}
+			if bytes.Compare(other.end, r.end) > 0 {
+				r.end = other.end
+			}
+		}
+	}
+
+	t.ranges = append(ranges, r)
+}
+
+// mark marks the keys of n within a range with a tombstone
+func (t *rangeTombstones) mark(n *Node) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	for _, k := range n.Keys {
+		if k != nil && !k.D && t.find(k.K) >= 0 {
+			k.D = true
+		}
+	}
+}
+
+// punch cuts the keys of n written since their range was deleted out of their range
+func (t *rangeTombstones) punch(n *Node) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, k := range n.Keys {
+		if k == nil || k.D {
+			continue
+		}
+
+		i := t.find(k.K)
+		if i < 0 {
+			continue
+		}
+
+		r := t.ranges[i]
+		holes := make([]keyInterval, 0, 2)
+		if bytes.Compare(r.start, k.K) < 0 {
+			holes = append(holes, keyInterval{start: r.start, end: k.K})
+		}
+
+		// the key after k.K is k.K followed by a zero byte
+		after := append(slices.Clip(bytes.Clone(k.K)), 0)
+		if bytes.Compare(after, r.end) < 0 {
+			holes = append(holes, keyInterval{start: after, end: r.end})
+		}
+
+		t.ranges = slices.Replace(slices.Clip(t.ranges), i, i+1, holes...)
+	}
+}
+
+// settle keeps the ranges changed by an operation or goes back to the ranges of the last successful operation if it failed with err
+func (t *rangeTombstones) settle(err error) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if err != nil {
+		t.ranges = t.committed
+		return err
+	}
+
+	if t.path != "" && !slices.EqualFunc(t.ranges, t.committed, func(a, b keyInterval) bool {
+		return bytes.Equal(a.start, b.start) && bytes.Equal(a.end, b.end)
+	}) {
+		data := encodeRanges(t.ranges)
+
+		// the ranges are replaced as a whole so a crash leaves the old or the new ranges
+		err = os.WriteFile(t.path+".tmp", data, 0644)
+		if err == nil {
+			err = os.Rename(t.path+".tmp", t.path)
+		}
+		if err != nil {
+			t.ranges = t.committed
+			return err
+		}
+	}
+
+	t.committed = t.ranges
+
+	return nil
+}
+
+// TombstoneRange deletes every key within [start, end] without touching the tree, the keys are hidden from reads right away
+// The range is kept in a small file next to the btree (btree.db.rangedel) and its keys are purged by PurgeTombstones or OptimizeLayout.
+// A key put into the range afterwards starts over without its old values.  Not supported with Options.ConcurrentWriters.
+func (b *BTree) TombstoneRange(start, end []byte) error {
+	start, end = b.transformKey(start), b.transformKey(end)
+
+	if bytes.Compare(start, end) > 0 {
+		return errors.New("start must not be greater than end")
+	}
+
+	if b.latches != nil {
+		return errors.New("range tombstones are not supported with concurrent writers")
+	}
+
+	return b.atomic(func() error {
+		if b.rangeDels == nil {
+			b.rangeDels = &rangeTombstones{}
+			if b.name != "" {
+				b.rangeDels.path = b.name + ".rangedel"
+			}
+		}
+
+		// nodes decoded before the range was deleted must not be written back
+		b.replaced()
+
+		b.rangeDels.add(keyInterval{start: bytes.Clone(start), end: append(bytes.Clone(end), 0)})
+
+		return nil
+	})
+}
+
+// markRangeTombstones marks the keys of a decoded node which are within a deleted range
+func (b *BTree) markRangeTombstones(n *Node) {
+	if b.rangeDels != nil && n != nil {
+		b.rangeDels.mark(n)
+	}
+}
+
+// settleRangeTombstones keeps the range tombstones changed by an operation or discards the changes of an operation which failed with err
+func (b *BTree) settleRangeTombstones(err error) error {
+	if b.rangeDels == nil {
+		return err
+	}
+	return b.rangeDels.settle(err)
+}
+
+// clearRangeTombstones drops every range once their keys were purged
+func (b *BTree) clearRangeTombstones() error {
+	if b.rangeDels == nil {
+		return nil
+	}
+
+	return b.atomic(func() error {
+		b.rangeDels.lock.Lock()
+		b.rangeDels.ranges = nil
+		b.rangeDels.lock.Unlock()
+		return nil
+	})
+}
+
+// encodeRanges encodes ranges in the format of the .rangedel file
+func encodeRanges(ranges []keyInterval) []byte {
+	data := make([]byte, 0)
+	for _, r := range ranges {
+		data = binary.AppendUvarint(data, uint64(len(r.start)))
+		data = append(data, r.start...)
+		data = binary.AppendUvarint(data, uint64(len(r.end)))
+		data = append(data, r.end...)
+	}
+
+	return data
+}
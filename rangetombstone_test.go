@@ -0,0 +1,222 @@
+// Package btree
+// range tombstones tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_TombstoneRange(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.rangedel")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.TombstoneRange([]byte("020"), []byte("059"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.TombstoneRange([]byte("050"), []byte("069"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"020", "045", "069"} {
+		key, err := btree.Get([]byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key != nil {
+			t.Fatalf("expected %s to be hidden", k)
+		}
+	}
+
+	keys, err := btree.Range([]byte("000"), []byte("099"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 50 {
+		t.Fatalf("expected 50 visible keys, got %d", len(keys))
+	}
+
+	// putting a deleted key brings it back with only the new value
+	err = btree.Put([]byte("030"), []byte("again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("030"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || len(key.V) != 1 || string(key.V[0]) != "again" {
+		t.Fatalf("expected only the new value, got %v", key)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	visible, err := btree.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(visible) != 51 {
+		t.Fatalf("expected the ranges to survive a reopen with 51 visible keys, got %d", len(visible))
+	}
+
+	purged, err := btree.PurgeTombstones()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 49 {
+		t.Fatalf("expected 49 purged keys, got %d", purged)
+	}
+
+	raw, err := btree.rawKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(raw) != 51 {
+		t.Fatalf("expected the purged keys to be removed, got %d keys", len(raw))
+	}
+
+	if len(btree.rangeDels.ranges) != 0 {
+		t.Fatalf("expected the ranges to be dropped, got %d", len(btree.rangeDels.ranges))
+	}
+
+	// keys put after the purge are visible
+	err = btree.Put([]byte("040"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err = btree.Get([]byte("040"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil {
+		t.Fatal("expected a key put after the purge to be visible")
+	}
+
+	checkBTree(t, btree)
+}
+
+func TestRangeTombstones(t *testing.T) {
+	ranges := &rangeTombstones{}
+	ranges.add(keyInterval{start: []byte("c"), end: []byte("e")})
+	ranges.add(keyInterval{start: []byte("a"), end: []byte("b")})
+	ranges.add(keyInterval{start: []byte("b"), end: []byte("c")})
+	ranges.add(keyInterval{start: []byte("x"), end: []byte("z")})
+
+	if len(ranges.ranges) != 2 || string(ranges.ranges[0].start) != "a" || string(ranges.ranges[0].end) != "e" {
+		t.Fatalf("expected touching ranges to merge, got %v", ranges.ranges)
+	}
+
+	n := &Node{Keys: []*Key{{K: []byte("c")}}}
+	ranges.punch(n)
+
+	if ranges.find([]byte("c")) >= 0 || ranges.find([]byte("b")) < 0 || ranges.find([]byte("c\x00")) < 0 {
+		t.Fatalf("expected c to be cut out of its range, got %v", ranges.ranges)
+	}
+
+	if ranges.find([]byte("e")) >= 0 || ranges.find([]byte("y")) < 0 {
+		t.Fatal("expected range ends to be exclusive")
+	}
+}
+
+func TestBTree_TombstoneRange_Remove(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 50; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.TombstoneRange([]byte("010"), []byte("019"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := 0
+	cancel := btree.Subscribe(nil, nil, func(c Change) {
+		changes++
+	})
+	defer cancel()
+
+	// a key inside the deleted range is absent, removing its value changes nothing
+	removed, err := btree.RemoveCount([]byte("015"), []byte("value"))
+	if !errors.Is(err, ErrKeyNotFound) || removed != 0 {
+		t.Fatalf("expected ErrKeyNotFound, got %d, %v", removed, err)
+	}
+
+	if changes != 0 {
+		t.Fatalf("expected no change event, got %d", changes)
+	}
+}
@@ -0,0 +1,128 @@
+// Package btree
+// read-through cache
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Loader loads the value of a key missing from a ReadThrough cache
+type Loader func(key []byte) ([]byte, error)
+
+// ReadThrough caches the values of a loader in a btree, each loaded value expires after a ttl
+// Concurrent misses of the same key share a single call to the loader.
+type ReadThrough struct {
+	b     *BTree
+	load  Loader
+	ttl   time.Duration
+	lock  sync.Mutex              // guards loads
+	loads map[string]*pendingLoad // the loads in flight by key
+}
+
+// pendingLoad is a call to the loader which misses of the same key wait for
+type pendingLoad struct {
+	done  chan struct{} // closed once value and err are set
+	value []byte
+	err   error
+}
+
+// ReadThrough returns a cache of the values of load in this btree, loaded values expire after ttl
+func (b *BTree) ReadThrough(ttl time.Duration, load Loader) (*ReadThrough, error) {
+	if ttl <= 0 {
+		return nil, errors.New("ttl must be greater than 0")
+	}
+
+	return &ReadThrough{b: b, load: load, ttl: ttl, loads: make(map[string]*pendingLoad)}, nil
+}
+
+// Get returns the cached value of a key, a key which is missing or expired is loaded and put with the ttl of the cache
+// Loader errors are returned to every caller waiting for the load and are not cached.
+func (r *ReadThrough) Get(key []byte) ([]byte, error) {
+	k, err := r.b.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if k != nil && len(k.V) > 0 {
+		return k.V[len(k.V)-1], nil
+	}
+
+	r.lock.Lock()
+	l, ok := r.loads[string(key)]
+	if ok {
+		r.lock.Unlock()
+		<-l.done
+		return l.value, l.err
+	}
+
+	l = &pendingLoad{done: make(chan struct{})}
+	r.loads[string(key)] = l
+	r.lock.Unlock()
+
+	l.value, l.err = r.fill(key)
+
+	// the load is only forgotten once the value is in the btree so later misses find it there
+	r.lock.Lock()
+	delete(r.loads, string(key))
+	r.lock.Unlock()
+	close(l.done)
+
+	return l.value, l.err
+}
+
+// fill loads a key and puts its value with the ttl of the cache
+func (r *ReadThrough) fill(key []byte) ([]byte, error) {
+	value, err := r.load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// an expired key still holds its old values, it is deleted so the value is not appended to them
+	err = r.b.Delete(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.b.PutWithTTL(key, value, r.ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Invalidate deletes the cached value of a key so the next Get loads it again
+func (r *ReadThrough) Invalidate(key []byte) error {
+	return r.b.Delete(key)
+}
@@ -0,0 +1,160 @@
+// Package btree
+// read-through cache tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadThrough(t *testing.T) {
+	defer os.Remove("readthrough.db")
+	defer os.Remove("readthrough.db.del")
+	defer os.Remove("readthrough.db.ttl")
+	defer os.Remove("readthrough.db.ttl.del")
+
+	btree, err := Open("readthrough.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	_, err = btree.ReadThrough(0, nil)
+	if err == nil {
+		t.Fatal("expected an error for a ttl of 0")
+	}
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	errFailed := errors.New("failed")
+
+	cache, err := btree.ReadThrough(time.Millisecond*100, func(key []byte) ([]byte, error) {
+		calls.Add(1)
+		<-release
+		if string(key) == "bad" {
+			return nil, errFailed
+		}
+		return append([]byte("value of "), key...), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent misses of the same key share one load
+	var wg sync.WaitGroup
+	values := make([][]byte, 8)
+	errs := make([]error, 8)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = cache.Get([]byte("key"))
+		}(i)
+	}
+
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 20)
+	close(release)
+	wg.Wait()
+
+	for i := range values {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if string(values[i]) != "value of key" {
+			t.Fatalf("expected the loaded value, got %q", values[i])
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 load, got %d", calls.Load())
+	}
+
+	// hits do not call the loader
+	value, err := cache.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "value of key" || calls.Load() != 1 {
+		t.Fatalf("expected a hit, got %q after %d loads", value, calls.Load())
+	}
+
+	// errors are not cached
+	for i := 0; i < 2; i++ {
+		_, err = cache.Get([]byte("bad"))
+		if !errors.Is(err, errFailed) {
+			t.Fatalf("expected the loader error, got %v", err)
+		}
+	}
+
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 loads, got %d", calls.Load())
+	}
+
+	// an expired key is loaded again and holds only the new value
+	time.Sleep(time.Millisecond * 150)
+
+	value, err = cache.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "value of key" || calls.Load() != 4 {
+		t.Fatalf("expected a reload, got %q after %d loads", value, calls.Load())
+	}
+
+	key, err := btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil || len(key.V) != 1 {
+		t.Fatalf("expected a single cached value, got %v", key)
+	}
+
+	err = cache.Invalidate([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cache.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 5 {
+		t.Fatalf("expected a load after invalidating, got %d loads", calls.Load())
+	}
+}
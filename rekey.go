@@ -0,0 +1,123 @@
+// Package btree
+// renaming keys
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "errors"
+
+// ErrKeyExists is returned when renaming a key to a key which already exists
+var ErrKeyExists = errors.New("key already exists")
+
+// ReKey moves every value of oldKey to newKey in a single atomic write and deletes oldKey
+// Values keep their metadata, versions and value log pointers, the expiry of the key is kept.
+// ErrKeyNotFound is returned if oldKey does not exist, ErrKeyExists if newKey does.  Renaming a key to itself does nothing.
+func (b *BTree) ReKey(oldKey, newKey []byte) error {
+	oldKey, newKey = b.transformKey(oldKey), b.transformKey(newKey)
+
+	err := b.checkKeySize(newKey)
+	if err != nil {
+		return err
+	}
+
+	var moved *Key
+
+	err = b.atomic(func() error {
+		root, err := b.getRoot()
+		if err != nil {
+			return err
+		}
+
+		key, err := b.lookupKey(root, oldKey)
+		if err != nil {
+			return err
+		}
+
+		if key == nil || key.hidden() {
+			return ErrKeyNotFound
+		}
+
+		if equal(oldKey, newKey) {
+			return nil
+		}
+
+		existing, err := b.lookupKey(root, newKey)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil && !existing.hidden() {
+			return ErrKeyExists
+		}
+
+		// a deleted or expired key still in the tree makes way for the new key
+		if existing != nil {
+			_, err = b.delete(newKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		moved = &Key{K: newKey, V: key.V, Ptr: key.Ptr, E: key.E, M: key.M, Ver: key.Ver, R: key.R, F: key.F, W: key.W}
+		b.keyChanged(moved)
+
+		// the bitmap page moves with the key instead of being freed with the old key
+		moved.B, err = b.detachBitmap(oldKey)
+		if err != nil {
+			return err
+		}
+
+		_, err = b.delete(oldKey)
+		if err != nil {
+			return err
+		}
+
+		// the new key does not exist so the moved key is added as is
+		return b.insertFromRoot(moved)
+	})
+	if err != nil || moved == nil || moved.E == 0 {
+		return err
+	}
+
+	// Sweep finds the key under its new name, the entry of the old name is stale
+	if b.expiry == nil {
+		err = b.openExpiryIndex()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = b.expiry.Put(expiryIndexKey(moved.E, newKey), newKey)
+	if err != nil {
+		return err
+	}
+
+	return b.expiry.Delete(expiryIndexKey(moved.E, oldKey))
+}
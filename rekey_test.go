@@ -0,0 +1,175 @@
+// Package btree
+// renaming keys tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBTree_ReKey(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("%04d", i))
+		err = b.Put(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.Put([]byte("0100"), []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.ReKey([]byte("0100"), []byte("1100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := b.Get([]byte("0100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k != nil {
+		t.Fatal("expected the old key to be gone")
+	}
+
+	k, err = b.Get([]byte("1100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k == nil || len(k.V) != 2 || string(k.V[0]) != "0100" || string(k.V[1]) != "second" {
+		t.Fatal("expected the values under the new key")
+	}
+
+	err = b.ReKey([]byte("0100"), []byte("2100"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	err = b.ReKey([]byte("0001"), []byte("0002"))
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	err = b.ReKey([]byte("0001"), []byte("0001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// move every key past the others
+	for i := 0; i < 200; i++ {
+		if i == 100 {
+			continue
+		}
+
+		err = b.ReKey([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("%04d", i+5000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkBTree(t, b)
+
+	for i := 0; i < 200; i++ {
+		if i == 100 {
+			continue
+		}
+
+		k, err := b.Get([]byte(fmt.Sprintf("%04d", i+5000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if k == nil || !bytes.Equal(k.V[0], []byte(fmt.Sprintf("%04d", i))) {
+			t.Fatalf("expected key %d to be moved", i)
+		}
+	}
+}
+
+func TestBTree_ReKey_ValueLogAndTTL(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+	defer os.Remove("btree.db.ttl")
+	defer os.Remove("btree.db.ttl.del")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	large := bytes.Repeat([]byte("v"), 100)
+	err = b.PutWithTTL([]byte("session"), large, time.Millisecond*50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.ReKey([]byte("session"), []byte("renamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := b.Get([]byte("renamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k == nil || !bytes.Equal(k.V[0], large) || k.E == 0 {
+		t.Fatal("expected the value and expiry under the new key")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	// the renamed key is found through the expiry index
+	deleted, err := b.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 1 {
+		t.Fatalf("expected 1 key swept, got %d", deleted)
+	}
+}
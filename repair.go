@@ -0,0 +1,250 @@
+// Package btree
+// page repair from a replica
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RepairFrom replaces damaged pages with the healthy copy of the same page in other, a mirror or backup of this file, and returns the repaired page ids
+// Detection is heuristic, pages carry no checksum.  A page is damaged when its header does not parse or points past the file, when the overflow chain it starts is broken
+// or when the data of the chain is not a single msgpack map followed by padding.  Compressed nodes are only checked up to their marker, nodes of other codecs up to their length.
+// Damage which leaves a node that still decodes, such as a changed byte within a key or value, is not detected and such a page is not repaired,
+// a page which differs from other is not taken as damaged as it may have been written after other was copied.
+// A damaged chain is replaced by the chain of other as a whole.  Pages which are damaged in both files are left alone and reported in an error wrapping ErrCorrupt.
+func (p *Pager) RepairFrom(other *Pager) ([]int64, error) {
+	if p.closed.Load() || other.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	if p == other {
+		return nil, errors.New("cannot repair a pager from itself")
+	}
+
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	count := min(p.count.Load(), other.count.Load())
+	local, err := scanPages(p, count, p.deletedPages)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := scanPages(other, count, other.GetDeletedPages())
+	if err != nil {
+		return nil, err
+	}
+
+	damaged := make(map[int64]bool)
+	for pageID, page := range local.pages {
+		switch {
+		case page.deleted:
+		case page.damaged:
+			damaged[int64(pageID)] = true
+		case page.header == "" && remote.pages[pageID].header != "":
+			// a page never written here is damaged if other holds data for it
+			damaged[int64(pageID)] = true
+		}
+	}
+
+	for head := range local.heads {
+		_, err := local.chain(head)
+		if err != nil {
+			damaged[head] = true
+		}
+	}
+
+	repaired := make([]int64, 0)
+	failed := make([]int64, 0)
+
+	for pageID := int64(0); pageID < count; pageID++ {
+		if !damaged[pageID] {
+			continue
+		}
+
+		// the whole chain of other replaces the damaged page, the chain of other is checked first
+		pages := []int64{pageID}
+		if remote.pages[pageID].damaged {
+			failed = append(failed, pageID)
+			continue
+		}
+
+		if remote.heads[pageID] {
+			pages, err = remote.chain(pageID)
+			if err != nil {
+				failed = append(failed, pageID)
+				continue
+			}
+		}
+
+		for _, page := range pages {
+			if slices.Contains(repaired, page) || bytes.Equal(local.pages[page].raw, remote.pages[page].raw) {
+				continue
+			}
+
+			_, err = p.file.WriteAt(remote.pages[page].raw, page*(PAGE_SIZE+HEADER_SIZE))
+			if err != nil {
+				return repaired, err
+			}
+
+			p.epochs.touch(page)
+			p.pinned.invalidate(page)
+			repaired = append(repaired, page)
+		}
+	}
+
+	slices.Sort(repaired)
+
+	if len(repaired) > 0 {
+		err = p.file.Sync()
+		if err != nil {
+			return repaired, err
+		}
+	}
+
+	if len(failed) > 0 {
+		return repaired, fmt.Errorf("%w: pages %v are damaged in both files", ErrCorrupt, failed)
+	}
+
+	return repaired, nil
+}
+
+// scannedPage is a page read by scanPages
+type scannedPage struct {
+	raw     []byte // header and data of the page
+	header  string // header without padding
+	next    int64  // next page of the chain, -1 if the chain ends here
+	damaged bool   // true if the header does not parse or points past the scanned pages
+	deleted bool   // true if the page is deleted, its content is stale
+}
+
+// scannedPages are the pages of a file and the chains starting at them
+type scannedPages struct {
+	pages []scannedPage  // every page
+	heads map[int64]bool // live pages which start a chain, pages no other live page links to
+}
+
+// scanPages reads the first count pages of a pager and parses their headers, links from deleted pages are ignored
+func scanPages(p *Pager, count int64, deleted []int64) (*scannedPages, error) {
+	s := &scannedPages{pages: make([]scannedPage, count), heads: make(map[int64]bool)}
+	linked := make(map[int64]bool)
+
+	for pageID := int64(0); pageID < count; pageID++ {
+		raw, err := p.readPage(pageID)
+		if errors.Is(err, ErrPageNotFound) {
+			s.pages[pageID] = scannedPage{raw: make([]byte, PAGE_SIZE+HEADER_SIZE), next: -1}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		page := scannedPage{raw: raw, header: string(bytes.Trim(raw[:HEADER_SIZE], "\x00")), next: -1, deleted: slices.Contains(deleted, pageID)}
+
+		if page.header != "" && page.header != "-1" {
+			next, err := strconv.ParseInt(strings.TrimPrefix(page.header, OVERFLOW_MARKER), 10, 64)
+			if err != nil || next < 0 || next >= count || next == pageID {
+				page.damaged = true
+			} else {
+				page.next = next
+				if !page.deleted {
+					linked[next] = true
+				}
+			}
+		}
+
+		s.pages[pageID] = page
+	}
+
+	for pageID, page := range s.pages {
+		if page.header != "" && !page.damaged && !page.deleted && !linked[int64(pageID)] {
+			s.heads[int64(pageID)] = true
+		}
+	}
+
+	return s, nil
+}
+
+// chain returns the pages of the chain starting at head and fails if the chain is broken or its data is not a single msgpack map
+func (s *scannedPages) chain(head int64) ([]int64, error) {
+	pages := []int64{head}
+	data := append([]byte(nil), s.pages[head].raw[HEADER_SIZE:]...)
+
+	for next := s.pages[head].next; next != -1; next = s.pages[next].next {
+		if len(pages) > len(s.pages) {
+			return nil, fmt.Errorf("page %d: overflow chain loops", head)
+		}
+
+		if s.pages[next].damaged || s.pages[next].header == "" || strings.HasPrefix(s.pages[next].header, OVERFLOW_MARKER) {
+			return nil, fmt.Errorf("page %d: broken overflow chain at page %d", head, next)
+		}
+
+		pages = append(pages, next)
+		data = append(data, s.pages[next].raw[HEADER_SIZE:]...)
+	}
+
+	// compressed nodes cannot be decoded without the dictionary of the btree
+	if data[0] == compressedNode {
+		return pages, nil
+	}
+
+	// a node of another codec is stored behind its length, only the length is checked
+	if len(data) >= NODE_CODEC_LENGTH_SIZE && data[0] == 0 {
+		length := int(binary.BigEndian.Uint32(data))
+		if length == 0 || length > len(data)-NODE_CODEC_LENGTH_SIZE || len(bytes.Trim(data[NODE_CODEC_LENGTH_SIZE+length:], "\x00")) > 0 {
+			return nil, fmt.Errorf("page %d: data is not a node behind its length followed by padding", head)
+		}
+		return pages, nil
+	}
+
+	// a node in the fixed key size encoding is a msgpack map behind its marker
+	if data[0] == fixedKeyNode {
+		data = data[1:]
+	}
+
+	if data[0]&0xf0 != 0x80 && data[0] != 0xde && data[0] != 0xdf {
+		return nil, fmt.Errorf("page %d: data is not a msgpack map", head)
+	}
+
+	r := &viewReader{data: data}
+	err := r.skip()
+	if err != nil || len(bytes.Trim(data[r.pos:], "\x00")) > 0 {
+		return nil, fmt.Errorf("page %d: data is not a msgpack map followed by padding", head)
+	}
+
+	return pages, nil
+}
@@ -0,0 +1,249 @@
+// Package btree
+// page repair tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeRepairTree writes a btree with overflowing nodes to name
+func writeRepairTree(t *testing.T, name string) {
+	b, err := Open(name, os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), bytes.Repeat([]byte{'a' + byte(i%26)}, 300))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// corruptPage overwrites part of a page of the file at name
+func corruptPage(t *testing.T, name string, pageID int64, offset int, data []byte) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteAt(data, pageID*(PAGE_SIZE+HEADER_SIZE)+int64(offset))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPager_RepairFrom(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("mirror.db")
+	defer os.Remove("mirror.db.del")
+
+	writeRepairTree(t, "btree.db")
+
+	data, err := os.ReadFile("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile("mirror.db", data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := int64(len(data) / (PAGE_SIZE + HEADER_SIZE))
+
+	// a zeroed root, an overflow page with a garbled header and a header pointing past the file
+	overflow := int64(-1)
+	for pageID := int64(1); pageID < pages; pageID++ {
+		header, _ := readPageHeader(data, pageID)
+		if len(header) > 1 && header[0] == 'c' {
+			fmt.Sscanf(header[1:], "%d", &overflow)
+			break
+		}
+	}
+
+	if overflow == -1 {
+		t.Fatal("expected an overflow chain")
+	}
+
+	corruptPage(t, "btree.db", 0, HEADER_SIZE, make([]byte, PAGE_SIZE))
+	corruptPage(t, "btree.db", overflow, 0, []byte("x9\x00"))
+	corruptPage(t, "btree.db", pages-1, 0, []byte("c99999\x00"))
+
+	pager, err := OpenPager("btree.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	mirror, err := OpenPager("mirror.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mirror.Close()
+
+	repaired, err := pager.RepairFrom(mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repaired) != 3 || repaired[0] != 0 {
+		t.Fatalf("expected 3 repaired pages, got %v", repaired)
+	}
+
+	fixed, err := os.ReadFile("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(fixed, data) {
+		t.Fatal("expected the file to match the mirror")
+	}
+
+	// a healthy file needs no repair
+	repaired, err = pager.RepairFrom(mirror)
+	if err != nil || len(repaired) != 0 {
+		t.Fatalf("expected nothing to repair, got %v %v", repaired, err)
+	}
+
+	b, err := OpenWithStorage(pager, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := b.Get([]byte("025"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || !reflect.DeepEqual(key.V[0], bytes.Repeat([]byte{'z'}, 300)) {
+		t.Fatalf("expected the value of 025, got %v", key)
+	}
+}
+
+func TestPager_RepairFrom_BothDamaged(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("mirror.db")
+	defer os.Remove("mirror.db.del")
+
+	writeRepairTree(t, "btree.db")
+	writeRepairTree(t, "mirror.db")
+
+	corruptPage(t, "btree.db", 0, 0, []byte("garbage\x00"))
+	corruptPage(t, "mirror.db", 0, 0, []byte("garbage\x00"))
+
+	pager, err := OpenPager("btree.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	mirror, err := OpenPager("mirror.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mirror.Close()
+
+	repaired, err := pager.RepairFrom(mirror)
+	if !errors.Is(err, ErrCorrupt) || len(repaired) != 0 {
+		t.Fatalf("expected ErrCorrupt and no repairs, got %v %v", repaired, err)
+	}
+}
+
+// readPageHeader returns the header of a page of a file read into data
+func readPageHeader(data []byte, pageID int64) (string, error) {
+	offset := pageID * (PAGE_SIZE + HEADER_SIZE)
+	if offset+HEADER_SIZE > int64(len(data)) {
+		return "", errors.New("page out of range")
+	}
+	return string(bytes.Trim(data[offset:offset+HEADER_SIZE], "\x00")), nil
+}
+
+func TestPager_RepairFrom_Undetected(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("mirror.db")
+	defer os.Remove("mirror.db.del")
+
+	writeRepairTree(t, "btree.db")
+	writeRepairTree(t, "mirror.db")
+
+	data, err := os.ReadFile("btree.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := bytes.Index(data, bytes.Repeat([]byte{'z'}, 100))
+	if offset == -1 {
+		t.Fatal("expected the value of 025 in the file")
+	}
+
+	// a changed byte within a value leaves a node which decodes
+	corruptPage(t, "btree.db", 0, offset, []byte{'y'})
+
+	pager, err := OpenPager("btree.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	mirror, err := OpenPager("mirror.db", os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mirror.Close()
+
+	repaired, err := pager.RepairFrom(mirror)
+	if err != nil || len(repaired) != 0 {
+		t.Fatalf("expected the damage to go unnoticed, got %v %v", repaired, err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package btree
+// transient i/o retries
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Typed i/o failures, returned wrapped in an IOError and matched with errors.Is
+var (
+	ErrDiskFull         = errors.New("disk full")                        // ENOSPC or EDQUOT, free space and retry
+	ErrPermissionDenied = errors.New("permission denied")                // EACCES, EPERM or EROFS
+	ErrDeviceIO         = errors.New("device i/o error")                 // EIO, the device or file system is failing
+	ErrRetriesExhausted = errors.New("transient i/o error kept failing") // EINTR, EAGAIN or short writes outlasted every retry
+)
+
+// IOError describes a read, write, sync or truncate which failed after retrying transient errors
+type IOError struct {
+	Op       string // The operation, read, write, sync or truncate
+	Offset   int64  // The offset of the read or write
+	Attempts int    // The number of attempts made
+	Kind     error  // One of the typed failures, nil if the failure is not classified
+	Err      error  // The underlying error
+}
+
+// Error returns the error message
+func (e *IOError) Error() string {
+	if e.Kind != nil {
+		return fmt.Sprintf("%s at offset %d failed after %d attempts: %s: %s", e.Op, e.Offset, e.Attempts, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s at offset %d failed after %d attempts: %s", e.Op, e.Offset, e.Attempts, e.Err)
+}
+
+// Unwrap returns the typed failure and the underlying error
+func (e *IOError) Unwrap() []error {
+	if e.Kind != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// retryFile wraps a page file retrying transient errors with exponential backoff
+// Failures which remain are returned as an IOError
+type retryFile struct {
+	pageFile
+	attempts int           // attempts per call, at least 1
+	backoff  time.Duration // wait before the second attempt, doubled after every attempt
+}
+
+// newRetryFile wraps file, attempts below 1 make a single attempt
+func newRetryFile(file pageFile, attempts int, backoff time.Duration) *retryFile {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &retryFile{pageFile: file, attempts: attempts, backoff: backoff}
+}
+
+// isTransient returns true for errors which are expected to go away when the call is repeated
+func isTransient(err error) bool {
+	return isAny(err, transientErrors) || errors.Is(err, io.ErrShortWrite)
+}
+
+// isAny returns true if err is one of errs
+func isAny(err error, errs []error) bool {
+	for _, e := range errs {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns the typed failure for err, nil if there is none
+func classify(err error) error {
+	switch {
+	case isAny(err, diskFullErrors):
+		return ErrDiskFull
+	case isAny(err, permissionErrors):
+		return ErrPermissionDenied
+	case isAny(err, deviceErrors):
+		return ErrDeviceIO
+	case isTransient(err):
+		return ErrRetriesExhausted
+	}
+	return nil
+}
+
+// retry calls fn until it succeeds, fails with an error which is not transient or runs out of attempts
+// fn returns the bytes it transferred, later attempts continue after them
+func (f *retryFile) retry(op string, offset int64, size int, transient func(error) bool, fn func(done int) (int, error)) (int, error) {
+	done := 0
+	backoff := f.backoff
+
+	for attempt := 1; ; attempt++ {
+		n, err := fn(done)
+		done += n
+
+		if err == nil && done >= size {
+			return done, nil
+		}
+
+		// a short write without an error is retried for the rest of the buffer
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrIOTimeout) {
+			return done, err
+		}
+
+		if !transient(err) || attempt >= f.attempts {
+			return done, &IOError{Op: op, Offset: offset, Attempts: attempt, Kind: classify(err), Err: err}
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// ReadAt reads len(b) bytes retrying transient errors, io.EOF is returned as is
+func (f *retryFile) ReadAt(b []byte, offset int64) (int, error) {
+	return f.retry("read", offset, len(b), isTransient, func(done int) (int, error) {
+		return f.pageFile.ReadAt(b[done:], offset+int64(done))
+	})
+}
+
+// WriteAt writes b retrying transient errors and continuing short writes
+func (f *retryFile) WriteAt(b []byte, offset int64) (int, error) {
+	return f.retry("write", offset, len(b), isTransient, func(done int) (int, error) {
+		return f.pageFile.WriteAt(b[done:], offset+int64(done))
+	})
+}
+
+// Sync flushes the file, only an interrupted sync is retried as a failed sync may have dropped written pages
+func (f *retryFile) Sync() error {
+	_, err := f.retry("sync", 0, 0, func(err error) bool {
+		return errors.Is(err, errInterrupted)
+	}, func(done int) (int, error) {
+		return 0, f.pageFile.Sync()
+	})
+	return err
+}
+
+// Truncate truncates the file retrying transient errors
+func (f *retryFile) Truncate(size int64) error {
+	_, err := f.retry("truncate", size, 0, isTransient, func(done int) (int, error) {
+		return 0, f.pageFile.Truncate(size)
+	})
+	return err
+}
@@ -0,0 +1,172 @@
+//go:build !plan9
+
+// Package btree
+// transient i/o retries tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyFile is an in-memory file whose reads and writes fail with err a number of times
+// Failed writes write at most short bytes first
+type flakyFile struct {
+	*memFile
+	err      error
+	failures int
+	short    int
+	calls    int
+}
+
+func (f *flakyFile) ReadAt(b []byte, offset int64) (int, error) {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return 0, f.err
+	}
+	return f.memFile.ReadAt(b, offset)
+}
+
+func (f *flakyFile) WriteAt(b []byte, offset int64) (int, error) {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		n, _ := f.memFile.WriteAt(b[:min(f.short, len(b))], offset)
+		return n, f.err
+	}
+	return f.memFile.WriteAt(b, offset)
+}
+
+func (f *flakyFile) Sync() error {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return f.err
+	}
+	return nil
+}
+
+func TestPager_Retry(t *testing.T) {
+	flaky := &flakyFile{memFile: newMemFile()}
+
+	pager, err := newPager(newRetryFile(flaky, 3, time.Microsecond), newMemFile(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	// an interrupted write which wrote part of the page continues after it
+	flaky.err, flaky.failures, flaky.short = syscall.EINTR, 2, 10
+
+	pageID, err := pager.Write([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flaky.err, flaky.failures = syscall.EAGAIN, 2
+
+	data, err := pager.GetPage(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bytes.Trim(data, "\x00"), []byte("Hello World")) {
+		t.Fatalf("expected Hello World, got %s", bytes.Trim(data, "\x00"))
+	}
+
+	// the transient error outlasts every attempt
+	flaky.err, flaky.failures, flaky.calls = syscall.EINTR, 3, 0
+
+	_, err = pager.GetPage(pageID)
+	if !errors.Is(err, ErrRetriesExhausted) || !errors.Is(err, syscall.EINTR) {
+		t.Fatalf("expected ErrRetriesExhausted, got %v", err)
+	}
+
+	var ioErr *IOError
+	if !errors.As(err, &ioErr) || ioErr.Attempts != 3 || ioErr.Op != "read" {
+		t.Fatalf("expected an IOError after 3 attempts, got %v", err)
+	}
+
+	if flaky.calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", flaky.calls)
+	}
+}
+
+func TestPager_Retry_Permanent(t *testing.T) {
+	flaky := &flakyFile{memFile: newMemFile()}
+
+	pager, err := newPager(newRetryFile(flaky, 3, time.Microsecond), newMemFile(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	// permanent errors are not retried
+	for _, tc := range []struct {
+		err  error
+		kind error
+	}{
+		{syscall.ENOSPC, ErrDiskFull},
+		{syscall.EROFS, ErrPermissionDenied},
+		{syscall.EIO, ErrDeviceIO},
+	} {
+		flaky.err, flaky.failures, flaky.calls = tc.err, 3, 0
+
+		_, err = pager.Write([]byte("Hello World"))
+		if !errors.Is(err, tc.kind) || !errors.Is(err, tc.err) {
+			t.Fatalf("expected %v, got %v", tc.kind, err)
+		}
+
+		if flaky.failures != 2 {
+			t.Fatalf("expected a single failed call, got %d", 3-flaky.failures)
+		}
+	}
+
+	// a failed sync is only retried when it was interrupted
+	flaky.err, flaky.failures, flaky.calls = syscall.EAGAIN, 1, 0
+
+	err = pager.Sync()
+	if !errors.Is(err, ErrRetriesExhausted) || flaky.calls != 1 {
+		t.Fatalf("expected the sync to fail without a retry, got %v after %d calls", err, flaky.calls)
+	}
+
+	flaky.err, flaky.failures, flaky.calls = syscall.EINTR, 1, 0
+
+	err = pager.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,412 @@
+// Package btree
+// roaring bitmaps
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// ARRAY_CONTAINER_MAX is the largest number of values a container holds as a sorted array, larger containers hold a bitset
+const ARRAY_CONTAINER_MAX = 4096
+
+// BITSET_CONTAINER_WORDS is the number of 64-bit words of a bitset container, one bit for each of the 65536 values of a container
+const BITSET_CONTAINER_WORDS = 1024
+
+// Bitmap is a compressed set of 32-bit integers in the roaring format
+// Values are grouped by their upper 16 bits into containers, a container holds its lower 16 bits as a sorted array
+// while it has up to ARRAY_CONTAINER_MAX values and as a bitset once it has more.  The zero Bitmap is an empty set.
+type Bitmap struct {
+	Containers []*BitmapContainer // The containers in ascending order of their keys
+}
+
+// BitmapContainer holds the values of a Bitmap sharing their upper 16 bits
+type BitmapContainer struct {
+	K uint16   // The upper 16 bits of the values
+	A []uint16 `codec:",omitempty"` // The lower 16 bits of the values in ascending order, nil for a bitset container
+	B []uint64 `codec:",omitempty"` // The bitset of the lower 16 bits, nil for an array container
+	N int      // The number of values in the container
+}
+
+// NewBitmap returns a bitmap holding values
+func NewBitmap(values ...uint32) *Bitmap {
+	bm := &Bitmap{}
+	for _, v := range values {
+		bm.Add(v)
+	}
+	return bm
+}
+
+// Add adds v to the bitmap and returns true if it was not in the bitmap
+func (bm *Bitmap) Add(v uint32) bool {
+	i, found := bm.container(uint16(v >> 16))
+	if !found {
+		bm.Containers = append(bm.Containers, nil)
+		copy(bm.Containers[i+1:], bm.Containers[i:])
+		bm.Containers[i] = &BitmapContainer{K: uint16(v >> 16)}
+	}
+
+	return bm.Containers[i].add(uint16(v))
+}
+
+// Remove removes v from the bitmap and returns true if it was in the bitmap
+func (bm *Bitmap) Remove(v uint32) bool {
+	i, found := bm.container(uint16(v >> 16))
+	if !found || !bm.Containers[i].remove(uint16(v)) {
+		return false
+	}
+
+	if bm.Containers[i].N == 0 {
+		bm.Containers = append(bm.Containers[:i], bm.Containers[i+1:]...)
+	}
+	return true
+}
+
+// Contains returns true if v is in the bitmap
+func (bm *Bitmap) Contains(v uint32) bool {
+	i, found := bm.container(uint16(v >> 16))
+	return found && bm.Containers[i].contains(uint16(v))
+}
+
+// Cardinality returns the number of values in the bitmap
+func (bm *Bitmap) Cardinality() int {
+	n := 0
+	for _, c := range bm.Containers {
+		n += c.N
+	}
+	return n
+}
+
+// Values returns the values of the bitmap in ascending order
+func (bm *Bitmap) Values() []uint32 {
+	values := make([]uint32, 0, bm.Cardinality())
+	for _, c := range bm.Containers {
+		high := uint32(c.K) << 16
+		c.each(func(low uint16) {
+			values = append(values, high|uint32(low))
+		})
+	}
+	return values
+}
+
+// And returns the values in both bitmaps
+func (bm *Bitmap) And(other *Bitmap) *Bitmap {
+	result := &Bitmap{}
+	for _, c := range bm.Containers {
+		j, found := other.container(c.K)
+		if !found {
+			continue
+		}
+
+		merged := c.combine(other.Containers[j], func(a, b uint64) uint64 { return a & b })
+		if merged.N > 0 {
+			result.Containers = append(result.Containers, merged)
+		}
+	}
+	return result
+}
+
+// Or returns the values in either bitmap
+func (bm *Bitmap) Or(other *Bitmap) *Bitmap {
+	result := &Bitmap{}
+	i, j := 0, 0
+	for i < len(bm.Containers) || j < len(other.Containers) {
+		switch {
+		case j == len(other.Containers) || (i < len(bm.Containers) && bm.Containers[i].K < other.Containers[j].K):
+			result.Containers = append(result.Containers, bm.Containers[i].clone())
+			i++
+		case i == len(bm.Containers) || other.Containers[j].K < bm.Containers[i].K:
+			result.Containers = append(result.Containers, other.Containers[j].clone())
+			j++
+		default:
+			result.Containers = append(result.Containers, bm.Containers[i].combine(other.Containers[j], func(a, b uint64) uint64 { return a | b }))
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// AndNot returns the values of the bitmap which are not in other
+func (bm *Bitmap) AndNot(other *Bitmap) *Bitmap {
+	result := &Bitmap{}
+	for _, c := range bm.Containers {
+		j, found := other.container(c.K)
+		if !found {
+			result.Containers = append(result.Containers, c.clone())
+			continue
+		}
+
+		merged := c.combine(other.Containers[j], func(a, b uint64) uint64 { return a &^ b })
+		if merged.N > 0 {
+			result.Containers = append(result.Containers, merged)
+		}
+	}
+	return result
+}
+
+// Kinds of an encoded container
+const (
+	bitmapArray  = 0 // the container is followed by its values as little endian uint16s
+	bitmapBitset = 1 // the container is followed by its bitset as BITSET_CONTAINER_WORDS little endian uint64s
+)
+
+// MarshalBinary encodes the bitmap in the format it is stored in a page
+// The uvarint number of containers is followed by every container as its little endian uint16 key, its kind, its uvarint number of values and its values or bitset.
+// Every field is fixed width or a uvarint so the bitmap decodes on 32-bit platforms as well.
+func (bm *Bitmap) MarshalBinary() ([]byte, error) {
+	encoded := binary.AppendUvarint(nil, uint64(len(bm.Containers)))
+
+	for _, c := range bm.Containers {
+		encoded = binary.LittleEndian.AppendUint16(encoded, c.K)
+
+		if c.B != nil {
+			encoded = append(encoded, bitmapBitset)
+			encoded = binary.AppendUvarint(encoded, uint64(c.N))
+			for _, word := range c.B {
+				encoded = binary.LittleEndian.AppendUint64(encoded, word)
+			}
+			continue
+		}
+
+		encoded = append(encoded, bitmapArray)
+		encoded = binary.AppendUvarint(encoded, uint64(len(c.A)))
+		for _, v := range c.A {
+			encoded = binary.LittleEndian.AppendUint16(encoded, v)
+		}
+	}
+
+	return encoded, nil
+}
+
+// UnmarshalBinary decodes a bitmap encoded by MarshalBinary, data may be followed by the padding of its page
+func (bm *Bitmap) UnmarshalBinary(data []byte) error {
+	count, size := binary.Uvarint(data)
+	if size <= 0 {
+		return errors.New("bitmap: truncated container count")
+	}
+	data = data[size:]
+
+	containers := make([]*BitmapContainer, 0, min(count, uint64(len(data)/4)))
+	for i := uint64(0); i < count; i++ {
+		if len(data) < 3 {
+			return errors.New("bitmap: truncated container")
+		}
+
+		c := &BitmapContainer{K: binary.LittleEndian.Uint16(data)}
+		kind := data[2]
+		data = data[3:]
+
+		n, size := binary.Uvarint(data)
+		if size <= 0 || n > 1<<16 {
+			return errors.New("bitmap: invalid container size")
+		}
+		data = data[size:]
+		c.N = int(n)
+
+		switch kind {
+		case bitmapArray:
+			if uint64(len(data)) < 2*n {
+				return errors.New("bitmap: truncated array container")
+			}
+
+			if n > 0 {
+				c.A = make([]uint16, n)
+				for j := range c.A {
+					c.A[j] = binary.LittleEndian.Uint16(data[2*j:])
+				}
+			}
+			data = data[2*n:]
+		case bitmapBitset:
+			if len(data) < 8*BITSET_CONTAINER_WORDS {
+				return errors.New("bitmap: truncated bitset container")
+			}
+
+			c.B = make([]uint64, BITSET_CONTAINER_WORDS)
+			for j := range c.B {
+				c.B[j] = binary.LittleEndian.Uint64(data[8*j:])
+			}
+			data = data[8*BITSET_CONTAINER_WORDS:]
+		default:
+			return errors.New("bitmap: unknown container kind")
+		}
+
+		containers = append(containers, c)
+	}
+
+	bm.Containers = containers
+	return nil
+}
+
+// container returns the index of the container with key k, or the index it would be inserted at and false
+func (bm *Bitmap) container(k uint16) (int, bool) {
+	i := sort.Search(len(bm.Containers), func(i int) bool {
+		return bm.Containers[i].K >= k
+	})
+	return i, i < len(bm.Containers) && bm.Containers[i].K == k
+}
+
+// add adds the lower 16 bits of a value and returns true if it was not in the container
+func (c *BitmapContainer) add(v uint16) bool {
+	if c.B != nil {
+		if c.B[v>>6]&(1<<(v&63)) != 0 {
+			return false
+		}
+		c.B[v>>6] |= 1 << (v & 63)
+		c.N++
+		return true
+	}
+
+	i := sort.Search(len(c.A), func(i int) bool { return c.A[i] >= v })
+	if i < len(c.A) && c.A[i] == v {
+		return false
+	}
+
+	c.A = append(c.A, 0)
+	copy(c.A[i+1:], c.A[i:])
+	c.A[i] = v
+	c.N++
+
+	if c.N > ARRAY_CONTAINER_MAX {
+		c.toBitset()
+	}
+	return true
+}
+
+// remove removes the lower 16 bits of a value and returns true if it was in the container
+func (c *BitmapContainer) remove(v uint16) bool {
+	if c.B != nil {
+		if c.B[v>>6]&(1<<(v&63)) == 0 {
+			return false
+		}
+		c.B[v>>6] &^= 1 << (v & 63)
+		c.N--
+
+		if c.N <= ARRAY_CONTAINER_MAX {
+			c.toArray()
+		}
+		return true
+	}
+
+	i := sort.Search(len(c.A), func(i int) bool { return c.A[i] >= v })
+	if i == len(c.A) || c.A[i] != v {
+		return false
+	}
+
+	c.A = append(c.A[:i], c.A[i+1:]...)
+	c.N--
+	return true
+}
+
+// contains returns true if the lower 16 bits of a value are in the container
+func (c *BitmapContainer) contains(v uint16) bool {
+	if c.B != nil {
+		return c.B[v>>6]&(1<<(v&63)) != 0
+	}
+
+	i := sort.Search(len(c.A), func(i int) bool { return c.A[i] >= v })
+	return i < len(c.A) && c.A[i] == v
+}
+
+// each calls fn for the lower 16 bits of every value in ascending order
+func (c *BitmapContainer) each(fn func(v uint16)) {
+	if c.B == nil {
+		for _, v := range c.A {
+			fn(v)
+		}
+		return
+	}
+
+	for w, word := range c.B {
+		for word != 0 {
+			fn(uint16(w<<6 + bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+}
+
+// bitset returns the values of the container as a bitset
+func (c *BitmapContainer) bitset() []uint64 {
+	if c.B != nil {
+		return c.B
+	}
+
+	set := make([]uint64, BITSET_CONTAINER_WORDS)
+	for _, v := range c.A {
+		set[v>>6] |= 1 << (v & 63)
+	}
+	return set
+}
+
+// toBitset converts an array container to a bitset container
+func (c *BitmapContainer) toBitset() {
+	c.B = c.bitset()
+	c.A = nil
+}
+
+// toArray converts a bitset container to an array container
+func (c *BitmapContainer) toArray() {
+	values := make([]uint16, 0, c.N)
+	c.each(func(v uint16) {
+		values = append(values, v)
+	})
+	c.A, c.B = values, nil
+}
+
+// combine returns a container with the same key applying op to the bitsets of both containers
+func (c *BitmapContainer) combine(other *BitmapContainer, op func(a, b uint64) uint64) *BitmapContainer {
+	a, b := c.bitset(), other.bitset()
+
+	result := &BitmapContainer{K: c.K, B: make([]uint64, BITSET_CONTAINER_WORDS)}
+	for w := range result.B {
+		result.B[w] = op(a[w], b[w])
+		result.N += bits.OnesCount64(result.B[w])
+	}
+
+	if result.N <= ARRAY_CONTAINER_MAX {
+		result.toArray()
+	}
+	return result
+}
+
+// clone returns a copy of the container
+func (c *BitmapContainer) clone() *BitmapContainer {
+	clone := &BitmapContainer{K: c.K, N: c.N}
+	if c.B != nil {
+		clone.B = append([]uint64(nil), c.B...)
+	} else {
+		clone.A = append([]uint16(nil), c.A...)
+	}
+	return clone
+}
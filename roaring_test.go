@@ -0,0 +1,139 @@
+// Package btree
+// roaring bitmaps tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// model is the set of values of bm, dense values fill bitset containers and sparse ones array containers
+	bm := &Bitmap{}
+	model := make(map[uint32]bool)
+	for i := 0; i < 20000; i++ {
+		v := uint32(rng.Intn(10000))
+		if i%2 == 0 {
+			v = rng.Uint32()
+		}
+
+		if bm.Add(v) == model[v] {
+			t.Fatalf("Add(%d) returned %v with the value in the set %v", v, !model[v], model[v])
+		}
+		model[v] = true
+	}
+
+	for i := 0; i < 8000; i++ {
+		v := uint32(rng.Intn(10000))
+		if bm.Remove(v) != model[v] {
+			t.Fatalf("Remove(%d) returned %v with the value in the set %v", v, !model[v], model[v])
+		}
+		delete(model, v)
+	}
+
+	if bm.Cardinality() != len(model) {
+		t.Fatalf("expected %d values, got %d", len(model), bm.Cardinality())
+	}
+
+	expected := make([]uint32, 0, len(model))
+	for v := range model {
+		expected = append(expected, v)
+	}
+	slices.Sort(expected)
+
+	if !slices.Equal(bm.Values(), expected) {
+		t.Fatal("expected the values of the model in ascending order")
+	}
+
+	encoded, err := bm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &Bitmap{}
+	err = decoded.UnmarshalBinary(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(decoded.Values(), expected) {
+		t.Fatal("expected the decoded bitmap to hold the same values")
+	}
+
+	// a dense container is stored as a bitset
+	dense := &Bitmap{}
+	for v := uint32(0); v < 65536; v += 2 {
+		dense.Add(v)
+	}
+
+	if dense.Containers[0].B == nil || dense.Cardinality() != 32768 {
+		t.Fatalf("expected a bitset container of 32768 values, got %d", dense.Cardinality())
+	}
+
+	odd := &Bitmap{}
+	for v := uint32(1); v < 100; v += 2 {
+		odd.Add(v)
+	}
+	odd.Add(1 << 20)
+
+	if dense.And(odd).Cardinality() != 0 {
+		t.Fatal("expected no even values among the odd ones")
+	}
+
+	union := dense.Or(odd)
+	if union.Cardinality() != 32768+51 || !union.Contains(1<<20) || !union.Contains(99) || !union.Contains(98) {
+		t.Fatalf("expected the union of both bitmaps, got %d values", union.Cardinality())
+	}
+
+	small := NewBitmap(2, 4, 5, 1<<20)
+	if !slices.Equal(small.And(dense).Values(), []uint32{2, 4}) {
+		t.Fatalf("expected 2 and 4, got %v", small.And(dense).Values())
+	}
+
+	if !slices.Equal(small.AndNot(dense).Values(), []uint32{5, 1 << 20}) {
+		t.Fatalf("expected 5 and 1<<20, got %v", small.AndNot(dense).Values())
+	}
+
+	// removing values turns a bitset container back into an array
+	for v := uint32(0); v < 65536; v += 2 {
+		if v%32 != 0 {
+			dense.Remove(v)
+		}
+	}
+
+	if dense.Containers[0].B != nil || dense.Cardinality() != 2048 {
+		t.Fatalf("expected an array container of 2048 values, got %d", dense.Cardinality())
+	}
+}
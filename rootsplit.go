@@ -0,0 +1,113 @@
+// Package btree
+// atomic root splits
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+)
+
+// writeRoot writes the new root of a root split so a crash leaves page 0 holding either the old or the new root
+// Safe writes and transactions publish the whole split already.  Otherwise the halves, written to new pages, are synced
+// before the new root is published through a .split journal which is replayed on open if the write of page 0 was torn.
+func (b *BTree) writeRoot(n *Node) error {
+	if _, ok := b.Pager.(*Pager); !ok || b.journal != nil || b.txn != nil || b.name == "" {
+		return b.writeNode(n)
+	}
+
+	shadow := newShadowStorage(b.Pager)
+	err := b.writeNodeTo(shadow, n)
+	if err != nil {
+		return err
+	}
+
+	// the disk may reorder unsynced writes, the halves must be durable before a root referencing them is
+	err = b.Pager.Sync()
+	if err != nil {
+		return err
+	}
+
+	journal, err := createRootSplitJournal(b.name+".split", b.perm, b.faults)
+	if err != nil {
+		return err
+	}
+
+	err = journal.publish(b.Pager, shadow)
+	if err != nil {
+		// a published journal is left for the next open to replay
+		journal.Close()
+		return err
+	}
+
+	err = journal.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(b.name + ".split")
+}
+
+// createRootSplitJournal creates an empty root split journal, a journal left by an earlier failed split is not replayed
+// as page 0 may have been written since
+func createRootSplitJournal(filename string, perm os.FileMode, faults *faultPlan) (*shadowJournal, error) {
+	file, err := openFile(filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &shadowJournal{file: file}
+	if faults != nil {
+		j.file = faults.wrap(file)
+	}
+
+	return j, nil
+}
+
+// recoverRootSplit replays the root split journal left by a crash and removes it, nothing is done if there is none
+func recoverRootSplit(filename string, perm os.FileMode, storage Storage, faults *faultPlan) error {
+	_, err := os.Stat(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	journal, err := openShadowJournal(filename, perm, storage, faults)
+	if err != nil {
+		return err
+	}
+
+	err = journal.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filename)
+}
@@ -0,0 +1,134 @@
+// Package btree
+// atomic root splits tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_SplitRoot_Crash(t *testing.T) {
+	for _, torn := range []int{0, 100} {
+		crashed := 0
+
+		for n := 1; ; n++ {
+			done := rootSplitCrashTest(t, n, torn)
+			if done {
+				break
+			}
+			crashed++
+		}
+
+		if crashed == 0 {
+			t.Fatal("expected the root split to crash")
+		}
+	}
+}
+
+// rootSplitCrashTest crashes a btree without safe writes at the nth write of a root split and checks the reopened btree
+// true is returned once the split finished before the crash
+func rootSplitCrashTest(t *testing.T, n, torn int) bool {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.split")
+
+	plan := &faultPlan{tornBytes: torn}
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{PagerOptions: &PagerOptions{faults: plan}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// large values give every node an overflow chain, the new root is written in several pages
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte{byte('a' + i)}, 1500)
+	}
+
+	for i := 0; i < 2*btree.T-1; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), value(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan.crash(n)
+
+	splitErr := btree.splitRoot()
+	if splitErr != nil && !plan.hasCrashed() {
+		t.Fatalf("unexpected error %v", splitErr)
+	}
+
+	// the files are closed as they are after a crash
+	btree.Close()
+
+	btree, err = Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatalf("crash at write %d: %v", n, err)
+	}
+	defer btree.Close()
+
+	_, err = os.Stat("btree.db.split")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("crash at write %d: expected the root split journal to be removed, got %v", n, err)
+	}
+
+	err = btree.Check()
+	if err != nil {
+		t.Fatalf("crash at write %d: %v", n, err)
+	}
+
+	for i := 0; i < 2*btree.T-1; i++ {
+		key, err := btree.Get([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatalf("crash at write %d: %v", n, err)
+		}
+
+		if key == nil || !bytes.Equal(key.V[0], value(i)) {
+			t.Fatalf("crash at write %d: expected key %03d to be intact", n, i)
+		}
+	}
+
+	height, err := btree.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the split is either lost or complete
+	if splitErr == nil && height != 2 {
+		t.Fatalf("crash at write %d: expected the finished split to survive, got height %d", n, height)
+	}
+
+	return !plan.hasCrashed()
+}
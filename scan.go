@@ -0,0 +1,80 @@
+// Package btree
+// value scans
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "regexp"
+
+// ScanValues calls fn for every key and value where pred(value) is true
+// Pages are read one at a time so the tree is never loaded into memory, pairs are visited in page order rather than key order
+// Scanning stops at the first error returned by fn
+func (b *BTree) ScanValues(pred func(v []byte) bool, fn func(key, value []byte) error) error {
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	return b.walk(root, func(n *Node) error {
+		for _, key := range n.Keys {
+			if key == nil || key.hidden() {
+				continue
+			}
+
+			for i := range key.V {
+				value, err := b.resolveValue(key, i)
+				if err != nil {
+					return err
+				}
+
+				if !pred(value) {
+					continue
+				}
+
+				err = fn(key.K, value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// ValuesMatching calls fn for every key and value matching the regular expression pattern, see ScanValues
+func (b *BTree) ValuesMatching(pattern string, fn func(key, value []byte) error) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	return b.ScanValues(re.Match, fn)
+}
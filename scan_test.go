@@ -0,0 +1,125 @@
+// Package btree
+// value scans tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestBTree_ScanValues(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	for i := 0; i < 200; i++ {
+		value := []byte("even")
+		if i%2 == 1 {
+			value = []byte("odd")
+		}
+
+		// large values are stored in the value log and still scanned
+		if i%10 == 0 {
+			value = append(bytes.Repeat([]byte("x"), 64), value...)
+		}
+
+		err = btree.Put([]byte(strconv.Itoa(i)), value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Delete([]byte("2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matched := make(map[string]bool)
+	err = btree.ScanValues(func(v []byte) bool {
+		return bytes.HasSuffix(v, []byte("even"))
+	}, func(key, value []byte) error {
+		matched[string(key)] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matched) != 99 {
+		t.Fatalf("expected 99 matches, got %d", len(matched))
+	}
+
+	if matched["2"] || matched["3"] || !matched["10"] {
+		t.Fatalf("unexpected matches %v", matched)
+	}
+
+	count := 0
+	err = btree.ValuesMatching("^x+odd$", func(key, value []byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only multiples of 10 have large values and they are all even
+	if count != 0 {
+		t.Fatalf("expected no matches, got %d", count)
+	}
+
+	stop := errors.New("stop")
+	count = 0
+	err = btree.ValuesMatching("odd", func(key, value []byte) error {
+		count++
+		if count == 5 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 5 {
+		t.Fatalf("expected the scan to stop after 5 matches, got %d %v", count, err)
+	}
+
+	err = btree.ValuesMatching("(", func(key, value []byte) error { return nil })
+	if err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+}
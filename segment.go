@@ -0,0 +1,365 @@
+// Package btree
+// segment files
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// segmentFile is a page file split into segment files of a fixed number of pages
+// Page p lives in segment p / pages at offset (p % pages) * (PAGE_SIZE + HEADER_SIZE).  Segment 0 is the file itself,
+// segment i > 0 is the file with .seg<i> appended.  Segments are created as pages are written past the last one.
+type segmentFile struct {
+	name     string      // the file of segment 0
+	flag     int         // flags segments are opened with
+	perm     os.FileMode // file mode of new segments
+	direct   bool        // true if segments are opened with direct i/o
+	size     int64       // bytes of a full segment
+	lock     sync.Mutex  // guards segments
+	segments []pageFile  // the open segments in order
+}
+
+// segmentInfo describes a segmented page file, its size is the size of every segment together
+type segmentInfo struct {
+	os.FileInfo
+	size int64
+}
+
+// Size returns the size of every segment together
+func (s *segmentInfo) Size() int64 {
+	return s.size
+}
+
+// segmentName returns the file of segment i of the page file name
+func segmentName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return name + ".seg" + strconv.Itoa(i)
+}
+
+// readSegmentPages returns the pages per segment recorded in name.segments, 0 if the file is not segmented
+func readSegmentPages(name string) (int64, error) {
+	data, err := os.ReadFile(name + ".segments")
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	pages, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || pages <= 0 {
+		return 0, fmt.Errorf("%s.segments: invalid segment size %q", name, data)
+	}
+
+	return pages, nil
+}
+
+// openSegments opens the page file name split into segments of pages pages, the recorded segment size of an existing file wins if pages is 0
+// A file which is not segmented is opened as a single file unless pages is set, it can only be segmented while it fits into the first segment.
+func openSegments(name string, flag int, perm os.FileMode, direct bool, pages int64) (pageFile, *segmentFile, bool, error) {
+	pages = max(pages, 0)
+
+	recorded, err := readSegmentPages(name)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if recorded != 0 && pages != 0 && recorded != pages {
+		return nil, nil, false, fmt.Errorf("%s is split into segments of %d pages, not %d", name, recorded, pages)
+	}
+
+	if recorded == 0 && pages == 0 {
+		file, direct, err := openPageFile(name, flag, perm, direct)
+		return file, nil, direct, err
+	}
+
+	if recorded == 0 {
+		stat, err := os.Stat(name)
+		if err == nil && stat.Size() > pages*(PAGE_SIZE+HEADER_SIZE) {
+			return nil, nil, false, fmt.Errorf("%s holds %d pages, more than a segment of %d pages", name, pageCount(stat.Size()), pages)
+		} else if err != nil && !os.IsNotExist(err) {
+			return nil, nil, false, err
+		}
+	}
+
+	first, direct, err := openPageFile(name, flag, perm, direct)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if recorded == 0 {
+		err = os.WriteFile(name+".segments", []byte(strconv.FormatInt(pages, 10)+"\n"), perm)
+		if err != nil {
+			first.Close()
+			return nil, nil, false, err
+		}
+	} else {
+		pages = recorded
+	}
+
+	s := &segmentFile{name: name, flag: flag &^ (os.O_CREATE | os.O_TRUNC | os.O_EXCL), perm: perm, direct: direct, size: pages * (PAGE_SIZE + HEADER_SIZE), segments: []pageFile{first}}
+
+	// the segments are numbered without gaps
+	for i := 1; ; i++ {
+		_, err = os.Stat(segmentName(name, i))
+		if os.IsNotExist(err) {
+			break
+		}
+
+		if err == nil {
+			err = s.open(i, s.flag)
+		}
+		if err != nil {
+			s.Close()
+			return nil, nil, false, err
+		}
+	}
+
+	return s, s, direct, nil
+}
+
+// open opens segment i with flag, the segments before it are open
+func (s *segmentFile) open(i int, flag int) error {
+	file, _, err := openPageFile(segmentName(s.name, i), flag, s.perm, s.direct)
+	if err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, file)
+
+	return nil
+}
+
+// segment returns segment i, the segments up to it are created if create is true, nil if it does not exist otherwise
+// Segments created by another handle since the file was opened are opened, i.e. by a follower.
+func (s *segmentFile) segment(i int, create bool) (pageFile, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for len(s.segments) <= i {
+		flag := s.flag | os.O_CREATE
+		if !create {
+			_, err := os.Stat(segmentName(s.name, len(s.segments)))
+			if os.IsNotExist(err) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			flag = s.flag
+		}
+
+		err := s.open(len(s.segments), flag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.segments[i], nil
+}
+
+// ReadAt reads len(b) bytes starting at offset, a read spanning segments continues in the next one
+func (s *segmentFile) ReadAt(b []byte, offset int64) (int, error) {
+	read := 0
+	for read < len(b) {
+		i, within := int(offset/s.size), offset%s.size
+		n := int(min(int64(len(b)-read), s.size-within))
+
+		seg, err := s.segment(i, false)
+		if err != nil {
+			return read, err
+		}
+
+		if seg == nil {
+			return read, io.EOF
+		}
+
+		m, err := seg.ReadAt(b[read:read+n], within)
+		read += m
+		offset += int64(m)
+
+		if err != nil && (err != io.EOF || m < n) {
+			return read, err
+		}
+	}
+
+	return read, nil
+}
+
+// WriteAt writes b starting at offset creating the segments it reaches
+func (s *segmentFile) WriteAt(b []byte, offset int64) (int, error) {
+	written := 0
+	for written < len(b) {
+		i, within := int(offset/s.size), offset%s.size
+		n := int(min(int64(len(b)-written), s.size-within))
+
+		seg, err := s.segment(i, true)
+		if err != nil {
+			return written, err
+		}
+
+		m, err := seg.WriteAt(b[written:written+n], within)
+		written += m
+		offset += int64(m)
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Truncate changes the size of the file, segments beyond the new size are removed
+func (s *segmentFile) Truncate(size int64) error {
+	last := 0
+	if size > 0 {
+		last = int((size - 1) / s.size)
+	}
+
+	_, err := s.segment(last, true)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for len(s.segments) > last+1 {
+		i := len(s.segments) - 1
+
+		err = s.segments[i].Close()
+		if err != nil {
+			return err
+		}
+		s.segments = s.segments[:i]
+
+		err = os.Remove(segmentName(s.name, i))
+		if err != nil {
+			return err
+		}
+	}
+
+	// every segment before the last one is full
+	for i, seg := range s.segments {
+		n := s.size
+		if i == last {
+			n = size - int64(last)*s.size
+		}
+
+		err = seg.Truncate(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sync syncs every segment
+func (s *segmentFile) Sync() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, seg := range s.segments {
+		err := seg.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes every segment
+func (s *segmentFile) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var errs []error
+	for _, seg := range s.segments {
+		errs = append(errs, seg.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Stat describes the first segment with the size of every segment together
+func (s *segmentFile) Stat() (os.FileInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	first, err := s.segments[0].Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := s.segments[len(s.segments)-1].Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &segmentInfo{FileInfo: first, size: int64(len(s.segments)-1)*s.size + last.Size()}, nil
+}
+
+// files returns the files of the segments in order
+func (s *segmentFile) files() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	files := make([]string, len(s.segments))
+	for i := range s.segments {
+		files[i] = segmentName(s.name, i)
+	}
+
+	return files
+}
+
+// Segments returns the files the pages are stored in in page order, a single file unless PagerOptions.SegmentPages was set
+// A backup can copy the segments one by one, every segment but the last holds PagerOptions.SegmentPages pages.  Nil for an in-memory pager.
+func (p *Pager) Segments() []string {
+	if p.segments != nil {
+		return p.segments.files()
+	}
+
+	if p.fileName == "" {
+		return nil
+	}
+
+	return []string{p.fileName}
+}
@@ -0,0 +1,193 @@
+// Package btree
+// segment files tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBTree_Segments(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("segment.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	opts := &Options{PagerOptions: &PagerOptions{SegmentPages: 8}}
+
+	btree, err := OpenWithOptions("segment.db", os.O_CREATE|os.O_RDWR, 0644, 3, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pager := btree.Pager.(*Pager)
+	segments := pager.Segments()
+	if int64(len(segments)) != (pager.PageCount()+7)/8 {
+		t.Fatalf("expected a segment per 8 of %d pages, got %d", pager.PageCount(), len(segments))
+	}
+
+	if segments[0] != "segment.db" || segments[1] != "segment.db.seg1" {
+		t.Fatalf("expected the file followed by its segments, got %v", segments[:2])
+	}
+
+	total := int64(0)
+	for i, f := range segments {
+		stat, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += stat.Size()
+
+		if stat.Size() > 8*(PAGE_SIZE+HEADER_SIZE) || (i < len(segments)-1 && stat.Size() != 8*(PAGE_SIZE+HEADER_SIZE)) {
+			t.Fatalf("expected segment %d to hold at most 8 pages, got %d bytes", i, stat.Size())
+		}
+	}
+
+	if pager.FileSize() != total {
+		t.Fatalf("expected the size of every segment together, got %d", pager.FileSize())
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the recorded segment size wins over a missing option and conflicts with another one
+	_, err = OpenWithOptions("segment.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{PagerOptions: &PagerOptions{SegmentPages: 16}})
+	if err == nil {
+		t.Fatal("expected an error opening with another segment size")
+	}
+
+	btree, err = Open("segment.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	checkBTree(t, btree)
+
+	for i := 0; i < 500; i++ {
+		key, err := btree.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("expected value-%d, got %v", i, key)
+		}
+	}
+
+	if len(btree.Pager.(*Pager).Segments()) != len(segments) {
+		t.Fatalf("expected %d segments after reopening, got %d", len(segments), len(btree.Pager.(*Pager).Segments()))
+	}
+}
+
+func TestSegmentFile(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("segfile.db*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	// an existing file can only be segmented while it fits into the first segment
+	err := os.WriteFile("segfile.db", make([]byte, 3*(PAGE_SIZE+HEADER_SIZE)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, err = openSegments("segfile.db", os.O_CREATE|os.O_RDWR, 0644, false, 2)
+	if err == nil {
+		t.Fatal("expected an error segmenting a file larger than a segment")
+	}
+
+	file, s, _, err := openSegments("segfile.db", os.O_CREATE|os.O_RDWR, 0644, false, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// a write spanning segments is split between them
+	data := make([]byte, 3*(PAGE_SIZE+HEADER_SIZE))
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	_, err = file.WriteAt(data, 2*(PAGE_SIZE+HEADER_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.files()) != 2 {
+		t.Fatalf("expected 2 segments, got %v", s.files())
+	}
+
+	read := make([]byte, len(data))
+	_, err = file.ReadAt(read, 2*(PAGE_SIZE+HEADER_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(read) != string(data) {
+		t.Fatal("expected to read back the write spanning segments")
+	}
+
+	err = file.Truncate(3 * (PAGE_SIZE + HEADER_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Size() != 3*(PAGE_SIZE+HEADER_SIZE) || len(s.files()) != 1 {
+		t.Fatalf("expected 3 pages in one segment, got %d bytes in %v", stat.Size(), s.files())
+	}
+
+	_, err = os.Stat("segfile.db.seg1")
+	if !os.IsNotExist(err) {
+		t.Fatal("expected the truncated segment to be removed")
+	}
+}
@@ -0,0 +1,287 @@
+// Package btree
+// separator index
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// sepIndex keeps the separator keys of the tree in memory in key order so a point lookup reads a single page
+// An in-order walk of the tree alternates between leaves and internal keys, exactly one internal key separates two neighbouring leaves.
+// The separators are kept sorted with the page of the node holding them, the leaves in between with their page, so a lookup
+// binary searches the separators and reads the node holding the key, or the leaf the key would be in.
+// Splits update the index in place, other changes of the shape of the tree invalidate it and it is rebuilt once enough lookups missed it.
+type sepIndex struct {
+	lock     sync.RWMutex
+	valid    bool
+	seps     [][]byte // separator keys in order
+	sepPages []int64  // page of the internal node holding each separator
+	leaves   []int64  // page of the leaf before each separator and of the last leaf
+	misses   int      // lookups made without the index since it was invalidated
+}
+
+// newSepIndex builds the separator index of the tree
+func (b *BTree) newSepIndex() (*sepIndex, error) {
+	idx := &sepIndex{}
+
+	err := idx.rebuild(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// rebuild walks the tree and replaces the contents of the index, the index stays invalid if the tree holds empty key slots
+func (idx *sepIndex) rebuild(b *BTree) error {
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	seps, sepPages, leaves := make([][]byte, 0), make([]int64, 0), make([]int64, 0)
+	valid := true
+
+	var visit func(x *Node) error
+	visit = func(x *Node) error {
+		if x.Leaf {
+			leaves = append(leaves, x.Page)
+			return nil
+		}
+
+		for i, c := range x.Children {
+			child, err := b.readNode(c)
+			if err != nil {
+				return err
+			}
+
+			err = visit(child)
+			if err != nil {
+				return err
+			}
+
+			if i == len(x.Children)-1 {
+				break
+			}
+
+			if i >= len(x.Keys) || x.Keys[i] == nil {
+				valid = false
+				return nil
+			}
+
+			// keys may point into page buffers which are reused
+			seps = append(seps, bytes.Clone(x.Keys[i].K))
+			sepPages = append(sepPages, x.Page)
+		}
+
+		return nil
+	}
+
+	err = visit(root)
+	if err != nil {
+		return err
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	idx.seps, idx.sepPages, idx.leaves = seps, sepPages, leaves
+	idx.valid = valid && len(leaves) == len(seps)+1
+	idx.misses = 0
+
+	return nil
+}
+
+// lookup returns the page of the node holding k, or of the leaf k would be in, false if the index is invalid
+func (idx *sepIndex) lookup(k []byte) (int64, bool) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	if !idx.valid {
+		return -1, false
+	}
+
+	j := idx.search(k)
+	if j < len(idx.seps) && equal(idx.seps[j], k) {
+		return idx.sepPages[j], true
+	}
+
+	return idx.leaves[j], true
+}
+
+// search returns the position of the first separator not less than k
+// lock must be held
+func (idx *sepIndex) search(k []byte) int {
+	return sort.Search(len(idx.seps), func(j int) bool {
+		return !lessThan(idx.seps[j], k)
+	})
+}
+
+// invalidate marks the index as stale after the shape of the tree changed
+func (idx *sepIndex) invalidate() {
+	idx.lock.Lock()
+	idx.valid = false
+	idx.misses = 0
+	idx.lock.Unlock()
+}
+
+// missed counts a lookup made without the index and returns true once the index should be rebuilt
+// The index is rebuilt after as many lookups as it has leaves, so the cost of the walk is spread over the lookups
+func (idx *sepIndex) missed() bool {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	idx.misses++
+	return idx.misses > len(idx.leaves)
+}
+
+// moved records that the keys of node n moved from page from to the page of n, as when the root is split
+func (idx *sepIndex) moved(n *Node, from int64) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if !idx.valid {
+		return
+	}
+
+	if n.Leaf {
+		if len(idx.leaves) != 1 || idx.leaves[0] != from {
+			idx.valid = false
+			return
+		}
+		idx.leaves[0] = n.Page
+		return
+	}
+
+	idx.setPages(n.Keys, from, n.Page)
+}
+
+// split records that y was split into y and z with median moving up to the internal node x
+func (idx *sepIndex) split(x, y, z *Node, median []byte) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if !idx.valid {
+		return
+	}
+
+	if !y.Leaf {
+		// the keys of y moved up to x and over to z, the leaves are unchanged
+		idx.setPages([]*Key{{K: median}}, y.Page, x.Page)
+		idx.setPages(z.Keys, y.Page, z.Page)
+		return
+	}
+
+	// the median lies within y, it becomes the separator between y and z
+	j := idx.search(median)
+	if idx.leaves[j] != y.Page {
+		idx.valid = false
+		return
+	}
+
+	idx.seps = append(idx.seps, nil)
+	copy(idx.seps[j+1:], idx.seps[j:])
+	idx.seps[j] = bytes.Clone(median)
+
+	idx.sepPages = append(idx.sepPages, 0)
+	copy(idx.sepPages[j+1:], idx.sepPages[j:])
+	idx.sepPages[j] = x.Page
+
+	idx.leaves = append(idx.leaves, 0)
+	copy(idx.leaves[j+2:], idx.leaves[j+1:])
+	idx.leaves[j+1] = z.Page
+}
+
+// setPages changes the page of the separators keys from page from to page to, the index is invalidated if one is missing
+// lock must be held
+func (idx *sepIndex) setPages(keys []*Key, from, to int64) {
+	for _, k := range keys {
+		if k == nil {
+			idx.valid = false
+			return
+		}
+
+		j := idx.search(k.K)
+		if j == len(idx.seps) || !equal(idx.seps[j], k.K) || idx.sepPages[j] != from {
+			idx.valid = false
+			return
+		}
+
+		idx.sepPages[j] = to
+	}
+}
+
+// indexedGet returns the key k reading the single page the separator index points to
+// false is returned if the lookup must descend from the root
+func (b *BTree) indexedGet(k []byte) (*Key, bool, error) {
+	idx := b.sepIndex
+
+	page, ok := idx.lookup(k)
+	if !ok {
+		if idx.missed() {
+			err := idx.rebuild(b)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		return nil, false, nil
+	}
+
+	x, err := b.readNode(page)
+	if err != nil {
+		return nil, false, err
+	}
+
+	x.Keys = removeNilFromKeys(x.Keys)
+	i := b.searchKeys(x.Keys, k)
+
+	if i < len(x.Keys) && equal(k, x.Keys[i].K) {
+		return x.Keys[i], true, nil
+	}
+
+	// a separator is always found in its node
+	if !x.Leaf {
+		idx.invalidate()
+		return nil, false, nil
+	}
+
+	return nil, true, nil
+}
+
+// shapeChanged invalidates the separator index after a change of the shape of the tree other than a split
+func (b *BTree) shapeChanged() {
+	if b.sepIndex != nil {
+		b.sepIndex.invalidate()
+	}
+}
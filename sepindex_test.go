@@ -0,0 +1,162 @@
+// Package btree
+// separator index tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestBTree_SeparatorIndex(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{SeparatorIndex: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(2000) {
+		err = btree.Put([]byte(fmt.Sprintf("a long string key prefix %05d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// splits keep the index up to date
+	if !btree.sepIndex.valid {
+		t.Fatal("expected the index to stay valid through splits")
+	}
+
+	rebuilt, err := btree.newSepIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.EqualFunc(btree.sepIndex.seps, rebuilt.seps, equal) || !slices.Equal(btree.sepIndex.sepPages, rebuilt.sepPages) || !slices.Equal(btree.sepIndex.leaves, rebuilt.leaves) {
+		t.Fatal("expected the updated index to match a rebuilt one")
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the index is rebuilt on open
+	btree, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{SeparatorIndex: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	pager := btree.Pager.(*Pager)
+
+	for i := 0; i < 2000; i++ {
+		pager.ResetStats()
+
+		key, err := btree.Get([]byte(fmt.Sprintf("a long string key prefix %05d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != "value" {
+			t.Fatalf("expected key %d, got %v", i, key)
+		}
+
+		// a single page is read instead of the path from the root
+		if pager.Stats().PagesRead != 1 {
+			t.Fatalf("expected 1 page read for key %d, got %d", i, pager.Stats().PagesRead)
+		}
+	}
+
+	key, err := btree.Get([]byte("missing"))
+	if err != nil || key != nil {
+		t.Fatalf("expected no key, got %v %v", key, err)
+	}
+}
+
+func TestBTree_SeparatorIndex_Deletes(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{SeparatorIndex: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	r := rand.New(rand.NewSource(2))
+	live := make(map[int]bool)
+
+	for n := 0; n < 5000; n++ {
+		i := r.Intn(500)
+		key := []byte(fmt.Sprintf("%03d", i))
+
+		switch r.Intn(3) {
+		case 0:
+			err = btree.Put(key, []byte("value"))
+			live[i] = true
+		case 1:
+			err = btree.Delete(key)
+			delete(live, i)
+		default:
+			var k *Key
+			k, err = btree.Get(key)
+			if err == nil && (k != nil) != live[i] {
+				t.Fatalf("operation %d: expected key %d to exist: %v", n, i, live[i])
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lookups rebuild the index once enough of them missed it
+	for i := 0; i < 500; i++ {
+		k, err := btree.Get([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (k != nil) != live[i] {
+			t.Fatalf("expected key %d to exist: %v", i, live[i])
+		}
+	}
+
+	if !btree.sepIndex.valid {
+		t.Fatal("expected the index to be rebuilt")
+	}
+}
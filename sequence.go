@@ -0,0 +1,171 @@
+// Package btree
+// sequences
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// DefaultSequenceBatch is the number of ids reserved at a time when Options.SequenceBatch is not set
+const DefaultSequenceBatch = 100
+
+// sequences hands out the ids of NextSequence, ids are reserved in batches on a metadata page
+// The page holds the first id after the reserved ones of every sequence, ids handed out never reach it.
+type sequences struct {
+	lock     sync.Locker       // serializes NextSequence, a no-op if locking is disabled
+	pager    *Pager            // the pager of the metadata page, page 0
+	batch    uint64            // number of ids reserved at a time
+	next     map[string]uint64 // the next id of every sequence
+	reserved map[string]uint64 // the first id of every sequence which is not reserved
+}
+
+// NextSequence returns the next id of the named sequence, ids start at 1 and increase by 1
+// Ids are reserved in batches of Options.SequenceBatch which are written and synced once per batch instead of once per id.
+// A crash skips the rest of the batch, ids are never handed out twice.  Close records the next id so a clean restart skips nothing.
+func (b *BTree) NextSequence(name string) (uint64, error) {
+	if b.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	if !b.HasLease() {
+		return 0, ErrReadOnly
+	}
+
+	s, err := b.sequenceTable()
+	if err != nil {
+		return 0, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	next := s.next[name]
+	if next == 0 {
+		next = 1
+	}
+
+	if next >= s.reserved[name] {
+		s.reserved[name] = next + s.batch
+
+		err = s.write(s.reserved)
+		if err != nil {
+			// the batch was not reserved, it is reserved again by the next call
+			s.reserved[name] = next
+			return 0, err
+		}
+	}
+
+	s.next[name] = next + 1
+
+	return next, nil
+}
+
+// sequenceTable opens the metadata page of the sequences on first use
+// The page is stored in name.seq next to the btree file, in memory for in-memory btrees
+func (b *BTree) sequenceTable() (*sequences, error) {
+	b.sequencesLock.Lock()
+	defer b.sequencesLock.Unlock()
+
+	if b.sequences != nil {
+		return b.sequences, nil
+	}
+
+	var pager *Pager
+	var err error
+
+	if b.name == "" {
+		pager, err = OpenMemoryPager()
+	} else {
+		pager, err = OpenPagerWithOptions(b.name+".seq", os.O_CREATE|os.O_RDWR, b.perm, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sequences{lock: &sync.Mutex{}, pager: pager, batch: uint64(b.sequenceBatch), next: make(map[string]uint64), reserved: make(map[string]uint64)}
+	if s.batch == 0 {
+		s.batch = DefaultSequenceBatch
+	}
+
+	if b.noLocking {
+		s.lock = noLock{}
+	}
+
+	data, err := pager.ReadPage(0)
+	if err == nil {
+		err = codec.NewDecoderBytes(data, msgpackHandle).Decode(&s.reserved)
+	} else if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		pager.Close()
+		return nil, err
+	}
+
+	// the ids before the reserved ones may have been handed out before the btree was closed
+	for name, reserved := range s.reserved {
+		s.next[name] = reserved
+	}
+
+	b.sequences = s
+	return s, nil
+}
+
+// write writes the first id after the reserved ones of every sequence to the metadata page and syncs it
+func (s *sequences) write(reserved map[string]uint64) error {
+	var encoded []byte
+	err := codec.NewEncoderBytes(&encoded, msgpackHandle).Encode(reserved)
+	if err != nil {
+		return err
+	}
+
+	err = s.pager.WritePage(0, encoded)
+	if err != nil {
+		return err
+	}
+
+	return s.pager.Sync()
+}
+
+// close records the next id of every sequence so the ids left in the batches are not skipped, then closes the pager
+func (s *sequences) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.write(s.next)
+	return errors.Join(err, s.pager.Close())
+}
@@ -0,0 +1,163 @@
+// Package btree
+// sequences tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestBTree_NextSequence(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.seq")
+	defer os.Remove("btree.db.seq.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{SequenceBatch: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint64(1); i <= 25; i++ {
+		id, err := btree.NextSequence("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id != i {
+			t.Fatalf("expected id %d, got %d", i, id)
+		}
+	}
+
+	id, err := btree.NextSequence("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 1 {
+		t.Fatalf("expected sequences to be independent, got %d", id)
+	}
+
+	// a batch is written and synced once per 10 ids
+	writes := btree.sequences.pager.Stats().PagesWritten
+	if writes != 4 {
+		t.Fatalf("expected 4 page writes, got %d", writes)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a clean close records the next id
+	btree, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{SequenceBatch: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err = btree.NextSequence("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 26 {
+		t.Fatalf("expected id 26 after a clean restart, got %d", id)
+	}
+
+	// a crash loses the batch in memory, the reserved ids are skipped
+	err = btree.sequences.pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btree.sequences = nil
+
+	id, err = btree.NextSequence("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 36 {
+		t.Fatalf("expected the reserved ids to be skipped, got %d", id)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = btree.NextSequence("users")
+	if err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestBTree_NextSequence_Concurrent(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	var lock sync.Mutex
+	seen := make(map[uint64]bool)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 250; i++ {
+				id, err := btree.NextSequence("jobs")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				lock.Lock()
+				if seen[id] {
+					t.Errorf("id %d handed out twice", id)
+				}
+				seen[id] = true
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for id := uint64(1); id <= 1000; id++ {
+		if !seen[id] {
+			t.Fatalf("expected id %d to be handed out", id)
+		}
+	}
+}
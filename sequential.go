@@ -0,0 +1,129 @@
+// Package btree
+// sequential inserts
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+// sequentialStreak is the number of ascending inserts in a row after which the rightmost leaf is cached
+const sequentialStreak = 8
+
+// sequentialSplit is the share of the keys a node split by an ascending insert keeps with sequential splits
+const sequentialSplit = 0.9
+
+// rightmostCache remembers the rightmost leaf so ascending keys (i.e. timestamps) are inserted without a descent from the root
+// The cache is only used while no other write happened, any split or other change drops it
+type rightmostCache struct {
+	leaf       *Node  // the rightmost leaf, nil if nothing is cached
+	generation uint64 // write generation of the tree the leaf is valid for
+	last       []byte // the last key inserted
+	streak     int    // the number of ascending inserts in a row
+}
+
+// insertRightmost appends a new greatest key to the cached rightmost leaf
+// false is returned if the key must be inserted through a descent from the root
+func (b *BTree) insertRightmost(k *Key) (bool, error) {
+	c := &b.rightmost
+	key := k.K
+
+	ascending := c.last != nil && lessThan(c.last, key)
+	c.last = key
+
+	if !ascending {
+		c.streak = 0
+		c.leaf = nil
+		return false, nil
+	}
+	c.streak++
+
+	if c.leaf == nil || c.generation != b.generation.Load() {
+		c.leaf = nil
+		return false, nil
+	}
+
+	leaf := c.leaf
+	if len(leaf.Keys) == 0 || !lessThan(leaf.Keys[len(leaf.Keys)-1].K, key) {
+		return false, nil
+	}
+
+	// a full leaf is split through a descent, the split drops the cache
+	full, err := b.isFull(leaf)
+	if err != nil || full {
+		return false, err
+	}
+
+	leaf.Keys = append(leaf.Keys, k)
+
+	err = b.writeNode(leaf)
+	if err != nil {
+		c.leaf = nil
+		return false, err
+	}
+
+	c.generation = b.generation.Load()
+
+	return true, nil
+}
+
+// cacheRightmost caches the rightmost leaf once inserts look sequential
+func (b *BTree) cacheRightmost() error {
+	c := &b.rightmost
+	if c.streak < sequentialStreak || (c.leaf != nil && c.generation == b.generation.Load()) {
+		return nil
+	}
+
+	x, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	for depth := 1; !x.Leaf; depth++ {
+		x, err = b.descendChild(x, len(x.Children)-1, depth)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.leaf = x
+	c.generation = b.generation.Load()
+
+	return nil
+}
+
+// appending returns true if y is split by an ascending insert past its last key and sequential splits are enabled
+// Latched puts do not track ascending inserts
+func (b *BTree) appending(y *Node) bool {
+	c := &b.rightmost
+	if !b.seqSplits || b.latched() || c.streak < sequentialStreak || len(y.Keys) == 0 {
+		return false
+	}
+
+	last := y.Keys[len(y.Keys)-1]
+	return last != nil && lessThan(last.K, c.last)
+}
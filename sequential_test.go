@@ -0,0 +1,247 @@
+// Package btree
+// sequential insert tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestBTree_SequentialPut(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	b, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	b.ResetStats()
+
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("%08d", i))
+		err = b.Put(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// most puts append to the cached rightmost leaf without reading a page
+	stats := b.Stats()
+	if stats.PagesRead >= 1000 {
+		t.Fatalf("expected fewer than 1000 pages read, got %d", stats.PagesRead)
+	}
+
+	checkBTree(t, b)
+
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("%08d", i))
+		k, err := b.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if k == nil || string(k.V[0]) != string(key) {
+			t.Fatalf("expected %s", key)
+		}
+	}
+}
+
+func TestBTree_SequentialPutMixed(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	r := rand.New(rand.NewSource(7))
+	want := make(map[string]bool)
+
+	next := 0
+	for i := 0; i < 3000; i++ {
+		var key []byte
+		if r.Intn(4) == 0 {
+			key = []byte(fmt.Sprintf("%08d", r.Intn(next+1)))
+		} else {
+			key = []byte(fmt.Sprintf("%08d", next))
+			next++
+		}
+
+		err = b.Put(key, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[string(key)] = true
+
+		// other writes drop the cached leaf
+		if i%500 == 0 {
+			err = b.Remove(key, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = b.Put(key, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	checkBTree(t, b)
+
+	for key := range want {
+		k, err := b.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if k == nil {
+			t.Fatalf("expected key %s", key)
+		}
+	}
+}
+
+func TestBTree_SequentialPutInvalidated(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 100; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%08d", i)), []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if b.rightmost.leaf == nil {
+		t.Fatal("expected the rightmost leaf to be cached")
+	}
+
+	err = b.Put([]byte("00000000"), []byte("w"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.rightmost.leaf != nil {
+		t.Fatal("expected the cache to be dropped")
+	}
+
+	err = b.Put([]byte(fmt.Sprintf("%08d", 100)), []byte("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBTree(t, b)
+}
+
+func TestBTree_SequentialSplits(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	// pages used by 2000 ascending keys with and without sequential splits
+	pages := func(opts *Options) int {
+		b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 16, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			b.Close()
+			os.Remove("btree.db")
+			os.Remove("btree.db.del")
+		}()
+
+		for i := 0; i < 2000; i++ {
+			err = b.Put([]byte(fmt.Sprintf("%05d", i)), []byte("value"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = b.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		root, err := b.getRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count := 0
+		err = b.walk(root, func(n *Node) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.SequentialSplits {
+			// nodes left with fewer than T-1 keys are still deleted from correctly
+			for i := 0; i < 2000; i += 3 {
+				err = b.Delete([]byte(fmt.Sprintf("%05d", i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = b.Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := b.InOrderTraversal()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(keys) != 1333 {
+				t.Fatalf("expected 1333 keys, got %d", len(keys))
+			}
+		}
+
+		return count
+	}
+
+	halves := pages(&Options{})
+	sequential := pages(&Options{SequentialSplits: true})
+
+	// half full nodes take almost twice the pages of nearly full ones
+	if sequential*3 > halves*2 {
+		t.Fatalf("expected sequential splits to use far fewer than %d pages, got %d", halves, sequential)
+	}
+}
@@ -0,0 +1,352 @@
+// Package btree
+// shadow paging
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"os"
+)
+
+// Journal entries with one of these lengths record a page id without page data
+const (
+	shadowFreed     = math.MaxUint32     // the page was freed by the operation
+	shadowAllocated = math.MaxUint32 - 1 // the page was allocated by the operation
+)
+
+// shadowStorage buffers the pages written by a single operation so they can be published together
+type shadowStorage struct {
+	Storage                    // underlying storage
+	pages     map[int64][]byte // pages written by the operation
+	order     []int64          // order pages were first written in
+	freed     []int64          // pages freed by the operation
+	allocated []int64          // pages allocated by the operation
+}
+
+// newShadowStorage returns a shadowStorage buffering writes to storage
+func newShadowStorage(storage Storage) *shadowStorage {
+	return &shadowStorage{Storage: storage, pages: make(map[int64][]byte)}
+}
+
+// ReadPage reads a page written by the operation or from the underlying storage
+func (s *shadowStorage) ReadPage(pageID int64) ([]byte, error) {
+	if data, ok := s.pages[pageID]; ok {
+		return data, nil
+	}
+	return s.Storage.ReadPage(pageID)
+}
+
+// WritePage buffers a page write until the operation is published
+func (s *shadowStorage) WritePage(pageID int64, data []byte) error {
+	if _, ok := s.pages[pageID]; !ok {
+		s.order = append(s.order, pageID)
+	}
+	s.pages[pageID] = data
+	return nil
+}
+
+// Allocate writes data to a new page of the underlying storage
+// Nothing references the page until the operation is published so it is recorded to be freed if the operation is abandoned
+func (s *shadowStorage) Allocate(data []byte) (int64, error) {
+	pageID, err := s.Storage.Allocate(data)
+	if err != nil {
+		return -1, err
+	}
+	s.allocated = append(s.allocated, pageID)
+	return pageID, nil
+}
+
+// Free defers freeing a page until the operation is published as the old tree still references it
+func (s *shadowStorage) Free(pageID int64) error {
+	s.freed = append(s.freed, pageID)
+	return nil
+}
+
+// empty returns true if the operation modified nothing
+func (s *shadowStorage) empty() bool {
+	return len(s.order) == 0 && len(s.freed) == 0 && len(s.allocated) == 0
+}
+
+// encode encodes the operation as journal entries followed by a trailer with their length and checksum
+func (s *shadowStorage) encode() []byte {
+	body := make([]byte, 0)
+	for _, pageID := range s.order {
+		body = appendShadowEntry(body, pageID, uint32(len(s.pages[pageID])))
+		body = append(body, s.pages[pageID]...)
+	}
+
+	for _, pageID := range s.freed {
+		body = appendShadowEntry(body, pageID, shadowFreed)
+	}
+
+	for _, pageID := range s.allocated {
+		body = appendShadowEntry(body, pageID, shadowAllocated)
+	}
+
+	trailer := make([]byte, 8)
+	binary.BigEndian.PutUint32(trailer[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(trailer[4:8], crc32.ChecksumIEEE(body))
+
+	return append(body, trailer...)
+}
+
+// appendShadowEntry appends a journal entry header
+func appendShadowEntry(body []byte, pageID int64, length uint32) []byte {
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint64(header[0:8], uint64(pageID))
+	binary.BigEndian.PutUint32(header[8:12], length)
+	return append(body, header...)
+}
+
+// decodeShadowStorage decodes an operation encoded by encode, false is returned if the journal is incomplete
+func decodeShadowStorage(data []byte, storage Storage) (*shadowStorage, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+
+	body := data[:len(data)-8]
+	trailer := data[len(data)-8:]
+
+	if binary.BigEndian.Uint32(trailer[0:4]) != uint32(len(body)) || binary.BigEndian.Uint32(trailer[4:8]) != crc32.ChecksumIEEE(body) {
+		return nil, false
+	}
+
+	s := newShadowStorage(storage)
+	for len(body) >= 12 {
+		pageID := int64(binary.BigEndian.Uint64(body[0:8]))
+		length := binary.BigEndian.Uint32(body[8:12])
+		body = body[12:]
+
+		switch length {
+		case shadowFreed:
+			s.freed = append(s.freed, pageID)
+		case shadowAllocated:
+			s.allocated = append(s.allocated, pageID)
+		default:
+			s.WritePage(pageID, body[:length])
+			body = body[length:]
+		}
+	}
+
+	return s, true
+}
+
+// shadowJournal is the scratch file modified pages are written to before they are published
+// A journal is either complete (trailer checksum matches) and replayed on open, or discarded
+type shadowJournal struct {
+	file pageFile // scratch file
+}
+
+// openShadowJournal opens the journal and replays a complete operation left by a crash
+// faults are injected into the journal when a test passes a fault plan
+func openShadowJournal(filename string, perm os.FileMode, storage Storage, faults *faultPlan) (*shadowJournal, error) {
+	file, err := openFile(filename, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &shadowJournal{file: file}
+	if faults != nil {
+		j.file = faults.wrap(file)
+	}
+
+	err = j.recover(storage)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// recover replays a complete journal into storage and clears the journal
+func (j *shadowJournal) recover(storage Storage) error {
+	s, ok, err := j.load(storage)
+	if err != nil {
+		return err
+	}
+
+	// an incomplete journal means the operation was never published, the tree is untouched
+	if ok {
+		err = s.apply(storage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return j.clear()
+}
+
+// load reads the operation stored in the journal, false is returned if the journal is incomplete
+func (j *shadowJournal) load(storage Storage) (*shadowStorage, bool, error) {
+	stat, err := j.file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	data := make([]byte, stat.Size())
+	_, err = j.file.ReadAt(data, 0)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s, ok := decodeShadowStorage(data, storage)
+	return s, ok, nil
+}
+
+// publish writes the operation's pages to the journal, then to their real locations, then clears the journal
+func (j *shadowJournal) publish(storage Storage, s *shadowStorage) error {
+	if s.empty() {
+		return nil
+	}
+
+	// the allocated pages are referenced once the operation is published, replay has nothing to reclaim
+	err := j.write(s.withoutAllocated())
+	if err != nil {
+		return err
+	}
+
+	err = s.apply(storage)
+	if err != nil {
+		return err
+	}
+
+	return j.clear()
+}
+
+// withoutAllocated returns the operation without the pages it allocated
+func (s *shadowStorage) withoutAllocated() *shadowStorage {
+	return &shadowStorage{Storage: s.Storage, pages: s.pages, order: s.order, freed: s.freed}
+}
+
+// write durably stores the operation in the journal, once written the operation is published
+func (j *shadowJournal) write(s *shadowStorage) error {
+	_, err := j.file.WriteAt(s.encode(), 0)
+	if err != nil {
+		return err
+	}
+
+	return j.file.Sync()
+}
+
+// apply writes the operation's pages to their real locations and frees the pages it freed
+// Applying an operation again after a crash is harmless
+func (s *shadowStorage) apply(storage Storage) error {
+	for _, pageID := range s.order {
+		err := storage.WritePage(pageID, s.pages[pageID])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pageID := range s.freed {
+		err := storage.Free(pageID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return storage.Sync()
+}
+
+// clear empties the journal
+func (j *shadowJournal) clear() error {
+	err := j.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Close closes the journal
+func (j *shadowJournal) Close() error {
+	return j.file.Close()
+}
+
+// atomic runs fn publishing every page it writes together when safe writes are enabled
+func (b *BTree) atomic(fn func() error) error {
+	// other writes exclude concurrent puts
+	if b.latches != nil {
+		b.latches.tree.Lock()
+		defer b.latches.tree.Unlock()
+	}
+
+	if b.closed.Load() {
+		return ErrClosed
+	}
+
+	if !b.HasLease() {
+		return ErrReadOnly
+	}
+
+	if b.ingest.Load() != nil {
+		return ErrIngesting
+	}
+
+	if b.txn != nil {
+		if b.txn.prepared {
+			return ErrInDoubt
+		}
+
+		// the transaction buffers every write until it is committed
+		return fn()
+	}
+
+	return b.published(func() error {
+		if b.journal == nil {
+			return b.settleRangeTombstones(fn())
+		}
+
+		storage := b.Pager
+		shadow := newShadowStorage(storage)
+
+		b.Pager = shadow
+		err := fn()
+		b.Pager = storage
+
+		if err != nil {
+			// nothing was published, the operation's pages are discarded and the pages it allocated freed
+			b.replaced()
+			for _, pageID := range shadow.allocated {
+				freeErr := storage.Free(pageID)
+				if freeErr != nil {
+					return b.settleRangeTombstones(errors.Join(err, freeErr))
+				}
+			}
+			return b.settleRangeTombstones(err)
+		}
+
+		return b.settleRangeTombstones(b.journal.publish(storage, shadow))
+	})
+}
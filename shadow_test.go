@@ -0,0 +1,244 @@
+// Package btree
+// shadow paging tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// crashingStorage fails every page write, simulating a crash after the journal is durable
+type crashingStorage struct {
+	Storage
+}
+
+func (c *crashingStorage) WritePage(pageID int64, data []byte) error {
+	return errors.New("crash")
+}
+
+func TestBTree_SafeWrites(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.shadow")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{SafeWrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Delete([]byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected key 0 to be deleted")
+	}
+
+	for i := 1; i < 500; i++ {
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected value to be %d", i)
+		}
+	}
+
+	stat, err := os.Stat("btree.db.shadow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Size() != 0 {
+		t.Fatalf("expected the journal to be empty, got %d bytes", stat.Size())
+	}
+}
+
+func TestShadowJournal_Recover(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.shadow")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pager.Write([]byte("old 0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pager.Write([]byte("old 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := openShadowJournal("btree.db.shadow", 0644, pager, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shadow := &shadowStorage{Storage: pager, pages: make(map[int64][]byte)}
+	shadow.WritePage(0, []byte("new 0"))
+	shadow.WritePage(1, []byte("new 1"))
+
+	err = journal.publish(&crashingStorage{pager}, shadow)
+	if err == nil {
+		t.Fatal("expected the publish to crash")
+	}
+
+	journal.Close()
+
+	// recovery replays the whole operation
+	journal, err = openShadowJournal("btree.db.shadow", 0644, pager, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	defer pager.Close()
+
+	for i := int64(0); i < 2; i++ {
+		data, err := pager.GetPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(bytes.Trim(data, "\x00")) != "new "+strconv.Itoa(int(i)) {
+			t.Fatalf("expected page %d to be replayed, got %s", i, bytes.Trim(data, "\x00"))
+		}
+	}
+}
+
+func TestShadowJournal_Incomplete(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.shadow")
+
+	pager, err := OpenPager("btree.db", os.O_CREATE|os.O_RDWR, 0644, time.Millisecond*128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	_, err = pager.Write([]byte("old 0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn journal write
+	err = os.WriteFile("btree.db.shadow", []byte("torn journal write"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := openShadowJournal("btree.db.shadow", 0644, pager, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	data, err := pager.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bytes.Trim(data, "\x00")) != "old 0" {
+		t.Fatalf("expected page 0 to be untouched, got %s", bytes.Trim(data, "\x00"))
+	}
+}
+
+func TestBTree_SafeWrites_FailedFreesAllocated(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.shadow")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{SafeWrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	pager := btree.Pager.(*Pager)
+	free := pager.FreePageCount()
+
+	failed := errors.New("failed")
+	err = btree.atomic(func() error {
+		for i := 0; i < 3; i++ {
+			_, err := btree.Pager.Allocate([]byte("orphan"))
+			if err != nil {
+				return err
+			}
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the operation to fail, got %v", err)
+	}
+
+	if pager.FreePageCount() != free+3 {
+		t.Fatalf("expected the 3 allocated pages to be freed, got %d free pages", pager.FreePageCount()-free)
+	}
+
+	// the pages of a published operation are referenced, the journal does not record them as allocated
+	shadow := newShadowStorage(pager)
+	_, err = shadow.Allocate([]byte("node"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := decodeShadowStorage(shadow.withoutAllocated().encode(), pager)
+	if !ok || len(s.allocated) != 0 {
+		t.Fatalf("expected no allocated pages in the journal, got %v", s.allocated)
+	}
+}
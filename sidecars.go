@@ -0,0 +1,194 @@
+// Package btree
+// files stored next to the btree file
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"os"
+	"strconv"
+)
+
+// sidecar is a file stored next to the btree file which a copy of the btree or Truncate has to deal with
+// Journals, locks and caches rebuilt on open are left out, see Format for every file
+type sidecar struct {
+	suffix string
+
+	// contents returns the file a copy needs to be read like the btree, false if the copy needs none
+	// Files with contents are written by SaveTo and carried by BackupIncremental.
+	contents func(b *BTree) ([]byte, bool)
+
+	// save writes a file which is not copied as a whole to name, it is written by SaveTo but not carried by backups
+	save func(b *BTree, name string) error
+
+	// clear empties the file once Truncate deleted every key, nil if the file does not describe keys
+	clear func(b *BTree) error
+}
+
+// sidecars returns the files SaveTo, BackupIncremental, RestoreIncremental and Truncate handle, in the order they are written
+func sidecars() []sidecar {
+	return []sidecar{
+		{
+			// pages of another layout cannot be read with the legacy layout
+			suffix: ".format",
+			contents: func(b *BTree) ([]byte, bool) {
+				return CurrentLayout().encode(), CurrentLayout() != legacyLayout
+			},
+		},
+		{
+			// compressed nodes cannot be read without the dictionary
+			suffix: ".dict",
+			contents: func(b *BTree) ([]byte, bool) {
+				return b.Dictionary(), b.compressor != nil
+			},
+		},
+		{
+			suffix: ".keysize",
+			contents: func(b *BTree) ([]byte, bool) {
+				return []byte(strconv.Itoa(b.fixedKeySize) + "\n"), b.fixedKeySize > 0
+			},
+		},
+		{
+			// nodes of other codecs cannot be read as msgpack
+			suffix: ".codec",
+			contents: func(b *BTree) ([]byte, bool) {
+				return []byte(b.NodeCodec() + "\n"), b.NodeCodec() != DefaultNodeCodec
+			},
+		},
+		{
+			// the stored keys were transformed, the copy must be opened with the same transform
+			suffix: ".transform",
+			contents: func(b *BTree) ([]byte, bool) {
+				if b.keyTransform == nil {
+					return nil, false
+				}
+				return []byte(b.keyTransform.Name() + "\n"), true
+			},
+		},
+		{
+			// keys within a deleted range are still stored until they are purged
+			suffix: ".rangedel",
+			contents: func(b *BTree) ([]byte, bool) {
+				if b.rangeDels == nil {
+					return nil, false
+				}
+
+				b.rangeDels.lock.RLock()
+				defer b.rangeDels.lock.RUnlock()
+
+				return encodeRanges(b.rangeDels.committed), len(b.rangeDels.committed) > 0
+			},
+			clear: func(b *BTree) error {
+				if b.rangeDels == nil {
+					return nil
+				}
+
+				b.rangeDels.lock.Lock()
+				b.rangeDels.ranges = nil
+				b.rangeDels.lock.Unlock()
+
+				return b.rangeDels.settle(nil)
+			},
+		},
+		{
+			// keys with a ttl are only found by Sweep through the expiry index
+			suffix: ".ttl",
+			save: func(b *BTree, name string) error {
+				if b.expiry == nil {
+					return nil
+				}
+				return b.expiry.SaveTo(name)
+			},
+			clear: func(b *BTree) error {
+				if b.expiry == nil {
+					return nil
+				}
+				return b.expiry.Truncate()
+			},
+		},
+		{
+			suffix: ".vlog",
+			save: func(b *BTree, name string) error {
+				if b.ValueLog == nil {
+					return nil
+				}
+				return b.ValueLog.SaveTo(name)
+			},
+			clear: func(b *BTree) error {
+				if b.ValueLog == nil {
+					return nil
+				}
+				return b.ValueLog.truncate()
+			},
+		},
+	}
+}
+
+// copiedFiles returns the contents of the files a copy of the btree needs by suffix
+func (b *BTree) copiedFiles() map[string][]byte {
+	files := make(map[string][]byte)
+	for _, s := range sidecars() {
+		if s.contents == nil {
+			continue
+		}
+
+		if data, ok := s.contents(b); ok {
+			files[s.suffix] = data
+		}
+	}
+
+	return files
+}
+
+// writeCopiedFiles writes files next to the copy at name, a copied file the copy does not need is removed
+// so a file left behind by an earlier copy does not apply to this one
+func writeCopiedFiles(name string, files map[string][]byte, perm os.FileMode) error {
+	for _, s := range sidecars() {
+		if s.contents == nil {
+			continue
+		}
+
+		data, ok := files[s.suffix]
+		if !ok {
+			err := os.Remove(name + s.suffix)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		err := os.WriteFile(name+s.suffix, data, perm)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package btree
+// files stored next to the btree file tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestBTree_Sidecars(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("sidecars*.db*")
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}()
+
+	dict := []byte("key value key value")
+
+	btree, err := OpenWithOptions("sidecars.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Dictionary: dict})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(strconv.Itoa(i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.TombstoneRange([]byte("2"), []byte("3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file left behind by an earlier copy does not apply to this one
+	err = os.WriteFile("sidecars_saved.db.keysize", []byte("8\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.SaveTo("sidecars_saved.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := new(bytes.Buffer)
+	_, err = btree.BackupIncremental(backup, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = RestoreIncremental(backup, "sidecars_restored.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"sidecars_saved.db", "sidecars_restored.db"} {
+		copied, err := OpenWithOptions(name, os.O_RDWR, 0644, 3, &Options{Dictionary: dict})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the deleted range is carried with the pages
+		for _, k := range []string{"2", "25", "3", "4"} {
+			key, err := copied.Get([]byte(k))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (key == nil) != (k != "4") {
+				t.Fatalf("expected only key 4 outside of the deleted range in %s, got %v for key %s", name, key, k)
+			}
+		}
+
+		err = copied.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deleted range went with the keys
+	err = btree.Put([]byte("25"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("25"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil {
+		t.Fatal("expected a key put after Truncate to be found")
+	}
+}
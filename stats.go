@@ -0,0 +1,303 @@
+// Package btree
+// statistics
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// SIZE_HISTOGRAM_BUCKETS is the number of buckets of a SizeHistogram
+const SIZE_HISTOGRAM_BUCKETS = 33
+
+// Stats are counters describing the work done by a tree since it was opened or the stats were reset
+type Stats struct {
+	Puts                 uint64        // Values inserted
+	UserBytes            uint64        // Bytes of keys and values inserted
+	NodeWrites           uint64        // Nodes written, including rewrites caused by splits and merges
+	PagesWritten         uint64        // Pages written by the pager, including overflow pages
+	OverflowPagesWritten uint64        // Overflow pages written by the pager
+	BytesWritten         uint64        // Bytes written to the page file
+	PagesRead            uint64        // Pages read by the pager, including overflow pages
+	BytesRead            uint64        // Bytes read from the page file
+	ValueLogBytesWritten uint64        // Bytes appended to the value log
+	WriteAmplification   float64       // Bytes written to the page file and value log per byte inserted
+	PagesPerPut          float64       // Pages written per value inserted
+	KeySizes             SizeHistogram // Sizes of the key of every value inserted, less those deleted or removed
+	ValueSizes           SizeHistogram // Sizes of the values inserted, less those deleted or removed
+	AuditDropped         uint64        // Audit records dropped because the sink fell behind, see Options.Audit
+	FreeNodePages        uint64        // Deleted node pages waiting to be reused, not reset by ResetStats
+	FreeOverflowPages    uint64        // Deleted overflow pages waiting to be reused, not reset by ResetStats
+
+	Namespaces map[string]NamespaceStats // Usage of the namespaces by prefix, nil until a namespace is opened, not reset by ResetStats
+}
+
+// SizeHistogram counts sizes in power of two buckets
+// Bucket 0 counts empty keys or values, bucket i counts sizes from 2^(i-1) to 2^i-1 and the last bucket every larger size.
+// Sizes deleted or removed which were inserted before the stats were reset are not subtracted below zero, the histograms are approximate.
+type SizeHistogram struct {
+	Buckets [SIZE_HISTOGRAM_BUCKETS]uint64 // Number of sizes in every bucket
+	Count   uint64                         // Number of sizes in all buckets
+	Bytes   uint64                         // Sum of the sizes
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th percentile of the sizes, p is within [0, 100]
+func (h SizeHistogram) Percentile(p float64) uint64 {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := max(uint64(math.Ceil(p/100*float64(h.Count))), 1)
+
+	seen := uint64(0)
+	for i, n := range h.Buckets {
+		seen += n
+		if seen >= rank {
+			return bucketLimit(i)
+		}
+	}
+
+	return bucketLimit(SIZE_HISTOGRAM_BUCKETS - 1)
+}
+
+// bucketLimit returns the largest size counted in bucket i
+func bucketLimit(i int) uint64 {
+	if i == SIZE_HISTOGRAM_BUCKETS-1 {
+		return math.MaxUint64
+	}
+	return 1<<i - 1
+}
+
+// PagerStats are the i/o counters of a pager
+type PagerStats struct {
+	PagesWritten         uint64 // Pages written, including overflow pages
+	OverflowPagesWritten uint64 // Overflow pages written
+	BytesWritten         uint64 // Bytes written to the page file
+	PagesRead            uint64 // Pages read, including overflow pages
+	BytesRead            uint64 // Bytes read from the page file
+}
+
+// treeCounters are the counters of a tree
+type treeCounters struct {
+	puts       atomic.Uint64
+	userBytes  atomic.Uint64
+	nodeWrites atomic.Uint64
+	keySizes   sizeHistogram
+	valueSizes sizeHistogram
+}
+
+// sizeHistogram is the histogram of SizeHistogram updated atomically
+type sizeHistogram struct {
+	buckets [SIZE_HISTOGRAM_BUCKETS]atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+// pagerCounters are the i/o counters of a pager
+type pagerCounters struct {
+	pagesWritten         atomic.Uint64
+	overflowPagesWritten atomic.Uint64
+	bytesWritten         atomic.Uint64
+	pagesRead            atomic.Uint64
+	bytesRead            atomic.Uint64
+}
+
+// add counts a size
+func (h *sizeHistogram) add(size int) {
+	h.buckets[sizeBucket(size)].Add(1)
+	h.bytes.Add(uint64(size))
+}
+
+// remove uncounts a size, the counters stay at zero for sizes counted before the histogram was reset
+func (h *sizeHistogram) remove(size int) {
+	decrement(&h.buckets[sizeBucket(size)], 1)
+	decrement(&h.bytes, uint64(size))
+}
+
+// snapshot returns the counts of the histogram
+func (h *sizeHistogram) snapshot() SizeHistogram {
+	var s SizeHistogram
+	for i := range h.buckets {
+		s.Buckets[i] = h.buckets[i].Load()
+		s.Count += s.Buckets[i]
+	}
+	s.Bytes = h.bytes.Load()
+	return s
+}
+
+// reset sets the counts of the histogram to zero
+func (h *sizeHistogram) reset() {
+	for i := range h.buckets {
+		h.buckets[i].Store(0)
+	}
+	h.bytes.Store(0)
+}
+
+// sizeBucket returns the bucket of a size
+func sizeBucket(size int) int {
+	return min(bits.Len(uint(size)), SIZE_HISTOGRAM_BUCKETS-1)
+}
+
+// decrement subtracts n from a counter without going below zero
+func decrement(c *atomic.Uint64, n uint64) {
+	for {
+		old := c.Load()
+		if c.CompareAndSwap(old, old-min(old, n)) {
+			return
+		}
+	}
+}
+
+// countPut counts a value inserted into a key
+func (b *BTree) countPut(key, value []byte) {
+	b.stats.puts.Add(1)
+	b.stats.userBytes.Add(uint64(len(key) + len(value)))
+	b.stats.keySizes.add(len(key))
+	b.stats.valueSizes.add(len(value))
+}
+
+// countRemoved uncounts the value at index i of a key which is deleted or removed
+func (b *BTree) countRemoved(k *Key, i int) {
+	b.stats.keySizes.remove(len(k.K))
+	b.stats.valueSizes.remove(valueLength(k, i))
+}
+
+// valueLength returns the length of the value at index i of a key without reading it from the value log
+func valueLength(k *Key, i int) int {
+	if i < len(k.Ptr) && k.Ptr[i] {
+		_, length, err := decodeValuePointer(k.V[i])
+		if err == nil {
+			return length
+		}
+	}
+	return len(k.V[i])
+}
+
+// Stats returns the i/o counters of the pager
+func (p *Pager) Stats() PagerStats {
+	return PagerStats{
+		PagesWritten:         p.stats.pagesWritten.Load(),
+		OverflowPagesWritten: p.stats.overflowPagesWritten.Load(),
+		BytesWritten:         p.stats.bytesWritten.Load(),
+		PagesRead:            p.stats.pagesRead.Load(),
+		BytesRead:            p.stats.bytesRead.Load(),
+	}
+}
+
+// ResetStats sets the i/o counters of the pager to zero
+func (p *Pager) ResetStats() {
+	p.stats.pagesWritten.Store(0)
+	p.stats.overflowPagesWritten.Store(0)
+	p.stats.bytesWritten.Store(0)
+	p.stats.pagesRead.Store(0)
+	p.stats.bytesRead.Store(0)
+}
+
+// Stats returns the counters of the tree
+// Page counters are only available when the tree is stored by a Pager
+func (b *BTree) Stats() Stats {
+	stats := Stats{
+		Puts:       b.stats.puts.Load(),
+		UserBytes:  b.stats.userBytes.Load(),
+		NodeWrites: b.stats.nodeWrites.Load(),
+		KeySizes:   b.stats.keySizes.snapshot(),
+		ValueSizes: b.stats.valueSizes.snapshot(),
+	}
+
+	if pager, ok := b.Pager.(*Pager); ok {
+		ps := pager.Stats()
+		stats.PagesWritten = ps.PagesWritten
+		stats.OverflowPagesWritten = ps.OverflowPagesWritten
+		stats.BytesWritten = ps.BytesWritten
+		stats.PagesRead = ps.PagesRead
+		stats.BytesRead = ps.BytesRead
+
+		pager.deletedPagesLock.Lock()
+		nodePages, overflowPages := pager.freeClassCounts()
+		pager.deletedPagesLock.Unlock()
+
+		stats.FreeNodePages = uint64(nodePages)
+		stats.FreeOverflowPages = uint64(overflowPages)
+	}
+
+	if b.ValueLog != nil {
+		stats.ValueLogBytesWritten = b.ValueLog.bytesWritten.Load()
+	}
+
+	if b.auditor != nil {
+		stats.AuditDropped = b.auditor.dropped.Load()
+	}
+
+	if stats.UserBytes > 0 {
+		stats.WriteAmplification = float64(stats.BytesWritten+stats.ValueLogBytesWritten) / float64(stats.UserBytes)
+	}
+
+	if stats.Puts > 0 {
+		stats.PagesPerPut = float64(stats.PagesWritten) / float64(stats.Puts)
+	}
+
+	b.namespacesLock.Lock()
+	t := b.namespaces
+	b.namespacesLock.Unlock()
+
+	if t != nil {
+		t.lock.Lock()
+		stats.Namespaces = make(map[string]NamespaceStats, len(t.usage))
+		for prefix, u := range t.usage {
+			stats.Namespaces[prefix] = *u
+		}
+		t.lock.Unlock()
+	}
+
+	return stats
+}
+
+// ResetStats sets all counters of the tree to zero, i.e. to measure a workload on its own
+func (b *BTree) ResetStats() {
+	b.stats.puts.Store(0)
+	b.stats.userBytes.Store(0)
+	b.stats.nodeWrites.Store(0)
+	b.stats.keySizes.reset()
+	b.stats.valueSizes.reset()
+
+	if pager, ok := b.Pager.(*Pager); ok {
+		pager.ResetStats()
+	}
+
+	if b.ValueLog != nil {
+		b.ValueLog.bytesWritten.Store(0)
+	}
+
+	if b.auditor != nil {
+		b.auditor.dropped.Store(0)
+	}
+}
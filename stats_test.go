@@ -0,0 +1,202 @@
+// Package btree
+// statistics tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_Stats(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := btree.Stats()
+
+	if stats.Puts != 100 || stats.UserBytes != 800 {
+		t.Fatalf("expected 100 puts of 800 bytes, got %d puts of %d bytes", stats.Puts, stats.UserBytes)
+	}
+
+	if stats.NodeWrites < 100 || stats.PagesWritten < stats.NodeWrites {
+		t.Fatalf("unexpected node writes %d and page writes %d", stats.NodeWrites, stats.PagesWritten)
+	}
+
+	if stats.BytesWritten != stats.PagesWritten*(PAGE_SIZE+HEADER_SIZE) {
+		t.Fatalf("expected whole pages to be written, got %d bytes for %d pages", stats.BytesWritten, stats.PagesWritten)
+	}
+
+	if stats.WriteAmplification <= 1 || stats.PagesPerPut < 1 {
+		t.Fatalf("unexpected write amplification %f and pages per put %f", stats.WriteAmplification, stats.PagesPerPut)
+	}
+
+	btree.ResetStats()
+
+	stats = btree.Stats()
+	if stats.Puts != 0 || stats.PagesWritten != 0 || stats.WriteAmplification != 0 {
+		t.Fatalf("expected reset stats, got %+v", stats)
+	}
+
+	// large values go to the value log, a node larger than a page writes overflow pages
+	err = btree.Put([]byte("large"), bytes.Repeat([]byte("x"), 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("000"), bytes.Repeat([]byte("y"), 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("001"), bytes.Repeat([]byte("y"), 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats = btree.Stats()
+
+	if stats.ValueLogBytesWritten != 1024+VLOG_HEADER_SIZE {
+		t.Fatalf("expected %d bytes in the value log, got %d", 1024+VLOG_HEADER_SIZE, stats.ValueLogBytesWritten)
+	}
+
+	if stats.OverflowPagesWritten == 0 {
+		t.Fatal("expected overflow pages to be written")
+	}
+
+	_, err = btree.Get([]byte("050"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats = btree.Stats()
+	if stats.PagesRead == 0 || stats.BytesRead == 0 {
+		t.Fatal("expected page reads to be counted")
+	}
+}
+
+func TestBTree_Stats_SizeHistograms(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 10; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a giant value stands out in the last buckets, it is stored in the value log
+	err = btree.Put([]byte("large"), bytes.Repeat([]byte("x"), 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := btree.Stats()
+
+	if stats.KeySizes.Count != 11 || stats.KeySizes.Buckets[2] != 10 || stats.KeySizes.Buckets[3] != 1 {
+		t.Fatalf("unexpected key sizes %+v", stats.KeySizes)
+	}
+
+	if stats.ValueSizes.Count != 11 || stats.ValueSizes.Buckets[3] != 10 || stats.ValueSizes.Buckets[13] != 1 || stats.ValueSizes.Bytes != 50+4096 {
+		t.Fatalf("unexpected value sizes %+v", stats.ValueSizes)
+	}
+
+	if p := stats.ValueSizes.Percentile(50); p != 7 {
+		t.Fatalf("expected a median below 8 bytes, got %d", p)
+	}
+
+	if p := stats.ValueSizes.Percentile(100); p != 8191 {
+		t.Fatalf("expected a maximum below 8192 bytes, got %d", p)
+	}
+
+	err = btree.Delete([]byte("large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("000"), []byte("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Remove([]byte("000"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats = btree.Stats()
+
+	if stats.ValueSizes.Count != 10 || stats.ValueSizes.Buckets[13] != 0 || stats.ValueSizes.Buckets[1] != 1 || stats.ValueSizes.Bytes != 46 {
+		t.Fatalf("unexpected value sizes after deletes %+v", stats.ValueSizes)
+	}
+
+	// keys written before a reset are not subtracted below zero
+	btree.ResetStats()
+
+	err = btree.Delete([]byte("001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats = btree.Stats()
+	if stats.KeySizes.Count != 0 || stats.ValueSizes.Bytes != 0 {
+		t.Fatalf("expected empty histograms, got %+v and %+v", stats.KeySizes, stats.ValueSizes)
+	}
+
+	if stats.ValueSizes.Percentile(99) != 0 {
+		t.Fatal("expected no percentile of an empty histogram")
+	}
+}
@@ -0,0 +1,150 @@
+// Package btree
+// strict mode
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNilKeySlot is returned in strict mode for a node holding a nil key
+var ErrNilKeySlot = errors.New("node holds a nil key slot")
+
+// ErrDanglingChild is returned in strict mode for a child pointer to a page which does not exist, or children which do not match the keys of a node
+var ErrDanglingChild = errors.New("node holds a dangling child pointer")
+
+// ErrInconsistentChild is returned with Options.VerifyChildren for a child pointer outside of the storage or to a node whose keys do not lie between the separators around the pointer
+var ErrInconsistentChild = errors.New("node holds an inconsistent child pointer")
+
+// checkKeySlots returns an error if the node holds a nil key
+// Without Options.Strict nil keys are dropped when a node is next modified.
+func checkKeySlots(n *Node) error {
+	for i, k := range n.Keys {
+		if k == nil {
+			return fmt.Errorf("%w: page %d slot %d", ErrNilKeySlot, n.Page, i)
+		}
+	}
+	return nil
+}
+
+// checkSlots returns an error if the node holds a nil key or its children do not match its keys
+// Nodes are only checked as a whole once read, a node being split or merged is written before it gets its keys and children.
+func checkSlots(n *Node) error {
+	err := checkKeySlots(n)
+	if err != nil {
+		return err
+	}
+
+	// an internal node has a child on either side of every key, a leaf has none
+	children := 0
+	if !n.Leaf {
+		children = len(n.Keys) + 1
+	}
+
+	if len(n.Children) != children {
+		return fmt.Errorf("%w: page %d holds %d keys and %d children", ErrDanglingChild, n.Page, len(n.Keys), len(n.Children))
+	}
+
+	return nil
+}
+
+// strictRead checks a node read from page in strict mode
+// A page beyond the end of the storage is a dangling child, page 0 is the root which is created on first use.
+func (b *BTree) strictRead(page int64, n *Node, err error) (*Node, error) {
+	if errors.Is(err, io.EOF) && page != 0 {
+		return nil, fmt.Errorf("%w: page %d: %w", ErrDanglingChild, page, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkSlots(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// descendChild reads child i of x, a node depth levels deep
+// With Options.VerifyChildren the child must be a page of the storage other than the root and every key of the child
+// must lie between the keys of x on either side of the pointer.
+func (b *BTree) descendChild(x *Node, i, depth int) (*Node, error) {
+	page := x.Children[i]
+	if !b.verifyPtrs {
+		return b.descend(page, depth)
+	}
+
+	if page <= 0 {
+		return nil, fmt.Errorf("%w: page %d child %d points to page %d", ErrInconsistentChild, x.Page, i, page)
+	}
+
+	child, err := b.descend(page, depth)
+	if errors.Is(err, io.EOF) || errors.Is(err, ErrPageNotFound) {
+		return nil, fmt.Errorf("%w: page %d child %d points to page %d outside of the storage: %w", ErrInconsistentChild, x.Page, i, page, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkChildRange(x, i, child)
+	if err != nil {
+		return nil, err
+	}
+
+	return child, nil
+}
+
+// checkChildRange returns an error if a key of child i of x does not lie between the separators of x around it
+// Nil keys are skipped, see checkKeySlots.
+func checkChildRange(x *Node, i int, child *Node) error {
+	var low, high *Key
+	if i > 0 && i-1 < len(x.Keys) {
+		low = x.Keys[i-1]
+	}
+	if i < len(x.Keys) {
+		high = x.Keys[i]
+	}
+
+	for _, k := range child.Keys {
+		if k == nil {
+			continue
+		}
+
+		if (low != nil && !greaterThan(k.K, low.K)) || (high != nil && !lessThan(k.K, high.K)) {
+			return fmt.Errorf("%w: page %d child %d at page %d holds key %q outside of its separators", ErrInconsistentChild, x.Page, i, child.Page, k.K)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+// Package btree
+// strict mode tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestBTree_Strict(t *testing.T) {
+	// corrupt rewrites the root of a fresh btree of 20 keys, the root is an internal node
+	open := func(corrupt func(root *Node)) *BTree {
+		btree, err := OpenMemory(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 20; i++ {
+			err = btree.Put([]byte(fmt.Sprintf("%02d", i)), []byte("value"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		root, err := btree.getRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if root.Leaf {
+			t.Fatal("expected an internal root")
+		}
+
+		corrupt(root)
+
+		encoded, err := encodeNode(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = btree.Pager.WritePage(0, encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		btree.strict = true
+		return btree
+	}
+
+	btree := open(func(root *Node) {
+		root.Keys = append(root.Keys, nil)
+		root.Children = append(root.Children, root.Children[0])
+	})
+
+	_, err := btree.Get([]byte("00"))
+	if !errors.Is(err, ErrNilKeySlot) {
+		t.Fatalf("expected ErrNilKeySlot, got %v", err)
+	}
+	btree.Close()
+
+	// a child lost by a split written halfway
+	btree = open(func(root *Node) {
+		root.Children = root.Children[:len(root.Children)-1]
+	})
+
+	_, err = btree.Get([]byte("00"))
+	if !errors.Is(err, ErrDanglingChild) {
+		t.Fatalf("expected ErrDanglingChild, got %v", err)
+	}
+	btree.Close()
+
+	// a child beyond the end of the storage
+	btree = open(func(root *Node) {
+		root.Children[0] = 1000
+	})
+
+	_, err = btree.Get([]byte("00"))
+	if !errors.Is(err, ErrDanglingChild) || !errors.Is(err, io.EOF) {
+		t.Fatalf("expected ErrDanglingChild wrapping io.EOF, got %v", err)
+	}
+
+	_, err = btree.Get([]byte("19"))
+	if err != nil {
+		t.Fatalf("expected the other children to be readable, got %v", err)
+	}
+
+	// a nil key is not written
+	root, err := btree.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root.Keys[0] = nil
+	err = btree.writeNode(root)
+	if !errors.Is(err, ErrNilKeySlot) {
+		t.Fatalf("expected ErrNilKeySlot, got %v", err)
+	}
+	btree.Close()
+
+	// without strict mode the child is read past
+	btree = open(func(root *Node) {
+		root.Children = root.Children[:len(root.Children)-1]
+	})
+	btree.strict = false
+
+	_, err = btree.Get([]byte("00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	btree.Close()
+}
+
+func TestBTree_VerifyChildren(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	// corrupt rewrites the root of a fresh btree of 20 keys, the root is an internal node
+	open := func(corrupt func(root *Node)) *BTree {
+		os.Remove("btree.db")
+		os.Remove("btree.db.del")
+
+		btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{VerifyChildren: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 20; i++ {
+			err = btree.Put([]byte(fmt.Sprintf("%02d", i)), []byte("value"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		root, err := btree.getRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if root.Leaf || len(root.Children) < 2 {
+			t.Fatal("expected an internal root")
+		}
+
+		corrupt(root)
+
+		err = btree.writeNode(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return btree
+	}
+
+	// swapped children decode fine but hold the keys of the other side of the separator
+	btree := open(func(root *Node) {
+		root.Children[0], root.Children[1] = root.Children[1], root.Children[0]
+	})
+
+	_, err := btree.Get([]byte("00"))
+	if !errors.Is(err, ErrInconsistentChild) {
+		t.Fatalf("expected ErrInconsistentChild, got %v", err)
+	}
+
+	_, err = btree.InOrderTraversal()
+	if !errors.Is(err, ErrInconsistentChild) {
+		t.Fatalf("expected ErrInconsistentChild, got %v", err)
+	}
+
+	// without verification the lookup silently misses the key
+	btree.verifyPtrs = false
+
+	key, err := btree.Get([]byte("00"))
+	if err != nil || key != nil {
+		t.Fatalf("expected the key to be missed, got %v %v", key, err)
+	}
+	btree.Close()
+
+	// a child beyond the end of the file
+	btree = open(func(root *Node) {
+		root.Children[0] = 1000
+	})
+
+	_, err = btree.Get([]byte("00"))
+	if !errors.Is(err, ErrInconsistentChild) {
+		t.Fatalf("expected ErrInconsistentChild, got %v", err)
+	}
+
+	key, err = btree.Get([]byte("19"))
+	if err != nil || key == nil {
+		t.Fatalf("expected the other children to be readable, got %v %v", key, err)
+	}
+	btree.Close()
+
+	// a child pointing back to the root
+	btree = open(func(root *Node) {
+		root.Children[0] = 0
+	})
+	defer btree.Close()
+
+	_, err = btree.Get([]byte("00"))
+	if !errors.Is(err, ErrInconsistentChild) {
+		t.Fatalf("expected ErrInconsistentChild, got %v", err)
+	}
+}
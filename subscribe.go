@@ -0,0 +1,190 @@
+// Package btree
+// range subscriptions
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Change describes a successful Put, PutMulti, Delete or Remove delivered to subscribers
+type Change struct {
+	Op   AuditOp // The operation
+	Key  []byte  // The key as stored, after Options.KeyTransform
+	Size int     // Bytes of the values put or removed, 0 for a delete
+}
+
+// subscription is the interest of a subscriber in the keys from start (inclusive) to end (exclusive)
+type subscription struct {
+	start []byte       // The first key of the range
+	end   []byte       // The key after the range, nil for no upper bound
+	fn    func(Change) // Called for every change within the range
+}
+
+// contains returns true if key is within the range of s
+func (s *subscription) contains(key []byte) bool {
+	return bytes.Compare(key, s.start) >= 0 && (s.end == nil || bytes.Compare(key, s.end) < 0)
+}
+
+// subscriptionIndex is an immutable interval index over subscriptions
+// The subscriptions are sorted by start and maxEnd[i] is the greatest end of subs[:i+1], so a lookup
+// finds the last subscription starting at or before a key and walks back only while an earlier range can still reach it.
+type subscriptionIndex struct {
+	subs   []*subscription
+	maxEnd [][]byte // nil for no upper bound
+}
+
+// newSubscriptionIndex builds the index of subs
+func newSubscriptionIndex(subs []*subscription) *subscriptionIndex {
+	sort.SliceStable(subs, func(i, j int) bool {
+		return bytes.Compare(subs[i].start, subs[j].start) < 0
+	})
+
+	idx := &subscriptionIndex{subs: subs, maxEnd: make([][]byte, len(subs))}
+	for i, s := range subs {
+		switch {
+		case i == 0 || s.end == nil:
+			idx.maxEnd[i] = s.end
+		case idx.maxEnd[i-1] == nil || bytes.Compare(idx.maxEnd[i-1], s.end) >= 0:
+			idx.maxEnd[i] = idx.maxEnd[i-1]
+		default:
+			idx.maxEnd[i] = s.end
+		}
+	}
+
+	return idx
+}
+
+// match calls fn for every subscription containing key
+func (idx *subscriptionIndex) match(key []byte, fn func(s *subscription)) {
+	i := sort.Search(len(idx.subs), func(i int) bool {
+		return bytes.Compare(idx.subs[i].start, key) > 0
+	}) - 1
+
+	for ; i >= 0; i-- {
+		if idx.maxEnd[i] != nil && bytes.Compare(idx.maxEnd[i], key) <= 0 {
+			return
+		}
+
+		if idx.subs[i].contains(key) {
+			fn(idx.subs[i])
+		}
+	}
+}
+
+// subscriptions holds the subscriptions of a btree
+// Writers read the index without locking, subscribing and cancelling replace it under lock.
+type subscriptions struct {
+	lock  sync.Mutex
+	index atomic.Pointer[subscriptionIndex]
+}
+
+// add adds s to the index
+func (t *subscriptions) add(s *subscription) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	subs := []*subscription{s}
+	if idx := t.index.Load(); idx != nil {
+		subs = append(subs, idx.subs...)
+	}
+
+	t.index.Store(newSubscriptionIndex(subs))
+}
+
+// remove removes s from the index, removing it twice does nothing
+func (t *subscriptions) remove(s *subscription) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	idx := t.index.Load()
+	if idx == nil {
+		return
+	}
+
+	subs := make([]*subscription, 0, len(idx.subs))
+	for _, other := range idx.subs {
+		if other != s {
+			subs = append(subs, other)
+		}
+	}
+
+	if len(subs) == 0 {
+		t.index.Store(nil)
+		return
+	}
+
+	t.index.Store(newSubscriptionIndex(subs))
+}
+
+// Subscribe calls fn for every successful change to a key from start (inclusive) to end (exclusive), a nil end means no upper bound
+// fn runs synchronously on the goroutine of the change once the change is done, so it should return quickly.
+// The returned function cancels the subscription.
+func (b *BTree) Subscribe(start, end []byte, fn func(c Change)) (cancel func()) {
+	s := &subscription{start: b.transformKey(start), end: b.transformKey(end), fn: fn}
+	b.subs.add(s)
+
+	return func() {
+		b.subs.remove(s)
+	}
+}
+
+// SubscribePrefix calls fn for every successful change to a key starting with prefix, see Subscribe
+// The prefix is matched against stored keys so it is only meaningful with an order preserving Options.KeyTransform.
+func (b *BTree) SubscribePrefix(prefix []byte, fn func(c Change)) (cancel func()) {
+	s := &subscription{start: b.transformKey(prefix), fn: fn}
+	s.end = prefixEnd(s.start)
+	b.subs.add(s)
+
+	return func() {
+		b.subs.remove(s)
+	}
+}
+
+// changed notifies the subscribers of key of a change, failed operations are not delivered
+func (b *BTree) changed(op AuditOp, key []byte, size int, err error) {
+	if err != nil {
+		return
+	}
+
+	idx := b.subs.index.Load()
+	if idx == nil {
+		return
+	}
+
+	c := Change{Op: op, Key: key, Size: size}
+	idx.match(key, func(s *subscription) {
+		s.fn(c)
+	})
+}
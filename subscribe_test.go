@@ -0,0 +1,160 @@
+// Package btree
+// range subscriptions tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_Subscribe(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	var users, ranged, all []Change
+	cancelUsers := btree.SubscribePrefix([]byte("user:"), func(c Change) { users = append(users, c) })
+	cancelRanged := btree.Subscribe([]byte("b"), []byte("d"), func(c Change) { ranged = append(ranged, c) })
+	btree.Subscribe(nil, nil, func(c Change) { all = append(all, c) })
+
+	for _, k := range []string{"a", "b", "c", "d", "user:1", "user:2", "userx"} {
+		err = btree.Put([]byte(k), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Delete([]byte("user:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// missing keys and failed operations are not changes
+	err = btree.Delete([]byte("user:3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Remove([]byte("user:4"), []byte("value"))
+	if err == nil {
+		t.Fatal("expected an error removing from a missing key")
+	}
+
+	err = btree.Remove([]byte("c"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := fmt.Sprint(changeKeys(users))
+	if got != "[put user:1 put user:2 delete user:1]" {
+		t.Fatalf("expected the prefix changes, got %s", got)
+	}
+
+	got = fmt.Sprint(changeKeys(ranged))
+	if got != "[put b put c remove c]" {
+		t.Fatalf("expected the changes within [b, d), got %s", got)
+	}
+
+	if len(all) != 9 {
+		t.Fatalf("expected 9 changes, got %d", len(all))
+	}
+
+	if ranged[0].Size != 5 || ranged[2].Size != 5 {
+		t.Fatalf("expected the size of the values, got %d and %d", ranged[0].Size, ranged[2].Size)
+	}
+
+	cancelUsers()
+	cancelRanged()
+	cancelRanged()
+
+	err = btree.Put([]byte("user:5"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 3 || len(ranged) != 3 || len(all) != 10 {
+		t.Fatalf("expected cancelled subscriptions to stop, got %d %d %d", len(users), len(ranged), len(all))
+	}
+}
+
+func TestSubscriptionIndex(t *testing.T) {
+	ranges := [][2]string{{"a", "c"}, {"b", "z"}, {"d", "e"}, {"f", ""}, {"m", "n"}}
+
+	subs := make([]*subscription, 0, len(ranges))
+	for _, r := range ranges {
+		s := &subscription{start: []byte(r[0])}
+		if r[1] != "" {
+			s.end = []byte(r[1])
+		}
+		subs = append(subs, s)
+	}
+
+	idx := newSubscriptionIndex(subs)
+
+	for c := byte('a'); c <= 'z'; c++ {
+		key := []byte{c}
+
+		expected := 0
+		for _, s := range subs {
+			if s.contains(key) {
+				expected++
+			}
+		}
+
+		matched := 0
+		idx.match(key, func(s *subscription) {
+			if !s.contains(key) {
+				t.Fatalf("matched %s outside [%s, %s)", key, s.start, s.end)
+			}
+			matched++
+		})
+
+		if matched != expected {
+			t.Fatalf("expected %d subscriptions for %s, got %d", expected, key, matched)
+		}
+	}
+}
+
+// changeKeys formats changes as their operation and key
+func changeKeys(changes []Change) []string {
+	keys := make([]string, 0, len(changes))
+	for _, c := range changes {
+		keys = append(keys, string(c.Op)+" "+string(c.Key))
+	}
+	return keys
+}
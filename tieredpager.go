@@ -0,0 +1,402 @@
+// Package btree
+// tiered pager
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"github.com/hashicorp/go-msgpack/codec"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	TIER_HOT  = 0 // page is stored on the hot (fast) device
+	TIER_COLD = 1 // page is stored on the cold (secondary) device
+)
+
+// TieredPager keeps frequently accessed pages on a hot pager and migrates cold pages to a secondary pager
+type TieredPager struct {
+	tiers       [2]Storage       // hot and cold storage
+	table       *tierTable       // location of every page
+	tableFile   *os.File         // file to store the location table
+	counts      map[int64]uint64 // access counts since the last rebalance
+	maxHotPages int              // maximum number of pages kept on the hot tier
+	lock        *sync.Mutex      // lock for the table and counts
+}
+
+// tierTable maps page ids to their location on a tier
+type tierTable struct {
+	Next      int64                   // next never used page id
+	Free      []int64                 // freed page ids
+	Locations map[int64]*tierLocation // location of each page
+}
+
+// tierLocation is the tier and page id on that tier a page is stored at
+type tierLocation struct {
+	Tier int   // TIER_HOT or TIER_COLD
+	Page int64 // page id on the tier
+}
+
+// OpenTieredPager opens a tiered pager storing hot pages in hotPath and cold pages in coldPath
+// At most maxHotPages pages are kept on the hot tier after a Rebalance
+func OpenTieredPager(hotPath, coldPath string, flag int, perm os.FileMode, maxHotPages int) (*TieredPager, error) {
+	if maxHotPages < 1 {
+		return nil, errors.New("maxHotPages must be greater than 0")
+	}
+
+	hot, err := OpenPager(hotPath, flag, perm, time.Millisecond*128)
+	if err != nil {
+		return nil, err
+	}
+
+	cold, err := OpenPager(coldPath, flag, perm, time.Millisecond*128)
+	if err != nil {
+		hot.Close()
+		return nil, err
+	}
+
+	tableFile, err := openFile(hotPath+".tier", os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		hot.Close()
+		cold.Close()
+		return nil, err
+	}
+
+	table := &tierTable{Free: make([]int64, 0), Locations: make(map[int64]*tierLocation)}
+
+	err = readTierTable(tableFile, table)
+	if err != nil {
+		tableFile.Close()
+		hot.Close()
+		cold.Close()
+		return nil, err
+	}
+
+	return &TieredPager{
+		tiers:       [2]Storage{hot, cold},
+		table:       table,
+		tableFile:   tableFile,
+		counts:      make(map[int64]uint64),
+		maxHotPages: maxHotPages,
+		lock:        &sync.Mutex{},
+	}, nil
+}
+
+// readTierTable decodes the location table stored in file into table, an empty file leaves the table empty
+func readTierTable(file *os.File, table *tierTable) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() == 0 {
+		return nil
+	}
+
+	data := make([]byte, stat.Size())
+	_, err = file.ReadAt(data, 0)
+	if err != nil {
+		return err
+	}
+
+	dec := codec.NewDecoderBytes(data, msgpackHandle)
+	return dec.Decode(table)
+}
+
+// ReadPage reads a page from the tier it is stored on
+func (p *TieredPager) ReadPage(pageID int64) ([]byte, error) {
+	p.lock.Lock()
+	loc, ok := p.table.Locations[pageID]
+	if ok {
+		p.counts[pageID]++
+	}
+	p.lock.Unlock()
+
+	if !ok {
+		return nil, io.EOF
+	}
+
+	return p.tiers[loc.Tier].ReadPage(loc.Page)
+}
+
+// WritePage writes data to a page, new pages are placed on the hot tier
+func (p *TieredPager) WritePage(pageID int64, data []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	loc, ok := p.table.Locations[pageID]
+	if ok {
+		return p.tiers[loc.Tier].WritePage(loc.Page, data)
+	}
+
+	page, err := p.tiers[TIER_HOT].Allocate(data)
+	if err != nil {
+		return err
+	}
+
+	p.table.Locations[pageID] = &tierLocation{Tier: TIER_HOT, Page: page}
+	if pageID >= p.table.Next {
+		p.table.Next = pageID + 1
+	}
+
+	return nil
+}
+
+// Allocate writes data to a new page on the hot tier and returns its id
+func (p *TieredPager) Allocate(data []byte) (int64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	page, err := p.tiers[TIER_HOT].Allocate(data)
+	if err != nil {
+		return -1, err
+	}
+
+	var pageID int64
+	if len(p.table.Free) > 0 {
+		pageID = p.table.Free[len(p.table.Free)-1]
+		p.table.Free = p.table.Free[:len(p.table.Free)-1]
+	} else {
+		pageID = p.table.Next
+		p.table.Next++
+	}
+
+	p.table.Locations[pageID] = &tierLocation{Tier: TIER_HOT, Page: page}
+
+	return pageID, nil
+}
+
+// Free frees a page on the tier it is stored on
+func (p *TieredPager) Free(pageID int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	loc, ok := p.table.Locations[pageID]
+	if !ok {
+		return nil
+	}
+
+	err := p.tiers[loc.Tier].Free(loc.Page)
+	if err != nil {
+		return err
+	}
+
+	delete(p.table.Locations, pageID)
+	delete(p.counts, pageID)
+	p.table.Free = append(p.table.Free, pageID)
+
+	return nil
+}
+
+// Rebalance keeps the most accessed pages on the hot tier and migrates the rest to the cold tier
+// Access counts are halved afterwards so the policy adapts to changing workloads
+func (p *TieredPager) Rebalance() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pages := make([]int64, 0, len(p.table.Locations))
+	for pageID := range p.table.Locations {
+		pages = append(pages, pageID)
+	}
+
+	// most accessed first, the root and low page ids win ties as they tend to be internal nodes
+	sort.Slice(pages, func(i, j int) bool {
+		if p.counts[pages[i]] != p.counts[pages[j]] {
+			return p.counts[pages[i]] > p.counts[pages[j]]
+		}
+		return pages[i] < pages[j]
+	})
+
+	hot := pages[:min(len(pages), p.maxHotPages)]
+	cold := pages[len(hot):]
+
+	// demote first so the hot tier has room for promoted pages
+	err := p.migrate(cold, TIER_COLD)
+	if err != nil {
+		return err
+	}
+
+	err = p.migrate(hot, TIER_HOT)
+	if err != nil {
+		return err
+	}
+
+	for pageID, count := range p.counts {
+		if count/2 == 0 {
+			delete(p.counts, pageID)
+		} else {
+			p.counts[pageID] = count / 2
+		}
+	}
+
+	return p.writeTable()
+}
+
+// migrate moves pages to a tier
+// The old pages are only freed once the copies and the location table pointing at them are durable, a crash leaves every page readable
+func (p *TieredPager) migrate(pages []int64, tier int) error {
+	moved := make([]int64, 0)
+	old := make([]tierLocation, 0)
+
+	// the copies are freed and the pages left where they were if the table pointing at them is never written
+	undo := func(err error) error {
+		for i, pageID := range moved {
+			err = errors.Join(err, p.tiers[tier].Free(p.table.Locations[pageID].Page))
+			p.table.Locations[pageID] = &old[i]
+		}
+		return err
+	}
+
+	for _, pageID := range pages {
+		loc := p.table.Locations[pageID]
+		if loc.Tier == tier {
+			continue
+		}
+
+		data, err := p.tiers[loc.Tier].ReadPage(loc.Page)
+		if err != nil {
+			return undo(err)
+		}
+
+		page, err := p.tiers[tier].Allocate(data)
+		if err != nil {
+			return undo(err)
+		}
+
+		moved = append(moved, pageID)
+		old = append(old, *loc)
+		p.table.Locations[pageID] = &tierLocation{Tier: tier, Page: page}
+	}
+
+	if len(moved) == 0 {
+		return nil
+	}
+
+	err := p.tiers[tier].Sync()
+	if err != nil {
+		return undo(err)
+	}
+
+	err = p.writeTable()
+	if err != nil {
+		return err
+	}
+
+	err = p.tableFile.Sync()
+	if err != nil {
+		return err
+	}
+
+	for _, loc := range old {
+		err = p.tiers[loc.Tier].Free(loc.Page)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TierCounts returns the number of pages on the hot and cold tier
+func (p *TieredPager) TierCounts() (int, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	hot, cold := 0, 0
+	for _, loc := range p.table.Locations {
+		if loc.Tier == TIER_HOT {
+			hot++
+		} else {
+			cold++
+		}
+	}
+
+	return hot, cold
+}
+
+// writeTable writes the location table to its file
+func (p *TieredPager) writeTable() error {
+	var encoded []byte
+	enc := codec.NewEncoderBytes(&encoded, msgpackHandle)
+	err := enc.Encode(p.table)
+	if err != nil {
+		return err
+	}
+
+	err = p.tableFile.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.tableFile.WriteAt(encoded, 0)
+	return err
+}
+
+// Sync flushes both tiers and the location table
+func (p *TieredPager) Sync() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, tier := range p.tiers {
+		err := tier.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := p.writeTable()
+	if err != nil {
+		return err
+	}
+
+	return p.tableFile.Sync()
+}
+
+// Close closes both tiers and the location table
+func (p *TieredPager) Close() error {
+	err := p.Sync()
+	if err != nil {
+		return err
+	}
+
+	for _, tier := range p.tiers {
+		err = tier.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.tableFile.Close()
+}
@@ -0,0 +1,216 @@
+// Package btree
+// tiered pager tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestTieredPager_Rebalance(t *testing.T) {
+	defer os.Remove("hot.db")
+	defer os.Remove("hot.db.del")
+	defer os.Remove("hot.db.tier")
+	defer os.Remove("cold.db")
+	defer os.Remove("cold.db.del")
+
+	pager, err := OpenTieredPager("hot.db", "cold.db", os.O_CREATE|os.O_RDWR, 0644, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err := OpenWithStorage(pager, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		err := btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = pager.Rebalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hot, cold := pager.TierCounts()
+	if hot != 10 {
+		t.Fatalf("expected 10 hot pages, got %d", hot)
+	}
+
+	if cold == 0 {
+		t.Fatal("expected pages on the cold tier")
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = OpenTieredPager("hot.db", "cold.db", os.O_CREATE|os.O_RDWR, 0644, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree, err = OpenWithStorage(pager, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 500; i++ {
+		key, err := btree.Get([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected value to be %d", i)
+		}
+	}
+
+	// the root is read on every Get and must be kept hot
+	err = pager.Rebalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pager.table.Locations[0].Tier != TIER_HOT {
+		t.Fatal("expected the root to be on the hot tier")
+	}
+}
+
+// failingAllocator fails every allocation after the first n
+type failingAllocator struct {
+	Storage
+	n int
+}
+
+func (f *failingAllocator) Allocate(data []byte) (int64, error) {
+	if f.n == 0 {
+		return -1, errors.New("device full")
+	}
+	f.n--
+	return f.Storage.Allocate(data)
+}
+
+func TestTieredPager_RebalanceFailure(t *testing.T) {
+	defer os.Remove("hot.db")
+	defer os.Remove("hot.db.del")
+	defer os.Remove("hot.db.tier")
+	defer os.Remove("cold.db")
+	defer os.Remove("cold.db.del")
+
+	pager, err := OpenTieredPager("hot.db", "cold.db", os.O_CREATE|os.O_RDWR, 0644, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err = pager.Allocate([]byte("page " + strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = pager.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hot := pager.tiers[TIER_HOT].(*Pager)
+	cold := pager.tiers[TIER_COLD]
+	pager.tiers[TIER_COLD] = &failingAllocator{Storage: cold, n: 3}
+
+	// the demotion fails part way, no hot page is freed while the stored table still points at it
+	err = pager.Rebalance()
+	if err == nil {
+		t.Fatal("expected the rebalance to fail")
+	}
+
+	if hot.FreePageCount() != 0 {
+		t.Fatalf("expected no hot page to be freed, got %d", hot.FreePageCount())
+	}
+
+	if _, c := pager.TierCounts(); c != 0 {
+		t.Fatalf("expected the failed demotion to leave every page on the hot tier, got %d cold pages", c)
+	}
+
+	pager.tiers[TIER_COLD] = cold
+
+	err = pager.Rebalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the table is durable once the rebalance returns
+	table := &tierTable{}
+	err = readTierTable(pager.tableFile, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pageID, loc := range pager.table.Locations {
+		if *table.Locations[pageID] != *loc {
+			t.Fatalf("expected page %d at %v in the stored table, got %v", pageID, *loc, *table.Locations[pageID])
+		}
+	}
+
+	err = pager.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager, err = OpenTieredPager("hot.db", "cold.db", os.O_RDWR, 0644, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	for i := int64(0); i < 10; i++ {
+		data, err := pager.ReadPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(bytes.Trim(data, "\x00")) != "page "+strconv.Itoa(int(i)) {
+			t.Fatalf("expected page %d, got %s", i, bytes.Trim(data, "\x00"))
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Package btree
+// i/o timeouts
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIOTimeout is returned when a read, write or sync does not finish before its deadline
+var ErrIOTimeout = errors.New("i/o timeout")
+
+// timeoutFile wraps a page file giving every read, write and sync a deadline
+// A timed out call keeps running in the background as a blocked syscall cannot be cancelled.
+// A timed out write may still land, a later write to the same bytes waits for it so it never lands over newer data.
+type timeoutFile struct {
+	pageFile
+	readTimeout  time.Duration   // deadline for reads, 0 waits forever
+	writeTimeout time.Duration   // deadline for writes and syncs, 0 waits forever
+	lock         sync.Mutex      // lock for writes
+	writes       []*pendingWrite // writes which have not finished yet
+}
+
+// pendingWrite is a write running in the background
+type pendingWrite struct {
+	offset    int64         // first byte written
+	end       int64         // byte after the last byte written
+	done      chan struct{} // closed once the write finished
+	abandoned atomic.Bool   // the caller stopped waiting for the write
+}
+
+// ioResult is the result of a call running in the background
+type ioResult struct {
+	n   int
+	err error
+}
+
+// withTimeout runs fn and returns ErrIOTimeout if it does not finish within timeout
+func withTimeout(timeout time.Duration, op string, offset int64, fn func() (int, error)) (int, error) {
+	if timeout <= 0 {
+		return fn()
+	}
+
+	done := make(chan ioResult, 1)
+	go func() {
+		n, err := fn()
+		done <- ioResult{n: n, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-timer.C:
+		return 0, fmt.Errorf("%w: %s at offset %d after %s", ErrIOTimeout, op, offset, timeout)
+	}
+}
+
+// ReadAt reads into a private buffer so a timed out read cannot write into b later
+func (f *timeoutFile) ReadAt(b []byte, offset int64) (int, error) {
+	buf := make([]byte, len(b))
+
+	n, err := withTimeout(f.readTimeout, "read", offset, func() (int, error) {
+		return f.pageFile.ReadAt(buf, offset)
+	})
+
+	copy(b, buf[:n])
+	return n, err
+}
+
+// WriteAt writes a copy of b so a timed out write cannot observe later changes to b
+// The write waits for unfinished writes to the same bytes, it is not issued at all if they do not finish before its deadline
+func (f *timeoutFile) WriteAt(b []byte, offset int64) (int, error) {
+	if f.writeTimeout <= 0 {
+		return f.pageFile.WriteAt(b, offset)
+	}
+
+	buf := make([]byte, len(b))
+	copy(buf, b)
+
+	timer := time.NewTimer(f.writeTimeout)
+	defer timer.Stop()
+
+	w := &pendingWrite{offset: offset, end: offset + int64(len(b)), done: make(chan struct{})}
+
+	f.lock.Lock()
+	overlapping := make([]*pendingWrite, 0)
+	for _, p := range f.writes {
+		if p.offset < w.end && w.offset < p.end {
+			overlapping = append(overlapping, p)
+		}
+	}
+	f.writes = append(f.writes, w)
+	f.lock.Unlock()
+
+	done := make(chan ioResult, 1)
+	go func() {
+		defer f.finished(w)
+
+		for _, p := range overlapping {
+			<-p.done
+		}
+
+		// the deadline passed while waiting, the write is dropped as the caller was told it failed
+		if w.abandoned.Load() {
+			done <- ioResult{err: ErrIOTimeout}
+			return
+		}
+
+		n, err := f.pageFile.WriteAt(buf, offset)
+		done <- ioResult{n: n, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-timer.C:
+		w.abandoned.Store(true)
+		return 0, fmt.Errorf("%w: write at offset %d after %s", ErrIOTimeout, offset, f.writeTimeout)
+	}
+}
+
+// finished removes a write from the unfinished writes
+func (f *timeoutFile) finished(w *pendingWrite) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.writes = slices.DeleteFunc(f.writes, func(p *pendingWrite) bool { return p == w })
+	close(w.done)
+}
+
+// Sync flushes the file within the write deadline
+func (f *timeoutFile) Sync() error {
+	_, err := withTimeout(f.writeTimeout, "sync", 0, func() (int, error) {
+		return 0, f.pageFile.Sync()
+	})
+	return err
+}
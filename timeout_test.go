@@ -0,0 +1,157 @@
+// Package btree
+// i/o timeout tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowFile is an in-memory file whose reads and writes hang for a while
+type slowFile struct {
+	*memFile
+	delay time.Duration
+}
+
+func (s *slowFile) ReadAt(b []byte, offset int64) (int, error) {
+	time.Sleep(s.delay)
+	return s.memFile.ReadAt(b, offset)
+}
+
+func (s *slowFile) WriteAt(b []byte, offset int64) (int, error) {
+	time.Sleep(s.delay)
+	return s.memFile.WriteAt(b, offset)
+}
+
+func TestPager_Timeout(t *testing.T) {
+	slow := &slowFile{memFile: newMemFile()}
+
+	file := &timeoutFile{pageFile: slow, readTimeout: time.Millisecond * 20, writeTimeout: time.Millisecond * 20}
+
+	pager, err := newPager(file, newMemFile(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	pageID, err := pager.Write([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow.delay = time.Millisecond * 100
+
+	_, err = pager.GetPage(pageID)
+	if !errors.Is(err, ErrIOTimeout) {
+		t.Fatalf("expected ErrIOTimeout, got %v", err)
+	}
+
+	_, err = pager.Write([]byte("Hello World"))
+	if !errors.Is(err, ErrIOTimeout) {
+		t.Fatalf("expected ErrIOTimeout, got %v", err)
+	}
+}
+
+func TestOpenPagerWithOptions(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	pager, err := OpenPagerWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, &PagerOptions{ReadTimeout: time.Second, WriteTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+
+	pageID, err := pager.Write([]byte("Hello World"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pager.GetPage(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gatedFile is an in-memory file whose first write hangs until the gate is opened
+type gatedFile struct {
+	*memFile
+	gate    chan struct{}
+	blocked atomic.Bool
+}
+
+func (g *gatedFile) WriteAt(b []byte, offset int64) (int, error) {
+	if g.blocked.CompareAndSwap(false, true) {
+		<-g.gate
+	}
+	return g.memFile.WriteAt(b, offset)
+}
+
+func TestTimeoutFile_TimedOutWrite(t *testing.T) {
+	gated := &gatedFile{memFile: newMemFile(), gate: make(chan struct{})}
+
+	file := &timeoutFile{pageFile: gated, writeTimeout: time.Millisecond * 20}
+
+	_, err := file.WriteAt([]byte("old"), 0)
+	if !errors.Is(err, ErrIOTimeout) {
+		t.Fatalf("expected ErrIOTimeout, got %v", err)
+	}
+
+	// the timed out write still hangs, a write to the same bytes is not issued before it lands
+	_, err = file.WriteAt([]byte("mid"), 0)
+	if !errors.Is(err, ErrIOTimeout) {
+		t.Fatalf("expected ErrIOTimeout, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		close(gated.gate)
+	}()
+
+	_, err = file.WriteAt([]byte("new"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 3)
+	_, err = gated.memFile.ReadAt(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "new" {
+		t.Fatalf("expected the newest write to be kept, got %s", data)
+	}
+}
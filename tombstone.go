@@ -0,0 +1,111 @@
+// Package btree
+// tombstone deletes
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+// tombstone marks a key as deleted without removing it from the tree
+// The values are dropped right away, the key itself is removed by PurgeTombstones.  Returns true if the key was visible to reads.
+func (b *BTree) tombstone(k []byte) (bool, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return false, err
+	}
+
+	key, err := b.lookupKey(root, k)
+	if err != nil || key == nil || key.D {
+		return false, err
+	}
+
+	n, i, err := b.findNodeForKey(root, k)
+	if err != nil {
+		return false, err
+	}
+
+	// an expired key was already gone for readers
+	visible := !n.Keys[i].hidden()
+
+	err = b.dropHashSet(n.Keys[i])
+	if err == nil {
+		err = b.dropBitmap(n.Keys[i])
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for j := range n.Keys[i].V {
+		b.countRemoved(n.Keys[i], j)
+	}
+
+	// the version survives the tombstone so a recreated key does not repeat versions
+	n.Keys[i] = &Key{K: n.Keys[i].K, V: make([][]byte, 0), D: true, R: n.Keys[i].R, W: n.Keys[i].W}
+	b.keyChanged(n.Keys[i])
+
+	return visible, b.writeNode(n)
+}
+
+// PurgeTombstones removes every key marked with a tombstone from the tree and returns how many were removed
+func (b *BTree) PurgeTombstones() (int, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := make([][]byte, 0)
+	err = b.walk(root, func(n *Node) error {
+		for _, key := range n.Keys {
+			if key != nil && key.D {
+				deleted = append(deleted, key.K)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, k := range deleted {
+		err = b.atomic(func() error {
+			_, err := b.delete(k)
+			return err
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+
+	// every key within a deleted range was marked and purged above
+	return len(deleted), b.clearRangeTombstones()
+}
+
+// hidden returns true if the key must not be visible to reads because it expired or was deleted
+func (k *Key) hidden() bool {
+	return k.D || k.expired()
+}
@@ -0,0 +1,167 @@
+// Package btree
+// tombstone deletes tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_TombstoneDeletes(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{TombstoneDeletes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 50; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%02d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 10; i < 20; i++ {
+		err = btree.Delete([]byte(fmt.Sprintf("%02d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := btree.Get([]byte("15"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected a deleted key to be hidden")
+	}
+
+	keys, err := btree.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 40 {
+		t.Fatalf("expected 40 visible keys, got %d", len(keys))
+	}
+
+	keys, err = btree.rawKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 50 {
+		t.Fatalf("expected tombstones to stay in the tree, got %d keys", len(keys))
+	}
+
+	// putting a deleted key brings it back with only the new value
+	err = btree.Put([]byte("10"), []byte("again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err = btree.Get([]byte("10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || len(key.V) != 1 || string(key.V[0]) != "again" {
+		t.Fatal("expected the deleted key to start over")
+	}
+
+	purged, err := btree.PurgeTombstones()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 9 {
+		t.Fatalf("expected 9 tombstones purged, got %d", purged)
+	}
+
+	keys, err = btree.rawKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 41 {
+		t.Fatalf("expected 41 keys after purging, got %d", len(keys))
+	}
+}
+
+// rawKeys returns every key stored in the tree including hidden ones
+func (b *BTree) rawKeys() ([]*Key, error) {
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0)
+	err = b.walk(root, func(n *Node) error {
+		keys = append(keys, n.Keys...)
+		return nil
+	})
+	return keys, err
+}
+
+func TestBTree_TombstoneDeletes_Remove(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	btree.tombstones = true
+
+	err = btree.PutMulti([]byte("key"), []byte("a"), []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Delete([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tombstone does not hold the key, nothing is removed
+	removed, err := btree.RemoveCount([]byte("key"), []byte("a"))
+	if !errors.Is(err, ErrKeyNotFound) || removed != 0 {
+		t.Fatalf("expected ErrKeyNotFound, got %d, %v", removed, err)
+	}
+}
@@ -0,0 +1,120 @@
+// Package btree
+// key transforms
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"strings"
+)
+
+// ErrKeyTransformMismatch is returned when opening a btree with a different key transform than the one it was written with
+var ErrKeyTransformMismatch = errors.New("key transform does not match the btree")
+
+// KeyTransform normalizes keys before they reach the tree, it is applied to every key passed to the BTree
+// The name is recorded with the btree so a file is always opened with the transform it was written with
+type KeyTransform interface {
+	Name() string                // Name identifies the transform, it must not change between releases
+	Transform(key []byte) []byte // Transform returns the normalized key, it must not modify key
+}
+
+// keyTransformFunc is a KeyTransform built from a function
+type keyTransformFunc struct {
+	name string
+	fn   func(key []byte) []byte
+}
+
+// Name returns the name of the transform
+func (t *keyTransformFunc) Name() string {
+	return t.name
+}
+
+// Transform returns the normalized key
+func (t *keyTransformFunc) Transform(key []byte) []byte {
+	return t.fn(key)
+}
+
+// KeyTransformFunc returns a KeyTransform named name which applies fn
+func KeyTransformFunc(name string, fn func(key []byte) []byte) KeyTransform {
+	return &keyTransformFunc{name: name, fn: fn}
+}
+
+// LowerCaseKeys lower-cases keys, i.e. for case-insensitive lookups
+var LowerCaseKeys = KeyTransformFunc("lower", bytes.ToLower)
+
+// SHA256Keys replaces keys with their sha256 hash, keys are spread evenly but ranges are no longer meaningful
+var SHA256Keys = KeyTransformFunc("sha256", func(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:]
+})
+
+// transformKey applies the key transform, nil keys are unbounded range ends and are left as is
+func (b *BTree) transformKey(k []byte) []byte {
+	if b.keyTransform == nil || k == nil {
+		return k
+	}
+	return b.keyTransform.Transform(k)
+}
+
+// checkKeyTransform compares the key transform with the one recorded in the .transform file
+// A new btree records its transform, a btree written without a transform can only be opened without one
+func (b *BTree) checkKeyTransform(transform KeyTransform) error {
+	b.keyTransform = transform
+
+	recorded, err := os.ReadFile(b.name + ".transform")
+	if err == nil {
+		if transform == nil || transform.Name() != strings.TrimSpace(string(recorded)) {
+			return ErrKeyTransformMismatch
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if transform == nil {
+		return nil
+	}
+
+	// keys already written to the btree were not transformed
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	if !root.Leaf || len(root.Keys) > 0 {
+		return ErrKeyTransformMismatch
+	}
+
+	return os.WriteFile(b.name+".transform", []byte(transform.Name()+"\n"), b.perm)
+}
@@ -0,0 +1,256 @@
+// Package btree
+// key transforms tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBTree_KeyTransform(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.transform")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{KeyTransform: LowerCaseKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("Key"), []byte("value 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("KEY"), []byte("value 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("kEy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.K) != "key" || len(key.V) != 2 {
+		t.Fatalf("expected both values under key, got %v", key)
+	}
+
+	keys, err := btree.NRange([]byte("A"), []byte("Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 0 {
+		t.Fatalf("expected no keys outside of a to z, got %d", len(keys))
+	}
+
+	c := btree.Cursor()
+	key, err = c.Seek([]byte("K"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || string(key.K) != "key" {
+		t.Fatalf("expected the cursor to seek to key, got %v", key)
+	}
+
+	err = btree.Delete([]byte("KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err = btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected key to be deleted")
+	}
+
+	err = btree.Put([]byte("Key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transform is recorded with the btree
+	_, err = Open("btree.db", os.O_RDWR, 0644, 3)
+	if !errors.Is(err, ErrKeyTransformMismatch) {
+		t.Fatalf("expected ErrKeyTransformMismatch, got %v", err)
+	}
+
+	_, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{KeyTransform: SHA256Keys})
+	if !errors.Is(err, ErrKeyTransformMismatch) {
+		t.Fatalf("expected ErrKeyTransformMismatch, got %v", err)
+	}
+
+	btree, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{KeyTransform: LowerCaseKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	key, err = btree.Get([]byte("KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil {
+		t.Fatal("expected key to exist")
+	}
+}
+
+func TestBTree_KeyTransform_RawKeys(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.transform")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("Key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// raw keys were already written
+	_, err = OpenWithOptions("btree.db", os.O_RDWR, 0644, 3, &Options{KeyTransform: LowerCaseKeys})
+	if !errors.Is(err, ErrKeyTransformMismatch) {
+		t.Fatalf("expected ErrKeyTransformMismatch, got %v", err)
+	}
+
+	_, err = os.Stat("btree.db.transform")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected no transform to be recorded, got %v", err)
+	}
+}
+
+func TestKeyTransformFunc(t *testing.T) {
+	reverse := KeyTransformFunc("reverse", func(key []byte) []byte {
+		reversed := make([]byte, len(key))
+		for i := range key {
+			reversed[len(key)-1-i] = key[i]
+		}
+		return reversed
+	})
+
+	if reverse.Name() != "reverse" || string(reverse.Transform([]byte("abc"))) != "cba" {
+		t.Fatal("unexpected transform")
+	}
+
+	if len(SHA256Keys.Transform([]byte("key"))) != 32 {
+		t.Fatal("expected a 32 byte hash")
+	}
+}
+
+func TestBTree_KeyTransform_Copies(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("transform*.db*")
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}()
+
+	btree, err := OpenWithOptions("transform.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{KeyTransform: LowerCaseKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	err = btree.Put([]byte("Key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.SaveTo("transform_saved.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := new(bytes.Buffer)
+	_, err = btree.BackupIncremental(backup, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := RestoreIncremental(backup, "transform_restored.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Files) != 1 || manifest.Files[0] != ".transform" {
+		t.Fatalf("expected the backup to hold the transform, got %v", manifest.Files)
+	}
+
+	for _, name := range []string{"transform_saved.db", "transform_restored.db"} {
+		// the keys of the copy were transformed
+		_, err = Open(name, os.O_RDWR, 0644, 3)
+		if !errors.Is(err, ErrKeyTransformMismatch) {
+			t.Fatalf("expected ErrKeyTransformMismatch opening %s, got %v", name, err)
+		}
+
+		copied, err := OpenWithOptions(name, os.O_RDWR, 0644, 3, &Options{KeyTransform: LowerCaseKeys})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := copied.Get([]byte("KEY"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || string(key.K) != "key" {
+			t.Fatalf("expected key in %s, got %v", name, key)
+		}
+
+		err = copied.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package btree
+// iterative traversals
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxDepth is the deepest a traversal descends when Options.MaxDepth is not set
+// A tree of order 2 holding 2^63 keys is 63 levels deep, a traversal descending further follows a child pointer cycle.
+const DefaultMaxDepth = 64
+
+// ErrMaxDepth is returned by a traversal descending deeper than Options.MaxDepth, a corrupt child pointer may form a cycle
+var ErrMaxDepth = errors.New("traversal exceeded the maximum depth")
+
+// depthLimit returns the deepest a traversal descends
+func (b *BTree) depthLimit() int {
+	if b.maxDepth <= 0 {
+		return DefaultMaxDepth
+	}
+	return b.maxDepth
+}
+
+// checkDepth returns ErrMaxDepth once a traversal reaches page more than the depth limit levels deep, the root is 1 level deep
+func (b *BTree) checkDepth(page int64, depth int) error {
+	if depth > b.depthLimit() {
+		return fmt.Errorf("%w of %d at page %d, a child pointer may form a cycle", ErrMaxDepth, b.depthLimit(), page)
+	}
+	return nil
+}
+
+// descend reads the child at page of a node depth levels deep
+func (b *BTree) descend(page int64, depth int) (*Node, error) {
+	err := b.checkDepth(page, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return b.readNode(page)
+}
+
+// traversalFrame is a node on the explicit stack of a traversal
+type traversalFrame struct {
+	node      *Node
+	i         int  // the next key or child
+	descended bool // true once child i was visited
+}
+
+// collectInOrder returns the keys of the subtree x in order, walking the tree with an explicit stack
+// In every node the keys before from(n) are skipped and the keys are taken while take returns true, the child before
+// every taken key and the child after the last one are descended into.
+func (b *BTree) collectInOrder(x *Node, from func(n *Node) int, take func(k *Key) bool) ([]*Key, error) {
+	keys := make([]*Key, 0)
+	if x == nil {
+		return keys, nil
+	}
+
+	stack := make([]traversalFrame, 1, b.stackSize())
+	stack[0] = traversalFrame{node: x, i: from(x)}
+
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+
+		if !f.descended {
+			f.descended = true
+
+			if !f.node.Leaf && f.i < len(f.node.Children) {
+				child, err := b.descendChild(f.node, f.i, len(stack))
+				if err != nil {
+					return nil, err
+				}
+
+				stack = append(stack, traversalFrame{node: child, i: from(child)})
+				continue
+			}
+		}
+
+		if f.i < len(f.node.Keys) && take(f.node.Keys[f.i]) {
+			keys = append(keys, f.node.Keys[f.i])
+			f.i++
+			f.descended = false
+			continue
+		}
+
+		stack = stack[:len(stack)-1]
+	}
+
+	return keys, nil
+}
+
+// fromFirst starts collectInOrder at the first key of every node
+func fromFirst(*Node) int {
+	return 0
+}
+
+// takeAll takes every key in collectInOrder
+func takeAll(*Key) bool {
+	return true
+}
+
+// scanOutside calls fn for the keys of the subtree x outside of bounds in order, in a single pass which reads every node at most once
+// A child whose keys all lie within bounds is not read, child i holds the keys between key i-1 and key i.
+func (b *BTree) scanOutside(x *Node, bounds *keyBounds, fn func(k *Key) error) error {
+	if x == nil {
+		return nil
+	}
+
+	x.Keys = removeNilFromKeys(x.Keys)
+	stack := make([]traversalFrame, 1, b.stackSize())
+	stack[0] = traversalFrame{node: x}
+
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		n := len(f.node.Keys)
+
+		if !f.descended {
+			f.descended = true
+
+			i := f.i
+			inside := i > 0 && i < n && bounds.afterStart(f.node.Keys[i-1].K) && bounds.beforeEnd(f.node.Keys[i].K)
+
+			if !f.node.Leaf && i < len(f.node.Children) && !inside {
+				child, err := b.descendChild(f.node, i, len(stack))
+				if err != nil {
+					return err
+				}
+
+				child.Keys = removeNilFromKeys(child.Keys)
+				stack = append(stack, traversalFrame{node: child})
+				continue
+			}
+		}
+
+		if f.i >= n {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		k := f.node.Keys[f.i]
+		f.i++
+		f.descended = false
+
+		if bounds.afterStart(k.K) && bounds.beforeEnd(k.K) {
+			continue
+		}
+
+		err := fn(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
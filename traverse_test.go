@@ -0,0 +1,177 @@
+// Package btree
+// traversal tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBTree_MaxDepth(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	for i := 0; i < 200; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	height, err := btree.Height()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if height < 3 {
+		t.Fatalf("expected a tree at least 3 levels deep, got %d", height)
+	}
+
+	keys, err := btree.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 200 {
+		t.Fatalf("expected 200 keys, got %d", len(keys))
+	}
+
+	for i, k := range keys {
+		if !bytes.Equal(k.K, []byte(fmt.Sprintf("%03d", i))) {
+			t.Fatalf("expected key %03d, got %s", i, k.K)
+		}
+	}
+
+	// a limit of the height of the tree is enough
+	btree.maxDepth = height
+	_, err = btree.Range([]byte("000"), []byte("199"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree.maxDepth = height - 1
+	_, err = btree.Get([]byte("000"))
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf("expected ErrMaxDepth, got %v", err)
+	}
+
+	btree.maxDepth = 0
+	_, err = btree.Get([]byte("000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTree_MaxDepth_Cycle(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	for i := 0; i < 20; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("%02d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := btree.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if root.Leaf {
+		t.Fatal("expected an internal root")
+	}
+
+	// the first child points back to the root
+	root.Children[0] = 0
+
+	encoded, err := encodeNode(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Pager.WritePage(0, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btree.maxDepth = 8
+
+	_, err = btree.Get([]byte("00"))
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf("expected ErrMaxDepth from Get, got %v", err)
+	}
+
+	_, err = btree.InOrderTraversal()
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf("expected ErrMaxDepth from InOrderTraversal, got %v", err)
+	}
+
+	_, err = btree.Range([]byte("00"), []byte("19"))
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf("expected ErrMaxDepth from Range, got %v", err)
+	}
+
+	_, err = btree.RangeOpt([]byte("00"), []byte("19"), &RangeOptions{Reverse: true})
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf("expected ErrMaxDepth from RangeOpt, got %v", err)
+	}
+
+	_, err = btree.KeyRangesByLeaf()
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf("expected ErrMaxDepth from KeyRangesByLeaf, got %v", err)
+	}
+
+	_, err = btree.Height()
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf("expected ErrMaxDepth from Height, got %v", err)
+	}
+
+	err = btree.Put([]byte("00"), []byte("value"))
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Fatalf("expected ErrMaxDepth from Put, got %v", err)
+	}
+
+	// keys right of the cycle are still reachable
+	_, err = btree.Get([]byte("19"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
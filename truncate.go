@@ -0,0 +1,101 @@
+// Package btree
+// truncating a tree
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "errors"
+
+// Truncate deletes every key at once by truncating the file to an empty root instead of deleting keys one by one
+// The deleted pages and the files describing keys, the value log, the expiry index and the deleted ranges, are emptied too.  The btree stays open with its options.
+// Only supported when the BTree is stored by a Pager, Truncate fails while a transaction is active.
+func (b *BTree) Truncate() error {
+	if b.closed.Load() {
+		return ErrClosed
+	}
+
+	if !b.HasLease() {
+		return ErrReadOnly
+	}
+
+	if b.txn != nil {
+		return ErrTxnActive
+	}
+
+	pager, ok := b.Pager.(*Pager)
+	if !ok {
+		return errors.New("storage does not support Truncate")
+	}
+
+	// the tree goes first, a value log left behind by a crash holds no reachable values
+	err := pager.truncate(0)
+	if err != nil {
+		return err
+	}
+
+	// every node was replaced, the next read writes a new empty root
+	b.replaced()
+
+	_, err = b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	err = pager.Sync()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sidecars() {
+		if s.clear != nil {
+			err = s.clear(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// truncate drops every value of the log
+func (v *ValueLog) truncate() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	err := v.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	v.size = 0
+
+	return v.file.Sync()
+}
@@ -0,0 +1,188 @@
+// Package btree
+// truncating a tree tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBTree_Truncate(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+	defer os.Remove("btree.db.ttl")
+	defer os.Remove("btree.db.ttl.del")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), bytes.Repeat([]byte("v"), i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.PutWithTTL([]byte("expiring"), []byte("value"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		err = b.Delete([]byte(fmt.Sprintf("%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager := b.Pager.(*Pager)
+	if pager.PageCount() != 1 || len(pager.GetDeletedPages()) != 0 || b.ValueLog.Size() != 0 {
+		t.Fatalf("expected a single page and an empty value log, got %d pages, %d deleted pages and %d bytes", pager.PageCount(), len(pager.GetDeletedPages()), b.ValueLog.Size())
+	}
+
+	keys, err := b.Range(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 0 {
+		t.Fatalf("expected no keys, got %d", len(keys))
+	}
+
+	swept, err := b.Sweep()
+	if err != nil || swept != 0 {
+		t.Fatalf("expected an empty expiry index, got %d %v", swept, err)
+	}
+
+	// the btree keeps its options
+	for i := 0; i < 100; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), bytes.Repeat([]byte("w"), 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if b.ValueLog.Size() == 0 {
+		t.Fatal("expected large values in the value log")
+	}
+
+	err = b.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	key, err := b.Get([]byte("050"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil || !bytes.Equal(key.V[0], bytes.Repeat([]byte("w"), 100)) || len(key.V) != 1 {
+		t.Fatalf("expected the value written after truncating, got %v", key)
+	}
+}
+
+func TestBTree_Truncate_Unsupported(t *testing.T) {
+	pager, err := OpenObjectPager(&mapObjectStore{objects: make(map[string][]byte)}, "btree/", 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenWithStorage(pager, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	err = b.Truncate()
+	if err == nil {
+		t.Fatal("expected an error for an object pager")
+	}
+
+	memory, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memory.Close()
+
+	err = memory.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := memory.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = memory.Truncate()
+	if !errors.Is(err, ErrTxnActive) {
+		t.Fatalf("expected ErrTxnActive, got %v", err)
+	}
+
+	err = txn.Abort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = memory.Truncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := memory.Get([]byte("key"))
+	if err != nil || key != nil {
+		t.Fatalf("expected no key, got %v %v", key, err)
+	}
+}
@@ -0,0 +1,164 @@
+// Package btree
+// ttl
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// PutWithTTL inserts a key value pair which expires after ttl
+// Every PutWithTTL on a key resets its expiry, expired keys are hidden from reads and removed by Sweep
+func (b *BTree) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	key = b.transformKey(key)
+
+	if b.expiry == nil {
+		err := b.openExpiryIndex()
+		if err != nil {
+			return err
+		}
+	}
+
+	expires := time.Now().Add(ttl).UnixNano()
+
+	return b.atomic(func() error {
+		// the index entry is written first so a crash never leaves an expiring key Sweep cannot find
+		// an entry whose key was not written is discarded by Sweep
+		err := b.expiry.Put(expiryIndexKey(expires, key), key)
+		if err != nil {
+			return err
+		}
+
+		if b.journal != nil {
+			err = b.expiry.Pager.Sync()
+			if err != nil {
+				return err
+			}
+		}
+
+		err = b.put(key, value)
+		if err != nil {
+			return err
+		}
+
+		root, err := b.getRoot()
+		if err != nil {
+			return err
+		}
+
+		n, i, err := b.findNodeForKey(root, key)
+		if err != nil {
+			return err
+		}
+
+		n.Keys[i].E = expires
+
+		return b.writeNode(n)
+	})
+}
+
+// Sweep deletes all expired keys and returns how many were deleted
+// Expired keys are found through the expiry index without scanning the tree
+func (b *BTree) Sweep() (int, error) {
+	if b.expiry == nil {
+		return 0, nil
+	}
+
+	entries, err := b.expiry.Range(expiryIndexKey(0, nil), expiryIndexKey(time.Now().UnixNano(), nil))
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, entry := range entries {
+		indexKey := entry.(*Key).K
+		expires := int64(binary.BigEndian.Uint64(indexKey[:8]))
+		k := indexKey[8:]
+
+		root, err := b.getRoot()
+		if err != nil {
+			return deleted, err
+		}
+
+		key, err := b.lookupKey(root, k)
+		if err != nil {
+			return deleted, err
+		}
+
+		// the key may have been deleted or its ttl refreshed since this entry was written
+		if key != nil && key.E == expires {
+			_, err = b.deleteKey(k)
+			if err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+
+		err = b.expiry.Delete(indexKey)
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// openExpiryIndex opens the expiry index, a btree of expiry time and key to key
+func (b *BTree) openExpiryIndex() error {
+	var err error
+
+	if b.name == "" {
+		b.expiry, err = OpenMemory(b.T)
+	} else {
+		// the expiry index is written by the owner of the lease of the btree
+		b.expiry, err = OpenWithOptions(b.name+".ttl", os.O_CREATE|os.O_RDWR, int(b.perm), b.T, &Options{PagerOptions: &PagerOptions{passive: !b.HasLease()}})
+	}
+
+	if err == nil && b.noLocking {
+		b.expiry.disableLocking()
+	}
+
+	return err
+}
+
+// expiryIndexKey encodes an expiry index key which sorts by expiry time then key
+func expiryIndexKey(expires int64, key []byte) []byte {
+	k := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(k, uint64(expires))
+	return append(k, key...)
+}
+
+// expired returns true if the key has an expiry time in the past
+func (k *Key) expired() bool {
+	return k.E != 0 && k.E <= time.Now().UnixNano()
+}
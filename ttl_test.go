@@ -0,0 +1,276 @@
+// Package btree
+// ttl tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBTree_PutWithTTL(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.ttl")
+	defer os.Remove("btree.db.ttl.del")
+
+	btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.PutWithTTL([]byte("short"), []byte("value"), time.Millisecond*50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutWithTTL([]byte("long"), []byte("value"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil {
+		t.Fatal("expected key to be visible before it expires")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	key, err = btree.Get([]byte("short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected expired key to be hidden")
+	}
+
+	keys, err := btree.InOrderTraversal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || string(keys[0].K) != "long" {
+		t.Fatalf("expected only the long key, got %d keys", len(keys))
+	}
+}
+
+func TestBTree_Sweep(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 0; i < 100; i++ {
+		err := btree.PutWithTTL([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)), time.Millisecond*50)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// refreshing a ttl keeps the key alive
+	err = btree.PutWithTTL([]byte("0"), []byte("0"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	deleted, err := btree.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 99 {
+		t.Fatalf("expected 99 deleted keys, got %d", deleted)
+	}
+
+	key, err := btree.Get([]byte("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil {
+		t.Fatal("expected refreshed key to survive the sweep")
+	}
+
+	deleted, err = btree.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 0 {
+		t.Fatalf("expected nothing left to sweep, got %d", deleted)
+	}
+}
+
+func TestBTree_PutWithTTL_IndexFirst(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.PutWithTTL([]byte("a"), []byte("a"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an expiry index which cannot be written leaves the tree untouched
+	expiry := btree.expiry
+	err = expiry.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.PutWithTTL([]byte("b"), []byte("b"), time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the put to fail")
+	}
+
+	key, err := btree.Get([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected key b not to be written without its expiry index entry")
+	}
+
+	// an index entry whose key was never written is discarded by Sweep
+	btree.expiry, err = OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.expiry.Put(expiryIndexKey(time.Now().UnixNano(), []byte("a")), []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := btree.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 0 {
+		t.Fatalf("expected no deleted keys, got %d", deleted)
+	}
+
+	key, err = btree.Get([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key == nil {
+		t.Fatal("expected key a to be kept")
+	}
+}
+
+func TestBTree_PutWithTTL_RemoveExpired(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.PutWithTTL([]byte("key"), []byte("value"), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 10)
+
+	// an expired key is absent for Remove as it is for Get
+	removed, err := btree.RemoveCount([]byte("key"), []byte("value"))
+	if !errors.Is(err, ErrKeyNotFound) || removed != 0 {
+		t.Fatalf("expected ErrKeyNotFound, got %d, %v", removed, err)
+	}
+}
+
+func TestBTree_Sweep_SaveTo(t *testing.T) {
+	defer func() {
+		files, _ := filepath.Glob("ttl*.db*")
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}()
+
+	btree, err := Open("ttl.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	for i := 0; i < 10; i++ {
+		err := btree.PutWithTTL([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)), time.Millisecond*50)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.SaveTo("ttl_saved.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := Open("ttl_saved.db", os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saved.Close()
+
+	time.Sleep(time.Millisecond * 100)
+
+	// the copy carries the expiry index
+	deleted, err := saved.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 10 {
+		t.Fatalf("expected 10 deleted keys in the copy, got %d", deleted)
+	}
+}
@@ -0,0 +1,247 @@
+// Package btree
+// two-phase commit
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrTxnActive is returned by Begin while another transaction is active
+var ErrTxnActive = errors.New("a transaction is already active")
+
+// ErrTxnDone is returned when using a transaction which was committed or aborted
+var ErrTxnDone = errors.New("transaction is already committed or aborted")
+
+// ErrInDoubt is returned when writing while a prepared transaction waits for Commit or Abort
+var ErrInDoubt = errors.New("a prepared transaction is waiting for commit or abort")
+
+// Txn is a write transaction, every write made to the BTree while it is active belongs to it
+// Prepare, Commit and Abort let a coordinator commit several trees atomically (i.e. a primary and a secondary index)
+// The expiry index and the value log are not part of the transaction
+type Txn struct {
+	b        *BTree         // The btree the transaction writes to
+	storage  Storage        // The storage the transaction is published to
+	shadow   *shadowStorage // The pages written by the transaction
+	journal  *shadowJournal // The prepare file, nil for in-memory trees or until prepared
+	prepared bool           // True once the transaction is prepared
+	done     bool           // True once the transaction is committed or aborted
+}
+
+// Begin starts a write transaction
+// Writes are buffered and visible through this BTree until the transaction is committed or aborted
+func (b *BTree) Begin() (*Txn, error) {
+	if b.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	if !b.HasLease() {
+		return nil, ErrReadOnly
+	}
+
+	if b.txn != nil {
+		if b.txn.prepared {
+			return nil, ErrInDoubt
+		}
+		return nil, ErrTxnActive
+	}
+
+	txn := &Txn{
+		b:       b,
+		storage: b.Pager,
+		shadow:  newShadowStorage(b.Pager),
+	}
+
+	b.Pager = txn.shadow
+	b.txn = txn
+
+	return txn, nil
+}
+
+// PreparedTxn returns the prepared transaction waiting for Commit or Abort, nil if there is none
+// A transaction prepared before a crash is recovered on open, the coordinator decides whether it commits
+func (b *BTree) PreparedTxn() *Txn {
+	if b.txn != nil && b.txn.prepared {
+		return b.txn
+	}
+	return nil
+}
+
+// Prepare durably stores the transaction so it can still be committed after a crash
+// Once prepared the transaction can only be committed or aborted and the BTree rejects writes until then
+func (t *Txn) Prepare() error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	if t.prepared {
+		return nil
+	}
+
+	if t.b.name != "" {
+		file, err := openFile(t.b.name+".prepare", os.O_CREATE|os.O_RDWR|os.O_TRUNC, t.b.perm)
+		if err != nil {
+			return err
+		}
+
+		journal := &shadowJournal{file: file}
+
+		err = journal.write(t.shadow)
+		if err != nil {
+			journal.Close()
+			return err
+		}
+
+		t.journal = journal
+	}
+
+	// reads see the transaction's writes until it is committed or aborted
+	t.prepared = true
+
+	return nil
+}
+
+// Commit publishes the transaction, it is prepared first if needed
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	err := t.Prepare()
+	if err != nil {
+		return err
+	}
+
+	err = t.b.published(func() error {
+		err := t.shadow.apply(t.storage)
+		if err != nil {
+			return err
+		}
+
+		// the range tombstones the transaction changed are kept with its pages
+		return t.b.settleRangeTombstones(nil)
+	})
+	if err != nil {
+		// the prepare file still holds the transaction, committing again replays it
+		return err
+	}
+
+	return t.finish()
+}
+
+// Abort discards the transaction and frees the pages it allocated
+func (t *Txn) Abort() error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	for _, pageID := range t.shadow.allocated {
+		err := t.storage.Free(pageID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := t.storage.Sync()
+	if err != nil {
+		return err
+	}
+
+	// the range tombstones the transaction changed are discarded with its pages
+	_ = t.b.settleRangeTombstones(ErrTxnDone)
+
+	return t.finish()
+}
+
+// finish removes the prepare file and detaches the transaction from the btree
+func (t *Txn) finish() error {
+	if t.journal != nil {
+		err := t.journal.Close()
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(t.b.name + ".prepare")
+		if err != nil {
+			return err
+		}
+	}
+
+	t.done = true
+	t.b.Pager = t.storage
+	t.b.txn = nil
+
+	// the tree cursors were reading changed
+	t.b.replaced()
+
+	return nil
+}
+
+// recoverPreparedTxn loads a transaction prepared before a crash, an incomplete prepare file is discarded
+func (b *BTree) recoverPreparedTxn() error {
+	file, err := openFile(b.name+".prepare", os.O_RDWR, b.perm)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	journal := &shadowJournal{file: file}
+
+	shadow, ok, err := journal.load(b.Pager)
+	if err != nil {
+		journal.Close()
+		return err
+	}
+
+	if !ok {
+		// the transaction was never prepared
+		err = journal.Close()
+		if err != nil {
+			return err
+		}
+		return os.Remove(b.name + ".prepare")
+	}
+
+	b.txn = &Txn{
+		b:        b,
+		storage:  b.Pager,
+		shadow:   shadow,
+		journal:  journal,
+		prepared: true,
+	}
+
+	// reads see the prepared transaction like they do before a crash
+	b.Pager = shadow
+
+	return nil
+}
@@ -0,0 +1,267 @@
+// Package btree
+// two-phase commit tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestTxn_Commit(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("index.db")
+	defer os.Remove("index.db.del")
+
+	primary, err := Open("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	index, err := Open("index.db", os.O_CREATE|os.O_RDWR, 0644, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	txns := make([]*Txn, 0)
+	for _, b := range []*BTree{primary, index} {
+		txn, err := b.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txns = append(txns, txn)
+	}
+
+	_, err = primary.Begin()
+	if !errors.Is(err, ErrTxnActive) {
+		t.Fatalf("expected ErrTxnActive, got %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		err = primary.Put(key, []byte("value "+strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = index.Put([]byte("value "+strconv.Itoa(i)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the coordinator commits once every tree is prepared
+	for _, txn := range txns {
+		err = txn.Prepare()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = primary.Put([]byte("key"), []byte("value"))
+	if !errors.Is(err, ErrInDoubt) {
+		t.Fatalf("expected ErrInDoubt, got %v", err)
+	}
+
+	for _, txn := range txns {
+		err = txn.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = txns[0].Commit()
+	if !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("expected ErrTxnDone, got %v", err)
+	}
+
+	_, err = os.Stat("btree.db.prepare")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the prepare file to be removed, got %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key, err := index.Get([]byte("value " + strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil {
+			t.Fatalf("expected value %d to be committed", i)
+		}
+
+		if string(key.V[0]) != strconv.Itoa(i) {
+			t.Fatalf("expected %d, got %s", i, key.V[0])
+		}
+
+		value, err := primary.Get(key.V[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if value == nil {
+			t.Fatalf("expected %d to be committed", i)
+		}
+	}
+}
+
+func TestTxn_Abort(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	err = btree.Put([]byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := btree.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// writes are visible through the tree before commit
+	_, err = btree.Get([]byte("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = txn.Abort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != nil {
+		t.Fatal("expected the aborted write to be discarded")
+	}
+
+	_, err = btree.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxn_Recover(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.prepare")
+
+	for _, commit := range []bool{true, false} {
+		btree, err := Open("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txn, err := btree.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			err = btree.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = txn.Prepare()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the process stops before the coordinator decides
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		btree, err = Open("btree.db", os.O_RDWR, 0644, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txn = btree.PreparedTxn()
+		if txn == nil {
+			t.Fatal("expected a prepared transaction")
+		}
+
+		_, err = btree.Begin()
+		if !errors.Is(err, ErrInDoubt) {
+			t.Fatalf("expected ErrInDoubt, got %v", err)
+		}
+
+		if commit {
+			err = txn.Commit()
+		} else {
+			err = txn.Abort()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if btree.PreparedTxn() != nil {
+			t.Fatal("expected no prepared transaction")
+		}
+
+		key, err := btree.Get([]byte("42"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if commit != (key != nil) {
+			t.Fatalf("expected the key to exist %v, got %v", commit, key != nil)
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package btree
+// string and int64 helpers
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrNotInt64 is returned when a key or value read as an int64 was not written by EncodeInt64
+var ErrNotInt64 = errors.New("not an encoded int64")
+
+// StringEntry is a key and its values read as strings
+type StringEntry struct {
+	Key    string   // The key
+	Values []string // The values
+}
+
+// Int64Entry is a key and its values read as int64s
+type Int64Entry struct {
+	Key    int64   // The key
+	Values []int64 // The values
+}
+
+// EncodeInt64 encodes v in 8 big endian bytes with its sign bit flipped, encoded values order like the integers
+func EncodeInt64(v int64) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, 0, 8), uint64(v)^(1<<63))
+}
+
+// DecodeInt64 decodes an int64 encoded by EncodeInt64
+func DecodeInt64(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, ErrNotInt64
+	}
+	return int64(binary.BigEndian.Uint64(b) ^ (1 << 63)), nil
+}
+
+// PutString appends a string value to a string key
+func (b *BTree) PutString(key, value string) error {
+	return b.Put([]byte(key), []byte(value))
+}
+
+// GetString returns the most recent value of a string key, false if the key does not exist
+func (b *BTree) GetString(key string) (string, bool, error) {
+	k, err := b.Get([]byte(key))
+	if err != nil || k == nil || len(k.V) == 0 {
+		return "", false, err
+	}
+
+	return string(k.V[len(k.V)-1]), true, nil
+}
+
+// RangeString returns the string keys within [start, end] with their values
+func (b *BTree) RangeString(start, end string) ([]StringEntry, error) {
+	keys, err := b.RangeOpt([]byte(start), []byte(end), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StringEntry, 0, len(keys))
+	for _, k := range keys {
+		entry := StringEntry{Key: string(k.K), Values: make([]string, len(k.V))}
+		for i, v := range k.V {
+			entry.Values[i] = string(v)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// PutInt64 appends an int64 value to an int64 key, both are encoded with EncodeInt64 so keys order like the integers
+func (b *BTree) PutInt64(key, value int64) error {
+	return b.Put(EncodeInt64(key), EncodeInt64(value))
+}
+
+// GetInt64 returns the most recent value of an int64 key, false if the key does not exist
+// ErrNotInt64 is returned if the value was not written by PutInt64
+func (b *BTree) GetInt64(key int64) (int64, bool, error) {
+	k, err := b.Get(EncodeInt64(key))
+	if err != nil || k == nil || len(k.V) == 0 {
+		return 0, false, err
+	}
+
+	v, err := DecodeInt64(k.V[len(k.V)-1])
+	if err != nil {
+		return 0, false, err
+	}
+
+	return v, true, nil
+}
+
+// RangeInt64 returns the int64 keys within [start, end] with their values in ascending order
+// ErrNotInt64 is returned if a key or value within the range was not written by PutInt64
+func (b *BTree) RangeInt64(start, end int64) ([]Int64Entry, error) {
+	keys, err := b.RangeOpt(EncodeInt64(start), EncodeInt64(end), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Int64Entry, 0, len(keys))
+	for _, k := range keys {
+		key, err := DecodeInt64(k.K)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := Int64Entry{Key: key, Values: make([]int64, len(k.V))}
+		for i, v := range k.V {
+			entry.Values[i], err = DecodeInt64(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,158 @@
+// Package btree
+// string and int64 helpers tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEncodeInt64(t *testing.T) {
+	values := []int64{math.MinInt64, -1 << 40, -256, -1, 0, 1, 255, 1 << 40, math.MaxInt64}
+
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		encoded[i] = EncodeInt64(v)
+
+		decoded, err := DecodeInt64(encoded[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded != v {
+			t.Fatalf("expected %d, got %d", v, decoded)
+		}
+	}
+
+	if !sort.SliceIsSorted(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 }) {
+		t.Fatal("expected encoded values to order like the integers")
+	}
+
+	_, err := DecodeInt64([]byte("short"))
+	if err != ErrNotInt64 {
+		t.Fatalf("expected ErrNotInt64, got %v", err)
+	}
+}
+
+func TestBTree_PutString(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for _, key := range []string{"apple", "banana", "cherry", "date"} {
+		err = b.PutString(key, key+" value")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.PutString("banana", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, ok, err := b.GetString("cherry")
+	if err != nil || !ok || value != "cherry value" {
+		t.Fatalf("expected cherry value, got %q %v %v", value, ok, err)
+	}
+
+	value, ok, err = b.GetString("banana")
+	if err != nil || !ok || value != "second" {
+		t.Fatalf("expected the most recent value, got %q %v %v", value, ok, err)
+	}
+
+	_, ok, err = b.GetString("missing")
+	if err != nil || ok {
+		t.Fatalf("expected missing key not to be found, got %v %v", ok, err)
+	}
+
+	entries, err := b.RangeString("b", "cz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []StringEntry{{Key: "banana", Values: []string{"banana value", "second"}}, {Key: "cherry", Values: []string{"cherry value"}}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+}
+
+func TestBTree_PutInt64(t *testing.T) {
+	b, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := int64(-50); i <= 50; i++ {
+		err = b.PutInt64(i, i*i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, ok, err := b.GetInt64(-7)
+	if err != nil || !ok || value != 49 {
+		t.Fatalf("expected 49, got %d %v %v", value, ok, err)
+	}
+
+	_, ok, err = b.GetInt64(100)
+	if err != nil || ok {
+		t.Fatalf("expected missing key not to be found, got %v %v", ok, err)
+	}
+
+	// negative keys order before positive ones
+	entries, err := b.RangeInt64(-2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Int64Entry{{-2, []int64{4}}, {-1, []int64{1}}, {0, []int64{0}}, {1, []int64{1}}, {2, []int64{4}}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+
+	err = b.Put(EncodeInt64(3), []byte("text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = b.GetInt64(3)
+	if err != ErrNotInt64 {
+		t.Fatalf("expected ErrNotInt64, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+// Package btree
+// value log
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const VLOG_HEADER_SIZE = 8   // crc32 + length of a value log record
+const VLOG_POINTER_SIZE = 12 // offset + length of a value stored in the tree
+
+// ValueLog is an append-only file storing large values outside the tree
+type ValueLog struct {
+	name string      // name of the log file, empty for in-memory logs
+	file pageFile    // file to store values
+	size int64       // current size of the log
+	lock sync.Locker // lock for appends, a no-op if locking is disabled
+
+	bytesWritten atomic.Uint64 // bytes appended since the log was opened or the stats were reset
+}
+
+// OpenValueLog opens a value log file
+func OpenValueLog(filename string, flag int, perm os.FileMode) (*ValueLog, error) {
+	file, err := openFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValueLog{name: filename, file: file, size: stat.Size(), lock: &sync.Mutex{}}, nil
+}
+
+// openReadOnlyValueLog opens a value log file which is never appended to or compacted
+func openReadOnlyValueLog(filename string) (*ValueLog, error) {
+	v, err := OpenValueLog(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	v.file = &readOnlyFile{pageFile: v.file}
+	return v, nil
+}
+
+// openMemoryValueLog opens a value log which keeps its values in memory
+func openMemoryValueLog() *ValueLog {
+	return &ValueLog{file: newMemFile(), lock: &sync.Mutex{}}
+}
+
+// Append appends a value to the log and returns its offset
+func (v *ValueLog) Append(value []byte) (int64, error) {
+	// the length of a record is stored in 32 bits
+	if uint64(len(value)) > math.MaxUint32 {
+		return -1, ErrValueTooLarge
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	record := make([]byte, VLOG_HEADER_SIZE+len(value))
+	binary.BigEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(value))
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(value)))
+	copy(record[VLOG_HEADER_SIZE:], value)
+
+	offset := v.size
+	_, err := v.file.WriteAt(record, offset)
+	if err != nil {
+		return -1, err
+	}
+
+	v.size += int64(len(record))
+	v.bytesWritten.Add(uint64(len(record)))
+
+	return offset, nil
+}
+
+// Read reads the value stored at offset
+func (v *ValueLog) Read(offset int64) ([]byte, error) {
+	header := make([]byte, VLOG_HEADER_SIZE)
+	_, err := v.file.ReadAt(header, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := checkLength(uint64(binary.BigEndian.Uint32(header[4:8])))
+	if err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, length)
+	_, err = v.file.ReadAt(value, offset+VLOG_HEADER_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(value) != binary.BigEndian.Uint32(header[0:4]) {
+		return nil, errors.New("value log checksum mismatch")
+	}
+
+	return value, nil
+}
+
+// Size returns the size of the log in bytes
+func (v *ValueLog) Size() int64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.size
+}
+
+// SaveTo writes a copy of the log to filename
+func (v *ValueLog) SaveTo(filename string) error {
+	file, err := openFile(filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	err = copyFile(file, v.file)
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// LoadFrom replaces the contents of the log with the contents of filename
+func (v *ValueLog) LoadFrom(filename string) error {
+	file, err := openFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	err = copyFile(v.file, file)
+	if err != nil {
+		return err
+	}
+
+	stat, err := v.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	v.size = stat.Size()
+
+	return nil
+}
+
+// Sync flushes the log to disk
+func (v *ValueLog) Sync() error {
+	return v.file.Sync()
+}
+
+// Close closes the log
+func (v *ValueLog) Close() error {
+	err := v.file.Sync()
+	if err != nil {
+		return err
+	}
+	return v.file.Close()
+}
+
+// encodeValuePointer encodes a value log offset and length into a pointer stored in the tree
+func encodeValuePointer(offset int64, length int) []byte {
+	ptr := make([]byte, VLOG_POINTER_SIZE)
+	binary.BigEndian.PutUint64(ptr[0:8], uint64(offset))
+	binary.BigEndian.PutUint32(ptr[8:12], uint32(length))
+	return ptr
+}
+
+// decodeValuePointer decodes a pointer stored in the tree into a value log offset and length
+func decodeValuePointer(ptr []byte) (int64, int, error) {
+	if len(ptr) != VLOG_POINTER_SIZE {
+		return -1, 0, errors.New("invalid value pointer")
+	}
+
+	length, err := checkLength(uint64(binary.BigEndian.Uint32(ptr[8:12])))
+	if err != nil {
+		return -1, 0, err
+	}
+
+	return int64(binary.BigEndian.Uint64(ptr[0:8])), length, nil
+}
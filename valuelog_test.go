@@ -0,0 +1,267 @@
+// Package btree
+// value log tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestValueLog_Append(t *testing.T) {
+	defer os.Remove("btree.db.vlog")
+
+	vlog, err := OpenValueLog("btree.db.vlog", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vlog.Close()
+
+	value := bytes.Repeat([]byte("v"), 4096)
+
+	offset, err := vlog.Append(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := vlog.Read(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, value) {
+		t.Fatalf("expected value of length %d, got %d", len(value), len(data))
+	}
+
+	if vlog.Size() != int64(VLOG_HEADER_SIZE+len(value)) {
+		t.Fatalf("expected size %d, got %d", VLOG_HEADER_SIZE+len(value), vlog.Size())
+	}
+}
+
+func TestBTree_ValueLog(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	large := bytes.Repeat([]byte("a"), 8192)
+
+	for i := 0; i < 100; i++ {
+		err := btree.Put([]byte{byte(i)}, large)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = btree.Put([]byte{byte(i)}, []byte("small"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		key, err := btree.Get([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(key.V) != 2 || !bytes.Equal(key.V[0], large) || string(key.V[1]) != "small" {
+			t.Fatalf("unexpected values for key %d", i)
+		}
+	}
+
+	err = btree.Remove([]byte{byte(0)}, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.Get([]byte{byte(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 1 || string(key.V[0]) != "small" {
+		t.Fatalf("expected only the small value to remain")
+	}
+}
+
+func TestBTree_CompactValueLog(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	large := bytes.Repeat([]byte("a"), 4096)
+
+	for i := 0; i < 50; i++ {
+		err := btree.Put([]byte{byte(i)}, large)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 25; i++ {
+		err := btree.Delete([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := btree.ValueLog.Size()
+
+	err = btree.CompactValueLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if btree.ValueLog.Size() >= before {
+		t.Fatalf("expected value log to shrink, was %d now %d", before, btree.ValueLog.Size())
+	}
+
+	for i := 25; i < 50; i++ {
+		key, err := btree.Get([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key == nil || !bytes.Equal(key.V[0], large) {
+			t.Fatalf("expected key %d to keep its value", i)
+		}
+	}
+}
+
+func TestBTree_CompactValueLog_Crash(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+	defer os.Remove("btree.db.vlog.compact")
+	defer os.Remove("btree.db.vlog.swap")
+
+	large := bytes.Repeat([]byte("a"), 4096)
+
+	for _, journaled := range []bool{false, true} {
+		btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 1024})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 50; i++ {
+			err := btree.Put([]byte{byte(i)}, large)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < 25; i++ {
+			err := btree.Delete([]byte{byte(i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		before := btree.ValueLog.Size()
+
+		compacted, shadow, err := btree.copyLiveValues()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the process crashes before or right after the swap is journaled, the logs are not swapped yet
+		if journaled {
+			file, err := openFile("btree.db.vlog.swap", os.O_CREATE|os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			journal := &shadowJournal{file: file}
+			err = journal.write(shadow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			journal.Close()
+		}
+
+		compacted.Close()
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		btree, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 1024})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"btree.db.vlog.compact", "btree.db.vlog.swap"} {
+			_, err = os.Stat(name)
+			if !os.IsNotExist(err) {
+				t.Fatalf("journaled %v: expected %s to be removed on open", journaled, name)
+			}
+		}
+
+		if journaled != (btree.ValueLog.Size() < before) {
+			t.Fatalf("journaled %v: unexpected value log size %d, was %d", journaled, btree.ValueLog.Size(), before)
+		}
+
+		for i := 25; i < 50; i++ {
+			key, err := btree.Get([]byte{byte(i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key == nil || !bytes.Equal(key.V[0], large) {
+				t.Fatalf("journaled %v: expected key %d to keep its value", journaled, i)
+			}
+		}
+
+		err = btree.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.Remove("btree.db")
+		os.Remove("btree.db.del")
+		os.Remove("btree.db.vlog")
+	}
+}
@@ -0,0 +1,197 @@
+// Package btree
+// sorted values
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import "errors"
+
+// ErrNoValueComparator is returned by ValuesBetween on a btree opened without Options.ValueComparator
+var ErrNoValueComparator = errors.New("btree has no value comparator")
+
+// placeLatest moves the n most recently appended values of a key into order
+func (b *BTree) placeLatest(key []byte, n int) error {
+	if b.valueCompare == nil {
+		return nil
+	}
+
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	x, i, err := b.findNodeForKey(root, key)
+	if err != nil {
+		return err
+	}
+
+	k := x.Keys[i]
+
+	// every value is placed after the values before it were, the retention policy may have pruned some of them
+	changed := false
+	for j := max(len(k.V)-n, 0); j < len(k.V); j++ {
+		moved, err := b.placeValue(k, j)
+		if err != nil {
+			return err
+		}
+		changed = changed || moved
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return b.writeNode(x)
+}
+
+// placeValue moves the value at index j after the values before it which are not greater, the values before j must be sorted
+// Equal values keep their insertion order, false is returned if the value was already in place
+func (b *BTree) placeValue(k *Key, j int) (bool, error) {
+	if b.valueCompare == nil {
+		return false, nil
+	}
+
+	value, err := b.resolveValue(k, j)
+	if err != nil {
+		return false, err
+	}
+
+	pos, err := b.searchValues(k, j, func(v []byte) bool {
+		return b.valueCompare(v, value) > 0
+	})
+	if err != nil {
+		return false, err
+	}
+
+	k.moveValue(j, pos)
+
+	return pos != j, nil
+}
+
+// searchValues returns the first of the first n values of a key for which after is true
+// after must be false for a prefix of the values and true for the rest
+func (b *BTree) searchValues(k *Key, n int, after func(v []byte) bool) (int, error) {
+	lo, hi := 0, n
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+
+		v, err := b.resolveValue(k, mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if after(v) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// moveValue moves the value at index from to index to along with its pointer flag, meta and version
+func (k *Key) moveValue(from, to int) {
+	if from == to {
+		return
+	}
+
+	move(k.V, from, to)
+
+	if len(k.Ptr) > 0 {
+		k.Ptr = append(k.Ptr, make([]bool, len(k.V)-len(k.Ptr))...)
+		move(k.Ptr, from, to)
+	}
+
+	if len(k.M) > 0 {
+		k.M = append(k.M, make([]*ValueMeta, len(k.V)-len(k.M))...)
+		move(k.M, from, to)
+	}
+
+	if len(k.Ver) > 0 {
+		k.Ver = append(k.Ver, make([]uint64, len(k.V)-len(k.Ver))...)
+		move(k.Ver, from, to)
+	}
+}
+
+// move moves the element at index from to index to shifting the elements between them
+func move[T any](s []T, from, to int) {
+	v := s[from]
+	if from < to {
+		copy(s[from:to], s[from+1:to+1])
+	} else {
+		copy(s[to+1:from+1], s[to:from])
+	}
+	s[to] = v
+}
+
+// ValuesBetween returns the values of a key from v1 to v2 inclusive in order of the value comparator
+// nil is returned if the key does not exist
+func (b *BTree) ValuesBetween(k, v1, v2 []byte) ([][]byte, error) {
+	if b.valueCompare == nil {
+		return nil, ErrNoValueComparator
+	}
+
+	k = b.transformKey(k)
+
+	root, err := b.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := b.lookupKey(root, k)
+	if err != nil || key == nil || key.hidden() {
+		return nil, err
+	}
+
+	start, err := b.searchValues(key, len(key.V), func(v []byte) bool {
+		return b.valueCompare(v, v1) >= 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := b.searchValues(key, len(key.V), func(v []byte) bool {
+		return b.valueCompare(v, v2) > 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, 0, max(end-start, 0))
+	for i := start; i < end; i++ {
+		v, err := b.resolveValue(key, i)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
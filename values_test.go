@@ -0,0 +1,178 @@
+// Package btree
+// sorted values tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// compareNumbers orders decimal values numerically
+func compareNumbers(a, b []byte) int {
+	x, _ := strconv.Atoi(string(a))
+	y, _ := strconv.Atoi(string(b))
+	return x - y
+}
+
+func TestBTree_ValueComparator(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{
+		ValueComparator:   compareNumbers,
+		ValueLogThreshold: 4,
+		Versioned:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	// values of 4 digits are stored in the value log
+	for _, i := range rand.Perm(200) {
+		err = btree.Put([]byte("key"), []byte(strconv.Itoa(i*10)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.PutWithMeta([]byte("key"), []byte("15"), ValueMeta{Flags: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := btree.GetWithMeta([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.V) != 201 {
+		t.Fatalf("expected 201 values, got %d", len(key.V))
+	}
+
+	for i := 1; i < len(key.V); i++ {
+		if compareNumbers(key.V[i-1], key.V[i]) > 0 {
+			t.Fatalf("expected values in order, got %s before %s", key.V[i-1], key.V[i])
+		}
+	}
+
+	// the meta moves with its value
+	if string(key.V[2]) != "15" || key.M[2] == nil || key.M[2].Flags != 7 {
+		t.Fatalf("expected 15 with its meta at index 2, got %s", key.V[2])
+	}
+
+	// the newest value is found by version rather than position
+	latest, _, err := btree.GetLatest([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(latest) != "15" {
+		t.Fatalf("expected the latest value to be 15, got %s", latest)
+	}
+
+	values, err := btree.ValuesBetween([]byte("key"), []byte("15"), []byte("50"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := [][]byte{[]byte("15"), []byte("20"), []byte("30"), []byte("40"), []byte("50")}
+	if len(values) != len(expect) {
+		t.Fatalf("expected %q, got %q", expect, values)
+	}
+
+	for i := range expect {
+		if !bytes.Equal(values[i], expect[i]) {
+			t.Fatalf("expected %q, got %q", expect, values)
+		}
+	}
+
+	values, err = btree.ValuesBetween([]byte("key"), []byte("1991"), []byte("5000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != 0 {
+		t.Fatalf("expected no values, got %q", values)
+	}
+
+	values, err = btree.ValuesBetween([]byte("missing"), []byte("0"), []byte("10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values != nil {
+		t.Fatalf("expected nil for a missing key, got %q", values)
+	}
+}
+
+func TestBTree_ValuesBetween_NoComparator(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer btree.Close()
+
+	_, err = btree.ValuesBetween([]byte("key"), []byte("a"), []byte("b"))
+	if !errors.Is(err, ErrNoValueComparator) {
+		t.Fatalf("expected ErrNoValueComparator, got %v", err)
+	}
+}
+
+func TestKey_MoveValue(t *testing.T) {
+	key := &Key{
+		V:   [][]byte{[]byte("a"), []byte("b"), []byte("c")},
+		Ver: []uint64{1, 2},
+	}
+
+	key.moveValue(2, 0)
+
+	if string(bytes.Join(key.V, nil)) != "cab" {
+		t.Fatalf("expected cab, got %s", bytes.Join(key.V, nil))
+	}
+
+	// parallel slices are padded and move along
+	if len(key.Ver) != 3 || key.Ver[0] != 0 || key.Ver[1] != 1 || key.Ver[2] != 2 {
+		t.Fatalf("expected versions 0 1 2, got %v", key.Ver)
+	}
+
+	key.moveValue(0, 2)
+
+	if string(bytes.Join(key.V, nil)) != "abc" {
+		t.Fatalf("expected abc, got %s", bytes.Join(key.V, nil))
+	}
+}
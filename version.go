@@ -0,0 +1,142 @@
+// Package btree
+// versioned values
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotVersioned is returned by version reads on a btree opened without Options.Versioned
+var ErrNotVersioned = errors.New("btree is not versioned")
+
+// GetAt returns the value of a key as of version, that is the value with the highest version not greater than version
+// nil is returned if the key does not exist or has no value as old as version
+func (b *BTree) GetAt(k []byte, version uint64) ([]byte, error) {
+	if !b.versioned {
+		return nil, ErrNotVersioned
+	}
+
+	key, err := b.Get(k)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	// values are not in version order when a value comparator is set
+	found := -1
+	for i := range key.V {
+		if key.version(i) <= version && (found == -1 || key.version(i) >= key.version(found)) {
+			found = i
+		}
+	}
+
+	if found == -1 {
+		return nil, nil
+	}
+
+	return key.V[found], nil
+}
+
+// GetLatest returns the newest value of a key and its version
+// A nil value is returned if the key does not exist
+func (b *BTree) GetLatest(k []byte) ([]byte, uint64, error) {
+	if !b.versioned {
+		return nil, 0, ErrNotVersioned
+	}
+
+	key, err := b.Get(k)
+	if err != nil || key == nil || len(key.V) == 0 {
+		return nil, 0, err
+	}
+
+	latest := 0
+	for i := range key.V {
+		if key.version(i) >= key.version(latest) {
+			latest = i
+		}
+	}
+
+	return key.V[latest], key.version(latest), nil
+}
+
+// versionLatest assigns the next versions of a key to its n most recently appended values
+func (b *BTree) versionLatest(key []byte, n int) error {
+	root, err := b.getRoot()
+	if err != nil {
+		return err
+	}
+
+	x, i, err := b.findNodeForKey(root, key)
+	if err != nil {
+		return err
+	}
+
+	k := x.Keys[i]
+	first := len(k.V) - n
+
+	// versions of removed values are not reused
+	next := k.W + 1
+	for j := 0; j < first; j++ {
+		if k.version(j) >= next {
+			next = k.version(j) + 1
+		}
+	}
+
+	now := time.Now().UnixNano()
+
+	k.Ver = append(k.Ver, make([]uint64, len(k.V)-len(k.Ver))...)
+	for j := first; j < len(k.V); j++ {
+		k.Ver[j] = next
+		k.W = next
+		next++
+
+		// the commit time lets AsOf find the value, values stored with metadata keep theirs
+		if k.meta(j) == nil {
+			k.setMeta(j, &ValueMeta{Created: now})
+		}
+	}
+
+	_, err = b.pruneKey(k, now)
+	if err != nil {
+		return err
+	}
+
+	return b.writeNode(x)
+}
+
+// version returns the version of the value at index i, 0 if the value is unversioned
+func (k *Key) version(i int) uint64 {
+	if i < len(k.Ver) {
+		return k.Ver[i]
+	}
+	return 0
+}
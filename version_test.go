@@ -0,0 +1,202 @@
+// Package btree
+// versioned values tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBTree_GetAt(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	for i := 1; i <= 3; i++ {
+		err = btree.Put([]byte("key"), []byte(fmt.Sprintf("v%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// other keys must not advance the versions of key
+	for i := 0; i < 50; i++ {
+		err = btree.Put([]byte(fmt.Sprintf("other%d", i)), []byte("value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for version := uint64(1); version <= 3; version++ {
+		value, err := btree.GetAt([]byte("key"), version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(value) != fmt.Sprintf("v%d", version) {
+			t.Fatalf("expected v%d at version %d, got %s", version, version, value)
+		}
+	}
+
+	value, err := btree.GetAt([]byte("key"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(value) != "v3" {
+		t.Fatalf("expected v3, got %s", value)
+	}
+
+	value, err = btree.GetAt([]byte("key"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != nil {
+		t.Fatalf("expected no value at version 0, got %s", value)
+	}
+
+	err = btree.Remove([]byte("key"), []byte("v3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Put([]byte("key"), []byte("v4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, version, err := btree.GetLatest([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the version of the removed value is not assigned again
+	if string(value) != "v4" || version != 4 {
+		t.Fatalf("expected v4 at version 4, got %s at version %d", value, version)
+	}
+
+	value, version, err = btree.GetLatest([]byte("missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != nil || version != 0 {
+		t.Fatal("expected no value for a missing key")
+	}
+}
+
+func TestBTree_GetAt_NotVersioned(t *testing.T) {
+	btree, err := OpenMemory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	_, err = btree.GetAt([]byte("key"), 1)
+	if !errors.Is(err, ErrNotVersioned) {
+		t.Fatalf("expected ErrNotVersioned, got %v", err)
+	}
+
+	_, _, err = btree.GetLatest([]byte("key"))
+	if !errors.Is(err, ErrNotVersioned) {
+		t.Fatalf("expected ErrNotVersioned, got %v", err)
+	}
+}
+
+func TestBTree_GetAt_RemovedVersion(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	btree, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"v1", "v2"} {
+		err = btree.Put([]byte("key"), []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = btree.Remove([]byte("key"), []byte("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = btree.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the highest version survives a reopen
+	btree, err = OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{Versioned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer btree.Close()
+
+	err = btree.Put([]byte("key"), []byte("v3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the version of the removed value is not reused so older reads keep their answer
+	value, err := btree.GetAt([]byte("key"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(value) != "v1" {
+		t.Fatalf("expected v1 as of version 2, got %s", value)
+	}
+
+	value, version, err := btree.GetLatest([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(value) != "v3" || version != 3 {
+		t.Fatalf("expected v3 at version 3, got %s at %d", value, version)
+	}
+}
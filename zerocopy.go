@@ -0,0 +1,611 @@
+// Package btree
+// zero-copy reads
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// pageViewer is implemented by storages which keep pages in memory and can hand them out without copying
+type pageViewer interface {
+	viewPage(pageID int64) ([]byte, bool) // returns a page kept in memory and holds it there until it is released, false if the page is not in memory
+	releasePage(pageID int64)             // releases a page returned by viewPage
+}
+
+// KeyView is a key returned by GetZeroCopy
+// Unless Copied is set K and V alias a page kept in memory by the pager, they are valid until Release and must not be modified.
+// Values stored in the value log are always copies.
+type KeyView struct {
+	*Key           // The key
+	Copied  bool   // True if the key was copied because its page was not kept in memory
+	release func() // releases the page the key aliases, nil once released
+}
+
+// Release hands the page the key aliases back to the pager, the key and its values must not be used afterwards
+// Release is safe to call more than once
+func (v *KeyView) Release() {
+	if v.release != nil {
+		v.release()
+		v.release = nil
+	}
+}
+
+// GetZeroCopy returns a key with values aliasing the page the key is stored in, saving a copy per read
+// Pages are only aliased if every page on the path to the key is kept in memory, pinned pages of a Pager or cached pages of an ObjectPager.
+// Otherwise the key is read like Get and returned with Copied set.  The page stays in memory until the view is released, writes to the key are not seen by the view.
+// Nil is returned if the key does not exist.
+func (b *BTree) GetZeroCopy(k []byte) (*KeyView, error) {
+	if b.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	// pages are viewed in place as msgpack
+	if viewer, ok := b.Pager.(pageViewer); ok && b.nodeCodec == nil {
+		view, viewed, err := b.viewKey(viewer, b.transformKey(k))
+		if viewed || err != nil {
+			return view, err
+		}
+	}
+
+	key, err := b.Get(k)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	return &KeyView{Key: key, Copied: true}, nil
+}
+
+// viewKey searches for a key through pages kept in memory, false is returned if a page on the path is not in memory
+func (b *BTree) viewKey(viewer pageViewer, k []byte) (*KeyView, bool, error) {
+	page := int64(0)
+
+	for {
+		data, ok := viewer.viewPage(page)
+		if !ok {
+			return nil, false, nil
+		}
+
+		x, err := viewNode(data)
+		if err != nil {
+			// the node is read like Get reads it which reports the problem
+			viewer.releasePage(page)
+			return nil, false, nil
+		}
+		b.markRangeTombstones(x)
+
+		i := b.searchKeys(x.Keys, k)
+
+		if i < len(x.Keys) && equal(k, x.Keys[i].K) {
+			key := x.Keys[i]
+			if key.hidden() {
+				viewer.releasePage(page)
+				return nil, true, nil
+			}
+
+			resolved, err := b.resolveKey(key)
+			if err != nil {
+				viewer.releasePage(page)
+				return nil, true, err
+			}
+
+			held := page
+			return &KeyView{Key: resolved, release: func() { viewer.releasePage(held) }}, true, nil
+		}
+
+		viewer.releasePage(page)
+
+		if x.Leaf || i >= len(x.Children) {
+			return nil, true, nil
+		}
+
+		page = x.Children[i]
+	}
+}
+
+// viewPage returns a pinned page without copying it and holds the pin until releasePage
+func (p *Pager) viewPage(pageID int64) ([]byte, bool) {
+	if p.closed.Load() {
+		return nil, false
+	}
+
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	pin, ok := p.pinned[pageID]
+	if !ok {
+		return nil, false
+	}
+
+	// a pinned page written since it was last read is read again, writes replace the data instead of modifying it
+	if pin.data == nil {
+		data, err := p.readChain(pageID)
+		if err != nil {
+			return nil, false
+		}
+		pin.data = data
+	}
+
+	pin.count++
+
+	return pin.data, true
+}
+
+// releasePage releases the pin taken by viewPage
+func (p *Pager) releasePage(pageID int64) {
+	p.deletedPagesLock.Lock()
+	defer p.deletedPagesLock.Unlock()
+
+	pin, ok := p.pinned[pageID]
+	if !ok {
+		return
+	}
+
+	pin.count--
+	if pin.count == 0 {
+		delete(p.pinned, pageID)
+	}
+}
+
+// viewPage returns a cached page without copying it and keeps it cached until releasePage
+func (p *ObjectPager) viewPage(pageID int64) ([]byte, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	data, ok := p.cache[pageID]
+	if !ok {
+		return nil, false
+	}
+
+	p.counters.hits++
+	p.policy.Accessed(pageID)
+	p.pinned[pageID]++
+
+	// writes replace the cached data instead of modifying it
+	return data, true
+}
+
+// releasePage allows a page returned by viewPage to be evicted again
+func (p *ObjectPager) releasePage(pageID int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.pinned[pageID] == 0 {
+		return
+	}
+
+	p.pinned[pageID]--
+	if p.pinned[pageID] == 0 {
+		delete(p.pinned, pageID)
+	}
+}
+
+// errViewEncoding is returned when a node uses an encoding viewNode does not read
+var errViewEncoding = errors.New("unexpected node encoding")
+
+// viewNode decodes a node like decodeNode but the keys and values alias data instead of being copied
+// Nodes in the fixed key size encoding are read too, their keys alias the dense key array.
+func viewNode(data []byte) (*Node, error) {
+	r := &viewReader{data: data}
+
+	fixed := len(data) > 0 && data[0] == fixedKeyNode
+	if fixed {
+		r.pos = 1
+	}
+
+	var dense []byte
+
+	fields, err := r.mapLen()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{}
+	for f := 0; f < fields; f++ {
+		name, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		switch string(name) {
+		case "Page":
+			n.Page, err = r.int()
+		case "Keys":
+			n.Keys, err = r.keys()
+		case "Children":
+			var count int
+			count, err = r.arrayLen()
+			if count >= 0 {
+				n.Children = make([]int64, 0, count)
+			}
+			for i := 0; i < count && err == nil; i++ {
+				var child int64
+				child, err = r.int()
+				n.Children = append(n.Children, child)
+			}
+		case "Leaf":
+			n.Leaf, err = r.bool()
+		case "Dense":
+			dense, err = r.bytes()
+		default:
+			err = r.skip()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if fixed && len(n.Keys) > 0 {
+		size := len(dense) / len(n.Keys)
+		if size*len(n.Keys) != len(dense) {
+			return nil, errViewEncoding
+		}
+
+		for i, k := range n.Keys {
+			k.K = dense[i*size : (i+1)*size : (i+1)*size]
+		}
+	}
+
+	return n, nil
+}
+
+// viewReader reads msgpack data without copying bytes
+type viewReader struct {
+	data []byte // the encoded data
+	pos  int    // position of the next byte
+}
+
+// next returns the next byte
+func (r *viewReader) next() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errViewEncoding
+	}
+	r.pos++
+	return r.data[r.pos-1], nil
+}
+
+// take returns the next n bytes without copying them
+func (r *viewReader) take(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errViewEncoding
+	}
+	r.pos += n
+	return r.data[r.pos-n : r.pos : r.pos], nil
+}
+
+// uint reads a big endian unsigned integer of n bytes
+func (r *viewReader) uint(n int) (uint64, error) {
+	b, err := r.take(n)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// length reads a length of n bytes
+func (r *viewReader) length(n int) (int, error) {
+	l, err := r.uint(n)
+	if err != nil || l > uint64(len(r.data)) {
+		return 0, errViewEncoding
+	}
+	return int(l), nil
+}
+
+// mapLen reads the number of entries of a map, 0 for nil
+func (r *viewReader) mapLen() (int, error) {
+	b, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case b&0xf0 == 0x80:
+		return int(b & 0x0f), nil
+	case b == 0xde:
+		return r.length(2)
+	case b == 0xdf:
+		return r.length(4)
+	case b == 0xc0:
+		return 0, nil
+	}
+	return 0, errViewEncoding
+}
+
+// arrayLen reads the number of elements of an array, -1 for nil
+func (r *viewReader) arrayLen() (int, error) {
+	b, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case b&0xf0 == 0x90:
+		return int(b & 0x0f), nil
+	case b == 0xdc:
+		return r.length(2)
+	case b == 0xdd:
+		return r.length(4)
+	case b == 0xc0:
+		return -1, nil
+	}
+	return 0, errViewEncoding
+}
+
+// bytes reads a raw string or binary without copying it, nil for nil
+func (r *viewReader) bytes() ([]byte, error) {
+	b, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	switch {
+	case b&0xe0 == 0xa0:
+		n = int(b & 0x1f)
+	case b == 0xd9 || b == 0xc4:
+		n, err = r.length(1)
+	case b == 0xda || b == 0xc5:
+		n, err = r.length(2)
+	case b == 0xdb || b == 0xc6:
+		n, err = r.length(4)
+	case b == 0xc0:
+		return nil, nil
+	default:
+		return nil, errViewEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.take(n)
+}
+
+// int reads a signed or unsigned integer
+func (r *viewReader) int() (int64, error) {
+	b, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b >= 0xcc && b <= 0xcf:
+		u, err := r.uint(1 << (b - 0xcc))
+		return int64(u), err
+	case b >= 0xd0 && b <= 0xd3:
+		n := 1 << (b - 0xd0)
+		u, err := r.uint(n)
+		switch n {
+		case 1:
+			return int64(int8(u)), err
+		case 2:
+			return int64(int16(u)), err
+		case 4:
+			return int64(int32(u)), err
+		}
+		return int64(u), err
+	case b == 0xc0:
+		return 0, nil
+	}
+	return 0, errViewEncoding
+}
+
+// bool reads a boolean
+func (r *viewReader) bool() (bool, error) {
+	b, err := r.next()
+	if err != nil {
+		return false, err
+	}
+
+	switch b {
+	case 0xc2, 0xc0:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	}
+	return false, errViewEncoding
+}
+
+// skip skips the next value
+func (r *viewReader) skip() error {
+	if r.pos >= len(r.data) {
+		return errViewEncoding
+	}
+	b := r.data[r.pos]
+
+	switch {
+	case b&0xf0 == 0x80 || b == 0xde || b == 0xdf:
+		n, err := r.mapLen()
+		for i := 0; i < 2*n && err == nil; i++ {
+			err = r.skip()
+		}
+		return err
+	case b&0xf0 == 0x90 || b == 0xdc || b == 0xdd:
+		n, err := r.arrayLen()
+		for i := 0; i < n && err == nil; i++ {
+			err = r.skip()
+		}
+		return err
+	case b&0xe0 == 0xa0 || (b >= 0xc4 && b <= 0xc6) || (b >= 0xd9 && b <= 0xdb):
+		_, err := r.bytes()
+		return err
+	case b == 0xc2 || b == 0xc3:
+		_, err := r.bool()
+		return err
+	case b == 0xca:
+		_, err := r.take(5)
+		return err
+	case b == 0xcb:
+		_, err := r.take(9)
+		return err
+	case b >= 0xd4 && b <= 0xd8:
+		_, err := r.take(2 + 1<<(b-0xd4))
+		return err
+	case b >= 0xc7 && b <= 0xc9:
+		r.pos++
+		n, err := r.length(1 << (b - 0xc7))
+		if err != nil {
+			return err
+		}
+		_, err = r.take(n + 1)
+		return err
+	}
+
+	_, err := r.int()
+	return err
+}
+
+// keys reads the keys of a node, nil keys are dropped
+func (r *viewReader) keys() ([]*Key, error) {
+	count, err := r.arrayLen()
+	if err != nil {
+		return nil, err
+	}
+
+	if count < 0 {
+		return nil, nil
+	}
+
+	keys := make([]*Key, 0, count)
+	for i := 0; i < count; i++ {
+		if r.pos < len(r.data) && r.data[r.pos] == 0xc0 {
+			r.pos++
+			continue
+		}
+
+		k, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// key reads a key, metadata is decoded and copied as it holds no values
+func (r *viewReader) key() (*Key, error) {
+	fields, err := r.mapLen()
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Key{}
+	for f := 0; f < fields; f++ {
+		name, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		var count int
+		switch string(name) {
+		case "K":
+			k.K, err = r.bytes()
+		case "V":
+			count, err = r.arrayLen()
+			if count >= 0 {
+				k.V = make([][]byte, 0, count)
+			}
+			for i := 0; i < count && err == nil; i++ {
+				var v []byte
+				v, err = r.bytes()
+				k.V = append(k.V, v)
+			}
+		case "Ptr":
+			count, err = r.arrayLen()
+			if count >= 0 {
+				k.Ptr = make([]bool, 0, count)
+			}
+			for i := 0; i < count && err == nil; i++ {
+				var ptr bool
+				ptr, err = r.bool()
+				k.Ptr = append(k.Ptr, ptr)
+			}
+		case "E":
+			k.E, err = r.int()
+		case "M":
+			start := r.pos
+			err = r.skip()
+			if err == nil {
+				err = codec.NewDecoderBytes(r.data[start:r.pos], msgpackHandle).Decode(&k.M)
+			}
+		case "Ver":
+			count, err = r.arrayLen()
+			if count >= 0 {
+				k.Ver = make([]uint64, 0, count)
+			}
+			for i := 0; i < count && err == nil; i++ {
+				var ver int64
+				ver, err = r.int()
+				k.Ver = append(k.Ver, uint64(ver))
+			}
+		case "D":
+			k.D, err = r.bool()
+		case "H":
+			k.H, err = r.int()
+		case "R":
+			var version int64
+			version, err = r.int()
+			k.R = uint64(version)
+		case "B":
+			k.B, err = r.int()
+		case "F":
+			var flags int64
+			flags, err = r.int()
+			k.F = uint64(flags)
+		case "W":
+			var version int64
+			version, err = r.int()
+			k.W = uint64(version)
+		default:
+			err = r.skip()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
@@ -0,0 +1,302 @@
+// Package btree
+// zero-copy read tests
+// BSD 3-Clause License
+//
+// Copyright (c) 2024, Alex Gaetano Padula
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//  1. Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//  2. Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//  3. Neither the name of the copyright holder nor the names of its
+//     contributors may be used to endorse or promote products derived from
+//     this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pinAll pins every page of the pager of b
+func pinAll(t testing.TB, b *BTree) *Pager {
+	pager := b.Pager.(*Pager)
+	for pageID := int64(0); pageID < pager.PageCount(); pageID++ {
+		err := pager.Pin(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pager
+}
+
+func TestViewNode(t *testing.T) {
+	n := &Node{
+		Page:     7,
+		Leaf:     false,
+		Children: []int64{1, 300, 70000, 5000000000},
+		Keys: []*Key{
+			{K: []byte("a"), V: [][]byte{[]byte("1"), bytes.Repeat([]byte("x"), 70000)}},
+			{K: bytes.Repeat([]byte("b"), 300), V: [][]byte{}, E: -5, D: true, H: 12},
+			{K: []byte("c"), V: [][]byte{[]byte("v"), []byte("w")}, Ptr: []bool{true, false}, M: []*ValueMeta{nil, {Created: 42, Flags: 3}}, Ver: []uint64{1, 1 << 63}},
+		},
+	}
+
+	data, err := encodeNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viewed, err := viewNode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeNode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(viewed, decoded) {
+		t.Fatalf("expected %+v, got %+v", decoded, viewed)
+	}
+
+	// the values alias the encoded node
+	if &viewed.Keys[0].V[0][0] != &data[bytes.Index(data, []byte("1"))] {
+		t.Fatal("expected the value to alias the node")
+	}
+
+	_, err = viewNode(data[:len(data)/2])
+	if err == nil {
+		t.Fatal("expected an error for a truncated node")
+	}
+}
+
+func TestBTree_GetZeroCopy(t *testing.T) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+	defer os.Remove("btree.db.vlog")
+	defer os.Remove("btree.db.ttl")
+	defer os.Remove("btree.db.ttl.del")
+
+	b, err := OpenWithOptions("btree.db", os.O_CREATE|os.O_RDWR, 0644, 3, &Options{ValueLogThreshold: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 100; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.PutWithMeta([]byte("050"), bytes.Repeat([]byte("v"), 100), ValueMeta{Flags: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.PutWithTTL([]byte("060"), []byte("expired"), -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pages which are not pinned are copied
+	view, err := b.GetZeroCopy([]byte("010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !view.Copied || string(view.V[0]) != "value10" {
+		t.Fatalf("expected a copy of value10, got %+v", view)
+	}
+	view.Release()
+
+	pager := pinAll(t, b)
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%03d", i))
+
+		expected, err := b.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		view, err := b.GetZeroCopy(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 60 {
+			if view != nil || expected != nil {
+				t.Fatal("expected the expired key to be hidden")
+			}
+			continue
+		}
+
+		if view.Copied {
+			t.Fatalf("expected %s to alias a pinned page", key)
+		}
+
+		if !reflect.DeepEqual(view.Key, expected) {
+			t.Fatalf("expected %+v, got %+v", expected, view.Key)
+		}
+
+		view.Release()
+		view.Release()
+	}
+
+	view, err = b.GetZeroCopy([]byte("missing"))
+	if err != nil || view != nil {
+		t.Fatalf("expected no key, got %v %v", view, err)
+	}
+
+	// a view holds its page pinned until it is released
+	pinned := pager.Pinned()
+
+	view, err = b.GetZeroCopy([]byte("020"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pageID := range pinned {
+		err = pager.Unpin(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(pager.Pinned()) != 1 {
+		t.Fatalf("expected the viewed page to stay pinned, got %v", pager.Pinned())
+	}
+
+	// writes are not seen by the view
+	err = b.Put([]byte("020"), []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(view.V) != 1 || string(view.V[0]) != "value20" {
+		t.Fatalf("expected the view to keep its value, got %q", view.V)
+	}
+
+	view.Release()
+
+	if len(pager.Pinned()) != 0 {
+		t.Fatalf("expected no pinned pages, got %v", pager.Pinned())
+	}
+}
+
+func TestBTree_GetZeroCopy_ObjectPager(t *testing.T) {
+	pager, err := OpenObjectPager(&mapObjectStore{objects: make(map[string][]byte)}, "btree/", 1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenWithStorage(pager, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 50; i++ {
+		err = b.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("value%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	view, err := b.GetZeroCopy([]byte("025"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if view.Copied || string(view.V[0]) != "value25" {
+		t.Fatalf("expected value25 aliasing the cache, got %+v", view)
+	}
+
+	if len(pager.pinned) != 1 {
+		t.Fatalf("expected the viewed page to be held, got %v", pager.pinned)
+	}
+
+	view.Release()
+
+	if len(pager.pinned) != 0 {
+		t.Fatalf("expected the page to be released, got %v", pager.pinned)
+	}
+}
+
+func BenchmarkBTree_Get_Pinned(b *testing.B) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	tree := benchmarkZeroCopyTree(b)
+	defer tree.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := tree.Get([]byte(fmt.Sprintf("%04d", i%1000)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBTree_GetZeroCopy_Pinned(b *testing.B) {
+	defer os.Remove("btree.db")
+	defer os.Remove("btree.db.del")
+
+	tree := benchmarkZeroCopyTree(b)
+	defer tree.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		view, err := tree.GetZeroCopy([]byte(fmt.Sprintf("%04d", i%1000)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		view.Release()
+	}
+}
+
+// benchmarkZeroCopyTree returns a btree of 1000 keys with every page pinned
+func benchmarkZeroCopyTree(b *testing.B) *BTree {
+	tree, err := Open("btree.db", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644, 32)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		err = tree.Put([]byte(fmt.Sprintf("%04d", i)), bytes.Repeat([]byte("v"), 64))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	pinAll(b, tree)
+
+	return tree
+}